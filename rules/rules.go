@@ -0,0 +1,10 @@
+// Package rules embeds the example rules files, e.g. for the presets of
+// "dgbridge init".
+package rules
+
+import "embed"
+
+// Files are the example rules files, named <preset>.rules.json.
+//
+//go:embed *.rules.json
+var Files embed.FS
@@ -0,0 +1,222 @@
+package main
+
+// This file implements relaying the console of a CubeCoders AMP instance, see
+// --amp_url. AMP's API has no push mechanism, so the console is polled.
+
+import (
+	"bytes"
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ampStates maps AMP's application states to process states. Other states,
+// e.g. stopped or sleeping, keep the current state.
+var ampStates = map[int]string{
+	5:  lib.ProcessStateStarting, // PreStart
+	7:  lib.ProcessStateStarting, // Configuring
+	10: lib.ProcessStateStarting, // Starting
+	20: lib.ProcessStateReady,    // Ready
+	30: lib.ProcessStateStopping, // Restarting
+	40: lib.ProcessStateStopping, // Stopping
+}
+
+// ampResponse is the part of the response of AMP API methods that reports
+// errors, e.g. an expired session.
+type ampResponse struct {
+	Title   string `json:"Title"`
+	Message string `json:"Message"`
+}
+
+func (self ampResponse) err() error {
+	if self.Title == "" {
+		return nil
+	}
+	return fmt.Errorf("%v: %v", self.Title, self.Message)
+}
+
+// ampLogin is the response of Core/Login.
+type ampLogin struct {
+	ampResponse
+	Success      bool   `json:"success"`
+	SessionId    string `json:"sessionID"`
+	ResultReason string `json:"resultReason"`
+}
+
+// ampUpdates is the response of Core/GetUpdates.
+type ampUpdates struct {
+	ampResponse
+	Status struct {
+		State int `json:"State"`
+	} `json:"Status"`
+	ConsoleEntries []struct {
+		Contents string `json:"Contents"`
+	} `json:"ConsoleEntries"`
+}
+
+// AmpConsole is the Console of a CubeCoders AMP instance. When its session is
+// lost, it logs in again. Stopping it only disconnects; the instance keeps
+// running.
+type AmpConsole struct {
+	remoteConsole
+	url      string // URL of the instance, e.g. http://localhost:8081
+	username string
+	password string
+	client   *http.Client
+
+	mutex     sync.Mutex // Guards sessionId
+	sessionId string     // Empty while logged out
+}
+
+// NewAmpConsole creates the console of an AMP instance. It is not connected
+// until started.
+//
+// Parameters:
+//
+//	url: URL of the instance's web interface
+//	username: user with permission to read and write the console
+//	password: password of the user
+func NewAmpConsole(url string, username string, password string) *AmpConsole {
+	return &AmpConsole{
+		remoteConsole: newRemoteConsole(),
+		url:           strings.TrimSuffix(url, "/"),
+		username:      username,
+		password:      password,
+		client:        newRemoteConsoleClient(false),
+	}
+}
+
+// newAmpConsole creates the console configured with command line arguments,
+// and relays its output to the terminal.
+func newAmpConsole(args CliArgs, rules *lib.Rules) Console {
+	if args.AmpUser == "" || args.AmpPassword == "" {
+		log.Fatalln("[fatal] --amp_url requires --amp_user and --amp_password")
+	}
+	console := NewAmpConsole(args.AmpUrl, args.AmpUser, args.AmpPassword)
+	console.States = lib.NewProcessStateTracker(rules.States)
+	go superviseJob("relay of the console", func() { relayConsoleOutput(console) })
+	return console
+}
+
+// Start connects to the console in the background.
+func (self *AmpConsole) Start() error {
+	go superviseJob("AMP console", self.run)
+	return nil
+}
+
+// Stop disconnects from the console.
+func (self *AmpConsole) Stop() {
+	self.stop(func() {
+		self.setSession("")
+	})
+}
+
+// WriteLine sends a command to the instance. Commands are dropped while the
+// console is disconnected.
+func (self *AmpConsole) WriteLine(text string) {
+	sessionId := self.session()
+	if sessionId == "" {
+		logDroppedCommand("AMP", text)
+		return
+	}
+	var response ampResponse
+	err := self.call("Core/SendConsoleMessage", map[string]string{"message": text, "SESSIONID": sessionId}, &response)
+	if err == nil {
+		err = response.err()
+	}
+	if err != nil {
+		log.Printf("[error] error writing to the AMP console: %v", err)
+		reportError(lib.MsgErrorKindServer, err)
+	}
+}
+
+// run logs in and polls the console, and logs in again whenever polling fails,
+// until the console is stopped.
+func (self *AmpConsole) run() {
+	for !self.isStopped() {
+		err := self.poll()
+		self.setSession("")
+		if self.isStopped() {
+			return
+		}
+		log.Printf("[error] AMP console of %v disconnected: %v", self.url, err)
+		if !self.sleep() {
+			return
+		}
+	}
+}
+
+// poll logs in and polls the console until polling fails.
+func (self *AmpConsole) poll() error {
+	var login ampLogin
+	credentials := map[string]any{"username": self.username, "password": self.password, "token": "", "rememberMe": false}
+	if err := self.call("Core/Login", credentials, &login); err != nil {
+		return fmt.Errorf("error logging in: %v", err)
+	}
+	if err := login.err(); err != nil {
+		return fmt.Errorf("error logging in: %v", err)
+	}
+	if !login.Success {
+		return fmt.Errorf("error logging in: %v", login.ResultReason)
+	}
+	self.setSession(login.SessionId)
+	log.Printf("[info] Connected to the AMP console of %v\n", self.url)
+
+	// The first updates of a session are the console's history, skip them.
+	first := true
+	for self.wait(remoteConsolePollInterval) {
+		var updates ampUpdates
+		if err := self.call("Core/GetUpdates", map[string]string{"SESSIONID": login.SessionId}, &updates); err != nil {
+			return err
+		}
+		if err := updates.err(); err != nil {
+			return err
+		}
+		if state, ok := ampStates[updates.Status.State]; ok && self.States != nil {
+			self.States.Set(state)
+		}
+		if !first {
+			for _, entry := range updates.ConsoleEntries {
+				self.emitLine(entry.Contents, false)
+			}
+		}
+		first = false
+	}
+	return nil
+}
+
+// call calls an AMP API method.
+//
+// Parameters:
+//
+//	method: name of the method, e.g. Core/GetUpdates
+//	parameters: parameters of the method, encoded as JSON
+//	result: decoded response
+func (self *AmpConsole) call(method string, parameters any, result any) error {
+	body, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, self.url+"/API/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return doJSON(self.client, request, result)
+}
+
+func (self *AmpConsole) session() string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.sessionId
+}
+
+func (self *AmpConsole) setSession(sessionId string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.sessionId = sessionId
+}
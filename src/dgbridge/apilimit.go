@@ -0,0 +1,101 @@
+package main
+
+// This file implements the limiter shared by all Discord API requests of the
+// bridge: message sends, member and role lookups, direct messages, threads,
+// scheduled events and slash commands.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// APILimiter is an http.RoundTripper that limits the rate and the concurrency
+// of the requests made through it. discordgo only enforces the rate limits
+// Discord reports per route; the APILimiter keeps all features together below
+// the global limit, since exceeding it repeatedly gets the bot's IP address
+// banned for a while.
+// It is shared by all Discord sessions of the bridge, and safe for concurrent
+// use.
+type APILimiter struct {
+	transport http.RoundTripper
+	limiter   *lib.RateLimiter
+	slots     chan struct{} // Holds a value per request in flight
+
+	requests    atomic.Int64 // Requests made
+	rateLimited atomic.Int64 // Responses with status 429
+	waiting     atomic.Int64 // Requests waiting for the limiter now
+	waited      atomic.Int64 // Total time requests waited, in nanoseconds
+}
+
+// APILimiterStats is a snapshot of the metrics of an APILimiter.
+type APILimiterStats struct {
+	Requests    int64
+	RateLimited int64 // Responses with status 429, see the X-RateLimit headers
+	Waiting     int64
+	Waited      time.Duration
+}
+
+// NewAPILimiter creates an APILimiter.
+//
+// Parameters:
+//
+//	transport: makes the requests, http.DefaultTransport if nil
+//	rate: requests per second
+//	concurrency: maximum number of requests in flight
+func NewAPILimiter(transport http.RoundTripper, rate float64, concurrency int) *APILimiter {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &APILimiter{
+		transport: transport,
+		limiter:   lib.NewRateLimiter(lib.RateLimit{Rate: rate, Burst: concurrency}),
+		slots:     make(chan struct{}, concurrency),
+	}
+}
+
+// RoundTrip waits until the request may be made, then makes it.
+func (self *APILimiter) RoundTrip(request *http.Request) (*http.Response, error) {
+	start := time.Now()
+	self.waiting.Add(1)
+	select {
+	case self.slots <- struct{}{}:
+	case <-request.Context().Done():
+		self.waiting.Add(-1)
+		return nil, request.Context().Err()
+	}
+	self.limiter.Wait()
+	self.waiting.Add(-1)
+	self.waited.Add(int64(time.Since(start)))
+	defer func() {
+		<-self.slots
+	}()
+
+	self.requests.Add(1)
+	response, err := self.transport.RoundTrip(request)
+	if err == nil && response.StatusCode == http.StatusTooManyRequests {
+		self.rateLimited.Add(1)
+	}
+	return response, err
+}
+
+// Stats returns the current metrics.
+func (self *APILimiter) Stats() APILimiterStats {
+	return APILimiterStats{
+		Requests:    self.requests.Load(),
+		RateLimited: self.rateLimited.Load(),
+		Waiting:     self.waiting.Load(),
+		Waited:      time.Duration(self.waited.Load()),
+	}
+}
+
+// String formats the metrics for /bridge stats.
+func (self APILimiterStats) String() string {
+	return fmt.Sprintf("%v Discord API requests (%v rate limited, %v waiting, %v waited in total)",
+		self.Requests, self.RateLimited, self.Waiting, self.Waited.Truncate(time.Millisecond))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTransport answers requests with a status, and records how many were in
+// flight at once.
+type fakeTransport struct {
+	status   int
+	release  chan struct{} // Requests wait for it if not nil
+	inFlight atomic.Int64
+	maximum  atomic.Int64
+}
+
+func (self *fakeTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	inFlight := self.inFlight.Add(1)
+	defer self.inFlight.Add(-1)
+	for {
+		maximum := self.maximum.Load()
+		if inFlight <= maximum || self.maximum.CompareAndSwap(maximum, inFlight) {
+			break
+		}
+	}
+	if self.release != nil {
+		<-self.release
+	}
+	return &http.Response{StatusCode: self.status, Request: request}, nil
+}
+
+func TestAPILimiterStats(t *testing.T) {
+	transport := &fakeTransport{status: http.StatusTooManyRequests}
+	limiter := NewAPILimiter(transport, 1000, 2)
+	for i := 0; i < 3; i++ {
+		request, _ := http.NewRequest(http.MethodGet, "https://discord.com/api", nil)
+		response, err := limiter.RoundTrip(request)
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Equal(t, http.StatusTooManyRequests, response.StatusCode, "Test #%v", i)
+	}
+	stats := limiter.Stats()
+	assert.Equal(t, int64(3), stats.Requests)
+	assert.Equal(t, int64(3), stats.RateLimited)
+	assert.Equal(t, int64(0), stats.Waiting)
+}
+
+func TestAPILimiterConcurrency(t *testing.T) {
+	transport := &fakeTransport{status: http.StatusOK, release: make(chan struct{})}
+	limiter := NewAPILimiter(transport, 1000, 2)
+	var wait sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			request, _ := http.NewRequest(http.MethodGet, "https://discord.com/api", nil)
+			_, _ = limiter.RoundTrip(request)
+		}()
+	}
+	close(transport.release)
+	wait.Wait()
+	assert.LessOrEqual(t, transport.maximum.Load(), int64(2))
+	assert.Equal(t, int64(5), limiter.Stats().Requests)
+}
+
+func TestAPILimiterCancel(t *testing.T) {
+	transport := &fakeTransport{status: http.StatusOK, release: make(chan struct{})}
+	limiter := NewAPILimiter(transport, 1000, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request, _ := http.NewRequest(http.MethodGet, "https://discord.com/api", nil)
+		_, _ = limiter.RoundTrip(request)
+	}()
+	// Wait for the first request to hold the only slot.
+	for transport.inFlight.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A request waiting for a slot returns once it's canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://discord.com/api", nil)
+	_, err := limiter.RoundTrip(request)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(0), limiter.Stats().Waiting)
+
+	close(transport.release)
+	<-done
+	assert.Equal(t, int64(1), limiter.Stats().Requests)
+}
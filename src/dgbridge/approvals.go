@@ -0,0 +1,176 @@
+package main
+
+// This file implements the approval of commands sent from Discord, see
+// Rule.RequireApproval: the bot posts the command to the relay channel with
+// buttons to approve or deny it, and only writes it to the subprocess once a
+// member that Rules.Approvals allows approved it. Requests, decisions and
+// timeouts are logged, so the log is an audit trail of dangerous commands.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Custom ID prefixes of the approval buttons, followed by the approval's ID.
+const (
+	approveButtonPrefix = "approve:"
+	denyButtonPrefix    = "deny:"
+)
+
+// approvalExpiryInterval is the time between checks for commands whose
+// approval timed out.
+const approvalExpiryInterval = 10 * time.Second
+
+// Colors of the approval embed.
+const (
+	approvalColorPending  = 0xf1c40f
+	approvalColorApproved = 0x2ecc71
+	approvalColorDenied   = 0xe74c3c
+)
+
+// requestApproval holds the command of an event until it is approved, and
+// posts the request for approval to the relay channel.
+func (self *BotContext) requestApproval(session *discordgo.Session, event *lib.Event) {
+	now := time.Now()
+	approval := self.approvals.Add(event, now)
+	message, err := session.ChannelMessageSendComplex(self.relayChannelId, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{approvalEmbed(approval, "Waiting for approval", approvalColorPending)},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Approve",
+					Style:    discordgo.SuccessButton,
+					Emoji:    &discordgo.ComponentEmoji{Name: "✅"},
+					CustomID: approveButtonPrefix + approval.Id,
+				},
+				discordgo.Button{
+					Label:    "Deny",
+					Style:    discordgo.DangerButton,
+					Emoji:    &discordgo.ComponentEmoji{Name: "❌"},
+					CustomID: denyButtonPrefix + approval.Id,
+				},
+			}},
+		},
+	})
+	if err != nil {
+		self.approvals.Take(approval.Id, now)
+		log.Printf("[error] error requesting approval of command %q of rule %v, dropping it: %v", event.Transformed, event.RuleId(), err)
+		reportRuleError(lib.MsgErrorKindDiscord, event.RuleId(), err)
+		return
+	}
+	self.approvals.SetMessage(approval.Id, message.ID)
+	log.Printf("[info] Command %q of %v (rule %v) waits for approval until %v\n",
+		event.Transformed, approvalAuthor(event), event.RuleId(), approval.Expires.Format(time.TimeOnly))
+}
+
+// approvalButton handles a click on an approval button: an approved command
+// continues to the subprocess, a denied one is dropped.
+func (self *BotContext) approvalButton(s *discordgo.Session, i *discordgo.InteractionCreate, customId string) {
+	if i.Member == nil {
+		return
+	}
+	id, approve := strings.CutPrefix(customId, approveButtonPrefix)
+	if !approve {
+		id = strings.TrimPrefix(customId, denyButtonPrefix)
+	}
+	now := time.Now()
+	approval, ok := self.approvals.Get(id, now)
+	if !ok {
+		self.respond(s, i, "This command expired or was decided already.")
+		return
+	}
+	member := interactionAuthor(s, i)
+	admin := i.Member.Permissions&adminPermissions != 0
+	allowed := self.rules.Load().Approvals.MayApprove(member, admin, approval)
+	if !approve {
+		// Authors may withdraw their own commands.
+		allowed = allowed || member.Id == approval.Event.Props.Author.Id
+	}
+	if !allowed {
+		self.respond(s, i, "You may not decide on this command.")
+		return
+	}
+	if approval, ok = self.approvals.Take(id, now); !ok {
+		self.respond(s, i, "This command expired or was decided already.")
+		return
+	}
+	event := approval.Event
+	status, color := fmt.Sprintf("Denied by %v", i.Member.Mention()), approvalColorDenied
+	if approve {
+		status, color = fmt.Sprintf("Approved by %v", i.Member.Mention()), approvalColorApproved
+		log.Printf("[info] %v approved command %q of %v (rule %v)\n", i.Member.User.Username, event.Transformed, approvalAuthor(event), event.RuleId())
+		self.approved.Run(event)
+	} else {
+		log.Printf("[info] %v denied command %q of %v (rule %v)\n", i.Member.User.Username, event.Transformed, approvalAuthor(event), event.RuleId())
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{approvalEmbed(approval, status, color)},
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		log.Printf("[error] error updating approval request: %v", err)
+	}
+}
+
+// startApprovalExpiryJob drops the commands whose approval timed out, and
+// marks their requests as expired.
+func (self *BotContext) startApprovalExpiryJob(session *discordgo.Session) {
+	ticker := time.NewTicker(approvalExpiryInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, approval := range self.approvals.Expire(now) {
+			event := approval.Event
+			log.Printf("[info] Command %q of %v (rule %v) expired without approval\n", event.Transformed, approvalAuthor(event), event.RuleId())
+			if approval.MessageId == "" {
+				continue
+			}
+			embeds := []*discordgo.MessageEmbed{approvalEmbed(approval, "Expired without approval", approvalColorDenied)}
+			_, err := session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+				ID:         approval.MessageId,
+				Channel:    self.relayChannelId,
+				Embeds:     &embeds,
+				Components: &[]discordgo.MessageComponent{},
+			})
+			if err != nil {
+				log.Printf("[warning] error marking approval request as expired: %v", err)
+			}
+		}
+	}
+}
+
+// approvalEmbed returns the embed of a request for approval.
+//
+// Parameters:
+//
+//	status: the state of the request, e.g. who approved it
+func approvalEmbed(approval lib.PendingApproval, status string, color int) *discordgo.MessageEmbed {
+	event := approval.Event
+	return &discordgo.MessageEmbed{
+		Title:       "Command requires approval",
+		Description: lib.FormatCodeBlock([]string{event.Transformed}, 4096),
+		Color:       color,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Sent by", Value: approvalAuthor(event), Inline: true},
+			{Name: "Rule", Value: event.RuleId(), Inline: true},
+			{Name: "Status", Value: status},
+		},
+		Timestamp: event.Received.Format(time.RFC3339),
+	}
+}
+
+// approvalAuthor returns the name of the author of a command, for the log and
+// the approval embed.
+func approvalAuthor(event *lib.Event) string {
+	if event.Props.Author.Nickname != "" {
+		return event.Props.Author.Nickname
+	}
+	return event.Props.Author.Username
+}
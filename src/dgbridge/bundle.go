@@ -0,0 +1,152 @@
+package main
+
+// This file implements the "dgbridge build-rules-bundle" subcommand, which
+// packages a rules file and a users file into a signed bundle, and loading
+// bundles with --bundle.
+
+import (
+	"bytes"
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+type BundleArgs struct {
+	RulesFile    string `arg:"-r,--rules" help:"Path to the file with translation rules"`
+	UsersFile    string `arg:"-u,--users" help:"Path to the users file, bundled as is, so an encrypted file stays encrypted"`
+	UsersKeyFile string `arg:"--users_key_file" help:"File with the key of an encrypted users file, for validating it, defaults to the DGBRIDGE_USERS_KEY environment variable"`
+	KeyFile      string `arg:"required,--key_file" help:"File with the base64-encoded private key the bundle is signed with"`
+	Output       string `arg:"-o,--output" help:"Bundle file to write"`
+	Keygen       bool   `arg:"--keygen" help:"Generate a key pair instead: write the private key to --key_file, and print the public key for --bundle_key"`
+}
+
+// runBundleCommand runs "dgbridge build-rules-bundle". The files are validated
+// like with "dgbridge check" first, so only valid bundles are signed.
+//
+// Parameters:
+//
+//	argv: command line arguments following "build-rules-bundle"
+func runBundleCommand(argv []string) {
+	var args BundleArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge build-rules-bundle",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+
+	if args.Keygen {
+		if err := generateBundleKey(args.KeyFile); err != nil {
+			log.Fatalln("[fatal]", err)
+		}
+		return
+	}
+	if args.RulesFile == "" || args.Output == "" {
+		parser.Fail("--rules and --output are required")
+	}
+
+	var report checkReport
+	checkRules(&report, args.RulesFile)
+	if args.UsersFile != "" {
+		checkUsers(&report, args.UsersFile, args.UsersKeyFile)
+	}
+	if report.failed {
+		os.Exit(1)
+	}
+	if err := buildBundle(args); err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	fmt.Printf("Wrote %v\n", args.Output)
+}
+
+// generateBundleKey writes a new private key to a file, and prints the public
+// key.
+func generateBundleKey(keyFile string) error {
+	public, private, err := lib.GenerateBundleKey()
+	if err != nil {
+		return err
+	}
+	if err := writeNewFile(keyFile, private+"\n", 0o600); err != nil {
+		return fmt.Errorf("error writing key file: %v", err)
+	}
+	fmt.Printf("Wrote the private key to %v. Public key, for --bundle_key:\n%v\n", keyFile, public)
+	return nil
+}
+
+// buildBundle writes the bundle of the files.
+func buildBundle(args BundleArgs) error {
+	encodedKey, err := os.ReadFile(args.KeyFile)
+	if err != nil {
+		return fmt.Errorf("error reading key file: %v", err)
+	}
+	key, err := lib.ParseBundlePrivateKey(string(encodedKey))
+	if err != nil {
+		return err
+	}
+	bundle := lib.Bundle{Created: time.Now(), Files: make(map[string][]byte)}
+	paths := map[string]string{lib.BundleRules: args.RulesFile, lib.BundleUsers: args.UsersFile}
+	for name, path := range paths {
+		if path == "" {
+			continue
+		}
+		if bundle.Files[name], err = os.ReadFile(path); err != nil {
+			return err
+		}
+	}
+	var archive bytes.Buffer
+	if err := lib.WriteBundle(&archive, bundle, key); err != nil {
+		return err
+	}
+	return os.WriteFile(args.Output, archive.Bytes(), 0o644)
+}
+
+// loadBundle reads and verifies the bundle given with --bundle.
+//
+// Returns:
+//
+//	the bundle, or nil if no --bundle is given
+func loadBundle(args CliArgs) *lib.Bundle {
+	if args.Bundle == "" {
+		return nil
+	}
+	bundle, err := readBundle(args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	log.Printf("[info] Loaded bundle %v, built %v\n", args.Bundle, bundle.Created.Local().Format(time.DateTime))
+	return bundle
+}
+
+// readBundle reads and verifies the --bundle file, on startup and when the
+// configuration is reloaded.
+func readBundle(args CliArgs) (*lib.Bundle, error) {
+	key, err := lib.ParseBundlePublicKey(args.BundleKey)
+	if err != nil {
+		return nil, fmt.Errorf("--bundle_key: %v", err)
+	}
+	file, err := os.Open(args.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bundle: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	bundle, err := lib.ReadBundle(file, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := bundle.Files[lib.BundleRules]; !ok {
+		return nil, fmt.Errorf("invalid bundle: no rules")
+	}
+	if _, ok := bundle.Files[lib.BundleUsers]; ok && args.UsersFile != "" {
+		return nil, fmt.Errorf("the bundle includes a users file, --users can't be used with it")
+	}
+	return bundle, nil
+}
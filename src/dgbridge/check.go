@@ -0,0 +1,172 @@
+package main
+
+// This file implements the "dgbridge check" subcommand, which validates a
+// deployment without starting the subprocess, e.g. in a deployment pipeline.
+
+import (
+	"dgbridge/src/lib"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/bwmarrin/discordgo"
+)
+
+type CheckArgs struct {
+	RulesFile    string `arg:"required,-r,--rules" help:"Path to the file with translation rules"`
+	UsersFile    string `arg:"-u,--users" help:"Path to the users file, may be encrypted"`
+	UsersKeyFile string `arg:"--users_key_file" help:"File with the key of an encrypted users file, defaults to the DGBRIDGE_USERS_KEY environment variable"`
+	Token        string `arg:"-t,--token,env:DISCORD_TOKEN" help:"Discord authentication token; if set, the token and channel are checked with the Discord API"`
+	ChannelId    string `arg:"-i,--channel_id" help:"Discord channel ID to resolve"`
+}
+
+// checkReport collects the results of "dgbridge check".
+type checkReport struct {
+	failed bool
+}
+
+func (self *checkReport) ok(subject string, format string, vargs ...any) {
+	fmt.Printf("[ok] %v: %v\n", subject, fmt.Sprintf(format, vargs...))
+}
+
+func (self *checkReport) fail(subject string, err error) {
+	self.failed = true
+	fmt.Printf("[error] %v: %v\n", subject, err)
+}
+
+// runCheckCommand runs "dgbridge check", exiting with status 1 if any check
+// fails.
+//
+// Parameters:
+//
+//	argv: command line arguments following "check"
+func runCheckCommand(argv []string) {
+	var args CheckArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge check",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+
+	var report checkReport
+	checkRules(&report, args.RulesFile)
+	if args.UsersFile != "" {
+		checkUsers(&report, args.UsersFile, args.UsersKeyFile)
+	}
+	if args.Token != "" {
+		checkDiscord(&report, args.Token, args.ChannelId)
+	} else {
+		fmt.Println("[skipped] discord: no token given")
+	}
+	if report.failed {
+		os.Exit(1)
+	}
+}
+
+// validateRules checks parsed rules before they are used: at startup, on
+// reload and by dgbridge check.
+func validateRules(rules *lib.Rules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	if errs := rules.CheckRoutes(); len(errs) > 0 {
+		return fmt.Errorf("invalid Routes: %w", errors.Join(errs...))
+	}
+	if errs := rules.CheckForms(); len(errs) > 0 {
+		return fmt.Errorf("invalid Forms: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// checkRules loads, compiles and validates a rules file.
+func checkRules(report *checkReport, path string) {
+	rules, err := lib.LoadRules(path)
+	if err != nil {
+		report.fail("rules", err)
+		return
+	}
+	if contents, err := os.ReadFile(path); err == nil {
+		if _, version, err := lib.MigrateRules(contents); err == nil && version < lib.CurrentRulesVersion {
+			fmt.Printf("[note] rules: file has version %v, run 'dgbridge migrate %v' to upgrade it to version %v\n",
+				version, path, lib.CurrentRulesVersion)
+		}
+	}
+	if err := validateRules(rules); err != nil {
+		report.fail("rules", err)
+		return
+	}
+	report.ok("rules", "%v: %v SubprocessToDiscord, %v DiscordToSubprocess rules",
+		path, len(rules.SubprocessToDiscord), len(rules.DiscordToSubprocess))
+	if checked, failures := rules.CheckExamples(); len(failures) > 0 {
+		errs := make([]error, len(failures))
+		for i, failure := range failures {
+			errs[i] = failure
+		}
+		report.fail("examples", errors.Join(errs...))
+	} else if checked > 0 {
+		report.ok("examples", "%v rule examples passed", checked)
+	}
+	for _, sinkConfig := range rules.Sinks {
+		report.ok("sinks", "%v sink declared (not connected)", sinkConfig.Type)
+	}
+	if len(rules.Routes) > 0 {
+		report.ok("routes", "%v routes to %v sinks", len(rules.Routes), len(rules.Sinks))
+	}
+}
+
+// checkUsers loads and validates a users file.
+func checkUsers(report *checkReport, path string, keyFile string) {
+	key, err := lib.LoadUserMapKey(keyFile)
+	if err != nil {
+		report.fail("users", err)
+		return
+	}
+	userMap, err := lib.LoadUserMap(path, key)
+	if err != nil {
+		report.fail("users", err)
+		return
+	}
+	report.ok("users", "%v: %v names", path, len(userMap))
+}
+
+// checkDiscord checks that the token is valid, and that the bot can read and
+// send messages in the relay channel.
+func checkDiscord(report *checkReport, token string, channelId string) {
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		report.fail("discord", err)
+		return
+	}
+	user, err := session.User("@me")
+	if err != nil {
+		report.fail("discord", fmt.Errorf("error authenticating: %v", err))
+		return
+	}
+	report.ok("discord", "authenticated as %v (%v)", user.Username, user.ID)
+	if channelId == "" {
+		fmt.Println("[skipped] channel: no channel ID given")
+		return
+	}
+	channel, err := session.Channel(channelId)
+	if err != nil {
+		report.fail("channel", fmt.Errorf("error fetching channel %v: %v", channelId, err))
+		return
+	}
+	permissions, err := session.UserChannelPermissions(user.ID, channel.ID)
+	if err != nil {
+		report.fail("channel", fmt.Errorf("error fetching permissions in #%v: %v", channel.Name, err))
+		return
+	}
+	const required = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages
+	if permissions&required != required {
+		report.fail("channel", fmt.Errorf("bot can't view or send messages in #%v", channel.Name))
+		return
+	}
+	report.ok("channel", "#%v (%v) in guild %v", channel.Name, channel.ID, channel.GuildID)
+}
@@ -0,0 +1,410 @@
+package main
+
+// This file implements the bot's slash commands. They are registered in the
+// relay channel's guild once the session is ready, and may only be used by
+// administrators of the guild, except for /players and /link.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// consoleTailDefault is the number of lines returned by /console tail if no
+// number is given.
+const consoleTailDefault = 20
+
+// adminPermissions are the permissions required to use the slash commands.
+var adminPermissions int64 = discordgo.PermissionAdministrator
+
+// commands returns the slash commands to register.
+func (self *BotContext) commands() []*discordgo.ApplicationCommand {
+	directionOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "direction",
+		Description: "Direction to pause or resume, defaults to both",
+		Choices: []*discordgo.ApplicationCommandOptionChoice{
+			{Name: "Server to Discord", Value: lib.DirectionSubprocessToDiscord},
+			{Name: "Discord to server", Value: lib.DirectionDiscordToSubprocess},
+		},
+	}
+	commands := []*discordgo.ApplicationCommand{
+		{
+			Name:                     "bridge",
+			Description:              "Control the bridge",
+			DefaultMemberPermissions: &adminPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "pause",
+					Description: "Stop relaying messages until resumed",
+					Options: []*discordgo.ApplicationCommandOption{
+						directionOption,
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "hold",
+							Description: "Relay recent messages once resumed instead of dropping them",
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "resume",
+					Description: "Resume relaying messages",
+					Options:     []*discordgo.ApplicationCommandOption{directionOption},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "maintenance",
+					Description: "Answer messages with a maintenance notice instead of relaying them",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "enabled",
+							Description: "Whether the server is under maintenance",
+							Required:    true,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "until",
+							Description: "When maintenance is expected to end, e.g. 18:00 UTC",
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "stats",
+					Description: "Show how often each rule matched, and when it last matched",
+				},
+			},
+		},
+	}
+	if self.console != nil {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:                     "console",
+			Description:              "Show recent console output",
+			DefaultMemberPermissions: &adminPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "tail",
+					Description: "Show the last lines of console output",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "n",
+							Description: fmt.Sprintf("Number of lines, defaults to %v", consoleTailDefault),
+							MinValue:    &[]float64{1}[0],
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "grep",
+					Description: "Show recent console lines matching a regular expression",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "regex",
+							Description: "Regular expression to search for",
+							Required:    true,
+						},
+					},
+				},
+			},
+		})
+	}
+	commands = append(commands, self.workflowCommands()...)
+	if command := self.filterCommand(); command != nil {
+		commands = append(commands, command)
+	}
+	commands = append(commands, self.muteCommands()...)
+	commands = append(commands, self.formCommands()...)
+	if self.journal != nil {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:                     "export",
+			Description:              "Upload a transcript of recently relayed messages",
+			DefaultMemberPermissions: &adminPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "hours",
+					Description: fmt.Sprintf("Number of hours to export, defaults to %v", exportHoursDefault),
+					MinValue:    &[]float64{1}[0],
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "format",
+					Description: "Format of the transcript, defaults to text",
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "Text", Value: lib.TranscriptFormatText},
+						{Name: "HTML", Value: lib.TranscriptFormatHtml},
+					},
+				},
+			},
+		})
+	}
+	if self.query != nil {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:        "players",
+			Description: "Show the players online on the server",
+		})
+	}
+	if self.links != nil {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:        "link",
+			Description: "Link your in-game name to your Discord account",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "name",
+					Description: "Your in-game name, to start linking it",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "code",
+					Description: "The code sent to you in game, to finish linking",
+				},
+			},
+		})
+	}
+	return commands
+}
+
+// registerCommands registers the slash commands in the relay channel's guild,
+// replacing commands registered by earlier runs.
+func (self *BotContext) registerCommands(s *discordgo.Session) {
+	channel, err := s.Channel(self.relayChannelId)
+	if err != nil {
+		log.Printf("[error] can't register slash commands, error fetching relay channel: %v", err)
+		return
+	}
+	_, err = s.ApplicationCommandBulkOverwrite(s.State.User.ID, channel.GuildID, self.commands())
+	if err != nil {
+		log.Printf("[error] error registering slash commands: %v", err)
+	}
+}
+
+// Handles a discordgo.InteractionCreate event, dispatching slash commands,
+// button clicks and modal submissions.
+func (self *BotContext) interactionCreate() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		defer recoverPanic("interaction handler")
+		if i.Type == discordgo.InteractionMessageComponent {
+			customId := i.MessageComponentData().CustomID
+			switch {
+			case strings.HasPrefix(customId, approveButtonPrefix), strings.HasPrefix(customId, denyButtonPrefix):
+				self.approvalButton(s, i, customId)
+			case strings.HasPrefix(customId, lib.PanelButtonPrefix):
+				self.panelButton(s, i, customId)
+			}
+			return
+		}
+		if i.Type == discordgo.InteractionModalSubmit {
+			if strings.HasPrefix(i.ModalSubmitData().CustomID, lib.FormModalPrefix) {
+				self.formSubmit(s, i)
+			}
+			return
+		}
+		if i.Type != discordgo.InteractionApplicationCommand {
+			return
+		}
+		data := i.ApplicationCommandData()
+		if data.Name == "players" && self.query != nil {
+			self.playersCommand(s, i)
+			return
+		}
+		if data.Name == "link" && self.links != nil {
+			self.linkCommand(s, i, data)
+			return
+		}
+		if form := self.rules.Load().Form(data.Name); form != nil {
+			// Forms check who may use them themselves.
+			self.openForm(s, i, form)
+			return
+		}
+		if i.Member == nil || i.Member.Permissions&adminPermissions == 0 {
+			// The command permissions can be overridden in the guild settings,
+			// so check again.
+			self.respond(s, i, "Only administrators can use this command.")
+			return
+		}
+		switch data.Name {
+		case "bridge":
+			self.bridgeCommand(s, i, data)
+		case "console":
+			self.consoleCommand(s, i, data)
+		case "whitelist", "ban":
+			self.workflowCommand(s, i, data)
+		case "filter":
+			self.filterSubcommand(s, i, data)
+		case "bridgemute", "bridgeunmute":
+			self.muteCommand(s, i, data)
+		case "export":
+			if self.journal != nil {
+				self.exportCommand(s, i, data)
+			}
+		}
+	}
+}
+
+// bridgeCommand handles the /bridge subcommands.
+func (self *BotContext) bridgeCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if len(data.Options) == 0 {
+		return
+	}
+	subcommand := data.Options[0]
+	options := subcommandOptions(subcommand)
+	direction := ""
+	if option, ok := options["direction"]; ok {
+		direction = option.StringValue()
+	}
+	switch subcommand.Name {
+	case "pause":
+		hold := false
+		if option, ok := options["hold"]; ok {
+			hold = option.BoolValue()
+		}
+		self.pause.pause(direction, hold)
+		log.Printf("[info] %v paused relaying (direction: %q, hold: %v)\n", i.Member.User.Username, direction, hold)
+		self.respond(s, i, "Relaying paused.")
+	case "resume":
+		held := self.resumeRelaying(direction)
+		log.Printf("[info] %v resumed relaying (direction: %q)\n", i.Member.User.Username, direction)
+		self.respond(s, i, fmt.Sprintf("Relaying resumed, %v held messages relayed to the server.", held))
+	case "maintenance":
+		if !options["enabled"].BoolValue() {
+			self.endMaintenance(s)
+			log.Printf("[info] %v ended maintenance\n", i.Member.User.Username)
+			self.respond(s, i, "Maintenance ended, relaying resumed.")
+			return
+		}
+		until := ""
+		if option, ok := options["until"]; ok {
+			until = option.StringValue()
+		}
+		message := self.startMaintenance(s, until)
+		log.Printf("[info] %v started maintenance (until: %q)\n", i.Member.User.Username, until)
+		self.respond(s, i, fmt.Sprintf("Maintenance started and announced: %v", message))
+	case "stats":
+		self.respond(s, i, self.statsReport())
+	}
+}
+
+// statsReport returns the response of /bridge stats.
+func (self *BotContext) statsReport() string {
+	header := []string{fmt.Sprintf("%v messages to Discord dropped after failing to send", self.dropped.Load())}
+	if self.name != "" {
+		header = append([]string{"Bridge " + self.name}, header...)
+	}
+	if self.apiLimiter != nil {
+		header = append(header, self.apiLimiter.Stats().String())
+	}
+	if self.shadow != nil {
+		header = append(header, self.shadow.summary())
+	}
+	return formatRuleStats(self.stats.Snapshot(), header, time.Now())
+}
+
+// resumeRelaying resumes relaying in a direction, see relayPause.resume, and
+// writes the held messages to the subprocess.
+//
+// Returns:
+//
+//	the number of held messages
+func (self *BotContext) resumeRelaying(direction string) int {
+	held := self.pause.resume(direction)
+	for _, message := range held {
+		self.subprocess.WriteLine(message)
+	}
+	return len(held)
+}
+
+// formatRuleStats formats rule stats as a code block, one line per rule.
+//
+// Parameters:
+//
+//	header: lines of bridge-wide stats, e.g. the number of dropped messages
+func formatRuleStats(stats []lib.RuleStat, header []string, now time.Time) string {
+	if len(stats) == 0 {
+		return "No rules."
+	}
+	lines := append([]string(nil), header...)
+	for _, stat := range stats {
+		lastMatch := "never"
+		if !stat.LastMatch.IsZero() {
+			lastMatch = fmt.Sprintf("%v ago", now.Sub(stat.LastMatch).Truncate(time.Second))
+		}
+		lines = append(lines, fmt.Sprintf("%v %v: %v matches, last %v", stat.Direction, stat.RuleId, stat.Count, lastMatch))
+	}
+	return lib.FormatCodeBlock(lines, lib.DiscordMessageLimit)
+}
+
+// subcommandOptions returns the options of a subcommand by name.
+func subcommandOptions(subcommand *discordgo.ApplicationCommandInteractionDataOption) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range subcommand.Options {
+		options[option.Name] = option
+	}
+	return options
+}
+
+// consoleCommand handles /console tail and /console grep.
+func (self *BotContext) consoleCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if self.console == nil || len(data.Options) == 0 {
+		return
+	}
+	subcommand := data.Options[0]
+	options := subcommandOptions(subcommand)
+	var lines []string
+	switch subcommand.Name {
+	case "tail":
+		n := consoleTailDefault
+		if option, ok := options["n"]; ok {
+			n = int(option.IntValue())
+		}
+		lines = lib.TailLines(self.console.Items(), n)
+	case "grep":
+		re, err := regexp.Compile(options["regex"].StringValue())
+		if err != nil {
+			self.respond(s, i, fmt.Sprintf("Invalid regular expression: %v", err))
+			return
+		}
+		lines = lib.GrepLines(self.console.Items(), re)
+		if len(lines) == 0 {
+			self.respond(s, i, "No matching lines.")
+			return
+		}
+	}
+	self.respond(s, i, lib.FormatCodeBlock(lines, lib.DiscordMessageLimit))
+}
+
+// interactionAuthor returns the member who used an interaction, for the
+// AuthorCondition of who may use it.
+func interactionAuthor(s *discordgo.Session, i *discordgo.InteractionCreate) lib.Author {
+	return lib.Author{
+		Id:       i.Member.User.ID,
+		Username: i.Member.User.Username,
+		Nickname: i.Member.Nick,
+		Roles:    memberRoles(s, i.GuildID, i.Member.Roles),
+	}
+}
+
+// respond responds to an interaction with a message only the user sees.
+func (self *BotContext) respond(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("[error] error responding to interaction: %v", err)
+	}
+}
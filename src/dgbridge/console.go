@@ -0,0 +1,197 @@
+package main
+
+// This file declares the consoles the bridge relays: the subprocess, or the
+// console of a game server run elsewhere, e.g. by a hosting panel.
+
+import (
+	"crypto/tls"
+	"dgbridge/src/ext"
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// remoteConsoleRetryInterval is the time between attempts to connect to a
+// remote console.
+const remoteConsoleRetryInterval = 10 * time.Second
+
+// remoteConsolePollInterval is the time between polls of remote consoles whose
+// API has no push mechanism.
+const remoteConsolePollInterval = time.Second
+
+// remoteConsoleTimeout is the timeout of requests to remote console APIs.
+const remoteConsoleTimeout = 10 * time.Second
+
+// Console is the console of a game server: lines are read from it and
+// commands are written to it. SubprocessContext is the console of a
+// subprocess; other consoles connect to a server that dgbridge doesn't run.
+type Console interface {
+	// InstanceName returns the name of the instance, empty if the bridge
+	// relays a single console.
+	InstanceName() string
+	// Start starts reading lines. It returns once the console was started, not
+	// when it stops.
+	Start() error
+	// Stop stops the console, returning once it has stopped.
+	Stop()
+	// WriteLine writes a line to the console, without a newline.
+	WriteLine(text string)
+	// Output emits the lines read from the console.
+	Output() *ext.EventChannel[OutputLine]
+	// Exited emits the exit code once the console has stopped.
+	Exited() *ext.EventChannel[int]
+}
+
+// remoteConsole implements the parts of Console that are the same for all
+// consoles of servers that dgbridge doesn't run. Stopping a remote console only
+// disconnects from it; the server keeps running.
+type remoteConsole struct {
+	Name            string                       // Name of the instance, see Console.InstanceName
+	States          *lib.ProcessStateTracker     // Tracks the state of the server, may be nil
+	OutputLineEvent ext.EventChannel[OutputLine] // See Console.Output
+	ExitEvent       ext.EventChannel[int]        // See Console.Exited
+	stopped         chan struct{}                // Closed once the console is stopped
+	stopOnce        sync.Once
+}
+
+func newRemoteConsole() remoteConsole {
+	return remoteConsole{stopped: make(chan struct{})}
+}
+
+func (self *remoteConsole) InstanceName() string {
+	return self.Name
+}
+
+func (self *remoteConsole) Output() *ext.EventChannel[OutputLine] {
+	return &self.OutputLineEvent
+}
+
+func (self *remoteConsole) Exited() *ext.EventChannel[int] {
+	return &self.ExitEvent
+}
+
+// emitLine broadcasts a line read from the console.
+func (self *remoteConsole) emitLine(text string, stderr bool) {
+	self.emit(OutputLine{Text: text, Stderr: stderr, Instance: self.Name})
+}
+
+// emit broadcasts a line read from the console, setting its time and state.
+func (self *remoteConsole) emit(line OutputLine) {
+	line.Time = time.Now()
+	if self.States != nil {
+		line.State = self.States.Observe(line.Text)
+	}
+	self.OutputLineEvent.Broadcast(line)
+}
+
+// isStopped reports whether the console was stopped.
+func (self *remoteConsole) isStopped() bool {
+	select {
+	case <-self.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop marks the console as stopped and broadcasts ExitEvent, once.
+//
+// Parameters:
+//
+//	disconnect: closes the connection to the console, called once
+func (self *remoteConsole) stop(disconnect func()) {
+	self.stopOnce.Do(func() {
+		close(self.stopped)
+		disconnect()
+		go self.ExitEvent.Broadcast(0)
+	})
+}
+
+// sleep waits for the retry interval, or until the console is stopped.
+//
+// Returns:
+//
+//	false if the console was stopped
+func (self *remoteConsole) sleep() bool {
+	return self.wait(remoteConsoleRetryInterval)
+}
+
+// wait waits for a duration, or until the console is stopped.
+//
+// Returns:
+//
+//	false if the console was stopped
+func (self *remoteConsole) wait(duration time.Duration) bool {
+	select {
+	case <-self.stopped:
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
+// relayConsoleOutput continuously relays the lines of a remote console to
+// os.Stdout, like relaySubprocessStdout does for a subprocess.
+func relayConsoleOutput(console Console) {
+	lineCh := console.Output().Listen()
+	defer console.Output().Off(lineCh)
+	for line := range lineCh {
+		output := os.Stdout
+		if line.Stderr {
+			output = os.Stderr
+		}
+		_, _ = output.WriteString(instancePrefix(line.Instance) + line.Text + "\n")
+	}
+}
+
+// logDroppedCommand logs a line that couldn't be written to a remote console.
+func logDroppedCommand(console string, text string) {
+	log.Printf("[warning] not connected to the %v console, dropping line %q\n", console, text)
+}
+
+// newRemoteConsoleClient returns the HTTP client of a remote console API.
+//
+// Parameters:
+//
+//	insecure: whether to skip verifying the server's certificate, e.g. for
+//		panels with a self-signed certificate
+func newRemoteConsoleClient(insecure bool) *http.Client {
+	client := &http.Client{Timeout: remoteConsoleTimeout}
+	if insecure {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client.Transport = transport
+	}
+	return client
+}
+
+// doJSON sends a request to a remote console API and decodes its JSON response.
+//
+// Parameters:
+//
+//	result: decoded response, may be nil to ignore the response body
+func doJSON(client *http.Client, request *http.Request, result any) error {
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %v from %v", response.Status, request.URL.Path)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding response of %v: %v", request.URL.Path, err)
+	}
+	return nil
+}
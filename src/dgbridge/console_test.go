@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConsole is a Console whose lines are emitted by the test, and that
+// records the lines written to it.
+type fakeConsole struct {
+	remoteConsole
+	written chan string
+}
+
+func newFakeConsole(name string) *fakeConsole {
+	self := &fakeConsole{remoteConsole: newRemoteConsole(), written: make(chan string, 16)}
+	self.Name = name
+	return self
+}
+
+func (self *fakeConsole) Start() error {
+	return nil
+}
+
+func (self *fakeConsole) Stop() {
+	self.stop(func() {})
+}
+
+func (self *fakeConsole) WriteLine(text string) {
+	self.written <- text
+}
+
+// nextWritten returns the next line written to the console, failing the test
+// if there is none within a second.
+func (self *fakeConsole) nextWritten(t *testing.T) string {
+	t.Helper()
+	select {
+	case line := <-self.written:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("no line was written to the console")
+		return ""
+	}
+}
+
+func TestSubprocessGroupWriteLine(t *testing.T) {
+	lobby, survival := newFakeConsole("lobby"), newFakeConsole("survival")
+	group := NewSubprocessGroup([]Console{lobby, survival})
+	group.WriteLine(stdinLine{Instance: "Survival", Text: "say hi"})
+	group.WriteLine(stdinLine{Text: "list"})
+	group.WriteLine(stdinLine{Instance: "creative", Text: "dropped"})
+	assert.Equal(t, "say hi", survival.nextWritten(t))
+	assert.Equal(t, "list", lobby.nextWritten(t))
+	assert.Equal(t, "list", survival.nextWritten(t))
+	assert.Empty(t, lobby.written)
+	assert.Empty(t, survival.written)
+}
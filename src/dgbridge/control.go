@@ -0,0 +1,204 @@
+package main
+
+// This file implements the HTTP control API, see --control_listen. It lets
+// external panels and dashboards control the bridge without Discord access.
+//
+// All endpoints take a POST request with an optional JSON body, authenticated
+// with "Authorization: Bearer <--control_token>":
+//
+//	/restart: stops the subprocess instances gracefully, then restarts dgbridge
+//	/send:    {"text": ..., "to": "discord" | "server", "instance": ...}
+//	/pause:   {"direction": ..., "hold": true | false}
+//	/resume:  {"direction": ...}
+
+import (
+	"crypto/subtle"
+	"dgbridge/src/lib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// controlBodyLimit is the maximum size of a request body.
+const controlBodyLimit = 64 * 1024
+
+// Targets of /send.
+const (
+	sendToDiscord = "discord"
+	sendToServer  = "server"
+)
+
+// controlRequest is the JSON body of a control request. Each endpoint uses
+// some of the fields.
+type controlRequest struct {
+	Text      string `json:"text"`
+	To        string `json:"to"`       // sendToDiscord (default) or sendToServer
+	Instance  string `json:"instance"` // Subprocess instance of sendToServer, empty for all
+	Direction string `json:"direction"`
+	Hold      bool   `json:"hold"`
+}
+
+// ControlServer serves the HTTP control API.
+type ControlServer struct {
+	token      string
+	service    bool // Whether dgbridge runs as a service, see restartSupported
+	subprocess *SubprocessGroup
+	restarting atomic.Bool // Set once /restart was requested, see Restarting
+
+	mutex   sync.Mutex
+	bot     *BotContext        // Set once the Discord session is ready
+	session *discordgo.Session // Session of bot
+}
+
+// StartControlServer starts serving the control API. This function is
+// non-blocking.
+//
+// Parameters:
+//
+//	addr: address to listen on, e.g. 127.0.0.1:7071
+//	token: bearer token that requests must be authenticated with
+//	service: whether dgbridge runs as a system service
+func StartControlServer(addr string, token string, service bool, subprocess *SubprocessGroup) (*ControlServer, error) {
+	if token == "" {
+		return nil, fmt.Errorf("the control API requires a token")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	self := &ControlServer{token: token, service: service, subprocess: subprocess}
+	go func() {
+		log.Printf("[info] Serving the control API on %v\n", listener.Addr())
+		if err := http.Serve(listener, self.handler()); err != nil {
+			log.Printf("[error] control API stopped: %v", err)
+		}
+	}()
+	return self, nil
+}
+
+// handler returns the handler of the endpoints of the control API.
+func (self *ControlServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /restart", self.authenticated(self.restart))
+	mux.HandleFunc("POST /send", self.authenticated(self.send))
+	mux.HandleFunc("POST /pause", self.authenticated(self.setPaused(true)))
+	mux.HandleFunc("POST /resume", self.authenticated(self.setPaused(false)))
+	return mux
+}
+
+// attach makes the bot's features available to the control API, once the
+// Discord session is ready.
+func (self *ControlServer) attach(bot *BotContext, session *discordgo.Session) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.bot = bot
+	self.session = session
+}
+
+// Restarting reports whether the subprocess exited because of a /restart
+// request, so that dgbridge restarts instead of exiting.
+func (self *ControlServer) Restarting() bool {
+	return self.restarting.Load()
+}
+
+// authenticated wraps a handler of a control request, checking the token and
+// decoding the body.
+func (self *ControlServer) authenticated(handler func(w http.ResponseWriter, request controlRequest)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expected := []byte("Bearer " + self.token)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var request controlRequest
+		err := json.NewDecoder(io.LimitReader(r.Body, controlBodyLimit)).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		handler(w, request)
+	}
+}
+
+// restart handles /restart.
+func (self *ControlServer) restart(w http.ResponseWriter, _ controlRequest) {
+	if err := restartSupported(self.service); err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if !self.restarting.CompareAndSwap(false, true) {
+		http.Error(w, "already restarting", http.StatusConflict)
+		return
+	}
+	log.Println("[info] Restart requested through the control API")
+	w.WriteHeader(http.StatusAccepted)
+	// The bridge restarts once the subprocess has exited, see startBridge.
+	go self.subprocess.Stop()
+}
+
+// send handles /send.
+func (self *ControlServer) send(w http.ResponseWriter, request controlRequest) {
+	if request.Text == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+	switch request.To {
+	case "", sendToDiscord:
+		bot, session := self.attached()
+		if bot == nil {
+			http.Error(w, "not connected to Discord", http.StatusServiceUnavailable)
+			return
+		}
+		if _, err := bot.deliverMessage(session, request.Text, "control API"); err != nil {
+			http.Error(w, fmt.Sprintf("error sending message: %v", err), http.StatusBadGateway)
+			return
+		}
+	case sendToServer:
+		self.subprocess.WriteLine(stdinLine{Instance: request.Instance, Text: request.Text})
+	default:
+		http.Error(w, fmt.Sprintf("invalid target %q, expected %q or %q", request.To, sendToDiscord, sendToServer), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setPaused returns the handler of /pause or /resume.
+func (self *ControlServer) setPaused(paused bool) func(w http.ResponseWriter, request controlRequest) {
+	return func(w http.ResponseWriter, request controlRequest) {
+		switch request.Direction {
+		case "", lib.DirectionSubprocessToDiscord, lib.DirectionDiscordToSubprocess:
+		default:
+			http.Error(w, fmt.Sprintf("invalid direction %q", request.Direction), http.StatusBadRequest)
+			return
+		}
+		bot, _ := self.attached()
+		if bot == nil {
+			http.Error(w, "not connected to Discord", http.StatusServiceUnavailable)
+			return
+		}
+		if paused {
+			bot.pause.pause(request.Direction, request.Hold)
+			log.Printf("[info] Relaying paused through the control API (direction: %q, hold: %v)\n", request.Direction, request.Hold)
+		} else {
+			held := bot.resumeRelaying(request.Direction)
+			log.Printf("[info] Relaying resumed through the control API (direction: %q, %v held messages)\n", request.Direction, held)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// attached returns the bot and its session, or nil if the Discord session
+// isn't ready yet.
+func (self *ControlServer) attached() (*BotContext, *discordgo.Session) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.bot, self.session
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// controlPost posts a control request, returning the response status.
+func controlPost(t *testing.T, server *httptest.Server, path string, token string, body string) int {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := server.Client().Do(request)
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	_ = response.Body.Close()
+	return response.StatusCode
+}
+
+func TestStartControlServer(t *testing.T) {
+	_, err := StartControlServer("127.0.0.1:0", "", false, nil)
+	assert.Error(t, err)
+
+	// The address is in use.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	_, err = StartControlServer(listener.Addr().String(), "secret", false, nil)
+	assert.Error(t, err)
+}
+
+func TestControlSend(t *testing.T) {
+	console := newFakeConsole("survival")
+	control := &ControlServer{token: "secret", subprocess: NewSubprocessGroup([]Console{console})}
+	server := httptest.NewServer(control.handler())
+	defer server.Close()
+
+	tests := []struct {
+		Token    string
+		Body     string
+		Expected int
+	}{
+		{"wrong", `{"text": "say hi", "to": "server"}`, http.StatusUnauthorized},
+		{"secret", `{"text": `, http.StatusBadRequest},
+		{"secret", `{"to": "server"}`, http.StatusBadRequest},
+		{"secret", `{"text": "say hi", "to": "irc"}`, http.StatusBadRequest},
+		// Messages to Discord wait for the session.
+		{"secret", `{"text": "hi"}`, http.StatusServiceUnavailable},
+		{"secret", `{"text": "say hi", "to": "server"}`, http.StatusNoContent},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.Expected, controlPost(t, server, "/send", test.Token, test.Body), "Test #%v", i)
+	}
+	assert.Equal(t, "say hi", console.nextWritten(t))
+	assert.Empty(t, console.written)
+}
+
+func TestControlPause(t *testing.T) {
+	console := newFakeConsole("")
+	group := NewSubprocessGroup([]Console{console})
+	control := &ControlServer{token: "secret", subprocess: group}
+	server := httptest.NewServer(control.handler())
+	defer server.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, controlPost(t, server, "/pause", "secret", ""))
+	bot := &BotContext{pause: newRelayPause(10), subprocess: group}
+	control.attach(bot, nil)
+
+	tests := []struct {
+		Path     string
+		Body     string
+		Expected int
+	}{
+		{"/pause", `{"direction": "sideways"}`, http.StatusBadRequest},
+		{"/pause", `{"direction": "` + lib.DirectionDiscordToSubprocess + `", "hold": true}`, http.StatusNoContent},
+		{"/resume", `{"direction": "sideways"}`, http.StatusBadRequest},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.Expected, controlPost(t, server, test.Path, "secret", test.Body), "Test #%v", i)
+	}
+
+	// Held messages are written once relaying resumes.
+	assert.False(t, bot.pause.admitDiscordToSubprocess(stdinLine{Text: "say held"}))
+	assert.Empty(t, console.written)
+	assert.Equal(t, http.StatusNoContent, controlPost(t, server, "/resume", "secret", ""))
+	assert.Equal(t, "say held", console.nextWritten(t))
+	assert.True(t, bot.pause.admitDiscordToSubprocess(stdinLine{Text: "say hi"}))
+}
+
+func TestControlRestart(t *testing.T) {
+	control := &ControlServer{token: "secret", subprocess: NewSubprocessGroup([]Console{newFakeConsole("")})}
+	server := httptest.NewServer(control.handler())
+	defer server.Close()
+
+	assert.Equal(t, http.StatusUnauthorized, controlPost(t, server, "/restart", "wrong", ""))
+	assert.False(t, control.Restarting())
+	if restartSupported(false) != nil {
+		assert.Equal(t, http.StatusNotImplemented, controlPost(t, server, "/restart", "secret", ""))
+		return
+	}
+	assert.Equal(t, http.StatusAccepted, controlPost(t, server, "/restart", "secret", ""))
+	assert.True(t, control.Restarting())
+	assert.Equal(t, http.StatusConflict, controlPost(t, server, "/restart", "secret", ""))
+}
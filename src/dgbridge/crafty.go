@@ -0,0 +1,148 @@
+package main
+
+// This file implements relaying the console of a server on Crafty Controller
+// 4, see --crafty_url. Crafty's API only returns the tail of the console, so
+// it is polled and compared with the previous tail.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// craftyLogs is the response of the logs endpoint.
+type craftyLogs struct {
+	Status string   `json:"status"`
+	Data   []string `json:"data"`
+}
+
+// CraftyConsole is the Console of a server on Crafty Controller. Stopping it
+// only disconnects; the server keeps running.
+type CraftyConsole struct {
+	remoteConsole
+	url       string // e.g. https://localhost:8443
+	serverId  string
+	token     string
+	client    *http.Client
+	connected atomic.Bool // Whether the last poll succeeded
+}
+
+// NewCraftyConsole creates the console of a Crafty server. It is not
+// connected until started.
+//
+// Parameters:
+//
+//	url: URL of Crafty's web interface
+//	serverId: ID of the server, as shown in its URL on Crafty
+//	token: API token of a user with access to the server's terminal
+//	insecure: whether to skip verifying Crafty's certificate, which is
+//		self-signed by default
+func NewCraftyConsole(url string, serverId string, token string, insecure bool) *CraftyConsole {
+	return &CraftyConsole{
+		remoteConsole: newRemoteConsole(),
+		url:           strings.TrimSuffix(url, "/"),
+		serverId:      serverId,
+		token:         token,
+		client:        newRemoteConsoleClient(insecure),
+	}
+}
+
+// newCraftyConsole creates the console configured with command line arguments,
+// and relays its output to the terminal.
+func newCraftyConsole(args CliArgs, rules *lib.Rules) Console {
+	if args.CraftyServer == "" || args.CraftyToken == "" {
+		log.Fatalln("[fatal] --crafty_url requires --crafty_server and --crafty_token")
+	}
+	console := NewCraftyConsole(args.CraftyUrl, args.CraftyServer, args.CraftyToken, args.CraftyInsecure)
+	console.States = lib.NewProcessStateTracker(rules.States)
+	go superviseJob("relay of the console", func() { relayConsoleOutput(console) })
+	return console
+}
+
+// Start connects to the console in the background.
+func (self *CraftyConsole) Start() error {
+	go superviseJob("Crafty console", self.run)
+	return nil
+}
+
+// Stop disconnects from the console.
+func (self *CraftyConsole) Stop() {
+	self.stop(func() {})
+}
+
+// WriteLine sends a command to the server. Commands are dropped while the
+// console is disconnected.
+func (self *CraftyConsole) WriteLine(text string) {
+	if !self.connected.Load() {
+		logDroppedCommand("Crafty", text)
+		return
+	}
+	request, err := self.newRequest(http.MethodPost, "stdin", strings.NewReader(text))
+	if err == nil {
+		request.Header.Set("Content-Type", "text/plain")
+		err = doJSON(self.client, request, nil)
+	}
+	if err != nil {
+		log.Printf("[error] error writing to the Crafty console: %v", err)
+		reportError(lib.MsgErrorKindServer, err)
+	}
+}
+
+// run polls the console until it is stopped. Lines that were already in the
+// console when the bridge connected are skipped.
+func (self *CraftyConsole) run() {
+	var previous []string
+	for self.wait(remoteConsolePollInterval) {
+		current, err := self.fetchLines()
+		if err != nil {
+			self.connected.Store(false)
+			log.Printf("[error] Crafty console of server %v disconnected: %v", self.serverId, err)
+			if !self.sleep() {
+				return
+			}
+			continue
+		}
+		if !self.connected.Swap(true) {
+			log.Printf("[info] Connected to the Crafty console of server %v\n", self.serverId)
+		}
+		if previous != nil {
+			for _, line := range lib.NewLines(previous, current) {
+				self.emitLine(line, false)
+			}
+		}
+		// An empty tail is kept as an empty, non-nil tail, so that the lines
+		// that follow are new.
+		previous = append(make([]string, 0, len(current)), current...)
+	}
+}
+
+// fetchLines returns the tail of the console.
+func (self *CraftyConsole) fetchLines() ([]string, error) {
+	request, err := self.newRequest(http.MethodGet, "logs", nil)
+	if err != nil {
+		return nil, err
+	}
+	var logs craftyLogs
+	if err := doJSON(self.client, request, &logs); err != nil {
+		return nil, err
+	}
+	if logs.Status != "ok" {
+		return nil, fmt.Errorf("unexpected status %q", logs.Status)
+	}
+	return logs.Data, nil
+}
+
+// newRequest creates an authenticated request to an endpoint of the server.
+func (self *CraftyConsole) newRequest(method string, endpoint string, body io.Reader) (*http.Request, error) {
+	url := fmt.Sprintf("%v/api/v2/servers/%v/%v", self.url, self.serverId, endpoint)
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+self.token)
+	return request, nil
+}
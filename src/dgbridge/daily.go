@@ -0,0 +1,109 @@
+package main
+
+// This file implements the daily summary of the bridge's statistics, see
+// --daily_summary_channel_id.
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// dailyStatsSaveInterval is the time between saves of the daily stats. Stats
+// counted since the last save are lost if the bridge crashes.
+const dailyStatsSaveInterval = time.Minute
+
+// dailySummaryColor is the color of the daily summary embed.
+const dailySummaryColor = 0x5865f2
+
+// dailySummary posts the daily stats to a channel once a day.
+type dailySummary struct {
+	stats     *lib.DailyStatsRecorder
+	channelId string // Channel the summary is posted to
+	hour      int    // Time of day the summary is posted at, in local time
+	minute    int
+	template  string // See lib.FormatDailySummary, empty for lib.MsgDailySummary
+}
+
+// newDailySummary creates the daily summary configured with command line
+// arguments, loading the saved stats.
+func newDailySummary(args CliArgs) *dailySummary {
+	if !lib.IsSnowflake(args.DailyChannelId) {
+		log.Fatalf("[fatal] --daily_summary_channel_id: %q is not a valid Discord channel ID\n", args.DailyChannelId)
+	}
+	hour, minute, err := lib.ParseClock(args.DailyTime)
+	if err != nil {
+		log.Fatalln("[fatal] --daily_summary_time:", err)
+	}
+	stats, err := lib.LoadDailyStats(args.StatsFile, time.Now())
+	if err != nil {
+		log.Fatalln("[fatal] error loading stats file:", err)
+	}
+	return &dailySummary{
+		stats:     stats,
+		channelId: args.DailyChannelId,
+		hour:      hour,
+		minute:    minute,
+		template:  args.DailyTemplate,
+	}
+}
+
+// startDailySummaryJob posts the daily summary at the configured time of day,
+// and saves the stats periodically. If the bridge wasn't running when the last
+// summary was due, it is posted right away.
+func (self *BotContext) startDailySummaryJob(s *discordgo.Session) {
+	daily := self.daily
+	saveTicker := time.NewTicker(dailyStatsSaveInterval)
+	defer saveTicker.Stop()
+	due := lib.NextClock(daily.stats.Since().Local(), daily.hour, daily.minute)
+	for {
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-timer.C:
+			self.postDailySummary(s)
+			due = lib.NextClock(time.Now(), daily.hour, daily.minute)
+		case <-saveTicker.C:
+			timer.Stop()
+			if err := daily.stats.Save(); err != nil {
+				log.Printf("[error] error saving daily stats: %v", err)
+			}
+		}
+	}
+}
+
+// postDailySummary posts the stats counted since the last summary, and starts
+// counting anew.
+func (self *BotContext) postDailySummary(s *discordgo.Session) {
+	daily := self.daily
+	stats, err := daily.stats.Take(time.Now())
+	if err != nil {
+		log.Printf("[error] error saving daily stats: %v", err)
+	}
+	template := daily.template
+	if template == "" {
+		template = lib.Tr(lib.MsgDailySummary)
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       lib.Tr(lib.MsgDailySummaryTitle, stats.Since.Format(time.DateOnly)),
+		Description: lib.FormatDailySummary(template, stats),
+		Color:       dailySummaryColor,
+		Footer:      self.statusFooter(),
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+	if _, err := s.ChannelMessageSendEmbed(daily.channelId, embed); err != nil {
+		log.Printf("[error] error posting daily summary: %v", err)
+	}
+}
+
+// recordDailyMessage counts a relayed message in the daily stats, if enabled.
+//
+// Parameters:
+//
+//	chatter: Discord user ID or in-game name of the sender, may be empty
+func (self *BotContext) recordDailyMessage(direction string, chatter string) {
+	if self.daily != nil {
+		self.daily.stats.RecordMessage(direction, chatter)
+	}
+}
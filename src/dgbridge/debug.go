@@ -0,0 +1,133 @@
+package main
+
+// This file implements the debug echo, see --debug-listen: a plaintext TCP
+// stream of each line or message, the result of the rules and the matching
+// rule, for tuning rules against a running server, e.g. with
+// "nc localhost 7072".
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// debugClientBuffer is the number of lines buffered for each debug client.
+// Lines are dropped while a client's buffer is full, so slow clients don't
+// hold up relaying.
+const debugClientBuffer = 256
+
+// debugWriteTimeout is the time after which a debug client that doesn't read
+// is disconnected.
+const debugWriteTimeout = 10 * time.Second
+
+// DebugEcho serves the debug echo to TCP clients.
+type DebugEcho struct {
+	mutex   sync.Mutex
+	clients map[chan string]struct{} // Lines to send, by client
+}
+
+// StartDebugEcho starts serving the debug echo. This function is non-blocking.
+//
+// Parameters:
+//
+//	addr: address to listen on, e.g. 127.0.0.1:7072
+func StartDebugEcho(addr string) (*DebugEcho, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	self := &DebugEcho{clients: make(map[chan string]struct{})}
+	log.Printf("[info] Serving the debug echo on %v\n", listener.Addr())
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Printf("[error] debug echo: %v", err)
+				return
+			}
+			go self.serve(conn)
+		}
+	}()
+	return self, nil
+}
+
+// serve sends the echoed lines to a client until it disconnects.
+func (self *DebugEcho) serve(conn net.Conn) {
+	defer recoverPanic("debug echo")
+	defer conn.Close()
+	lines := make(chan string, debugClientBuffer)
+	self.mutex.Lock()
+	self.clients[lines] = struct{}{}
+	self.mutex.Unlock()
+	defer func() {
+		self.mutex.Lock()
+		delete(self.clients, lines)
+		self.mutex.Unlock()
+	}()
+
+	// Notice disconnects while no lines are echoed. Clients aren't expected to
+	// send anything.
+	closed := make(chan struct{})
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			if _, err := conn.Read(buffer); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+	lines <- "# dgbridge debug echo: (direction) raw → transformed [rule]\n"
+	for {
+		select {
+		case line := <-lines:
+			_ = conn.SetWriteDeadline(time.Now().Add(debugWriteTimeout))
+			if _, err := conn.Write([]byte(line)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// Echo sends the outcome of applying the rules to a line or message to the
+// connected clients.
+//
+// Parameters:
+//
+//	direction: one of the Direction constants
+//	input: the line or message the rules were applied to
+//	match: the matching rule, or nil if none matched
+func (self *DebugEcho) Echo(direction string, input string, match *lib.RuleMatch) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if len(self.clients) == 0 {
+		return
+	}
+	line := formatDebugLine(direction, input, match)
+	for client := range self.clients {
+		select {
+		case client <- line:
+		default:
+			// The client is too slow, drop the line.
+		}
+	}
+}
+
+// formatDebugLine formats a line of the debug echo. The texts are quoted, so
+// that ANSI codes, line breaks and surrounding spaces are visible.
+func formatDebugLine(direction string, input string, match *lib.RuleMatch) string {
+	if match == nil {
+		return fmt.Sprintf("(%v) %v → (no match)\n", direction, strconv.QuoteToGraphic(input))
+	}
+	return fmt.Sprintf("(%v) %v → %v [%v]\n",
+		direction, strconv.QuoteToGraphic(input), strconv.QuoteToGraphic(match.Result), match.RuleId())
+}
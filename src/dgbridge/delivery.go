@@ -0,0 +1,78 @@
+package main
+
+// This file implements retrying messages that failed to send to Discord, and
+// the dead letter file that messages are written to once retrying gave up.
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// deadLetter is an entry of the dead letter file, one JSON object per line.
+type deadLetter struct {
+	Time    time.Time `json:"time"`
+	Rule    string    `json:"rule"`
+	Content string    `json:"content"`
+	Error   string    `json:"error"`
+}
+
+// isTransientError reports whether sending a message may succeed if retried.
+// Server errors and network errors are transient; other API errors, such as
+// missing permissions (403) or an unknown channel (404), are not; neither are
+// messages held back while the bot lacks permissions, see lib.SendBackoff.
+func isTransientError(err error) bool {
+	if errors.Is(err, errSendForbidden) {
+		return false
+	}
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Response == nil {
+		return true
+	}
+	status := restErr.Response.StatusCode
+	return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+}
+
+// deliverMessage sends a message to the relay channel, retrying transient
+// failures according to the retry policy. If the message can't be sent, it is
+// counted as dropped and written to the dead letter file.
+//
+// Parameters:
+//
+//	ruleId: the rule that produced the message, see lib.RuleMatch.RuleId
+func (self *BotContext) deliverMessage(session *discordgo.Session, content string, ruleId string) (*discordgo.Message, error) {
+	var message *discordgo.Message
+	err := self.sendRetry.Retry(func() error {
+		var err error
+		message, err = self.sendMessage(session, content)
+		if err != nil && isTransientError(err) {
+			log.Printf("[warning] error sending message of rule %v to discord, retrying: %v", ruleId, err)
+		}
+		return err
+	}, isTransientError, time.Sleep)
+	if err != nil {
+		self.dropped.Add(1)
+		self.writeDeadLetter(deadLetter{Time: time.Now(), Rule: ruleId, Content: content, Error: err.Error()})
+		return nil, err
+	}
+	return message, nil
+}
+
+// writeDeadLetter appends an entry to the dead letter file, if there is one.
+func (self *BotContext) writeDeadLetter(entry deadLetter) {
+	if self.deadLetters == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("[error] error encoding dead letter: %v", err)
+		return
+	}
+	if _, err := self.deadLetters.Write(append(line, '\n')); err != nil {
+		log.Printf("[error] error writing dead letter file: %v", err)
+	}
+}
@@ -3,27 +3,117 @@ package main
 import (
 	"dgbridge/src/ext"
 	"dgbridge/src/lib"
+	"dgbridge/src/sink"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 // BotParameters holds data to be passed to StartDiscordBot.
 type BotParameters struct {
-	Token          string             // Discord auth token
-	RelayChannelId string             // Saved in BotContext
-	Subprocess     *SubprocessContext // Saved in BotContext
-	Rules          lib.Rules          // Saved in BotContext
+	Token          string                  // Discord auth token
+	RelayChannelId string                  // Saved in BotContext
+	Name           string                  // Saved in BotContext, may be empty
+	Subprocess     *SubprocessGroup        // Saved in BotContext
+	OutputLines    <-chan OutputLine       // Subprocess stdout and stderr lines to relay, see bufferLines
+	Rules          lib.Rules               // Saved in BotContext
+	UserMap        *userDirectory          // Saved in BotContext
+	VerifyUsers    bool                    // Check that all users in UserMap are guild members once ready
+	Signature      string                  // Appended to sent messages, messages with it are ignored
+	Sink           *sink.Router            // Saved in BotContext, may be nil
+	Console        *ext.RingBuffer[string] // Saved in BotContext, may be nil
+	Backfill       []string                // Results of the Backfill rules, sent once ready
+	SendRetry      lib.RetryPolicy         // Retries of messages that failed to send
+	DeadLetters    io.Writer               // Receives messages that failed to send, may be nil
+	MemberCacheTTL time.Duration           // Time guild member lookups are cached for, see MemberResolver
+	AlertUserIds   []string                // Users that results of critical rules are sent to as direct messages
+	AlertInterval  time.Duration           // Minimum time between alerts, more are summarized
+	SummaryUntil   time.Time               // End of the startup window of summarize rules, see lib.StartupSummary
+	QueueLimit     int                     // Maximum number of Discord messages held while paused or rate limited
+	APILimiter     *APILimiter             // Limits all Discord API requests, may be nil
+	UpdateCheck    *updateCheck            // Check for a newer release, may be nil
+	UpdateChannel  string                  // ID of the channel update notices are posted to, may be empty
+	DailySummary   *dailySummary           // Posts the daily stats, may be nil
+	ServerQuery    *serverQuery            // Polls the server's status, may be nil
+	Journal        *lib.Journal            // Saved in BotContext, may be nil
+	DebugEcho      *DebugEcho              // Saved in BotContext, may be nil
+	ErrorChannel   string                  // ID of the channel error reports are posted to, may be empty
+	ErrorInterval  time.Duration           // Time between error reports
+	Control        *ControlServer          // Serves the control API, may be nil
+	LinkOAuth      *linkOAuth              // Verifies /link with OAuth2, may be nil
+	Mutes          *lib.MuteList           // Users whose messages aren't relayed, may be nil
+	Shadow         *shadowRules            // Candidate rules compared with the rules, may be nil
+	Reloader       *configReloader         // Reloads the rules and users, may be nil
+	History        *channelHistory         // Relays messages sent while the bridge was down, may be nil
+	Shard          shardConfig             // Gateway shard to connect as
+	OnReady        func()                  // Called once the session is ready, may be nil
 }
 
 type BotContext struct {
-	relayChannelId string             // ID of destination Discord channel
-	subprocess     *SubprocessContext // Subprocess context
-	rules          lib.Rules          // Message conversion rules
-	readyOnce      sync.Once          // Tracks if bot was initialized
+	relayChannelId  string                        // ID of destination Discord channel
+	name            string                        // Name of the bridge in sink events and status embeds, see --bridge_name
+	subprocess      *SubprocessGroup              // Subprocess instances
+	outputLines     <-chan OutputLine             // Subprocess stdout and stderr lines to relay, in order
+	rules           atomic.Pointer[lib.Rules]     // Message conversion rules, replaced when they are reloaded
+	userMap         *userDirectory                // In-game names to mention as Discord users
+	verifyUsers     bool                          // Check that all users in userMap are guild members once ready
+	signature       string                        // Appended to sent messages, messages with it are ignored
+	sink            *sink.Router                  // Receives matched messages, may be nil
+	console         *ext.RingBuffer[string]       // Recent console lines for /console, may be nil
+	backfill        []string                      // Results of the Backfill rules, sent once ready
+	pause           *relayPause                   // Directions in which relaying is paused
+	maintenance     maintenanceMode               // Toggled with /bridge maintenance
+	limiters        map[string]*lib.RateLimiter   // Rate limiters by direction
+	stdinQueue      *ext.DroppingQueue[stdinLine] // Rate limited messages to the subprocess
+	stdinMutex      sync.Mutex                    // Guards pushing to stdinQueue
+	alertUserIds    []string                      // Users that results of critical rules are sent to
+	alertLimiter    *lib.RateLimiter              // Limits the rate of alerts
+	alertInterval   time.Duration                 // Minimum time between alerts
+	sendRetry       lib.RetryPolicy               // Retries of messages that failed to send
+	sendBackoff     *lib.SendBackoff              // Holds back messages while Discord rejects them
+	deadLetters     io.Writer                     // Receives messages that failed to send, may be nil
+	dropped         atomic.Int64                  // Messages that failed to send
+	members         *MemberResolver               // Guild members of mapped players
+	stats           *lib.RuleStats                // Matches of each rule, for /bridge stats
+	summary         *lib.StartupSummary           // Counts matches of summarize rules, nil if there are none
+	repeats         *lib.RepeatFilter             // Suppresses repeated messages to Discord, may be nil
+	threads         lib.ThreadTracker             // Open incident thread, see lib.ThreadConfig
+	emojis          emojiCache                    // Custom emoji of the relay channel's guild
+	readyOnce       sync.Once                     // Tracks if bot was initialized
+	apiLimiter      *APILimiter                   // Limits all Discord API requests, may be nil
+	updateCheck     *updateCheck                  // Check for a newer release, may be nil
+	updateChannelId string                        // Channel update notices are posted to, may be empty
+	daily           *dailySummary                 // Posts the daily stats, may be nil
+	query           *serverQuery                  // Polls the server's status, may be nil
+	journal         *lib.Journal                  // Relayed messages for /export, may be nil
+	debugEcho       *DebugEcho                    // Echoes the rules' results, may be nil
+	errorChannelId  string                        // Channel error reports are posted to, may be empty
+	errorInterval   time.Duration                 // Time between error reports
+	workflows       lib.WorkflowRunner            // Runs the moderation workflows, see Rules.Workflows
+	control         *ControlServer                // Serves the control API, may be nil
+	linkOAuth       *linkOAuth                    // Verification of /link, may be nil
+	links           *lib.PendingLinks             // Links started with /link, nil if /link is disabled
+	mutes           *lib.MuteList                 // Users muted with /bridgemute, nil if it is disabled
+	shadow          *shadowRules                  // Candidate rules compared with the rules, may be nil
+	reloader        *configReloader               // Reloads the rules and users, may be nil
+	history         *channelHistory               // Relays messages sent while the bridge was down, may be nil
+	shard           shardConfig                   // Gateway shard connected as
+	toSubprocess    *lib.Pipeline                 // Stages of Discord messages relayed to the subprocess
+	approved        *lib.Pipeline                 // Stages of approved Discord messages, see RequireApproval
+	approvals       *lib.PendingApprovals         // Commands waiting for approval
+	panel           *controlPanel                 // The control panel, nil without Rules.Panel
+	onReady         func()                        // Called once the session is ready, may be nil
 }
 
 // StartDiscordBot starts the discord bot. This function is non-blocking.
@@ -37,17 +127,79 @@ func StartDiscordBot(params BotParameters) (func(), error) {
 	if err != nil {
 		return nil, fmt.Errorf("error creating Discord session: %v", err)
 	}
+	if params.APILimiter != nil {
+		dg.Client = &http.Client{Timeout: dg.Client.Timeout, Transport: params.APILimiter}
+	}
 	context := BotContext{
-		relayChannelId: params.RelayChannelId,
-		subprocess:     params.Subprocess,
-		rules:          params.Rules,
-		readyOnce:      sync.Once{},
+		relayChannelId:  params.RelayChannelId,
+		name:            params.Name,
+		subprocess:      params.Subprocess,
+		outputLines:     params.OutputLines,
+		userMap:         params.UserMap,
+		verifyUsers:     params.VerifyUsers,
+		signature:       params.Signature,
+		sink:            params.Sink,
+		console:         params.Console,
+		backfill:        params.Backfill,
+		pause:           newRelayPause(params.QueueLimit),
+		limiters:        newRateLimiters(params.Rules.RateLimits),
+		stdinQueue:      ext.NewDroppingQueue[stdinLine](params.QueueLimit),
+		alertUserIds:    params.AlertUserIds,
+		alertLimiter:    newAlertLimiter(params.AlertInterval),
+		alertInterval:   params.AlertInterval,
+		sendRetry:       params.SendRetry,
+		sendBackoff:     lib.NewSendBackoff(forbiddenBackoff),
+		deadLetters:     params.DeadLetters,
+		members:         NewMemberResolver(dg, params.RelayChannelId, params.MemberCacheTTL),
+		stats:           lib.NewRuleStats(&params.Rules, lib.DirectionSubprocessToDiscord, lib.DirectionDiscordToSubprocess),
+		summary:         lib.NewStartupSummary(params.Rules.SubprocessToDiscord, params.SummaryUntil),
+		readyOnce:       sync.Once{},
+		apiLimiter:      params.APILimiter,
+		updateCheck:     params.UpdateCheck,
+		updateChannelId: params.UpdateChannel,
+		daily:           params.DailySummary,
+		query:           params.ServerQuery,
+		journal:         params.Journal,
+		debugEcho:       params.DebugEcho,
+		errorChannelId:  params.ErrorChannel,
+		errorInterval:   params.ErrorInterval,
+		control:         params.Control,
+		linkOAuth:       params.LinkOAuth,
+		mutes:           params.Mutes,
+		shadow:          params.Shadow,
+		reloader:        params.Reloader,
+		history:         params.History,
+		shard:           params.Shard,
+		onReady:         params.OnReady,
+	}
+	context.rules.Store(&params.Rules)
+	context.toSubprocess = context.relayToSubprocessPipeline(dg)
+	context.approved = lib.NewPipeline(context.subprocessDeliveryStages()...)
+	context.approvals = lib.NewPendingApprovals(params.Rules.Approvals.ApprovalTimeout())
+	if params.Rules.Repeats != nil {
+		context.repeats = lib.NewRepeatFilter(*params.Rules.Repeats)
+	}
+	if params.LinkOAuth != nil || (params.Rules.Link != nil && params.Rules.Link.Verify != nil) {
+		context.links = lib.NewPendingLinks(params.Rules.Link.LinkTimeout())
+	}
+	if params.Rules.Panel != nil {
+		context.panel = newControlPanel(params.Rules.Panel, params.RelayChannelId)
+		dg.AddHandler(context.panelMessageDelete())
 	}
 	dg.AddHandler(context.ready())
 	dg.AddHandler(context.messageCreate())
-	dg.Identify.Intents = discordgo.IntentsGuildMessages
+	dg.AddHandler(context.emojis.guildEmojisUpdate())
+	dg.AddHandler(context.interactionCreate())
+	// Guilds keeps the channels and roles in the state, see contentNames.
+	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsGuildEmojis
+	if err := params.Shard.apply(dg, params.RelayChannelId); err != nil {
+		return nil, err
+	}
 	err = dg.Open()
 	if err != nil {
+		// Close what was opened, so that a retry doesn't leave a second
+		// session behind.
+		_ = dg.Close()
 		return nil, fmt.Errorf("error opening connection: %v", err)
 	}
 	return func() {
@@ -59,58 +211,363 @@ func StartDiscordBot(params BotParameters) (func(), error) {
 // Sets up the jobs to relay text to Discord.
 func (self *BotContext) ready() func(s *discordgo.Session, r *discordgo.Ready) {
 	return func(s *discordgo.Session, r *discordgo.Ready) {
+		defer recoverPanic("ready handler")
 		self.readyOnce.Do(func() {
-			go self.startRelayJob(s, &self.subprocess.StdoutLineEvent)
-			go self.startRelayJob(s, &self.subprocess.StderrLineEvent)
+			self.emojis.load(s, self.relayChannelId)
+			go self.registerCommands(s)
+			go self.shard.verify(s, self.relayChannelId)
+			go func() {
+				// Send the backfill before the lines emitted since.
+				superviseJob("backfill", func() { self.sendBackfill(s) })
+				superviseJob("relay to Discord", func() { self.startRelayJob(s, self.outputLines) })
+			}()
+			if self.history != nil {
+				// Messages arriving meanwhile wait for the history.
+				go superviseJob("history backfill", func() { self.relayHistory(s) })
+			}
+			if self.limiters[lib.DirectionDiscordToSubprocess] != nil {
+				go superviseJob("relay to the subprocess", self.startStdinJob)
+			}
+			if self.verifyUsers {
+				go self.verifyUserMap(s)
+			}
+			if len(self.alertUserIds) > 0 {
+				go superviseJob("alert summary", func() { self.startAlertSummaryJob(s) })
+			}
+			if self.updateCheck != nil && self.updateChannelId != "" {
+				go self.announceUpdate(s)
+			}
+			if self.daily != nil {
+				go superviseJob("daily summary", func() { self.startDailySummaryJob(s) })
+			}
+			if self.query != nil {
+				go superviseJob("server query", func() { self.startQueryJob(s) })
+			}
+			if self.journal != nil {
+				go superviseJob("journal pruning", self.startJournalPruneJob)
+			}
+			if self.errorChannelId != "" {
+				go superviseJob("error reports", func() { self.startErrorReportJob(s) })
+			}
+			if self.rules.Load().Workflows != nil {
+				lineCh := self.subprocess.OutputLineEvent.Listen()
+				go superviseJob("moderation workflows", func() { self.observeWorkflows(lineCh) })
+			}
+			if self.control != nil {
+				self.control.attach(self, s)
+			}
+			if self.reloader != nil {
+				self.reloader.attach(self, s)
+			}
+			if self.sink != nil {
+				self.sink.AttachDiscord(func(channelId string, content string) error {
+					// Signed, so that a bridge relaying the channel ignores it.
+					_, err := s.ChannelMessageSend(channelId, content+self.signature)
+					return err
+				})
+			}
+			if self.linkOAuth != nil {
+				if self.linkOAuth.clientId == "" {
+					self.linkOAuth.clientId = s.State.User.ID
+				}
+				go superviseJob("link verification", self.startLinkServer)
+			} else if self.links != nil {
+				lineCh := self.subprocess.OutputLineEvent.Listen()
+				go superviseJob("link verification", func() { self.observeLinkCodes(s, lineCh) })
+			}
+			if self.panel != nil {
+				go runRecovered("control panel", func() { self.maintainPanel(s) })
+			}
+			go superviseJob("approval expiry", func() { self.startApprovalExpiryJob(s) })
+			if self.shadow != nil {
+				go superviseJob("shadow rules report", self.shadow.startReportJob)
+			}
+			if self.onReady != nil {
+				self.onReady()
+			}
 		})
 	}
 }
 
 // Relays the output of a subprocess to a discord channel.
-// It continuously listens to the specified event for data to relay.
+// It continuously reads the specified channel for data to relay. Once the
+// startup window ends, the startup summary is sent, see lib.StartupSummary.
 //
-// If an error occurs when sending a message to Discord, error is simply
-// logged to stdout.
+// Messages that fail to send with a transient error, e.g. a server or network
+// error, are retried according to --send_attempts and --send_retry_delay. If a
+// message still can't be sent, the error is logged, and the message is counted
+// as dropped and written to the --dead_letter_file, see deliverMessage.
 //
 // Parameters:
 //
 //	s:
 //		A pointer to a discordgo session, used to send the message to discord
 //		channel.
-//	event:
-//		Which subprocess event to listen to
-func (self *BotContext) startRelayJob(session *discordgo.Session, event *ext.EventChannel[string]) {
-	lineCh := event.Listen()
-	defer event.Off(lineCh)
-	for line := range lineCh {
-		line = lib.ApplyRules(self.rules.SubprocessToDiscord, nil, line)
-		if line == "" {
-			// No rules matched.
-			continue
+//	lines:
+//		Channel of subprocess lines to relay
+func (self *BotContext) startRelayJob(session *discordgo.Session, lines <-chan OutputLine) {
+	var summaryCh <-chan time.Time
+	if self.summary != nil {
+		timer := time.NewTimer(time.Until(self.summary.Until()))
+		defer timer.Stop()
+		summaryCh = timer.C
+	}
+	var repeatsCh <-chan time.Time
+	if self.repeats != nil {
+		// Notices are also sent when no line follows the window.
+		ticker := time.NewTicker(self.repeats.Window())
+		defer ticker.Stop()
+		repeatsCh = ticker.C
+	}
+	pipeline := self.relayToDiscordPipeline(session)
+	for {
+		select {
+		case output, ok := <-lines:
+			if !ok {
+				return
+			}
+			pipeline.Run(output.Event(lib.DirectionSubprocessToDiscord))
+		case <-summaryCh:
+			summaryCh = nil
+			self.sendSummary(session)
+		case now := <-repeatsCh:
+			self.sendRepeatNotices(session, now)
+		}
+	}
+}
+
+// sendSummary sends the startup summary, if anything was counted and it
+// wasn't sent yet.
+func (self *BotContext) sendSummary(session *discordgo.Session) {
+	self.deliverSummary(session, self.summary.Flush())
+}
+
+// deliverSummary sends a flushed startup summary, waiting while relaying to
+// Discord is paused.
+func (self *BotContext) deliverSummary(session *discordgo.Session, summary string) {
+	if summary == "" || !self.pause.waitSubprocessToDiscord() {
+		return
+	}
+	if _, err := self.deliverMessage(session, summary, "summary"); err != nil {
+		log.Printf("[error] error sending startup summary to discord: %v", err)
+		reportError(lib.MsgErrorKindDiscord, err)
+	}
+}
+
+// openThread opens the incident thread of a rule match from the message sent
+// for it, see lib.ThreadConfig.
+func (self *BotContext) openThread(session *discordgo.Session, message *discordgo.Message, match *lib.RuleMatch, input string) {
+	// Threads are archived after an hour without messages.
+	thread, err := session.MessageThreadStart(message.ChannelID, message.ID, match.ThreadName(input), 60)
+	if err != nil {
+		log.Printf("error opening thread for rule %v: %v", match.RuleId(), err)
+		return
+	}
+	self.threads.Open(thread.ID, match.Rule.Thread, time.Now())
+}
+
+// sendBackfill sends the results of the Backfill rules as one message.
+func (self *BotContext) sendBackfill(session *discordgo.Session) {
+	if len(self.backfill) == 0 {
+		return
+	}
+	lines := make([]string, len(self.backfill))
+	for i, line := range self.backfill {
+		lines[i] = lib.ResolveEmojis(lib.ApplyUserTags(self.userMap.get(), line), self.emojis.get())
+	}
+	header := func(count int) string {
+		return lib.Tr(lib.MsgBackfillHeader, count)
+	}
+	// Leave room for the signature.
+	content := lib.CollapseLines(header, lines, lib.DiscordMessageLimit-utf8.RuneCountInString(self.signature))
+	if _, err := self.sendMessage(session, content); err != nil {
+		log.Printf("[error] error sending backfill to discord: %v", err)
+		reportError(lib.MsgErrorKindDiscord, err)
+	}
+}
+
+// sendRepeatNotices sends the notices of repeated messages that were
+// suppressed, see lib.RepeatFilter.
+func (self *BotContext) sendRepeatNotices(session *discordgo.Session, now time.Time) {
+	for _, notice := range self.repeats.Flush(now) {
+		log.Printf("[warning] repeated messages to Discord suppressed: %v\n", notice)
+		if _, err := self.sendMessage(session, notice); err != nil {
+			log.Printf("[error] error sending message to discord: %v", err)
+		}
+	}
+}
+
+// sendAlert sends the result of a critical rule to the alert users as a
+// direct message.
+func (self *BotContext) sendAlert(session *discordgo.Session, content string) {
+	if len(self.alertUserIds) == 0 {
+		return
+	}
+	send, suppressed := self.alertLimiter.Wait()
+	if !send {
+		return
+	}
+	if suppressed > 0 {
+		content = fmt.Sprintf("%v\n*%v earlier alerts were suppressed*", content, suppressed)
+	}
+	self.sendToAlertUsers(session, content)
+}
+
+// startAlertSummaryJob sends the number of suppressed alerts to the alert
+// users once no alert followed them for an alert interval, so that they
+// aren't held back until the next alert.
+func (self *BotContext) startAlertSummaryJob(session *discordgo.Session) {
+	interval := max(self.alertInterval, time.Second)
+	for {
+		time.Sleep(interval)
+		if suppressed := self.alertLimiter.FlushSuppressed(); suppressed > 0 {
+			self.sendToAlertUsers(session, fmt.Sprintf("*%v alerts were suppressed*", suppressed))
+		}
+	}
+}
+
+// sendToAlertUsers sends an alert to the alert users as a direct message.
+func (self *BotContext) sendToAlertUsers(session *discordgo.Session, content string) {
+	if self.name != "" {
+		content = fmt.Sprintf("**Alert** from %v (<#%v>): %v", self.name, self.relayChannelId, content)
+	} else {
+		content = fmt.Sprintf("**Alert** from <#%v>: %v", self.relayChannelId, content)
+	}
+	for _, userId := range self.alertUserIds {
+		channel, err := session.UserChannelCreate(userId)
+		if err == nil {
+			_, err = session.ChannelMessageSend(channel.ID, content)
 		}
-		// Send the message to the Discord channel
-		_, err := session.ChannelMessageSend(self.relayChannelId, line)
 		if err != nil {
-			log.Printf("error sending message to discord: %v", err)
+			log.Printf("[error] error sending alert to user %v: %v", userId, err)
+			reportError(lib.MsgErrorKindDiscord, err)
 		}
 	}
 }
 
-// getHighestRoleWithColor finds the highest positioned role with a color for the member.
-// It returns the color value (int) or 0 if no colored role is found or an error occurs.
-func getHighestRoleWithColor(s *discordgo.Session, m *discordgo.MessageCreate) int {
-	// Ensure member and guild information is available
-	if m.Member == nil || m.GuildID == "" || len(m.Member.Roles) == 0 {
-		return 0 // Cannot determine role color without member/guild/roles info
+// newAlertLimiter creates the rate limiter of alerts, allowing one alert per
+// interval. Alerts over the limit are dropped and counted.
+func newAlertLimiter(interval time.Duration) *lib.RateLimiter {
+	if interval <= 0 {
+		interval = time.Nanosecond
+	}
+	return lib.NewRateLimiter(lib.RateLimit{
+		Rate:     1 / interval.Seconds(),
+		Overflow: lib.RateLimitOverflowSummarize,
+	})
+}
+
+// statusFooter returns the footer of the bridge's status embeds, e.g. the
+// error reports, naming the bridge, or nil if it has no name.
+func (self *BotContext) statusFooter() *discordgo.MessageEmbedFooter {
+	if self.name == "" {
+		return nil
 	}
+	return &discordgo.MessageEmbedFooter{Text: self.name}
+}
 
-	// Fetch all roles for the guild
-	guildRoles, err := s.GuildRoles(m.GuildID)
+// sendToThread sends a subprocess line to an incident thread.
+func (self *BotContext) sendToThread(session *discordgo.Session, threadId string, line string) {
+	line = lib.ApplyUserTags(self.userMap.get(), lib.StripAnsi(line))
+	if strings.TrimSpace(line) == "" {
+		// Discord rejects empty messages.
+		return
+	}
+	if _, err := session.ChannelMessageSend(threadId, line); err != nil {
+		log.Printf("error sending message to thread: %v", err)
+	}
+}
+
+// isSigned reports whether a message was sent by a bridge with the same
+// signature, e.g. another bridge sharing the relay channel.
+func (self *BotContext) isSigned(content string) bool {
+	signature := strings.TrimSpace(self.signature)
+	return signature != "" && strings.HasSuffix(strings.TrimSpace(content), signature)
+}
+
+// verifyUserMap checks that every user in the user map exists and is a member of
+// the relay channel's guild. Problems are logged, mentions of such users would
+// not work.
+func (self *BotContext) verifyUserMap(s *discordgo.Session) {
+	channel, err := s.Channel(self.relayChannelId)
+	if err != nil {
+		log.Printf("[warning] can't verify users, error fetching relay channel: %v", err)
+		return
+	}
+	problems := 0
+	userMap := self.userMap.get()
+	for _, name := range userMap.Names() {
+		id := userMap[name]
+		_, err := s.GuildMember(channel.GuildID, id)
+		if err == nil {
+			continue
+		}
+		problems++
+		if !isRESTErrorCode(err, discordgo.ErrCodeUnknownMember) {
+			log.Printf("[warning] users: error verifying %q (%v): %v", name, id, err)
+		} else if _, err := s.User(id); isRESTErrorCode(err, discordgo.ErrCodeUnknownUser) {
+			log.Printf("[warning] users: %q is mapped to unknown Discord user %v", name, id)
+		} else {
+			log.Printf("[warning] users: %q is mapped to Discord user %v, who is not a member of the guild", name, id)
+		}
+	}
+	log.Printf("[info] Verified %v users, %v problems found", len(userMap), problems)
+}
+
+// isRESTErrorCode reports whether err is a Discord API error with the given
+// JSON error code.
+func isRESTErrorCode(err error, code int) bool {
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == code
+}
+
+// cachedGuildRoles returns the roles of a guild from the session state, which
+// discordgo keeps up to date with the GuildRoleCreate, GuildRoleUpdate and
+// GuildRoleDelete events. Only if the guild or one of a member's roles is
+// missing from the state, e.g. before the guild's GuildCreate event, the roles
+// are fetched from Discord and added to the state.
+//
+// Parameters:
+//
+//	memberRoles: IDs of the roles that must be known, e.g. a member's roles
+func cachedGuildRoles(s *discordgo.Session, guildId string, memberRoles []string) ([]*discordgo.Role, error) {
+	if guild, err := s.State.Guild(guildId); err == nil {
+		s.State.RLock()
+		roles := slices.Clone(guild.Roles)
+		s.State.RUnlock()
+		if hasRoles(roles, memberRoles) {
+			return roles, nil
+		}
+	}
+	roles, err := s.GuildRoles(guildId)
 	if err != nil {
-		log.Printf("error fetching guild roles for guild %s: %v", m.GuildID, err)
-		return 0 // Error fetching roles, cannot determine color
+		return nil, err
 	}
+	for _, role := range roles {
+		// Fails if the guild isn't in the state yet.
+		_ = s.State.RoleAdd(guildId, role)
+	}
+	return roles, nil
+}
+
+// hasRoles reports whether all roles of roleIds are among roles.
+func hasRoles(roles []*discordgo.Role, roleIds []string) bool {
+	for _, id := range roleIds {
+		if !slices.ContainsFunc(roles, func(role *discordgo.Role) bool { return role.ID == id }) {
+			return false
+		}
+	}
+	return true
+}
 
+// getHighestRoleWithColor returns the color of the highest positioned role with
+// a color among a member's roles, or 0.
+//
+// Parameters:
+//
+//	guildRoles: all roles of the guild
+//	memberRoles: IDs of the member's roles
+func getHighestRoleWithColor(guildRoles []*discordgo.Role, memberRoles []string) int {
 	// Create a map for quick lookup of role details by ID
 	roleMap := make(map[string]*discordgo.Role, len(guildRoles))
 	for _, role := range guildRoles {
@@ -119,7 +576,7 @@ func getHighestRoleWithColor(s *discordgo.Session, m *discordgo.MessageCreate) i
 
 	// Filter member's roles to find those with colors
 	coloredRoles := make([]*discordgo.Role, 0)
-	for _, roleID := range m.Member.Roles {
+	for _, roleID := range memberRoles {
 		if role, ok := roleMap[roleID]; ok && role.Color != 0 {
 			coloredRoles = append(coloredRoles, role)
 		}
@@ -139,12 +596,46 @@ func getHighestRoleWithColor(s *discordgo.Session, m *discordgo.MessageCreate) i
 	return coloredRoles[0].Color
 }
 
+// playerProps returns the Props of an in-game player for rules with a Player
+// template: those of the guild member the player is mapped to in the user map.
+// If the player isn't mapped or the member can't be looked up, only the
+// Username is set, to the player's name.
+func (self *BotContext) playerProps(player string) lib.Props {
+	props := lib.Props{Author: lib.Author{Username: player}}
+	userId, ok := self.userMap.get().Lookup(player)
+	if !ok {
+		return props
+	}
+	info := self.members.Resolve(userId)
+	if info == nil {
+		return props
+	}
+	member := info.Member
+	props.Author = lib.Author{
+		Id:            member.User.ID,
+		Username:      member.User.Username,
+		Nickname:      member.Nick,
+		GlobalName:    member.User.GlobalName,
+		Discriminator: member.User.Discriminator,
+		AccentColor:   info.RoleColor,
+	}
+	if props.Author.AccentColor == 0 {
+		props.Author.AccentColor = member.User.AccentColor
+	}
+	return props
+}
+
 // getAccentColor determines the accent color based on the user's highest role or default accent color.
 func getAccentColor(s *discordgo.Session, m *discordgo.MessageCreate) int {
-	// Try to get the color from the highest role
-	roleColor := getHighestRoleWithColor(s, m)
-	if roleColor != 0 {
-		return roleColor
+	// Try to get the color from the highest role, which needs the member and
+	// the roles of the guild
+	if m.Member != nil && m.GuildID != "" && len(m.Member.Roles) > 0 {
+		guildRoles, err := cachedGuildRoles(s, m.GuildID, m.Member.Roles)
+		if err != nil {
+			log.Printf("error fetching guild roles for guild %s: %v", m.GuildID, err)
+		} else if roleColor := getHighestRoleWithColor(guildRoles, m.Member.Roles); roleColor != 0 {
+			return roleColor
+		}
 	}
 
 	// Fallback to the user's profile accent color if available
@@ -156,35 +647,204 @@ func getAccentColor(s *discordgo.Session, m *discordgo.MessageCreate) int {
 	return 0 // Or some other default color value if desired
 }
 
+// contentNames looks up the names of users, roles and channels mentioned in a
+// message in the session state, for lib.NormalizeContent.
+func contentNames(s *discordgo.Session, m *discordgo.MessageCreate) lib.ContentNames {
+	return lib.ContentNames{
+		User: func(id string) string {
+			if member, err := s.State.Member(m.GuildID, id); err == nil && member.Nick != "" {
+				return member.Nick
+			}
+			for _, user := range m.Mentions {
+				if user.ID == id {
+					if user.GlobalName != "" {
+						return user.GlobalName
+					}
+					return user.Username
+				}
+			}
+			return ""
+		},
+		Role: func(id string) string {
+			if role, err := s.State.Role(m.GuildID, id); err == nil {
+				return role.Name
+			}
+			return ""
+		},
+		Channel: func(id string) string {
+			if channel, err := s.State.Channel(id); err == nil {
+				return channel.Name
+			}
+			return ""
+		},
+	}
+}
+
+// discordPoll converts a Discord poll to the poll of lib.Props.
+func discordPoll(poll *discordgo.Poll) *lib.Poll {
+	result := &lib.Poll{Question: poll.Question.Text}
+	for _, answer := range poll.Answers {
+		if answer.Media == nil {
+			continue
+		}
+		text := answer.Media.Text
+		if emoji := answer.Media.Emoji; emoji != nil && emoji.ID == "" && emoji.Name != "" {
+			// Unicode emoji; custom emoji can't be shown in-game.
+			text = strings.TrimSpace(emoji.Name + " " + text)
+		}
+		result.Answers = append(result.Answers, text)
+	}
+	return result
+}
+
 func (self *BotContext) messageCreate() func(s *discordgo.Session, m *discordgo.MessageCreate) {
 	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		if m.Author.ID == s.State.User.ID {
-			// Is bot's own message
-			return
-		}
+		defer recoverPanic("message handler")
 		if !(m.ChannelID == self.relayChannelId) {
 			// Is not relay channel
 			return
 		}
-		msg := m.Content
-		props := &lib.Props{
-			Author: lib.Author{
-				Username:      m.Author.Username,
-				Nickname:      m.Member.Nick,
-				GlobalName:    m.Author.GlobalName,
-				Discriminator: m.Author.Discriminator,
-				AccentColor:   getAccentColor(s, m),
-			},
-		}
-
-		// Apply conversion rules
-		msg = lib.ApplyRules(self.rules.DiscordToSubprocess, props, msg)
-		if msg == "" {
-			// No rules matched or message was filtered out.
+		if self.history != nil && !self.history.admit(m.ID) {
+			// Was relayed from the channel's history already
 			return
 		}
+		self.relayMessage(s, m)
+	}
+}
+
+// relayMessage relays a message of the relay channel to the subprocess, unless
+// it is ignored, e.g. because it was sent by the bot.
+func (self *BotContext) relayMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author == nil || isSystemMessage(m.Message) {
+		// Joins, pins, boosts etc. have no author text to relay
+		return
+	}
+	if m.Author.ID == s.State.User.ID {
+		// Is bot's own message
+		return
+	}
+	if self.isSigned(m.Content) {
+		// Was sent by another bridge, relaying it could cause a loop
+		return
+	}
+	if self.mutes != nil && self.mutes.IsMuted(m.Author.ID, time.Now()) {
+		// Was muted with /bridgemute
+		return
+	}
+	if maintenance, reply := self.maintenance.reply(m.Author.ID, time.Now()); maintenance {
+		if reply != "" {
+			if _, err := s.ChannelMessageSendReply(m.ChannelID, reply+self.signature, m.Reference()); err != nil {
+				log.Printf("[error] error replying to message: %v", err)
+			}
+		}
+		return
+	}
+	msg := lib.NormalizeContent(m.Content, contentNames(s, m))
+	props := discordProps(s, m)
+	if props.Poll != nil && msg == "" {
+		// Polls have no content, so rules match the question.
+		msg = props.Poll.Question
+	}
+
+	event := lib.NewEvent(lib.EventSourceDiscord, lib.DirectionDiscordToSubprocess, msg, time.Now())
+	event.Props = props
+	self.toSubprocess.Run(event)
+}
+
+// discordProps returns the Props of a Discord message. The author of a webhook
+// message is the webhook, which has no member; its name and avatar are chosen
+// by whoever sent the message.
+func discordProps(s *discordgo.Session, m *discordgo.MessageCreate) *lib.Props {
+	props := &lib.Props{
+		Author: lib.Author{
+			Id:            m.Author.ID,
+			Username:      m.Author.Username,
+			GlobalName:    m.Author.GlobalName,
+			Discriminator: m.Author.Discriminator,
+			AccentColor:   getAccentColor(s, m),
+			IsBot:         m.Author.Bot,
+			IsWebhook:     m.WebhookID != "",
+		},
+		MessageType: lib.MessageTypeMessage,
+		RawContent:  m.Content,
+	}
+	if m.Member != nil {
+		props.Author.Nickname = m.Member.Nick
+		props.Author.Roles = memberRoles(s, m.GuildID, m.Member.Roles)
+	}
+	if props.Author.IsWebhook {
+		// The webhook identifies the integration, whatever name it posts as.
+		props.Author.Id = m.WebhookID
+	}
+	if m.Flags&discordgo.MessageFlagsIsCrossPosted != 0 {
+		props.MessageType = lib.MessageTypeAnnouncement
+	}
+	if m.Poll != nil {
+		props.MessageType = lib.MessageTypePoll
+		props.Poll = discordPoll(m.Poll)
+	}
+	return props
+}
+
+// memberRoles looks up the names of a member's roles in the session state, for
+// the Roles condition of rules. Roles missing from the state only have an ID.
+func memberRoles(s *discordgo.Session, guildId string, roleIds []string) []lib.Role {
+	roles := make([]lib.Role, len(roleIds))
+	for i, id := range roleIds {
+		roles[i].Id = id
+		if role, err := s.State.Role(guildId, id); err == nil {
+			roles[i].Name = role.Name
+		}
+	}
+	return roles
+}
+
+// isSystemMessage reports whether a message was generated by Discord, such as
+// a member join or a pinned message notice, rather than sent by its author.
+func isSystemMessage(m *discordgo.Message) bool {
+	switch m.Type {
+	case discordgo.MessageTypeDefault, discordgo.MessageTypeReply,
+		discordgo.MessageTypeChatInputCommand, discordgo.MessageTypeContextMenuCommand:
+		return false
+	default:
+		return true
+	}
+}
+
+// startStdinJob relays rate limited Discord messages to the subprocess' stdin.
+func (self *BotContext) startStdinJob() {
+	limiter := self.limiters[lib.DirectionDiscordToSubprocess]
+	for msg := range self.stdinQueue.Chan() {
+		relay, suppressed := limiter.Wait()
+		if !relay {
+			continue
+		}
+		if suppressed > 0 {
+			log.Printf("[warning] rate limit: %v messages to the subprocess suppressed\n", suppressed)
+			if summary := limiter.Summary(suppressed, ""); summary != "" {
+				self.subprocess.WriteLine(stdinLine{Instance: msg.Instance, Text: summary})
+			}
+		}
+		self.subprocess.WriteLine(msg)
+	}
+}
+
+// newRateLimiters creates a rate limiter for each direction with a rate limit.
+func newRateLimiters(limits map[string]lib.RateLimit) map[string]*lib.RateLimiter {
+	limiters := make(map[string]*lib.RateLimiter, len(limits))
+	for direction, limit := range limits {
+		limiters[direction] = lib.NewRateLimiter(limit)
+	}
+	return limiters
+}
 
-		// Relay the processed message to the subprocess stdin
-		self.subprocess.WriteStdinLineEvent.Broadcast(msg + "\n")
+// publish publishes a matched event to the configured sinks, and counts the
+// match in the rule stats.
+func (self *BotContext) publish(event *lib.Event) {
+	self.stats.Record(event.Direction, event.Match, event.Matched)
+	if self.sink != nil {
+		published := sink.NewEvent(event)
+		published.Bridge = self.name
+		self.sink.Publish(published)
 	}
 }
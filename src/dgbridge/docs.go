@@ -0,0 +1,62 @@
+package main
+
+// This file implements the "dgbridge docs-rules" subcommand, which renders a
+// rules file into a table of its rules for moderators who need to understand
+// what gets relayed.
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/alexflint/go-arg"
+)
+
+type DocsArgs struct {
+	RulesFile string `arg:"required,-r,--rules" help:"Path to the file with translation rules"`
+	Format    string `arg:"--format" default:"markdown" help:"Format of the documentation: markdown or html"`
+	Output    string `arg:"-o,--output" help:"File to write the documentation to, defaults to stdout"`
+	Title     string `arg:"--title" help:"Title of the documentation, defaults to the name of the rules file"`
+}
+
+// runDocsCommand runs "dgbridge docs-rules".
+//
+// Parameters:
+//
+//	argv: command line arguments following "docs-rules"
+func runDocsCommand(argv []string) {
+	var args DocsArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge docs-rules",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+	if args.Format != lib.RuleDocsFormatMarkdown && args.Format != lib.RuleDocsFormatHtml {
+		parser.Fail("--format must be markdown or html")
+	}
+
+	rules, err := lib.LoadRules(args.RulesFile)
+	if err != nil {
+		log.Fatalln("[fatal] error loading rules:", err)
+	}
+	title := args.Title
+	if title == "" {
+		title = "Rules of " + filepath.Base(args.RulesFile)
+	}
+	docs, err := lib.FormatRuleDocs(rules, args.Format, title)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	if args.Output == "" {
+		_, _ = os.Stdout.Write(docs)
+		return
+	}
+	if err := os.WriteFile(args.Output, docs, 0o644); err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// emojiCache holds the custom emoji of the relay channel's guild, so that emoji
+// shortcodes in subprocess output can be resolved without an API call per line.
+type emojiCache struct {
+	mutex   sync.RWMutex
+	guildId string
+	emojis  map[string]string // Emoji name to message format
+}
+
+// load fetches the custom emoji of the relay channel's guild.
+func (self *emojiCache) load(s *discordgo.Session, channelId string) {
+	channel, err := s.Channel(channelId)
+	if err != nil {
+		log.Printf("error fetching relay channel, custom emoji won't be resolved: %v", err)
+		return
+	}
+	emojis, err := s.GuildEmojis(channel.GuildID)
+	if err != nil {
+		log.Printf("error fetching guild emoji, custom emoji won't be resolved: %v", err)
+		return
+	}
+	self.mutex.Lock()
+	self.guildId = channel.GuildID
+	self.mutex.Unlock()
+	self.set(emojis)
+}
+
+// set replaces the cached emoji.
+func (self *emojiCache) set(emojis []*discordgo.Emoji) {
+	formatted := make(map[string]string, len(emojis))
+	for _, emoji := range emojis {
+		// Emoji may be unavailable, e.g. when the guild lost a boost level.
+		if emoji.Available {
+			formatted[emoji.Name] = emoji.MessageFormat()
+		}
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.emojis = formatted
+}
+
+// get returns the cached emoji. The map must not be modified.
+func (self *emojiCache) get() map[string]string {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.emojis
+}
+
+// Handles a discordgo.GuildEmojisUpdate event.
+// Keeps the cache up to date when emoji are added, renamed or removed.
+func (self *emojiCache) guildEmojisUpdate() func(s *discordgo.Session, e *discordgo.GuildEmojisUpdate) {
+	return func(s *discordgo.Session, e *discordgo.GuildEmojisUpdate) {
+		defer recoverPanic("emoji handler")
+		self.mutex.RLock()
+		guildId := self.guildId
+		self.mutex.RUnlock()
+		if e.GuildID == guildId {
+			self.set(e.Emojis)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+// This file implements the error reports of the bridge, see
+// --error_channel_id: errors are counted, and posted to an admin channel as a
+// summary once per --error_report_interval.
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// errorReportColor is the color of the error report embed.
+const errorReportColor = 0xed4245
+
+// bridgeErrors counts the errors of the bridge until they are reported, see
+// reportError.
+var bridgeErrors lib.ErrorTally
+
+// reportError counts an error for the next error report, and reports it to the
+// error tracker. The caller logs the error as well.
+//
+// Parameters:
+//
+//	kind: message key of what failed, e.g. lib.MsgErrorKindDiscord
+func reportError(kind string, err error) {
+	reportRuleError(kind, "", err)
+}
+
+// reportRuleError is like reportError, for errors relaying the result of a
+// rule.
+//
+// Parameters:
+//
+//	rule: ID of the rule, see lib.RuleId
+func reportRuleError(kind string, rule string, err error) {
+	now := time.Now()
+	bridgeErrors.Record(kind, err, now)
+	tracker.capture(lib.ErrorEvent{
+		Time:    now,
+		Level:   lib.ErrorLevelError,
+		Kind:    kind,
+		Message: err.Error(),
+		Rule:    rule,
+	})
+}
+
+// startErrorReportJob posts the errors counted in each interval to the error
+// channel. Intervals without errors aren't reported.
+func (self *BotContext) startErrorReportJob(s *discordgo.Session) {
+	// Errors from before the session was ready are reported with the first
+	// interval.
+	ticker := time.NewTicker(self.errorInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		counts := bridgeErrors.Take()
+		if len(counts) == 0 {
+			continue
+		}
+		total := 0
+		for _, count := range counts {
+			total += count.Count
+		}
+		embed := &discordgo.MessageEmbed{
+			Title:       lib.Tr(lib.MsgErrorReportTitle, total),
+			Description: lib.FormatErrorReport(counts, time.Local),
+			Color:       errorReportColor,
+			Footer:      self.statusFooter(),
+			Timestamp:   time.Now().Format(time.RFC3339),
+		}
+		// Failures to post the report aren't counted, so that a channel the bot
+		// can't post to doesn't keep the report going.
+		if _, err := s.ChannelMessageSendEmbed(self.errorChannelId, embed); err != nil {
+			log.Printf("[error] error posting error report: %v", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+// This file implements creating Discord scheduled events for rules with an
+// Event, see lib.EventConfig.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// scheduleEvent creates the scheduled event of a match in the relay channel's
+// guild, or updates the scheduled event of the bridge with the same name.
+// Errors are logged.
+//
+// Parameters:
+//
+//	input: the line the rule matched
+//	now: the time the line was read
+func (self *BotContext) scheduleEvent(session *discordgo.Session, match *lib.RuleMatch, input string, now time.Time) {
+	event, err := match.ScheduledEvent(input, now)
+	if err != nil {
+		log.Printf("[error] error building scheduled event of rule %v: %v", match.RuleId(), err)
+		return
+	}
+	if err := self.saveScheduledEvent(session, event); err != nil {
+		log.Printf("[error] error scheduling event %q of rule %v: %v", event.Name, match.RuleId(), err)
+		return
+	}
+	log.Printf("[info] Scheduled event %q at %v\n", event.Name, event.Start.Format(time.RFC3339))
+}
+
+// saveScheduledEvent creates or updates a scheduled event, see scheduleEvent.
+func (self *BotContext) saveScheduledEvent(session *discordgo.Session, event lib.ScheduledEvent) error {
+	channel, err := session.State.Channel(self.relayChannelId)
+	if err != nil {
+		if channel, err = session.Channel(self.relayChannelId); err != nil {
+			return fmt.Errorf("error fetching relay channel: %v", err)
+		}
+	}
+	params := &discordgo.GuildScheduledEventParams{
+		Name:               event.Name,
+		Description:        event.Description,
+		ScheduledStartTime: &event.Start,
+		ScheduledEndTime:   &event.End,
+		PrivacyLevel:       discordgo.GuildScheduledEventPrivacyLevelGuildOnly,
+		EntityType:         discordgo.GuildScheduledEventEntityTypeExternal,
+		EntityMetadata:     &discordgo.GuildScheduledEventEntityMetadata{Location: event.Location},
+	}
+	events, err := session.GuildScheduledEvents(channel.GuildID, false)
+	if err != nil {
+		return fmt.Errorf("error fetching scheduled events: %v", err)
+	}
+	for _, existing := range events {
+		if existing.CreatorID == session.State.User.ID && existing.Name == event.Name &&
+			existing.Status == discordgo.GuildScheduledEventStatusScheduled {
+			_, err = session.GuildScheduledEventEdit(channel.GuildID, existing.ID, params)
+			return err
+		}
+	}
+	_, err = session.GuildScheduledEventCreate(channel.GuildID, params)
+	return err
+}
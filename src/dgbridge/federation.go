@@ -0,0 +1,194 @@
+package main
+
+// This file implements federation: linking two or more bridges over WebSocket
+// connections, so that chat can be relayed between game servers directly.
+//
+// Subprocess lines matching the SubprocessToPeer rules are sent to all peers.
+// Messages received from peers go through the PeerToSubprocess rules and are
+// written to the subprocess' stdin.
+
+import (
+	"crypto/subtle"
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// FederationParameters holds data to be passed to StartFederation.
+type FederationParameters struct {
+	ListenAddr  string            // Address to accept peer connections on, may be empty
+	PeerUrls    []string          // WebSocket URLs of peers to connect to
+	Secret      string            // Shared secret that peers authenticate with
+	Rules       *lib.Rules        // SubprocessToPeer and PeerToSubprocess rules
+	Subprocess  *SubprocessGroup  // Receives messages from peers
+	OutputLines <-chan OutputLine // Subprocess stdout and stderr lines to send, see bufferLines
+}
+
+// federationMessage is the JSON message exchanged between peers.
+type federationMessage struct {
+	Text string `json:"text"`
+}
+
+// federationPeer is a connection to another bridge.
+type federationPeer struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex // Only one goroutine may write to conn at a time
+}
+
+// Federation relays messages between the subprocess and federated bridges.
+type Federation struct {
+	params FederationParameters
+	mutex  sync.Mutex
+	peers  map[*federationPeer]struct{}
+}
+
+// federationRetryInterval is the time between attempts to connect to a peer.
+const federationRetryInterval = 10 * time.Second
+
+// StartFederation starts accepting connections from peers and connecting to
+// peers. This function is non-blocking.
+func StartFederation(params FederationParameters) (*Federation, error) {
+	if params.Secret == "" {
+		return nil, fmt.Errorf("federation requires a shared secret")
+	}
+	self := &Federation{
+		params: params,
+		peers:  make(map[*federationPeer]struct{}),
+	}
+	if params.ListenAddr != "" {
+		server := &http.Server{
+			Addr:    params.ListenAddr,
+			Handler: http.HandlerFunc(self.acceptPeer),
+		}
+		go func() {
+			log.Printf("[info] Accepting federation peers on %v\n", params.ListenAddr)
+			if err := server.ListenAndServe(); err != nil {
+				log.Printf("[error] federation server stopped: %v", err)
+			}
+		}()
+	}
+	for _, peerUrl := range params.PeerUrls {
+		go superviseJob("federation peer "+peerUrl, func() { self.connectPeer(peerUrl) })
+	}
+	go superviseJob("relay to federation peers", func() { self.startSendJob(params.OutputLines) })
+	return self, nil
+}
+
+// acceptPeer handles an incoming peer connection.
+func (self *Federation) acceptPeer(w http.ResponseWriter, r *http.Request) {
+	expected := []byte("Bearer " + self.params.Secret)
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[error] accepting federation peer: %v", err)
+		return
+	}
+	log.Printf("[info] Federation peer %v connected\n", r.RemoteAddr)
+	self.handlePeer(&federationPeer{conn: conn})
+	log.Printf("[info] Federation peer %v disconnected\n", r.RemoteAddr)
+}
+
+// connectPeer connects to a peer, and reconnects whenever the connection is
+// lost.
+func (self *Federation) connectPeer(peerUrl string) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+self.params.Secret)
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(peerUrl, header)
+		if err != nil {
+			log.Printf("[error] connecting to federation peer %v: %v", peerUrl, err)
+		} else {
+			log.Printf("[info] Connected to federation peer %v\n", peerUrl)
+			self.handlePeer(&federationPeer{conn: conn})
+			log.Printf("[info] Federation peer %v disconnected\n", peerUrl)
+		}
+		time.Sleep(federationRetryInterval)
+	}
+}
+
+// handlePeer relays messages received from a peer until the connection is
+// closed.
+func (self *Federation) handlePeer(peer *federationPeer) {
+	self.mutex.Lock()
+	self.peers[peer] = struct{}{}
+	self.mutex.Unlock()
+	defer func() {
+		self.mutex.Lock()
+		delete(self.peers, peer)
+		self.mutex.Unlock()
+		_ = peer.conn.Close()
+	}()
+
+	pipeline := lib.NewPipeline(
+		lib.MatchStage(self.rules, nil),
+		lib.RequireMatch(),
+		lib.Tap("delivery", func(event *lib.Event) {
+			self.params.Subprocess.WriteLine(eventStdinLine(event))
+		}),
+	)
+	for {
+		var message federationMessage
+		if err := peer.conn.ReadJSON(&message); err != nil {
+			return
+		}
+		pipeline.Run(lib.NewEvent(lib.EventSourcePeer, lib.DirectionPeerToSubprocess, message.Text, time.Now()))
+	}
+}
+
+// startSendJob sends subprocess lines matching the SubprocessToPeer rules to
+// all peers.
+func (self *Federation) startSendJob(lines <-chan OutputLine) {
+	pipeline := lib.NewPipeline(
+		lib.MatchStage(self.rules, nil),
+		lib.RequireMatch(),
+		lib.Tap("delivery", func(event *lib.Event) {
+			self.broadcast(federationMessage{Text: event.Transformed})
+		}),
+	)
+	for line := range lines {
+		pipeline.Run(line.Event(lib.DirectionSubprocessToPeer))
+	}
+}
+
+// rules returns the SubprocessToPeer and PeerToSubprocess rules, for
+// lib.MatchStage.
+func (self *Federation) rules() *lib.Rules {
+	return self.params.Rules
+}
+
+// broadcast sends a message to all connected peers.
+func (self *Federation) broadcast(message federationMessage) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("[error] encoding federation message: %v", err)
+		return
+	}
+	self.mutex.Lock()
+	peers := make([]*federationPeer, 0, len(self.peers))
+	for peer := range self.peers {
+		peers = append(peers, peer)
+	}
+	self.mutex.Unlock()
+
+	for _, peer := range peers {
+		peer.writeMutex.Lock()
+		_ = peer.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		err := peer.conn.WriteMessage(websocket.TextMessage, payload)
+		peer.writeMutex.Unlock()
+		if err != nil {
+			log.Printf("[error] sending federation message: %v", err)
+			// The read loop notices the broken connection and removes the peer.
+			_ = peer.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"dgbridge/src/testsupport"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const federationRules = `{
+	"SubprocessToDiscord": [],
+	"DiscordToSubprocess": [],
+	"SubprocessToPeer": [
+		{"Match": "^<(\\w+)> (.*)$", "Template": "${1}: ${2}"}
+	],
+	"PeerToSubprocess": [
+		{"Match": "^(\\w+): (.*)$", "Template": "say [peer] ${1}: ${2}"}
+	]
+}`
+
+func TestFederation(t *testing.T) {
+	rules := testsupport.ParseRules(t, federationRules)
+	_, err := StartFederation(FederationParameters{Rules: rules})
+	assert.Error(t, err, "a secret is required")
+
+	// The accepting bridge.
+	console := newFakeConsole("")
+	accepting := &Federation{
+		params: FederationParameters{
+			Secret:     "secret",
+			Rules:      rules,
+			Subprocess: NewSubprocessGroup([]Console{console}),
+		},
+		peers: make(map[*federationPeer]struct{}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(accepting.acceptPeer))
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	_ = response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// The connecting bridge.
+	lines := make(chan OutputLine, 4)
+	_, err = StartFederation(FederationParameters{
+		PeerUrls:    []string{"ws" + strings.TrimPrefix(server.URL, "http")},
+		Secret:      "secret",
+		Rules:       rules,
+		Subprocess:  NewSubprocessGroup(nil),
+		OutputLines: lines,
+	})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		accepting.mutex.Lock()
+		defer accepting.mutex.Unlock()
+		return len(accepting.peers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	lines <- OutputLine{Text: "Saving the world"}
+	lines <- OutputLine{Text: "<Steve> hello"}
+	assert.Equal(t, "say [peer] Steve: hello", console.nextWritten(t))
+	assert.Empty(t, console.written, "unmatched lines aren't sent")
+}
@@ -0,0 +1,117 @@
+package main
+
+// This file implements /filter, which maintains the filter list of the content
+// filter at runtime, see --filter_file and lib.FilterList.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// filterCommand returns the /filter command, or nil without --filter_file.
+func (self *BotContext) filterCommand() *discordgo.ApplicationCommand {
+	if self.filterList() == nil {
+		return nil
+	}
+	entryOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "entry",
+		Description: "Word, or regular expression with regex set",
+		Required:    true,
+	}
+	return &discordgo.ApplicationCommand{
+		Name:                     "filter",
+		Description:              "Manage the words and patterns masked by the content filter",
+		DefaultMemberPermissions: &adminPermissions,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Mask a word or pattern in relayed messages",
+				Options: []*discordgo.ApplicationCommandOption{
+					entryOption,
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "regex",
+						Description: "The entry is a regular expression instead of a word",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Stop masking a word or pattern",
+				Options: []*discordgo.ApplicationCommandOption{
+					entryOption,
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "regex",
+						Description: "The entry is a regular expression instead of a word",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show the words and patterns added with /filter",
+			},
+		},
+	}
+}
+
+// filterSubcommand handles the /filter subcommands.
+func (self *BotContext) filterSubcommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	list := self.filterList()
+	if list == nil || len(data.Options) == 0 {
+		return
+	}
+	subcommand := data.Options[0]
+	options := subcommandOptions(subcommand)
+	entry := ""
+	if option, ok := options["entry"]; ok {
+		entry = strings.TrimSpace(option.StringValue())
+		if option, ok := options["regex"]; ok && option.BoolValue() {
+			entry = lib.FilterPatternPrefix + entry
+		}
+	}
+	switch subcommand.Name {
+	case "add":
+		if err := list.Add(entry); err != nil {
+			self.respond(s, i, fmt.Sprintf("Can't add %q: %v", entry, err))
+			return
+		}
+		log.Printf("[info] %v added %q to the content filter\n", i.Member.User.Username, entry)
+		self.respond(s, i, fmt.Sprintf("Added %q to the content filter.", entry))
+	case "remove":
+		removed, err := list.Remove(entry)
+		if err != nil {
+			self.respond(s, i, fmt.Sprintf("Can't remove %q: %v", entry, err))
+			return
+		}
+		if !removed {
+			self.respond(s, i, fmt.Sprintf("%q isn't in the list of /filter.", entry))
+			return
+		}
+		log.Printf("[info] %v removed %q from the content filter\n", i.Member.User.Username, entry)
+		self.respond(s, i, fmt.Sprintf("Removed %q from the content filter.", entry))
+	case "list":
+		entries := list.Entries()
+		if len(entries) == 0 {
+			self.respond(s, i, "No words or patterns were added with /filter.")
+			return
+		}
+		self.respond(s, i, lib.FormatCodeBlock(entries, lib.DiscordMessageLimit))
+	}
+}
+
+// filterList returns the filter list of --filter_file, or nil without it.
+func (self *BotContext) filterList() *lib.FilterList {
+	if filter := self.rules.Load().Filter; filter != nil {
+		return filter.List
+	}
+	return nil
+}
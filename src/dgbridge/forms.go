@@ -0,0 +1,104 @@
+package main
+
+// This file implements the forms of the rules file, see lib.Form: a slash
+// command or a control panel button opens a form's modal, and its submission
+// is written to the subprocess as a console command.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// formCommands returns the slash commands that open the forms.
+func (self *BotContext) formCommands() []*discordgo.ApplicationCommand {
+	var commands []*discordgo.ApplicationCommand
+	for _, form := range self.rules.Load().Forms {
+		command := &discordgo.ApplicationCommand{Name: form.Name, Description: form.Description}
+		if command.Description == "" {
+			command.Description = form.Title
+		}
+		if form.Users == nil {
+			// Members matching Users need to see the command.
+			command.DefaultMemberPermissions = &adminPermissions
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// openForm responds to an interaction with the modal of a form.
+func (self *BotContext) openForm(s *discordgo.Session, i *discordgo.InteractionCreate, form *lib.Form) {
+	if i.Member == nil {
+		return
+	}
+	if !form.MayUse(interactionAuthor(s, i), i.Member.Permissions&adminPermissions != 0) {
+		self.respond(s, i, "You may not use this form.")
+		return
+	}
+	rows := make([]discordgo.MessageComponent, len(form.Fields))
+	for index, field := range form.Fields {
+		style := discordgo.TextInputShort
+		if field.Paragraph {
+			style = discordgo.TextInputParagraph
+		}
+		rows[index] = discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.TextInput{
+				CustomID:    field.Name,
+				Label:       field.Label,
+				Style:       style,
+				Placeholder: field.Placeholder,
+				Required:    field.Required,
+				MaxLength:   field.MaxLength,
+			},
+		}}
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   form.ModalId(),
+			Title:      form.Title,
+			Components: rows,
+		},
+	})
+	if err != nil {
+		log.Printf("[error] error opening form %v: %v", form.Name, err)
+	}
+}
+
+// formSubmit handles the submission of a form's modal, writing its command to
+// the subprocess.
+func (self *BotContext) formSubmit(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ModalSubmitData()
+	form := self.rules.Load().Form(strings.TrimPrefix(data.CustomID, lib.FormModalPrefix))
+	if form == nil || i.Member == nil {
+		return
+	}
+	if !form.MayUse(interactionAuthor(s, i), i.Member.Permissions&adminPermissions != 0) {
+		self.respond(s, i, "You may not use this form.")
+		return
+	}
+	values := make(map[string]string)
+	for _, component := range data.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, component := range row.Components {
+			if input, ok := component.(*discordgo.TextInput); ok {
+				values[input.CustomID] = input.Value
+			}
+		}
+	}
+	command, err := form.FormatCommand(values)
+	if err != nil {
+		self.respond(s, i, fmt.Sprintf("Can't send the command: %v", err))
+		return
+	}
+	log.Printf("[info] %v submitted form %v, sending %q\n", i.Member.User.Username, form.Name, command)
+	self.subprocess.WriteLine(stdinLine{Text: command})
+	self.respond(s, i, fmt.Sprintf("Sent `%v` to the server.", command))
+}
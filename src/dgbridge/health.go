@@ -0,0 +1,70 @@
+package main
+
+// This file implements the health probes of --health_listen, e.g. for the
+// liveness and readiness probes of a Kubernetes pod:
+//
+//	/healthz: 200 while dgbridge runs
+//	/readyz:  200 once the console was started and the Discord session is
+//	          ready, 503 before
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// HealthServer serves the health probes. It is safe for concurrent use.
+type HealthServer struct {
+	readiness lib.Readiness
+}
+
+// StartHealthServer starts serving the health probes. This function is
+// non-blocking.
+//
+// Parameters:
+//
+//	addr: address to listen on, e.g. :8086
+func StartHealthServer(addr string) (*HealthServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	self := &HealthServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", self.readyz)
+	go func() {
+		log.Printf("[info] Serving the health probes on %v\n", listener.Addr())
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("[error] health probes stopped: %v", err)
+		}
+	}()
+	return self, nil
+}
+
+// SetStarted marks the console as started. Safe to call on a nil server.
+func (self *HealthServer) SetStarted() {
+	if self != nil {
+		self.readiness.SetStarted()
+	}
+}
+
+// SetReady marks the Discord session as ready. Safe to call on a nil server.
+func (self *HealthServer) SetReady() {
+	if self != nil {
+		self.readiness.SetReady()
+	}
+}
+
+// readyz reports whether the bridge relays messages.
+func (self *HealthServer) readyz(w http.ResponseWriter, _ *http.Request) {
+	if problem := self.readiness.NotReady(); problem != "" {
+		http.Error(w, problem, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
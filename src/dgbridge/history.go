@@ -0,0 +1,144 @@
+package main
+
+// This file implements the backfill of Discord messages: on startup, the last
+// messages of the relay channel are fetched, and those sent while the bridge
+// was down are relayed to the subprocess, see --discord_backfill. The last
+// message relayed is tracked in a file, see lib.MessageCursor.
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// channelMessagesLimit is the maximum number of messages Discord returns per
+// request of a channel's history.
+const channelMessagesLimit = 100
+
+// messageCursorSaveInterval is the time between saves of the message cursor.
+// Messages processed since the last save are relayed again from the history
+// if the bridge crashes.
+const messageCursorSaveInterval = 10 * time.Second
+
+// channelHistory tracks the messages of the relay channel relayed to the
+// subprocess, and relays those sent while the bridge was down on startup.
+type channelHistory struct {
+	cursor *lib.MessageCursor
+	limit  int // Number of the channel's last messages fetched on startup
+	// ID of the newest message relayed from the history, empty if none was;
+	// set before done is closed
+	relayed  string
+	done     chan struct{} // Closed once the history was relayed
+	doneOnce sync.Once
+}
+
+func newChannelHistory(cursor *lib.MessageCursor, limit int) *channelHistory {
+	return &channelHistory{cursor: cursor, limit: limit, done: make(chan struct{})}
+}
+
+// admit waits for the history to be relayed, then records a message received
+// from the gateway. Messages from the gateway may be handled out of order, so
+// only those the history relayed are skipped.
+//
+// Returns:
+//
+//	false if the message was relayed from the history already
+func (self *channelHistory) admit(messageId string) bool {
+	<-self.done
+	if !lib.SnowflakeNewer(messageId, self.relayed) {
+		return false
+	}
+	self.cursor.Advance(messageId)
+	return true
+}
+
+func (self *channelHistory) finish() {
+	self.doneOnce.Do(func() { close(self.done) })
+}
+
+// save saves the message cursor, logging errors.
+func (self *channelHistory) save() {
+	if err := self.cursor.Save(); err != nil {
+		log.Println("[error]", err)
+	}
+}
+
+// startSaveJob saves the message cursor periodically.
+func (self *channelHistory) startSaveJob() {
+	ticker := time.NewTicker(messageCursorSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		self.save()
+	}
+}
+
+// relayHistory fetches the last messages of the relay channel, and relays
+// those newer than the last message processed before, oldest first. If no
+// message was processed before, e.g. on the first start, none is relayed.
+func (self *BotContext) relayHistory(session *discordgo.Session) {
+	history := self.history
+	defer history.finish()
+	last := history.cursor.Last()
+	messages, err := fetchLastMessages(session, self.relayChannelId, history.limit, last)
+	if err != nil {
+		log.Printf("[warning] can't backfill messages, error fetching relay channel history: %v", err)
+		return
+	}
+	if last == "" {
+		if len(messages) > 0 {
+			history.cursor.Advance(messages[0].ID)
+		}
+		log.Println("[info] No message was relayed before, messages are backfilled from the next start")
+		return
+	}
+	guildId := ""
+	if channel, err := session.State.Channel(self.relayChannelId); err == nil {
+		guildId = channel.GuildID
+	}
+	relayed := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		message := messages[i]
+		if !lib.SnowflakeNewer(message.ID, last) {
+			continue
+		}
+		history.relayed = message.ID
+		history.cursor.Advance(message.ID)
+		// The history has no guild or member, which conditions on roles need.
+		message.GuildID = guildId
+		if message.Author != nil && message.WebhookID == "" {
+			if info := self.members.Resolve(message.Author.ID); info != nil {
+				message.Member = info.Member
+			}
+		}
+		self.relayMessage(session, &discordgo.MessageCreate{Message: message})
+		relayed++
+	}
+	log.Printf("[info] Backfilled %v messages sent while the bridge was down\n", relayed)
+}
+
+// fetchLastMessages fetches the last messages of a channel, newest first.
+//
+// Parameters:
+//
+//	limit: maximum number of messages
+//	after: ID of a message that fetching stops at, may be empty
+func fetchLastMessages(session *discordgo.Session, channelId string, limit int, after string) ([]*discordgo.Message, error) {
+	var messages []*discordgo.Message
+	before := ""
+	for len(messages) < limit {
+		count := min(limit-len(messages), channelMessagesLimit)
+		page, err := session.ChannelMessages(channelId, count, before, "", "")
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page...)
+		if len(page) < count || !lib.SnowflakeNewer(page[len(page)-1].ID, after) {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+	return messages, nil
+}
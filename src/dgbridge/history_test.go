@@ -0,0 +1,32 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelHistoryAdmit(t *testing.T) {
+	cursor, err := lib.LoadMessageCursor(filepath.Join(t.TempDir(), "cursor"))
+	assert.NoError(t, err)
+	history := newChannelHistory(cursor, 10)
+	history.relayed = "1100000000000000005"
+	history.finish()
+
+	tests := []struct {
+		messageId string
+		admitted  bool
+	}{
+		{"1100000000000000004", false},
+		{"1100000000000000005", false},
+		{"1100000000000000008", true},
+		// Handled after a newer message, but not relayed from the history
+		{"1100000000000000007", true},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.admitted, history.admit(test.messageId), "Test #%v", i)
+	}
+	assert.Equal(t, "1100000000000000008", cursor.Last())
+}
@@ -0,0 +1,279 @@
+package main
+
+// This file implements the "dgbridge init" subcommand, which interactively
+// sets up a bridge: it asks for the token, guild, channel, game and command,
+// then writes a rules file, an environment file with the token, and a systemd
+// unit.
+
+import (
+	"bufio"
+	"dgbridge/rules"
+	"dgbridge/src/lib"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/bwmarrin/discordgo"
+)
+
+type InitArgs struct {
+	Dir  string `arg:"--dir" default:"." help:"Directory to write the files to, and that the bridge runs in"`
+	Name string `arg:"--name" default:"dgbridge" help:"Name of the systemd unit, e.g. survival for survival.service"`
+}
+
+// initPreset is a game offered by "dgbridge init".
+type initPreset struct {
+	Name        string
+	RulesFile   string // In rules.Files, empty for a custom game
+	Command     string // Default command
+	StopCommand string
+}
+
+var initPresets = []initPreset{
+	{Name: "Minecraft", RulesFile: "minecraft.rules.json", Command: "java -Xms512M -Xmx1G -jar server.jar nogui", StopCommand: "stop"},
+	{Name: "Terraria", RulesFile: "terraria.rules.json", Command: "./TerrariaServer -config config.ini", StopCommand: "exit"},
+	{Name: "Other (empty rules file)"},
+}
+
+// initEmptyRules is the rules file written for a custom game.
+var initEmptyRules = fmt.Sprintf(`{
+  "Version": %v,
+  "DiscordToSubprocess": [],
+  "SubprocessToDiscord": []
+}
+`, lib.CurrentRulesVersion)
+
+// prompter asks the questions of "dgbridge init" on the terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask asks for a line of text.
+//
+// Parameters:
+//
+//	fallback: answer if the line is empty, no answer is required if not empty
+func (self *prompter) ask(question string, fallback string) string {
+	for {
+		if fallback != "" {
+			_, _ = fmt.Fprintf(self.out, "%v [%v]: ", question, fallback)
+		} else {
+			_, _ = fmt.Fprintf(self.out, "%v: ", question)
+		}
+		line, err := self.in.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err != nil && line == "" {
+			log.Fatalln("[fatal] no answer:", err)
+		}
+		if line == "" {
+			line = fallback
+		}
+		if line != "" {
+			return line
+		}
+	}
+}
+
+// choose asks to choose one of a list of options.
+//
+// Returns:
+//
+//	the index of the chosen option
+func (self *prompter) choose(question string, options []string) int {
+	for i, option := range options {
+		_, _ = fmt.Fprintf(self.out, "  %v) %v\n", i+1, option)
+	}
+	for {
+		answer := self.ask(question, "1")
+		index, err := strconv.Atoi(answer)
+		if err == nil && index >= 1 && index <= len(options) {
+			return index - 1
+		}
+		_, _ = fmt.Fprintf(self.out, "Enter a number from 1 to %v\n", len(options))
+	}
+}
+
+// runInitCommand runs "dgbridge init".
+//
+// Parameters:
+//
+//	argv: command line arguments following "init"
+func runInitCommand(argv []string) {
+	var args InitArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge init",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+
+	dir, err := filepath.Abs(args.Dir)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	fmt.Println("This sets up a bridge in", dir)
+
+	token := os.Getenv("DISCORD_TOKEN")
+	if token == "" {
+		token = p.ask("Discord bot token (from the Discord developer portal)", "")
+	}
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	user, err := session.User("@me")
+	if err != nil {
+		log.Fatalln("[fatal] error authenticating, check the token:", err)
+	}
+	fmt.Printf("Authenticated as %v\n", user.Username)
+
+	channelId := chooseChannel(p, session)
+	preset := initPresets[p.choose("Game", presetNames())]
+	command := p.ask("Command that starts the server", preset.Command)
+
+	rulesFile := "rules.json"
+	rulesContents := []byte(initEmptyRules)
+	if preset.RulesFile != "" {
+		rulesFile = preset.RulesFile
+		if rulesContents, err = rules.Files.ReadFile(preset.RulesFile); err != nil {
+			log.Fatalln("[fatal]", err)
+		}
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	envFile := args.Name + ".env"
+	unitFile := args.Name + ".service"
+	files := []struct {
+		name     string
+		contents string
+		mode     os.FileMode
+	}{
+		{rulesFile, string(rulesContents), 0o644},
+		// The token is kept out of the unit, which anyone may read.
+		{envFile, fmt.Sprintf("DISCORD_TOKEN=%v\n%v=%v\n", token, channelIdEnv, channelId), 0o600},
+		{unitFile, systemdUnit(args.Name, dir, executable, envFile, rulesFile, preset.StopCommand, command), 0o644},
+	}
+	// Check first, so that no file is written if one exists.
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(dir, file.name)); err == nil {
+			log.Fatalf("[fatal] %v exists already\n", filepath.Join(dir, file.name))
+		}
+	}
+	for _, file := range files {
+		if err := writeNewFile(filepath.Join(dir, file.name), file.contents, file.mode); err != nil {
+			log.Fatalln("[fatal]", err)
+		}
+		fmt.Println("Wrote", filepath.Join(dir, file.name))
+	}
+	fmt.Printf(`
+To run the bridge as a systemd service:
+
+    sudo cp %v /etc/systemd/system/
+    sudo systemctl enable --now %v
+
+`, filepath.Join(dir, unitFile), args.Name)
+}
+
+// chooseChannel asks to choose a guild of the bot, then one of its text
+// channels.
+//
+// Returns:
+//
+//	the ID of the channel
+func chooseChannel(p *prompter, session *discordgo.Session) string {
+	guilds, err := session.UserGuilds(200, "", "", false)
+	if err != nil {
+		log.Fatalln("[fatal] error listing guilds:", err)
+	}
+	if len(guilds) == 0 {
+		log.Fatalln("[fatal] the bot isn't in any guild yet, invite it to your guild first")
+	}
+	names := make([]string, len(guilds))
+	for i, guild := range guilds {
+		names[i] = guild.Name
+	}
+	guild := guilds[p.choose("Guild", names)]
+
+	channels, err := session.GuildChannels(guild.ID)
+	if err != nil {
+		log.Fatalln("[fatal] error listing channels:", err)
+	}
+	var textChannels []*discordgo.Channel
+	names = nil
+	for _, channel := range channels {
+		if channel.Type == discordgo.ChannelTypeGuildText {
+			textChannels = append(textChannels, channel)
+			names = append(names, "#"+channel.Name)
+		}
+	}
+	if len(textChannels) == 0 {
+		log.Fatalf("[fatal] %v has no text channels the bot can see\n", guild.Name)
+	}
+	return textChannels[p.choose("Channel to relay", names)].ID
+}
+
+func presetNames() []string {
+	names := make([]string, len(initPresets))
+	for i, preset := range initPresets {
+		names[i] = preset.Name
+	}
+	return names
+}
+
+// systemdUnit returns a systemd unit that runs the bridge.
+// The token and channel ID are read from the environment file.
+func systemdUnit(name, dir, executable, envFile, rulesFile, stopCommand, command string) string {
+	execStart := []string{executable, "--rules", rulesFile}
+	if stopCommand != "" {
+		execStart = append(execStart, "--stop_command", stopCommand)
+	}
+	execStart = append(execStart, command)
+	for i, arg := range execStart {
+		execStart[i] = systemdQuote(arg)
+	}
+	return fmt.Sprintf(`[Unit]
+Description=dgbridge %v
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+WorkingDirectory=%v
+EnvironmentFile=%v
+ExecStart=%v
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`, name, dir, filepath.Join(dir, envFile), strings.Join(execStart, " "))
+}
+
+// systemdQuote quotes an argument of a systemd ExecStart line.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "%", "%%").Replace(s)
+	return `"` + s + `"`
+}
+
+// writeNewFile writes a file, failing if it exists.
+func writeNewFile(path string, contents string, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(contents); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
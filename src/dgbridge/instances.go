@@ -0,0 +1,162 @@
+package main
+
+// This file implements running several subprocess instances in one bridge,
+// e.g. the lobby and the worlds of a sharded server, see --instance. A bridge
+// relaying a remote console (see Console) runs it as its only instance.
+
+import (
+	"dgbridge/src/ext"
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stdinLine is a line to write to the stdin of a subprocess instance.
+type stdinLine struct {
+	Instance string // Name of the instance, empty for all instances
+	Text     string
+}
+
+// SubprocessGroup runs the subprocess instances of a bridge. The output of all
+// instances is merged, and lines are written to a single instance or to all.
+// When an instance exits, the others are stopped.
+type SubprocessGroup struct {
+	Instances       []Console
+	OutputLineEvent ext.EventChannel[OutputLine] // Emits the lines of all instances
+	ExitEvent       ext.EventChannel[int]        // Emits the exit code of the first instance that exits
+	Transcript      *lib.StdinTranscript         // Records the written lines, may be nil, see --stdin_transcript
+	exitOnce        sync.Once
+}
+
+// NewSubprocessGroup creates a SubprocessGroup of instances that have not been
+// started.
+func NewSubprocessGroup(instances []Console) *SubprocessGroup {
+	self := &SubprocessGroup{Instances: instances}
+	for _, instance := range instances {
+		// Listen before the instances are started, so that no line is missed.
+		lineCh := instance.Output().Listen()
+		go superviseJob("output of instance "+instance.InstanceName(), func() { self.forwardLines(lineCh) })
+		go self.watchExit(instance, instance.Exited().Listen())
+	}
+	return self
+}
+
+// Start starts all instances, see SubprocessContext.Start.
+func (self *SubprocessGroup) Start() error {
+	if self.Transcript != nil {
+		if err := self.Transcript.Start(time.Now()); err != nil {
+			log.Println("[error] error writing stdin transcript:", err)
+		}
+	}
+	for _, instance := range self.Instances {
+		if err := instance.Start(); err != nil {
+			if instance.InstanceName() != "" {
+				return fmt.Errorf("instance %v: %v", instance.InstanceName(), err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops all instances gracefully and concurrently, see Console.Stop.
+// Returns once all instances have exited.
+func (self *SubprocessGroup) Stop() {
+	var wg sync.WaitGroup
+	for _, instance := range self.Instances {
+		wg.Add(1)
+		go func(instance Console) {
+			defer wg.Done()
+			instance.Stop()
+		}(instance)
+	}
+	wg.Wait()
+}
+
+// WriteLine writes a line to the stdin of an instance. A newline is appended.
+//
+// Parameters:
+//
+//	line: the line and the name of the instance to write it to. If the name is
+//		empty, or the bridge runs a single unnamed subprocess, the line is
+//		written to all instances; lines for unknown instances are dropped.
+func (self *SubprocessGroup) WriteLine(line stdinLine) {
+	written := false
+	for _, instance := range self.Instances {
+		name := instance.InstanceName()
+		if line.Instance == "" || name == "" || strings.EqualFold(name, line.Instance) {
+			instance.WriteLine(line.Text)
+			written = true
+		}
+	}
+	if !written {
+		log.Printf("[warning] no subprocess instance named %q, dropping line\n", line.Instance)
+		return
+	}
+	if self.Transcript != nil {
+		if err := self.Transcript.Record(line.Instance, line.Text, time.Now()); err != nil {
+			log.Println("[error] error writing stdin transcript:", err)
+		}
+	}
+}
+
+// forwardLines broadcasts the lines of an instance to OutputLineEvent.
+// The channel stays registered if forwardLines panics, so that the lines emitted
+// until it is restarted are held rather than lost.
+func (self *SubprocessGroup) forwardLines(lineCh <-chan OutputLine) {
+	for line := range lineCh {
+		self.OutputLineEvent.Broadcast(line)
+	}
+}
+
+// watchExit waits for an instance to exit. The first instance to exit stops the
+// others, then its exit code is broadcast to ExitEvent.
+func (self *SubprocessGroup) watchExit(instance Console, exitCh <-chan int) {
+	exitCode := <-exitCh
+	instance.Exited().Off(exitCh)
+	self.exitOnce.Do(func() {
+		if len(self.Instances) > 1 {
+			log.Printf("[info] Instance %v exited with code %d, stopping the other instances\n", instance.InstanceName(), exitCode)
+			self.Stop()
+		}
+		self.ExitEvent.Broadcast(exitCode)
+	})
+}
+
+// instancePrefix returns the prefix of the lines of an instance in the
+// terminal, the log file and the console history: "[name] ", or "" for a
+// single unnamed subprocess.
+func instancePrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "[" + name + "] "
+}
+
+// parseInstances parses the --instance arguments.
+//
+// Returns:
+//
+//	the names of the instances and their commands, in order
+func parseInstances(values []string) ([]string, []string, error) {
+	names := make([]string, 0, len(values))
+	commands := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		name, command, ok := strings.Cut(value, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.TrimSpace(command) == "" {
+			return nil, nil, fmt.Errorf("--instance: expected NAME=COMMAND, got %q", value)
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, nil, fmt.Errorf("--instance: duplicate instance name %q", name)
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+		commands = append(commands, command)
+	}
+	return names, commands, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+// This file implements the message journal, see --journal_file, and /export,
+// which uploads a transcript of it.
+
+import (
+	"bytes"
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// journalPruneInterval is the time between removals of old journal entries.
+const journalPruneInterval = time.Hour
+
+// exportHoursDefault is the number of hours exported by /export if none is
+// given.
+const exportHoursDefault = 24
+
+// exportSizeLimit is the maximum size of a transcript uploaded by /export.
+// Discord rejects larger files from bots in guilds without boosts.
+const exportSizeLimit = 10 * 1024 * 1024
+
+// startJournalPruneJob periodically removes the journal entries older than
+// --journal_max_age.
+func (self *BotContext) startJournalPruneJob() {
+	ticker := time.NewTicker(journalPruneInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		if err := self.journal.Prune(time.Now()); err != nil {
+			log.Printf("[error] error pruning journal: %v", err)
+		}
+	}
+}
+
+// recordJournal appends a relayed message to the journal, if enabled.
+//
+// Parameters:
+//
+//	author: Discord name or in-game name of the sender, may be empty
+func (self *BotContext) recordJournal(direction string, author string, content string) {
+	if self.journal == nil {
+		return
+	}
+	entry := lib.JournalEntry{Time: time.Now(), Direction: direction, Author: author, Content: content}
+	if err := self.journal.Record(entry); err != nil {
+		log.Printf("[error] error writing journal: %v", err)
+	}
+}
+
+// exportCommand handles /export.
+func (self *BotContext) exportCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range data.Options {
+		options[option.Name] = option
+	}
+	hours := int64(exportHoursDefault)
+	if option, ok := options["hours"]; ok {
+		hours = option.IntValue()
+	}
+	format := lib.TranscriptFormatText
+	if option, ok := options["format"]; ok {
+		format = option.StringValue()
+	}
+
+	now := time.Now()
+	entries, err := self.journal.Read(now.Add(-time.Duration(hours) * time.Hour))
+	if err != nil {
+		log.Printf("[error] error reading journal: %v", err)
+		self.respond(s, i, "The journal couldn't be read, see the bridge's log.")
+		return
+	}
+	if len(entries) == 0 {
+		self.respond(s, i, fmt.Sprintf("No messages were relayed in the last %v hours.", hours))
+		return
+	}
+	title := fmt.Sprintf("Relayed messages, last %v hours until %v", hours, now.Format(time.DateTime))
+	transcript, err := lib.FormatTranscript(entries, format, title, time.Local)
+	if err != nil {
+		log.Printf("[error] error formatting transcript: %v", err)
+		self.respond(s, i, "The transcript couldn't be created, see the bridge's log.")
+		return
+	}
+	if len(transcript) > exportSizeLimit {
+		self.respond(s, i, "The transcript is too large to upload, try fewer hours.")
+		return
+	}
+	extension, contentType := "txt", "text/plain; charset=utf-8"
+	if format == lib.TranscriptFormatHtml {
+		extension, contentType = "html", "text/html; charset=utf-8"
+	}
+	log.Printf("[info] %v exported %v journal entries\n", i.Member.User.Username, len(entries))
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("%v messages from the last %v hours.", len(entries), hours),
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Files: []*discordgo.File{{
+				Name:        fmt.Sprintf("transcript-%v.%v", now.Format("2006-01-02-1504"), extension),
+				ContentType: contentType,
+				Reader:      bytes.NewReader(transcript),
+			}},
+		},
+	})
+	if err != nil {
+		log.Printf("[error] error responding to interaction: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+// This file implements linking Discord accounts to in-game names with /link:
+// the user map that links are added to and saved, and the verification of the
+// Discord account with OAuth2, see --link_listen. The player then confirms the
+// link with a code whispered in game by the Link.ConfirmCommand, unless the
+// rules file sets Link.Unconfirmed. Without OAuth2, if the rules file has a Link.Verify, the
+// player confirms the link by typing its code in the game chat instead.
+
+import (
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord's OAuth2 endpoints.
+const (
+	discordTokenUrl = "https://discord.com/api/oauth2/token"
+	discordMeUrl    = "https://discord.com/api/users/@me"
+)
+
+// linkCallbackPath is the path of the OAuth2 redirect URL.
+const linkCallbackPath = "/link/callback"
+
+// userDirectory is the user map of the bridge. Links made with /link are added
+// to it, and saved to the users file. It is safe for concurrent use.
+type userDirectory struct {
+	mutex sync.RWMutex
+	users lib.UserMap // Replaced, not modified, when a link is added
+	path  string      // Users file that links are saved to, empty if they can't be saved
+	key   []byte      // Encrypts the saved file, nil if it isn't encrypted
+}
+
+// newUserDirectory creates a userDirectory.
+//
+// Parameters:
+//
+//	users: the loaded user map, may be nil
+//	path: users file that links are saved to, empty if they can't be saved
+//	key: key of the users file if it is encrypted, otherwise nil
+func newUserDirectory(users lib.UserMap, path string, key []byte) *userDirectory {
+	return &userDirectory{users: users, path: path, key: key}
+}
+
+// get returns the current user map, which must not be modified.
+func (self *userDirectory) get() lib.UserMap {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.users
+}
+
+// replace replaces the user map with a reloaded one.
+//
+// Parameters:
+//
+//	key: key of the users file if it is encrypted, otherwise nil
+func (self *userDirectory) replace(users lib.UserMap, key []byte) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.users = users
+	self.key = key
+}
+
+// canLink reports whether links can be saved.
+func (self *userDirectory) canLink() bool {
+	return self.path != ""
+}
+
+// link maps an in-game name to a Discord user, and saves the users file. The
+// file isn't written if the name is linked to the user already.
+func (self *userDirectory) link(name string, userId string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.canLink() {
+		return fmt.Errorf("links can't be saved without --users")
+	}
+	if existing, ok := self.users.Lookup(name); ok && existing != userId {
+		return fmt.Errorf("%v is linked to another Discord account already", name)
+	}
+	if self.users[name] == userId {
+		return nil
+	}
+	users := maps.Clone(self.users)
+	if users == nil {
+		users = lib.UserMap{}
+	}
+	// Lookup ignores case, so replace an entry that differs in case only.
+	for existing := range users {
+		if strings.EqualFold(existing, name) {
+			delete(users, existing)
+		}
+	}
+	users[name] = userId
+	if err := writeUserMapFile(self.path, users, self.key); err != nil {
+		return fmt.Errorf("error saving users file: %v", err)
+	}
+	self.users = users
+	return nil
+}
+
+// linkOAuth configures the OAuth2 verification of /link, see --link_listen.
+type linkOAuth struct {
+	listen       string // Address the redirect URL is served on
+	redirectUrl  string // Public URL of linkCallbackPath
+	clientId     string // ID of the bot's application, defaults to the bot's user ID
+	clientSecret string
+}
+
+// linkCommand handles /link: with a name, it starts a link, responding with
+// the URL that verifies the Discord account, or without OAuth2 with the code
+// to type in game; with a code, it confirms a verified link.
+func (self *BotContext) linkCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if i.Member == nil {
+		return
+	}
+	userId := i.Member.User.ID
+	options := make(map[string]string)
+	for _, option := range data.Options {
+		options[option.Name] = option.StringValue()
+	}
+	now := time.Now()
+	if code, ok := options["code"]; ok {
+		link, ok := self.links.Confirm(userId, code, now)
+		if !ok {
+			self.respond(s, i, "That code is wrong or expired. Run /link with your in-game name to start again.")
+			return
+		}
+		self.completeLink(s, i, link)
+		return
+	}
+	name, ok := options["name"]
+	if !ok {
+		self.respond(s, i, "Run /link with your in-game name to link it to your Discord account.")
+		return
+	}
+	if err := lib.ValidWorkflowPlayer(name); err != nil {
+		self.respond(s, i, fmt.Sprintf("Can't link %q: %v", name, err))
+		return
+	}
+	link := self.links.Start(userId, name, now)
+	timeout := self.rules.Load().Link.LinkTimeout()
+	if self.linkOAuth == nil {
+		self.respond(s, i, fmt.Sprintf("To link **%v**, type **%v** in the game chat as %v within %v.", name, link.Code, name, timeout))
+		return
+	}
+	authorizeUrl := lib.DiscordAuthorizeUrl(self.linkOAuth.clientId, self.linkOAuth.redirectUrl, link.State)
+	self.respond(s, i, fmt.Sprintf("To link **%v**, verify your Discord account within %v: %v", name, timeout, authorizeUrl))
+}
+
+// completeLink adds a confirmed link to the user map, and responds to the
+// interaction.
+func (self *BotContext) completeLink(s *discordgo.Session, i *discordgo.InteractionCreate, link lib.PendingLink) {
+	if err := self.userMap.link(link.Name, link.UserId); err != nil {
+		log.Printf("[error] error linking %v to %v: %v", link.Name, link.UserId, err)
+		self.respond(s, i, fmt.Sprintf("Can't link %v: %v", link.Name, err))
+		return
+	}
+	log.Printf("[info] Linked %v to Discord user %v\n", link.Name, link.UserId)
+	self.respond(s, i, fmt.Sprintf("Linked **%v** to your Discord account.", link.Name))
+}
+
+// observeLinkCodes confirms links with the codes players type in the game
+// chat, see lib.LinkVerification, and tells the Discord users.
+func (self *BotContext) observeLinkCodes(session *discordgo.Session, lineCh <-chan OutputLine) {
+	for line := range lineCh {
+		player, code, ok := self.rules.Load().Link.Verify.Parse(line.Text)
+		if !ok {
+			continue
+		}
+		link, ok := self.links.ConfirmInGame(player, code, time.Now())
+		if !ok {
+			continue
+		}
+		content := fmt.Sprintf("Linked **%v** to your Discord account.", link.Name)
+		if err := self.userMap.link(link.Name, link.UserId); err != nil {
+			log.Printf("[error] error linking %v to %v: %v", link.Name, link.UserId, err)
+			content = fmt.Sprintf("Can't link %v: %v", link.Name, err)
+		} else {
+			log.Printf("[info] Linked %v to Discord user %v\n", link.Name, link.UserId)
+		}
+		channel, err := session.UserChannelCreate(link.UserId)
+		if err == nil {
+			_, err = session.ChannelMessageSend(channel.ID, content)
+		}
+		if err != nil {
+			log.Printf("[warning] error telling user %v about the link: %v", link.UserId, err)
+		}
+	}
+}
+
+// startLinkServer serves the OAuth2 redirect URL of /link.
+func (self *BotContext) startLinkServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+linkCallbackPath, self.linkCallback)
+	server := &http.Server{Addr: self.linkOAuth.listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Printf("[info] Serving the /link verification on %v\n", self.linkOAuth.listen)
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("[error] /link verification stopped: %v", err)
+	}
+}
+
+// linkCallback handles Discord's redirect after the user authorized the bot's
+// application: the authorization code is exchanged for the user's identity,
+// which must be the user that ran /link.
+func (self *BotContext) linkCallback(w http.ResponseWriter, r *http.Request) {
+	defer recoverPanic("link verification")
+	now := time.Now()
+	query := r.URL.Query()
+	link, ok := self.links.ByState(query.Get("state"), now)
+	if !ok {
+		http.Error(w, "This link expired. Run /link in Discord to start again.", http.StatusBadRequest)
+		return
+	}
+	if query.Get("error") != "" {
+		http.Error(w, "The authorization was denied. Run /link in Discord to start again.", http.StatusForbidden)
+		return
+	}
+	userId, err := self.fetchOAuthUserId(query.Get("code"))
+	if err != nil {
+		log.Printf("[warning] error verifying /link of %v: %v", link.Name, err)
+		http.Error(w, "Verifying your Discord account failed. Run /link in Discord to start again.", http.StatusBadGateway)
+		return
+	}
+	if userId != link.UserId {
+		http.Error(w, "You signed in with a different Discord account than the one that ran /link.", http.StatusForbidden)
+		return
+	}
+	if !self.links.Verify(link, now) {
+		http.Error(w, "This link expired. Run /link in Discord to start again.", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	config := self.rules.Load().Link
+	if config != nil && config.ConfirmCommand != "" {
+		self.subprocess.WriteLine(stdinLine{Text: config.FormatConfirmCommand(link)})
+		_, _ = fmt.Fprintf(w, "Your Discord account is verified. To finish, enter the code sent to %v in game with /link code in Discord.\n", link.Name)
+		return
+	}
+	self.links.Remove(link.UserId)
+	if config == nil || !config.Unconfirmed {
+		// Checked on startup, see Link.Unconfirmed.
+		http.Error(w, "Linking isn't configured.", http.StatusServiceUnavailable)
+		return
+	}
+	if err := self.userMap.link(link.Name, link.UserId); err != nil {
+		log.Printf("[error] error linking %v to %v: %v", link.Name, link.UserId, err)
+		http.Error(w, fmt.Sprintf("Can't link %v: %v", link.Name, err), http.StatusConflict)
+		return
+	}
+	log.Printf("[info] Linked %v to Discord user %v\n", link.Name, link.UserId)
+	_, _ = fmt.Fprintf(w, "Linked %v to your Discord account. You can close this page.\n", link.Name)
+}
+
+// fetchOAuthUserId exchanges an OAuth2 authorization code for an access token,
+// and returns the ID of the user it belongs to.
+func (self *BotContext) fetchOAuthUserId(code string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.PostForm(discordTokenUrl, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {self.linkOAuth.redirectUrl},
+		"client_id":     {self.linkOAuth.clientId},
+		"client_secret": {self.linkOAuth.clientSecret},
+	})
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := decodeOAuthResponse(response, &token); err != nil {
+		return "", fmt.Errorf("error exchanging the code: %v", err)
+	}
+	request, err := http.NewRequest(http.MethodGet, discordMeUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	response, err = client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	var user struct {
+		Id string `json:"id"`
+	}
+	if err := decodeOAuthResponse(response, &user); err != nil {
+		return "", fmt.Errorf("error fetching the user: %v", err)
+	}
+	return user.Id, nil
+}
+
+// decodeOAuthResponse decodes the JSON body of a successful response.
+func decodeOAuthResponse(response *http.Response, v any) error {
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v", response.Status)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, 64*1024)).Decode(v)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserDirectoryLink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	users := newUserDirectory(lib.UserMap{"Steve": "123456789012345678"}, path, nil)
+
+	assert.NoError(t, users.link("Alex", "223456789012345678"))
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "Alex")
+
+	// Linking a name to its user again doesn't write the file.
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, users.link("Alex", "223456789012345678"))
+	assert.NoFileExists(t, path)
+
+	assert.Error(t, users.link("steve", "223456789012345678"))
+	assert.NoFileExists(t, path)
+
+	// Names that differ in case only are replaced.
+	assert.NoError(t, users.link("alex", "223456789012345678"))
+	assert.Equal(t, lib.UserMap{"Steve": "123456789012345678", "alex": "223456789012345678"}, users.get())
+
+	assert.Error(t, newUserDirectory(nil, "", nil).link("Alex", "223456789012345678"))
+}
@@ -0,0 +1,160 @@
+package main
+
+// This file implements reading lines from a container's log file, see
+// --log_source: dgbridge runs as a sidecar of the server's container, e.g. in
+// a Kubernetes pod, and follows the log file the container runtime writes its
+// output to, like tail -F. Commands can be appended to a file the server's
+// container reads, see --log_source_commands.
+
+import (
+	"dgbridge/src/lib"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// logSourcePollInterval is the time between checks of the log file for new
+// lines.
+const logSourcePollInterval = 250 * time.Millisecond
+
+// LogFileConsole is a Console that follows a container's log file, see
+// lib.ContainerLogParser. The path may be a glob pattern, e.g. of the log
+// files of a Kubernetes container, of which the newest one is followed, so
+// that a restarted container's new file is picked up. Lines written before the
+// console was started aren't read.
+type LogFileConsole struct {
+	remoteConsole
+	pattern      string // Path or glob pattern of the log file
+	commandsPath string // File commands are appended to, may be empty
+	commandMutex sync.Mutex
+	droppedOnce  sync.Once // Warns about dropped commands once
+}
+
+// NewLogFileConsole creates a console that follows a log file once started.
+//
+// Parameters:
+//
+//	commandsPath: the file that lines written to the console are appended
+//		to, or "" to drop them
+func NewLogFileConsole(pattern string, commandsPath string) *LogFileConsole {
+	return &LogFileConsole{remoteConsole: newRemoteConsole(), pattern: pattern, commandsPath: commandsPath}
+}
+
+// newLogFileConsole creates the console configured with command line
+// arguments, and relays its output to the terminal.
+func newLogFileConsole(args CliArgs, rules *lib.Rules) Console {
+	console := NewLogFileConsole(args.LogSource, args.LogSourceCommands)
+	console.States = lib.NewProcessStateTracker(rules.States)
+	go superviseJob("relay of the console", func() { relayConsoleOutput(console) })
+	return console
+}
+
+// Start starts following the log file.
+func (self *LogFileConsole) Start() error {
+	if _, err := filepath.Match(self.pattern, ""); err != nil {
+		return fmt.Errorf("invalid log source %q: %v", self.pattern, err)
+	}
+	log.Printf("[info] Following log file %v\n", self.pattern)
+	go superviseJob("log file console", self.run)
+	return nil
+}
+
+// Stop stops following the log file.
+func (self *LogFileConsole) Stop() {
+	self.stop(func() {})
+}
+
+// WriteLine appends the line to the commands file, or drops it if there is
+// none.
+func (self *LogFileConsole) WriteLine(text string) {
+	if self.commandsPath == "" {
+		self.droppedOnce.Do(func() {
+			log.Printf("[warning] the log file console can't receive commands without --log_source_commands, dropping line %q and all that follow\n", text)
+		})
+		return
+	}
+	self.commandMutex.Lock()
+	defer self.commandMutex.Unlock()
+	file, err := os.OpenFile(self.commandsPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err == nil {
+		_, err = file.WriteString(text + "\n")
+		err = errors.Join(err, file.Close())
+	}
+	if err != nil {
+		log.Printf("[error] error writing command to %v: %v", self.commandsPath, err)
+	}
+}
+
+// run follows the newest log file until the console is stopped. A file that
+// is replaced at the same path, e.g. by log rotation, is read from the start.
+func (self *LogFileConsole) run() {
+	var file *os.File
+	defer func() {
+		if file != nil {
+			_ = file.Close()
+		}
+	}()
+	var tail *lib.LogTail
+	current := ""
+	first := true
+	for !self.isStopped() {
+		path, err := lib.NewestMatch(self.pattern)
+		if err != nil {
+			log.Printf("[error] error finding log file %v: %v", self.pattern, err)
+		}
+		if path != "" && path == current && !sameFile(file, path) {
+			log.Printf("[info] Log file %v was rotated, reading the new file\n", path)
+			current = ""
+		}
+		if path != "" && path != current {
+			if file != nil {
+				_ = file.Close()
+			}
+			file, err = os.Open(path)
+			if err != nil {
+				log.Printf("[error] error opening log file %v: %v", path, err)
+				file = nil
+				self.sleep()
+				continue
+			}
+			// Files found later, e.g. of a restarted container, are read from
+			// the start.
+			tail = lib.NewLogTail(file, first)
+			log.Printf("[info] Following log file %v\n", path)
+			current, first = path, false
+		}
+		if file != nil {
+			if info, err := file.Stat(); err == nil {
+				lines, truncated := tail.Read(info.Size())
+				if truncated {
+					log.Printf("[info] Log file %v was truncated, reading it from the start\n", current)
+				}
+				for _, line := range lines {
+					self.emitLine(line.Text, line.Stderr)
+				}
+			}
+		}
+		if !self.wait(logSourcePollInterval) {
+			return
+		}
+	}
+}
+
+// sameFile reports whether an open file is still the file at a path. Files
+// whose path can't be checked, e.g. while a rotated file is being replaced,
+// are taken to be the same.
+func sameFile(file *os.File, path string) bool {
+	if file == nil {
+		return true
+	}
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	fileInfo, err := file.Stat()
+	return err != nil || os.SameFile(pathInfo, fileInfo)
+}
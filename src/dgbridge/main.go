@@ -2,37 +2,438 @@ package main
 
 import (
 	"bufio"
+	"dgbridge/src/ext"
 	"dgbridge/src/lib"
+	"dgbridge/src/sink"
+	"errors"
 	"fmt"
 	"github.com/alexflint/go-arg"
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadSignal is the signal that reloads the configuration unless
+// --on_signal gives it another action, see configReloader.
+const reloadSignal = "SIGHUP"
+
+// Values of the --startup_order argument.
+const (
+	StartupOrderSubprocess = "subprocess" // Start the subprocess, then connect to Discord
+	StartupOrderDiscord    = "discord"    // Connect to Discord, then start the subprocess
 )
 
 type CliArgs struct {
-	Token     string `arg:"required,-t,--token" help:"Discord authentication token"`
-	ChannelId string `arg:"required,-i,--channel_id" help:"Discord channel ID"`
-	RulesFile string `arg:"required,-r,--rules" help:"Path to the file with translation rules"`
-	Command   string `arg:"required,positional"`
+	Token             string            `arg:"required,-t,--token,env:DISCORD_TOKEN" help:"Discord authentication token"`
+	ChannelId         string            `arg:"required,-i,--channel_id,env:DGBRIDGE_CHANNEL_ID" help:"Discord channel ID"`
+	RulesFile         string            `arg:"-r,--rules,env:DGBRIDGE_RULES" help:"Path to the file with translation rules, or an HTTPS URL to fetch it from; required unless --bundle is given"`
+	SelfTest          bool              `arg:"--self-test" help:"Check the Examples of the rules on startup, and exit if any fails"`
+	Bundle            string            `arg:"--bundle" help:"Load the rules and users file from a bundle built with 'dgbridge build-rules-bundle' instead"`
+	BundleKey         string            `arg:"--bundle_key,env:DGBRIDGE_BUNDLE_KEY" help:"Base64-encoded public key that the signature of --bundle is verified with"`
+	UsersFile         string            `arg:"-u,--users,env:DGBRIDGE_USERS" help:"Path to the file mapping in-game names to Discord user IDs, may be encrypted"`
+	UsersKeyFile      string            `arg:"--users_key_file" help:"File with the key of an encrypted users file, defaults to the DGBRIDGE_USERS_KEY environment variable"`
+	VerifyUsers       bool              `arg:"--verify_users" help:"Check that all users in the users file are members of the guild on startup"`
+	FederationListen  string            `arg:"--federation_listen" help:"Address to accept connections from federated bridges on, e.g. :7070"`
+	FederationPeers   []string          `arg:"--federation_peer,separate" help:"WebSocket URL of a federated bridge to connect to, e.g. ws://host:7070, may be repeated"`
+	FederationSecret  string            `arg:"--federation_secret,env:DGBRIDGE_FEDERATION_SECRET" help:"Shared secret of federated bridges"`
+	APIRate           float64           `arg:"--api_rate" default:"40" help:"Maximum Discord API requests per second, of all features together"`
+	APIConcurrency    int               `arg:"--api_concurrency" default:"4" help:"Maximum Discord API requests in flight at once"`
+	SendAttempts      int               `arg:"--send_attempts" default:"3" help:"Attempts to send a message to Discord if sending fails with a server or network error"`
+	SendRetryDelay    time.Duration     `arg:"--send_retry_delay" default:"1s" help:"Delay before retrying to send a message, doubled for every further retry"`
+	DeadLetterFile    string            `arg:"--dead_letter_file" help:"Append messages that couldn't be sent to Discord to this file, as JSON lines"`
+	MemberCacheTTL    time.Duration     `arg:"--member_cache_ttl" default:"10m" help:"Time guild members of mapped players are cached for, see the Player rule option"`
+	AlertUsers        []string          `arg:"--alert_user,separate" help:"Discord user ID that results of critical rules are sent to as a direct message, may be repeated"`
+	AlertInterval     time.Duration     `arg:"--alert_interval" default:"1m" help:"Minimum time between alert direct messages; alerts in between are counted and summarized"`
+	SummaryWindow     time.Duration     `arg:"--summary_window" default:"2m" help:"Time after startup during which matches of summarize rules are counted and posted as a single summary"`
+	LogFile           string            `arg:"--log_file" help:"Append all subprocess output to this file, whether relayed or not"`
+	ShadowRules       string            `arg:"--shadow_rules" help:"Candidate rules file run next to the rules on live traffic, logging inputs whose results differ without relaying the candidate's results"`
+	MuteFile          string            `arg:"--mute_file" help:"File of the Discord users muted with /bridgemute, whose messages aren't relayed to the subprocess; created if it doesn't exist"`
+	FilterFile        string            `arg:"--filter_file" help:"File of the words and patterns added to the content filter with /filter, created if it doesn't exist"`
+	StdinTranscript   string            `arg:"--stdin_transcript" help:"Append the lines written to the subprocess' stdin to this file with their time, for replaying them with dgbridge replay-stdin"`
+	LogMaxSize        int64             `arg:"--log_max_size" default:"100" help:"Size in MiB after which the log file is rotated, 0 for no limit"`
+	LogMaxAge         time.Duration     `arg:"--log_max_age" default:"24h" help:"Time after which the log file is rotated, 0 for no limit"`
+	LogMaxFiles       int               `arg:"--log_max_files" default:"10" help:"Number of rotated, gzip-compressed log files kept, 0 to keep all"`
+	BackfillFile      string            `arg:"--backfill_file" help:"Server log file whose last lines are matched with the Backfill rules on startup, and relayed as one message"`
+	BackfillLines     int               `arg:"--backfill_lines" default:"100" help:"Number of lines of --backfill_file to read"`
+	DiscordBackfill   int               `arg:"--discord_backfill" help:"Number of the relay channel's last messages fetched on startup; those sent since the last message relayed before are relayed to the subprocess"`
+	DiscordCursorFile string            `arg:"--discord_backfill_file" help:"File the ID of the last message relayed to the subprocess is saved to, for --discord_backfill; created if it doesn't exist"`
+	ConsoleHistory    int               `arg:"--console_history" default:"1000" help:"Number of recent console lines kept for the /console command, 0 to disable it"`
+	StopCommand       string            `arg:"--stop_command" help:"Command written to the subprocess' stdin to stop it gracefully, e.g. stop; used when the service is stopped and on Windows"`
+	StopTimeout       time.Duration     `arg:"--stop_timeout" default:"30s" help:"Time to wait for the subprocess to exit after asking it to stop, before killing it"`
+	OnSignal          map[string]string `arg:"--on_signal,separate" help:"What to do when dgbridge receives a signal: forward (default), ignore, reload (default for SIGHUP) to reload the rules and users, or command:<COMMAND> to write a command to stdin, e.g. --on_signal SIGUSR1=command:save-all"`
+	ControlListen     string            `arg:"--control_listen" help:"Address to serve the HTTP control API on, e.g. 127.0.0.1:7071"`
+	ControlToken      string            `arg:"--control_token,env:DGBRIDGE_CONTROL_TOKEN" help:"Bearer token that requests to the control API must be authenticated with"`
+	ErrorChannelId    string            `arg:"--error_channel_id" help:"Discord channel ID that a summary of the bridge's errors, e.g. failures to send messages, is posted to, e.g. an admin channel"`
+	ErrorInterval     time.Duration     `arg:"--error_report_interval" default:"10m" help:"Time between error summaries; errors in between are counted"`
+	SentryDsn         string            `arg:"--sentry_dsn,env:SENTRY_DSN" help:"Sentry DSN that panics and errors are reported to, with the version, a hash of the rules file and the rule involved"`
+	ErrorWebhookUrl   string            `arg:"--error_webhook_url" help:"URL that panics and errors are posted to as JSON, like --sentry_dsn, for other error trackers"`
+	LinkListen        string            `arg:"--link_listen" help:"Address to serve the OAuth2 verification of /link on, which lets players link their in-game name to their Discord account, e.g. :7073"`
+	LinkUrl           string            `arg:"--link_url" help:"Public URL of /link/callback on --link_listen, registered as a redirect of the bot's application, e.g. https://bridge.example.com/link/callback"`
+	LinkClientId      string            `arg:"--link_client_id" help:"Client ID of the bot's application, defaults to the bot's user ID"`
+	LinkClientSecret  string            `arg:"--link_client_secret,env:DGBRIDGE_LINK_CLIENT_SECRET" help:"Client secret of the bot's application"`
+	DebugListen       string            `arg:"--debug-listen" help:"Address to serve a plaintext TCP stream of each line, its result and the matching rule on, for tuning rules, e.g. 127.0.0.1:7072"`
+	RulesCacheDir     string            `arg:"--rules_cache_dir" help:"Directory that rules fetched from an HTTPS --rules URL are cached in; defaults to the user's cache directory"`
+	RulesRefresh      time.Duration     `arg:"--rules_refresh" default:"15m" help:"Time between checks of an HTTPS --rules URL for changes, which reload the configuration; 0 to disable"`
+	CrashDumpDir      string            `arg:"--crash_dump_dir" help:"Directory that a crash dump with the stack trace is written to whenever a relay job or Discord handler panics"`
+	Service           bool              `arg:"--service" help:"Run under the system service manager; set by 'dgbridge service install'"`
+	Signature         string            `arg:"--signature" help:"Suffix appended to messages sent to Discord; messages ending with it are not relayed, so bridges sharing a channel ignore each other. \"zero-width\" uses an invisible marker"`
+	CheckUpdates      bool              `arg:"--check_updates" help:"Check GitHub for a newer dgbridge release on startup, and log it"`
+	UpdateChannelId   string            `arg:"--update_channel_id" help:"Discord channel ID that newer releases found by --check_updates are posted to, e.g. an admin channel"`
+	UpdateRepo        string            `arg:"--update_repo" default:"SootyOwl/dgbridge" help:"GitHub repository checked by --check_updates"`
+	DailyChannelId    string            `arg:"--daily_summary_channel_id" help:"Discord channel ID that a summary of the day's statistics is posted to daily"`
+	DailyTime         string            `arg:"--daily_summary_time" default:"00:00" help:"Time of day the daily summary is posted at, as HH:MM in local time"`
+	DailyTemplate     string            `arg:"--daily_summary_template" help:"Template of the daily summary, see the README for its parameters"`
+	StatsFile         string            `arg:"--stats_file" help:"File the statistics of the daily summary are saved to, so that they survive restarts"`
+	JournalFile       string            `arg:"--journal_file" help:"File relayed messages are saved to, for transcripts exported with /export"`
+	JournalMaxAge     time.Duration     `arg:"--journal_max_age" default:"168h" help:"Time after which messages are removed from the journal, 0 to keep them"`
+	QueryAddress      string            `arg:"--query_address" help:"Address of the game server's query port, e.g. localhost:25565; enables /players"`
+	QueryProtocol     string            `arg:"--query_protocol" default:"minecraft" help:"Query protocol of the game server: minecraft (Server List Ping), minecraft_query or a2s (Steam)"`
+	QueryInterval     time.Duration     `arg:"--query_interval" default:"30s" help:"Time between queries of the game server"`
+	QueryPresence     string            `arg:"--query_presence" help:"Template of the bot's status, updated with each query, e.g. \"^P/^X players online\"; see the README for its parameters"`
+	QueryTopic        string            `arg:"--query_topic" help:"Template of the relay channel's topic, updated with the queries at most every 5 minutes"`
+	BridgeName        string            `arg:"--bridge_name,env:DGBRIDGE_NAME" help:"Name of this bridge in the log, error tracker events, sink events and status embeds, to tell apart the bridges of a host"`
+	Locale            string            `arg:"--locale" default:"en" help:"Language of messages printed and sent by the bridge"`
+	ShardId           int               `arg:"--shard_id" help:"ID of the gateway shard to connect as, for bots in too many guilds for one gateway connection; see --shard_count"`
+	ShardCount        int               `arg:"--shard_count" help:"Number of gateway shards of the bot; the relay channel's guild must be on --shard_id"`
+	ShardAuto         bool              `arg:"--shard_auto" help:"Connect as the gateway shard of the relay channel's guild, with the shard count recommended by Discord, so bridges sharing a bot identity coordinate their shards"`
+	ReconnectInterval time.Duration     `arg:"--reconnect_interval" default:"30s" help:"Time between attempts to connect to Discord if connecting fails, 0 to give up after the first attempt"`
+	StartupOrder      string            `arg:"--startup_order" default:"subprocess" help:"What to start first: subprocess or discord"`
+	WaitForDiscord    time.Duration     `arg:"--wait_for_discord" help:"With --startup_order discord, wait up to this long for the Discord session to be ready before starting the subprocess"`
+	PreReadyBuffer    int               `arg:"--pre_ready_buffer" default:"100" help:"Maximum number of subprocess lines kept until the Discord session is ready"`
+	MqttBroker        string            `arg:"--mqtt_broker" help:"MQTT broker URL to publish matched messages to, e.g. tcp://localhost:1883"`
+	MqttClientId      string            `arg:"--mqtt_client_id" default:"dgbridge" help:"MQTT client ID"`
+	MqttUsername      string            `arg:"--mqtt_username,env:MQTT_USERNAME" help:"MQTT username"`
+	MqttPassword      string            `arg:"--mqtt_password,env:MQTT_PASSWORD" help:"MQTT password"`
+	MqttTopic         string            `arg:"--mqtt_topic" default:"dgbridge" help:"MQTT topic prefix, events are published to <prefix>/<direction>"`
+	SandboxNoFile     uint64            `arg:"--sandbox_nofile" help:"Limit of open files (RLIMIT_NOFILE) of the subprocess"`
+	SandboxMemory     int64             `arg:"--sandbox_memory" help:"Memory limit in MiB of the subprocess, enforced by the cgroup --sandbox_cgroup"`
+	SandboxCgroup     string            `arg:"--sandbox_cgroup" help:"cgroup v2 directory the subprocess runs in, delegated to dgbridge's user, e.g. /sys/fs/cgroup/dgbridge.slice/server; each --instance runs in a child cgroup named after it"`
+	NoNewPrivileges   bool              `arg:"--no_new_privileges" help:"Start the subprocess with the no_new_privs flag, so that neither it nor its children can gain privileges, e.g. through setuid programs"`
+	CleanEnv          bool              `arg:"--clean_env" help:"Start the subprocess with a minimal environment (PATH, HOME, USER, LANG, LC_ALL, TZ, TMPDIR, TERM, on Windows SystemRoot, ComSpec and PATHEXT, and --var) instead of dgbridge's, so that e.g. DISCORD_TOKEN isn't passed on"`
+	EnvAllow          []string          `arg:"--env_allow,separate" help:"Environment variable passed on to the subprocess with --clean_env, may be repeated"`
+	Shell             bool              `arg:"--shell" help:"Run the command with the system shell (sh -c, or cmd /C on Windows), allowing pipes, redirects and shell variables"`
+	Vars              map[string]string `arg:"--var,separate" help:"Value for the command, e.g. --var MEMORY=4G; replaces $MEMORY in the command, and is set as an environment variable"`
+	Instances         []string          `arg:"--instance,separate" help:"Run several subprocess instances instead of a command, as NAME=COMMAND, e.g. --instance \"lobby=java -jar lobby.jar\"; may be repeated"`
+	PterodactylUrl    string            `arg:"--pterodactyl_url" help:"Relay the console of a server on a Pterodactyl panel instead of running a command, e.g. https://panel.example.com"`
+	PterodactylServer string            `arg:"--pterodactyl_server" help:"Identifier of the Pterodactyl server, as shown in its URL on the panel"`
+	PterodactylKey    string            `arg:"--pterodactyl_key,env:PTERODACTYL_API_KEY" help:"Pterodactyl client API key"`
+	AmpUrl            string            `arg:"--amp_url" help:"Relay the console of a CubeCoders AMP instance instead of running a command, e.g. http://localhost:8081"`
+	AmpUser           string            `arg:"--amp_user" help:"AMP user with permission to read and write the console"`
+	AmpPassword       string            `arg:"--amp_password,env:AMP_PASSWORD" help:"Password of the AMP user"`
+	CraftyUrl         string            `arg:"--crafty_url" help:"Relay the console of a server on Crafty Controller instead of running a command, e.g. https://localhost:8443"`
+	CraftyServer      string            `arg:"--crafty_server" help:"ID of the Crafty server, as shown in its URL on Crafty"`
+	CraftyToken       string            `arg:"--crafty_token,env:CRAFTY_API_TOKEN" help:"Crafty API token"`
+	CraftyInsecure    bool              `arg:"--crafty_insecure" help:"Don't verify Crafty's certificate, which is self-signed by default"`
+	UdpListen         string            `arg:"--udp_listen" help:"Read lines from GELF or syslog messages received over UDP instead of running a command, e.g. :12201"`
+	LogSource         string            `arg:"--log_source" help:"Follow a container's log file instead of running a command, e.g. the server container's log in /var/log/pods; may be a glob pattern, of which the newest file is followed"`
+	LogSourceCommands string            `arg:"--log_source_commands" help:"File that commands for --log_source are appended to, e.g. on a volume shared with the server's container"`
+	ConfigWatch       *time.Duration    `arg:"--config_watch" help:"Time between checks of the rules and users files for changes, which reload the configuration, e.g. of a mounted ConfigMap; 0 to disable"`
+	HealthListen      string            `arg:"--health_listen" help:"Address to serve the /healthz and /readyz probes on, e.g. :8086"`
+	Kubernetes        bool              `arg:"--kubernetes,env:DGBRIDGE_KUBERNETES" help:"Run as a sidecar in a Kubernetes pod: defaults --health_listen to :8086, --config_watch to 10s and --bridge_name to the POD_NAME environment variable"`
+	Command           []string          `arg:"positional" help:"Command to run: a single string that is split at spaces, or each argument separately after --"`
 }
 
 func main() {
-	fmt.Printf("Dgbridge (%v)\n", lib.Version)
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "users":
+			runUsersCommand(os.Args[2:])
+			return
+		case "check":
+			runCheckCommand(os.Args[2:])
+			return
+		case "service":
+			runServiceCommand(os.Args[2:])
+			return
+		case "migrate":
+			runMigrateCommand(os.Args[2:])
+			return
+		case "init":
+			runInitCommand(os.Args[2:])
+			return
+		case "setup-channel":
+			runSetupChannelCommand(os.Args[2:])
+			return
+		case "build-rules-bundle":
+			runBundleCommand(os.Args[2:])
+			return
+		case "docs-rules":
+			runDocsCommand(os.Args[2:])
+			return
+		case "replay-stdin":
+			runReplayCommand(os.Args[2:])
+			return
+		case "sandbox-exec":
+			runSandboxExecCommand(os.Args[2:])
+			return
+		}
+	}
+
 	var args CliArgs
-	arg.MustParse(&args)
+	parser, err := arg.NewParser(arg.Config{Exit: os.Exit, Out: os.Stdout}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(expandVersionFlag(os.Args[1:]))
+	fmt.Printf("Dgbridge (%v)\n", lib.Version)
+	if args.Kubernetes {
+		applyKubernetesDefaults(&args)
+	}
 
-	rules, err := lib.LoadRules(args.RulesFile)
+	if err := lib.SetLocale(args.Locale); err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	if args.BridgeName != "" {
+		// After the file name, so that lines of all bridges line up.
+		log.SetPrefix(fmt.Sprintf("bridge=%v ", args.BridgeName))
+		log.SetFlags(log.Flags() | log.Lmsgprefix)
+	}
+
+	if args.StartupOrder != StartupOrderSubprocess && args.StartupOrder != StartupOrderDiscord {
+		log.Fatalf("[fatal] invalid startup order %q, expected %q or %q\n",
+			args.StartupOrder, StartupOrderSubprocess, StartupOrderDiscord)
+	}
+
+	if args.ShardAuto && (args.ShardId != 0 || args.ShardCount != 0) {
+		log.Fatalln("[fatal] --shard_auto can't be combined with --shard_id and --shard_count")
+	}
+	if args.ShardId < 0 || (args.ShardId > 0 && args.ShardId >= args.ShardCount) {
+		log.Fatalf("[fatal] invalid --shard_id %v, expected less than --shard_count\n", args.ShardId)
+	}
+
+	if consoleSources(args) != 1 {
+		log.Fatalln("[fatal] expected either a command, --instance arguments, --pterodactyl_url, --amp_url, --crafty_url, --udp_listen or --log_source")
+	}
+
+	if args.Service {
+		runService(args)
+		return
+	}
+	startBridge(args)
+
+	// Block forever
+	select {}
+}
+
+// startBridge starts the subprocess instances and the bridge, exiting when an
+// instance exits.
+//
+// Returns:
+//
+//	the started instances
+func startBridge(args CliArgs) *SubprocessGroup {
+	crashDumpDir = args.CrashDumpDir
+	if (args.RulesFile == "") == (args.Bundle == "") {
+		log.Fatalln("[fatal] expected either --rules or --bundle")
+	}
+	bundle := loadBundle(args)
+	var rulesFile []byte
+	var rulesPath string
+	var remoteRules *lib.RemoteFile
+	if bundle != nil {
+		rulesFile = bundle.Files[lib.BundleRules]
+	} else {
+		rulesPath, remoteRules = fetchRemoteRules(args)
+		var err error
+		if rulesFile, err = os.ReadFile(rulesPath); err != nil {
+			log.Fatalln(lib.Tr(lib.MsgErrorLoadingRules, err))
+		}
+	}
+	rules, err := lib.ParseRules(rulesFile)
+	if err == nil {
+		err = validateRules(rules)
+	}
 	if err != nil {
-		log.Fatalf("error loading rules: %v\n", err)
+		log.Fatalln(lib.Tr(lib.MsgErrorLoadingRules, err))
+	}
+	if args.SelfTest {
+		selfTest(rules)
+	}
+	if args.FilterFile != "" {
+		list, err := lib.LoadFilterList(args.FilterFile)
+		if err != nil {
+			log.Fatalln("[fatal] error loading --filter_file:", err)
+		}
+		if rules.Filter == nil {
+			// The content filter applies to all directions by default.
+			rules.Filter = &lib.Filter{}
+		}
+		rules.Filter.List = list
+	}
+	var filterList *lib.FilterList
+	if rules.Filter != nil {
+		filterList = rules.Filter.List
+	}
+	shadow := loadShadowRules(args, filterList)
+	tracker = startErrorTracker(args, rulesFile)
+	if args.UpdateChannelId != "" && !lib.IsSnowflake(args.UpdateChannelId) {
+		log.Fatalf("[fatal] --update_channel_id: %q is not a valid Discord channel ID\n", args.UpdateChannelId)
+	}
+	if args.ErrorChannelId != "" {
+		if !lib.IsSnowflake(args.ErrorChannelId) {
+			log.Fatalf("[fatal] --error_channel_id: %q is not a valid Discord channel ID\n", args.ErrorChannelId)
+		}
+		if args.ErrorInterval <= 0 {
+			log.Fatalln("[fatal] --error_report_interval must be positive")
+		}
+	}
+
+	if args.APIRate <= 0 {
+		log.Fatalln("[fatal] --api_rate must be positive")
+	}
+
+	for _, userId := range args.AlertUsers {
+		if !lib.IsSnowflake(userId) {
+			log.Fatalf("[fatal] --alert_user: %q is not a valid Discord user ID\n", userId)
+		}
+	}
+
+	var userMap lib.UserMap
+	var usersPath string // Links are saved to the file, not to bundles
+	var usersKey []byte  // Key of the saved file, nil if it isn't encrypted
+	var usersContents []byte
+	usersBundled := false
+	if bundle != nil {
+		usersContents, usersBundled = bundle.Files[lib.BundleUsers]
+	}
+	if args.UsersFile != "" || usersBundled {
+		key, err := lib.LoadUserMapKey(args.UsersKeyFile)
+		if err != nil {
+			log.Fatalln("[fatal]", err)
+		}
+		if !usersBundled {
+			usersPath = args.UsersFile
+			usersContents, err = os.ReadFile(args.UsersFile)
+		}
+		if err == nil {
+			userMap, err = lib.ParseUserMap(usersContents, key)
+		}
+		if err == nil {
+			err = errors.Join(userMap.Validate()...)
+		}
+		if err != nil {
+			log.Fatalln("[fatal] error loading users:", err)
+		}
+		if lib.IsEncryptedUserMap(usersContents) {
+			usersKey = key
+		}
+	}
+	var mutes *lib.MuteList
+	if args.MuteFile != "" {
+		if mutes, err = lib.LoadMuteList(args.MuteFile); err != nil {
+			log.Fatalln("[fatal] error loading --mute_file:", err)
+		}
+	}
+	var history *channelHistory
+	if args.DiscordBackfill > 0 {
+		if args.DiscordCursorFile == "" {
+			log.Fatalln("[fatal] --discord_backfill requires a --discord_backfill_file")
+		}
+		cursor, err := lib.LoadMessageCursor(args.DiscordCursorFile)
+		if err != nil {
+			log.Fatalln("[fatal] error loading --discord_backfill_file:", err)
+		}
+		history = newChannelHistory(cursor, args.DiscordBackfill)
+		go superviseJob("message cursor", history.startSaveJob)
+		onExit(history.save)
+	}
+	var oauth *linkOAuth
+	if args.LinkListen != "" {
+		if usersPath == "" {
+			log.Fatalln("[fatal] --link_listen requires a --users file that links are saved to")
+		}
+		if args.LinkUrl == "" || args.LinkClientSecret == "" {
+			log.Fatalln("[fatal] --link_listen requires --link_url and --link_client_secret")
+		}
+		if rules.Link == nil || (rules.Link.ConfirmCommand == "" && !rules.Link.Unconfirmed) {
+			log.Fatalln("[fatal] --link_listen requires Link.ConfirmCommand in the rules, so that players confirm that they own the in-game name, or Link.Unconfirmed")
+		}
+		oauth = &linkOAuth{
+			listen:       args.LinkListen,
+			redirectUrl:  args.LinkUrl,
+			clientId:     args.LinkClientId,
+			clientSecret: args.LinkClientSecret,
+		}
+	}
+	if rules.Link != nil && rules.Link.Verify != nil && usersPath == "" {
+		log.Fatalln("[fatal] Link.Verify requires a --users file that links are saved to")
 	}
 
-	subprocess := NewSubprocess(args.Command)
+	sinkConfigs := rules.Sinks
+	if args.MqttBroker != "" {
+		// Not named, so it receives all matches.
+		sinkConfigs = append(sinkConfigs, mqttSinkConfig(args))
+	}
+	sinks, errs := sink.NewRouter(sinkConfigs, rules.Routes)
+	for _, err := range errs {
+		// Like the Discord connection, this is non-fatal.
+		log.Println("[error]", err)
+	}
+	onExit(sinks.Close)
 
-	go relaySubprocessStdout(&subprocess)
-	go relaySubprocessStderr(&subprocess)
-	go relayStdinToSubprocessStdin(&subprocess)
+	// Read the log before the subprocess appends to it.
+	var backfill []string
+	if args.BackfillFile != "" {
+		lines, err := lib.ReadLastLines(args.BackfillFile, args.BackfillLines)
+		if err != nil {
+			log.Println("[warning] can't read backfill file:", err)
+		}
+		backfill = rules.MatchBackfill(lines)
+	}
+
+	var instances []Console
+	switch {
+	case args.PterodactylUrl != "":
+		instances = []Console{newPterodactylConsole(args, rules)}
+	case args.AmpUrl != "":
+		instances = []Console{newAmpConsole(args, rules)}
+	case args.CraftyUrl != "":
+		instances = []Console{newCraftyConsole(args, rules)}
+	case args.UdpListen != "":
+		instances = []Console{newUdpConsole(args, rules)}
+	case args.LogSource != "":
+		instances = []Console{newLogFileConsole(args, rules)}
+	default:
+		instances = newSubprocesses(args, rules)
+	}
+	subprocess := NewSubprocessGroup(instances)
+	if args.StdinTranscript != "" {
+		file, err := os.OpenFile(args.StdinTranscript, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatalln("[fatal] error opening stdin transcript:", err)
+		}
+		onExit(func() { _ = file.Close() })
+		subprocess.Transcript = lib.NewStdinTranscript(file)
+	}
+	go superviseJob("relay of stdin", func() { relayStdinToSubprocessStdin(subprocess) })
+	if args.LogFile != "" {
+		logFile, err := ext.NewRotatingFile(args.LogFile, args.LogMaxSize*1024*1024, args.LogMaxAge, args.LogMaxFiles)
+		if err != nil {
+			log.Fatalln("[fatal] error opening log file:", err)
+		}
+		go superviseJob("log file", func() { archiveLines(&subprocess.OutputLineEvent, logFile) })
+	}
+	outputLines := bufferLines(&subprocess.OutputLineEvent, args.PreReadyBuffer)
+
+	var console *ext.RingBuffer[string]
+	if args.ConsoleHistory > 0 {
+		console = ext.NewRingBuffer[string](args.ConsoleHistory)
+		go superviseJob("console history", func() { recordLines(&subprocess.OutputLineEvent, console) })
+	}
+
+	var control *ControlServer
+	if args.ControlListen != "" {
+		control, err = StartControlServer(args.ControlListen, args.ControlToken, args.Service, subprocess)
+		if err != nil {
+			log.Fatalln("[fatal] error starting control API:", err)
+		}
+	}
 
 	// Create a goroutine that will wait for the subprocess to emit an exit event.
 	go func() {
@@ -40,39 +441,451 @@ func main() {
 		exitCh := subprocess.ExitEvent.Listen()
 		defer subprocess.ExitEvent.Off(exitCh)
 		exitCode := <-exitCh
+		runExitFuncs()
+		if control != nil && control.Restarting() {
+			log.Println("[info] Restarting dgbridge")
+			if err := restartBridge(); err != nil {
+				log.Fatalln("[fatal] error restarting dgbridge:", err)
+			}
+			os.Exit(0)
+		}
 		os.Exit(exitCode)
 	}()
 
-	err = subprocess.Start()
-	if err != nil {
-		log.Fatalln("[fatal] error starting command:", err)
+	var deadLetters io.Writer
+	if args.DeadLetterFile != "" {
+		file, err := os.OpenFile(args.DeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			log.Fatalln("[fatal] error opening dead letter file:", err)
+		}
+		onExit(func() { _ = file.Close() })
+		deadLetters = file
+	}
+
+	var daily *dailySummary
+	if args.DailyChannelId != "" {
+		daily = newDailySummary(args)
+	}
+
+	var query *serverQuery
+	if args.QueryAddress != "" {
+		query = newServerQuery(args)
+	}
+
+	var journal *lib.Journal
+	if args.JournalFile != "" {
+		journal, err = lib.OpenJournal(args.JournalFile, args.JournalMaxAge)
+		if err != nil {
+			log.Fatalln("[fatal] error opening journal file:", err)
+		}
+	}
+
+	var debugEcho *DebugEcho
+	if args.DebugListen != "" {
+		debugEcho, err = StartDebugEcho(args.DebugListen)
+		if err != nil {
+			log.Fatalln("[fatal] error starting debug echo:", err)
+		}
+	}
+
+	var update *updateCheck
+	if args.CheckUpdates {
+		update = startUpdateCheck(args.UpdateRepo)
+	}
+
+	reloader := newConfigReloader(args, rulesPath)
+	if signals := reloadSignals(args); len(signals) > 0 {
+		go superviseJob("configuration reload", func() { reloader.startSignalJob(signals) })
+	}
+	if args.ConfigWatch != nil && *args.ConfigWatch > 0 {
+		go superviseJob("configuration watch", func() { reloader.startWatchJob(*args.ConfigWatch) })
+	}
+	if remoteRules != nil && args.RulesRefresh > 0 {
+		go superviseJob("rules refresh", func() { startRulesRefreshJob(remoteRules, args.RulesRefresh, reloader) })
+	}
+
+	var health *HealthServer
+	if args.HealthListen != "" {
+		health, err = StartHealthServer(args.HealthListen)
+		if err != nil {
+			log.Fatalln("[fatal] error starting health probes:", err)
+		}
 	}
 
-	freeBotFunc, err := StartDiscordBot(BotParameters{
+	var readyOnce sync.Once
+	readyCh := make(chan struct{})
+	botParams := BotParameters{
 		Token:          args.Token,
 		RelayChannelId: args.ChannelId,
-		Subprocess:     &subprocess,
+		Name:           args.BridgeName,
+		Subprocess:     subprocess,
+		OutputLines:    outputLines,
 		Rules:          *rules,
-	})
+		UserMap:        newUserDirectory(userMap, usersPath, usersKey),
+		VerifyUsers:    args.VerifyUsers,
+		Signature:      expandSignature(args.Signature),
+		Sink:           sinks,
+		Console:        console,
+		Backfill:       backfill,
+		SendRetry:      lib.RetryPolicy{MaxAttempts: args.SendAttempts, BaseDelay: args.SendRetryDelay, MaxDelay: time.Minute},
+		DeadLetters:    deadLetters,
+		MemberCacheTTL: args.MemberCacheTTL,
+		AlertUserIds:   args.AlertUsers,
+		AlertInterval:  args.AlertInterval,
+		SummaryUntil:   time.Now().Add(args.SummaryWindow),
+		QueueLimit:     args.PreReadyBuffer,
+		APILimiter:     NewAPILimiter(nil, args.APIRate, args.APIConcurrency),
+		UpdateCheck:    update,
+		UpdateChannel:  args.UpdateChannelId,
+		DailySummary:   daily,
+		ServerQuery:    query,
+		Journal:        journal,
+		DebugEcho:      debugEcho,
+		ErrorChannel:   args.ErrorChannelId,
+		ErrorInterval:  args.ErrorInterval,
+		Control:        control,
+		LinkOAuth:      oauth,
+		Mutes:          mutes,
+		History:        history,
+		Shard:          shardConfig{Id: args.ShardId, Count: args.ShardCount, Auto: args.ShardAuto},
+		Shadow:         shadow,
+		Reloader:       reloader,
+		OnReady: func() {
+			readyOnce.Do(func() { close(readyCh) })
+			health.SetReady()
+		},
+	}
+
+	if args.FederationListen != "" || len(args.FederationPeers) > 0 {
+		_, err := StartFederation(FederationParameters{
+			ListenAddr:  args.FederationListen,
+			PeerUrls:    args.FederationPeers,
+			Secret:      args.FederationSecret,
+			Rules:       rules,
+			Subprocess:  subprocess,
+			OutputLines: bufferLines(&subprocess.OutputLineEvent, args.PreReadyBuffer),
+		})
+		if err != nil {
+			log.Fatalln("[fatal] error starting federation:", err)
+		}
+	}
+
+	if args.StartupOrder == StartupOrderDiscord {
+		go startDiscordBotWithRetry(botParams, args.ReconnectInterval)
+		if args.WaitForDiscord > 0 {
+			waitForDiscordReady(readyCh, args.WaitForDiscord)
+		}
+	}
+
+	err = subprocess.Start()
 	if err != nil {
-		// This is a non-fatal error. We want the server to run even if the
-		// Discord connection failed.
-		log.Println("[error] failed to start Discord bot:", err)
+		log.Fatalln("[fatal]", lib.Tr(lib.MsgErrorStartingCommand, err))
 	}
-	defer freeBotFunc()
+	health.SetStarted()
 
-	// Block forever
-	select {}
+	if args.StartupOrder == StartupOrderSubprocess {
+		go startDiscordBotWithRetry(botParams, args.ReconnectInterval)
+	}
+
+	return subprocess
 }
 
-// relayStdinToSubprocessStdin continuously relays os.Stdin to the subprocess' stdin.
-func relayStdinToSubprocessStdin(ctx *SubprocessContext) {
-	// Relay os.Stdin to the subprocess' stdin.
+// applyKubernetesDefaults sets the defaults of --kubernetes for the arguments
+// that weren't given. An explicit --config_watch 0 keeps watching disabled.
+func applyKubernetesDefaults(args *CliArgs) {
+	if args.HealthListen == "" {
+		args.HealthListen = ":8086"
+	}
+	if args.ConfigWatch == nil {
+		interval := 10 * time.Second
+		args.ConfigWatch = &interval
+	}
+	if args.BridgeName == "" {
+		// Set from the downward API, e.g. with a fieldRef to metadata.name.
+		args.BridgeName = os.Getenv("POD_NAME")
+	}
+}
+
+// consoleSources returns the number of console sources given on the command
+// line, of which there must be exactly one: a command, --instance arguments,
+// or a remote console (Pterodactyl, AMP, Crafty, UDP or a log file).
+func consoleSources(args CliArgs) int {
+	sources := 0
+	given := []bool{
+		len(args.Command) > 0,
+		len(args.Instances) > 0,
+		args.PterodactylUrl != "",
+		args.AmpUrl != "",
+		args.CraftyUrl != "",
+		args.UdpListen != "",
+		args.LogSource != "",
+	}
+	for _, source := range given {
+		if source {
+			sources++
+		}
+	}
+	return sources
+}
+
+// selfTest checks the examples of the rules, see --self-test, exiting if any
+// fails.
+func selfTest(rules *lib.Rules) {
+	checked, failures := rules.CheckExamples()
+	for _, failure := range failures {
+		log.Printf("[error] self-test: %v\n", failure)
+	}
+	if len(failures) > 0 {
+		log.Fatalf("[fatal] self-test: %v of %v rule examples failed\n", len(failures), checked)
+	}
+	log.Printf("[info] self-test: %v rule examples passed\n", checked)
+}
+
+// newSubprocesses creates the subprocess instances of the command or of the
+// --instance arguments, and relays their output to the terminal.
+func newSubprocesses(args CliArgs, rules *lib.Rules) []Console {
+	signalActions, err := parseSignalActions(args.OnSignal)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	names, commands := []string{""}, [][]string{args.Command}
+	if len(args.Instances) > 0 {
+		var values []string
+		names, values, err = parseInstances(args.Instances)
+		if err != nil {
+			log.Fatalln("[fatal]", err)
+		}
+		commands = make([][]string, len(values))
+		for i, value := range values {
+			commands[i] = []string{value}
+		}
+	}
+	env := lib.CommandEnv(args.Vars)
+	if args.CleanEnv {
+		env = lib.CleanCommandEnv(os.Environ(), args.EnvAllow, args.Vars)
+	}
+	sandboxed := sandboxEnabled(args)
+	if sandboxed {
+		err := checkSandbox(args)
+		if err == nil {
+			err = prepareSandboxCgroups(args, names)
+		}
+		if err != nil {
+			log.Fatalln("[fatal] sandbox:", err)
+		}
+	}
+	instances := make([]Console, len(names))
+	for i, name := range names {
+		argv := commandArgv(args, commands[i])
+		if sandboxed {
+			argv = sandboxArgv(args, name, argv)
+		}
+		instance := NewSubprocess(argv, env)
+		instance.Name = name
+		instance.StopCommand = args.StopCommand
+		instance.StopTimeout = args.StopTimeout
+		instance.SignalActions = signalActions
+		instance.States = lib.NewProcessStateTracker(rules.States)
+		instances[i] = &instance
+		go superviseJob("relay of stdout", func() { relaySubprocessStdout(&instance) })
+		go superviseJob("relay of stderr", func() { relaySubprocessStderr(&instance) })
+	}
+	return instances
+}
+
+// commandArgv returns the arguments of a subprocess command.
+// With --shell, the command is left to the shell to parse and expand.
+func commandArgv(args CliArgs, command []string) []string {
+	if args.Shell {
+		return shellCommand(strings.Join(command, " "))
+	}
+	return lib.ParseCommand(command, args.Vars)
+}
+
+// parseSignalActions parses the --on_signal arguments.
+//
+// Returns:
+//
+//	the signal actions by signal name
+func parseSignalActions(values map[string]string) (map[string]lib.SignalAction, error) {
+	known := make(map[string]bool, len(signalNames))
+	for _, name := range signalNames {
+		known[name] = true
+	}
+	actions := make(map[string]lib.SignalAction, len(values))
+	for name, value := range values {
+		name = lib.NormalizeSignalName(name)
+		if !known[name] {
+			return nil, fmt.Errorf("--on_signal: unsupported signal %v", name)
+		}
+		action, err := lib.ParseSignalAction(value)
+		if err != nil {
+			return nil, fmt.Errorf("--on_signal %v: %v", name, err)
+		}
+		actions[name] = action
+	}
+	if _, ok := actions[reloadSignal]; !ok && known[reloadSignal] {
+		// Like other daemons, dgbridge reloads its configuration on SIGHUP.
+		actions[reloadSignal] = lib.SignalAction{Kind: lib.SignalActionReload}
+	}
+	return actions, nil
+}
+
+// waitForDiscordReady blocks until the Discord session is ready or the timeout
+// expires, whichever comes first.
+func waitForDiscordReady(readyCh <-chan struct{}, timeout time.Duration) {
+	log.Printf("[info] Waiting up to %v for Discord before starting subprocess\n", timeout)
+	select {
+	case <-readyCh:
+	case <-time.After(timeout):
+		log.Println("[info]", lib.Tr(lib.MsgDiscordNotReady, timeout))
+	}
+}
+
+// exitFuncs are run before dgbridge exits or restarts with the subprocess,
+// see onExit.
+var exitFuncs struct {
+	mutex sync.Mutex
+	funcs []func()
+}
+
+// onExit registers a function to run before dgbridge exits or restarts with
+// the subprocess, e.g. to flush the sinks or close a file.
+func onExit(f func()) {
+	exitFuncs.mutex.Lock()
+	defer exitFuncs.mutex.Unlock()
+	exitFuncs.funcs = append(exitFuncs.funcs, f)
+}
+
+// runExitFuncs runs the functions registered with onExit, the last registered
+// first.
+func runExitFuncs() {
+	exitFuncs.mutex.Lock()
+	funcs := exitFuncs.funcs
+	exitFuncs.funcs = nil
+	exitFuncs.mutex.Unlock()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+}
+
+// startDiscordBotWithRetry starts the Discord bot, retrying periodically until
+// it succeeds. The session is closed on exit.
+//
+// Failing to connect is a non-fatal error. We want the server to run even if
+// the Discord connection failed, e.g. during a Discord outage or if the token
+// is invalid.
+//
+// Parameters:
+//
+//	interval:
+//		Time between connection attempts. If zero, no further attempts are made
+//		after the first one fails.
+func startDiscordBotWithRetry(params BotParameters, interval time.Duration) {
+	for {
+		freeBotFunc, err := StartDiscordBot(params)
+		if err == nil {
+			onExit(freeBotFunc)
+			return
+		}
+		log.Println("[error]", lib.Tr(lib.MsgErrorStartingBot, err))
+		if interval <= 0 {
+			return
+		}
+		log.Println("[info]", lib.Tr(lib.MsgRetryingBotStart, interval))
+		time.Sleep(interval)
+	}
+}
+
+// relayStdinToSubprocessStdin continuously relays os.Stdin to the stdin of all
+// subprocess instances.
+func relayStdinToSubprocessStdin(group *SubprocessGroup) {
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
-		// It is required to append a newline to the line because  it is
-		// not included in Text().
-		ctx.WriteStdinLineEvent.Broadcast(scanner.Text() + "\n")
+		// WriteLine appends the newline, which is not included in Text().
+		group.WriteLine(stdinLine{Text: scanner.Text()})
+	}
+}
+
+// ZeroWidthSignature is an invisible message signature, used for
+// --signature zero-width.
+const ZeroWidthSignature = "\u200b\u200d\u200b"
+
+// expandSignature returns the message signature for a --signature value.
+func expandSignature(signature string) string {
+	if signature == "zero-width" {
+		return ZeroWidthSignature
+	}
+	return signature
+}
+
+// mqttSinkConfig creates the configuration of the MQTT sink declared with
+// command line arguments.
+func mqttSinkConfig(args CliArgs) lib.SinkConfig {
+	brokerUrl := args.MqttBroker
+	if parsed, err := url.Parse(brokerUrl); err == nil && args.MqttUsername != "" {
+		parsed.User = url.UserPassword(args.MqttUsername, args.MqttPassword)
+		brokerUrl = parsed.String()
+	}
+	return lib.SinkConfig{
+		Type:     lib.SinkTypeMqtt,
+		Url:      brokerUrl,
+		Topic:    args.MqttTopic,
+		ClientId: args.MqttClientId,
+	}
+}
+
+// bufferLines listens to the subprocess' output and queues the lines until
+// they are relayed to Discord. This keeps lines emitted before the Discord
+// session is ready, such as server boot logs.
+//
+// At most limit lines are kept; when the queue is full, the oldest line is
+// dropped. Dropped lines are logged once the queue keeps up again.
+//
+// Returns:
+//
+//	a channel that emits the queued lines
+func bufferLines(event *ext.EventChannel[OutputLine], limit int) <-chan OutputLine {
+	queue := ext.NewDroppingQueue[OutputLine](limit)
+	lineCh := event.Listen()
+	go func() {
+		defer event.Off(lineCh)
+		superviseJob("line buffer", func() {
+			dropped := 0
+			for line := range lineCh {
+				if queue.Push(line) {
+					if dropped == 0 {
+						log.Printf("[warning] more than %v lines are waiting to be relayed to Discord, dropping the oldest ones (see --pre_ready_buffer)\n", limit)
+					}
+					dropped++
+				} else if dropped > 0 {
+					log.Printf("[warning] dropped %v lines waiting to be relayed to Discord\n", dropped)
+					dropped = 0
+				}
+			}
+		})
+	}()
+	return queue.Chan()
+}
+
+// archiveLines continuously writes the subprocess' output to a log file.
+func archiveLines(event *ext.EventChannel[OutputLine], file io.Writer) {
+	lineCh := event.Listen()
+	defer event.Off(lineCh)
+	for line := range lineCh {
+		_, err := io.WriteString(file, instancePrefix(line.Instance)+line.Text+"\n")
+		if err != nil {
+			log.Println("[error] error writing log file:", err)
+		}
+	}
+}
+
+// recordLines continuously adds the subprocess' output to a ring buffer.
+func recordLines(event *ext.EventChannel[OutputLine], buffer *ext.RingBuffer[string]) {
+	lineCh := event.Listen()
+	defer event.Off(lineCh)
+	for line := range lineCh {
+		buffer.Push(instancePrefix(line.Instance) + line.Text)
 	}
 }
 
@@ -81,7 +894,7 @@ func relaySubprocessStdout(ctx *SubprocessContext) {
 	lineCh := ctx.StdoutLineEvent.Listen()
 	defer ctx.StdoutLineEvent.Off(lineCh)
 	for line := range lineCh {
-		_, _ = os.Stdout.WriteString(line + "\n")
+		_, _ = os.Stdout.WriteString(instancePrefix(ctx.Name) + line + "\n")
 	}
 }
 
@@ -90,6 +903,6 @@ func relaySubprocessStderr(ctx *SubprocessContext) {
 	lineCh := ctx.StderrLineEvent.Listen()
 	defer ctx.StderrLineEvent.Off(lineCh)
 	for line := range lineCh {
-		_, _ = os.Stderr.WriteString(line + "\n")
+		_, _ = os.Stderr.WriteString(instancePrefix(ctx.Name) + line + "\n")
 	}
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyKubernetesDefaults(t *testing.T) {
+	tests := []struct {
+		argv     []string
+		expected time.Duration
+	}{
+		{nil, 10 * time.Second},
+		{[]string{"--config_watch", "1m"}, time.Minute},
+		{[]string{"--config_watch", "0"}, 0},
+	}
+	for i, test := range tests {
+		var args CliArgs
+		parser, err := arg.NewParser(arg.Config{}, &args)
+		assert.NoError(t, err, "Test #%v", i)
+		argv := append([]string{"--token", "token", "--channel_id", "123456789012345678", "--kubernetes"}, test.argv...)
+		assert.NoError(t, parser.Parse(append(argv, "java")), "Test #%v", i)
+		applyKubernetesDefaults(&args)
+		if assert.NotNil(t, args.ConfigWatch, "Test #%v", i) {
+			assert.Equal(t, test.expected, *args.ConfigWatch, "Test #%v", i)
+		}
+		assert.Equal(t, ":8086", args.HealthListen, "Test #%v", i)
+	}
+}
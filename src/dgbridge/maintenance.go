@@ -0,0 +1,128 @@
+package main
+
+// This file implements maintenance mode, toggled with /bridge maintenance.
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maintenanceReplyInterval is the minimum time between automatic replies to the
+// same user during maintenance.
+const maintenanceReplyInterval = 10 * time.Minute
+
+// maintenanceMode tracks whether the server is under maintenance. During
+// maintenance, Discord messages are answered with an automatic reply instead
+// of being relayed, and subprocess lines aren't relayed.
+type maintenanceMode struct {
+	mutex       sync.Mutex
+	enabled     bool
+	until       string               // Free text, e.g. "18:00 UTC", may be empty
+	replied     map[string]time.Time // Time of the last automatic reply, by user ID
+	pausedRelay bool                 // Whether maintenance paused relaying, rather than e.g. /bridge pause
+}
+
+// start starts maintenance, or changes its end time.
+//
+// Parameters:
+//
+//	pausedRelay: whether starting maintenance paused relaying
+//
+// Returns:
+//
+//	the maintenance announcement
+func (self *maintenanceMode) start(until string, pausedRelay bool) string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.enabled {
+		self.pausedRelay = false
+	}
+	self.enabled = true
+	self.until = until
+	self.replied = make(map[string]time.Time)
+	self.pausedRelay = self.pausedRelay || pausedRelay
+	return self.message()
+}
+
+// end ends maintenance.
+//
+// Returns:
+//
+//	whether relaying was paused by maintenance, and must be resumed
+func (self *maintenanceMode) end() bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	pausedRelay := self.enabled && self.pausedRelay
+	self.enabled = false
+	self.replied = nil
+	self.pausedRelay = false
+	return pausedRelay
+}
+
+// active reports whether the server is under maintenance.
+func (self *maintenanceMode) active() bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.enabled
+}
+
+// reply decides how to answer a Discord message during maintenance.
+//
+// Returns:
+//
+//	whether the server is under maintenance, and the automatic reply, or ""
+//	if the user was answered recently
+func (self *maintenanceMode) reply(userId string, now time.Time) (bool, string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.enabled {
+		return false, ""
+	}
+	if last, ok := self.replied[userId]; ok && now.Sub(last) < maintenanceReplyInterval {
+		return true, ""
+	}
+	self.replied[userId] = now
+	return true, self.message()
+}
+
+// message returns the maintenance announcement. The caller must hold the mutex.
+func (self *maintenanceMode) message() string {
+	if self.until == "" {
+		return lib.Tr(lib.MsgMaintenance)
+	}
+	return lib.Tr(lib.MsgMaintenanceUntil, self.until)
+}
+
+// startMaintenance starts maintenance mode, pauses relaying subprocess lines,
+// announces the maintenance in the relay channel, and shows it in the bot's
+// presence.
+//
+// Returns:
+//
+//	the maintenance announcement
+func (self *BotContext) startMaintenance(s *discordgo.Session, until string) string {
+	message := self.maintenance.start(until, self.pause.pauseIfRelaying(lib.DirectionSubprocessToDiscord))
+	if _, err := self.sendMessage(s, message); err != nil {
+		log.Printf("[error] error announcing maintenance: %v", err)
+	}
+	if err := s.UpdateCustomStatus(lib.Tr(lib.MsgMaintenanceStatus)); err != nil {
+		log.Printf("[error] error updating presence: %v", err)
+	}
+	return message
+}
+
+// endMaintenance ends maintenance mode, resumes relaying if maintenance paused
+// it, and clears the bot's presence. Relaying paused otherwise, e.g. with
+// /bridge pause before maintenance started, stays paused.
+func (self *BotContext) endMaintenance(s *discordgo.Session) {
+	if self.maintenance.end() {
+		self.pause.resume(lib.DirectionSubprocessToDiscord)
+	}
+	if err := s.UpdateCustomStatus(""); err != nil {
+		log.Printf("[error] error updating presence: %v", err)
+	}
+}
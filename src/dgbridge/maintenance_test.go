@@ -0,0 +1,25 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceResumesOnlyItsPause(t *testing.T) {
+	var maintenance maintenanceMode
+	pause := newRelayPause(0)
+
+	// Maintenance paused relaying, so ending it resumes.
+	maintenance.start("", pause.pauseIfRelaying(lib.DirectionSubprocessToDiscord))
+	maintenance.start("18:00", pause.pauseIfRelaying(lib.DirectionSubprocessToDiscord))
+	assert.True(t, maintenance.end())
+	assert.False(t, maintenance.end(), "maintenance already ended")
+
+	// Relaying was paused before maintenance, so it stays paused.
+	pause.resume("")
+	pause.pause(lib.DirectionSubprocessToDiscord, true)
+	maintenance.start("", pause.pauseIfRelaying(lib.DirectionSubprocessToDiscord))
+	assert.False(t, maintenance.end())
+}
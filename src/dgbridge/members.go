@@ -0,0 +1,101 @@
+package main
+
+// This file implements looking up the guild members that in-game players are
+// mapped to, for rules that are built with a player's Props.
+
+import (
+	"dgbridge/src/ext"
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord API requests a MemberResolver may make, per second and at once.
+const (
+	memberLookupRate  = 1.0
+	memberLookupBurst = 5
+)
+
+// memberCacheSize is the number of lookups a MemberResolver caches.
+const memberCacheSize = 1000
+
+// MemberInfo is what outbound messages use of a guild member.
+type MemberInfo struct {
+	Member    *discordgo.Member
+	RoleColor int // Color of the member's highest colored role, or 0
+}
+
+// MemberResolver looks up guild members by user ID. Lookups are cached for a
+// time, and Discord API requests are rate limited; while limited, expired
+// lookups are used.
+// It is safe for concurrent use.
+type MemberResolver struct {
+	session   *discordgo.Session
+	channelId string // Members are looked up in the guild of this channel
+	limiter   *lib.RateLimiter
+	members   *ext.TTLCache[string, *MemberInfo] // By user ID, nil if the lookup failed
+}
+
+// NewMemberResolver creates a MemberResolver for the guild of a channel.
+//
+// Parameters:
+//
+//	ttl: time lookups are cached for
+func NewMemberResolver(session *discordgo.Session, channelId string, ttl time.Duration) *MemberResolver {
+	return &MemberResolver{
+		session:   session,
+		channelId: channelId,
+		limiter: lib.NewRateLimiter(lib.RateLimit{
+			Rate:     memberLookupRate,
+			Burst:    memberLookupBurst,
+			Overflow: lib.RateLimitOverflowSummarize,
+		}),
+		members: ext.NewTTLCache[string, *MemberInfo](ttl, memberCacheSize),
+	}
+}
+
+// Resolve looks up a guild member by user ID. Failed lookups are cached too,
+// so that e.g. users who left the guild aren't looked up for every line; errors
+// are logged when the member is fetched.
+//
+// Returns:
+//
+//	the member, or nil if the member can't be looked up
+func (self *MemberResolver) Resolve(userId string) *MemberInfo {
+	if info, ok := self.members.Get(userId); ok {
+		return info
+	}
+	if allowed, _ := self.limiter.Wait(); !allowed {
+		info, _ := self.members.Stale(userId)
+		return info
+	}
+	info, err := self.fetch(userId)
+	if err != nil {
+		log.Printf("[error] error looking up guild member: %v", err)
+	}
+	self.members.Set(userId, info)
+	return info
+}
+
+// fetch fetches a guild member from Discord, and the guild's roles if they
+// aren't in the session state, see cachedGuildRoles.
+func (self *MemberResolver) fetch(userId string) (*MemberInfo, error) {
+	channel, err := self.session.State.Channel(self.channelId)
+	if err != nil {
+		if channel, err = self.session.Channel(self.channelId); err != nil {
+			return nil, fmt.Errorf("error fetching relay channel: %v", err)
+		}
+	}
+	member, err := self.session.GuildMember(channel.GuildID, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching guild member %v: %v", userId, err)
+	}
+	roles, err := cachedGuildRoles(self.session, channel.GuildID, member.Roles)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching guild roles: %v", err)
+	}
+	return &MemberInfo{Member: member, RoleColor: getHighestRoleWithColor(roles, member.Roles)}, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+// This file implements the "dgbridge migrate" subcommand, which upgrades rules
+// files to the current schema version.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/alexflint/go-arg"
+)
+
+type MigrateArgs struct {
+	Files  []string `arg:"required,positional" help:"Rules files to upgrade in place, the original is kept as <FILE>.bak"`
+	DryRun bool     `arg:"--dry_run" help:"Only print the migrated files, don't write them"`
+}
+
+// runMigrateCommand runs "dgbridge migrate".
+//
+// Parameters:
+//
+//	argv: command line arguments following "migrate"
+func runMigrateCommand(argv []string) {
+	var args MigrateArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge migrate",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+
+	for _, path := range args.Files {
+		if err := migrateRulesFile(path, args.DryRun); err != nil {
+			log.Fatalf("[fatal] %v: %v\n", path, err)
+		}
+	}
+}
+
+// migrateRulesFile upgrades a rules file to lib.CurrentRulesVersion.
+func migrateRulesFile(path string, dryRun bool) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	migrated, version, err := lib.MigrateRules(contents)
+	if err != nil {
+		return err
+	}
+	if version == lib.CurrentRulesVersion {
+		fmt.Printf("%v: already at version %v\n", path, version)
+		return nil
+	}
+	if dryRun {
+		fmt.Printf("%v: would migrate from version %v to %v:\n%s", path, version, lib.CurrentRulesVersion, migrated)
+		return nil
+	}
+	if err := os.WriteFile(path+".bak", contents, 0644); err != nil {
+		return fmt.Errorf("error writing backup: %v", err)
+	}
+	if err := os.WriteFile(path, migrated, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%v: migrated from version %v to %v\n", path, version, lib.CurrentRulesVersion)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+// This file implements /bridgemute and /bridgeunmute, which stop relaying the
+// messages of a Discord user to the subprocess, see --mute_file and
+// lib.MuteList.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// muteCommands returns the mute commands, or nil without --mute_file.
+func (self *BotContext) muteCommands() []*discordgo.ApplicationCommand {
+	if self.mutes == nil {
+		return nil
+	}
+	userOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionUser,
+		Name:        "user",
+		Description: "The Discord user",
+		Required:    true,
+	}
+	return []*discordgo.ApplicationCommand{
+		{
+			Name:                     "bridgemute",
+			Description:              "Stop relaying a user's messages to the server",
+			DefaultMemberPermissions: &adminPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				userOption,
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "duration",
+					Description: "How long, e.g. 30m, 12h or 7d; defaults to until unmuted",
+				},
+			},
+		},
+		{
+			Name:                     "bridgeunmute",
+			Description:              "Relay a muted user's messages to the server again",
+			DefaultMemberPermissions: &adminPermissions,
+			Options:                  []*discordgo.ApplicationCommandOption{userOption},
+		},
+	}
+}
+
+// muteCommand handles /bridgemute and /bridgeunmute.
+func (self *BotContext) muteCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	if self.mutes == nil {
+		return
+	}
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range data.Options {
+		options[option.Name] = option
+	}
+	option, ok := options["user"]
+	if !ok {
+		return
+	}
+	user := option.UserValue(nil)
+	now := time.Now()
+	if data.Name == "bridgeunmute" {
+		unmuted, err := self.mutes.Unmute(user.ID, now)
+		switch {
+		case err != nil:
+			log.Printf("[error] error unmuting %v: %v", user.ID, err)
+			self.respond(s, i, fmt.Sprintf("Can't unmute <@%v>: %v", user.ID, err))
+		case !unmuted:
+			self.respond(s, i, fmt.Sprintf("<@%v> isn't muted.", user.ID))
+		default:
+			log.Printf("[info] %v unmuted Discord user %v\n", i.Member.User.Username, user.ID)
+			self.respond(s, i, fmt.Sprintf("Messages of <@%v> are relayed to the server again.", user.ID))
+		}
+		return
+	}
+	var until time.Time
+	if option, ok := options["duration"]; ok {
+		duration, err := lib.ParseMuteDuration(option.StringValue())
+		if err != nil {
+			self.respond(s, i, fmt.Sprintf("Can't mute <@%v>: %v", user.ID, err))
+			return
+		}
+		until = now.Add(duration)
+	}
+	if err := self.mutes.Mute(user.ID, until, now); err != nil {
+		log.Printf("[error] error muting %v: %v", user.ID, err)
+		self.respond(s, i, fmt.Sprintf("Can't mute <@%v>: %v", user.ID, err))
+		return
+	}
+	log.Printf("[info] %v muted Discord user %v (until: %v)\n", i.Member.User.Username, user.ID, formatMuteEnd(until))
+	self.respond(s, i, fmt.Sprintf("Messages of <@%v> aren't relayed to the server %v.", user.ID, formatMuteEnd(until)))
+}
+
+// formatMuteEnd formats the end of a mute for a response.
+func formatMuteEnd(until time.Time) string {
+	if until.IsZero() {
+		return "until unmuted"
+	}
+	return fmt.Sprintf("until <t:%v:f>", until.Unix())
+}
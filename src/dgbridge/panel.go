@@ -0,0 +1,209 @@
+package main
+
+// This file implements the control panel, see lib.PanelConfig: a message with
+// buttons for common server actions. Once ready, the bot edits its panel
+// message in place, or posts and pins one if it finds none, and posts it again
+// if it is deleted.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// panelSearchLimit is the number of recent messages searched for the panel if
+// it isn't pinned.
+const panelSearchLimit = 50
+
+// panelButtonsPerRow is the number of buttons Discord allows in a row.
+const panelButtonsPerRow = 5
+
+// panelButtonStyles maps the PanelStyle constants to Discord's button styles.
+var panelButtonStyles = map[string]discordgo.ButtonStyle{
+	lib.PanelStylePrimary:   discordgo.PrimaryButton,
+	lib.PanelStyleSecondary: discordgo.SecondaryButton,
+	lib.PanelStyleSuccess:   discordgo.SuccessButton,
+	lib.PanelStyleDanger:    discordgo.DangerButton,
+}
+
+// controlPanel is the message of the control panel. It is safe for concurrent
+// use.
+type controlPanel struct {
+	channelId string
+	mutex     sync.Mutex
+	messageId string // Empty until the panel was found or posted
+}
+
+// newControlPanel creates a controlPanel.
+//
+// Parameters:
+//
+//	relayChannelId: the channel of the panel if the config names none
+func newControlPanel(config *lib.PanelConfig, relayChannelId string) *controlPanel {
+	channelId := config.ChannelId
+	if channelId == "" {
+		channelId = relayChannelId
+	}
+	return &controlPanel{channelId: channelId}
+}
+
+// maintainPanel updates the panel message to the configured buttons, or
+// posts it if there is none.
+func (self *BotContext) maintainPanel(s *discordgo.Session) {
+	config := self.rules.Load().Panel
+	embeds := []*discordgo.MessageEmbed{{Title: config.PanelTitle(), Description: config.Description}}
+	components := panelComponents(config)
+	self.panel.mutex.Lock()
+	defer self.panel.mutex.Unlock()
+	if self.panel.messageId == "" {
+		self.panel.messageId = findPanelMessage(s, self.panel.channelId)
+	}
+	if self.panel.messageId != "" {
+		_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			ID:         self.panel.messageId,
+			Channel:    self.panel.channelId,
+			Embeds:     &embeds,
+			Components: &components,
+		})
+		if err == nil {
+			return
+		}
+		log.Printf("[warning] error updating the control panel, posting it again: %v", err)
+	}
+	message, err := s.ChannelMessageSendComplex(self.panel.channelId, &discordgo.MessageSend{
+		Embeds:     embeds,
+		Components: components,
+	})
+	if err != nil {
+		log.Printf("[error] error posting the control panel: %v", err)
+		self.panel.messageId = ""
+		return
+	}
+	self.panel.messageId = message.ID
+	if err := s.ChannelMessagePin(self.panel.channelId, message.ID); err != nil {
+		log.Printf("[warning] error pinning the control panel, it needs the Manage Messages permission: %v", err)
+	}
+}
+
+// findPanelMessage returns the ID of the bot's panel message in a channel,
+// searching the pinned and the recent messages, or "" if there is none.
+func findPanelMessage(s *discordgo.Session, channelId string) string {
+	pinned, err := s.ChannelMessagesPinned(channelId)
+	if err != nil {
+		log.Printf("[warning] error fetching pinned messages: %v", err)
+	}
+	recent, err := s.ChannelMessages(channelId, panelSearchLimit, "", "", "")
+	if err != nil {
+		log.Printf("[warning] error fetching recent messages: %v", err)
+	}
+	for _, message := range append(pinned, recent...) {
+		if message.Author != nil && message.Author.ID == s.State.User.ID && isPanelMessage(message) {
+			return message.ID
+		}
+	}
+	return ""
+}
+
+// isPanelMessage reports whether a message has the buttons of a panel.
+func isPanelMessage(message *discordgo.Message) bool {
+	for _, component := range message.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, component := range row.Components {
+			if button, ok := component.(*discordgo.Button); ok && strings.HasPrefix(button.CustomID, lib.PanelButtonPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// panelComponents returns the rows of buttons of the panel.
+func panelComponents(config *lib.PanelConfig) []discordgo.MessageComponent {
+	var rows []discordgo.MessageComponent
+	var row discordgo.ActionsRow
+	for index, button := range config.Buttons {
+		style, ok := panelButtonStyles[button.Style]
+		if !ok {
+			style = discordgo.SecondaryButton
+		}
+		component := discordgo.Button{Label: button.Label, Style: style, CustomID: config.ButtonId(index)}
+		if button.Emoji != "" {
+			component.Emoji = &discordgo.ComponentEmoji{Name: button.Emoji}
+		}
+		row.Components = append(row.Components, component)
+		if len(row.Components) == panelButtonsPerRow {
+			rows = append(rows, row)
+			row = discordgo.ActionsRow{}
+		}
+	}
+	if len(row.Components) > 0 {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Handles a discordgo.MessageDelete event, posting the panel again if it was
+// deleted.
+func (self *BotContext) panelMessageDelete() func(s *discordgo.Session, m *discordgo.MessageDelete) {
+	return func(s *discordgo.Session, m *discordgo.MessageDelete) {
+		defer recoverPanic("message delete handler")
+		self.panel.mutex.Lock()
+		deleted := m.ID == self.panel.messageId
+		if deleted {
+			self.panel.messageId = ""
+		}
+		self.panel.mutex.Unlock()
+		if deleted {
+			log.Println("[info] The control panel was deleted, posting it again")
+			self.maintainPanel(s)
+		}
+	}
+}
+
+// panelButton handles a click on a button of the panel: it writes the
+// button's command to the subprocess, runs its action or opens its form.
+func (self *BotContext) panelButton(s *discordgo.Session, i *discordgo.InteractionCreate, customId string) {
+	config := self.rules.Load().Panel
+	if config == nil || i.Member == nil {
+		return
+	}
+	button, ok := config.Button(customId)
+	if !ok {
+		self.respond(s, i, "This button is no longer available.")
+		return
+	}
+	if !config.MayUse(interactionAuthor(s, i), i.Member.Permissions&adminPermissions != 0) {
+		self.respond(s, i, "You may not use the control panel.")
+		return
+	}
+	switch button.Action {
+	case lib.PanelActionPlayers:
+		if self.query == nil {
+			self.respond(s, i, "Listing the players requires --query_address.")
+			return
+		}
+		self.playersCommand(s, i)
+	case lib.PanelActionStats:
+		self.respond(s, i, self.statsReport())
+	case "":
+		if button.Form != "" {
+			form := self.rules.Load().Form(button.Form)
+			if form == nil {
+				self.respond(s, i, fmt.Sprintf("There is no form %q.", button.Form))
+				return
+			}
+			self.openForm(s, i, form)
+			return
+		}
+		log.Printf("[info] %v pressed %q on the control panel, sending %q\n", i.Member.User.Username, button.Label, button.Command)
+		self.subprocess.WriteLine(stdinLine{Text: button.Command})
+		self.respond(s, i, button.CommandReply())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"sync"
+)
+
+// pauseMode is how messages are handled while relaying is paused.
+type pauseMode int
+
+const (
+	notPaused     pauseMode = iota
+	pausedDropped           // Messages are dropped
+	pausedHeld              // Messages are held until relaying is resumed
+)
+
+// relayPause tracks in which directions relaying is paused, e.g. during
+// maintenance.
+//
+// Subprocess lines held for Discord stay in the pre-ready buffer (see
+// bufferLines), which keeps the newest lines. Discord messages held for the
+// subprocess are kept here, up to holdLimit messages.
+type relayPause struct {
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	modes     map[string]pauseMode // By direction
+	held      []stdinLine          // Held DiscordToSubprocess messages
+	holdLimit int
+}
+
+func newRelayPause(holdLimit int) *relayPause {
+	self := &relayPause{
+		modes:     make(map[string]pauseMode),
+		holdLimit: holdLimit,
+	}
+	self.cond = sync.NewCond(&self.mutex)
+	return self
+}
+
+// pause pauses relaying in a direction.
+//
+// Parameters:
+//
+//	direction: DirectionSubprocessToDiscord, DirectionDiscordToSubprocess, or
+//		empty for both
+//	hold: whether to hold messages until relaying is resumed instead of
+//		dropping them
+func (self *relayPause) pause(direction string, hold bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	mode := pausedDropped
+	if hold {
+		mode = pausedHeld
+	}
+	for _, d := range pauseDirections(direction) {
+		self.modes[d] = mode
+		if d == lib.DirectionDiscordToSubprocess && !hold {
+			self.held = nil
+		}
+	}
+	self.cond.Broadcast()
+}
+
+// pauseIfRelaying pauses relaying in a direction, dropping messages, unless it
+// is paused already.
+//
+// Returns:
+//
+//	whether relaying was paused by this call
+func (self *relayPause) pauseIfRelaying(direction string) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.modes[direction] != notPaused {
+		return false
+	}
+	self.modes[direction] = pausedDropped
+	self.cond.Broadcast()
+	return true
+}
+
+// resume resumes relaying in a direction, see pause.
+//
+// Returns:
+//
+//	the held DiscordToSubprocess messages, if that direction was resumed
+func (self *relayPause) resume(direction string) []stdinLine {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	var held []stdinLine
+	for _, d := range pauseDirections(direction) {
+		delete(self.modes, d)
+		if d == lib.DirectionDiscordToSubprocess {
+			held, self.held = self.held, nil
+		}
+	}
+	self.cond.Broadcast()
+	return held
+}
+
+// waitSubprocessToDiscord blocks while subprocess lines are held.
+//
+// Returns:
+//
+//	false if the line should be dropped
+func (self *relayPause) waitSubprocessToDiscord() bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for self.modes[lib.DirectionSubprocessToDiscord] == pausedHeld {
+		self.cond.Wait()
+	}
+	return self.modes[lib.DirectionSubprocessToDiscord] == notPaused
+}
+
+// admitDiscordToSubprocess holds a message for the subprocess if relaying is
+// paused, dropping the oldest held message if holdLimit is reached.
+//
+// Returns:
+//
+//	true if the message should be relayed now
+func (self *relayPause) admitDiscordToSubprocess(message stdinLine) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	switch self.modes[lib.DirectionDiscordToSubprocess] {
+	case pausedDropped:
+		return false
+	case pausedHeld:
+		if self.holdLimit <= 0 {
+			return false
+		}
+		if len(self.held) >= self.holdLimit {
+			self.held = self.held[1:]
+		}
+		self.held = append(self.held, message)
+		return false
+	}
+	return true
+}
+
+func pauseDirections(direction string) []string {
+	if direction == "" {
+		return []string{lib.DirectionSubprocessToDiscord, lib.DirectionDiscordToSubprocess}
+	}
+	return []string{direction}
+}
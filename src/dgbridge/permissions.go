@@ -0,0 +1,145 @@
+package main
+
+// This file implements holding back the messages to the relay channel while
+// Discord rejects them, see lib.SendBackoff: while the bot lacks permission to
+// send to the channel, messages are dropped without logging each one, and
+// while the channel's slow mode limits the bot, they wait. Both are reported
+// once to the admins, describing how to fix them.
+
+import (
+	"dgbridge/src/lib"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// errSendForbidden is returned for messages dropped while the bot lacks
+// permission to send to the relay channel.
+var errSendForbidden = errors.New("the bot lacks permission to send messages to the relay channel")
+
+// forbiddenBackoff is the backoff of sending to the relay channel after the
+// bot lacked permission to.
+var forbiddenBackoff = lib.RetryPolicy{BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}
+
+// relayPermissions are the permissions the bot needs to send to the relay
+// channel, by name.
+var relayPermissions = []struct {
+	permission int64
+	name       string
+}{
+	{discordgo.PermissionViewChannel, "View Channel"},
+	{discordgo.PermissionSendMessages, "Send Messages"},
+}
+
+// sendMessage sends a message to the relay channel, signed with the bridge's
+// signature. While Discord rejects messages, it waits or fails without
+// sending it, see lib.SendBackoff.
+func (self *BotContext) sendMessage(session *discordgo.Session, content string) (*discordgo.Message, error) {
+	for {
+		wait, drop := self.sendBackoff.Hold(time.Now())
+		if drop {
+			return nil, errSendForbidden
+		}
+		time.Sleep(wait)
+		// Rate limits are retried here instead of by discordgo, so that the
+		// slow mode holds back all messages and is reported.
+		message, err := session.ChannelMessageSend(self.relayChannelId, content+self.signature, discordgo.WithRetryOnRatelimit(false))
+		var rateLimitErr *discordgo.RateLimitError
+		switch {
+		case err == nil:
+			if self.sendBackoff.Succeeded() {
+				log.Println("[info] The bot may send messages to the relay channel again")
+			}
+			return message, nil
+		case errors.As(err, &rateLimitErr):
+			self.rateLimited(session, rateLimitErr.RetryAfter)
+		case isForbiddenError(err):
+			if self.sendBackoff.Forbidden(time.Now()) {
+				self.alertAdmins(session, self.forbiddenAlert(session, err))
+			}
+			return nil, err
+		default:
+			self.sendBackoff.Failed()
+			return nil, err
+		}
+	}
+}
+
+// rateLimited holds back the messages to the relay channel after Discord
+// rate limited one, reporting the channel's slow mode if it has one.
+func (self *BotContext) rateLimited(session *discordgo.Session, retryAfter time.Duration) {
+	channel, err := session.State.Channel(self.relayChannelId)
+	if err != nil {
+		channel, err = session.Channel(self.relayChannelId)
+	}
+	if err != nil || channel.RateLimitPerUser == 0 {
+		// A rate limit of the API, not the slow mode.
+		self.sendBackoff.SlowMode(retryAfter, time.Now())
+		return
+	}
+	if self.sendBackoff.SlowMode(retryAfter, time.Now()) {
+		self.alertAdmins(session, fmt.Sprintf(
+			"<#%v> has slow mode enabled (one message per %v), so messages to Discord are delayed. "+
+				"Give the bot the Manage Messages or Manage Channels permission in the channel to exempt it.",
+			self.relayChannelId, time.Duration(channel.RateLimitPerUser)*time.Second))
+	}
+}
+
+// isForbiddenError reports whether err is a Discord API error for missing
+// permissions or access (403).
+func isForbiddenError(err error) bool {
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusForbidden
+}
+
+// forbiddenAlert describes the permissions the bot lacks in the relay channel,
+// or the error if they can't be determined.
+func (self *BotContext) forbiddenAlert(session *discordgo.Session, err error) string {
+	var missing []string
+	if user := session.State.User; user != nil {
+		permissions, permErr := session.State.UserChannelPermissions(user.ID, self.relayChannelId)
+		if permErr != nil {
+			permissions, permErr = session.UserChannelPermissions(user.ID, self.relayChannelId)
+		}
+		if permErr == nil {
+			for _, required := range relayPermissions {
+				if permissions&required.permission == 0 {
+					missing = append(missing, required.name)
+				}
+			}
+		}
+	}
+	problem := fmt.Sprintf("Discord rejected a message: %v", err)
+	if len(missing) > 0 {
+		problem = fmt.Sprintf("The bot lacks the %v permission in the channel.", strings.Join(missing, " and "))
+	}
+	return fmt.Sprintf("The bot can't send messages to <#%v>. %v "+
+		"Messages to Discord are dropped until it can, see --dead_letter_file; "+
+		"sending is retried with a growing backoff of up to %v.",
+		self.relayChannelId, problem, forbiddenBackoff.MaxDelay)
+}
+
+// alertAdmins reports a problem of the bridge to the admins: to the error
+// channel, or to the alert users if there is none.
+func (self *BotContext) alertAdmins(session *discordgo.Session, content string) {
+	log.Printf("[warning] %v\n", content)
+	if self.errorChannelId == "" {
+		self.sendAlert(session, content)
+		return
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       "Bridge problem",
+		Description: content,
+		Color:       errorReportColor,
+		Footer:      self.statusFooter(),
+		Timestamp:   time.Now().Format(time.RFC3339),
+	}
+	if _, err := session.ChannelMessageSendEmbed(self.errorChannelId, embed); err != nil {
+		log.Printf("[error] error posting alert to the error channel: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testGuildId        = "100000000000000001"
+	testRelayChannelId = "100000000000000002"
+	testErrorChannelId = "100000000000000003"
+	testBotId          = "100000000000000004"
+)
+
+// fakeDiscordApi answers the messages sent to channels with the statuses of
+// the test, in order, and records the channel each was sent to.
+type fakeDiscordApi struct {
+	mutex    sync.Mutex
+	statuses []int // 200 once exhausted
+	sent     []string
+}
+
+func (self *fakeDiscordApi) RoundTrip(request *http.Request) (*http.Response, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	channelId, _, _ := strings.Cut(strings.TrimPrefix(request.URL.Path, "/api/v9/channels/"), "/")
+	self.sent = append(self.sent, channelId)
+	status, body := http.StatusOK, `{"id": "100000000000000009"}`
+	if len(self.statuses) > 0 {
+		status, self.statuses = self.statuses[0], self.statuses[1:]
+	}
+	switch status {
+	case http.StatusTooManyRequests:
+		body = `{"message": "You are being rate limited.", "retry_after": 0, "global": false}`
+	case http.StatusForbidden:
+		body = `{"message": "Missing Permissions", "code": 50013}`
+	}
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{}, Request: request}, nil
+}
+
+// requests returns the channels messages were sent to, and forgets them.
+func (self *fakeDiscordApi) requests() []string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	sent := self.sent
+	self.sent = nil
+	return sent
+}
+
+// newPermissionsTest returns a bot whose relay channel has a slow mode of
+// slowMode seconds, and whose session sends through api. The bot may view the
+// channel, but only send to it with sendAllowed.
+func newPermissionsTest(t *testing.T, api *fakeDiscordApi, slowMode int, sendAllowed bool) (*BotContext, *discordgo.Session) {
+	t.Helper()
+	session, err := discordgo.New("Bot token")
+	assert.NoError(t, err)
+	session.Client = &http.Client{Transport: api}
+	session.State.User = &discordgo.User{ID: testBotId}
+	permissions := int64(discordgo.PermissionViewChannel)
+	if sendAllowed {
+		permissions |= discordgo.PermissionSendMessages
+	}
+	assert.NoError(t, session.State.GuildAdd(&discordgo.Guild{
+		ID:       testGuildId,
+		Roles:    []*discordgo.Role{{ID: testGuildId, Permissions: permissions}},
+		Channels: []*discordgo.Channel{{ID: testRelayChannelId, GuildID: testGuildId, RateLimitPerUser: slowMode}},
+	}))
+	assert.NoError(t, session.State.MemberAdd(&discordgo.Member{GuildID: testGuildId, User: &discordgo.User{ID: testBotId}}))
+	bot := &BotContext{
+		relayChannelId: testRelayChannelId,
+		errorChannelId: testErrorChannelId,
+		sendBackoff:    lib.NewSendBackoff(forbiddenBackoff),
+	}
+	return bot, session
+}
+
+func TestSendMessageForbidden(t *testing.T) {
+	api := &fakeDiscordApi{statuses: []int{http.StatusForbidden}}
+	bot, session := newPermissionsTest(t, api, 0, false)
+
+	_, err := bot.sendMessage(session, "first")
+	assert.True(t, isForbiddenError(err))
+	// The alert is posted to the error channel.
+	assert.Equal(t, []string{testRelayChannelId, testErrorChannelId}, api.requests())
+
+	// Messages are dropped during the backoff, without sending them.
+	_, err = bot.sendMessage(session, "second")
+	assert.True(t, errors.Is(err, errSendForbidden))
+	assert.Empty(t, api.requests())
+}
+
+func TestSendMessageRateLimited(t *testing.T) {
+	api := &fakeDiscordApi{statuses: []int{http.StatusTooManyRequests}}
+	bot, session := newPermissionsTest(t, api, 10, true)
+
+	start := time.Now()
+	message, err := bot.sendMessage(session, "hello")
+	assert.NoError(t, err)
+	assert.NotNil(t, message)
+	// Retries after 0s wait, and the slow mode is reported.
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Equal(t, []string{testRelayChannelId, testErrorChannelId, testRelayChannelId}, api.requests())
+}
+
+func TestRateLimited(t *testing.T) {
+	tests := []struct {
+		slowMode int
+		alerted  bool
+	}{
+		{0, false}, // A rate limit of the API
+		{10, true},
+	}
+	for i, test := range tests {
+		api := &fakeDiscordApi{}
+		bot, session := newPermissionsTest(t, api, test.slowMode, true)
+		bot.rateLimited(session, 0)
+		assert.Equal(t, test.alerted, len(api.requests()) == 1, "Test #%v", i)
+		wait, drop := bot.sendBackoff.Hold(time.Now())
+		assert.Positive(t, wait, "Test #%v", i)
+		assert.False(t, drop, "Test #%v", i)
+	}
+}
+
+func TestForbiddenAlert(t *testing.T) {
+	bot, session := newPermissionsTest(t, &fakeDiscordApi{}, 0, false)
+	alert := bot.forbiddenAlert(session, errors.New("403 Forbidden"))
+	assert.Contains(t, alert, "The bot lacks the Send Messages permission in the channel.")
+
+	// The error is reported if the bot has the permissions.
+	bot, session = newPermissionsTest(t, &fakeDiscordApi{}, 0, true)
+	alert = bot.forbiddenAlert(session, errors.New("403 Forbidden"))
+	assert.Contains(t, alert, "Discord rejected a message: 403 Forbidden")
+}
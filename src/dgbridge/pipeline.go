@@ -0,0 +1,179 @@
+package main
+
+// This file builds the pipelines of the bridge, see lib.Pipeline: the stages
+// that subprocess lines flow through on their way to Discord, and those that
+// Discord messages flow through on their way to the subprocess.
+
+import (
+	"dgbridge/src/lib"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// relayToDiscordPipeline returns the pipeline of subprocess lines relayed to
+// Discord, see startRelayJob.
+func (self *BotContext) relayToDiscordPipeline(session *discordgo.Session) *lib.Pipeline {
+	pipeline := lib.NewPipeline(
+		lib.Tap("startup window", func(event *lib.Event) {
+			if self.summary != nil && event.Received.After(self.summary.Until()) {
+				// Send the summary before the first line after the startup window.
+				self.sendSummary(session)
+			}
+		}),
+		lib.Stage{Name: "incident threads", Process: func(event *lib.Event) bool {
+			if threadId := self.threads.Route(event.Raw, time.Now()); threadId != "" {
+				self.sendToThread(session, threadId, event.Raw)
+				return false
+			}
+			return true
+		}},
+	).Then(self.matchStages(self.playerProps)...)
+	return pipeline.Then(
+		lib.Tap("publish", self.publish),
+		lib.Tap("player count", func(event *lib.Event) {
+			if count, ok := event.Match.PlayerCount(event.Raw); ok && self.daily != nil {
+				self.daily.stats.RecordPlayers(count)
+			}
+		}),
+		lib.Tap("alerts", func(event *lib.Event) {
+			if event.Match.Rule.Critical {
+				// Alerts are sent even while relaying is paused or rate limited.
+				go runRecovered("critical alert", func() { self.sendAlert(session, event.Match.Result) })
+			}
+		}),
+		lib.Tap("scheduled events", func(event *lib.Event) {
+			if event.Match.Rule.Event != nil {
+				// Like alerts, events are scheduled whether or not the line is relayed.
+				go runRecovered("scheduled event", func() { self.scheduleEvent(session, event.Match, event.Raw, event.Received) })
+			}
+		}),
+		lib.Stage{Name: "startup summary", Process: func(event *lib.Event) bool {
+			return self.summary == nil || !self.summary.Count(event.Match, event.Received)
+		}},
+		lib.Stage{Name: "pause", Process: func(event *lib.Event) bool {
+			return self.pause.waitSubprocessToDiscord()
+		}},
+		lib.Stage{Name: "repeats", Process: func(event *lib.Event) bool {
+			if self.repeats == nil {
+				return true
+			}
+			now := time.Now()
+			self.sendRepeatNotices(session, now)
+			return self.repeats.Allow(event.Transformed, now)
+		}},
+		lib.Stage{Name: "rate limit", Process: func(event *lib.Event) bool {
+			limiter := self.limiters[lib.DirectionSubprocessToDiscord]
+			if limiter == nil {
+				return true
+			}
+			relay, suppressed := limiter.Wait()
+			if !relay {
+				return false
+			}
+			if suppressed > 0 {
+				log.Printf("[warning] rate limit: %v messages to Discord suppressed\n", suppressed)
+				if _, err := self.sendMessage(session, limiter.Summary(suppressed, lib.DefaultRateLimitSummary)); err != nil {
+					log.Printf("error sending message to discord: %v", err)
+				}
+			}
+			return true
+		}},
+		lib.Stage{Name: "delivery", Process: func(event *lib.Event) bool {
+			event.Transformed = lib.ApplyUserTags(self.userMap.get(), event.Transformed)
+			event.Transformed = lib.ResolveEmojis(event.Transformed, self.emojis.get())
+			message, err := self.deliverMessage(session, event.Transformed, event.RuleId())
+			if errors.Is(err, errSendForbidden) {
+				// Reported once, see alertAdmins.
+				return false
+			}
+			if err != nil {
+				log.Printf("[error] error sending message of rule %v to discord, dropping it: %v", event.RuleId(), err)
+				reportRuleError(lib.MsgErrorKindDiscord, event.RuleId(), err)
+				return false
+			}
+			self.recordJournal(event.Direction, event.Match.Player(event.Raw), event.Match.Result)
+			self.recordDailyMessage(event.Direction, event.Match.Player(event.Raw))
+			if event.Match.Rule.Thread != nil {
+				self.openThread(session, message, event.Match, event.Raw)
+			}
+			return true
+		}},
+	)
+}
+
+// relayToSubprocessPipeline returns the pipeline of Discord messages relayed
+// to the subprocess, see messageCreate. The results of rules with
+// RequireApproval leave it at the approval stage, and continue through the
+// subprocessDeliveryStages once approved, see approveCommand.
+func (self *BotContext) relayToSubprocessPipeline(session *discordgo.Session) *lib.Pipeline {
+	return lib.NewPipeline(self.matchStages(nil)...).Then(
+		lib.Stage{Name: "approval", Process: func(event *lib.Event) bool {
+			if !event.Match.Rule.RequireApproval {
+				return true
+			}
+			self.requestApproval(session, event)
+			return false
+		}},
+	).Then(self.subprocessDeliveryStages()...)
+}
+
+// subprocessDeliveryStages returns the stages that write Discord messages to
+// the subprocess, once they were approved if their rule requires it. Messages
+// are published to the sinks here, so that rejected ones aren't.
+func (self *BotContext) subprocessDeliveryStages() []lib.Stage {
+	return []lib.Stage{
+		lib.Tap("publish", self.publish),
+		{Name: "pause", Process: func(event *lib.Event) bool {
+			return self.pause.admitDiscordToSubprocess(eventStdinLine(event))
+		}},
+		lib.Tap("journal", func(event *lib.Event) {
+			author := event.Props.Author.Nickname
+			if author == "" {
+				author = event.Props.Author.Username
+			}
+			self.recordJournal(event.Direction, author, event.Raw)
+		}),
+		lib.Tap("delivery", func(event *lib.Event) {
+			line := eventStdinLine(event)
+			if self.limiters[lib.DirectionDiscordToSubprocess] != nil {
+				// Relayed by startStdinJob at the rate limit
+				self.stdinMutex.Lock()
+				self.stdinQueue.Push(line)
+				self.stdinMutex.Unlock()
+				return
+			}
+			self.subprocess.WriteLine(line)
+		}),
+		lib.Tap("daily summary", func(event *lib.Event) {
+			self.recordDailyMessage(event.Direction, event.Props.Author.Id)
+		}),
+	}
+}
+
+// matchStages returns the stages that match the rules, ending with the stage
+// that drops events no rule matched.
+//
+// Parameters:
+//
+//	resolve: returns the Props of a player, may be nil
+func (self *BotContext) matchStages(resolve lib.PropsResolver) []lib.Stage {
+	stages := []lib.Stage{
+		lib.MatchStage(self.rules.Load, resolve),
+		lib.Tap("debug echo", func(event *lib.Event) {
+			self.debugEcho.Echo(event.Direction, event.Raw, event.Match)
+		}),
+	}
+	if self.shadow != nil {
+		stages = append(stages, self.shadow.stage(resolve))
+	}
+	return append(stages, lib.RequireMatch())
+}
+
+// eventStdinLine returns the line written to the subprocess for an event that
+// a rule matched.
+func eventStdinLine(event *lib.Event) stdinLine {
+	return stdinLine{Instance: event.Match.InstanceName(event.Raw), Text: event.Transformed}
+}
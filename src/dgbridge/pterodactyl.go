@@ -0,0 +1,247 @@
+package main
+
+// This file implements relaying the console of a server on a Pterodactyl
+// panel, see --pterodactyl_url. The console is read and written through the
+// websocket of the server's Wings daemon, which the panel's client API grants
+// access to.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Events of the Wings websocket.
+const (
+	pterodactylEventAuth          = "auth"
+	pterodactylEventAuthSuccess   = "auth success"
+	pterodactylEventConsoleOutput = "console output"
+	pterodactylEventDaemonMessage = "daemon message"
+	pterodactylEventStatus        = "status"
+	pterodactylEventSendCommand   = "send command"
+	pterodactylEventTokenExpiring = "token expiring"
+	pterodactylEventTokenExpired  = "token expired"
+	pterodactylEventJwtError      = "jwt error"
+	pterodactylEventDaemonError   = "daemon error"
+)
+
+// pterodactylStates maps the server statuses reported by Wings to process
+// states.
+var pterodactylStates = map[string]string{
+	"starting": lib.ProcessStateStarting,
+	"running":  lib.ProcessStateReady,
+	"stopping": lib.ProcessStateStopping,
+}
+
+// pterodactylEvent is a message of the Wings websocket.
+type pterodactylEvent struct {
+	Event string   `json:"event"`
+	Args  []string `json:"args,omitempty"`
+}
+
+// pterodactylCredentials grant access to the websocket of a server, see
+// fetchCredentials.
+type pterodactylCredentials struct {
+	Data struct {
+		Token  string `json:"token"`
+		Socket string `json:"socket"`
+	} `json:"data"`
+}
+
+// PterodactylConsole is the Console of a server on a Pterodactyl panel. When
+// the connection is lost, it reconnects. Stopping it only disconnects; the
+// server keeps running.
+type PterodactylConsole struct {
+	remoteConsole
+	panelUrl string // e.g. https://panel.example.com
+	serverId string
+	apiKey   string
+	client   *http.Client
+
+	mutex sync.Mutex // Guards conn, only one goroutine may write to it at a time
+	conn  *websocket.Conn
+}
+
+// NewPterodactylConsole creates the console of a Pterodactyl server. It is not
+// connected until started.
+//
+// Parameters:
+//
+//	panelUrl: URL of the panel
+//	serverId: identifier of the server, as shown in its URL on the panel
+//	apiKey: client API key of a user with access to the server's console
+func NewPterodactylConsole(panelUrl string, serverId string, apiKey string) *PterodactylConsole {
+	return &PterodactylConsole{
+		remoteConsole: newRemoteConsole(),
+		panelUrl:      strings.TrimSuffix(panelUrl, "/"),
+		serverId:      serverId,
+		apiKey:        apiKey,
+		client:        newRemoteConsoleClient(false),
+	}
+}
+
+// newPterodactylConsole creates the console configured with command line
+// arguments, and relays its output to the terminal.
+func newPterodactylConsole(args CliArgs, rules *lib.Rules) Console {
+	if args.PterodactylServer == "" || args.PterodactylKey == "" {
+		log.Fatalln("[fatal] --pterodactyl_url requires --pterodactyl_server and --pterodactyl_key")
+	}
+	console := NewPterodactylConsole(args.PterodactylUrl, args.PterodactylServer, args.PterodactylKey)
+	console.States = lib.NewProcessStateTracker(rules.States)
+	go superviseJob("relay of the console", func() { relayConsoleOutput(console) })
+	return console
+}
+
+// Start connects to the console in the background.
+func (self *PterodactylConsole) Start() error {
+	go superviseJob("Pterodactyl console", self.run)
+	return nil
+}
+
+// Stop disconnects from the console.
+func (self *PterodactylConsole) Stop() {
+	self.stop(func() {
+		self.mutex.Lock()
+		defer self.mutex.Unlock()
+		if self.conn != nil {
+			_ = self.conn.Close()
+		}
+	})
+}
+
+// WriteLine sends a command to the server. Commands are dropped while the
+// console is disconnected.
+func (self *PterodactylConsole) WriteLine(text string) {
+	if !self.send(pterodactylEvent{Event: pterodactylEventSendCommand, Args: []string{text}}) {
+		logDroppedCommand("Pterodactyl", text)
+	}
+}
+
+// run connects to the console, and reconnects whenever the connection is lost,
+// until the console is stopped.
+func (self *PterodactylConsole) run() {
+	for !self.isStopped() {
+		err := self.connect()
+		if self.isStopped() {
+			return
+		}
+		log.Printf("[error] Pterodactyl console of server %v disconnected: %v", self.serverId, err)
+		if !self.sleep() {
+			return
+		}
+	}
+}
+
+// connect connects to the console and relays its events until the
+// connection is lost.
+func (self *PterodactylConsole) connect() error {
+	credentials, err := self.fetchCredentials()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	// Wings only accepts connections from the panel's origin.
+	header.Set("Origin", self.panelUrl)
+	conn, _, err := websocket.DefaultDialer.Dial(credentials.Data.Socket, header)
+	if err != nil {
+		return fmt.Errorf("error connecting to %v: %v", credentials.Data.Socket, err)
+	}
+	self.mutex.Lock()
+	self.conn = conn
+	self.mutex.Unlock()
+	defer func() {
+		self.mutex.Lock()
+		self.conn = nil
+		self.mutex.Unlock()
+		_ = conn.Close()
+	}()
+	if self.isStopped() {
+		return nil
+	}
+	if !self.send(pterodactylEvent{Event: pterodactylEventAuth, Args: []string{credentials.Data.Token}}) {
+		return fmt.Errorf("error authenticating")
+	}
+
+	for {
+		var event pterodactylEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			return err
+		}
+		if err := self.handleEvent(event); err != nil {
+			return err
+		}
+	}
+}
+
+// handleEvent handles an event received from the websocket.
+func (self *PterodactylConsole) handleEvent(event pterodactylEvent) error {
+	switch event.Event {
+	case pterodactylEventAuthSuccess:
+		log.Printf("[info] Connected to the Pterodactyl console of server %v\n", self.serverId)
+	case pterodactylEventConsoleOutput, pterodactylEventDaemonMessage:
+		// Daemon messages are Wings' own notices, e.g. "Server marked as
+		// running", like a wrapper script would print them.
+		for _, text := range event.Args {
+			for _, line := range strings.Split(strings.TrimRight(text, "\r\n"), "\n") {
+				self.emitLine(strings.TrimSuffix(line, "\r"), false)
+			}
+		}
+	case pterodactylEventStatus:
+		if len(event.Args) > 0 && self.States != nil {
+			if state, ok := pterodactylStates[event.Args[0]]; ok {
+				self.States.Set(state)
+			}
+		}
+	case pterodactylEventTokenExpiring:
+		credentials, err := self.fetchCredentials()
+		if err != nil {
+			return fmt.Errorf("error renewing token: %v", err)
+		}
+		self.send(pterodactylEvent{Event: pterodactylEventAuth, Args: []string{credentials.Data.Token}})
+	case pterodactylEventTokenExpired, pterodactylEventJwtError:
+		return fmt.Errorf("%v: %v", event.Event, strings.Join(event.Args, " "))
+	case pterodactylEventDaemonError:
+		log.Printf("[error] Pterodactyl daemon error: %v", strings.Join(event.Args, " "))
+	}
+	return nil
+}
+
+// send sends an event to the websocket.
+//
+// Returns:
+//
+//	false if the console is disconnected or sending failed
+func (self *PterodactylConsole) send(event pterodactylEvent) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.conn == nil {
+		return false
+	}
+	if err := self.conn.WriteJSON(event); err != nil {
+		log.Printf("[error] error writing to the Pterodactyl console: %v", err)
+		reportError(lib.MsgErrorKindServer, err)
+		return false
+	}
+	return true
+}
+
+// fetchCredentials fetches the URL of the server's websocket and a token to
+// authenticate with from the panel. Tokens expire after a few minutes.
+func (self *PterodactylConsole) fetchCredentials() (*pterodactylCredentials, error) {
+	url := fmt.Sprintf("%v/api/client/servers/%v/websocket", self.panelUrl, self.serverId)
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+self.apiKey)
+	var credentials pterodactylCredentials
+	if err := doJSON(self.client, request, &credentials); err != nil {
+		return nil, fmt.Errorf("error fetching websocket credentials of server %v: %v", self.serverId, err)
+	}
+	return &credentials, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWings serves the websocket credentials of a panel and the websocket of
+// Wings, sending the events of the test once authenticated.
+type fakeWings struct {
+	server   *httptest.Server
+	events   []pterodactylEvent // Sent once authenticated
+	received chan pterodactylEvent
+}
+
+func newFakeWings(t *testing.T, events []pterodactylEvent) *fakeWings {
+	self := &fakeWings{events: events, received: make(chan pterodactylEvent, 16)}
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/client/servers/abc/websocket", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var credentials pterodactylCredentials
+		credentials.Data.Token = "token"
+		credentials.Data.Socket = "ws" + strings.TrimPrefix(self.server.URL, "http") + "/ws"
+		_ = json.NewEncoder(w).Encode(credentials)
+	})
+	mux.HandleFunc("GET /ws", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, self.server.URL, r.Header.Get("Origin"))
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var auth pterodactylEvent
+		if conn.ReadJSON(&auth) != nil || auth.Event != pterodactylEventAuth || auth.Args[0] != "token" {
+			_ = conn.WriteJSON(pterodactylEvent{Event: pterodactylEventJwtError, Args: []string{"invalid token"}})
+			return
+		}
+		_ = conn.WriteJSON(pterodactylEvent{Event: pterodactylEventAuthSuccess})
+		for _, event := range self.events {
+			_ = conn.WriteJSON(event)
+		}
+		for {
+			var event pterodactylEvent
+			if conn.ReadJSON(&event) != nil {
+				return
+			}
+			self.received <- event
+		}
+	})
+	self.server = httptest.NewServer(mux)
+	return self
+}
+
+// nextLine returns the next line emitted by a console, failing the test if
+// there is none within a second.
+func nextLine(t *testing.T, lines <-chan OutputLine) OutputLine {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("no line was emitted by the console")
+		return OutputLine{}
+	}
+}
+
+func TestPterodactylConsole(t *testing.T) {
+	wings := newFakeWings(t, []pterodactylEvent{
+		{Event: pterodactylEventStatus, Args: []string{"running"}},
+		{Event: pterodactylEventConsoleOutput, Args: []string{"[Server] Done\r\n"}},
+		{Event: pterodactylEventDaemonMessage, Args: []string{"Server marked as running\nUpdating process configuration"}},
+	})
+	defer wings.server.Close()
+
+	console := NewPterodactylConsole(wings.server.URL+"/", "abc", "key")
+	console.Name = "survival"
+	console.States = lib.NewProcessStateTracker(nil)
+	console.States.Set(lib.ProcessStateStarting)
+	lines := console.Output().Listen()
+	exited := console.Exited().Listen()
+	assert.NoError(t, console.Start())
+
+	for i, expected := range []string{"[Server] Done", "Server marked as running", "Updating process configuration"} {
+		line := nextLine(t, lines)
+		assert.Equal(t, expected, line.Text, "Test #%v", i)
+		assert.Equal(t, "survival", line.Instance, "Test #%v", i)
+	}
+	assert.Equal(t, lib.ProcessStateReady, console.States.State())
+
+	console.WriteLine("list")
+	select {
+	case event := <-wings.received:
+		assert.Equal(t, pterodactylEvent{Event: pterodactylEventSendCommand, Args: []string{"list"}}, event)
+	case <-time.After(time.Second):
+		t.Fatal("no command was sent to the console")
+	}
+
+	console.Stop()
+	select {
+	case code := <-exited:
+		assert.Equal(t, 0, code)
+	case <-time.After(time.Second):
+		t.Fatal("the console didn't exit")
+	}
+	// Commands are dropped once disconnected.
+	assert.False(t, console.send(pterodactylEvent{Event: pterodactylEventSendCommand, Args: []string{"list"}}))
+}
+
+func TestPterodactylCredentials(t *testing.T) {
+	wings := newFakeWings(t, nil)
+	defer wings.server.Close()
+
+	credentials, err := NewPterodactylConsole(wings.server.URL, "abc", "key").fetchCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "token", credentials.Data.Token)
+	_, err = NewPterodactylConsole(wings.server.URL, "abc", "wrong").fetchCredentials()
+	assert.Error(t, err)
+	_, err = NewPterodactylConsole(wings.server.URL, "missing", "key").fetchCredentials()
+	assert.Error(t, err)
+}
+
+func TestPterodactylHandleEvent(t *testing.T) {
+	console := NewPterodactylConsole("https://panel.example.com", "abc", "key")
+	tests := []struct {
+		Event   pterodactylEvent
+		IsError bool
+	}{
+		{pterodactylEvent{Event: pterodactylEventAuthSuccess}, false},
+		{pterodactylEvent{Event: pterodactylEventStatus}, false},
+		{pterodactylEvent{Event: pterodactylEventDaemonError, Args: []string{"out of disk"}}, false},
+		{pterodactylEvent{Event: pterodactylEventTokenExpired}, true},
+		{pterodactylEvent{Event: pterodactylEventJwtError, Args: []string{"invalid token"}}, true},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.IsError, console.handleEvent(test.Event) != nil, "Test #%v", i)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+// This file implements polling the game server's query protocol, see
+// --query_address. The status is shown in the bot's presence and the relay
+// channel's topic, and with /players.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// queryTimeout is the timeout of a query of the server.
+const queryTimeout = 5 * time.Second
+
+// topicUpdateInterval is the minimum time between updates of the relay
+// channel's topic. Discord only allows two updates every ten minutes.
+const topicUpdateInterval = 5 * time.Minute
+
+// serverQuery polls the status of the server.
+type serverQuery struct {
+	protocol         string // See lib.QueryServer
+	addr             string
+	interval         time.Duration // Time between queries
+	presenceTemplate string        // See lib.FormatServerStatus, empty to leave the presence alone
+	topicTemplate    string        // See lib.FormatServerStatus, empty to leave the topic alone
+
+	mutex  sync.Mutex
+	status lib.ServerStatus // Result of the last successful query
+	online bool             // Whether the last query succeeded
+	polled bool             // Whether the server was queried yet
+}
+
+// newServerQuery creates the server query configured with command line
+// arguments, checking the protocol.
+func newServerQuery(args CliArgs) *serverQuery {
+	switch args.QueryProtocol {
+	case lib.QueryProtocolMinecraft, lib.QueryProtocolMinecraftQuery, lib.QueryProtocolA2S:
+	default:
+		log.Fatalf("[fatal] --query_protocol: expected %v, %v or %v\n",
+			lib.QueryProtocolMinecraft, lib.QueryProtocolMinecraftQuery, lib.QueryProtocolA2S)
+	}
+	if args.QueryInterval <= 0 {
+		log.Fatalln("[fatal] --query_interval must be positive")
+	}
+	return &serverQuery{
+		protocol:         args.QueryProtocol,
+		addr:             args.QueryAddress,
+		interval:         args.QueryInterval,
+		presenceTemplate: args.QueryPresence,
+		topicTemplate:    args.QueryTopic,
+	}
+}
+
+// poll queries the server, and saves the result.
+//
+// Returns:
+//
+//	the status, and whether the server responded
+func (self *serverQuery) poll() (lib.ServerStatus, bool) {
+	status, err := lib.QueryServer(self.protocol, self.addr, queryTimeout)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if err != nil {
+		if self.online || !self.polled {
+			log.Printf("[warning] server at %v isn't responding to queries: %v\n", self.addr, err)
+		}
+		self.online, self.polled = false, true
+		return lib.ServerStatus{}, false
+	}
+	if !self.online && self.polled {
+		log.Printf("[info] Server at %v is responding to queries again\n", self.addr)
+	}
+	self.status, self.online, self.polled = status, true, true
+	return status, true
+}
+
+// last returns the result of the last query.
+//
+// Returns:
+//
+//	the status, whether the server responded, and whether it was queried yet
+func (self *serverQuery) last() (lib.ServerStatus, bool, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.status, self.online, self.polled
+}
+
+// format formats the status with a template, or the offline notice if the
+// server didn't respond.
+func (self *serverQuery) format(template string, status lib.ServerStatus, online bool) string {
+	if !online {
+		return lib.Tr(lib.MsgServerOffline)
+	}
+	return lib.FormatServerStatus(template, status)
+}
+
+// startQueryJob queries the server periodically, and shows its status in the
+// bot's presence and the relay channel's topic.
+func (self *BotContext) startQueryJob(s *discordgo.Session) {
+	query := self.query
+	var lastPresence, lastTopic string
+	var lastTopicUpdate time.Time
+	ticker := time.NewTicker(query.interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		status, online := query.poll()
+		if online && self.daily != nil {
+			self.daily.stats.RecordPlayers(status.Players)
+		}
+		// Maintenance mode shows its own presence, and clears it once it ends.
+		if self.maintenance.active() {
+			lastPresence = ""
+		} else if query.presenceTemplate != "" {
+			presence := query.format(query.presenceTemplate, status, online)
+			if presence != lastPresence {
+				if err := s.UpdateCustomStatus(presence); err != nil {
+					log.Printf("[error] error updating presence: %v", err)
+				} else {
+					lastPresence = presence
+				}
+			}
+		}
+		if query.topicTemplate != "" && time.Since(lastTopicUpdate) >= topicUpdateInterval {
+			topic := query.format(query.topicTemplate, status, online)
+			if topic != lastTopic {
+				_, err := s.ChannelEdit(self.relayChannelId, &discordgo.ChannelEdit{Topic: topic})
+				if err != nil {
+					log.Printf("[error] error updating channel topic: %v", err)
+				} else {
+					lastTopic = topic
+				}
+				lastTopicUpdate = time.Now()
+			}
+		}
+	}
+}
+
+// playersCommand handles /players.
+func (self *BotContext) playersCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	status, online, polled := self.query.last()
+	if !polled {
+		status, online = self.query.poll()
+	}
+	if !online {
+		self.respond(s, i, "The server isn't responding.")
+		return
+	}
+	message := fmt.Sprintf("%v of %v players online", status.Players, status.MaxPlayers)
+	if status.Motd != "" {
+		message = status.Motd + "\n" + message
+	}
+	// Leave room for the number of names left out.
+	var names []string
+	length := len(message) + 2
+	for _, name := range status.Names {
+		length += len(name) + 2
+		if length > lib.DiscordMessageLimit-30 {
+			break
+		}
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		message += ": " + strings.Join(names, ", ")
+		if len(names) < status.Players {
+			message += fmt.Sprintf(" and %v more", status.Players-len(names))
+		}
+	}
+	self.respond(s, i, message)
+}
@@ -0,0 +1,110 @@
+package main
+
+// This file implements recovering from panics in the jobs and Discord event
+// handlers of the bridge, see --crash_dump_dir.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// jobRestartDelay is the time before a job that panicked is restarted. It keeps
+// a job that panics on every line from filling the log.
+const jobRestartDelay = time.Second
+
+// crashDumpDir is the directory crash dumps are written to, see
+// --crash_dump_dir. Empty if crash dumps are disabled.
+var crashDumpDir string
+
+// crashDumpNameExpr matches the characters of a job name that are replaced in
+// the name of its crash dumps.
+var crashDumpNameExpr = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// superviseJob runs a long-running job, restarting it whenever it panics, so
+// that a single bad line or event doesn't stop relaying for good.
+// Returns once the job returns without panicking.
+//
+// Parameters:
+//
+//	job: name of the job in log messages and crash dumps, e.g. "relay to Discord"
+//	run: runs the job
+func superviseJob(job string, run func()) {
+	for runRecovered(job, run) {
+		log.Printf("[info] Restarting %v in %v\n", job, jobRestartDelay)
+		time.Sleep(jobRestartDelay)
+	}
+}
+
+// runRecovered runs a job, recovering if it panics.
+//
+// Returns:
+//
+//	whether the job panicked
+func runRecovered(job string, run func()) (panicked bool) {
+	defer func() {
+		if value := recover(); value != nil {
+			reportPanic(job, value, debug.Stack())
+			panicked = true
+		}
+	}()
+	run()
+	return false
+}
+
+// recoverPanic recovers from a panic in a Discord event handler, so that the
+// event is dropped instead of crashing the bridge. It must be deferred by the
+// handler itself.
+func recoverPanic(job string) {
+	if value := recover(); value != nil {
+		reportPanic(job, value, debug.Stack())
+	}
+}
+
+// reportPanic logs a recovered panic with its stack trace, reports it to the
+// error tracker and writes a crash dump if enabled.
+func reportPanic(job string, value any, stack []byte) {
+	log.Printf("[error] panic in %v: %v\n%s", job, value, stack)
+	tracker.capture(lib.ErrorEvent{
+		Time:    time.Now(),
+		Level:   lib.ErrorLevelFatal,
+		Kind:    "panic in " + job,
+		Message: fmt.Sprint(value),
+		Stack:   string(stack),
+	})
+	if crashDumpDir == "" {
+		return
+	}
+	path, err := writeCrashDump(crashDumpDir, job, value, stack, time.Now())
+	if err != nil {
+		log.Printf("[error] error writing crash dump: %v", err)
+		return
+	}
+	log.Printf("[info] Wrote crash dump %v\n", path)
+}
+
+// writeCrashDump writes a crash dump file, named after the job and the time of
+// the panic.
+//
+// Returns:
+//
+//	the path of the crash dump
+func writeCrashDump(dir string, job string, value any, stack []byte, now time.Time) (string, error) {
+	name := strings.Trim(crashDumpNameExpr.ReplaceAllString(strings.ToLower(job), "-"), "-")
+	path := filepath.Join(dir, fmt.Sprintf("crash-%v-%v.txt", now.UTC().Format("20060102T150405.000Z"), name))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	content := fmt.Sprintf("dgbridge %v\nTime: %v\nJob: %v\nPanic: %v\n\n%s",
+		versionString(), now.Format(time.RFC3339), job, value, stack)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteCrashDump(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 45, 123000000, time.UTC)
+	tests := []struct {
+		Job  string
+		Name string
+	}{
+		{"relay to Discord", "crash-20240301T123045.123Z-relay-to-discord.txt"},
+		{"Discord event: messageCreate", "crash-20240301T123045.123Z-discord-event-messagecreate.txt"},
+		{"../../etc/passwd", "crash-20240301T123045.123Z-etc-passwd.txt"},
+	}
+	for i, test := range tests {
+		dir := filepath.Join(t.TempDir(), "dumps")
+		path, err := writeCrashDump(dir, test.Job, "boom", []byte("goroutine 1 [running]:"), now)
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Equal(t, filepath.Join(dir, test.Name), path, "Test #%v", i)
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Contains(t, string(content), "Time: 2024-03-01T12:30:45Z\nJob: "+test.Job+"\nPanic: boom\n\ngoroutine 1 [running]:", "Test #%v", i)
+	}
+
+	// The directory can't be created under a file.
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0o644))
+	_, err := writeCrashDump(filepath.Join(file, "dumps"), "relay to Discord", "boom", nil, now)
+	assert.Error(t, err)
+}
+
+func TestRunRecoveredWritesCrashDump(t *testing.T) {
+	defer func(dir string) { crashDumpDir = dir }(crashDumpDir)
+	crashDumpDir = t.TempDir()
+
+	assert.False(t, runRecovered("quiet job", func() {}))
+	assert.True(t, runRecovered("panicking job", func() { panic("boom") }))
+
+	entries, err := os.ReadDir(crashDumpDir)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.True(t, strings.HasSuffix(entries[0].Name(), "-panicking-job.txt"))
+	}
+}
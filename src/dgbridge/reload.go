@@ -0,0 +1,256 @@
+package main
+
+// This file implements reloading the configuration while the bridge runs: on
+// SIGHUP (see --on_signal), when its files change (see --config_watch) or when
+// the rules at an HTTPS --rules URL change (see --rules_refresh), the
+// rules, the users file and the --filter_file are read and validated again,
+// then replace the current ones at once. If any of them is invalid, the
+// current configuration stays in use. A report of each reload is logged and
+// posted to --error_channel_id.
+
+import (
+	"dgbridge/src/lib"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// configReloader reloads the configuration the bridge was started with.
+type configReloader struct {
+	args      CliArgs
+	rulesPath string // File the rules are read from, the cache of an HTTPS --rules URL; empty with --bundle
+
+	mutex   sync.Mutex // Serializes reloads
+	bot     *BotContext
+	session *discordgo.Session
+}
+
+// reloadedConfig is a configuration that was read and validated again.
+type reloadedConfig struct {
+	rules      *lib.Rules
+	users      lib.UserMap
+	usersKey   []byte          // Key of the users file if it is encrypted, otherwise nil
+	filterList *lib.FilterList // nil without --filter_file
+}
+
+// newConfigReloader creates a configReloader for the configuration loaded on
+// startup.
+//
+// Parameters:
+//
+//	rulesPath: the file the rules were read from, empty with --bundle
+func newConfigReloader(args CliArgs, rulesPath string) *configReloader {
+	return &configReloader{args: args, rulesPath: rulesPath}
+}
+
+// attach makes the bot available to reloads, once the Discord session is
+// ready.
+func (self *configReloader) attach(bot *BotContext, session *discordgo.Session) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.bot = bot
+	self.session = session
+}
+
+// startSignalJob reloads the configuration whenever one of the signals is
+// received. Signals received during a reload are handled once it is done.
+func (self *configReloader) startSignalJob(signals []os.Signal) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, signals...)
+	for sig := range sigCh {
+		log.Printf("[info] Received signal %q, reloading the configuration\n", sig)
+		self.reload()
+	}
+}
+
+// startWatchJob reloads the configuration whenever the contents of one of its
+// files changed, see --config_watch and lib.ConfigWatch.
+func (self *configReloader) startWatchJob(interval time.Duration) {
+	watch := lib.NewConfigWatch(self.watchedPaths())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if watch.Changed() {
+			log.Println("[info] The configuration files changed, reloading the configuration")
+			self.reload()
+		}
+	}
+}
+
+// watchedPaths returns the files of the configuration, see startWatchJob. The
+// cache of an HTTPS --rules URL isn't watched, since startRulesRefreshJob
+// reloads the configuration when it changes.
+func (self *configReloader) watchedPaths() []string {
+	rulesPath := self.rulesPath
+	if lib.IsRemoteFile(self.args.RulesFile) {
+		rulesPath = ""
+	}
+	var paths []string
+	for _, path := range []string{self.args.Bundle, rulesPath, self.args.UsersFile, self.args.UsersKeyFile, self.args.FilterFile} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// reload reloads the configuration, and reports the result.
+func (self *configReloader) reload() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.bot == nil {
+		log.Println("[warning] the configuration can't be reloaded before the Discord session is ready")
+		return
+	}
+	var report string
+	config, err := self.load()
+	if err != nil {
+		log.Printf("[error] error reloading the configuration: %v\n", err)
+		report = fmt.Sprintf("Reloading the configuration failed, the current one stays in use: %v", err)
+	} else {
+		report = self.bot.applyConfig(self.session, config)
+		log.Printf("[info] %v\n", report)
+	}
+	if self.bot.errorChannelId != "" {
+		if _, err := self.session.ChannelMessageSend(self.bot.errorChannelId, report); err != nil {
+			log.Printf("[error] error posting reload report: %v\n", err)
+		}
+	}
+}
+
+// load reads and validates the configuration again, like on startup.
+func (self *configReloader) load() (reloadedConfig, error) {
+	var config reloadedConfig
+	var rulesFile, usersContents []byte
+	usersBundled := false
+	if self.args.Bundle != "" {
+		bundle, err := readBundle(self.args)
+		if err != nil {
+			return config, err
+		}
+		rulesFile = bundle.Files[lib.BundleRules]
+		usersContents, usersBundled = bundle.Files[lib.BundleUsers]
+	} else {
+		var err error
+		if rulesFile, err = os.ReadFile(self.rulesPath); err != nil {
+			return config, fmt.Errorf("error reading rules: %v", err)
+		}
+	}
+	rules, err := lib.ParseRules(rulesFile)
+	if err == nil {
+		err = validateRules(rules)
+	}
+	if err != nil {
+		return config, fmt.Errorf("invalid rules: %v", err)
+	}
+	if self.args.SelfTest {
+		if checked, failures := rules.CheckExamples(); len(failures) > 0 {
+			return config, fmt.Errorf("self-test: %v of %v rule examples failed, e.g. %v", len(failures), checked, failures[0])
+		}
+	}
+	config.rules = rules
+
+	if self.args.UsersFile != "" || usersBundled {
+		key, err := lib.LoadUserMapKey(self.args.UsersKeyFile)
+		if err != nil {
+			return config, err
+		}
+		if !usersBundled {
+			usersContents, err = os.ReadFile(self.args.UsersFile)
+		}
+		if err == nil {
+			config.users, err = lib.ParseUserMap(usersContents, key)
+		}
+		if err == nil {
+			err = errors.Join(config.users.Validate()...)
+		}
+		if err != nil {
+			return config, fmt.Errorf("error loading users: %v", err)
+		}
+		if lib.IsEncryptedUserMap(usersContents) {
+			config.usersKey = key
+		}
+	}
+
+	if self.args.FilterFile != "" {
+		if config.filterList, err = lib.LoadFilterList(self.args.FilterFile); err != nil {
+			return config, fmt.Errorf("error loading --filter_file: %v", err)
+		}
+	}
+	return config, nil
+}
+
+// applyConfig replaces the rules, the user map and the filter list with
+// reloaded ones. Sections of the rules that only apply on startup keep their
+// values, see lib.Rules.KeepStartupSections.
+//
+// Returns:
+//
+//	the report of the reload, e.g. "Reloaded the configuration: 14 rules (was
+//	12), 41 users (was 40)"
+func (self *BotContext) applyConfig(session *discordgo.Session, config reloadedConfig) string {
+	current := self.rules.Load()
+	rules := config.rules
+	unapplied := rules.KeepStartupSections(current)
+	list := self.filterList()
+	var entriesBefore int
+	if list != nil {
+		// The list keeps its identity, since the shadow rules share it.
+		entriesBefore = len(list.Entries())
+		list.Replace(config.filterList)
+		if rules.Filter == nil {
+			rules.Filter = &lib.Filter{}
+		}
+		rules.Filter.List = list
+	}
+	if self.summary != nil {
+		// Counts by rule index don't carry over to the reloaded rules, so the
+		// startup window ends. The summary isn't sent under the lock of the
+		// reload, since it waits while relaying to Discord is paused.
+		summary := self.summary.Flush()
+		go runRecovered("startup summary", func() { self.deliverSummary(session, summary) })
+	}
+	usersBefore := len(self.userMap.get())
+
+	self.rules.Store(rules)
+	self.stats.Reload(rules)
+	self.userMap.replace(config.users, config.usersKey)
+
+	report := fmt.Sprintf("Reloaded the configuration: %v rules (was %v), %v users (was %v)",
+		ruleCount(rules), ruleCount(current), len(config.users), usersBefore)
+	if list != nil {
+		report += fmt.Sprintf(", %v filter entries (was %v)", len(list.Entries()), entriesBefore)
+	}
+	if len(unapplied) > 0 {
+		report += fmt.Sprintf(". Changes to %v apply on the next start", strings.Join(unapplied, ", "))
+	}
+	return report
+}
+
+// ruleCount returns the number of rules relaying between Discord and the
+// subprocess.
+func ruleCount(rules *lib.Rules) int {
+	return len(rules.SubprocessToDiscord) + len(rules.DiscordToSubprocess)
+}
+
+// reloadSignals returns the signals whose action is SignalActionReload.
+func reloadSignals(args CliArgs) []os.Signal {
+	actions, err := parseSignalActions(args.OnSignal)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	var signals []os.Signal
+	for sig, name := range signalNames {
+		if actions[name].Kind == lib.SignalActionReload {
+			signals = append(signals, sig)
+		}
+	}
+	return signals
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyConfigWhilePaused(t *testing.T) {
+	rules := &lib.Rules{SubprocessToDiscord: []lib.Rule{{Action: lib.RuleActionSummarize, Summary: "^# plugins loaded"}}}
+	bot := &BotContext{
+		userMap: newUserDirectory(nil, "", nil),
+		pause:   newRelayPause(10),
+		stats:   lib.NewRuleStats(rules),
+		summary: lib.NewStartupSummary(rules.SubprocessToDiscord, time.Now().Add(time.Hour)),
+	}
+	bot.rules.Store(rules)
+	assert.True(t, bot.summary.Count(&lib.RuleMatch{Index: 0, Rule: &rules.SubprocessToDiscord[0]}, time.Now()))
+	bot.pause.pause(lib.DirectionSubprocessToDiscord, true)
+
+	// The reload doesn't wait for relaying to Discord to resume.
+	done := make(chan string)
+	go func() { done <- bot.applyConfig(nil, reloadedConfig{rules: &lib.Rules{}}) }()
+	select {
+	case report := <-done:
+		assert.Equal(t, "Reloaded the configuration: 0 rules (was 1), 0 users (was 0)", report)
+	case <-time.After(time.Second):
+		t.Fatal("the reload waited for relaying to Discord to resume")
+	}
+	assert.Empty(t, bot.summary.Flush())
+}
+
+func TestConfigReloaderLoadChecksRules(t *testing.T) {
+	tests := []struct {
+		contents string
+		isError  bool
+	}{
+		{`{"SubprocessToDiscord": [{"Name": "chat", "Match": "(.*)", "Template": "${1}"}], "DiscordToSubprocess": []}`, false},
+		{`{"SubprocessToDiscord": [
+			{"Name": "chat", "Match": "<(\\w+)> (.*)", "Template": "${2}"},
+			{"Name": "chat", "Match": "(.*)", "Template": "${1}"}
+		], "DiscordToSubprocess": []}`, true},
+		{`{"SubprocessToDiscord": [], "DiscordToSubprocess": [], "Routes": [{"Sinks": ["irc"]}]}`, true},
+	}
+	for i, test := range tests {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		assert.NoError(t, os.WriteFile(path, []byte(test.contents), 0o600), "Test #%v", i)
+		_, err := newConfigReloader(CliArgs{}, path).load()
+		assert.Equal(t, test.isError, err != nil, "Test #%v: %v", i, err)
+	}
+}
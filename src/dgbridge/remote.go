@@ -0,0 +1,68 @@
+package main
+
+// This file implements loading the rules file from an HTTPS URL, see --rules,
+// e.g. for hosting providers managing the rules of many bridges centrally.
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fetchRemoteRules fetches the rules file if --rules is an HTTPS URL. If the
+// URL is unreachable, the file cached by an earlier start is used.
+//
+// Returns:
+//
+//	the path of the rules file to load, and the remote file, or nil if --rules
+//	is a local path
+func fetchRemoteRules(args CliArgs) (string, *lib.RemoteFile) {
+	if !lib.IsRemoteFile(args.RulesFile) {
+		return args.RulesFile, nil
+	}
+	dir := args.RulesCacheDir
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			cacheDir = os.TempDir()
+		}
+		dir = filepath.Join(cacheDir, "dgbridge")
+	}
+	file := &lib.RemoteFile{
+		Url:       args.RulesFile,
+		CachePath: lib.RemoteCachePath(dir, args.RulesFile, ".rules.json"),
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		Validate: func(contents []byte) error {
+			_, err := lib.ParseRules(contents)
+			return err
+		},
+	}
+	if _, err := file.Fetch(); err != nil {
+		if !file.Cached() {
+			log.Fatalln("[fatal] error fetching rules:", err)
+		}
+		log.Printf("[warning] error fetching rules, using the cached ones from %v: %v\n", file.CachePath, err)
+	}
+	return file.CachePath, file
+}
+
+// startRulesRefreshJob fetches the rules file every interval, keeping the
+// cached file up to date, and reloads the configuration when it changed.
+func startRulesRefreshJob(file *lib.RemoteFile, interval time.Duration, reloader *configReloader) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed, err := file.Fetch()
+		if err != nil {
+			log.Println("[warning] error refreshing rules:", err)
+			continue
+		}
+		if changed {
+			log.Printf("[info] The rules at %v changed, reloading the configuration\n", file.Url)
+			reloader.reload()
+		}
+	}
+}
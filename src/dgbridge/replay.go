@@ -0,0 +1,99 @@
+package main
+
+// This file implements the "dgbridge replay-stdin" subcommand, which replays
+// a stdin transcript recorded with --stdin_transcript against a fresh
+// subprocess at the original pacing, e.g. to reproduce an issue that only
+// triggered under a specific sequence of commands from Discord.
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+type ReplayArgs struct {
+	Transcript string            `arg:"required,-t,--transcript" help:"Stdin transcript recorded with --stdin_transcript"`
+	Run        int               `arg:"--run" help:"Run of the subprocess in the transcript to replay, 1 for the first; defaults to the last"`
+	Speed      float64           `arg:"--speed" default:"1" help:"Speed of the replay, e.g. 2 for twice as fast"`
+	Vars       map[string]string `arg:"--var,separate" help:"Value for the command, e.g. --var MEMORY=4G"`
+	Shell      bool              `arg:"--shell" help:"Run the command with the system shell"`
+	Command    []string          `arg:"positional,required" help:"The command to run"`
+}
+
+// runReplayCommand runs "dgbridge replay-stdin". The subprocess keeps running
+// after the last line, with the terminal's stdin relayed to it, until it exits.
+//
+// Parameters:
+//
+//	argv: command line arguments following "replay-stdin"
+func runReplayCommand(argv []string) {
+	var args ReplayArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge replay-stdin",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+	if args.Speed <= 0 {
+		parser.Fail("--speed must be greater than 0")
+	}
+
+	file, err := os.Open(args.Transcript)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	runs, err := lib.ReadStdinTranscript(file)
+	_ = file.Close()
+	if err != nil {
+		log.Fatalln("[fatal] invalid transcript:", err)
+	}
+	if len(runs) == 0 {
+		log.Fatalln("[fatal] the transcript is empty")
+	}
+	index := len(runs) - 1
+	if args.Run != 0 {
+		if args.Run < 1 || args.Run > len(runs) {
+			log.Fatalf("[fatal] --run: the transcript has %v runs\n", len(runs))
+		}
+		index = args.Run - 1
+	}
+	run := runs[index]
+
+	bridgeArgs := CliArgs{Command: args.Command, Vars: args.Vars, Shell: args.Shell}
+	instance := NewSubprocess(commandArgv(bridgeArgs, args.Command), lib.CommandEnv(args.Vars))
+	go superviseJob("relay of stdout", func() { relaySubprocessStdout(&instance) })
+	go superviseJob("relay of stderr", func() { relaySubprocessStderr(&instance) })
+	subprocess := NewSubprocessGroup([]Console{&instance})
+	exitCh := subprocess.ExitEvent.Listen()
+	if err := subprocess.Start(); err != nil {
+		log.Fatalln("[fatal] error starting subprocess:", err)
+	}
+	log.Printf("[info] Replaying %v lines of run %v of %v, started %v\n",
+		len(run.Lines), index+1, len(runs), run.Started.Local().Format(time.DateTime))
+	go superviseJob("stdin replay", func() { replayStdin(subprocess, run, args.Speed) })
+	go superviseJob("relay of stdin", func() { relayStdinToSubprocessStdin(subprocess) })
+	os.Exit(<-exitCh)
+}
+
+// replayStdin writes the lines of a run to the subprocess, each at its offset
+// from the start of the run.
+//
+// Parameters:
+//
+//	speed: factor the offsets are divided by
+func replayStdin(subprocess *SubprocessGroup, run lib.StdinRun, speed float64) {
+	start := time.Now()
+	for _, line := range run.Lines {
+		offset := time.Duration(float64(run.Offset(line)) / speed)
+		time.Sleep(time.Until(start.Add(offset)))
+		log.Printf("[info] Replaying %q (at %v)\n", line.Text, offset.Round(time.Millisecond))
+		subprocess.WriteLine(stdinLine{Instance: line.Instance, Text: line.Text})
+	}
+	log.Println("[info] Replay finished, the subprocess keeps running until it exits")
+}
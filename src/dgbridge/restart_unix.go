@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSupported reports whether dgbridge can restart itself, see
+// restartBridge.
+func restartSupported(service bool) error {
+	return nil
+}
+
+// restartBridge replaces the dgbridge process with a new one with the same
+// arguments and environment. The process ID stays the same, so service
+// managers don't notice the restart.
+// Only returns on error.
+func restartBridge() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// restartSupported reports whether dgbridge can restart itself, see
+// restartBridge. A Windows service can't be restarted by starting a new
+// process, it must be restarted by the service manager.
+func restartSupported(service bool) error {
+	if service {
+		return fmt.Errorf("restarting isn't supported for Windows services, use 'dgbridge service restart'")
+	}
+	return nil
+}
+
+// restartBridge starts a new dgbridge process with the same arguments,
+// environment and standard streams. Windows can't replace the running process,
+// so the caller exits once the new process has started.
+func restartBridge() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Start()
+}
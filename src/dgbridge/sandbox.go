@@ -0,0 +1,95 @@
+package main
+
+// This file implements the sandbox of the subprocess, see --sandbox_nofile,
+// --sandbox_memory, --sandbox_cgroup and --no_new_privileges, so that dgbridge
+// can double as a minimal supervisor on hardened hosts. A sandboxed subprocess
+// is started through dgbridge sandbox-exec, which applies the limits to itself
+// and then replaces itself with the command, so that they apply before the
+// command runs. Sandboxing is only supported on Linux.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+)
+
+// SandboxArgs are the arguments of dgbridge sandbox-exec.
+type SandboxArgs struct {
+	NoFile          uint64   `arg:"--nofile" help:"Limit of open files (RLIMIT_NOFILE)"`
+	Cgroup          string   `arg:"--cgroup" help:"cgroup v2 directory to join, prepared by the bridge"`
+	NoNewPrivileges bool     `arg:"--no_new_privileges" help:"Set the no_new_privs flag, so that the command can't gain privileges"`
+	Command         []string `arg:"positional,required" help:"Command to run, after --"`
+}
+
+// sandboxEnabled reports whether any sandbox option is given.
+func sandboxEnabled(args CliArgs) bool {
+	return args.SandboxNoFile > 0 || args.SandboxMemory > 0 || args.SandboxCgroup != "" || args.NoNewPrivileges
+}
+
+// sandboxCgroup returns the cgroup of a subprocess instance: the
+// --sandbox_cgroup itself for a single subprocess, or a child cgroup named
+// after the instance, since cgroups with children can't have processes.
+func sandboxCgroup(args CliArgs, instance string) string {
+	if args.SandboxCgroup == "" || instance == "" {
+		return args.SandboxCgroup
+	}
+	return filepath.Join(args.SandboxCgroup, instance)
+}
+
+// checkCgroupName checks that the name of a subprocess instance can name its
+// child cgroup, see sandboxCgroup, so that e.g. "../x" doesn't escape the
+// --sandbox_cgroup.
+func checkCgroupName(instance string) error {
+	if instance == "." || instance == ".." || strings.ContainsAny(instance, `/\`) {
+		return fmt.Errorf("instance name %q can't name a cgroup, expected no path separators", instance)
+	}
+	return nil
+}
+
+// sandboxArgv returns the arguments that run a command through dgbridge
+// sandbox-exec, with the sandbox options.
+//
+// Parameters:
+//
+//	instance: name of the subprocess instance, empty for a single subprocess
+func sandboxArgv(args CliArgs, instance string, argv []string) []string {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalln("[fatal] error finding the dgbridge executable for the sandbox:", err)
+	}
+	sandboxed := []string{executable, "sandbox-exec"}
+	if args.SandboxNoFile > 0 {
+		sandboxed = append(sandboxed, "--nofile", strconv.FormatUint(args.SandboxNoFile, 10))
+	}
+	if cgroup := sandboxCgroup(args, instance); cgroup != "" {
+		sandboxed = append(sandboxed, "--cgroup", cgroup)
+	}
+	if args.NoNewPrivileges {
+		sandboxed = append(sandboxed, "--no_new_privileges")
+	}
+	return append(append(sandboxed, "--"), argv...)
+}
+
+// runSandboxExecCommand implements dgbridge sandbox-exec: it applies the
+// sandbox to itself, then replaces itself with the command. Only returns on
+// error.
+func runSandboxExecCommand(argv []string) {
+	var args SandboxArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge sandbox-exec",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+	if err := execSandboxed(args); err != nil {
+		log.Fatalln("[fatal] sandbox:", err)
+	}
+}
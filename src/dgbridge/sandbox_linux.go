@@ -0,0 +1,94 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// prSetNoNewPrivs is the prctl option that sets the no_new_privs flag.
+const prSetNoNewPrivs = 38
+
+// checkSandbox checks that the sandbox options are supported.
+func checkSandbox(args CliArgs) error {
+	if args.SandboxMemory > 0 && args.SandboxCgroup == "" {
+		return fmt.Errorf("--sandbox_memory requires --sandbox_cgroup")
+	}
+	return nil
+}
+
+// prepareSandboxCgroups creates the cgroups of the subprocess instances, and
+// sets their memory limit. The --sandbox_cgroup must be delegated to the user
+// dgbridge runs as, e.g. with Delegate=yes in its systemd unit.
+//
+// Parameters:
+//
+//	instances: names of the subprocess instances, [""] for a single
+//		subprocess
+func prepareSandboxCgroups(args CliArgs, instances []string) error {
+	if args.SandboxCgroup == "" {
+		return nil
+	}
+	for _, instance := range instances {
+		if err := checkCgroupName(instance); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(args.SandboxCgroup, 0o755); err != nil {
+		return fmt.Errorf("error creating cgroup: %v", err)
+	}
+	if len(instances) > 1 && args.SandboxMemory > 0 {
+		control := filepath.Join(args.SandboxCgroup, "cgroup.subtree_control")
+		if err := os.WriteFile(control, []byte("+memory"), 0o644); err != nil {
+			return fmt.Errorf("error enabling the memory controller of %v: %v", args.SandboxCgroup, err)
+		}
+	}
+	for _, instance := range instances {
+		cgroup := sandboxCgroup(args, instance)
+		if err := os.MkdirAll(cgroup, 0o755); err != nil {
+			return fmt.Errorf("error creating cgroup: %v", err)
+		}
+		if args.SandboxMemory > 0 {
+			limit := strconv.FormatInt(args.SandboxMemory*1024*1024, 10)
+			if err := os.WriteFile(filepath.Join(cgroup, "memory.max"), []byte(limit), 0o644); err != nil {
+				return fmt.Errorf("error setting the memory limit of %v: %v", cgroup, err)
+			}
+		}
+	}
+	return nil
+}
+
+// execSandboxed applies the sandbox to the current process, then replaces it
+// with the command. Only returns on error.
+func execSandboxed(args SandboxArgs) error {
+	// The no_new_privs flag is set per thread, on the thread that execs.
+	runtime.LockOSThread()
+	if args.NoFile > 0 {
+		limit := syscall.Rlimit{Cur: args.NoFile, Max: args.NoFile}
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+			return fmt.Errorf("error limiting open files to %v: %v", args.NoFile, err)
+		}
+	}
+	if args.Cgroup != "" {
+		procs := filepath.Join(args.Cgroup, "cgroup.procs")
+		if err := os.WriteFile(procs, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+			return fmt.Errorf("error joining cgroup %v: %v", args.Cgroup, err)
+		}
+	}
+	if args.NoNewPrivileges {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+			return fmt.Errorf("error setting no_new_privs: %v", errno)
+		}
+	}
+	path, err := exec.LookPath(args.Command[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, args.Command, os.Environ())
+}
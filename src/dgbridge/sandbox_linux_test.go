@@ -0,0 +1,44 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareSandboxCgroups(t *testing.T) {
+	cgroup := filepath.Join(t.TempDir(), "dgbridge")
+	args := CliArgs{SandboxCgroup: cgroup, SandboxMemory: 2048}
+	assert.NoError(t, prepareSandboxCgroups(args, []string{"lobby", "survival"}))
+
+	control, err := os.ReadFile(filepath.Join(cgroup, "cgroup.subtree_control"))
+	assert.NoError(t, err)
+	assert.Equal(t, "+memory", string(control))
+	for i, instance := range []string{"lobby", "survival"} {
+		limit, err := os.ReadFile(filepath.Join(cgroup, instance, "memory.max"))
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Equal(t, "2147483648", string(limit), "Test #%v", i)
+	}
+
+	// A single subprocess joins the --sandbox_cgroup itself.
+	single := filepath.Join(t.TempDir(), "dgbridge")
+	assert.NoError(t, prepareSandboxCgroups(CliArgs{SandboxCgroup: single, SandboxMemory: 512}, []string{""}))
+	limit, err := os.ReadFile(filepath.Join(single, "memory.max"))
+	assert.NoError(t, err)
+	assert.Equal(t, "536870912", string(limit))
+
+	// Instance names can't escape the --sandbox_cgroup.
+	escaping := filepath.Join(t.TempDir(), "dgbridge")
+	assert.Error(t, prepareSandboxCgroups(CliArgs{SandboxCgroup: escaping}, []string{"lobby", "../x"}))
+	assert.NoDirExists(t, escaping)
+	assert.NoError(t, prepareSandboxCgroups(CliArgs{}, []string{"../x"}), "without a cgroup, names aren't used")
+}
+
+func TestCheckSandbox(t *testing.T) {
+	assert.Error(t, checkSandbox(CliArgs{SandboxMemory: 512}))
+	assert.NoError(t, checkSandbox(CliArgs{SandboxMemory: 512, SandboxCgroup: "/sys/fs/cgroup/dgbridge"}))
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// checkSandbox checks that the sandbox options are supported.
+func checkSandbox(args CliArgs) error {
+	return fmt.Errorf("the sandbox options are only supported on Linux")
+}
+
+// prepareSandboxCgroups does nothing, see checkSandbox.
+func prepareSandboxCgroups(args CliArgs, instances []string) error {
+	return nil
+}
+
+// execSandboxed fails, see checkSandbox.
+func execSandboxed(args SandboxArgs) error {
+	return fmt.Errorf("the sandbox is only supported on Linux")
+}
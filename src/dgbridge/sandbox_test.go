@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxCgroup(t *testing.T) {
+	tests := []struct {
+		cgroup   string
+		instance string
+		expected string
+	}{
+		{"", "", ""},
+		{"", "lobby", ""},
+		{"/sys/fs/cgroup/dgbridge", "", "/sys/fs/cgroup/dgbridge"},
+		{"/sys/fs/cgroup/dgbridge", "lobby", filepath.Join("/sys/fs/cgroup/dgbridge", "lobby")},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, sandboxCgroup(CliArgs{SandboxCgroup: test.cgroup}, test.instance), "Test #%v", i)
+	}
+}
+
+func TestCheckCgroupName(t *testing.T) {
+	tests := []struct {
+		instance string
+		isError  bool
+	}{
+		{"", false},
+		{"lobby", false},
+		{"survival.1", false},
+		{".", true},
+		{"..", true},
+		{"../x", true},
+		{"a/b", true},
+		{`a\b`, true},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.isError, checkCgroupName(test.instance) != nil, "Test #%v", i)
+	}
+}
+
+func TestSandboxArgv(t *testing.T) {
+	executable, err := os.Executable()
+	assert.NoError(t, err)
+	tests := []struct {
+		args     CliArgs
+		instance string
+		expected []string
+	}{
+		{CliArgs{NoNewPrivileges: true}, "", []string{"--no_new_privileges", "--"}},
+		{
+			CliArgs{SandboxNoFile: 4096, SandboxCgroup: "/sys/fs/cgroup/dgbridge"},
+			"lobby",
+			[]string{"--nofile", "4096", "--cgroup", filepath.Join("/sys/fs/cgroup/dgbridge", "lobby"), "--"},
+		},
+	}
+	for i, test := range tests {
+		expected := append(append([]string{executable, "sandbox-exec"}, test.expected...), "java", "-jar", "server.jar")
+		assert.Equal(t, expected, sandboxArgv(test.args, test.instance, []string{"java", "-jar", "server.jar"}), "Test #%v", i)
+	}
+}
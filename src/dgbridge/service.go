@@ -0,0 +1,161 @@
+package main
+
+// This file implements running dgbridge as a system service (a Windows service,
+// a systemd unit, a launchd daemon, ...), and the "dgbridge service"
+// subcommands that install and control the service.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/alexflint/go-arg"
+	"github.com/kardianos/service"
+)
+
+type ServiceArgs struct {
+	Install   *ServiceInstallArgs `arg:"subcommand:install" help:"Install dgbridge as a system service, running in the current directory"`
+	Uninstall *ServiceNameArgs    `arg:"subcommand:uninstall" help:"Uninstall the service"`
+	Start     *ServiceNameArgs    `arg:"subcommand:start" help:"Start the service"`
+	Stop      *ServiceNameArgs    `arg:"subcommand:stop" help:"Stop the service"`
+	Restart   *ServiceNameArgs    `arg:"subcommand:restart" help:"Restart the service"`
+}
+
+type ServiceNameArgs struct {
+	Name string `arg:"--name" default:"dgbridge" help:"Name of the service"`
+}
+
+type ServiceInstallArgs struct {
+	Name       string   `arg:"--name" default:"dgbridge" help:"Name of the service"`
+	BridgeArgs []string `arg:"required,positional" help:"Arguments of the bridge, after --, e.g. -- -t <TOKEN> -i <CHANNEL_ID> -r rules.json \"java -jar server.jar\""`
+}
+
+// serviceProgram runs the bridge under the service manager.
+type serviceProgram struct {
+	args       CliArgs
+	mutex      sync.Mutex
+	subprocess *SubprocessGroup // Set once the bridge has started
+}
+
+// Start starts the bridge. As required by the service manager, it returns
+// immediately.
+func (self *serviceProgram) Start(s service.Service) error {
+	go func() {
+		subprocess := startBridge(self.args)
+		self.mutex.Lock()
+		self.subprocess = subprocess
+		self.mutex.Unlock()
+	}()
+	return nil
+}
+
+// Stop stops the subprocess instances gracefully, see SubprocessGroup.Stop.
+func (self *serviceProgram) Stop(s service.Service) error {
+	self.mutex.Lock()
+	subprocess := self.subprocess
+	self.mutex.Unlock()
+	if subprocess != nil {
+		subprocess.Stop()
+	}
+	return nil
+}
+
+// runService runs the bridge under the service manager until the service is
+// stopped.
+func runService(args CliArgs) {
+	svc, err := service.New(&serviceProgram{args: args}, serviceConfig("dgbridge", nil))
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	if err := svc.Run(); err != nil {
+		log.Fatalln("[fatal] error running service:", err)
+	}
+}
+
+// runServiceCommand runs a "dgbridge service" subcommand.
+//
+// Parameters:
+//
+//	argv: command line arguments following "service"
+func runServiceCommand(argv []string) {
+	var args ServiceArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge service",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+
+	switch {
+	case args.Install != nil:
+		err = installService(*args.Install)
+	case args.Uninstall != nil:
+		err = controlService(args.Uninstall.Name, "uninstall")
+	case args.Start != nil:
+		err = controlService(args.Start.Name, "start")
+	case args.Stop != nil:
+		err = controlService(args.Stop.Name, "stop")
+	case args.Restart != nil:
+		err = controlService(args.Restart.Name, "restart")
+	default:
+		parser.Fail("missing subcommand")
+	}
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+}
+
+// installService installs a service running the bridge with the given
+// arguments in the current directory.
+func installService(args ServiceInstallArgs) error {
+	// Catch mistakes now rather than when the service starts.
+	var bridgeArgs CliArgs
+	parser, err := arg.NewParser(arg.Config{Program: "dgbridge"}, &bridgeArgs)
+	if err != nil {
+		return err
+	}
+	if err := parser.Parse(args.BridgeArgs); err != nil {
+		return fmt.Errorf("invalid bridge arguments: %v", err)
+	}
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	config := serviceConfig(args.Name, append([]string{"--service"}, args.BridgeArgs...))
+	config.WorkingDirectory = workingDirectory
+	svc, err := service.New(&serviceProgram{}, config)
+	if err != nil {
+		return err
+	}
+	if err := svc.Install(); err != nil {
+		return fmt.Errorf("error installing service: %v", err)
+	}
+	fmt.Printf("Installed service %q, start it with: dgbridge service start --name %v\n", args.Name, args.Name)
+	return nil
+}
+
+// controlService performs an action on an installed service.
+func controlService(name string, action string) error {
+	svc, err := service.New(&serviceProgram{}, serviceConfig(name, nil))
+	if err != nil {
+		return err
+	}
+	if err := service.Control(svc, action); err != nil {
+		return err
+	}
+	fmt.Printf("Service %q: %v done\n", name, action)
+	return nil
+}
+
+func serviceConfig(name string, arguments []string) *service.Config {
+	return &service.Config{
+		Name:        name,
+		DisplayName: fmt.Sprintf("Dgbridge (%v)", name),
+		Description: "Bridges a game server's console to a Discord channel",
+		Arguments:   arguments,
+	}
+}
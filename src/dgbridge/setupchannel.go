@@ -0,0 +1,131 @@
+package main
+
+// This file implements the "dgbridge setup-channel" subcommand, which creates
+// the relay channel with permission overwrites for the bot and @everyone.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Access of @everyone to the relay channel, see --access.
+const (
+	ChannelAccessWrite = "write" // Read and send messages
+	ChannelAccessRead  = "read"  // Read messages only
+	ChannelAccessNone  = "none"  // Not see the channel
+)
+
+// channelIdEnv is the environment variable of --channel_id.
+const channelIdEnv = "DGBRIDGE_CHANNEL_ID"
+
+// botChannelPermissions are the permissions of the bot in the relay channel.
+const botChannelPermissions = discordgo.PermissionViewChannel |
+	discordgo.PermissionSendMessages |
+	discordgo.PermissionReadMessageHistory |
+	discordgo.PermissionEmbedLinks |
+	discordgo.PermissionAttachFiles |
+	discordgo.PermissionCreatePublicThreads |
+	discordgo.PermissionSendMessagesInThreads
+
+type SetupChannelArgs struct {
+	Token    string `arg:"required,-t,--token,env:DISCORD_TOKEN" help:"Discord authentication token"`
+	GuildId  string `arg:"required,-g,--guild_id" help:"ID of the guild to create the channel in"`
+	Name     string `arg:"--name" default:"server-chat" help:"Name of the channel"`
+	Topic    string `arg:"--topic" help:"Topic of the channel"`
+	Category string `arg:"--category_id" help:"ID of the category to create the channel in"`
+	Access   string `arg:"--access" default:"write" help:"What @everyone may do in the channel: write (read and send messages), read, or none"`
+	EnvFile  string `arg:"--env_file" default:"dgbridge.env" help:"Environment file, e.g. written by 'dgbridge init', that the channel ID is saved to as DGBRIDGE_CHANNEL_ID; empty to only print it"`
+}
+
+// runSetupChannelCommand runs "dgbridge setup-channel".
+//
+// Parameters:
+//
+//	argv: command line arguments following "setup-channel"
+func runSetupChannelCommand(argv []string) {
+	var args SetupChannelArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge setup-channel",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+
+	allow, deny, err := everyoneChannelPermissions(args.Access)
+	if err != nil {
+		parser.Fail(err.Error())
+	}
+	if !lib.IsSnowflake(args.GuildId) {
+		parser.Fail(fmt.Sprintf("--guild_id: %q is not a valid Discord guild ID", args.GuildId))
+	}
+	if args.Category != "" && !lib.IsSnowflake(args.Category) {
+		parser.Fail(fmt.Sprintf("--category_id: %q is not a valid Discord channel ID", args.Category))
+	}
+
+	session, err := discordgo.New("Bot " + args.Token)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	user, err := session.User("@me")
+	if err != nil {
+		log.Fatalln("[fatal] error authenticating:", err)
+	}
+	channel, err := session.GuildChannelCreateComplex(args.GuildId, discordgo.GuildChannelCreateData{
+		Name:     args.Name,
+		Type:     discordgo.ChannelTypeGuildText,
+		Topic:    args.Topic,
+		ParentID: args.Category,
+		PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			// The @everyone role has the ID of the guild.
+			{ID: args.GuildId, Type: discordgo.PermissionOverwriteTypeRole, Allow: allow, Deny: deny},
+			{ID: user.ID, Type: discordgo.PermissionOverwriteTypeMember, Allow: botChannelPermissions},
+		},
+	})
+	if err != nil {
+		log.Fatalln("[fatal] error creating channel, does the bot have the Manage Channels and Manage Roles permissions?", err)
+	}
+	fmt.Printf("Created #%v (%v)\n", channel.Name, channel.ID)
+
+	if args.EnvFile == "" {
+		return
+	}
+	contents, err := os.ReadFile(args.EnvFile)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalln("[fatal]", err)
+	}
+	// Existing files keep their mode, since they may contain the token.
+	updated := lib.SetEnvFileVar(string(contents), channelIdEnv, channel.ID)
+	if err := os.WriteFile(args.EnvFile, []byte(updated), 0o600); err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	fmt.Printf("Saved the channel ID to %v as %v\n", args.EnvFile, channelIdEnv)
+}
+
+// everyoneChannelPermissions returns the permission overwrite of @everyone for
+// an access level.
+//
+// Returns:
+//
+//	the allowed and denied permissions
+func everyoneChannelPermissions(access string) (int64, int64, error) {
+	const read = discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory
+	switch access {
+	case ChannelAccessWrite:
+		return read | discordgo.PermissionSendMessages, 0, nil
+	case ChannelAccessRead:
+		return read, discordgo.PermissionSendMessages, nil
+	case ChannelAccessNone:
+		return 0, discordgo.PermissionViewChannel, nil
+	default:
+		return 0, 0, fmt.Errorf("--access: expected %v, %v or %v, got %q",
+			ChannelAccessWrite, ChannelAccessRead, ChannelAccessNone, access)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+// This file implements shadow rules, a candidate rules file that runs next to
+// the rules on live traffic without its results being relayed anywhere, see
+// --shadow_rules. Inputs whose results differ are logged, so that a rewrite of
+// the rules can be validated before it replaces them.
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"os"
+	"time"
+)
+
+// shadowReportInterval is the time between the logged summaries of the shadow
+// rules.
+const shadowReportInterval = time.Hour
+
+// shadowRules are the candidate rules of --shadow_rules.
+type shadowRules struct {
+	rules *lib.Rules
+	stats *lib.ShadowStats
+}
+
+// loadShadowRules loads the rules file given with --shadow_rules.
+//
+// Returns:
+//
+//	the shadow rules, or nil if no --shadow_rules is given
+func loadShadowRules(args CliArgs, filterList *lib.FilterList) *shadowRules {
+	if args.ShadowRules == "" {
+		return nil
+	}
+	contents, err := os.ReadFile(args.ShadowRules)
+	if err != nil {
+		log.Fatalln("[fatal] error reading --shadow_rules:", err)
+	}
+	rules, err := lib.ParseRules(contents)
+	if err != nil {
+		log.Fatalln("[fatal] error loading --shadow_rules:", err)
+	}
+	if filterList != nil {
+		// Words added with /filter are masked in both, so they don't differ.
+		if rules.Filter == nil {
+			rules.Filter = &lib.Filter{}
+		}
+		rules.Filter.List = filterList
+	}
+	log.Printf("[info] Running shadow rules %v, their results are only logged\n", args.ShadowRules)
+	return &shadowRules{rules: rules, stats: lib.NewShadowStats()}
+}
+
+// compare compares the match of the rules with the candidate's match, and logs
+// the difference.
+func (self *shadowRules) compare(direction string, input string, current *lib.RuleMatch, candidate *lib.RuleMatch) {
+	diff, differs := lib.CompareMatches(direction, input, current, candidate)
+	self.stats.Count(direction, differs)
+	if differs {
+		log.Printf("[info] shadow rules differ: %v\n", diff)
+	}
+}
+
+// stage returns the stage that compares the rules' match of each event with
+// the candidate's.
+//
+// Parameters:
+//
+//	resolve: returns the Props of a player, may be nil
+func (self *shadowRules) stage(resolve lib.PropsResolver) lib.Stage {
+	return lib.Tap("shadow rules", func(event *lib.Event) {
+		self.compare(event.Direction, event.Raw, event.Match, self.rules.MatchEvent(event, resolve))
+	})
+}
+
+// summary returns the counts of each direction, for the log and /bridge stats.
+func (self *shadowRules) summary() string {
+	return "Shadow rules: SubprocessToDiscord " + self.stats.Summary(lib.DirectionSubprocessToDiscord) +
+		", DiscordToSubprocess " + self.stats.Summary(lib.DirectionDiscordToSubprocess)
+}
+
+// startReportJob logs a summary of the shadow rules every
+// shadowReportInterval.
+func (self *shadowRules) startReportJob() {
+	ticker := time.NewTicker(shadowReportInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		log.Printf("[info] %v\n", self.summary())
+	}
+}
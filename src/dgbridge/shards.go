@@ -0,0 +1,73 @@
+package main
+
+// This file implements gateway sharding. Bots in many guilds must split their
+// gateway connection into shards, each receiving the events of a part of the
+// guilds. A bridge only needs the events of its relay channel's guild, so
+// bridges sharing a bot identity each connect as the shard of their guild.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// shardConfig selects the gateway shard the bridge connects as.
+type shardConfig struct {
+	Id    int  // ID of the shard, less than Count
+	Count int  // Number of shards, 0 or 1 for no sharding
+	Auto  bool // Use the shard count recommended by Discord and the shard of the relay channel's guild
+}
+
+// apply sets the shard of a session before it is opened. In auto mode, the
+// shard count recommended by Discord is fetched, and connecting waits while
+// the bot's session starts are used up, e.g. because many bridges sharing it
+// started at once.
+func (self shardConfig) apply(session *discordgo.Session, relayChannelId string) error {
+	if self.Auto {
+		gateway, err := session.GatewayBot()
+		if err != nil {
+			return fmt.Errorf("error fetching recommended shard count: %v", err)
+		}
+		channel, err := session.Channel(relayChannelId)
+		if err != nil {
+			return fmt.Errorf("error fetching relay channel: %v", err)
+		}
+		self.Count = max(gateway.Shards, 1)
+		if self.Id, err = lib.ShardFor(channel.GuildID, self.Count); err != nil {
+			return err
+		}
+		if limit := gateway.SessionStartLimit; limit.Total > 0 && limit.Remaining == 0 {
+			wait := time.Duration(limit.ResetAfter) * time.Millisecond
+			log.Printf("[warning] the bot has no session starts left, connecting in %v\n", wait.Round(time.Second))
+			time.Sleep(wait)
+		}
+	}
+	if self.Count > 1 {
+		session.ShardID = self.Id
+		session.ShardCount = self.Count
+		log.Printf("[info] Connecting as gateway shard %v of %v\n", self.Id, self.Count)
+	}
+	return nil
+}
+
+// verify logs a warning if the relay channel's guild isn't on the shard the
+// session connected as; its messages and commands would never be received.
+func (self shardConfig) verify(session *discordgo.Session, relayChannelId string) {
+	if session.ShardCount <= 1 {
+		return
+	}
+	channel, err := session.State.Channel(relayChannelId)
+	if err != nil {
+		if channel, err = session.Channel(relayChannelId); err != nil {
+			log.Printf("[warning] can't verify the gateway shard, error fetching relay channel: %v", err)
+			return
+		}
+	}
+	shard, err := lib.ShardFor(channel.GuildID, session.ShardCount)
+	if err == nil && shard != session.ShardID {
+		log.Printf("[warning] the relay channel's guild is on gateway shard %v, not %v; its messages won't be received\n", shard, session.ShardID)
+	}
+}
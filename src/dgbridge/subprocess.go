@@ -7,36 +7,74 @@ package main
 import (
 	"bufio"
 	"dgbridge/src/ext"
+	"dgbridge/src/lib"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
-	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
+// OutputLine is a line of the subprocess' stdout or stderr.
+type OutputLine struct {
+	Text     string
+	Stderr   bool      // Whether the line was read from stderr
+	Time     time.Time // When the line was read, with a monotonic clock reading
+	Instance string    // Name of the subprocess instance, see SubprocessContext.Name
+	State    string    // State of the subprocess when the line was read, see SubprocessContext.States
+}
+
+// Info returns where the line came from, for lib.Rules.MatchPlayer.
+func (self OutputLine) Info() lib.LineInfo {
+	return lib.LineInfo{Instance: self.Instance, Stderr: self.Stderr, State: self.State}
+}
+
+// Event returns the event of the line, see lib.Pipeline.
+//
+// Parameters:
+//
+//	direction: the direction the line is relayed in, e.g.
+//	lib.DirectionSubprocessToDiscord
+func (self OutputLine) Event(direction string) *lib.Event {
+	event := lib.NewEvent(lib.EventSourceSubprocess, direction, self.Text, self.Time)
+	event.Line = self.Info()
+	return event
+}
+
 // SubprocessContext is a struct that holds all events for reading and writing to a subprocess' streams.
 type SubprocessContext struct {
 	cmd                 *exec.Cmd
-	StdoutLineEvent     ext.EventChannel[string] // Emits when subprocess' stdout emits a line
-	StderrLineEvent     ext.EventChannel[string] // Emits when subprocess' stderr emits a line
-	WriteStdinLineEvent ext.EventChannel[string] // Listens for data to write to stdin
-	ExitEvent           ext.EventChannel[int]    // Emits when subprocess exits
+	Name                string                       // Name of the instance, empty if the bridge runs a single subprocess
+	StdoutLineEvent     ext.EventChannel[string]     // Emits when subprocess' stdout emits a line
+	StderrLineEvent     ext.EventChannel[string]     // Emits when subprocess' stderr emits a line
+	OutputLineEvent     ext.EventChannel[OutputLine] // Emits stdout and stderr lines, in the order they were read
+	WriteStdinLineEvent ext.EventChannel[string]     // Listens for data to write to stdin
+	ExitEvent           ext.EventChannel[int]        // Emits when subprocess exits
+	StopCommand         string                       // Written to stdin to stop the subprocess gracefully, may be empty
+	StopTimeout         time.Duration                // Time to wait for the subprocess to exit after asking it to stop
+	SignalActions       map[string]lib.SignalAction  // By signal name; signals without an action are forwarded
+	States              *lib.ProcessStateTracker     // Tracks the state of the subprocess from its output, may be nil
+	outputMutex         sync.Mutex                   // Orders the lines of both streams, see emitLine
+	done                chan struct{}                // Closed when the subprocess exits
 }
 
-// NewSubprocess creates a command handle from the specified system command string and returns a SubprocessContext
+// NewSubprocess creates a command handle from the specified command arguments and returns a SubprocessContext
 // struct.
 // The subprocess is not started.
 //
 // Parameters:
 //
-//	command: system command string to use to start the process.
-func NewSubprocess(command string) SubprocessContext {
-	cmd := createCommand(command)
+//	argv: the program to run, followed by its arguments, see lib.ParseCommand
+//	env: environment of the subprocess, see lib.CommandEnv
+func NewSubprocess(argv []string, env []string) SubprocessContext {
+	cmd := createCommand(argv, env)
 	return SubprocessContext{
-		cmd: cmd,
+		cmd:  cmd,
+		done: make(chan struct{}),
 	}
 }
 
@@ -68,15 +106,88 @@ func (self *SubprocessContext) Start() error {
 	return nil
 }
 
-// createCommand returns a command handle created from the specified system command string.
+// InstanceName returns the name of the instance, see Console.InstanceName.
+func (self *SubprocessContext) InstanceName() string {
+	return self.Name
+}
+
+// Output returns OutputLineEvent, which emits the lines of both stdout and
+// stderr.
+func (self *SubprocessContext) Output() *ext.EventChannel[OutputLine] {
+	return &self.OutputLineEvent
+}
+
+// Exited returns ExitEvent, which emits the exit code of the subprocess.
+func (self *SubprocessContext) Exited() *ext.EventChannel[int] {
+	return &self.ExitEvent
+}
+
+// WriteLine writes a line to the subprocess' stdin. A newline is appended.
+func (self *SubprocessContext) WriteLine(text string) {
+	self.WriteStdinLineEvent.Broadcast(text + "\n")
+}
+
+// createCommand returns a command handle created from the specified command arguments.
 // It doesn't run the command.
-func createCommand(command string) *exec.Cmd {
-	trimmed := strings.TrimSpace(command)
-	tokens := strings.Split(trimmed, " ")
-	cmd := exec.Command(tokens[0], tokens[1:]...)
+func createCommand(argv []string, env []string) *exec.Cmd {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = env
+	configureCommand(cmd)
 	return cmd
 }
 
+// Stop stops the subprocess gracefully: it writes StopCommand to the
+// subprocess' stdin, or interrupts it if there is no stop command, then kills
+// the subprocess if it hasn't exited after StopTimeout.
+// Returns once the subprocess has exited.
+func (self *SubprocessContext) Stop() {
+	if self.cmd.Process == nil {
+		return
+	}
+	select {
+	case <-self.done:
+		return
+	default:
+	}
+
+	if self.States != nil {
+		self.States.Stopping()
+	}
+	if self.StopCommand != "" {
+		log.Printf("[info] Stopping subprocess with %q\n", self.StopCommand)
+		self.WriteStdinLineEvent.Broadcast(self.StopCommand + "\n")
+	} else if err := self.interrupt(); err != nil {
+		log.Printf("[warning] can't stop subprocess gracefully, killing it: %v\n", err)
+		_ = self.cmd.Process.Kill()
+	}
+	select {
+	case <-self.done:
+	case <-time.After(self.StopTimeout):
+		log.Printf("[warning] subprocess didn't exit within %v, killing it\n", self.StopTimeout)
+		_ = self.cmd.Process.Kill()
+		<-self.done
+	}
+}
+
+// emitLine broadcasts a line read from stdout or stderr to its stream's event
+// and to OutputLineEvent. Lines are timestamped and broadcast one at a time, so
+// that listeners of OutputLineEvent receive them in the order they were read,
+// e.g. a warning on stderr followed by its details on stdout.
+func (self *SubprocessContext) emitLine(text string, stderr bool) {
+	self.outputMutex.Lock()
+	defer self.outputMutex.Unlock()
+	if stderr {
+		self.StderrLineEvent.Broadcast(text)
+	} else {
+		self.StdoutLineEvent.Broadcast(text)
+	}
+	line := OutputLine{Text: text, Stderr: stderr, Time: time.Now(), Instance: self.Name}
+	if self.States != nil {
+		line.State = self.States.Observe(text)
+	}
+	self.OutputLineEvent.Broadcast(line)
+}
+
 // watchStdout watches the subprocess' stdout.
 // It broadcasts StdoutLineEvent whenever the process emits a line.
 func (self *SubprocessContext) watchStdout() error {
@@ -90,7 +201,7 @@ func (self *SubprocessContext) watchStdout() error {
 		}(pipe)
 		scanner := bufio.NewScanner(pipe)
 		for scanner.Scan() {
-			self.StdoutLineEvent.Broadcast(scanner.Text())
+			self.emitLine(scanner.Text(), false)
 		}
 	}()
 	return nil
@@ -109,7 +220,7 @@ func (self *SubprocessContext) watchStderr() error {
 		}(pipe)
 		scanner := bufio.NewScanner(pipe)
 		for scanner.Scan() {
-			self.StderrLineEvent.Broadcast(scanner.Text())
+			self.emitLine(scanner.Text(), true)
 		}
 	}()
 	return nil
@@ -132,8 +243,14 @@ func (self *SubprocessContext) listenStdin() error {
 		defer self.WriteStdinLineEvent.Off(lineCh)
 
 		for line := range lineCh {
-			_, _ = writer.WriteString(line)
-			_ = writer.Flush()
+			_, err := writer.WriteString(line)
+			if err == nil {
+				err = writer.Flush()
+			}
+			if err != nil {
+				log.Printf("[error] error writing to the subprocess' stdin: %v", err)
+				reportError(lib.MsgErrorKindServer, err)
+			}
 		}
 	}()
 	return nil
@@ -143,6 +260,7 @@ func (self *SubprocessContext) listenStdin() error {
 // When the subprocess exits, it emits ExitEvent.
 func (self *SubprocessContext) watchSubprocessExit() {
 	err := self.cmd.Wait()
+	close(self.done)
 
 	// Subprocess exited
 	// Now we can check for the subprocess' exit code, and exit our own process with that same exit code.
@@ -177,7 +295,10 @@ func (self *SubprocessContext) relaySignalsToSubprocessUntilExit() {
 		select {
 		case sig := <-sigCh:
 			// We received a signal, let's try passing it to the subprocess
-			if err := self.cmd.Process.Signal(sig); err != nil {
+			if self.applySignalAction(sig) {
+				continue
+			}
+			if err := self.handleSignal(sig); err != nil {
 				// Not clear how we can hit this, but probably not
 				// worth terminating the child.
 				log.Printf("[debug] Couldn't send signal \"%v\" to subprocess: %v\n", sig, err)
@@ -187,3 +308,29 @@ func (self *SubprocessContext) relaySignalsToSubprocessUntilExit() {
 		}
 	}
 }
+
+// applySignalAction applies the configured action for a signal, if it isn't
+// SignalActionForward.
+//
+// Returns:
+//
+//	true if the signal was handled
+func (self *SubprocessContext) applySignalAction(sig os.Signal) bool {
+	action, ok := self.SignalActions[signalNames[sig]]
+	if !ok {
+		return false
+	}
+	switch action.Kind {
+	case lib.SignalActionIgnore:
+		log.Printf("[debug] Ignoring signal %q\n", sig)
+		return true
+	case lib.SignalActionCommand:
+		log.Printf("[debug] Received signal %q, writing %q to subprocess\n", sig, action.Command)
+		self.WriteStdinLineEvent.Broadcast(action.Command + "\n")
+		return true
+	case lib.SignalActionReload:
+		// Reloads are done by configReloader, which is notified of the signal too.
+		return true
+	}
+	return false
+}
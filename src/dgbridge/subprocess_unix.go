@@ -0,0 +1,44 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// signalNames are the names of the signals that can be configured with
+// --on_signal.
+var signalNames = map[os.Signal]string{
+	syscall.SIGHUP:   "SIGHUP",
+	syscall.SIGINT:   "SIGINT",
+	syscall.SIGQUIT:  "SIGQUIT",
+	syscall.SIGTERM:  "SIGTERM",
+	syscall.SIGUSR1:  "SIGUSR1",
+	syscall.SIGUSR2:  "SIGUSR2",
+	syscall.SIGWINCH: "SIGWINCH",
+	syscall.SIGCONT:  "SIGCONT",
+	syscall.SIGTSTP:  "SIGTSTP",
+	syscall.SIGALRM:  "SIGALRM",
+}
+
+// configureCommand applies platform-specific settings to the subprocess
+// command.
+func configureCommand(cmd *exec.Cmd) {}
+
+// handleSignal handles a signal received by dgbridge by passing it on to the
+// subprocess.
+func (self *SubprocessContext) handleSignal(sig os.Signal) error {
+	return self.cmd.Process.Signal(sig)
+}
+
+// interrupt asks the subprocess to exit.
+func (self *SubprocessContext) interrupt() error {
+	return self.cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// shellCommand returns the arguments to run a command with the system shell.
+func shellCommand(command string) []string {
+	return []string{"/bin/sh", "-c", command}
+}
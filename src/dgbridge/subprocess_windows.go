@@ -0,0 +1,60 @@
+//go:build windows
+
+package main
+
+// Windows does not support sending signals to processes. Console control
+// events (Ctrl+C, closing the console window, logging off, shutting down) are
+// delivered to every process attached to a console, and Windows kills the
+// processes a few seconds after a close event.
+//
+// So that the subprocess isn't killed along with dgbridge before it could shut
+// down, it is given its own, hidden console. When dgbridge receives a control
+// event, it stops the subprocess with the stop command instead.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// createNewConsole is the CREATE_NEW_CONSOLE process creation flag.
+const createNewConsole = 0x00000010
+
+// signalNames are the names of the signals that can be configured with
+// --on_signal.
+var signalNames = map[os.Signal]string{
+	os.Interrupt:    "SIGINT",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// configureCommand applies platform-specific settings to the subprocess
+// command.
+func configureCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: createNewConsole | syscall.CREATE_NEW_PROCESS_GROUP,
+		HideWindow:    true,
+	}
+}
+
+// handleSignal handles a signal received by dgbridge. Go reports console
+// control events as os.Interrupt (Ctrl+C, Ctrl+Break) and syscall.SIGTERM
+// (close, logoff, shutdown); both stop the subprocess gracefully.
+func (self *SubprocessContext) handleSignal(sig os.Signal) error {
+	if sig == os.Interrupt || sig == syscall.SIGTERM {
+		go self.Stop()
+		return nil
+	}
+	return fmt.Errorf("signals are not supported on Windows")
+}
+
+// interrupt asks the subprocess to exit. This is not possible on Windows,
+// where only a stop command can shut down the subprocess gracefully.
+func (self *SubprocessContext) interrupt() error {
+	return fmt.Errorf("signals are not supported on Windows")
+}
+
+// shellCommand returns the arguments to run a command with the system shell.
+func shellCommand(command string) []string {
+	return []string{"cmd.exe", "/C", command}
+}
@@ -0,0 +1,145 @@
+package main
+
+// This file implements reporting panics and errors to error trackers, see
+// --sentry_dsn and --error_webhook_url, e.g. for hosting providers running
+// many bridges.
+
+import (
+	"bytes"
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// errorTrackerInterval is the minimum time between events of the same kind.
+const errorTrackerInterval = time.Minute
+
+// errorTrackerQueue is the number of events waiting to be sent. Events are
+// dropped while the queue is full.
+const errorTrackerQueue = 32
+
+// tracker reports panics and errors, see reportPanic and reportError. Nil if
+// no error tracker is configured.
+var tracker *errorTracker
+
+// errorTracker sends error events to Sentry and a generic HTTP endpoint.
+type errorTracker struct {
+	sentry     *lib.SentryDsn // May be nil
+	webhookUrl string         // Receives events as JSON, may be empty
+	metadata   lib.ErrorMetadata
+	throttle   *lib.ErrorThrottle
+	client     *http.Client
+	events     chan lib.ErrorEvent
+}
+
+// startErrorTracker starts the error tracker configured with command line
+// arguments.
+//
+// Parameters:
+//
+//	rulesFile: contents of the rules file, see lib.ConfigHash
+//
+// Returns:
+//
+//	the tracker, or nil if none is configured
+func startErrorTracker(args CliArgs, rulesFile []byte) *errorTracker {
+	if args.SentryDsn == "" && args.ErrorWebhookUrl == "" {
+		return nil
+	}
+	host, _ := os.Hostname()
+	self := &errorTracker{
+		webhookUrl: args.ErrorWebhookUrl,
+		metadata: lib.ErrorMetadata{
+			Version:    lib.Version,
+			ConfigHash: lib.ConfigHash(rulesFile),
+			Host:       host,
+			Bridge:     args.BridgeName,
+		},
+		throttle: lib.NewErrorThrottle(errorTrackerInterval),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		events:   make(chan lib.ErrorEvent, errorTrackerQueue),
+	}
+	if args.SentryDsn != "" {
+		dsn, err := lib.ParseSentryDsn(args.SentryDsn)
+		if err != nil {
+			log.Fatalln("[fatal] --sentry_dsn:", err)
+		}
+		self.sentry = dsn
+	}
+	go func() {
+		for event := range self.events {
+			self.send(event)
+		}
+	}()
+	return self
+}
+
+// capture queues an event, unless an event of the same kind was sent
+// recently. Safe to call on a nil tracker.
+func (self *errorTracker) capture(event lib.ErrorEvent) {
+	if self == nil {
+		return
+	}
+	allowed, suppressed := self.throttle.Allow(event.Kind, event.Time)
+	if !allowed {
+		return
+	}
+	event.Id = lib.NewErrorEventId()
+	event.Suppressed = suppressed
+	event.Metadata = self.metadata
+	select {
+	case self.events <- event:
+	default:
+		log.Printf("[warning] error tracker queue is full, dropping event of %v\n", event.Kind)
+	}
+}
+
+// send sends an event to the configured trackers. Failures are only logged, so
+// that they don't cause more events.
+func (self *errorTracker) send(event lib.ErrorEvent) {
+	if self.sentry != nil {
+		envelope, err := self.sentry.SentryEnvelope(event)
+		if err == nil {
+			err = self.post(self.sentry.EnvelopeUrl(), "application/x-sentry-envelope", envelope,
+				map[string]string{"X-Sentry-Auth": self.sentry.AuthHeader()})
+		}
+		if err != nil {
+			log.Printf("[warning] error sending event to Sentry: %v", err)
+		}
+	}
+	if self.webhookUrl != "" {
+		body, err := json.Marshal(event)
+		if err == nil {
+			err = self.post(self.webhookUrl, "application/json", body, nil)
+		}
+		if err != nil {
+			log.Printf("[warning] error sending event to the error webhook: %v", err)
+		}
+	}
+}
+
+// post posts a request body, checking the response's status.
+func (self *errorTracker) post(url string, contentType string, body []byte, headers map[string]string) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("User-Agent", "dgbridge/"+versionString())
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := self.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %v", response.Status)
+	}
+	return nil
+}
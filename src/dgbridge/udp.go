@@ -0,0 +1,102 @@
+package main
+
+// This file implements reading lines from log messages received over UDP, see
+// --udp_listen. Game servers that log over the network, possibly on several
+// machines, can feed one bridge.
+
+import (
+	"dgbridge/src/lib"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpPacketSize is the maximum size of a UDP packet.
+const udpPacketSize = 65535
+
+// UdpConsole is a Console that reads GELF or syslog messages received over
+// UDP. Lines are tagged with the host that sent them as their instance name,
+// see lib.NetworkLine. It can't receive commands.
+type UdpConsole struct {
+	remoteConsole
+	addr        string // Address to listen on, e.g. :12201
+	conn        net.PacketConn
+	droppedOnce sync.Once // Warns about dropped commands once
+}
+
+// NewUdpConsole creates a console that listens on a UDP address once started.
+func NewUdpConsole(addr string) *UdpConsole {
+	return &UdpConsole{remoteConsole: newRemoteConsole(), addr: addr}
+}
+
+// newUdpConsole creates the console configured with command line arguments,
+// and relays its output to the terminal.
+func newUdpConsole(args CliArgs, rules *lib.Rules) Console {
+	console := NewUdpConsole(args.UdpListen)
+	console.States = lib.NewProcessStateTracker(rules.States)
+	go superviseJob("relay of the console", func() { relayConsoleOutput(console) })
+	return console
+}
+
+// Start starts listening.
+func (self *UdpConsole) Start() error {
+	conn, err := net.ListenPacket("udp", self.addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %v: %v", self.addr, err)
+	}
+	self.conn = conn
+	log.Printf("[info] Listening for log messages on udp %v\n", conn.LocalAddr())
+	go superviseJob("UDP console", self.run)
+	return nil
+}
+
+// Stop stops listening.
+func (self *UdpConsole) Stop() {
+	self.stop(func() {
+		if self.conn != nil {
+			_ = self.conn.Close()
+		}
+	})
+}
+
+// WriteLine drops the line: the senders of the log messages can't receive
+// commands.
+func (self *UdpConsole) WriteLine(text string) {
+	self.droppedOnce.Do(func() {
+		log.Printf("[warning] the UDP console can't receive commands, dropping line %q and all that follow\n", text)
+	})
+}
+
+// run reads messages until the console is stopped.
+func (self *UdpConsole) run() {
+	assembler := lib.NewGelfAssembler()
+	buffer := make([]byte, udpPacketSize)
+	for {
+		n, sender, err := self.conn.ReadFrom(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			log.Printf("[error] error reading UDP message: %v", err)
+			time.Sleep(jobRestartDelay)
+			continue
+		}
+		message, complete, err := assembler.Add(buffer[:n], time.Now())
+		if err != nil {
+			log.Printf("[warning] dropping UDP message from %v: %v\n", sender, err)
+			continue
+		} else if !complete {
+			continue
+		}
+		lines, err := lib.ParseNetworkLog(message)
+		if err != nil {
+			log.Printf("[warning] dropping UDP message from %v: %v\n", sender, err)
+			continue
+		}
+		for _, line := range lines {
+			self.emit(OutputLine{Text: line.Text, Stderr: line.Error, Instance: line.Host})
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+// This file implements checking GitHub for newer dgbridge releases on startup,
+// see --check_updates.
+
+import (
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// updateNotesLines is the number of lines of the release notes in an update
+// notice.
+const updateNotesLines = 10
+
+// githubRelease is the part of a GitHub release that the update check uses.
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	HtmlUrl string `json:"html_url"`
+	Body    string `json:"body"`
+}
+
+// updateCheck is the result of an update check that runs in the background.
+type updateCheck struct {
+	done   chan struct{}
+	notice string // Empty if dgbridge is up to date or the check failed
+}
+
+// startUpdateCheck checks in the background whether a newer release than the
+// running version exists, and logs it if so.
+//
+// Parameters:
+//
+//	repo: GitHub repository, e.g. "SootyOwl/dgbridge"
+func startUpdateCheck(repo string) *updateCheck {
+	check := &updateCheck{done: make(chan struct{})}
+	go func() {
+		defer close(check.done)
+		if lib.Version == "" {
+			log.Println("[debug] Development build, not checking for updates")
+			return
+		}
+		release, err := fetchLatestRelease(repo)
+		if err != nil {
+			log.Println("[warning] error checking for updates:", err)
+			return
+		}
+		if lib.CompareVersions(release.TagName, lib.Version) <= 0 {
+			return
+		}
+		check.notice = formatUpdateNotice(release)
+		log.Printf("[info] %v\n", check.notice)
+	}()
+	return check
+}
+
+// wait waits for the check to finish.
+//
+// Returns:
+//
+//	the update notice, or "" if there is no update
+func (self *updateCheck) wait() string {
+	<-self.done
+	return self.notice
+}
+
+// versionString returns the running version, for --version.
+func versionString() string {
+	if lib.Version == "" {
+		return "(development build)"
+	}
+	return lib.Version
+}
+
+// Version implements arg.Versioned, so that go-arg prints the version for
+// --version and at the top of --help.
+func (CliArgs) Version() string {
+	return "dgbridge " + versionString()
+}
+
+// expandVersionFlag replaces -V in the options of the command line with
+// --version, since go-arg only handles the long form.
+func expandVersionFlag(argv []string) []string {
+	expanded := make([]string, len(argv))
+	copy(expanded, argv)
+	for i, argument := range expanded {
+		if argument == "--" {
+			break
+		}
+		if argument == "-V" {
+			expanded[i] = "--version"
+		}
+	}
+	return expanded
+}
+
+// githubApiUrl is the base URL of the GitHub API, replaced by tests.
+var githubApiUrl = "https://api.github.com"
+
+// fetchLatestRelease fetches the latest release of a GitHub repository.
+func fetchLatestRelease(repo string) (*githubRelease, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v/repos/%v/releases/latest", githubApiUrl, repo), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v fetching the latest release of %v", response.Status, repo)
+	}
+	var release githubRelease
+	if err := json.NewDecoder(response.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("error decoding release: %v", err)
+	}
+	return &release, nil
+}
+
+// formatUpdateNotice formats the notice of a newer release, with a summary of
+// its release notes.
+func formatUpdateNotice(release *githubRelease) string {
+	notice := fmt.Sprintf("dgbridge %v is available (running %v): %v", release.TagName, lib.Version, release.HtmlUrl)
+	if summary := lib.SummarizeReleaseNotes(release.Body, updateNotesLines); summary != "" {
+		notice += "\n" + summary
+	}
+	return notice
+}
+
+// announceUpdate posts the notice of the update check to the update channel,
+// once the check has finished.
+func (self *BotContext) announceUpdate(s *discordgo.Session) {
+	notice := self.updateCheck.wait()
+	if notice == "" {
+		return
+	}
+	if runes := []rune(notice); len(runes) > lib.DiscordMessageLimit-len(self.signature) {
+		notice = string(runes[:lib.DiscordMessageLimit-len(self.signature)-1]) + "…"
+	}
+	message := notice + self.signature
+	if _, err := s.ChannelMessageSend(self.updateChannelId, message); err != nil {
+		log.Printf("[error] error posting update notice: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"dgbridge/src/lib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandVersionFlag(t *testing.T) {
+	tests := []struct {
+		Argv     []string
+		Expected []string
+	}{
+		{[]string{"-V"}, []string{"--version"}},
+		{[]string{"-t", "token", "-V"}, []string{"-t", "token", "--version"}},
+		{[]string{"--", "java", "-V"}, []string{"--", "java", "-V"}},
+		{[]string{"-i", "42", "java -jar server.jar"}, []string{"-i", "42", "java -jar server.jar"}},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.Expected, expandVersionFlag(test.Argv), "Test #%v", i)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	defer func(version string) { lib.Version = version }(lib.Version)
+	lib.Version = ""
+	assert.Equal(t, "dgbridge (development build)", CliArgs{}.Version())
+	lib.Version = "v1.2.0"
+	assert.Equal(t, "dgbridge v1.2.0", CliArgs{}.Version())
+}
+
+func TestUpdateCheck(t *testing.T) {
+	defer func(version, url string) {
+		lib.Version = version
+		githubApiUrl = url
+	}(lib.Version, githubApiUrl)
+	latest := `{"tag_name": "v1.3.0", "html_url": "https://github.com/SootyOwl/dgbridge/releases/tag/v1.3.0", "body": "## Changes\n\n- Faster"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/SootyOwl/dgbridge/releases/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(latest))
+	}))
+	defer server.Close()
+	githubApiUrl = server.URL
+
+	tests := []struct {
+		Version  string
+		Repo     string
+		Expected string
+	}{
+		{"v1.2.0", "SootyOwl/dgbridge", "dgbridge v1.3.0 is available (running v1.2.0): https://github.com/SootyOwl/dgbridge/releases/tag/v1.3.0\n- Faster"},
+		{"v1.3.0", "SootyOwl/dgbridge", ""},
+		{"v1.4.0", "SootyOwl/dgbridge", ""},
+		// Development builds aren't checked.
+		{"", "SootyOwl/dgbridge", ""},
+		// Failed checks are only logged.
+		{"v1.2.0", "SootyOwl/missing", ""},
+	}
+	for i, test := range tests {
+		lib.Version = test.Version
+		assert.Equal(t, test.Expected, startUpdateCheck(test.Repo).wait(), "Test #%v", i)
+	}
+}
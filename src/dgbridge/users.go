@@ -0,0 +1,410 @@
+package main
+
+// This file implements the "dgbridge users" subcommands, which manage user map
+// files.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+	"github.com/bwmarrin/discordgo"
+)
+
+type UsersArgs struct {
+	Export  *UsersExportArgs `arg:"subcommand:export" help:"Convert a user map file to JSON or CSV"`
+	Import  *UsersImportArgs `arg:"subcommand:import" help:"Merge JSON or CSV user map files into a user map file"`
+	Sync    *UsersSyncArgs   `arg:"subcommand:sync" help:"Add guild members whose display name matches an in-game name to a user map file"`
+	Encrypt *UsersCryptArgs  `arg:"subcommand:encrypt" help:"Encrypt a user map file"`
+	Decrypt *UsersCryptArgs  `arg:"subcommand:decrypt" help:"Decrypt a user map file"`
+	Keygen  *struct{}        `arg:"subcommand:keygen" help:"Generate a key for encrypting user map files"`
+}
+
+type UsersExportArgs struct {
+	Input   string `arg:"required,positional" help:"User map file to read, may be encrypted"`
+	Output  string `arg:"required,positional" help:"File to write, the format is chosen by its extension (.csv or .json)"`
+	KeyFile string `arg:"--key_file" help:"File with the base64-encoded key, defaults to the DGBRIDGE_USERS_KEY environment variable"`
+}
+
+type UsersImportArgs struct {
+	Output    string   `arg:"required,positional" help:"User map file to merge into, created if missing, stays encrypted if it is"`
+	Inputs    []string `arg:"required,positional" help:"JSON or CSV files to import"`
+	Overwrite bool     `arg:"--overwrite" help:"On conflicts, use the imported entry instead of keeping the existing one"`
+	KeyFile   string   `arg:"--key_file" help:"File with the base64-encoded key, defaults to the DGBRIDGE_USERS_KEY environment variable"`
+}
+
+type UsersSyncArgs struct {
+	Token     string `arg:"required,-t,--token,env:DISCORD_TOKEN" help:"Discord authentication token"`
+	ChannelId string `arg:"required,-i,--channel_id" help:"Discord channel ID, members of its guild are matched"`
+	Output    string `arg:"required,positional" help:"User map file to update, created if missing, stays encrypted if it is"`
+	NamesFile string `arg:"--names" help:"File with one in-game name per line to match, defaults to the names in the user map file, or every member if it is empty"`
+	DryRun    bool   `arg:"--dry_run" help:"Only print the changes, don't write the file"`
+	Overwrite bool   `arg:"--overwrite" help:"Update names that are already mapped to a different user"`
+	KeyFile   string `arg:"--key_file" help:"File with the base64-encoded key, defaults to the DGBRIDGE_USERS_KEY environment variable"`
+}
+
+type UsersCryptArgs struct {
+	Input   string `arg:"required,positional" help:"File to read"`
+	Output  string `arg:"required,positional" help:"File to write"`
+	KeyFile string `arg:"--key_file" help:"File with the base64-encoded key, defaults to the DGBRIDGE_USERS_KEY environment variable"`
+}
+
+// runUsersCommand runs a "dgbridge users" subcommand.
+//
+// Parameters:
+//
+//	argv: command line arguments following "users"
+func runUsersCommand(argv []string) {
+	var args UsersArgs
+	parser, err := arg.NewParser(arg.Config{
+		Program: "dgbridge users",
+		Exit:    os.Exit,
+		Out:     os.Stdout,
+	}, &args)
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+	parser.MustParse(argv)
+
+	switch {
+	case args.Export != nil:
+		err = exportUserMap(*args.Export)
+	case args.Import != nil:
+		err = importUserMaps(*args.Import)
+	case args.Sync != nil:
+		err = syncUserMap(*args.Sync)
+	case args.Encrypt != nil:
+		err = convertUserMapFile(*args.Encrypt, true)
+	case args.Decrypt != nil:
+		err = convertUserMapFile(*args.Decrypt, false)
+	case args.Keygen != nil:
+		var key string
+		key, err = lib.GenerateUserMapKey()
+		if err == nil {
+			fmt.Println(key)
+		}
+	default:
+		parser.Fail("missing subcommand")
+	}
+	if err != nil {
+		log.Fatalln("[fatal]", err)
+	}
+}
+
+// exportUserMap converts a user map file to the format of the output file.
+// Invalid entries are reported, but exported anyway.
+func exportUserMap(args UsersExportArgs) error {
+	key, err := lib.LoadUserMapKey(args.KeyFile)
+	if err != nil {
+		return err
+	}
+	userMap, _, err := readUserMapFile(args.Input, key)
+	if err != nil {
+		return err
+	}
+	for _, err := range userMap.Validate() {
+		log.Println("[warning]", err)
+	}
+	return writeUserMapFile(args.Output, userMap, nil)
+}
+
+// importUserMaps merges user map files into another user map file.
+// Invalid entries are skipped, and conflicts between files are reported.
+func importUserMaps(args UsersImportArgs) error {
+	key, err := lib.LoadUserMapKey(args.KeyFile)
+	if err != nil {
+		return err
+	}
+	userMap := lib.UserMap{}
+	encrypted := false
+	if _, err := os.Stat(args.Output); err == nil {
+		userMap, encrypted, err = readUserMapFile(args.Output, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	imported, skipped, conflicts := 0, 0, 0
+	for _, input := range args.Inputs {
+		inputMap, _, err := readUserMapFile(input, key)
+		if err != nil {
+			return err
+		}
+		for _, err := range inputMap.Validate() {
+			log.Printf("[warning] %v: skipping %v\n", input, err)
+		}
+		valid := lib.UserMap{}
+		for name, id := range inputMap {
+			if lib.IsSnowflake(id) {
+				valid[name] = id
+			} else {
+				skipped++
+			}
+		}
+		for _, conflict := range userMap.Merge(valid, args.Overwrite) {
+			kept := conflict.ExistingId
+			if args.Overwrite {
+				kept = conflict.NewId
+			}
+			log.Printf("[warning] %v: conflict for %q: existing %v, imported %v, keeping %v\n",
+				input, conflict.Name, conflict.ExistingId, conflict.NewId, kept)
+			conflicts++
+		}
+		imported += len(valid)
+	}
+
+	var writeKey []byte
+	if encrypted {
+		writeKey = key
+	}
+	if err := writeUserMapFile(args.Output, userMap, writeKey); err != nil {
+		return err
+	}
+	log.Printf("[info] Imported %v entries (%v invalid entries skipped, %v conflicts), %v now has %v entries\n",
+		imported, skipped, conflicts, args.Output, len(userMap))
+	return nil
+}
+
+// syncUserMap matches in-game names to the display names of guild members, and
+// adds the matches to a user map file.
+func syncUserMap(args UsersSyncArgs) error {
+	key, err := lib.LoadUserMapKey(args.KeyFile)
+	if err != nil {
+		return err
+	}
+	userMap := lib.UserMap{}
+	encrypted := false
+	if _, err := os.Stat(args.Output); err == nil {
+		userMap, encrypted, err = readUserMapFile(args.Output, key)
+		if err != nil {
+			return err
+		}
+	}
+	names := userMap.Names()
+	if args.NamesFile != "" {
+		contents, err := os.ReadFile(args.NamesFile)
+		if err != nil {
+			return err
+		}
+		names = strings.Fields(string(contents))
+	}
+
+	members, err := fetchGuildMembers(args.Token, args.ChannelId)
+	if err != nil {
+		return err
+	}
+	matched, ambiguous := matchMemberNames(names, members)
+	for _, name := range ambiguous {
+		log.Printf("[warning] %q matches several guild members, skipping\n", name)
+	}
+
+	added := 0
+	for _, name := range matched.Names() {
+		if _, ok := userMap.Lookup(name); !ok {
+			log.Printf("[info] + %v: %v\n", name, matched[name])
+			added++
+		}
+	}
+	conflicts := userMap.Merge(matched, args.Overwrite)
+	for _, conflict := range conflicts {
+		action := "keeping"
+		if args.Overwrite {
+			action = "updating"
+		}
+		log.Printf("[info] ~ %v: %v, guild member %v matches, %v\n",
+			conflict.Name, conflict.ExistingId, conflict.NewId, action)
+	}
+	log.Printf("[info] %v names added, %v conflicts, %v ambiguous\n", added, len(conflicts), len(ambiguous))
+	if args.DryRun {
+		log.Println("[info] Dry run, not writing", args.Output)
+		return nil
+	}
+
+	var writeKey []byte
+	if encrypted {
+		writeKey = key
+	}
+	return writeUserMapFile(args.Output, userMap, writeKey)
+}
+
+// fetchGuildMembers fetches all members of the guild of a channel.
+// This requires the Server Members privileged intent to be enabled for the bot.
+func fetchGuildMembers(token string, channelId string) ([]*discordgo.Member, error) {
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Discord session: %v", err)
+	}
+	channel, err := session.Channel(channelId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching channel: %v", err)
+	}
+	var members []*discordgo.Member
+	after := ""
+	for {
+		page, err := session.GuildMembers(channel.GuildID, after, 1000)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching guild members: %v", err)
+		}
+		members = append(members, page...)
+		if len(page) < 1000 {
+			return members, nil
+		}
+		after = page[len(page)-1].User.ID
+	}
+}
+
+// matchMemberNames finds the guild member of each in-game name, by comparing it
+// case-insensitively to the members' nicknames, global names and usernames.
+// If names is empty, every member whose display name is a valid in-game name
+// (letters, digits and underscores) is matched to it. Bots are ignored.
+//
+// Returns:
+//
+//	the matched names, and the names that match several members
+func matchMemberNames(names []string, members []*discordgo.Member) (lib.UserMap, []string) {
+	if len(names) == 0 {
+		for _, member := range members {
+			if !member.User.Bot && inGameNameRegex.MatchString(member.DisplayName()) {
+				names = append(names, member.DisplayName())
+			}
+		}
+	}
+	matched := lib.UserMap{}
+	var ambiguous []string
+	for _, name := range names {
+		id := ""
+		for _, member := range members {
+			if member.User.Bot || !memberHasName(member, name) {
+				continue
+			}
+			if id != "" && id != member.User.ID {
+				id = ""
+				ambiguous = append(ambiguous, name)
+				break
+			}
+			id = member.User.ID
+		}
+		if id != "" {
+			matched[name] = id
+		}
+	}
+	return matched, ambiguous
+}
+
+// inGameNameRegex matches names that can be mentioned in subprocess output.
+var inGameNameRegex = regexp.MustCompile(`^\w+$`)
+
+func memberHasName(member *discordgo.Member, name string) bool {
+	return strings.EqualFold(member.Nick, name) ||
+		strings.EqualFold(member.User.GlobalName, name) ||
+		strings.EqualFold(member.User.Username, name)
+}
+
+// readUserMapFile reads a user map file in the format given by its extension.
+// JSON files may be encrypted.
+//
+// Returns:
+//
+//	the user map, and whether the file was encrypted
+func readUserMapFile(path string, key []byte) (lib.UserMap, bool, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	var userMap lib.UserMap
+	if isCsvFile(path) {
+		userMap, err = lib.ParseUserMapCSV(contents)
+	} else {
+		userMap, err = lib.ParseUserMap(contents, key)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error loading %v: %v", path, err)
+	}
+	return userMap, lib.IsEncryptedUserMap(contents), nil
+}
+
+// writeUserMapFile writes a user map file in the format given by its
+// extension. If key is not nil, the file is encrypted (JSON only). Entries
+// with aliases of a JSON file being overwritten keep their shape, see
+// lib.MarshalUserMap.
+func writeUserMapFile(path string, userMap lib.UserMap, key []byte) error {
+	var contents []byte
+	var err error
+	if isCsvFile(path) {
+		if key != nil {
+			return fmt.Errorf("CSV files can't be encrypted")
+		}
+		contents, err = userMap.MarshalCSV()
+	} else {
+		contents, err = lib.MarshalUserMap(userMap, readPreviousUserMap(path, key))
+	}
+	if err == nil && key != nil {
+		contents, err = lib.EncryptUserMap(contents, key)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0600)
+}
+
+// readPreviousUserMap returns the decrypted contents of the JSON user map file
+// about to be overwritten, or nil if it can't be read.
+func readPreviousUserMap(path string, key []byte) []byte {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	if lib.IsEncryptedUserMap(contents) {
+		if key == nil {
+			return nil
+		}
+		if contents, err = lib.DecryptUserMap(contents, key); err != nil {
+			return nil
+		}
+	}
+	return contents
+}
+
+func isCsvFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".csv")
+}
+
+// convertUserMapFile encrypts or decrypts a user map file.
+func convertUserMapFile(args UsersCryptArgs, encrypt bool) error {
+	key, err := lib.LoadUserMapKey(args.KeyFile)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("no key given, use --key_file or set %v", lib.UserMapKeyEnv)
+	}
+	contents, err := os.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+	// Parsing validates the input and decrypts it if needed.
+	if _, err := lib.ParseUserMap(contents, key); err != nil {
+		return fmt.Errorf("error loading user map: %v", err)
+	}
+	var output []byte
+	if encrypt {
+		if lib.IsEncryptedUserMap(contents) {
+			return fmt.Errorf("%v is already encrypted", args.Input)
+		}
+		output, err = lib.EncryptUserMap(contents, key)
+	} else {
+		if !lib.IsEncryptedUserMap(contents) {
+			return fmt.Errorf("%v is not encrypted", args.Input)
+		}
+		output, err = lib.DecryptUserMap(contents, key)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(args.Output, output, 0600)
+}
@@ -0,0 +1,132 @@
+package main
+
+// This file implements the moderation slash commands, /whitelist and /ban,
+// which run the workflows of the rules file, see lib.Workflows.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// workflowCommands returns the slash commands of the configured workflows.
+func (self *BotContext) workflowCommands() []*discordgo.ApplicationCommand {
+	workflows := self.rules.Load().Workflows
+	playerOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "player",
+		Description: "In-game name of the player",
+		Required:    true,
+	}
+	var commands []*discordgo.ApplicationCommand
+	var whitelist []*discordgo.ApplicationCommandOption
+	if workflows.Get(lib.WorkflowWhitelistAdd) != nil {
+		whitelist = append(whitelist, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "add",
+			Description: "Add a player to the whitelist",
+			Options:     []*discordgo.ApplicationCommandOption{playerOption},
+		})
+	}
+	if workflows.Get(lib.WorkflowWhitelistRemove) != nil {
+		whitelist = append(whitelist, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "remove",
+			Description: "Remove a player from the whitelist",
+			Options:     []*discordgo.ApplicationCommandOption{playerOption},
+		})
+	}
+	if len(whitelist) > 0 {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:                     "whitelist",
+			Description:              "Manage the server's whitelist",
+			DefaultMemberPermissions: &adminPermissions,
+			Options:                  whitelist,
+		})
+	}
+	if workflows.Get(lib.WorkflowBan) != nil {
+		commands = append(commands, &discordgo.ApplicationCommand{
+			Name:                     "ban",
+			Description:              "Ban a player from the server",
+			DefaultMemberPermissions: &adminPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				playerOption,
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "reason",
+					Description: "Reason shown to the player",
+				},
+			},
+		})
+	}
+	return commands
+}
+
+// workflowCommand handles /whitelist add, /whitelist remove and /ban. The
+// response is deferred until the console confirmed the command, or the
+// workflow timed out.
+func (self *BotContext) workflowCommand(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ApplicationCommandInteractionData) {
+	options := data.Options
+	name := lib.WorkflowBan
+	if data.Name == "whitelist" {
+		if len(options) == 0 {
+			return
+		}
+		name = lib.WorkflowWhitelistAdd
+		if options[0].Name == "remove" {
+			name = lib.WorkflowWhitelistRemove
+		}
+		options = options[0].Options
+	}
+	workflow := self.rules.Load().Workflows.Get(name)
+	if workflow == nil {
+		return
+	}
+	values := make(map[string]string)
+	for _, option := range options {
+		values[option.Name] = option.StringValue()
+	}
+	player, reason := values["player"], values["reason"]
+	if err := lib.ValidWorkflowPlayer(player); err != nil {
+		self.respond(s, i, fmt.Sprintf("Invalid player name %q.", player))
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		log.Printf("[error] error responding to interaction: %v", err)
+		return
+	}
+	result := self.workflows.Run(workflow, player, reason, func(command string) {
+		log.Printf("[info] %v ran %v for %v: %q\n", i.Member.User.Username, name, player, command)
+		self.subprocess.WriteLine(stdinLine{Text: command})
+	})
+	// Keep the line from closing the code span early.
+	line := strings.ReplaceAll(result.Line, "`", "'")
+	var content string
+	switch {
+	case result.TimedOut:
+		content = fmt.Sprintf("The server didn't confirm the command for %v, check the console.", player)
+	case result.Success:
+		content = fmt.Sprintf("Done: `%v`", line)
+	default:
+		content = fmt.Sprintf("Failed: `%v`", line)
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+		log.Printf("[error] error responding to interaction: %v", err)
+	}
+}
+
+// observeWorkflows continuously passes the subprocess' output to the running
+// workflow, see lib.WorkflowRunner.
+func (self *BotContext) observeWorkflows(lineCh <-chan OutputLine) {
+	for line := range lineCh {
+		self.workflows.Observe(line.Text)
+	}
+}
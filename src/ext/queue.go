@@ -0,0 +1,49 @@
+package ext
+
+// DroppingQueue is a bounded FIFO queue with a single producer. When the queue
+// is full, pushing an item drops the oldest item instead of blocking.
+type DroppingQueue[T any] struct {
+	channel chan T
+}
+
+// NewDroppingQueue creates a DroppingQueue that holds up to capacity items.
+// A queue with zero capacity only delivers items to a consumer that is
+// currently waiting on Chan.
+func NewDroppingQueue[T any](capacity int) *DroppingQueue[T] {
+	return &DroppingQueue[T]{
+		channel: make(chan T, capacity),
+	}
+}
+
+// Push adds an item to the queue without blocking.
+// Must not be called concurrently.
+//
+// Returns:
+//
+//	true if an item was dropped to make room
+func (q *DroppingQueue[T]) Push(item T) bool {
+	select {
+	case q.channel <- item:
+		return false
+	default:
+	}
+	if cap(q.channel) == 0 {
+		// Nobody is waiting for the item.
+		return true
+	}
+	// The queue is full, drop the oldest item. The consumer may have taken
+	// an item in the meantime, in which case nothing needs to be dropped.
+	dropped := false
+	select {
+	case <-q.channel:
+		dropped = true
+	default:
+	}
+	q.channel <- item
+	return dropped
+}
+
+// Chan returns the channel items are received from.
+func (q *DroppingQueue[T]) Chan() <-chan T {
+	return q.channel
+}
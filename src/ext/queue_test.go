@@ -0,0 +1,19 @@
+package ext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDroppingQueue(t *testing.T) {
+	q := NewDroppingQueue[int](2)
+	assert.False(t, q.Push(1))
+	assert.False(t, q.Push(2))
+	assert.True(t, q.Push(3))
+	assert.Equal(t, 2, <-q.Chan())
+	assert.Equal(t, 3, <-q.Chan())
+
+	unbuffered := NewDroppingQueue[int](0)
+	assert.True(t, unbuffered.Push(1))
+}
@@ -0,0 +1,45 @@
+package ext
+
+import "sync"
+
+// RingBuffer keeps the most recent items pushed to it, up to a fixed capacity.
+// It is safe for concurrent use.
+type RingBuffer[T any] struct {
+	mutex sync.Mutex
+	items []T
+	next  int  // Index the next item is written to
+	full  bool // Whether all slots hold an item
+}
+
+// NewRingBuffer creates a RingBuffer that keeps up to capacity items.
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	return &RingBuffer[T]{
+		items: make([]T, capacity),
+	}
+}
+
+// Push adds an item, overwriting the oldest one if the buffer is full.
+func (b *RingBuffer[T]) Push(item T) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.items) == 0 {
+		return
+	}
+	b.items[b.next] = item
+	b.next = (b.next + 1) % len(b.items)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Items returns a copy of the buffered items, oldest first.
+func (b *RingBuffer[T]) Items() []T {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.full {
+		return append([]T(nil), b.items[:b.next]...)
+	}
+	items := make([]T, 0, len(b.items))
+	items = append(items, b.items[b.next:]...)
+	return append(items, b.items[:b.next]...)
+}
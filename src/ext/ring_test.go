@@ -0,0 +1,22 @@
+package ext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingBuffer(t *testing.T) {
+	b := NewRingBuffer[int](3)
+	assert.Empty(t, b.Items())
+	b.Push(1)
+	b.Push(2)
+	assert.Equal(t, []int{1, 2}, b.Items())
+	b.Push(3)
+	b.Push(4)
+	assert.Equal(t, []int{2, 3, 4}, b.Items())
+
+	empty := NewRingBuffer[int](0)
+	empty.Push(1)
+	assert.Empty(t, empty.Items())
+}
@@ -0,0 +1,193 @@
+package ext
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatedFileTimeFormat is the timestamp appended to names of rotated files.
+// It sorts chronologically.
+const rotatedFileTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an io.Writer that appends to a file, and rotates the file
+// when it grows too large or gets too old. Rotated files are renamed to
+// <path>.<timestamp> and gzip-compressed to <path>.<timestamp>.gz in the
+// background.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	opened   time.Time
+	now      func() time.Time
+	compress sync.WaitGroup
+}
+
+// NewRotatingFile opens a RotatingFile, appending to path if it exists.
+//
+// Parameters:
+//
+//	maxSize: size in bytes after which the file is rotated, 0 for no limit
+//	maxAge: time after which the file is rotated, 0 for no limit
+//	maxBackups: number of rotated files kept, 0 to keep all
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the file, rotating the file first if p would exceed the
+// size limit or the file is older than the age limit.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file and waits for rotated files to be compressed.
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mutex.Unlock()
+	f.compress.Wait()
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+	return nil
+}
+
+func (f *RotatingFile) shouldRotate(writeSize int) bool {
+	if f.size == 0 {
+		// Never rotate an empty file, even if a single write is too large.
+		return false
+	}
+	if f.maxSize > 0 && f.size+int64(writeSize) > f.maxSize {
+		return true
+	}
+	return f.maxAge > 0 && f.now().Sub(f.opened) >= f.maxAge
+}
+
+// rotate renames the current file, opens a new one and compresses the old one
+// in the background.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	rotatedPath := f.path + "." + f.now().Format(rotatedFileTimeFormat)
+	for i := 1; fileExists(rotatedPath) || fileExists(rotatedPath+".gz"); i++ {
+		rotatedPath = fmt.Sprintf("%v.%v.%v", f.path, f.now().Format(rotatedFileTimeFormat), i)
+	}
+	if err := os.Rename(f.path, rotatedPath); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.compress.Add(1)
+	go func() {
+		defer f.compress.Done()
+		if err := compressFile(rotatedPath); err != nil {
+			// The uncompressed file is kept; there is nobody to report to.
+			return
+		}
+		f.removeOldBackups()
+	}()
+	return nil
+}
+
+// removeOldBackups removes the oldest rotated files beyond maxBackups.
+func (f *RotatingFile) removeOldBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	backups, err := filepath.Glob(f.path + ".*.gz")
+	if err != nil {
+		return
+	}
+	sort.Strings(backups)
+	for len(backups) > f.maxBackups {
+		_ = os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// compressFile gzip-compresses a file to <path>.gz and removes the original.
+func compressFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(target)
+	_, err = io.Copy(writer, source)
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	_ = source.Close()
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
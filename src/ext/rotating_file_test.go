@@ -0,0 +1,59 @@
+package ext
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.log")
+	f, err := NewRotatingFile(path, 10, time.Hour, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	clock := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		assert.NoError(t, err)
+		clock = clock.Add(time.Second)
+	}
+	// Rotated because of its age.
+	clock = clock.Add(time.Hour)
+	_, err = f.Write([]byte("fifth\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	current, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "fifth\n", string(current))
+
+	backups, err := filepath.Glob(path + ".*.gz")
+	assert.NoError(t, err)
+	if assert.Len(t, backups, 2) {
+		assert.Equal(t, "third\n", readGzip(t, backups[0]))
+		assert.Equal(t, "fourth\n", readGzip(t, backups[1]))
+	}
+}
+
+func readGzip(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	contents, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return string(contents)
+}
@@ -0,0 +1,83 @@
+package ext
+
+import (
+	"sync"
+	"time"
+)
+
+// TTLCache is a map whose entries expire after a fixed time to live. Expired
+// entries are kept until overwritten or evicted, so that callers may fall back
+// to them. Once the cache is full, setting a new key evicts the expired
+// entries, or the entry closest to expiring if none has expired.
+// It is safe for concurrent use.
+type TTLCache[K comparable, V any] struct {
+	ttl      time.Duration
+	capacity int
+	now      func() time.Time
+	mutex    sync.Mutex
+	entries  map[K]ttlEntry[V]
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// NewTTLCache creates a TTLCache whose entries expire after ttl, holding up to
+// capacity entries.
+func NewTTLCache[K comparable, V any](ttl time.Duration, capacity int) *TTLCache[K, V] {
+	return &TTLCache[K, V]{
+		ttl:      ttl,
+		capacity: max(capacity, 1),
+		now:      time.Now,
+		entries:  make(map[K]ttlEntry[V]),
+	}
+}
+
+// Get returns the value of a key, if it is set and hasn't expired.
+func (c *TTLCache[K, V]) Get(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Stale returns the value of a key, even if it has expired.
+func (c *TTLCache[K, V]) Stale(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	return entry.value, ok
+}
+
+// Set sets the value of a key.
+func (c *TTLCache[K, V]) Set(key K, value V) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.capacity {
+		c.evict(now)
+	}
+	c.entries[key] = ttlEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
+
+// evict makes room for an entry: it deletes the expired entries, or the entry
+// closest to expiring if none has expired.
+func (c *TTLCache[K, V]) evict(now time.Time) {
+	var oldest K
+	var oldestExpires time.Time
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		} else if oldestExpires.IsZero() || entry.expires.Before(oldestExpires) {
+			oldest, oldestExpires = key, entry.expires
+		}
+	}
+	if len(c.entries) >= c.capacity {
+		delete(c.entries, oldest)
+	}
+}
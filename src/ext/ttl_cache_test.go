@@ -0,0 +1,58 @@
+package ext
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLCache(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := NewTTLCache[string, int](time.Minute, 10)
+	c.now = func() time.Time { return now }
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	c.Set("a", 1)
+	value, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	value, ok = c.Stale("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	_, ok = c.Stale("b")
+	assert.False(t, ok)
+
+	c.Set("a", 2)
+	value, ok = c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+}
+
+func TestTTLCacheCapacity(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := NewTTLCache[string, int](time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	now = now.Add(time.Second)
+	c.Set("b", 2)
+	c.Set("b", 3)
+	// The entry closest to expiring makes room.
+	c.Set("c", 4)
+	_, ok := c.Stale("a")
+	assert.False(t, ok)
+	value, ok := c.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, 3, value)
+
+	// Expired entries make room first.
+	now = now.Add(time.Minute)
+	c.Set("d", 5)
+	assert.Len(t, c.entries, 1)
+}
@@ -0,0 +1,141 @@
+package lib
+
+// This file implements approvals of console commands from Discord: the results
+// of DiscordToSubprocess rules with RequireApproval are held until a moderator
+// approves them, e.g. for commands that stop the server or wipe a world.
+
+import (
+	"dgbridge/src/ext"
+	"sync"
+	"time"
+)
+
+// DefaultApprovalTimeout is the time a command waits for approval, see
+// ApprovalConfig.Timeout.
+const DefaultApprovalTimeout = 5 * time.Minute
+
+// ApprovalConfig configures the approval of rules with RequireApproval.
+type ApprovalConfig struct {
+	// Optional, the members who may approve besides administrators, e.g.
+	// {"Roles": ["Moderator"]}
+	Approvers *AuthorCondition
+	// Optional, let the author of a command approve it, e.g. a moderator
+	// confirming a command they sent; authors may always deny their commands
+	AllowSelf bool
+	Timeout   ext.Duration // Optional, see DefaultApprovalTimeout
+}
+
+// ApprovalTimeout returns the time a command waits for approval.
+func (c *ApprovalConfig) ApprovalTimeout() time.Duration {
+	if c == nil || c.Timeout.Duration <= 0 {
+		return DefaultApprovalTimeout
+	}
+	return c.Timeout.Duration
+}
+
+// MayApprove reports whether a member may approve a command.
+//
+// Parameters:
+//
+//	admin: whether the member is an administrator
+//	approval: the command
+func (c *ApprovalConfig) MayApprove(member Author, admin bool, approval PendingApproval) bool {
+	if member.Id == approval.Event.Props.Author.Id && !(c != nil && c.AllowSelf) {
+		return false
+	}
+	return admin || (c != nil && c.Approvers != nil && c.Approvers.Matches(member))
+}
+
+// PendingApproval is a command that waits for approval.
+type PendingApproval struct {
+	Id        string    // Random ID, e.g. of the buttons approving it
+	Event     *Event    // The matched Discord message
+	MessageId string    // Message asking for approval, empty until it was sent
+	Expires   time.Time // Time the command is dropped unless approved
+}
+
+// PendingApprovals are the commands that wait for approval. It is safe for
+// concurrent use.
+type PendingApprovals struct {
+	mutex     sync.Mutex
+	ttl       time.Duration
+	approvals map[string]*PendingApproval // By ID
+}
+
+// NewPendingApprovals creates a PendingApprovals.
+//
+// Parameters:
+//
+//	ttl: time a command waits for approval
+func NewPendingApprovals(ttl time.Duration) *PendingApprovals {
+	return &PendingApprovals{ttl: ttl, approvals: make(map[string]*PendingApproval)}
+}
+
+// Add adds a command that waits for approval.
+func (p *PendingApprovals) Add(event *Event, now time.Time) PendingApproval {
+	approval := &PendingApproval{Id: randomHex(8), Event: event, Expires: now.Add(p.ttl)}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.approvals[approval.Id] = approval
+	return *approval
+}
+
+// SetMessage sets the message asking for approval of a command.
+func (p *PendingApprovals) SetMessage(id string, messageId string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if approval, ok := p.approvals[id]; ok {
+		approval.MessageId = messageId
+	}
+}
+
+// Get returns a command that waits for approval.
+//
+// Returns:
+//
+//	the command, and false if it expired or was approved or denied
+func (p *PendingApprovals) Get(id string, now time.Time) (PendingApproval, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	approval, ok := p.approvals[id]
+	if !ok || !now.Before(approval.Expires) {
+		return PendingApproval{}, false
+	}
+	return *approval, true
+}
+
+// Take removes a command that waits for approval, once it was approved or
+// denied.
+//
+// Returns:
+//
+//	the command, and false if it expired or was approved or denied already
+func (p *PendingApprovals) Take(id string, now time.Time) (PendingApproval, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	approval, ok := p.approvals[id]
+	if !ok || !now.Before(approval.Expires) {
+		// Expired commands are left to Expire.
+		return PendingApproval{}, false
+	}
+	delete(p.approvals, id)
+	return *approval, true
+}
+
+// Expire removes the commands whose approval timed out.
+//
+// Returns:
+//
+//	the removed commands
+func (p *PendingApprovals) Expire(now time.Time) []PendingApproval {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var expired []PendingApproval
+	for id, approval := range p.approvals {
+		if !now.Before(approval.Expires) {
+			expired = append(expired, *approval)
+			delete(p.approvals, id)
+		}
+	}
+	return expired
+}
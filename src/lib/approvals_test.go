@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"dgbridge/src/ext"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingApprovals(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	approvals := NewPendingApprovals(time.Minute)
+	event := NewEvent(EventSourceDiscord, DirectionDiscordToSubprocess, "!stop", now)
+	first := approvals.Add(event, now)
+	second := approvals.Add(event, now.Add(30*time.Second))
+	assert.NotEqual(t, first.Id, second.Id)
+	approvals.SetMessage(first.Id, "123")
+
+	pending, ok := approvals.Get(first.Id, now)
+	assert.True(t, ok)
+	assert.Equal(t, "123", pending.MessageId)
+	assert.Same(t, event, pending.Event)
+
+	taken, ok := approvals.Take(first.Id, now.Add(59*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, first.Id, taken.Id)
+	_, ok = approvals.Take(first.Id, now)
+	assert.False(t, ok, "commands are approved once")
+
+	_, ok = approvals.Take(second.Id, now.Add(90*time.Second))
+	assert.False(t, ok, "the approval timed out")
+	assert.Empty(t, approvals.Expire(now.Add(89*time.Second)))
+	expired := approvals.Expire(now.Add(90 * time.Second))
+	if assert.Len(t, expired, 1) {
+		assert.Equal(t, second.Id, expired[0].Id)
+	}
+	assert.Empty(t, approvals.Expire(now.Add(time.Hour)))
+}
+
+func TestApprovalConfigMayApprove(t *testing.T) {
+	event := NewEvent(EventSourceDiscord, DirectionDiscordToSubprocess, "!stop", time.Now())
+	event.Props = &Props{Author: Author{Id: "1"}}
+	approval := PendingApproval{Event: event}
+	moderator := Author{Id: "2", Roles: []Role{{Id: "10", Name: "Moderator"}}}
+	member := Author{Id: "3"}
+
+	var config *ApprovalConfig
+	assert.True(t, config.MayApprove(member, true, approval), "administrators may approve")
+	assert.False(t, config.MayApprove(moderator, false, approval))
+	assert.False(t, config.MayApprove(Author{Id: "1"}, true, approval), "authors may not approve their commands")
+	assert.Equal(t, DefaultApprovalTimeout, config.ApprovalTimeout())
+
+	config = &ApprovalConfig{
+		Approvers: &AuthorCondition{Roles: []string{"moderator"}},
+		AllowSelf: true,
+		Timeout:   ext.Duration{Duration: time.Minute},
+	}
+	assert.True(t, config.MayApprove(moderator, false, approval))
+	assert.False(t, config.MayApprove(member, false, approval))
+	assert.True(t, config.MayApprove(Author{Id: "1"}, true, approval))
+	assert.Equal(t, time.Minute, config.ApprovalTimeout())
+}
@@ -0,0 +1,186 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// arithmeticRegex matches template expressions that operate on a capture group.
+//
+// Examples:
+//   - ${1/20} divides capture group 1 by 20
+//   - ${bytes/1048576|%.1f} divides the named group "bytes" and formats it
+//   - ${1|%d} coerces capture group 1 to an integer
+//   - ${1|num} and ${1|time:R} format capture group 1, see parseModifier
+//
+// Plain group references such as ${1} are left to the regex engine.
+var arithmeticRegex = regexp.MustCompile(`\$\{(\w+)\s*(?:([-+*/%])\s*(-?[0-9]*\.?[0-9]+))?\s*(?:\|([^}]*))?}`)
+
+// expandTemplate replaces every match of re in input with the expanded template,
+// the same way regexp.Regexp.ReplaceAllString does, but evaluates conditional
+// sections and arithmetic expressions against each match first.
+//
+// Parameters:
+//
+//	format: configures the named modifiers, see Rule.valueFormat
+func expandTemplate(re *regexp.Regexp, input string, template string, format valueFormat) string {
+	if !arithmeticRegex.MatchString(template) && !hasConditionals(template) {
+		return re.ReplaceAllString(input, template)
+	}
+	var result []byte
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(input, -1) {
+		result = append(result, input[last:match[0]]...)
+		result = re.ExpandString(result, evaluateArithmetic(re, evaluateConditionals(re, template, input, match), input, match, format), input, match)
+		last = match[1]
+	}
+	result = append(result, input[last:]...)
+	return string(result)
+}
+
+// expandEach applies a template to every match of a regex in the input, like
+// expandTemplate, but only keeps the results, joined with joiner.
+func expandEach(re *regexp.Regexp, input string, template string, joiner string, format valueFormat) string {
+	var results []string
+	for _, match := range re.FindAllStringSubmatchIndex(input, -1) {
+		results = append(results, string(re.ExpandString(nil, evaluateArithmetic(re, evaluateConditionals(re, template, input, match), input, match, format), input, match)))
+	}
+	return strings.Join(results, joiner)
+}
+
+// evaluateArithmetic replaces all arithmetic expressions in a template with
+// their results for a single match. If an expression can not be evaluated
+// (e.g. the capture is not a number), the raw capture is inserted instead.
+func evaluateArithmetic(re *regexp.Regexp, template string, input string, match []int, modifiers valueFormat) string {
+	return arithmeticRegex.ReplaceAllStringFunc(template, func(expression string) string {
+		parts := arithmeticRegex.FindStringSubmatch(expression)
+		group, operator, operand, format := parts[1], parts[2], parts[3], parts[4]
+		if operator == "" && format == "" {
+			// Plain group reference, let the regex engine expand it.
+			return expression
+		}
+		captured := string(re.ExpandString(nil, "${"+group+"}", input, match))
+		modifier, argument, named := parseModifier(format)
+		value, err := strconv.ParseFloat(strings.TrimSpace(captured), 64)
+		if err != nil {
+			if named && modifier == modifierTime && operator == "" {
+				if parsed, ok := modifiers.parseTimestamp(captured); ok {
+					return discordTimestamp(parsed, argument)
+				}
+			}
+			return escapeDollars(captured)
+		}
+		if operator != "" {
+			// The operand was already validated by arithmeticRegex.
+			right, _ := strconv.ParseFloat(operand, 64)
+			value, err = applyOperator(value, operator, right)
+			if err != nil {
+				return escapeDollars(captured)
+			}
+		}
+		if named {
+			return escapeDollars(modifiers.applyModifier(value, modifier, argument))
+		}
+		return escapeDollars(formatNumber(value, format))
+	})
+}
+
+// applyOperator applies a binary arithmetic operator to two numbers.
+func applyOperator(left float64, operator string, right float64) (float64, error) {
+	switch operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	case "%":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return math.Mod(left, right), nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", operator)
+}
+
+// formatNumber formats a number with a printf-style format string.
+// Integer verbs (%d, %x, %X, %o, %b) round the value to the nearest integer.
+// Without a format, integral values are printed without a decimal point.
+func formatNumber(value float64, format string) string {
+	if format == "" {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	switch formatVerb(format) {
+	case 'd', 'x', 'X', 'o', 'b':
+		return fmt.Sprintf(format, int64(math.Round(value)))
+	}
+	return fmt.Sprintf(format, value)
+}
+
+// formatVerb returns the verb of the first formatting directive in a printf
+// format string, or 0 if there is none.
+func formatVerb(format string) rune {
+	verbs := formatVerbs(format)
+	if len(verbs) == 0 {
+		return 0
+	}
+	return verbs[0]
+}
+
+// formatVerbs returns the verbs of the formatting directives in a printf format
+// string, ignoring %%. A directive without a verb has the verb 0.
+func formatVerbs(format string) []rune {
+	var verbs []rune
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			i++
+			continue
+		}
+		verb := rune(0)
+		for j := i + 1; j < len(format); j++ {
+			if strings.IndexByte("+-# 0123456789.", format[j]) < 0 {
+				verb, i = rune(format[j]), j
+				break
+			}
+		}
+		verbs = append(verbs, verb)
+	}
+	return verbs
+}
+
+// checkNumberFormats checks the printf formats of the arithmetic expressions of
+// a template: each must have a single directive, with a float verb (%f, %e,
+// %g) or an integer verb that formatNumber rounds the value for.
+func checkNumberFormats(template string) error {
+	for _, parts := range arithmeticRegex.FindAllStringSubmatch(template, -1) {
+		format := parts[4]
+		if _, _, named := parseModifier(format); format == "" || named {
+			continue
+		}
+		verbs := formatVerbs(format)
+		if len(verbs) != 1 {
+			return fmt.Errorf("%v: the format must have one directive, e.g. %%.1f", parts[0])
+		}
+		if verbs[0] == 0 || !strings.ContainsRune("fFeEgGdxXob", verbs[0]) {
+			return fmt.Errorf("%v: the format of a number must use %%f, %%e, %%g or %%d", parts[0])
+		}
+	}
+	return nil
+}
+
+// escapeDollars escapes $ so that the text is inserted literally by
+// regexp.Regexp.ExpandString.
+func escapeDollars(text string) string {
+	return strings.ReplaceAll(text, "$", "$$")
+}
@@ -0,0 +1,59 @@
+package lib
+
+// This file implements conditions on the author of a Discord message, which
+// restrict the rules that apply to it, see Rule.Author.
+
+import (
+	"dgbridge/src/ext"
+	"slices"
+	"strings"
+)
+
+// AuthorCondition restricts a DiscordToSubprocess rule to the messages of
+// certain authors, e.g. to relay the messages of admins with a different
+// prefix. All conditions that are set must hold.
+type AuthorCondition struct {
+	Roles []string   // The author has one of these roles, by ID or name (case-insensitive)
+	IsBot *bool      // The author is a bot or webhook, or isn't if false
+	Ids   []string   // The author's user ID is one of these
+	Name  ext.Regexp // Matches the author's username, global name or nickname
+}
+
+// Matches reports whether an author meets the conditions.
+func (c *AuthorCondition) Matches(author Author) bool {
+	if len(c.Roles) > 0 && !c.hasRole(author) {
+		return false
+	}
+	if c.IsBot != nil && *c.IsBot != (author.IsBot || author.IsWebhook) {
+		return false
+	}
+	if len(c.Ids) > 0 && !slices.Contains(c.Ids, author.Id) {
+		return false
+	}
+	if c.Name.Regexp != nil && !c.matchesName(author) {
+		return false
+	}
+	return true
+}
+
+// hasRole reports whether the author has one of the roles of the condition.
+func (c *AuthorCondition) hasRole(author Author) bool {
+	for _, role := range author.Roles {
+		for _, wanted := range c.Roles {
+			if role.Id == wanted || strings.EqualFold(role.Name, wanted) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesName reports whether one of the author's names matches Name.
+func (c *AuthorCondition) matchesName(author Author) bool {
+	for _, name := range []string{author.Username, author.GlobalName, author.Nickname} {
+		if name != "" && c.Name.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
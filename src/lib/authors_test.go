@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mustRegexp compiles a regular expression for a test.
+func mustRegexp(t *testing.T, expr string) ext.Regexp {
+	var re ext.Regexp
+	assert.NoError(t, re.UnmarshalText([]byte(expr)))
+	return re
+}
+
+func TestAuthorConditionMatches(t *testing.T) {
+	isBot, isUser := true, false
+	admin := Author{
+		Id:       "123456789012345678",
+		Username: "alice",
+		Nickname: "Alice",
+		Roles:    []Role{{Id: "111111111111111111", Name: "Admin"}},
+	}
+	bot := Author{Id: "234567890123456789", Username: "modbot", IsBot: true}
+	webhook := Author{Username: "GitHub", IsWebhook: true}
+
+	tests := []struct {
+		name      string
+		condition AuthorCondition
+		author    Author
+		expected  bool
+	}{
+		{"No conditions", AuthorCondition{}, admin, true},
+		{"Role by name", AuthorCondition{Roles: []string{"admin"}}, admin, true},
+		{"Role by ID", AuthorCondition{Roles: []string{"111111111111111111"}}, admin, true},
+		{"Missing role", AuthorCondition{Roles: []string{"Moderator"}}, admin, false},
+		{"No roles", AuthorCondition{Roles: []string{"Admin"}}, bot, false},
+		{"Is bot", AuthorCondition{IsBot: &isBot}, bot, true},
+		{"Webhook is bot", AuthorCondition{IsBot: &isBot}, webhook, true},
+		{"Is not bot", AuthorCondition{IsBot: &isUser}, bot, false},
+		{"User is not bot", AuthorCondition{IsBot: &isUser}, admin, true},
+		{"ID in list", AuthorCondition{Ids: []string{"1", "123456789012345678"}}, admin, true},
+		{"ID not in list", AuthorCondition{Ids: []string{"1"}}, admin, false},
+		{"Name matches nickname", AuthorCondition{Name: mustRegexp(t, `^Ali`)}, admin, true},
+		{"Name doesn't match", AuthorCondition{Name: mustRegexp(t, `^Bob$`)}, admin, false},
+		{"All conditions", AuthorCondition{Roles: []string{"Admin"}, IsBot: &isUser, Name: mustRegexp(t, `alice`)}, admin, true},
+		{"One condition fails", AuthorCondition{Roles: []string{"Admin"}, IsBot: &isBot}, admin, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.condition.Matches(test.author), test.name)
+	}
+}
+
+func TestRulesMatchAuthorCondition(t *testing.T) {
+	rules := Rules{DiscordToSubprocess: make([]Rule, 2)}
+	assert.NoError(t, rules.DiscordToSubprocess[0].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[0].Template = "say [Admin] ^U: ${1}"
+	rules.DiscordToSubprocess[0].Author = &AuthorCondition{Roles: []string{"Admin"}}
+	assert.NoError(t, rules.DiscordToSubprocess[1].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[1].Template = "say <^U> ${1}"
+
+	admin := Author{Username: "Alice", Roles: []Role{{Name: "Admin"}}}
+	assert.Equal(t, "say [Admin] Alice: hi", rules.Match(DirectionDiscordToSubprocess, &Props{Author: admin}, "hi").Result)
+	assert.Equal(t, "say <Bob> hi", rules.Match(DirectionDiscordToSubprocess, &Props{Author: Author{Username: "Bob"}}, "hi").Result)
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// backfillChunkSize is the number of bytes ReadLastLines reads at a time.
+const backfillChunkSize = 64 * 1024
+
+// ReadLastLines reads the last n lines of a file, e.g. a server log, without
+// reading the whole file.
+func ReadLastLines(path string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	// Read chunks from the end until the tail has more than n line breaks (the
+	// last line may end with one).
+	var tail []byte
+	for offset > 0 && bytes.Count(tail, []byte("\n")) <= n {
+		size := int64(backfillChunkSize)
+		if size > offset {
+			size = offset
+		}
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err := file.ReadAt(chunk, offset); err != nil {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(tail), "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	lines := strings.Split(text, "\n")
+	if offset > 0 {
+		// The first line may be incomplete.
+		lines = lines[1:]
+	}
+	return TailLines(lines, n), nil
+}
+
+// MatchBackfill applies the Backfill rules to lines read from the server log.
+//
+// Returns:
+//
+//	the results of the matching lines, in order
+func (r *Rules) MatchBackfill(lines []string) []string {
+	var results []string
+	for _, line := range lines {
+		if match := r.Match(DirectionBackfill, nil, line); match != nil {
+			results = append(results, match.Result)
+		}
+	}
+	return results
+}
+
+// CollapseLines joins a header and lines into a single message of at most
+// maxLength characters. If the lines don't fit, the oldest ones are left out.
+//
+// Parameters:
+//
+//	header: returns the header for the number of lines included, e.g. "5
+//		messages:"; fewer lines must not make it longer
+func CollapseLines(header func(count int) string, lines []string, maxLength int) string {
+	length := utf8.RuneCountInString(header(len(lines)))
+	start := len(lines)
+	for start > 0 {
+		lineLength := utf8.RuneCountInString(lines[start-1]) + 1 // And its newline
+		if length+lineLength > maxLength {
+			break
+		}
+		length += lineLength
+		start--
+	}
+	kept := lines[start:]
+	return strings.Join(append([]string{header(len(kept))}, kept...), "\n")
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadLastLines(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		contents string
+		n        int
+		expected []string
+	}{
+		{"", 5, nil},
+		{"a\nb\nc\n", 2, []string{"b", "c"}},
+		{"a\r\nb\r\nc", 5, []string{"a", "b", "c"}},
+		{"a\nb\n", 0, nil},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("%v.log", i))
+		assert.NoError(t, os.WriteFile(path, []byte(test.contents), 0o644))
+		lines, err := ReadLastLines(path, test.n)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, lines, "Test #%v", i)
+	}
+
+	// Longer than a chunk.
+	var builder strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&builder, "line %v\n", i)
+	}
+	path := filepath.Join(dir, "long.log")
+	assert.NoError(t, os.WriteFile(path, []byte(builder.String()), 0o644))
+	lines, err := ReadLastLines(path, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 19997", "line 19998", "line 19999"}, lines)
+	lines, err = ReadLastLines(path, 15000)
+	assert.NoError(t, err)
+	assert.Len(t, lines, 15000)
+	assert.Equal(t, "line 5000", lines[0])
+
+	_, err = ReadLastLines(filepath.Join(dir, "missing.log"), 3)
+	assert.Error(t, err)
+}
+
+func TestMatchBackfill(t *testing.T) {
+	rules := Rules{Backfill: []Rule{{Template: ":arrow_right: ${1} joined"}}}
+	assert.NoError(t, rules.Backfill[0].Match.UnmarshalText([]byte(`(\w+) joined the game`)))
+	results := rules.MatchBackfill([]string{"Bob joined the game", "Saving...", "Alice joined the game"})
+	assert.Equal(t, []string{":arrow_right: Bob joined", ":arrow_right: Alice joined"}, results)
+}
+
+func TestCollapseLines(t *testing.T) {
+	header := func(count int) string {
+		return fmt.Sprintf("%v lines:", count)
+	}
+	tests := []struct {
+		lines     []string
+		maxLength int
+		expected  string
+	}{
+		{[]string{"a", "b"}, DiscordMessageLimit, "2 lines:\na\nb"},
+		// Only the newest lines that fit are kept, and counted.
+		{[]string{"aaa", "bbb", "ccc"}, 12, "1 lines:\nccc"},
+		{[]string{"aaa", "bbb", "ccc"}, 16, "2 lines:\nbbb\nccc"},
+		// Characters are counted, not bytes.
+		{[]string{"ééé", "ééé"}, 16, "2 lines:\nééé\nééé"},
+		{nil, DiscordMessageLimit, "0 lines:"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, CollapseLines(header, test.lines, test.maxLength), "Test #%v", i)
+	}
+}
@@ -0,0 +1,183 @@
+package lib
+
+// This file implements rules bundles: a rules file and, optionally, a user map
+// file in a single archive signed with an Ed25519 key, for distributing
+// validated configurations to game hosts.
+//
+// A bundle is a gzipped tar archive of the bundled files, a manifest with the
+// SHA-256 hash of each file, and the manifest's signature.
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Names of the files in a bundle.
+const (
+	bundleManifest  = "manifest.json"
+	bundleSignature = "manifest.sig"
+	BundleRules     = "rules.json"
+	BundleUsers     = "users.json"
+)
+
+// bundleFileLimit is the maximum size of a file in a bundle.
+const bundleFileLimit = 16 * 1024 * 1024
+
+// Bundle is the contents of a rules bundle.
+type Bundle struct {
+	Created time.Time
+	Files   map[string][]byte // Contents by name, e.g. BundleRules
+}
+
+// bundleManifestData is the signed manifest of a bundle.
+type bundleManifestData struct {
+	Created time.Time         `json:"created"`
+	Files   map[string]string `json:"files"` // Hex SHA-256 hash by name
+}
+
+// GenerateBundleKey generates a key pair for signing bundles.
+//
+// Returns:
+//
+//	the base64-encoded public and private keys
+func GenerateBundleKey() (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(public), base64.StdEncoding.EncodeToString(private), nil
+}
+
+// ParseBundlePublicKey parses a base64-encoded public key, see
+// GenerateBundleKey.
+func ParseBundlePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid bundle public key: expected %v base64-encoded bytes", ed25519.PublicKeySize)
+	}
+	return key, nil
+}
+
+// ParseBundlePrivateKey parses a base64-encoded private key, see
+// GenerateBundleKey.
+func ParseBundlePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid bundle private key: expected %v base64-encoded bytes", ed25519.PrivateKeySize)
+	}
+	return key, nil
+}
+
+// WriteBundle writes a bundle signed with a private key.
+func WriteBundle(w io.Writer, bundle Bundle, key ed25519.PrivateKey) error {
+	manifest := bundleManifestData{Created: bundle.Created.UTC(), Files: make(map[string]string)}
+	for name, contents := range bundle.Files {
+		sum := sha256.Sum256(contents)
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+	}
+	manifestJson, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestJson)))
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	write := func(name string, contents []byte) error {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents)), ModTime: manifest.Created}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := archive.Write(contents)
+		return err
+	}
+	if err := write(bundleManifest, manifestJson); err != nil {
+		return err
+	}
+	if err := write(bundleSignature, signature); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(bundle.Files)) {
+		if err := write(name, bundle.Files[name]); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadBundle reads a bundle, verifying its signature with a public key and the
+// hash of each file.
+func ReadBundle(r io.Reader, key ed25519.PublicKey) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	archive := tar.NewReader(gz)
+	entries := make(map[string][]byte)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if _, ok := entries[header.Name]; ok {
+			return nil, fmt.Errorf("invalid bundle: duplicate file %v", header.Name)
+		}
+		contents, err := io.ReadAll(io.LimitReader(archive, bundleFileLimit+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %v", err)
+		}
+		if len(contents) > bundleFileLimit {
+			return nil, fmt.Errorf("invalid bundle: %v is larger than %v bytes", header.Name, bundleFileLimit)
+		}
+		entries[header.Name] = contents
+	}
+
+	manifestJson, ok := entries[bundleManifest]
+	if !ok {
+		return nil, fmt.Errorf("invalid bundle: no %v", bundleManifest)
+	}
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(entries[bundleSignature])))
+	if err != nil || !ed25519.Verify(key, manifestJson, signature) {
+		return nil, fmt.Errorf("invalid bundle: the signature doesn't match the key")
+	}
+	var manifest bundleManifestData
+	if err := json.Unmarshal(manifestJson, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	bundle := &Bundle{Created: manifest.Created, Files: make(map[string][]byte)}
+	for name, hash := range manifest.Files {
+		contents, ok := entries[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid bundle: %v is missing", name)
+		}
+		sum := sha256.Sum256(contents)
+		if hex.EncodeToString(sum[:]) != hash {
+			return nil, fmt.Errorf("invalid bundle: %v was modified", name)
+		}
+		bundle.Files[name] = contents
+	}
+	// Files that aren't in the manifest aren't signed, so they are ignored.
+	return bundle, nil
+}
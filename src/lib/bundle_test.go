@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundle(t *testing.T) {
+	publicEncoded, privateEncoded, err := GenerateBundleKey()
+	assert.NoError(t, err)
+	public, err := ParseBundlePublicKey(publicEncoded)
+	assert.NoError(t, err)
+	private, err := ParseBundlePrivateKey(privateEncoded)
+	assert.NoError(t, err)
+
+	bundle := Bundle{
+		Created: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Files:   map[string][]byte{BundleRules: []byte(`{"version": 2}`), BundleUsers: []byte(`{"Steve": "123"}`)},
+	}
+	var archive bytes.Buffer
+	assert.NoError(t, WriteBundle(&archive, bundle, private))
+
+	read, err := ReadBundle(bytes.NewReader(archive.Bytes()), public)
+	assert.NoError(t, err)
+	assert.Equal(t, bundle, *read)
+
+	otherKey, _, _ := GenerateBundleKey()
+	other, _ := ParseBundlePublicKey(otherKey)
+	tests := []struct {
+		archive  []byte
+		key      ed25519.PublicKey
+		expected string // Part of the error
+	}{
+		{archive.Bytes(), other, "signature"},
+		{tamper(t, archive.Bytes(), BundleRules, `{"version": 3}`), public, "rules.json was modified"},
+		{[]byte("not a bundle"), public, ""},
+	}
+	for i, test := range tests {
+		_, err = ReadBundle(bytes.NewReader(test.archive), test.key)
+		assert.ErrorContains(t, err, test.expected, "Test #%v", i)
+	}
+
+	_, err = ParseBundlePublicKey(privateEncoded)
+	assert.Error(t, err)
+}
+
+// tamper replaces the contents of a file in a bundle, keeping its manifest and
+// signature.
+func tamper(t *testing.T, bundle []byte, name string, contents string) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	assert.NoError(t, err)
+	reader := tar.NewReader(gz)
+	var out bytes.Buffer
+	gzOut := gzip.NewWriter(&out)
+	writer := tar.NewWriter(gzOut)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(reader)
+		if header.Name == name {
+			data = []byte(contents)
+			header.Size = int64(len(data))
+		}
+		assert.NoError(t, writer.WriteHeader(header))
+		_, _ = writer.Write(data)
+	}
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, gzOut.Close())
+	return out.Bytes()
+}
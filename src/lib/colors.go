@@ -0,0 +1,110 @@
+package lib
+
+// This file implements the conversion of Discord colors, e.g. a user's role
+// color, to the formats games and chat networks take, for the formats of the
+// ^C template token.
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// PaletteColor is a color of a fixed palette, e.g. the chat colors of a game.
+type PaletteColor struct {
+	Code string // Code of the color in text, e.g. §6
+	Name string // Name of the color, e.g. gold
+	RGB  int
+}
+
+// MinecraftColors are the chat colors of Minecraft, see
+// https://minecraft.wiki/w/Formatting_codes.
+var MinecraftColors = []PaletteColor{
+	{"§0", "black", 0x000000},
+	{"§1", "dark_blue", 0x0000AA},
+	{"§2", "dark_green", 0x00AA00},
+	{"§3", "dark_aqua", 0x00AAAA},
+	{"§4", "dark_red", 0xAA0000},
+	{"§5", "dark_purple", 0xAA00AA},
+	{"§6", "gold", 0xFFAA00},
+	{"§7", "gray", 0xAAAAAA},
+	{"§8", "dark_gray", 0x555555},
+	{"§9", "blue", 0x5555FF},
+	{"§a", "green", 0x55FF55},
+	{"§b", "aqua", 0x55FFFF},
+	{"§c", "red", 0xFF5555},
+	{"§d", "light_purple", 0xFF55FF},
+	{"§e", "yellow", 0xFFFF55},
+	{"§f", "white", 0xFFFFFF},
+}
+
+// IrcColors are the 16 standard IRC colors, see
+// https://modern.ircdocs.horse/formatting#colors. The code is the color
+// control character followed by the color's number.
+var IrcColors = []PaletteColor{
+	{"\x0300", "white", 0xFFFFFF},
+	{"\x0301", "black", 0x000000},
+	{"\x0302", "blue", 0x00007F},
+	{"\x0303", "green", 0x009300},
+	{"\x0304", "red", 0xFF0000},
+	{"\x0305", "brown", 0x7F0000},
+	{"\x0306", "magenta", 0x9C009C},
+	{"\x0307", "orange", 0xFC7F00},
+	{"\x0308", "yellow", 0xFFFF00},
+	{"\x0309", "light green", 0x00FC00},
+	{"\x0310", "cyan", 0x009393},
+	{"\x0311", "light cyan", 0x00FFFF},
+	{"\x0312", "light blue", 0x0000FC},
+	{"\x0313", "pink", 0xFF00FF},
+	{"\x0314", "grey", 0x7F7F7F},
+	{"\x0315", "light grey", 0xD2D2D2},
+}
+
+// ColorHex formats a color as #rrggbb.
+func ColorHex(color int) string {
+	return fmt.Sprintf("#%06x", color&0xFFFFFF)
+}
+
+// NearestColor returns the color of a palette closest to a color, by the
+// "redmean" distance of their red, green and blue components, which weighs
+// them like the eye does.
+func NearestColor(color int, palette []PaletteColor) PaletteColor {
+	nearest, distance := palette[0], -1
+	for _, candidate := range palette {
+		if d := colorDistance(color, candidate.RGB); distance < 0 || d < distance {
+			nearest, distance = candidate, d
+		}
+	}
+	return nearest
+}
+
+func colorDistance(a int, b int) int {
+	r1, g1, b1 := (a>>16)&0xFF, (a>>8)&0xFF, a&0xFF
+	r2, g2, b2 := (b>>16)&0xFF, (b>>8)&0xFF, b&0xFF
+	redMean := (r1 + r2) / 2
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return ((512+redMean)*dr*dr)>>8 + 4*dg*dg + ((767-redMean)*db*db)>>8
+}
+
+// colorFormats are the formats of the ^C token, written ^C{format}. Discord
+// uses 0 for no color, which the palette formats turn into no code, so that
+// the game's default color applies.
+var colorFormats = map[string]func(color int) string{
+	"hex": ColorHex,
+	"dec": strconv.Itoa,
+	"mc": func(color int) string {
+		return paletteFormat(color, MinecraftColors, func(c PaletteColor) string { return c.Code })
+	},
+	"mcname": func(color int) string {
+		return paletteFormat(color, MinecraftColors, func(c PaletteColor) string { return c.Name })
+	},
+	"irc": func(color int) string {
+		return paletteFormat(color, IrcColors, func(c PaletteColor) string { return c.Code })
+	},
+}
+
+func paletteFormat(color int, palette []PaletteColor, format func(PaletteColor) string) string {
+	if color == 0 {
+		return ""
+	}
+	return format(NearestColor(color, palette))
+}
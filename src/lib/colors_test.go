@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColorHex(t *testing.T) {
+	assert.Equal(t, "#ff8800", ColorHex(0xFF8800))
+	assert.Equal(t, "#0000ff", ColorHex(0xFF))
+}
+
+func TestNearestColor(t *testing.T) {
+	tests := []struct {
+		color     int
+		minecraft string
+		irc       string
+	}{
+		{0xFFAA00, "gold", "orange"},
+		{0xE67E22, "gold", "orange"},    // Discord orange
+		{0xF1C40F, "gold", "yellow"},    // Discord gold
+		{0x1ABC9C, "dark_aqua", "cyan"}, // Discord teal
+		{0xEE1111, "dark_red", "red"},
+		{0xFFFFFF, "white", "white"},
+		{0x010101, "black", "black"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.minecraft, NearestColor(test.color, MinecraftColors).Name, "Test #%v", i)
+		assert.Equal(t, test.irc, NearestColor(test.color, IrcColors).Name, "Test #%v", i)
+	}
+}
+
+func TestColorFormats(t *testing.T) {
+	props := &Props{Author: Author{Username: "Bob", AccentColor: 0xFFAA00}}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"^C", "ffaa00"},
+		{"^C{hex}", "#ffaa00"},
+		{"^C{dec}", "16755200"},
+		{"^C{mc}^U", "§6Bob"},
+		{"^C{mcname}", "gold"},
+		{"^C{irc}^U\x0f", "\x0307Bob\x0f"},
+		{"^C{rgb}", "ffaa00{rgb}"},
+		{"^U{hex}", "Bob{hex}"},
+		{"^C{hex", "ffaa00{hex"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, buildTemplate(test.template, *props), "Test #%v", i)
+	}
+
+	noColor := Props{Author: Author{Username: "Bob"}}
+	assert.Equal(t, "Bob", buildTemplate("^C{mc}^U", noColor))
+	assert.Equal(t, "#000000", buildTemplate("^C{hex}", noColor))
+}
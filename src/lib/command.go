@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// commandVarRegex matches a reference to a var in a command argument, $NAME or
+// ${NAME}.
+var commandVarRegex = regexp.MustCompile(`\$(\w+)|\$\{(\w+)\}`)
+
+// ParseCommand returns the arguments of the subprocess command.
+// A single argument is split at whitespace, so that the command can be given as
+// one string; more arguments are used as they are.
+//
+// Occurrences of $NAME or ${NAME} in the arguments are replaced with the value
+// of vars[NAME]. Other occurrences, e.g. of environment variables, are kept as
+// they are, since there is no shell to expand them, see --shell.
+func ParseCommand(argv []string, vars map[string]string) []string {
+	if len(argv) == 1 {
+		argv = strings.Fields(argv[0])
+	}
+	expanded := make([]string, len(argv))
+	for i, argument := range argv {
+		expanded[i] = commandVarRegex.ReplaceAllStringFunc(argument, func(reference string) string {
+			name := strings.Trim(reference, "${}")
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			return reference
+		})
+	}
+	return expanded
+}
+
+// CommandEnv returns the environment of the subprocess: dgbridge's environment,
+// plus vars.
+func CommandEnv(vars map[string]string) []string {
+	env := os.Environ()
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// CleanEnvNames are the environment variables passed on to the subprocess by
+// CleanCommandEnv, which programs commonly need. SystemRoot, ComSpec and
+// PATHEXT are those of Windows, where PATH is also named Path.
+var CleanEnvNames = []string{"PATH", "HOME", "USER", "LANG", "LC_ALL", "TZ", "TMPDIR", "TERM", "SystemRoot", "ComSpec", "PATHEXT"}
+
+// CleanCommandEnv returns a locked-down environment of the subprocess: the
+// variables of CleanEnvNames and allow from dgbridge's environment, plus vars.
+// Other variables, e.g. DISCORD_TOKEN and other secrets, aren't passed on.
+// Names are compared ignoring case on Windows, like Windows does.
+//
+// Parameters:
+//
+//	environ: dgbridge's environment, see os.Environ
+//	allow: names of further variables to pass on
+func CleanCommandEnv(environ []string, allow []string, vars map[string]string) []string {
+	return cleanCommandEnv(environ, allow, vars, runtime.GOOS == "windows")
+}
+
+// cleanCommandEnv is CleanCommandEnv, comparing names ignoring case if
+// foldCase is set.
+func cleanCommandEnv(environ []string, allow []string, vars map[string]string, foldCase bool) []string {
+	allowed := func(names []string, name string) bool {
+		if foldCase {
+			return slices.ContainsFunc(names, func(allowed string) bool { return strings.EqualFold(allowed, name) })
+		}
+		return slices.Contains(names, name)
+	}
+	var env []string
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if allowed(CleanEnvNames, name) || allowed(allow, name) {
+			env = append(env, entry)
+		}
+	}
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	return env
+}
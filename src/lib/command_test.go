@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommand(t *testing.T) {
+	t.Setenv("DGBRIDGE_TEST_PORT", "25565")
+	vars := map[string]string{"MEMORY": "4G", "PORT": "25566"}
+
+	tests := []struct {
+		argv     []string
+		expected []string
+	}{
+		{[]string{"java  -jar server.jar"}, []string{"java", "-jar", "server.jar"}},
+		{[]string{"java -Xmx${MEMORY} -jar server.jar --port $PORT"},
+			[]string{"java", "-Xmx4G", "-jar", "server.jar", "--port", "25566"}},
+		{[]string{"./start server.sh", "--world", "My World"}, []string{"./start server.sh", "--world", "My World"}},
+		// Only vars are expanded, not the environment.
+		{[]string{"echo", "$DGBRIDGE_TEST_PORT", "${HOME}", "$"}, []string{"echo", "$DGBRIDGE_TEST_PORT", "${HOME}", "$"}},
+		{[]string{"echo", "price: $5"}, []string{"echo", "price: $5"}},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, ParseCommand(test.argv, vars), "Test #%v", i)
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	env := CommandEnv(map[string]string{"MEMORY": "4G"})
+	assert.Contains(t, env, "MEMORY=4G")
+}
+
+func TestCleanCommandEnv(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "DISCORD_TOKEN=secret", "HOME=/srv", "JAVA_HOME=/opt/java", "EMPTY="}
+	env := CleanCommandEnv(environ, []string{"JAVA_HOME"}, map[string]string{"MEMORY": "4G"})
+	assert.Equal(t, []string{"PATH=/usr/bin", "HOME=/srv", "JAVA_HOME=/opt/java", "MEMORY=4G"}, env)
+	assert.Equal(t, []string{"PATH=/usr/bin", "HOME=/srv"}, CleanCommandEnv(environ, nil, nil))
+}
+
+func TestCleanCommandEnvFoldCase(t *testing.T) {
+	environ := []string{"Path=C:\\Windows", "SYSTEMROOT=C:\\Windows", "java_home=C:\\Java", "DISCORD_TOKEN=secret"}
+	tests := []struct {
+		foldCase bool
+		expected []string
+	}{
+		{false, nil},
+		{true, []string{"Path=C:\\Windows", "SYSTEMROOT=C:\\Windows", "java_home=C:\\Java"}},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, cleanCommandEnv(environ, []string{"JAVA_HOME"}, nil, test.foldCase), "Test #%v", i)
+	}
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Template sections that are only rendered depending on whether a capture group
+// matched, so that optional parts of a line don't leave empty parentheses or
+// double spaces:
+//   - ${?3: (via ${3})} renders " (via ${3})" if group 3 is non-empty
+//   - ${!3: (from the server)} renders " (from the server)" if group 3 is empty
+//
+// Groups are numbered or named. The section ends at the matching "}", so
+// sections may contain group references, arithmetic and other sections.
+const (
+	conditionalPresent = "${?"
+	conditionalAbsent  = "${!"
+)
+
+// hasConditionals reports whether a template has conditional sections.
+func hasConditionals(template string) bool {
+	return strings.Contains(template, conditionalPresent) || strings.Contains(template, conditionalAbsent)
+}
+
+// evaluateConditionals renders the conditional sections of a template for a
+// single match, see conditionalPresent.
+func evaluateConditionals(re *regexp.Regexp, template string, input string, match []int) string {
+	if !hasConditionals(template) {
+		return template
+	}
+	var result strings.Builder
+	for i := 0; i < len(template); i++ {
+		prefix := template[i:min(i+3, len(template))]
+		if prefix != conditionalPresent && prefix != conditionalAbsent {
+			result.WriteByte(template[i])
+			continue
+		}
+		colon := strings.IndexByte(template[i+3:], ':')
+		end := closingBrace(template, i+1)
+		if colon < 0 || end < 0 || i+3+colon > end {
+			// Malformed, leave it to the regex engine.
+			result.WriteByte(template[i])
+			continue
+		}
+		name := template[i+3 : i+3+colon]
+		body := template[i+3+colon+1 : end]
+		if groupPresent(re, name, input, match) == (prefix == conditionalPresent) {
+			result.WriteString(evaluateConditionals(re, body, input, match))
+		}
+		i = end
+	}
+	return result.String()
+}
+
+// closingBrace returns the index of the "}" closing the "{" at start, or -1.
+func closingBrace(template string, start int) int {
+	depth := 0
+	for i := start; i < len(template); i++ {
+		switch template[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// groupPresent reports whether a numbered or named capture group of a match is
+// non-empty.
+func groupPresent(re *regexp.Regexp, name string, input string, match []int) bool {
+	group, err := strconv.Atoi(name)
+	if err != nil {
+		group = re.SubexpIndex(name)
+	}
+	if group < 0 || 2*group+1 >= len(match) || match[2*group] < 0 {
+		return false
+	}
+	return strings.TrimSpace(input[match[2*group]:match[2*group+1]]) != ""
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyRuleConditionals(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Match    string
+		Template string
+		Input    string
+		Expect   string
+	}{
+		{
+			Name:     "Present group",
+			Match:    `^<(\w+)> (.*?)(?: \[(console)\])?$`,
+			Template: "**${1}**: ${2}${?3: (via ${3})}",
+			Input:    "<Bob> hi [console]",
+			Expect:   "**Bob**: hi (via console)",
+		},
+		{
+			Name:     "Missing group",
+			Match:    `^<(\w+)> (.*?)(?: \[(console)\])?$`,
+			Template: "**${1}**: ${2}${?3: (via ${3})}",
+			Input:    "<Bob> hi",
+			Expect:   "**Bob**: hi",
+		},
+		{
+			Name:     "Absent section",
+			Match:    `^(\w+) left(?: \((?P<reason>.*)\))?$`,
+			Template: "${1} left${?reason:: ${reason}}${!reason:.}",
+			Input:    "Bob left",
+			Expect:   "Bob left.",
+		},
+		{
+			Name:     "Named group and arithmetic",
+			Match:    `^(\w+) left(?: \((?P<reason>.*)\))?(?: after (\d+)s)?$`,
+			Template: "${1} left${?reason:: ${reason}}${?3: after ${3/60|%.0f} min}",
+			Input:    "Bob left (timed out) after 120s",
+			Expect:   "Bob left: timed out after 2 min",
+		},
+		{
+			Name:     "Nested",
+			Match:    `^(\w+)(?: (\w+))?(?: (\w+))?$`,
+			Template: "${1}${?2: [${2}${?3:, ${3}}]}",
+			Input:    "a b",
+			Expect:   "a [b]",
+		},
+		{
+			Name:     "Malformed section is left unchanged",
+			Match:    `^(\w+)$`,
+			Template: "${1} ${?1 no colon",
+			Input:    "a",
+			Expect:   "a ${?1 no colon",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			rule := Rule{Template: test.Template}
+			assert.NoError(t, rule.Match.UnmarshalText([]byte(test.Match)))
+			assert.Equal(t, test.Expect, ApplyRule(rule, nil, test.Input))
+		})
+	}
+}
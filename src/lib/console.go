@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DiscordMessageLimit is the maximum number of characters in a Discord message.
+const DiscordMessageLimit = 2000
+
+// TailLines returns the last n lines, or all lines if there are fewer.
+func TailLines(lines []string, n int) []string {
+	if n < 0 {
+		n = 0
+	}
+	if n > len(lines) {
+		n = len(lines)
+	}
+	return lines[len(lines)-n:]
+}
+
+// NewLines returns the lines of a log that were added since an earlier read of
+// its tail, e.g. when polling an API that only returns the last lines of a
+// log. The new tail is assumed to continue the longest suffix of the previous
+// tail that it starts with; if there is none, all lines are new.
+func NewLines(previous []string, current []string) []string {
+	for start := max(0, len(previous)-len(current)); start < len(previous); start++ {
+		overlap := len(previous) - start
+		if slices.Equal(previous[start:], current[:overlap]) {
+			return current[overlap:]
+		}
+	}
+	return current
+}
+
+// GrepLines returns the lines matching re, in order.
+func GrepLines(lines []string, re *regexp.Regexp) []string {
+	var matching []string
+	for _, line := range lines {
+		if re.MatchString(ansiRegex.ReplaceAllString(line, "")) {
+			matching = append(matching, line)
+		}
+	}
+	return matching
+}
+
+// FormatCodeBlock formats console lines as a Discord code block of at most
+// maxLength characters. ANSI color codes are removed. If the lines don't fit,
+// the oldest ones are left out.
+func FormatCodeBlock(lines []string, maxLength int) string {
+	const prefix, suffix = "```\n", "```"
+	length := len(prefix) + len(suffix)
+	start := len(lines)
+	cleaned := make([]string, len(lines))
+	for start > 0 {
+		line := ansiRegex.ReplaceAllString(lines[start-1], "")
+		// Keep lines from closing the code block early.
+		line = strings.ReplaceAll(line, "```", "`\u200b``")
+		if length+len(line)+1 > maxLength {
+			break
+		}
+		length += len(line) + 1
+		start--
+		cleaned[start] = line
+	}
+	var builder strings.Builder
+	builder.WriteString(prefix)
+	for _, line := range cleaned[start:] {
+		builder.WriteString(line)
+		builder.WriteString("\n")
+	}
+	builder.WriteString(suffix)
+	return builder.String()
+}
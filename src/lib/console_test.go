@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailLines(t *testing.T) {
+	lines := []string{"a", "b", "c"}
+	assert.Equal(t, []string{"b", "c"}, TailLines(lines, 2))
+	assert.Equal(t, lines, TailLines(lines, 10))
+	assert.Empty(t, TailLines(lines, 0))
+}
+
+func TestNewLines(t *testing.T) {
+	tests := []struct {
+		previous []string
+		current  []string
+		expected []string
+	}{
+		{nil, []string{"a", "b"}, []string{"a", "b"}},
+		{[]string{"a", "b"}, []string{"a", "b", "c"}, []string{"c"}},
+		// The tail moved on: the oldest lines dropped out.
+		{[]string{"a", "b", "c"}, []string{"c", "d", "e"}, []string{"d", "e"}},
+		{[]string{"a", "b"}, []string{"a", "b"}, []string{}},
+		// Repeated lines overlap as much as possible.
+		{[]string{"x", "x"}, []string{"x", "x", "x"}, []string{"x"}},
+		// The log was rotated.
+		{[]string{"a", "b"}, []string{"c"}, []string{"c"}},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, NewLines(test.previous, test.current), "Test #%v", i)
+	}
+}
+
+func TestGrepLines(t *testing.T) {
+	lines := []string{"\x1b[32m[INFO] Bob joined\x1b[0m", "[WARN] Lag", "[INFO] Alice joined"}
+	assert.Equal(t, []string{lines[0], lines[2]}, GrepLines(lines, regexp.MustCompile(`^\[INFO] \w+ joined`)))
+	assert.Nil(t, GrepLines(lines, regexp.MustCompile(`crash`)))
+}
+
+func TestFormatCodeBlock(t *testing.T) {
+	assert.Equal(t, "```\n```", FormatCodeBlock(nil, DiscordMessageLimit))
+	assert.Equal(t, "```\nred\nplain\n```", FormatCodeBlock([]string{"\x1b[31mred\x1b[0m", "plain"}, DiscordMessageLimit))
+	// Only the newest lines that fit are kept.
+	assert.Equal(t, "```\nccc\n```", FormatCodeBlock([]string{"aaa", "bbb", "ccc"}, 12))
+	assert.Equal(t, "```\n`\u200b``\n```", FormatCodeBlock([]string{"```"}, DiscordMessageLimit))
+}
@@ -0,0 +1,81 @@
+package lib
+
+// This file implements parsing the log files that container runtimes write
+// the output of containers to, e.g. in /var/log/pods on a Kubernetes node, so
+// that a sidecar can relay the output of the server's container.
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ContainerLogLine is a line of a container's output, read from its log file.
+type ContainerLogLine struct {
+	Text   string
+	Stderr bool
+	Time   time.Time // When the runtime received the line, zero if unknown
+}
+
+// dockerLogEntry is a line of Docker's json-file log format.
+type dockerLogEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// ContainerLogParser parses the lines of a container's log file:
+//
+//   - the CRI format of containerd and CRI-O, e.g.
+//     2024-05-01T12:00:00.123456789Z stdout F <Steve> hi
+//   - Docker's json-file format, e.g.
+//     {"log":"<Steve> hi\n","stream":"stdout","time":"2024-05-01T12:00:00Z"}
+//
+// Runtimes split long lines into partial lines, which are joined again. Lines
+// in neither format are taken as they are, e.g. of a log file the server's
+// container writes to a shared volume.
+type ContainerLogParser struct {
+	partial [2]strings.Builder // Partial lines of stdout and stderr
+}
+
+// Parse parses a line of the log file, without its newline.
+//
+// Returns:
+//
+//	the line of the container's output, and false if the line is a partial
+//	line whose rest follows
+func (p *ContainerLogParser) Parse(line string) (ContainerLogLine, bool) {
+	if strings.HasPrefix(line, "{") {
+		var entry dockerLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Stream != "" {
+			text, complete := strings.CutSuffix(entry.Log, "\n")
+			return p.join(ContainerLogLine{Text: text, Stderr: entry.Stream == "stderr", Time: entry.Time}, complete)
+		}
+	}
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) >= 3 && (fields[1] == "stdout" || fields[1] == "stderr") && (fields[2] == "F" || fields[2] == "P") {
+		if parsed, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+			text := ""
+			if len(fields) == 4 {
+				text = fields[3]
+			}
+			return p.join(ContainerLogLine{Text: text, Stderr: fields[1] == "stderr", Time: parsed}, fields[2] == "F")
+		}
+	}
+	return ContainerLogLine{Text: line}, true
+}
+
+// join joins a partial line with the partial lines of its stream before it.
+func (p *ContainerLogParser) join(line ContainerLogLine, complete bool) (ContainerLogLine, bool) {
+	partial := &p.partial[0]
+	if line.Stderr {
+		partial = &p.partial[1]
+	}
+	partial.WriteString(line.Text)
+	if !complete {
+		return ContainerLogLine{}, false
+	}
+	line.Text = partial.String()
+	partial.Reset()
+	return line, true
+}
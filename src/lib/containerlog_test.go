@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerLogParser(t *testing.T) {
+	received := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	tests := []struct {
+		lines    []string
+		expected ContainerLogLine
+	}{
+		{[]string{"2024-05-01T12:00:00.123456789Z stdout F <Steve> hi"}, ContainerLogLine{Text: "<Steve> hi", Time: received}},
+		{[]string{"2024-05-01T12:00:00.123456789Z stderr F Exception"}, ContainerLogLine{Text: "Exception", Stderr: true, Time: received}},
+		{[]string{"2024-05-01T12:00:00.123456789Z stdout F "}, ContainerLogLine{Time: received}},
+		{
+			[]string{"2024-05-01T12:00:00.123456789Z stdout P <Steve> a very ", "2024-05-01T12:00:00.123456789Z stdout F long line"},
+			ContainerLogLine{Text: "<Steve> a very long line", Time: received},
+		},
+		{
+			[]string{`{"log":"<Steve> hi\n","stream":"stdout","time":"2024-05-01T12:00:00.123456789Z"}`},
+			ContainerLogLine{Text: "<Steve> hi", Time: received},
+		},
+		{
+			[]string{`{"log":"Excep","stream":"stderr","time":"2024-05-01T12:00:00Z"}`, `{"log":"tion\n","stream":"stderr","time":"2024-05-01T12:00:00.123456789Z"}`},
+			ContainerLogLine{Text: "Exception", Stderr: true, Time: received},
+		},
+		{[]string{"[12:00:00] [Server thread/INFO]: <Steve> hi"}, ContainerLogLine{Text: "[12:00:00] [Server thread/INFO]: <Steve> hi"}},
+		{[]string{`{"text":"not a docker line"}`}, ContainerLogLine{Text: `{"text":"not a docker line"}`}},
+		{[]string{"yesterday stdout F hi"}, ContainerLogLine{Text: "yesterday stdout F hi"}},
+	}
+	for i, test := range tests {
+		var parser ContainerLogParser
+		for j, line := range test.lines {
+			parsed, complete := parser.Parse(line)
+			if j < len(test.lines)-1 {
+				assert.False(t, complete, "Test #%v", i)
+				continue
+			}
+			assert.True(t, complete, "Test #%v", i)
+			assert.Equal(t, test.expected, parsed, "Test #%v", i)
+		}
+	}
+}
+
+func TestContainerLogParserInterleaved(t *testing.T) {
+	var parser ContainerLogParser
+	_, complete := parser.Parse("2024-05-01T12:00:00Z stdout P <Steve> ")
+	assert.False(t, complete)
+	line, complete := parser.Parse("2024-05-01T12:00:00Z stderr F Exception")
+	assert.True(t, complete)
+	assert.Equal(t, "Exception", line.Text)
+	line, complete = parser.Parse("2024-05-01T12:00:00Z stdout F hi")
+	assert.True(t, complete)
+	assert.Equal(t, "<Steve> hi", line.Text)
+}
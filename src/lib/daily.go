@@ -0,0 +1,222 @@
+package lib
+
+// This file implements the daily statistics of the bridge, which are posted as
+// a daily summary and persisted across restarts.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DailyStats are the statistics of the bridge since the last daily summary.
+type DailyStats struct {
+	Since        time.Time // When counting started
+	ToDiscord    int       // Messages relayed from the subprocess to Discord
+	ToSubprocess int       // Messages relayed from Discord to the subprocess
+	Chatters     []string  // Discord user IDs and in-game names that sent messages, sorted
+	PeakPlayers  int       // Highest number of online players reported, see Rule.Players
+}
+
+// DailyStatsRecorder accumulates DailyStats, and saves them to a file so that
+// they survive restarts. It is safe for concurrent use.
+type DailyStatsRecorder struct {
+	mutex    sync.Mutex
+	path     string // Empty to keep the stats in memory only
+	stats    DailyStats
+	chatters map[string]struct{}
+	changed  bool // Whether the stats changed since they were saved
+}
+
+// LoadDailyStats creates a DailyStatsRecorder, continuing with the stats saved
+// in a file if it exists.
+//
+// Parameters:
+//
+//	path: file the stats are saved to, or empty to keep them in memory only
+//	now: start of the stats if there are no saved stats
+func LoadDailyStats(path string, now time.Time) (*DailyStatsRecorder, error) {
+	r := &DailyStatsRecorder{
+		path:     path,
+		stats:    DailyStats{Since: now},
+		chatters: make(map[string]struct{}),
+	}
+	if path == "" {
+		return r, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &r.stats); err != nil {
+		return nil, fmt.Errorf("error decoding %v: %v", path, err)
+	}
+	for _, chatter := range r.stats.Chatters {
+		r.chatters[chatter] = struct{}{}
+	}
+	return r, nil
+}
+
+// RecordMessage counts a relayed message.
+//
+// Parameters:
+//
+//	direction: DirectionSubprocessToDiscord or DirectionDiscordToSubprocess;
+//		other directions are ignored
+//	chatter: Discord user ID or in-game name of the sender, may be empty
+func (r *DailyStatsRecorder) RecordMessage(direction string, chatter string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch direction {
+	case DirectionSubprocessToDiscord:
+		r.stats.ToDiscord++
+	case DirectionDiscordToSubprocess:
+		r.stats.ToSubprocess++
+	default:
+		return
+	}
+	if _, ok := r.chatters[chatter]; chatter != "" && !ok {
+		r.chatters[chatter] = struct{}{}
+	}
+	r.changed = true
+}
+
+// RecordPlayers records the number of online players.
+func (r *DailyStatsRecorder) RecordPlayers(count int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if count > r.stats.PeakPlayers {
+		r.stats.PeakPlayers = count
+		r.changed = true
+	}
+}
+
+// Since returns when counting the current stats started.
+func (r *DailyStatsRecorder) Since() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats.Since
+}
+
+// Take returns the current stats and starts counting anew, e.g. once the
+// daily summary is posted. The new stats are saved.
+func (r *DailyStatsRecorder) Take(now time.Time) (DailyStats, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stats := r.snapshot()
+	r.stats = DailyStats{Since: now}
+	r.chatters = make(map[string]struct{})
+	r.changed = true
+	return stats, r.save()
+}
+
+// Save saves the stats to the file, if they changed since they were last
+// saved.
+func (r *DailyStatsRecorder) Save() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.save()
+}
+
+// save saves the stats. The caller must hold the mutex.
+func (r *DailyStatsRecorder) save() error {
+	if r.path == "" || !r.changed {
+		return nil
+	}
+	content, err := json.Marshal(r.snapshot())
+	if err != nil {
+		return err
+	}
+	// Write a temporary file first, so that a crash doesn't leave a truncated
+	// file behind.
+	if err := os.WriteFile(r.path+".tmp", content, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path+".tmp", r.path); err != nil {
+		return err
+	}
+	r.changed = false
+	return nil
+}
+
+// snapshot returns a copy of the stats. The caller must hold the mutex.
+func (r *DailyStatsRecorder) snapshot() DailyStats {
+	stats := r.stats
+	stats.Chatters = make([]string, 0, len(r.chatters))
+	for chatter := range r.chatters {
+		stats.Chatters = append(stats.Chatters, chatter)
+	}
+	sort.Strings(stats.Chatters)
+	return stats
+}
+
+// FormatDailySummary expands a daily summary template with the stats.
+// The parameters are:
+//   - ^D turns into the date counting started, e.g. 2024-05-01
+//   - ^G turns into the number of messages relayed from the game to Discord
+//   - ^S turns into the number of messages relayed from Discord to the server
+//   - ^U turns into the number of unique chatters
+//   - ^P turns into the peak number of online players
+//   - ^^ turns into ^
+func FormatDailySummary(template string, stats DailyStats) string {
+	return expandParameters(template, map[byte]string{
+		'D': stats.Since.Format(time.DateOnly),
+		'G': strconv.Itoa(stats.ToDiscord),
+		'S': strconv.Itoa(stats.ToSubprocess),
+		'U': strconv.Itoa(len(stats.Chatters)),
+		'P': strconv.Itoa(stats.PeakPlayers),
+		'^': "^",
+	})
+}
+
+// expandParameters replaces the ^ parameters of a template with their values.
+// Unknown parameters are left as they are.
+//
+// Parameters:
+//
+//	values: values of the parameters, by the character that follows the ^
+func expandParameters(template string, values map[byte]string) string {
+	var result strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] == '^' && i+1 < len(template) {
+			if value, ok := values[template[i+1]]; ok {
+				result.WriteString(value)
+				i++
+				continue
+			}
+		}
+		result.WriteByte(template[i])
+	}
+	return result.String()
+}
+
+// ParseClock parses a time of day, e.g. "23:30".
+//
+// Returns:
+//
+//	the hour and the minute
+func ParseClock(value string) (int, int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return clock.Hour(), clock.Minute(), nil
+}
+
+// NextClock returns the next time after now that the clock shows a time of
+// day, in now's location.
+func NextClock(now time.Time, hour int, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDailyStatsRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	recorder, err := LoadDailyStats(path, start)
+	assert.NoError(t, err)
+
+	recorder.RecordMessage(DirectionSubprocessToDiscord, "Bob")
+	recorder.RecordMessage(DirectionSubprocessToDiscord, "Bob")
+	recorder.RecordMessage(DirectionSubprocessToDiscord, "")
+	recorder.RecordMessage(DirectionDiscordToSubprocess, "123456789012345678")
+	recorder.RecordMessage(DirectionSubprocessToPeer, "Alice")
+	recorder.RecordPlayers(3)
+	recorder.RecordPlayers(7)
+	recorder.RecordPlayers(5)
+	assert.NoError(t, recorder.Save())
+
+	// The stats survive a restart.
+	recorder, err = LoadDailyStats(path, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, start, recorder.Since().UTC())
+	recorder.RecordMessage(DirectionSubprocessToDiscord, "Carol")
+
+	next := start.Add(24 * time.Hour)
+	stats, err := recorder.Take(next)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, stats.ToDiscord)
+	assert.Equal(t, 1, stats.ToSubprocess)
+	assert.Equal(t, []string{"123456789012345678", "Bob", "Carol"}, stats.Chatters)
+	assert.Equal(t, 7, stats.PeakPlayers)
+
+	// Take starts counting anew, and saves the new stats.
+	recorder, err = LoadDailyStats(path, start)
+	assert.NoError(t, err)
+	assert.Equal(t, next, recorder.Since().UTC())
+	stats, _ = recorder.Take(next)
+	assert.Equal(t, 0, stats.ToDiscord)
+	assert.Empty(t, stats.Chatters)
+}
+
+func TestFormatDailySummary(t *testing.T) {
+	stats := DailyStats{
+		Since:        time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		ToDiscord:    120,
+		ToSubprocess: 45,
+		Chatters:     []string{"Alice", "Bob"},
+		PeakPlayers:  8,
+	}
+	assert.Equal(t,
+		"2024-05-01: 120 to Discord, 45 to the server, 2 chatters, 8 players ^X",
+		FormatDailySummary("^D: ^G to Discord, ^S to the server, ^U chatters, ^P players ^^X", stats))
+	assert.Equal(t, "^", FormatDailySummary("^", stats))
+}
+
+func TestNextClock(t *testing.T) {
+	tests := []struct {
+		now      time.Time
+		expected time.Time
+	}{
+		{time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 21, 30, 0, 0, time.UTC)},
+		{time.Date(2024, 5, 1, 21, 30, 0, 0, time.UTC), time.Date(2024, 5, 2, 21, 30, 0, 0, time.UTC)},
+		{time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 21, 30, 0, 0, time.UTC)},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, NextClock(test.now, 21, 30), "Test #%v", i)
+	}
+	_, _, err := ParseClock("25:00")
+	assert.Error(t, err)
+	hour, minute, err := ParseClock("07:05")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{7, 5}, []int{hour, minute})
+}
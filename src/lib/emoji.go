@@ -0,0 +1,32 @@
+package lib
+
+import "regexp"
+
+// emojiRegex matches emoji shortcodes such as :wave:, and custom emoji that are
+// already in Discord's message format (<:name:id> or <a:name:id>) so that they
+// are left alone.
+var emojiRegex = regexp.MustCompile(`<a?:\w+:\d+>|:(\w+):`)
+
+// ResolveEmojis replaces emoji shortcodes (e.g. :pepe:) in a message with the
+// Discord message format of the custom emoji with that name.
+//
+// Parameters:
+//
+//	emojis: maps emoji names to their message format, e.g. "pepe" to "<:pepe:123>"
+//
+// Shortcodes of unknown emoji are left unchanged, so that Discord can still
+// render standard emoji such as :wave:.
+func ResolveEmojis(message string, emojis map[string]string) string {
+	if len(emojis) == 0 {
+		return message
+	}
+	return emojiRegex.ReplaceAllStringFunc(message, func(match string) string {
+		if match[0] == '<' {
+			return match
+		}
+		if formatted, ok := emojis[match[1:len(match)-1]]; ok {
+			return formatted
+		}
+		return match
+	})
+}
@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveEmojis(t *testing.T) {
+	emojis := map[string]string{
+		"pepe":  "<:pepe:123456789012345678>",
+		"party": "<a:party:234567890123456789>",
+	}
+	tests := []struct {
+		Name   string
+		Input  string
+		Expect string
+	}{
+		{"Custom emoji", "gg :pepe:", "gg <:pepe:123456789012345678>"},
+		{"Animated emoji", ":party::pepe:", "<a:party:234567890123456789><:pepe:123456789012345678>"},
+		{"Standard emoji", "hi :wave:", "hi :wave:"},
+		{"Already formatted", "<:pepe:123456789012345678>", "<:pepe:123456789012345678>"},
+		{"Time is not an emoji", "at 12:30:00", "at 12:30:00"},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expect, ResolveEmojis(test.Input, emojis))
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package lib
+
+// This file implements editing environment files, e.g. the one written by
+// "dgbridge init".
+
+import (
+	"strings"
+)
+
+// SetEnvFileVar sets a variable in the contents of an environment file, e.g.
+// one loaded by a systemd unit, replacing its line or appending one. Other
+// lines are kept as they are.
+func SetEnvFileVar(contents string, name string, value string) string {
+	line := name + "=" + value
+	lines := strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	if contents == "" {
+		lines = nil
+	}
+	for i, existing := range lines {
+		trimmed := strings.TrimPrefix(strings.TrimSpace(existing), "export ")
+		if strings.HasPrefix(trimmed, name+"=") {
+			lines[i] = line
+			return strings.Join(lines, "\n") + "\n"
+		}
+	}
+	return strings.Join(append(lines, line), "\n") + "\n"
+}
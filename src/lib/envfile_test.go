@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetEnvFileVar(t *testing.T) {
+	tests := []struct {
+		contents string
+		expected string
+	}{
+		{"", "DGBRIDGE_CHANNEL_ID=123\n"},
+		{"DISCORD_TOKEN=abc\n", "DISCORD_TOKEN=abc\nDGBRIDGE_CHANNEL_ID=123\n"},
+		// Without a final line break
+		{"DISCORD_TOKEN=abc", "DISCORD_TOKEN=abc\nDGBRIDGE_CHANNEL_ID=123\n"},
+		{"# bridge\nDGBRIDGE_CHANNEL_ID=1\nDISCORD_TOKEN=abc\n", "# bridge\nDGBRIDGE_CHANNEL_ID=123\nDISCORD_TOKEN=abc\n"},
+		{"export DGBRIDGE_CHANNEL_ID=1\n", "DGBRIDGE_CHANNEL_ID=123\n"},
+		// The name is a prefix of another name
+		{"DGBRIDGE_CHANNEL_ID_OLD=1\n", "DGBRIDGE_CHANNEL_ID_OLD=1\nDGBRIDGE_CHANNEL_ID=123\n"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, SetEnvFileVar(test.contents, "DGBRIDGE_CHANNEL_ID", "123"), "Test #%v", i)
+	}
+}
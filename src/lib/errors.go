@@ -0,0 +1,110 @@
+package lib
+
+// This file implements the tally of the bridge's own errors, which is posted
+// to an admin channel as a summary instead of an alert per error.
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorReportTextLimit is the maximum length of an error in a report.
+const errorReportTextLimit = 200
+
+// errorReportLimit is the maximum length of a report, Discord's limit of embed
+// descriptions.
+const errorReportLimit = 4096
+
+// ErrorCount is the number of errors of a kind, e.g. failures to send
+// messages to Discord, with the first and last of them.
+type ErrorCount struct {
+	Kind      string // Message key of the kind, e.g. MsgErrorKindDiscord
+	Count     int
+	First     string // Text of the first error
+	FirstTime time.Time
+	Last      string // Text of the last error
+	LastTime  time.Time
+}
+
+// ErrorTally counts errors by kind until they are reported. It is safe for
+// concurrent use.
+type ErrorTally struct {
+	mutex  sync.Mutex
+	counts []*ErrorCount // In the order of the kinds' first errors
+}
+
+// Record counts an error.
+//
+// Parameters:
+//
+//	kind: message key of what failed, e.g. MsgErrorKindDiscord
+func (t *ErrorTally) Record(kind string, err error, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	text := err.Error()
+	for _, count := range t.counts {
+		if count.Kind == kind {
+			count.Count++
+			count.Last, count.LastTime = text, now
+			return
+		}
+	}
+	t.counts = append(t.counts, &ErrorCount{
+		Kind:      kind,
+		Count:     1,
+		First:     text,
+		FirstTime: now,
+		Last:      text,
+		LastTime:  now,
+	})
+}
+
+// Take returns the errors counted since the last call, and starts counting
+// anew.
+func (t *ErrorTally) Take() []ErrorCount {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	counts := make([]ErrorCount, len(t.counts))
+	for i, count := range t.counts {
+		counts[i] = *count
+	}
+	t.counts = nil
+	return counts
+}
+
+// FormatErrorReport formats counted errors as the description of a Discord
+// embed, with a line for each kind. Only the first error is shown for kinds
+// with a single error. Kinds that don't fit are left out.
+func FormatErrorReport(counts []ErrorCount, location *time.Location) string {
+	var report strings.Builder
+	for _, count := range counts {
+		first := fmt.Sprintf("`%v` (%v)", errorReportText(count.First), count.FirstTime.In(location).Format(time.TimeOnly))
+		var line string
+		if count.Count == 1 {
+			line = Tr(MsgErrorReportSingle, Tr(count.Kind), first)
+		} else {
+			last := fmt.Sprintf("`%v` (%v)", errorReportText(count.Last), count.LastTime.In(location).Format(time.TimeOnly))
+			line = Tr(MsgErrorReportLine, Tr(count.Kind), count.Count, first, last)
+		}
+		if report.Len() > 0 {
+			line = "\n" + line
+		}
+		if report.Len()+len(line) > errorReportLimit {
+			break
+		}
+		report.WriteString(line)
+	}
+	return report.String()
+}
+
+// errorReportText shortens an error for a report, and keeps it from closing
+// its code span early.
+func errorReportText(text string) string {
+	text = strings.ReplaceAll(text, "`", "'")
+	if runes := []rune(text); len(runes) > errorReportTextLimit {
+		text = string(runes[:errorReportTextLimit-1]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorTally(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var tally ErrorTally
+	tally.Record(MsgErrorKindDiscord, errors.New("HTTP 500"), start)
+	tally.Record(MsgErrorKindServer, errors.New("broken pipe"), start.Add(time.Second))
+	tally.Record(MsgErrorKindDiscord, errors.New("HTTP 502"), start.Add(time.Minute))
+	tally.Record(MsgErrorKindDiscord, errors.New("HTTP `503`"), start.Add(2*time.Minute))
+
+	counts := tally.Take()
+	assert.Equal(t, []ErrorCount{
+		{
+			Kind:      MsgErrorKindDiscord,
+			Count:     3,
+			First:     "HTTP 500",
+			FirstTime: start,
+			Last:      "HTTP `503`",
+			LastTime:  start.Add(2 * time.Minute),
+		},
+		{
+			Kind:      MsgErrorKindServer,
+			Count:     1,
+			First:     "broken pipe",
+			FirstTime: start.Add(time.Second),
+			Last:      "broken pipe",
+			LastTime:  start.Add(time.Second),
+		},
+	}, counts)
+	assert.Empty(t, tally.Take())
+
+	assert.Equal(t,
+		"**Sending messages to Discord**: 3 times, first `HTTP 500` (12:00:00), last `HTTP '503'` (12:02:00)\n"+
+			"**Writing to the server**: once, `broken pipe` (12:00:01)",
+		FormatErrorReport(counts, time.UTC))
+}
+
+func TestFormatErrorReportLimit(t *testing.T) {
+	var tally ErrorTally
+	long := errors.New(strings.Repeat("x", 1000))
+	for i := 0; i < 30; i++ {
+		tally.Record(string(rune('a'+i)), long, time.Now())
+	}
+	report := FormatErrorReport(tally.Take(), time.UTC)
+	assert.LessOrEqual(t, len(report), errorReportLimit)
+	assert.Contains(t, report, strings.Repeat("x", errorReportTextLimit-1)+"…`")
+}
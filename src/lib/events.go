@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Defaults of EventConfig.
+const (
+	DefaultEventDuration = time.Hour
+	DefaultEventLocation = "In-game"
+)
+
+// Maximum lengths of the fields of a Discord scheduled event.
+const (
+	eventNameLimit        = 100
+	eventDescriptionLimit = 1000
+	eventLocationLimit    = 100
+)
+
+// EventConfig makes a SubprocessToDiscord rule create a Discord scheduled
+// event, e.g. when the server announces a boss fight or a tournament. If a
+// scheduled event of the bridge with the same name exists, it is updated
+// instead.
+type EventConfig struct {
+	Name        string `validate:"required"` // Template of the event name, like Rule.Template
+	Description string // Optional, template of the event description
+	// Template of the start time: a duration from when the line was read, e.g.
+	// "${1}m", or an RFC 3339 time, e.g. "2024-06-01T18:00:00Z"
+	Start    string       `validate:"required"`
+	Duration ext.Duration // Defaults to DefaultEventDuration
+	Location string       // Optional, template of the location, defaults to DefaultEventLocation
+}
+
+// ScheduledEvent is the Discord scheduled event built for a match, see
+// RuleMatch.ScheduledEvent.
+type ScheduledEvent struct {
+	Name        string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+}
+
+// ScheduledEvent builds the scheduled event of a match of a rule with an
+// EventConfig.
+//
+// Parameters:
+//
+//	now: the time the line was read, relative start times are added to it
+func (m *RuleMatch) ScheduledEvent(input string, now time.Time) (ScheduledEvent, error) {
+	config := m.Rule.Event
+	input = strings.ReplaceAll(input, "\n", " ")
+	// expand builds a template, truncated to limit runes unless limit is 0.
+	expand := func(template string, limit int) string {
+		result := strings.TrimSpace(StripAnsi(expandTemplate(m.Rule.Match.Regexp, input, template, m.Rule.valueFormat())))
+		if runes := []rune(result); limit > 0 && len(runes) > limit {
+			result = string(runes[:limit])
+		}
+		return result
+	}
+	event := ScheduledEvent{
+		Name:        expand(config.Name, eventNameLimit),
+		Description: expand(config.Description, eventDescriptionLimit),
+		Location:    expand(config.Location, eventLocationLimit),
+	}
+	if event.Name == "" {
+		return event, fmt.Errorf("event name is empty")
+	}
+	if event.Location == "" {
+		event.Location = DefaultEventLocation
+	}
+	start, err := parseEventStart(expand(config.Start, 0), now)
+	if err != nil {
+		return event, err
+	}
+	duration := config.Duration.Duration
+	if duration <= 0 {
+		duration = DefaultEventDuration
+	}
+	event.Start = start
+	event.End = start.Add(duration)
+	return event, nil
+}
+
+// parseEventStart parses the start time of an event, see EventConfig.Start.
+func parseEventStart(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(duration), nil
+	}
+	start, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid event start %q, expected a duration or an RFC 3339 time", value)
+	}
+	return start, nil
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledEvent(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	rule := Rule{
+		Template: ":crossed_swords: ${1} in ${2} minutes!",
+		Event: &EventConfig{
+			Name:        "${1}",
+			Description: "Fight ${1} with everyone",
+			Start:       "${2}m",
+		},
+	}
+	assert.NoError(t, rule.Match.UnmarshalText([]byte(`(.+) starts in (\d+) minutes`)))
+	match := MatchRules([]Rule{rule}, nil, "Boss fight starts in 10 minutes")
+	event, err := match.ScheduledEvent("Boss fight starts in 10 minutes", now)
+	assert.NoError(t, err)
+	assert.Equal(t, ScheduledEvent{
+		Name:        "Boss fight",
+		Description: "Fight Boss fight with everyone",
+		Location:    DefaultEventLocation,
+		Start:       now.Add(10 * time.Minute),
+		End:         now.Add(10*time.Minute + DefaultEventDuration),
+	}, event)
+
+	rule.Event = &EventConfig{Name: "Tournament", Start: "${1}", Location: "Arena"}
+	rule.Event.Duration.Duration = 2 * time.Hour
+	assert.NoError(t, rule.Match.UnmarshalText([]byte(`Tournament at (\S+)`)))
+	match = MatchRules([]Rule{rule}, nil, "Tournament at 2024-06-01T18:00:00Z")
+	event, err = match.ScheduledEvent("Tournament at 2024-06-01T18:00:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "Arena", event.Location)
+	assert.Equal(t, time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC), event.Start)
+	assert.Equal(t, time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC), event.End)
+
+	match = MatchRules([]Rule{rule}, nil, "Tournament at noon")
+	_, err = match.ScheduledEvent("Tournament at noon", now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package lib
+
+// This file implements rule examples: inputs and expected results embedded in
+// the rules file, which are checked by the rule tester and --self-test.
+
+import "fmt"
+
+// RuleExample is an input of a rule, and the result the rule is expected to
+// produce from it.
+type RuleExample struct {
+	In string `validate:"required"`
+	// Expected result; empty expects the rule not to match, e.g. an input
+	// that should be left to another rule
+	Out string
+	// Optional, DiscordToSubprocess: the Props of the message, defaults to
+	// ExampleProps
+	Props *Props
+}
+
+// ExampleProps are the Props of the Discord messages of examples that don't
+// set their own.
+var ExampleProps = Props{
+	Author: Author{
+		Id:            "123456789012345678",
+		Username:      "example",
+		Nickname:      "Example",
+		GlobalName:    "Example",
+		Discriminator: "0",
+		AccentColor:   0xffffff,
+	},
+}
+
+// ExampleFailure is an example that its rule doesn't fulfil.
+type ExampleFailure struct {
+	Direction string      // One of the Direction constants
+	RuleId    string      // The rule of the example, see RuleId
+	Example   RuleExample // The failed example
+	Got       string      // Result of the matching rule, "" if no rule matched
+	GotRule   string      // ID of the matching rule, "" if no rule matched
+}
+
+func (f ExampleFailure) Error() string {
+	got := "no rule matched"
+	if f.GotRule != "" {
+		got = fmt.Sprintf("rule %v gave %q", f.GotRule, f.Got)
+	}
+	if f.Example.Out == "" {
+		return fmt.Sprintf("%v rule %v: example %q: expected the rule not to match, %v",
+			f.Direction, f.RuleId, f.Example.In, got)
+	}
+	return fmt.Sprintf("%v rule %v: example %q: expected %q, %v",
+		f.Direction, f.RuleId, f.Example.In, f.Example.Out, got)
+}
+
+// CheckExamples checks the examples of all rules. Each example is matched
+// against all rules of its direction, like the bridge does, so an earlier rule
+// matching the input fails the example. Lines of subprocess directions are
+// matched as if they were read from the rule's Source in the rule's State, and
+// players of rules with a Player template only have a Username.
+//
+// Returns:
+//
+//	the number of examples checked, and the ones that failed
+func (r *Rules) CheckExamples() (int, []ExampleFailure) {
+	checked := 0
+	var failures []ExampleFailure
+	for _, direction := range RuleDirections {
+		rules := r.List(direction)
+		for i := range rules {
+			for _, example := range rules[i].Examples {
+				checked++
+				if failure := r.CheckExample(direction, i, example); failure != nil {
+					failures = append(failures, *failure)
+				}
+			}
+		}
+	}
+	return checked, failures
+}
+
+// CheckExample checks an example of a rule, see CheckExamples.
+//
+// Parameters:
+//
+//	direction: one of the Direction constants
+//	index: index of the rule in the rule list of the direction
+//
+// Returns:
+//
+//	the failure, or nil if the rule fulfils the example
+func (r *Rules) CheckExample(direction string, index int, example RuleExample) *ExampleFailure {
+	rule := &r.List(direction)[index]
+	match := r.matchExample(direction, rule, example)
+	var passed bool
+	if example.Out == "" {
+		passed = match == nil || match.Rule != rule
+	} else {
+		passed = match != nil && match.Rule == rule && match.Result == example.Out
+	}
+	if passed {
+		return nil
+	}
+	failure := &ExampleFailure{Direction: direction, RuleId: RuleId(rule, index), Example: example}
+	if match != nil {
+		failure.Got, failure.GotRule = match.Result, match.RuleId()
+	}
+	return failure
+}
+
+// matchExample matches the input of an example of a rule, see CheckExamples.
+func (r *Rules) matchExample(direction string, rule *Rule, example RuleExample) *RuleMatch {
+	switch direction {
+	case DirectionDiscordToSubprocess:
+		props := ExampleProps
+		if example.Props != nil {
+			props = *example.Props
+		}
+		return r.Match(direction, &props, example.In)
+	case DirectionPeerToSubprocess, DirectionBackfill:
+		return r.Match(direction, nil, example.In)
+	}
+	info := LineInfo{Stderr: rule.Source == SourceStderr, State: rule.State}
+	return r.MatchPlayer(direction, info, example.In, func(player string) Props {
+		return Props{Author: Author{Username: player}}
+	})
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckExamples(t *testing.T) {
+	var rules Rules
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"SubprocessToDiscord": [
+			{
+				"Name": "chat",
+				"Match": "^<(\\w+)> (.*)$",
+				"Template": "**${1}**: ${2}",
+				"Examples": [
+					{"In": "<Steve> hi", "Out": "**Steve**: hi"},
+					{"In": "<Steve> hi", "Out": "Steve: hi"},
+					{"In": "Steve joined the game"}
+				]
+			},
+			{
+				"Name": "join",
+				"Match": "^(\\w+) joined the game$",
+				"Template": "${1} joined",
+				"Examples": [
+					{"In": "<Alex> Steve joined the game", "Out": "Steve joined"}
+				]
+			},
+			{
+				"Name": "error",
+				"Match": "ERROR (.*)",
+				"Template": "${1}",
+				"Source": "stderr",
+				"Examples": [{"In": "ERROR disk full", "Out": "disk full"}]
+			}
+		],
+		"DiscordToSubprocess": [
+			{
+				"Match": "(.*)",
+				"Template": "say <^N> ${1}",
+				"Examples": [
+					{"In": "hello", "Out": "say <Example> hello"},
+					{"In": "hello", "Out": "say <Bob> hello", "Props": {"Author": {"Username": "Bob"}}}
+				]
+			}
+		]
+	}`), &rules))
+
+	checked, failures := rules.CheckExamples()
+	assert.Equal(t, 7, checked)
+	assert.Equal(t, []ExampleFailure{
+		{
+			Direction: DirectionSubprocessToDiscord,
+			RuleId:    "chat",
+			Example:   RuleExample{In: "<Steve> hi", Out: "Steve: hi"},
+			Got:       "**Steve**: hi",
+			GotRule:   "chat",
+		},
+		{
+			// Shadowed by an earlier rule.
+			Direction: DirectionSubprocessToDiscord,
+			RuleId:    "join",
+			Example:   RuleExample{In: "<Alex> Steve joined the game", Out: "Steve joined"},
+			Got:       "**Alex**: Steve joined the game",
+			GotRule:   "chat",
+		},
+	}, failures)
+	assert.Equal(t,
+		`SubprocessToDiscord rule join: example "<Alex> Steve joined the game": expected "Steve joined", rule chat gave "**Alex**: Steve joined the game"`,
+		failures[1].Error())
+}
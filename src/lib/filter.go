@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Masks of a Filter.
+const (
+	FilterMaskAsterisks = "asterisks" // Replace every character with *
+	FilterMaskSpoiler   = "spoiler"   // Wrap in Discord spoiler tags (||text||)
+)
+
+// Filter masks unwanted words in relayed messages, e.g. profanity.
+// It is applied to the result of a rule, unless the rule has NoFilter set.
+type Filter struct {
+	Words      []string     // Masked as whole words, case-insensitively
+	Patterns   []ext.Regexp // Masked wherever they match
+	Mask       string       `validate:"omitempty,oneof=asterisks spoiler"`                                                             // Defaults to FilterMaskAsterisks
+	Directions []string     `validate:"dive,oneof=SubprocessToDiscord DiscordToSubprocess SubprocessToPeer PeerToSubprocess Backfill"` // Defaults to all
+	List       *FilterList  `json:"-"`                                                                                                 // Optional, entries added at runtime
+
+	wordsRegex *regexp.Regexp // Compiled Words, see compile
+}
+
+// compile prepares the filter for use. It is called by LoadRules.
+func (f *Filter) compile() {
+	f.wordsRegex = f.buildWordsRegex()
+}
+
+// buildWordsRegex compiles Words into a single regex.
+// Returns nil if there are no words.
+func (f *Filter) buildWordsRegex() *regexp.Regexp {
+	if len(f.Words) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(f.Words))
+	for i, word := range f.Words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// AppliesTo reports whether the filter masks messages relayed in a direction.
+func (f *Filter) AppliesTo(direction string) bool {
+	return len(f.Directions) == 0 || slices.Contains(f.Directions, direction)
+}
+
+// Apply masks all filtered words and patterns in a message.
+func (f *Filter) Apply(message string) string {
+	wordsRegex := f.wordsRegex
+	if wordsRegex == nil {
+		wordsRegex = f.buildWordsRegex()
+	}
+	if wordsRegex != nil {
+		message = wordsRegex.ReplaceAllStringFunc(message, f.mask)
+	}
+	for _, pattern := range f.Patterns {
+		message = pattern.ReplaceAllStringFunc(message, f.mask)
+	}
+	if f.List != nil {
+		message = f.List.apply(message, f.mask)
+	}
+	return message
+}
+
+func (f *Filter) mask(text string) string {
+	if f.Mask == FilterMaskSpoiler {
+		return "||" + text + "||"
+	}
+	return strings.Repeat("*", utf8.RuneCountInString(text))
+}
@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterApply(t *testing.T) {
+	filter := Filter{
+		Words:    []string{"darn", "h.ck"},
+		Patterns: make([]ext.Regexp, 1),
+	}
+	assert.NoError(t, filter.Patterns[0].UnmarshalText([]byte(`\d{3}-\d{4}`)))
+
+	assert.Equal(t, "**** it, call ******** now, hack", filter.Apply("Darn it, call 555-1234 now, hack"))
+	assert.Equal(t, "darnit **** ****", filter.Apply("darnit darn h.ck"))
+
+	filter.Mask = FilterMaskSpoiler
+	assert.Equal(t, "||darn||", filter.Apply("darn"))
+}
+
+func TestRulesMatchFilter(t *testing.T) {
+	rules := Rules{
+		SubprocessToDiscord: make([]Rule, 2),
+		Filter: &Filter{
+			Words:      []string{"darn"},
+			Directions: []string{DirectionSubprocessToDiscord},
+		},
+	}
+	assert.NoError(t, rules.SubprocessToDiscord[0].Match.UnmarshalText([]byte(`^<(\w+)> (.*)$`)))
+	rules.SubprocessToDiscord[0].Template = "${1}: ${2}"
+	assert.NoError(t, rules.SubprocessToDiscord[1].Match.UnmarshalText([]byte(`^\[raw] (.*)$`)))
+	rules.SubprocessToDiscord[1].Template = "${1}"
+	rules.SubprocessToDiscord[1].NoFilter = true
+	rules.DiscordToSubprocess = rules.SubprocessToDiscord
+
+	assert.Equal(t, "Bob: ****", rules.Match(DirectionSubprocessToDiscord, nil, "<Bob> darn").Result)
+	assert.Equal(t, "darn", rules.Match(DirectionSubprocessToDiscord, nil, "[raw] darn").Result)
+	assert.Equal(t, "Bob: darn", rules.Match(DirectionDiscordToSubprocess, nil, "<Bob> darn").Result)
+	assert.Nil(t, rules.Match(DirectionSubprocessToDiscord, nil, "nothing"))
+}
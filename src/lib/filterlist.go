@@ -0,0 +1,168 @@
+package lib
+
+// This file implements the filter list: words and patterns of the content
+// filter that are added and removed at runtime, e.g. with /filter, and saved to
+// a file, so that moderators can react to new slurs without editing the rules
+// file and restarting.
+//
+// The file has an entry per line: a word, or a regular expression prefixed
+// with "re:". Empty lines and lines starting with # are ignored.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// FilterPatternPrefix marks the entries of a filter list that are regular
+// expressions.
+const FilterPatternPrefix = "re:"
+
+// FilterList is a list of filtered words and patterns that is changed at
+// runtime and saved to a file, see Filter.List. It is safe for concurrent use.
+type FilterList struct {
+	path    string
+	mutex   sync.Mutex // Serializes changes
+	entries []string
+	regex   atomic.Pointer[regexp.Regexp] // Compiled entries, nil if there are none
+}
+
+// LoadFilterList loads a filter list file. A missing file is an empty list, and
+// is created once an entry is added.
+func LoadFilterList(path string) (*FilterList, error) {
+	list := &FilterList{path: path}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for number, line := range strings.Split(string(contents), "\n") {
+		entry := strings.TrimSpace(line)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if _, err := compileFilterEntry(entry); err != nil {
+			return nil, fmt.Errorf("line %v: %v", number+1, err)
+		}
+		if !slices.Contains(list.entries, entry) {
+			list.entries = append(list.entries, entry)
+		}
+	}
+	list.regex.Store(compileFilterEntries(list.entries))
+	return list, nil
+}
+
+// Entries returns the entries of the list, in the order they were added.
+func (l *FilterList) Entries() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return slices.Clone(l.entries)
+}
+
+// Add adds an entry to the list and saves the file.
+//
+// Parameters:
+//
+//	entry: a word, or a regular expression prefixed with FilterPatternPrefix
+//
+// Returns:
+//
+//	an error if the entry is invalid or listed already, or the file can't be
+//	saved
+func (l *FilterList) Add(entry string) error {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || strings.HasPrefix(entry, "#") || strings.Contains(entry, "\n") {
+		return fmt.Errorf("invalid entry")
+	}
+	if _, err := compileFilterEntry(entry); err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if slices.Contains(l.entries, entry) {
+		return fmt.Errorf("the entry is listed already")
+	}
+	return l.update(append(slices.Clone(l.entries), entry))
+}
+
+// Remove removes an entry from the list and saves the file.
+//
+// Returns:
+//
+//	false if the entry isn't listed
+func (l *FilterList) Remove(entry string) (bool, error) {
+	entry = strings.TrimSpace(entry)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	index := slices.Index(l.entries, entry)
+	if index < 0 {
+		return false, nil
+	}
+	return true, l.update(slices.Delete(slices.Clone(l.entries), index, index+1))
+}
+
+// update saves new entries, then uses them. The caller holds the mutex.
+func (l *FilterList) update(entries []string) error {
+	contents := "# Content filter entries, see /filter\n" + strings.Join(entries, "\n") + "\n"
+	if err := writeFileAtomic(l.path, []byte(contents)); err != nil {
+		return fmt.Errorf("error saving filter list: %v", err)
+	}
+	l.entries = entries
+	l.regex.Store(compileFilterEntries(entries))
+	return nil
+}
+
+// Replace replaces the entries of the list with those of a list loaded from
+// the file again, e.g. after it was edited by hand.
+func (l *FilterList) Replace(loaded *FilterList) {
+	entries := loaded.Entries()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = entries
+	l.regex.Store(compileFilterEntries(entries))
+}
+
+// apply masks the entries of the list in a message.
+func (l *FilterList) apply(message string, mask func(string) string) string {
+	if regex := l.regex.Load(); regex != nil {
+		message = regex.ReplaceAllStringFunc(message, mask)
+	}
+	return message
+}
+
+// compileFilterEntry returns the regular expression of an entry: words are
+// matched as whole words, case-insensitively, like Filter.Words.
+func compileFilterEntry(entry string) (string, error) {
+	if pattern, ok := strings.CutPrefix(entry, FilterPatternPrefix); ok {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid regular expression: %v", err)
+		}
+		if regex.MatchString("") {
+			return "", fmt.Errorf("the regular expression %q matches empty text", pattern)
+		}
+		return pattern, nil
+	}
+	return `(?i:\b` + regexp.QuoteMeta(entry) + `\b)`, nil
+}
+
+// compileFilterEntries compiles valid entries into a single regex.
+// Returns nil if there are no entries.
+func compileFilterEntries(entries []string) *regexp.Regexp {
+	if len(entries) == 0 {
+		return nil
+	}
+	patterns := make([]string, len(entries))
+	for i, entry := range entries {
+		patterns[i], _ = compileFilterEntry(entry)
+		patterns[i] = "(?:" + patterns[i] + ")"
+	}
+	return regexp.MustCompile(strings.Join(patterns, "|"))
+}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.txt")
+	list, err := LoadFilterList(path)
+	assert.NoError(t, err)
+	assert.Empty(t, list.Entries())
+
+	filter := &Filter{List: list}
+	assert.Equal(t, "darn it", filter.Apply("darn it"))
+	assert.NoError(t, list.Add("darn"))
+	assert.NoError(t, list.Add("re:\\d{3}-\\d{4}"))
+	assert.Error(t, list.Add("darn"), "entries are added once")
+	assert.Error(t, list.Add("re:("), "invalid patterns are refused")
+	assert.Error(t, list.Add("re:a*"), "patterns matching empty text are refused")
+	assert.Error(t, list.Add("# comment"))
+	assert.Equal(t, "**** it, call ******** Darnell", filter.Apply("DARN it, call 555-1234 Darnell"))
+
+	// The list is saved
+	loaded, err := LoadFilterList(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"darn", "re:\\d{3}-\\d{4}"}, loaded.Entries())
+
+	removed, err := list.Remove("darn")
+	assert.NoError(t, err)
+	assert.True(t, removed)
+	removed, err = list.Remove("darn")
+	assert.NoError(t, err)
+	assert.False(t, removed)
+	assert.Equal(t, "darn it", filter.Apply("darn it"))
+}
+
+func TestLoadFilterList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("# words\nheck\n\n  heck  \nre:[0-9]+\n"), 0o644))
+	list, err := LoadFilterList(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"heck", "re:[0-9]+"}, list.Entries())
+
+	assert.NoError(t, os.WriteFile(path, []byte("heck\nre:(\n"), 0o644))
+	_, err = LoadFilterList(path)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestFilterList_Replace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.txt")
+	list, err := LoadFilterList(path)
+	assert.NoError(t, err)
+	assert.NoError(t, list.Add("darn"))
+	assert.NoError(t, os.WriteFile(path, []byte("heck\n"), 0o644))
+
+	loaded, err := LoadFilterList(path)
+	assert.NoError(t, err)
+	list.Replace(loaded)
+	filter := &Filter{List: list}
+	assert.Equal(t, []string{"heck"}, list.Entries())
+	assert.Equal(t, "darn ****", filter.Apply("darn heck"))
+}
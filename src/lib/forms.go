@@ -0,0 +1,135 @@
+package lib
+
+// This file implements forms: Discord modals with text fields that are opened
+// with a slash command or a control panel button, and whose submissions are
+// written to the subprocess as a console command, e.g. banning a player with
+// a reason. Forms give moderators structured input instead of free-text
+// commands prone to typos.
+
+import (
+	"dgbridge/src/ext"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// FormModalPrefix starts the custom IDs of the modals of forms, followed by
+// the name of the form.
+const FormModalPrefix = "form:"
+
+// formFieldExpr matches the fields in the command of a form, e.g. ${player}.
+var formFieldExpr = regexp.MustCompile(`\$\{(\w+)}`)
+
+// Form is a modal with text fields, whose values are inserted into a console
+// command.
+type Form struct {
+	// Name of the slash command that opens the form, e.g. "ban-player"; it
+	// must not be the name of one of the bridge's commands
+	Name        string `validate:"required,max=32,lowercase,excludesall= "`
+	Description string `validate:"max=100"` // Optional, of the slash command
+	Title       string `validate:"required,max=45"`
+	// Template of the console command, where ${name} turns into the value of
+	// the field with that name, e.g. "ban ${player} ${reason}"
+	Command string      `validate:"required"`
+	Fields  []FormField `validate:"required,min=1,max=5,dive"` // Discord allows at most 5
+	// Optional, the members who may use the form besides administrators,
+	// e.g. {"Roles": ["Moderator"]}
+	Users *AuthorCondition
+}
+
+// FormField is a text field of a Form.
+type FormField struct {
+	Name        string `validate:"required"` // Referenced in Form.Command as ${name}
+	Label       string `validate:"required,max=45"`
+	Placeholder string `validate:"max=100"` // Optional
+	Paragraph   bool   // Optional, a multi-line field, e.g. for a reason
+	Required    bool   // Optional, the field must not be empty
+	MaxLength   int    `validate:"min=0,max=4000"` // Optional
+	// Optional, values must match, e.g. "^\\w{3,16}$" for player names
+	Pattern ext.Regexp
+}
+
+// Form returns the form with a name, or nil if there is none.
+func (r *Rules) Form(name string) *Form {
+	for i := range r.Forms {
+		if r.Forms[i].Name == name {
+			return &r.Forms[i]
+		}
+	}
+	return nil
+}
+
+// ModalId returns the custom ID of the form's modal.
+func (f *Form) ModalId() string {
+	return FormModalPrefix + f.Name
+}
+
+// MayUse reports whether a member may use the form.
+//
+// Parameters:
+//
+//	admin: whether the member is an administrator
+func (f *Form) MayUse(member Author, admin bool) bool {
+	return admin || (f.Users != nil && f.Users.Matches(member))
+}
+
+// FormatCommand returns the console command of a submission of the form.
+// Line breaks in the values are replaced with spaces, so that a value can't
+// write a second command.
+//
+// Parameters:
+//
+//	values: the values of the fields by name
+//
+// Returns:
+//
+//	the command, or an error naming the first field whose value is invalid
+func (f *Form) FormatCommand(values map[string]string) (string, error) {
+	clean := make(map[string]string, len(f.Fields))
+	for _, field := range f.Fields {
+		value := strings.Join(strings.Fields(values[field.Name]), " ")
+		if value == "" && field.Required {
+			return "", fmt.Errorf("%v is required", field.Label)
+		}
+		if value != "" && field.Pattern.Regexp != nil && !field.Pattern.MatchString(value) {
+			return "", fmt.Errorf("%v %q is invalid", field.Label, value)
+		}
+		clean[field.Name] = value
+	}
+	command := formFieldExpr.ReplaceAllStringFunc(f.Command, func(reference string) string {
+		return clean[formFieldExpr.FindStringSubmatch(reference)[1]]
+	})
+	return strings.TrimSpace(command), nil
+}
+
+// CheckForms checks that the names of the forms are unique, that their
+// commands only reference their fields, and that the panel buttons open forms
+// that exist.
+//
+// Returns:
+//
+//	an error for each problem
+func (r *Rules) CheckForms() []error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, form := range r.Forms {
+		if seen[form.Name] {
+			errs = append(errs, fmt.Errorf("there are several forms named %q", form.Name))
+		}
+		seen[form.Name] = true
+		for _, reference := range formFieldExpr.FindAllStringSubmatch(form.Command, -1) {
+			if !slices.ContainsFunc(form.Fields, func(field FormField) bool { return field.Name == reference[1] }) {
+				errs = append(errs, fmt.Errorf("form %v: the command references the unknown field %q", form.Name, reference[1]))
+			}
+		}
+	}
+	if r.Panel != nil {
+		for _, button := range r.Panel.Buttons {
+			if button.Form != "" && r.Form(button.Form) == nil {
+				errs = append(errs, fmt.Errorf("panel button %q: there is no form %q", button.Label, button.Form))
+			}
+		}
+	}
+	return errs
+}
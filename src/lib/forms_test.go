@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForm_FormatCommand(t *testing.T) {
+	form := &Form{
+		Name:    "ban-player",
+		Command: "ban ${player} ${reason}",
+		Fields: []FormField{
+			{Name: "player", Label: "Player", Required: true, Pattern: ext.Regexp{Regexp: regexp.MustCompile(`^\w{3,16}$`)}},
+			{Name: "reason", Label: "Reason", Paragraph: true},
+		},
+	}
+	command, err := form.FormatCommand(map[string]string{"player": " Steve ", "reason": "griefing\nop yourself"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ban Steve griefing op yourself", command, "line breaks can't start another command")
+	command, err = form.FormatCommand(map[string]string{"player": "Steve"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ban Steve", command)
+
+	_, err = form.FormatCommand(map[string]string{"reason": "griefing"})
+	assert.EqualError(t, err, "Player is required")
+	_, err = form.FormatCommand(map[string]string{"player": "Steve; stop"})
+	assert.EqualError(t, err, `Player "Steve; stop" is invalid`)
+}
+
+func TestRules_Form(t *testing.T) {
+	rules, err := ParseRules([]byte(`{
+		"SubprocessToDiscord": [],
+		"DiscordToSubprocess": [],
+		"Forms": [{
+			"Name": "kick",
+			"Title": "Kick a player",
+			"Command": "kick ${player}",
+			"Fields": [{"Name": "player", "Label": "Player", "Pattern": "^\\w+$"}],
+			"Users": {"Roles": ["Moderator"]}
+		}]
+	}`))
+	assert.NoError(t, err)
+	form := rules.Form("kick")
+	if assert.NotNil(t, form) {
+		assert.Equal(t, "form:kick", form.ModalId())
+		assert.True(t, form.MayUse(Author{Roles: []Role{{Name: "Moderator"}}}, false))
+		assert.False(t, form.MayUse(Author{}, false))
+		assert.True(t, form.MayUse(Author{}, true))
+	}
+	assert.Nil(t, rules.Form("ban"))
+}
+
+func TestRules_CheckForms(t *testing.T) {
+	rules := &Rules{
+		Forms: []Form{
+			{Name: "kick", Command: "kick ${player}", Fields: []FormField{{Name: "player"}}},
+			{Name: "kick", Command: "kick ${name}", Fields: []FormField{{Name: "player"}}},
+		},
+		Panel: &PanelConfig{Buttons: []PanelButton{
+			{Label: "Kick", Form: "kick"},
+			{Label: "Ban", Form: "ban"},
+		}},
+	}
+	errs := rules.CheckForms()
+	if assert.Len(t, errs, 3) {
+		assert.EqualError(t, errs[0], `there are several forms named "kick"`)
+		assert.EqualError(t, errs[1], `form kick: the command references the unknown field "name"`)
+		assert.EqualError(t, errs[2], `panel button "Ban": there is no form "ban"`)
+	}
+}
@@ -0,0 +1,32 @@
+package lib
+
+import "sync/atomic"
+
+// Readiness tracks whether the bridge relays messages, for the readiness probe
+// of --health_listen. It is safe for concurrent use.
+type Readiness struct {
+	started atomic.Bool // Whether the console was started
+	ready   atomic.Bool // Whether the Discord session is ready
+}
+
+// SetStarted marks the console as started.
+func (r *Readiness) SetStarted() {
+	r.started.Store(true)
+}
+
+// SetReady marks the Discord session as ready.
+func (r *Readiness) SetReady() {
+	r.ready.Store(true)
+}
+
+// NotReady returns why the bridge doesn't relay messages yet, or "" if it
+// does.
+func (r *Readiness) NotReady() string {
+	switch {
+	case !r.started.Load():
+		return "the console wasn't started yet"
+	case !r.ready.Load():
+		return "the Discord session isn't ready yet"
+	}
+	return ""
+}
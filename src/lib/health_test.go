@@ -0,0 +1,19 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	var readiness Readiness
+	assert.Equal(t, "the console wasn't started yet", readiness.NotReady())
+	readiness.SetReady()
+	assert.Equal(t, "the console wasn't started yet", readiness.NotReady())
+	readiness = Readiness{}
+	readiness.SetStarted()
+	assert.Equal(t, "the Discord session isn't ready yet", readiness.NotReady())
+	readiness.SetReady()
+	assert.Empty(t, readiness.NotReady())
+}
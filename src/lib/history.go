@@ -0,0 +1,98 @@
+package lib
+
+// This file implements the message cursor: the ID of the last Discord message
+// of the relay channel that was relayed to the subprocess, saved to a file, so
+// that on startup the messages sent while the bridge was down can be fetched
+// from the channel's history and relayed, see --discord_backfill.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MessageCursor tracks the last processed message of a channel, saved to a
+// file by Save. It is safe for concurrent use.
+type MessageCursor struct {
+	path    string
+	mutex   sync.Mutex
+	last    string // ID of the last processed message, empty if none was
+	changed bool   // Whether last changed since the file was saved
+}
+
+// LoadMessageCursor loads a cursor file. A missing file is a cursor without a
+// processed message, and is created once a message is processed.
+func LoadMessageCursor(path string) (*MessageCursor, error) {
+	cursor := &MessageCursor{path: path}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cursor, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cursor.last = strings.TrimSpace(string(contents))
+	if cursor.last != "" && !isSnowflake(cursor.last) {
+		return nil, fmt.Errorf("invalid message ID %q", cursor.last)
+	}
+	return cursor, nil
+}
+
+// Last returns the ID of the last processed message, empty if none was.
+func (c *MessageCursor) Last() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.last
+}
+
+// Advance records a processed message. The cursor only moves forward: a
+// message that isn't newer than the last processed message, e.g. because
+// messages were processed out of order, leaves it unchanged.
+//
+// Returns:
+//
+//	false if the message isn't newer than the last processed message
+func (c *MessageCursor) Advance(id string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !SnowflakeNewer(id, c.last) {
+		return false
+	}
+	c.last = id
+	c.changed = true
+	return true
+}
+
+// Save saves the cursor to the file, if it advanced since it was last saved.
+func (c *MessageCursor) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.changed {
+		return nil
+	}
+	if err := writeFileAtomic(c.path, []byte(c.last+"\n")); err != nil {
+		return fmt.Errorf("error saving message cursor: %v", err)
+	}
+	c.changed = false
+	return nil
+}
+
+// SnowflakeNewer reports whether the Discord ID a is newer than b. IDs grow
+// with the time they were created; any ID is newer than the empty ID.
+func SnowflakeNewer(a string, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
+
+func isSnowflake(id string) bool {
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return id != "" && id[0] != '0'
+}
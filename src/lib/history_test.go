@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageCursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cursor")
+	cursor, err := LoadMessageCursor(path)
+	assert.NoError(t, err)
+	assert.Empty(t, cursor.Last())
+
+	assert.NoError(t, cursor.Save())
+	assert.NoFileExists(t, path, "the cursor didn't advance")
+
+	assert.True(t, cursor.Advance("1100000000000000000"))
+	assert.False(t, cursor.Advance("999999999999999999"), "shorter IDs are older")
+	assert.False(t, cursor.Advance("1100000000000000000"), "the message was processed already")
+	assert.Equal(t, "1100000000000000000", cursor.Last())
+
+	// The cursor is saved
+	assert.NoError(t, cursor.Save())
+	loaded, err := LoadMessageCursor(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "1100000000000000000", loaded.Last())
+
+	// Unchanged cursors aren't saved again.
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, cursor.Save())
+	assert.NoFileExists(t, path)
+
+	assert.NoError(t, os.WriteFile(path, []byte("latest\n"), 0o644))
+	_, err = LoadMessageCursor(path)
+	assert.Error(t, err)
+}
+
+func TestSnowflakeNewer(t *testing.T) {
+	assert.True(t, SnowflakeNewer("1", ""))
+	assert.True(t, SnowflakeNewer("10", "9"))
+	assert.True(t, SnowflakeNewer("19", "18"))
+	assert.False(t, SnowflakeNewer("18", "18"))
+	assert.False(t, SnowflakeNewer("", "1"))
+}
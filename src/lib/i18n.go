@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultLocale is the locale used when no locale is selected, and the
+// fallback for messages missing from the selected locale's catalog.
+const DefaultLocale = "en"
+
+// Keys of bridge-originated messages. The message for each key is a format
+// string passed to fmt.Sprintf together with the arguments given to Tr.
+const (
+	MsgErrorLoadingRules    = "error_loading_rules"
+	MsgErrorStartingCommand = "error_starting_command"
+	MsgErrorStartingBot     = "error_starting_bot"
+	MsgRetryingBotStart     = "retrying_bot_start"
+	MsgDiscordNotReady      = "discord_not_ready"
+	MsgBackfillHeader       = "backfill_header"
+	MsgMaintenance          = "maintenance"
+	MsgMaintenanceUntil     = "maintenance_until"
+	MsgMaintenanceStatus    = "maintenance_status"
+	MsgDailySummaryTitle    = "daily_summary_title"
+	MsgDailySummary         = "daily_summary"
+	MsgServerOffline        = "server_offline"
+	MsgErrorReportTitle     = "error_report_title"
+	MsgErrorReportLine      = "error_report_line"
+	MsgErrorReportSingle    = "error_report_single"
+	MsgErrorKindDiscord     = "error_kind_discord"
+	MsgErrorKindServer      = "error_kind_server"
+)
+
+// catalogs maps a locale name to its message catalog.
+var catalogs = map[string]map[string]string{
+	"en": {
+		MsgErrorLoadingRules:    "error loading rules: %v",
+		MsgErrorStartingCommand: "error starting command: %v",
+		MsgErrorStartingBot:     "failed to start Discord bot: %v",
+		MsgRetryingBotStart:     "retrying Discord connection in %v",
+		MsgDiscordNotReady:      "Discord was not ready after %v, starting subprocess anyway",
+		MsgBackfillHeader:       "**Before the bridge started** (%v messages):",
+		MsgMaintenance:          "The server is under maintenance.",
+		MsgMaintenanceUntil:     "The server is under maintenance until ~%v.",
+		MsgMaintenanceStatus:    "Under maintenance",
+		MsgDailySummaryTitle:    "Daily summary for %v",
+		MsgDailySummary:         "^G messages to Discord, ^S messages to the server, ^U chatters, peak of ^P players online",
+		MsgServerOffline:        "Server offline",
+		MsgErrorReportTitle:     "The bridge ran into %v errors",
+		MsgErrorReportLine:      "**%v**: %v times, first %v, last %v",
+		MsgErrorReportSingle:    "**%v**: once, %v",
+		MsgErrorKindDiscord:     "Sending messages to Discord",
+		MsgErrorKindServer:      "Writing to the server",
+	},
+	"de": {
+		MsgErrorLoadingRules:    "Fehler beim Laden der Regeln: %v",
+		MsgErrorStartingCommand: "Fehler beim Starten des Befehls: %v",
+		MsgErrorStartingBot:     "Discord-Bot konnte nicht gestartet werden: %v",
+		MsgRetryingBotStart:     "neuer Verbindungsversuch zu Discord in %v",
+		MsgDiscordNotReady:      "Discord war nach %v nicht bereit, der Unterprozess wird trotzdem gestartet",
+		MsgBackfillHeader:       "**Vor dem Start der Brücke** (%v Nachrichten):",
+		MsgMaintenance:          "Der Server wird gerade gewartet.",
+		MsgMaintenanceUntil:     "Der Server wird bis ca. %v gewartet.",
+		MsgMaintenanceStatus:    "In Wartung",
+		MsgDailySummaryTitle:    "Tageszusammenfassung für %v",
+		MsgDailySummary:         "^G Nachrichten an Discord, ^S Nachrichten an den Server, ^U Schreibende, bis zu ^P Spieler online",
+		MsgServerOffline:        "Server offline",
+		MsgErrorReportTitle:     "Die Brücke hatte %v Fehler",
+		MsgErrorReportLine:      "**%v**: %v-mal, zuerst %v, zuletzt %v",
+		MsgErrorReportSingle:    "**%v**: einmal, %v",
+		MsgErrorKindDiscord:     "Senden von Nachrichten an Discord",
+		MsgErrorKindServer:      "Schreiben an den Server",
+	},
+	"es": {
+		MsgErrorLoadingRules:    "error al cargar las reglas: %v",
+		MsgErrorStartingCommand: "error al iniciar el comando: %v",
+		MsgErrorStartingBot:     "no se pudo iniciar el bot de Discord: %v",
+		MsgRetryingBotStart:     "reintentando la conexión con Discord en %v",
+		MsgDiscordNotReady:      "Discord no estaba listo tras %v, iniciando el subproceso de todos modos",
+		MsgBackfillHeader:       "**Antes de iniciar el puente** (%v mensajes):",
+		MsgMaintenance:          "El servidor está en mantenimiento.",
+		MsgMaintenanceUntil:     "El servidor está en mantenimiento hasta ~%v.",
+		MsgMaintenanceStatus:    "En mantenimiento",
+		MsgDailySummaryTitle:    "Resumen diario del %v",
+		MsgDailySummary:         "^G mensajes a Discord, ^S mensajes al servidor, ^U participantes, máximo de ^P jugadores conectados",
+		MsgServerOffline:        "Servidor desconectado",
+		MsgErrorReportTitle:     "El puente tuvo %v errores",
+		MsgErrorReportLine:      "**%v**: %v veces, primero %v, último %v",
+		MsgErrorReportSingle:    "**%v**: una vez, %v",
+		MsgErrorKindDiscord:     "Envío de mensajes a Discord",
+		MsgErrorKindServer:      "Escritura al servidor",
+	},
+	"fr": {
+		MsgErrorLoadingRules:    "erreur lors du chargement des règles : %v",
+		MsgErrorStartingCommand: "erreur lors du démarrage de la commande : %v",
+		MsgErrorStartingBot:     "impossible de démarrer le bot Discord : %v",
+		MsgRetryingBotStart:     "nouvelle tentative de connexion à Discord dans %v",
+		MsgDiscordNotReady:      "Discord n'était pas prêt après %v, démarrage du sous-processus quand même",
+		MsgBackfillHeader:       "**Avant le démarrage du pont** (%v messages) :",
+		MsgMaintenance:          "Le serveur est en maintenance.",
+		MsgMaintenanceUntil:     "Le serveur est en maintenance jusqu’à ~%v.",
+		MsgMaintenanceStatus:    "En maintenance",
+		MsgDailySummaryTitle:    "Résumé quotidien du %v",
+		MsgDailySummary:         "^G messages vers Discord, ^S messages vers le serveur, ^U participants, jusqu’à ^P joueurs en ligne",
+		MsgServerOffline:        "Serveur hors ligne",
+		MsgErrorReportTitle:     "Le pont a rencontré %v erreurs",
+		MsgErrorReportLine:      "**%v** : %v fois, d’abord %v, en dernier %v",
+		MsgErrorReportSingle:    "**%v** : une fois, %v",
+		MsgErrorKindDiscord:     "Envoi de messages à Discord",
+		MsgErrorKindServer:      "Écriture vers le serveur",
+	},
+	"pt": {
+		MsgErrorLoadingRules:    "erro ao carregar as regras: %v",
+		MsgErrorStartingCommand: "erro ao iniciar o comando: %v",
+		MsgErrorStartingBot:     "falha ao iniciar o bot do Discord: %v",
+		MsgRetryingBotStart:     "tentando conectar ao Discord novamente em %v",
+		MsgDiscordNotReady:      "O Discord não estava pronto após %v, iniciando o subprocesso mesmo assim",
+		MsgBackfillHeader:       "**Antes de a ponte iniciar** (%v mensagens):",
+		MsgMaintenance:          "O servidor está em manutenção.",
+		MsgMaintenanceUntil:     "O servidor está em manutenção até ~%v.",
+		MsgMaintenanceStatus:    "Em manutenção",
+		MsgDailySummaryTitle:    "Resumo diário de %v",
+		MsgDailySummary:         "^G mensagens para o Discord, ^S mensagens para o servidor, ^U participantes, pico de ^P jogadores online",
+		MsgServerOffline:        "Servidor offline",
+		MsgErrorReportTitle:     "A ponte encontrou %v erros",
+		MsgErrorReportLine:      "**%v**: %v vezes, primeiro %v, último %v",
+		MsgErrorReportSingle:    "**%v**: uma vez, %v",
+		MsgErrorKindDiscord:     "Envio de mensagens ao Discord",
+		MsgErrorKindServer:      "Escrita no servidor",
+	},
+}
+
+// locale is the currently selected locale.
+var locale = DefaultLocale
+
+// SetLocale selects the catalog used by Tr.
+// Returns an error if there is no catalog for the locale.
+func SetLocale(name string) error {
+	if _, ok := catalogs[name]; !ok {
+		return fmt.Errorf("unknown locale %q, available locales: %v", name, Locales())
+	}
+	locale = name
+	return nil
+}
+
+// Locales returns the names of all available locales, sorted.
+func Locales() []string {
+	names := make([]string, 0, len(catalogs))
+	for name := range catalogs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tr returns the message for key in the selected locale, formatted with args.
+// Falls back to DefaultLocale if the selected catalog lacks the message, and
+// to the key itself if no catalog has it.
+func Tr(key string, args ...any) string {
+	format, ok := catalogs[locale][key]
+	if !ok {
+		format, ok = catalogs[DefaultLocale][key]
+	}
+	if !ok {
+		format = key
+	}
+	return fmt.Sprintf(format, args...)
+}
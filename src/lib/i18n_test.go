@@ -0,0 +1,24 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTr(t *testing.T) {
+	defer func() { locale = DefaultLocale }()
+
+	assert.Equal(t, "error loading rules: boom", Tr(MsgErrorLoadingRules, "boom"))
+
+	assert.NoError(t, SetLocale("de"))
+	assert.Equal(t, "Fehler beim Laden der Regeln: boom", Tr(MsgErrorLoadingRules, "boom"))
+
+	catalogs[DefaultLocale]["only_en"] = "fallback %v"
+	defer delete(catalogs[DefaultLocale], "only_en")
+	assert.Equal(t, "fallback 1", Tr("only_en", 1))
+
+	assert.Equal(t, "missing_key", Tr("missing_key"))
+	assert.Error(t, SetLocale("xx"))
+	assert.Equal(t, "de", locale)
+}
@@ -0,0 +1,234 @@
+package lib
+
+// This file implements the message journal: the relayed messages, saved as
+// JSON lines, and the transcripts exported from it.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of transcripts, see FormatTranscript.
+const (
+	TranscriptFormatText = "text"
+	TranscriptFormatHtml = "html"
+)
+
+// journalLineLimit is the maximum length of a journal line.
+const journalLineLimit = 1024 * 1024
+
+// JournalEntry is a relayed message.
+type JournalEntry struct {
+	Time      time.Time
+	Direction string // DirectionSubprocessToDiscord or DirectionDiscordToSubprocess
+	Author    string `json:",omitempty"` // Discord name or in-game player, may be empty
+	Content   string
+}
+
+// Journal appends relayed messages to a file, one JSON line each, so that they
+// can be exported later. Entries older than its maximum age are removed by
+// Prune. It is safe for concurrent use.
+type Journal struct {
+	mutex  sync.Mutex
+	path   string
+	maxAge time.Duration // 0 to keep all entries
+	file   *os.File      // Opened for appending
+}
+
+// OpenJournal opens a journal, creating its file if it doesn't exist.
+//
+// Parameters:
+//
+//	maxAge: time after which entries are removed by Prune, 0 to keep them
+func OpenJournal(path string, maxAge time.Duration) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, maxAge: maxAge, file: file}, nil
+}
+
+// Record appends an entry to the journal.
+func (j *Journal) Record(entry JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	_, err = j.file.Write(append(line, '\n'))
+	return err
+}
+
+// Read returns the entries of the journal since a time, oldest first.
+func (j *Journal) Read(since time.Time) ([]JournalEntry, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	file, err := os.Open(j.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readJournal(file, since)
+}
+
+// Prune removes the entries older than the journal's maximum age.
+func (j *Journal) Prune(now time.Time) error {
+	if j.maxAge <= 0 {
+		return nil
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	file, err := os.Open(j.path)
+	if err != nil {
+		return err
+	}
+	entries, err := readJournal(file, now.Add(-j.maxAge))
+	file.Close()
+	if err != nil {
+		return err
+	}
+	var content bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		content.Write(append(line, '\n'))
+	}
+	// Write a temporary file first, so that a crash doesn't leave a truncated
+	// journal behind. The journal is closed first, as Windows can't replace
+	// open files.
+	if err := os.WriteFile(j.path+".tmp", content.Bytes(), 0o644); err != nil {
+		return err
+	}
+	j.file.Close()
+	renameErr := os.Rename(j.path+".tmp", j.path)
+	j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if renameErr != nil {
+		return renameErr
+	}
+	return err
+}
+
+// Close closes the journal's file.
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.file.Close()
+}
+
+// readJournal reads the entries of a journal since a time. Lines that can't be
+// decoded, e.g. one cut off by a crash, are skipped.
+func readJournal(reader io.Reader, since time.Time) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, journalLineLimit)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// transcriptHtml is the template of HTML transcripts.
+var transcriptHtml = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 2px 8px; vertical-align: top; }
+td.message { white-space: pre-wrap; }
+tr.DiscordToSubprocess { background: #eef; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>Time</th><th>Direction</th><th>Author</th><th>Message</th></tr>
+{{- range .Entries}}
+<tr class="{{.Direction}}"><td>{{.Time}}</td><td>{{.Direction}}</td><td>{{.Author}}</td><td class="message">{{.Content}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// transcriptEntry is a JournalEntry formatted for a transcript.
+type transcriptEntry struct {
+	Time      string
+	Direction string
+	Author    string
+	Content   string
+}
+
+// FormatTranscript formats journal entries as a transcript, e.g. for a
+// moderation review.
+//
+// Parameters:
+//
+//	format: one of the TranscriptFormat constants
+//	title: title of the transcript, e.g. its time range
+//	location: time zone of the times
+func FormatTranscript(entries []JournalEntry, format string, title string, location *time.Location) ([]byte, error) {
+	formatted := make([]transcriptEntry, len(entries))
+	for i, entry := range entries {
+		formatted[i] = transcriptEntry{
+			Time:      entry.Time.In(location).Format(time.DateTime),
+			Direction: transcriptDirection(entry.Direction),
+			Author:    entry.Author,
+			Content:   StripAnsi(entry.Content),
+		}
+	}
+	var buffer bytes.Buffer
+	switch format {
+	case TranscriptFormatText:
+		buffer.WriteString(title + "\n\n")
+		for _, entry := range formatted {
+			author := ""
+			if entry.Author != "" {
+				author = entry.Author + ": "
+			}
+			// Indent continuation lines, so that each entry starts with its time.
+			content := strings.ReplaceAll(entry.Content, "\n", "\n    ")
+			fmt.Fprintf(&buffer, "%v [%v] %v%v\n", entry.Time, entry.Direction, author, content)
+		}
+	case TranscriptFormatHtml:
+		err := transcriptHtml.Execute(&buffer, struct {
+			Title   string
+			Entries []transcriptEntry
+		}{title, formatted})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown transcript format %q", format)
+	}
+	return buffer.Bytes(), nil
+}
+
+// transcriptDirection returns the label of a direction in transcripts.
+func transcriptDirection(direction string) string {
+	switch direction {
+	case DirectionSubprocessToDiscord:
+		return "Server → Discord"
+	case DirectionDiscordToSubprocess:
+		return "Discord → Server"
+	}
+	return direction
+}
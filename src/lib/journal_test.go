@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	journal, err := OpenJournal(path, 24*time.Hour)
+	assert.NoError(t, err)
+	entries := []JournalEntry{
+		{Time: start, Direction: DirectionSubprocessToDiscord, Author: "Steve", Content: "**Steve**: hi"},
+		{Time: start.Add(time.Hour), Direction: DirectionDiscordToSubprocess, Author: "Alice", Content: "hello"},
+		{Time: start.Add(2 * time.Hour), Direction: DirectionSubprocessToDiscord, Content: "Server started"},
+	}
+	for _, entry := range entries {
+		assert.NoError(t, journal.Record(entry))
+	}
+	// A line cut off by a crash is skipped.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"Time":"2024-05-01T14:30:00Z","Direc` + "\n")
+	assert.NoError(t, err)
+	file.Close()
+
+	read, err := journal.Read(start.Add(30 * time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, entries[1:], read)
+
+	// Prune keeps the entries within the maximum age, and recording continues.
+	assert.NoError(t, journal.Prune(start.Add(24*time.Hour+time.Minute)))
+	later := JournalEntry{Time: start.Add(25 * time.Hour), Direction: DirectionSubprocessToDiscord, Content: "later"}
+	assert.NoError(t, journal.Record(later))
+	assert.NoError(t, journal.Close())
+
+	journal, err = OpenJournal(path, 0)
+	assert.NoError(t, err)
+	defer journal.Close()
+	read, err = journal.Read(time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, []JournalEntry{entries[1], entries[2], later}, read)
+}
+
+func TestFormatTranscript(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := []JournalEntry{
+		{Time: start, Direction: DirectionSubprocessToDiscord, Author: "Steve", Content: "\x1b[32m<b>hi</b>\x1b[0m"},
+		{Time: start.Add(time.Minute), Direction: DirectionDiscordToSubprocess, Author: "Alice", Content: "two\nlines"},
+	}
+	tests := []struct {
+		format   string
+		contains []string
+	}{
+		{
+			format: TranscriptFormatText,
+			contains: []string{
+				"Last 24 hours\n\n",
+				"2024-05-01 12:00:00 [Server → Discord] Steve: <b>hi</b>\n",
+				"2024-05-01 12:01:00 [Discord → Server] Alice: two\n    lines\n",
+			},
+		},
+		{
+			format: TranscriptFormatHtml,
+			contains: []string{
+				"<title>Last 24 hours</title>",
+				`<td>Steve</td><td class="message">&lt;b&gt;hi&lt;/b&gt;</td>`,
+				"<td>2024-05-01 12:01:00</td>",
+			},
+		},
+	}
+	for i, test := range tests {
+		transcript, err := FormatTranscript(entries, test.format, "Last 24 hours", time.UTC)
+		assert.NoError(t, err, "Test #%v", i)
+		for _, expected := range test.contains {
+			assert.Contains(t, string(transcript), expected, "Test #%v", i)
+		}
+	}
+
+	_, err := FormatTranscript(entries, "pdf", "", time.UTC)
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package lib
+
+// This file implements JSON lines: subprocess lines that are JSON objects, e.g.
+// of a log4j JSON layout, whose fields templates insert with ${json:field}
+// instead of extracting them with capture groups:
+//   - ${json:level} inserts the field "level"
+//   - ${json:thrown.message} inserts the field "message" of the object "thrown"
+//   - ${json:tags.0} inserts the first element of the array "tags"
+//
+// Fields that are objects or arrays are inserted as JSON, missing fields as
+// nothing. A rule whose template inserts fields only applies to JSON lines, so
+// other lines, e.g. a stack trace printed outside the layout, fall through to
+// later rules matching the raw line.
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// jsonTokenRegex matches the ${json:field} tokens of a template.
+var jsonTokenRegex = regexp.MustCompile(`\$\{json:([^}]+)}`)
+
+// JsonLines declares the subprocess lines that are JSON objects.
+type JsonLines struct {
+	// Streams printing JSON lines, Source constants; defaults to both
+	Sources []string `validate:"dive,oneof=stdout stderr"`
+	// Subprocess instances printing JSON lines; defaults to all
+	Instances []string
+}
+
+// JsonFields are the fields of a JSON line.
+type JsonFields map[string]any
+
+// Parse parses a subprocess line as a JSON object if its source prints JSON
+// lines.
+//
+// Returns:
+//
+//	the fields, or nil if the line's source doesn't print JSON lines or the
+//	line isn't a JSON object
+func (j *JsonLines) Parse(info LineInfo, line string) JsonFields {
+	if j == nil || !j.appliesTo(info) {
+		return nil
+	}
+	line = strings.TrimSpace(StripAnsi(line))
+	if !strings.HasPrefix(line, "{") {
+		return nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var fields JsonFields
+	if err := decoder.Decode(&fields); err != nil || decoder.More() {
+		return nil
+	}
+	return fields
+}
+
+func (j *JsonLines) appliesTo(info LineInfo) bool {
+	if len(j.Sources) > 0 && !slices.Contains(j.Sources, info.Source()) {
+		return false
+	}
+	return len(j.Instances) == 0 || slices.Contains(j.Instances, info.Instance)
+}
+
+// Get returns a field by its path, e.g. "thrown.message". Keys containing
+// dots, e.g. "log.level", are found too.
+//
+// Returns:
+//
+//	the field as text, and false if there is no such field
+func (f JsonFields) Get(path string) (string, bool) {
+	value, ok := lookupJsonPath(map[string]any(f), path)
+	if !ok {
+		return "", false
+	}
+	return formatJsonValue(value), true
+}
+
+func lookupJsonPath(value any, path string) (any, bool) {
+	switch value := value.(type) {
+	case map[string]any:
+		if field, ok := value[path]; ok {
+			return field, true
+		}
+		for i := range len(path) {
+			if path[i] != '.' {
+				continue
+			}
+			if field, ok := value[path[:i]]; ok {
+				if result, ok := lookupJsonPath(field, path[i+1:]); ok {
+					return result, true
+				}
+			}
+		}
+	case []any:
+		index, rest, _ := strings.Cut(path, ".")
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(value) {
+			return nil, false
+		}
+		if rest == "" {
+			return value[i], true
+		}
+		return lookupJsonPath(value[i], rest)
+	}
+	return nil, false
+}
+
+func formatJsonValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		_ = encoder.Encode(value)
+		return strings.TrimSuffix(buffer.String(), "\n")
+	}
+}
+
+// usesJsonFields reports whether a template inserts fields of JSON lines.
+func usesJsonFields(template string) bool {
+	return strings.Contains(template, "${json:")
+}
+
+// expandJsonFields replaces the ${json:field} tokens of a template with the
+// fields of a JSON line. The fields are inserted literally, so $ in them
+// doesn't expand capture groups.
+func expandJsonFields(template string, fields JsonFields) string {
+	if !usesJsonFields(template) {
+		return template
+	}
+	return jsonTokenRegex.ReplaceAllStringFunc(template, func(token string) string {
+		value, _ := fields.Get(jsonTokenRegex.FindStringSubmatch(token)[1])
+		return escapeDollars(value)
+	})
+}
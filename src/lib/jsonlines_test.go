@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonLinesParse(t *testing.T) {
+	lines := &JsonLines{Sources: []string{SourceStdout}, Instances: []string{"lobby"}}
+	line := `{"level":"WARN","message":"Can't keep up!","thrown":{"message":"Timeout"},"tags":["perf",2],"log.origin":"Server","ms":2003.5,"ok":false,"extra":null}`
+	fields := lines.Parse(LineInfo{Instance: "lobby"}, line)
+	assert.NotNil(t, fields)
+
+	tests := []struct {
+		path     string
+		expected string
+		found    bool
+	}{
+		{"level", "WARN", true},
+		{"thrown.message", "Timeout", true},
+		{"thrown", `{"message":"Timeout"}`, true},
+		{"tags.0", "perf", true},
+		{"tags.1", "2", true},
+		{"tags", `["perf",2]`, true},
+		{"log.origin", "Server", true},
+		{"ms", "2003.5", true},
+		{"ok", "false", true},
+		{"extra", "", true},
+		{"thread", "", false},
+		{"tags.2", "", false},
+		{"level.name", "", false},
+	}
+	for _, tt := range tests {
+		value, found := fields.Get(tt.path)
+		assert.Equal(t, tt.expected, value, tt.path)
+		assert.Equal(t, tt.found, found, tt.path)
+	}
+
+	assert.Nil(t, lines.Parse(LineInfo{Instance: "lobby"}, "Starting minecraft server"))
+	assert.Nil(t, lines.Parse(LineInfo{Instance: "lobby"}, `{"level":"INFO"} trailing`))
+	assert.Nil(t, lines.Parse(LineInfo{Instance: "lobby", Stderr: true}, line), "stderr doesn't print JSON lines")
+	assert.Nil(t, lines.Parse(LineInfo{Instance: "survival"}, line), "survival doesn't print JSON lines")
+	assert.Nil(t, (*JsonLines)(nil).Parse(LineInfo{}, line))
+	assert.NotNil(t, (&JsonLines{}).Parse(LineInfo{Stderr: true}, "\x1b[33m"+line+"\x1b[0m"))
+}
+
+func TestRulesMatchJsonLines(t *testing.T) {
+	rules := Rules{SubprocessToDiscord: make([]Rule, 2), JsonLines: &JsonLines{}}
+	assert.NoError(t, rules.SubprocessToDiscord[0].Match.UnmarshalText([]byte(`^.*"level":"(WARN|ERROR)".*$`)))
+	rules.SubprocessToDiscord[0].Template = ":warning: **${json:level}** ${json:message} (${1}, ${json:thread})"
+	rules.SubprocessToDiscord[0].Player = "${json:player}"
+	assert.NoError(t, rules.SubprocessToDiscord[1].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.SubprocessToDiscord[1].Template = "raw: ${1}"
+
+	match := rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, `{"level":"WARN","message":"Costs $1","player":"Steve"}`, nil)
+	assert.Equal(t, ":warning: **WARN** Costs $1 (WARN, )", match.Result)
+	assert.Equal(t, "Steve", match.Player(`{"level":"WARN","message":"Costs $1","player":"Steve"}`))
+
+	// Lines that aren't JSON fall through to the rules matching the raw line.
+	match = rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, `"level":"WARN" printed as text`, nil)
+	assert.Equal(t, `raw: "level":"WARN" printed as text`, match.Result)
+
+	rules.JsonLines = nil
+	match = rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, `{"level":"WARN","message":"hi"}`, nil)
+	assert.Equal(t, `raw: {"level":"WARN","message":"hi"}`, match.Result)
+}
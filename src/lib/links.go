@@ -0,0 +1,250 @@
+package lib
+
+// This file implements linking Discord accounts to in-game names with /link,
+// adding entries to the user map: the links that were started and wait for
+// verification, the OAuth2 URL that verifies the Discord account, and the chat
+// lines that confirm links with codes typed in game.
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"dgbridge/src/ext"
+	"encoding/hex"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLinkTimeout is the time a started link stays valid, see
+// LinkConfig.Timeout.
+const DefaultLinkTimeout = 10 * time.Minute
+
+// linkCodeAlphabet are the characters of link codes, without ones that are
+// easily confused, e.g. O and 0.
+const linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// linkCodeLength is the number of characters of link codes.
+const linkCodeLength = 6
+
+// LinkConfig configures linking accounts with /link.
+type LinkConfig struct {
+	// Optional, template of a console command that whispers a code to the
+	// player, where ^P turns into the player, ^K into the code and ^^ into ^,
+	// e.g. "tell ^P Your Discord link code is ^K"; linking then also requires
+	// entering the code with /link code
+	ConfirmCommand string
+	// Optional, links names with OAuth2 alone, without a ConfirmCommand;
+	// anyone can then link any in-game name that isn't linked yet
+	Unconfirmed bool
+	Timeout     ext.Duration // Optional, see DefaultLinkTimeout
+	// Optional, enables linking without OAuth2: the player confirms the link
+	// by typing its code in the game chat
+	Verify *LinkVerification
+}
+
+// LinkVerification matches the chat lines of players, for confirming links
+// with codes typed in game.
+type LinkVerification struct {
+	Match  ext.Regexp `validate:"required"` // Chat line, e.g. ".*<(\\w+)> (.+)"
+	Player string     `validate:"required"` // Template of the player, e.g. "${1}"
+	Code   string     `validate:"required"` // Template of the typed text, e.g. "${2}"
+}
+
+// Parse parses a chat line.
+//
+// Returns:
+//
+//	the player and the typed text, and false if the line doesn't match
+func (v *LinkVerification) Parse(line string) (string, string, bool) {
+	line = StripAnsi(line)
+	indices := v.Match.FindStringSubmatchIndex(line)
+	if indices == nil {
+		return "", "", false
+	}
+	player := v.Match.ExpandString(nil, v.Player, line, indices)
+	code := v.Match.ExpandString(nil, v.Code, line, indices)
+	return strings.TrimSpace(string(player)), strings.TrimSpace(string(code)), true
+}
+
+// FormatConfirmCommand returns the console command whispering the code of a
+// link.
+func (c *LinkConfig) FormatConfirmCommand(link PendingLink) string {
+	return strings.TrimSpace(expandParameters(c.ConfirmCommand, map[byte]string{'P': link.Name, 'K': link.Code, '^': "^"}))
+}
+
+// LinkTimeout returns the time a started link stays valid.
+func (c *LinkConfig) LinkTimeout() time.Duration {
+	if c == nil || c.Timeout.Duration <= 0 {
+		return DefaultLinkTimeout
+	}
+	return c.Timeout.Duration
+}
+
+// PendingLink is a link of a Discord user to an in-game name that was started,
+// and waits for verification.
+type PendingLink struct {
+	UserId   string // Discord user that ran /link
+	Name     string // In-game name, see ValidWorkflowPlayer
+	State    string // Random state of the OAuth2 authorization, see DiscordAuthorizeUrl
+	Code     string // Random code the player confirms the link with
+	Verified bool   // Whether the Discord account was verified with OAuth2
+	Expires  time.Time
+}
+
+// PendingLinks are the started links, at most one per Discord user. It is safe
+// for concurrent use.
+type PendingLinks struct {
+	mutex sync.Mutex
+	ttl   time.Duration
+	links map[string]*PendingLink // By Discord user ID
+}
+
+// NewPendingLinks creates a PendingLinks.
+//
+// Parameters:
+//
+//	ttl: time a started link stays valid
+func NewPendingLinks(ttl time.Duration) *PendingLinks {
+	return &PendingLinks{ttl: ttl, links: make(map[string]*PendingLink)}
+}
+
+// Start starts a link, replacing a link the user started before.
+func (p *PendingLinks) Start(userId string, name string, now time.Time) PendingLink {
+	link := &PendingLink{
+		UserId:  userId,
+		Name:    name,
+		State:   randomHex(16),
+		Code:    randomLinkCode(),
+		Expires: now.Add(p.ttl),
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.prune(now)
+	p.links[userId] = link
+	return *link
+}
+
+// ByState returns the link of an OAuth2 state.
+func (p *PendingLinks) ByState(state string, now time.Time) (PendingLink, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.prune(now)
+	for _, link := range p.links {
+		if subtle.ConstantTimeCompare([]byte(link.State), []byte(state)) == 1 {
+			return *link, true
+		}
+	}
+	return PendingLink{}, false
+}
+
+// Verify marks a link as verified with OAuth2.
+//
+// Returns:
+//
+//	false if the link expired or was replaced
+func (p *PendingLinks) Verify(link PendingLink, now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.prune(now)
+	current, ok := p.links[link.UserId]
+	if !ok || current.State != link.State {
+		return false
+	}
+	current.Verified = true
+	return true
+}
+
+// Confirm completes a verified link of a user with its code, removing it.
+// Codes are compared case-insensitively.
+//
+// Returns:
+//
+//	the link, and false if the user has no verified link or the code is wrong
+func (p *PendingLinks) Confirm(userId string, code string, now time.Time) (PendingLink, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.prune(now)
+	link, ok := p.links[userId]
+	if !ok || !link.Verified {
+		return PendingLink{}, false
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(link.Code), []byte(code)) != 1 {
+		return PendingLink{}, false
+	}
+	delete(p.links, userId)
+	return *link, true
+}
+
+// ConfirmInGame completes the link of a player with the code the player typed
+// in game, removing it. Names and codes are compared case-insensitively.
+//
+// Returns:
+//
+//	the link, and false if the player has no link with the code
+func (p *PendingLinks) ConfirmInGame(player string, code string, now time.Time) (PendingLink, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.prune(now)
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for userId, link := range p.links {
+		if strings.EqualFold(link.Name, player) && subtle.ConstantTimeCompare([]byte(link.Code), []byte(code)) == 1 {
+			delete(p.links, userId)
+			return *link, true
+		}
+	}
+	return PendingLink{}, false
+}
+
+// Remove removes the link of a user.
+func (p *PendingLinks) Remove(userId string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.links, userId)
+}
+
+// prune removes expired links. The caller holds the mutex.
+func (p *PendingLinks) prune(now time.Time) {
+	for userId, link := range p.links {
+		if !now.Before(link.Expires) {
+			delete(p.links, userId)
+		}
+	}
+}
+
+// DiscordAuthorizeUrl returns the URL of Discord's OAuth2 authorization page,
+// asking for the "identify" scope.
+//
+// Parameters:
+//
+//	clientId: ID of the bot's application
+//	redirectUrl: URL Discord redirects to with the authorization code
+//	state: state of the link, see PendingLink.State
+func DiscordAuthorizeUrl(clientId string, redirectUrl string, state string) string {
+	query := url.Values{
+		"client_id":     {clientId},
+		"redirect_uri":  {redirectUrl},
+		"response_type": {"code"},
+		"scope":         {"identify"},
+		"state":         {state},
+		"prompt":        {"none"},
+	}
+	return "https://discord.com/oauth2/authorize?" + query.Encode()
+}
+
+func randomHex(n int) string {
+	bytes := make([]byte, n)
+	_, _ = rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+func randomLinkCode() string {
+	code := make([]byte, linkCodeLength)
+	for i := range code {
+		index, _ := rand.Int(rand.Reader, big.NewInt(int64(len(linkCodeAlphabet))))
+		code[i] = linkCodeAlphabet[index.Int64()]
+	}
+	return string(code)
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingLinks(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	links := NewPendingLinks(10 * time.Minute)
+
+	link := links.Start("1", "Steve", start)
+	assert.Equal(t, "Steve", link.Name)
+	assert.Len(t, link.State, 32)
+	assert.Len(t, link.Code, linkCodeLength)
+
+	found, ok := links.ByState(link.State, start.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, link, found)
+	_, ok = links.ByState("other", start)
+	assert.False(t, ok)
+
+	// Links must be verified before they are confirmed
+	_, ok = links.Confirm("1", link.Code, start.Add(time.Minute))
+	assert.False(t, ok)
+	assert.True(t, links.Verify(link, start.Add(time.Minute)))
+	_, ok = links.Confirm("1", "WRONG1", start.Add(time.Minute))
+	assert.False(t, ok)
+	confirmed, ok := links.Confirm("1", " "+strings.ToLower(link.Code)+" ", start.Add(2*time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, "Steve", confirmed.Name)
+	_, ok = links.Confirm("1", link.Code, start.Add(2*time.Minute))
+	assert.False(t, ok, "links are confirmed once")
+
+	// Starting again replaces the user's link
+	first := links.Start("2", "Alex", start)
+	second := links.Start("2", "Alex2", start)
+	assert.False(t, links.Verify(first, start))
+	assert.True(t, links.Verify(second, start))
+
+	// Expired links are removed
+	_, ok = links.ByState(second.State, start.Add(10*time.Minute))
+	assert.False(t, ok)
+}
+
+func TestPendingLinks_ConfirmInGame(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	links := NewPendingLinks(10 * time.Minute)
+	link := links.Start("1", "Steve", start)
+
+	_, ok := links.ConfirmInGame("Alex", link.Code, start)
+	assert.False(t, ok, "other players can't confirm the link")
+	_, ok = links.ConfirmInGame("Steve", "WRONG1", start)
+	assert.False(t, ok)
+	confirmed, ok := links.ConfirmInGame("steve", strings.ToLower(link.Code), start.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, "1", confirmed.UserId)
+	_, ok = links.ConfirmInGame("Steve", link.Code, start.Add(time.Minute))
+	assert.False(t, ok, "links are confirmed once")
+
+	link = links.Start("2", "Alex", start)
+	_, ok = links.ConfirmInGame("Alex", link.Code, start.Add(10*time.Minute))
+	assert.False(t, ok, "expired links can't be confirmed")
+}
+
+func TestLinkVerification_Parse(t *testing.T) {
+	verify := &LinkVerification{
+		Match:  ext.Regexp{Regexp: regexp.MustCompile(`<(\w+)> (.+)`)},
+		Player: "${1}",
+		Code:   "${2}",
+	}
+	tests := []struct {
+		line   string
+		player string
+		code   string
+		ok     bool
+	}{
+		{"[12:00:00] [Server thread/INFO]: <Steve> ABC234 ", "Steve", "ABC234", true},
+		{"\x1b[32m<Steve> ABC234\x1b[0m", "Steve", "ABC234", true},
+		{"[12:00:00] [Server thread/INFO]: Steve joined the game", "", "", false},
+	}
+	for i, test := range tests {
+		player, code, ok := verify.Parse(test.line)
+		assert.Equal(t, test.ok, ok, "Test #%v", i)
+		assert.Equal(t, test.player, player, "Test #%v", i)
+		assert.Equal(t, test.code, code, "Test #%v", i)
+	}
+}
+
+func TestLinkConfig(t *testing.T) {
+	config := &LinkConfig{ConfirmCommand: "tell ^P Your link code is ^K ^^"}
+	assert.Equal(t, "tell Steve Your link code is ABC234 ^", config.FormatConfirmCommand(PendingLink{Name: "Steve", Code: "ABC234"}))
+	assert.Equal(t, DefaultLinkTimeout, config.LinkTimeout())
+	assert.Equal(t, DefaultLinkTimeout, (*LinkConfig)(nil).LinkTimeout())
+}
+
+func TestDiscordAuthorizeUrl(t *testing.T) {
+	parsed, err := url.Parse(DiscordAuthorizeUrl("42", "https://bridge.example.com/link/callback", "abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, "discord.com", parsed.Host)
+	query := parsed.Query()
+	assert.Equal(t, "42", query.Get("client_id"))
+	assert.Equal(t, "https://bridge.example.com/link/callback", query.Get("redirect_uri"))
+	assert.Equal(t, "identify", query.Get("scope"))
+	assert.Equal(t, "abc", query.Get("state"))
+}
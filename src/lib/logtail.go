@@ -0,0 +1,102 @@
+package lib
+
+// This file implements following a container's log file, like tail -F, see
+// ContainerLogParser: finding the newest of the log files matching a pattern,
+// and reading the lines appended to it.
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// NewestMatch returns the most recently modified file matching a glob
+// pattern, or "" if there is none.
+func NewestMatch(pattern string) (string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", err
+	}
+	newest := ""
+	var newestTime time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest, newestTime = path, info.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// LogTail reads the lines appended to a log file, parsed with a
+// ContainerLogParser. A line whose newline wasn't written yet is read once it
+// is complete, and a truncated file is read from the start.
+type LogTail struct {
+	file    io.ReadSeeker
+	reader  *bufio.Reader
+	parser  ContainerLogParser
+	offset  int64  // Offset in the file of the end of what was read
+	pending string // Start of a line whose newline wasn't written yet
+}
+
+// NewLogTail creates a LogTail of an opened file.
+//
+// Parameters:
+//
+//	fromEnd: whether lines written before are skipped, otherwise the file is
+//		read from the start
+func NewLogTail(file io.ReadSeeker, fromEnd bool) *LogTail {
+	tail := &LogTail{file: file, reader: bufio.NewReader(file)}
+	if fromEnd {
+		tail.offset, _ = file.Seek(0, io.SeekEnd)
+	}
+	return tail
+}
+
+// Read reads the lines appended since the last read.
+//
+// Parameters:
+//
+//	size: the current size of the file; if it shrunk below what was read, the
+//		file was truncated and is read from the start
+//
+// Returns:
+//
+//	the complete lines, and true if the file was truncated
+func (t *LogTail) Read(size int64) ([]ContainerLogLine, bool) {
+	truncated := size < t.offset
+	if truncated {
+		t.offset, _ = t.file.Seek(0, io.SeekStart)
+		t.pending = ""
+		t.reader.Reset(t.file)
+	}
+	var lines []ContainerLogLine
+	for {
+		chunk, err := t.reader.ReadString('\n')
+		t.offset += int64(len(chunk))
+		if err != nil {
+			t.pending += chunk
+			return lines, truncated
+		}
+		line, complete := t.parser.Parse(trimNewline(t.pending + chunk))
+		t.pending = ""
+		if complete {
+			lines = append(lines, line)
+		}
+	}
+}
+
+// trimNewline removes the newline at the end of a line, and the carriage
+// return before it.
+func trimNewline(line string) string {
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewestMatch(t *testing.T) {
+	dir := t.TempDir()
+	newest, err := NewestMatch(filepath.Join(dir, "*.log"))
+	assert.NoError(t, err)
+	assert.Empty(t, newest)
+
+	now := time.Now()
+	for i, name := range []string{"0.log", "1.log", "2.log.gz"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, nil, 0o644), "Test #%v", i)
+		assert.NoError(t, os.Chtimes(path, now, now.Add(time.Duration(i)*time.Minute)), "Test #%v", i)
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "3.log"), 0o755))
+	newest, err = NewestMatch(filepath.Join(dir, "*.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "1.log"), newest)
+
+	_, err = NewestMatch("[")
+	assert.Error(t, err)
+}
+
+func TestTrimNewline(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"hi\n", "hi"},
+		{"hi\r\n", "hi"},
+		{"\n", ""},
+		{"\r\n", ""},
+		{"hi\r\r\n", "hi\r"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, trimNewline(test.line), "Test #%v", i)
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "0.log")
+	assert.NoError(t, os.WriteFile(path, []byte("before\n"), 0o644))
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	appendLog := func(text string) int64 {
+		t.Helper()
+		log, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		assert.NoError(t, err)
+		_, err = log.WriteString(text)
+		assert.NoError(t, err)
+		assert.NoError(t, log.Close())
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		return info.Size()
+	}
+
+	// Lines written before are skipped.
+	tail := NewLogTail(file, true)
+	lines, truncated := tail.Read(appendLog("2024-05-01T12:00:00Z stdout F first\r\n2024-05-01T12:00:00Z stderr F sec"))
+	assert.False(t, truncated)
+	assert.Equal(t, []string{"first"}, logTexts(lines))
+
+	// A partial line is read once its newline was written.
+	lines, _ = tail.Read(appendLog("ond\n"))
+	assert.Equal(t, []string{"second"}, logTexts(lines))
+	assert.True(t, lines[0].Stderr)
+	lines, _ = tail.Read(appendLog(""))
+	assert.Empty(t, lines)
+
+	assert.NoError(t, os.Truncate(path, 0))
+	lines, truncated = tail.Read(appendLog("after\n"))
+	assert.True(t, truncated)
+	assert.Equal(t, []string{"after"}, logTexts(lines))
+
+	// Files found later are read from the start.
+	_, err = file.Seek(0, 0)
+	assert.NoError(t, err)
+	lines, _ = NewLogTail(file, false).Read(appendLog(""))
+	assert.Equal(t, []string{"after"}, logTexts(lines))
+}
+
+// logTexts returns the texts of log lines.
+func logTexts(lines []ContainerLogLine) []string {
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	return texts
+}
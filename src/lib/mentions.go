@@ -0,0 +1,47 @@
+package lib
+
+import "regexp"
+
+// contentMarkupRegex matches the markup of mentions and custom emoji in Discord
+// messages: <@ID> and <@!ID> (users), <@&ID> (roles), <#ID> (channels), and
+// <:name:ID> or <a:name:ID> (custom emoji).
+var contentMarkupRegex = regexp.MustCompile(`<(@!?|@&|#)(\d+)>|<a?:(\w+):\d+>`)
+
+// ContentNames looks up the names that NormalizeContent inserts. A function
+// returns "" if the name is unknown; nil functions know no names.
+type ContentNames struct {
+	User    func(id string) string // Display name of a user
+	Role    func(id string) string
+	Channel func(id string) string
+}
+
+// NormalizeContent replaces the markup of mentions and custom emoji in a
+// Discord message with readable names, e.g. "<@123> <#456> <:wave:789>" with
+// "@Alice #general :wave:", so that rules match, and in-game players read, what
+// Discord users see. Mentions of unknown names are left unchanged.
+func NormalizeContent(content string, names ContentNames) string {
+	return contentMarkupRegex.ReplaceAllStringFunc(content, func(markup string) string {
+		groups := contentMarkupRegex.FindStringSubmatch(markup)
+		kind, id, emoji := groups[1], groups[2], groups[3]
+		if emoji != "" {
+			return ":" + emoji + ":"
+		}
+		var lookup func(string) string
+		prefix := "@"
+		switch kind {
+		case "@", "@!":
+			lookup = names.User
+		case "@&":
+			lookup = names.Role
+		case "#":
+			lookup, prefix = names.Channel, "#"
+		}
+		if lookup == nil {
+			return markup
+		}
+		if name := lookup(id); name != "" {
+			return prefix + name
+		}
+		return markup
+	})
+}
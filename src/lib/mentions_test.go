@@ -0,0 +1,35 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeContent(t *testing.T) {
+	lookup := func(names map[string]string) func(string) string {
+		return func(id string) string {
+			return names[id]
+		}
+	}
+	names := ContentNames{
+		User:    lookup(map[string]string{"123456789012345678": "Alice"}),
+		Role:    lookup(map[string]string{"234567890123456789": "Moderators"}),
+		Channel: lookup(map[string]string{"345678901234567890": "general"}),
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"hi <@123456789012345678>", "hi @Alice"},
+		{"hi <@!123456789012345678>", "hi @Alice"},
+		{"ask <@&234567890123456789> in <#345678901234567890>", "ask @Moderators in #general"},
+		{"<:wave:456789012345678901> <a:dance:456789012345678902>", ":wave: :dance:"},
+		{"hi <@999999999999999999>", "hi <@999999999999999999>"},
+		{"no markup <3 <b>", "no markup <3 <b>"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, NormalizeContent(test.input, names), "Test #%v", i)
+	}
+	assert.Equal(t, "<@123456789012345678> :wave:", NormalizeContent("<@123456789012345678> <:wave:1>", ContentNames{}))
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentRulesVersion is the Version of rules files written by this version of
+// dgbridge. Files without a Version have version 0.
+const CurrentRulesVersion = 1
+
+// rulesMigrations upgrade rules files; rulesMigrations[i] upgrades a file
+// from version i to version i+1. The Version field is updated by MigrateRules.
+var rulesMigrations = []func(rules *jsonObject) error{
+	// Version 1 introduced the Version field.
+	func(rules *jsonObject) error { return nil },
+}
+
+// MigrateRules upgrades the contents of a rules file to CurrentRulesVersion.
+// The order of the top-level fields is kept, so that migrated files can be
+// written back without rearranging them.
+//
+// Returns:
+//
+//	the migrated contents, and the version of the original contents
+func MigrateRules(contents []byte) ([]byte, int, error) {
+	var rules jsonObject
+	if err := json.Unmarshal(contents, &rules); err != nil {
+		return nil, 0, err
+	}
+	version := 0
+	if raw, ok := rules.get("Version"); ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid Version: %v", err)
+		}
+	}
+	if version > CurrentRulesVersion {
+		return nil, version, fmt.Errorf("rules file version %v is newer than the supported version %v, please update dgbridge",
+			version, CurrentRulesVersion)
+	}
+	if version == CurrentRulesVersion {
+		return contents, version, nil
+	}
+	for v := version; v < CurrentRulesVersion; v++ {
+		if err := rulesMigrations[v](&rules); err != nil {
+			return nil, version, fmt.Errorf("error migrating rules from version %v to %v: %v", v, v+1, err)
+		}
+	}
+	rules.set("Version", json.RawMessage(fmt.Sprint(CurrentRulesVersion)), true)
+	migrated, err := rules.marshalIndent()
+	if err != nil {
+		return nil, version, err
+	}
+	return migrated, version, nil
+}
+
+// jsonObject is a JSON object that keeps the order of its fields. Field values
+// are kept as they are.
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+func (o *jsonObject) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("expected a JSON object")
+	}
+	o.keys = nil
+	o.values = make(map[string]json.RawMessage)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		o.set(key, value, false)
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+func (o *jsonObject) get(key string) (json.RawMessage, bool) {
+	value, ok := o.values[key]
+	return value, ok
+}
+
+// set sets a field, adding it at the start or the end of the object if it's
+// new.
+func (o *jsonObject) set(key string, value json.RawMessage, first bool) {
+	if _, ok := o.values[key]; !ok {
+		if first {
+			o.keys = append([]string{key}, o.keys...)
+		} else {
+			o.keys = append(o.keys, key)
+		}
+	}
+	o.values[key] = value
+}
+
+// marshalIndent encodes the object with two-space indentation.
+func (o *jsonObject) marshalIndent() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("{\n")
+	for i, key := range o.keys {
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteString("  ")
+		buffer.Write(encodedKey)
+		buffer.WriteString(": ")
+		if err := json.Indent(&buffer, o.values[key], "  ", "  "); err != nil {
+			return nil, err
+		}
+		if i < len(o.keys)-1 {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("\n")
+	}
+	buffer.WriteString("}\n")
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateRules(t *testing.T) {
+	original := `{"SubprocessToDiscord": [{"Match": "a", "Template": "b"}], "DiscordToSubprocess": []}`
+	migrated, version, err := MigrateRules([]byte(original))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+	assert.Equal(t, `{
+  "Version": 1,
+  "SubprocessToDiscord": [
+    {
+      "Match": "a",
+      "Template": "b"
+    }
+  ],
+  "DiscordToSubprocess": []
+}
+`, string(migrated))
+
+	// Migrating again changes nothing.
+	again, version, err := MigrateRules(migrated)
+	assert.NoError(t, err)
+	assert.Equal(t, CurrentRulesVersion, version)
+	assert.Equal(t, string(migrated), string(again))
+
+	_, _, err = MigrateRules([]byte(`{"Version": 99}`))
+	assert.Error(t, err)
+	_, _, err = MigrateRules([]byte(`[]`))
+	assert.Error(t, err)
+}
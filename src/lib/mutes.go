@@ -0,0 +1,130 @@
+package lib
+
+// This file implements bridge mutes: Discord users whose messages aren't
+// relayed to the subprocess, see /bridgemute, while they may still talk in
+// Discord. Mutes are saved to a JSON file of the time each mute ends by user
+// ID, where the zero time mutes a user until unmuted.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MuteList is the list of muted Discord users, saved to a file. It is safe for
+// concurrent use.
+type MuteList struct {
+	path  string
+	mutex sync.RWMutex
+	mutes map[string]time.Time // End of the mute by user ID, zero if it doesn't end
+}
+
+// LoadMuteList loads a mute file. A missing file is an empty list, and is
+// created once a user is muted.
+func LoadMuteList(path string) (*MuteList, error) {
+	list := &MuteList{path: path, mutes: make(map[string]time.Time)}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &list.mutes); err != nil {
+		return nil, fmt.Errorf("invalid mute file: %v", err)
+	}
+	if list.mutes == nil {
+		list.mutes = make(map[string]time.Time)
+	}
+	return list, nil
+}
+
+// IsMuted reports whether a user is muted.
+func (l *MuteList) IsMuted(userId string, now time.Time) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	until, ok := l.mutes[userId]
+	return ok && (until.IsZero() || now.Before(until))
+}
+
+// Mute mutes a user and saves the file, replacing an earlier mute of the user.
+//
+// Parameters:
+//
+//	until: end of the mute, zero to mute the user until unmuted
+func (l *MuteList) Mute(userId string, until time.Time, now time.Time) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	mutes := l.active(now)
+	mutes[userId] = until.UTC()
+	return l.save(mutes)
+}
+
+// Unmute unmutes a user and saves the file.
+//
+// Returns:
+//
+//	false if the user isn't muted
+func (l *MuteList) Unmute(userId string, now time.Time) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	mutes := l.active(now)
+	if _, ok := mutes[userId]; !ok {
+		return false, nil
+	}
+	delete(mutes, userId)
+	return true, l.save(mutes)
+}
+
+// Mutes returns the active mutes, the end of each by user ID.
+func (l *MuteList) Mutes(now time.Time) map[string]time.Time {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.active(now)
+}
+
+// active returns a copy of the mutes that haven't ended. The caller holds the
+// mutex.
+func (l *MuteList) active(now time.Time) map[string]time.Time {
+	mutes := maps.Clone(l.mutes)
+	for userId, until := range mutes {
+		if !until.IsZero() && !now.Before(until) {
+			delete(mutes, userId)
+		}
+	}
+	return mutes
+}
+
+// save saves mutes, then uses them. The caller holds the mutex.
+func (l *MuteList) save(mutes map[string]time.Time) error {
+	contents, err := json.MarshalIndent(mutes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(l.path, append(contents, '\n')); err != nil {
+		return fmt.Errorf("error saving mute file: %v", err)
+	}
+	l.mutes = mutes
+	return nil
+}
+
+// ParseMuteDuration parses the duration of a mute: a Go duration, e.g. "90m",
+// or a number of days, e.g. "7d".
+func ParseMuteDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+		return duration, nil
+	}
+	return 0, fmt.Errorf("invalid duration %q, expected e.g. 30m, 12h or 7d", value)
+}
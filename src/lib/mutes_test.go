@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMuteList(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "mutes.json")
+	list, err := LoadMuteList(path)
+	assert.NoError(t, err)
+	assert.False(t, list.IsMuted("1", now))
+
+	assert.NoError(t, list.Mute("1", now.Add(time.Hour), now))
+	assert.NoError(t, list.Mute("2", time.Time{}, now))
+	assert.True(t, list.IsMuted("1", now.Add(59*time.Minute)))
+	assert.False(t, list.IsMuted("1", now.Add(time.Hour)), "mutes end")
+	assert.True(t, list.IsMuted("2", now.Add(1000*time.Hour)), "mutes without an end last until unmuted")
+
+	// The mutes are saved
+	loaded, err := LoadMuteList(path)
+	assert.NoError(t, err)
+	assert.True(t, loaded.IsMuted("1", now))
+	assert.True(t, loaded.IsMuted("2", now))
+
+	unmuted, err := list.Unmute("2", now)
+	assert.NoError(t, err)
+	assert.True(t, unmuted)
+	unmuted, err = list.Unmute("2", now)
+	assert.NoError(t, err)
+	assert.False(t, unmuted)
+	unmuted, err = list.Unmute("1", now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, unmuted, "the mute ended")
+	assert.Len(t, list.Mutes(now), 1)
+	assert.Empty(t, list.Mutes(now.Add(2*time.Hour)))
+
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err = LoadMuteList(path)
+	assert.Error(t, err)
+}
+
+func TestParseMuteDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		isError  bool
+	}{
+		{"30m", 30 * time.Minute, false},
+		{" 12h ", 12 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"0d", 0, true},
+		{"-1h", 0, true},
+		{"soon", 0, true},
+	}
+	for i, test := range tests {
+		duration, err := ParseMuteDuration(test.value)
+		assert.Equal(t, test.isError, err != nil, "Test #%v", i)
+		assert.Equal(t, test.expected, duration, "Test #%v", i)
+	}
+}
@@ -0,0 +1,295 @@
+package lib
+
+// This file implements parsing log messages received over the network: GELF
+// (Graylog Extended Log Format) and syslog messages, or plain lines.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GelfChunkTimeout is the time all chunks of a chunked GELF message must be
+// received in. Incomplete messages are dropped after it.
+const GelfChunkTimeout = 5 * time.Second
+
+// gelfMaxChunks is the maximum number of chunks of a GELF message, as set by
+// the GELF specification.
+const gelfMaxChunks = 128
+
+// gelfMaxMessages is the maximum number of incomplete chunked GELF messages
+// kept at once, so that chunks of messages that are never completed can't
+// exhaust the memory. The oldest message is dropped for a new one.
+const gelfMaxMessages = 256
+
+// gelfMaxPendingSize is the maximum total size of the chunks of incomplete GELF
+// messages. The oldest messages are dropped for new chunks.
+const gelfMaxPendingSize = 16 * 1024 * 1024
+
+// networkLogMaxSize is the maximum size of a decompressed message, so that a
+// small compressed message can't exhaust the memory.
+const networkLogMaxSize = 1024 * 1024
+
+// syslogSeverityError is the syslog severity of errors. Messages of this
+// severity or a more severe one (a lower number) are errors.
+const syslogSeverityError = 3
+
+var (
+	gelfChunkMagic  = []byte{0x1e, 0x0f}
+	gzipMagic       = []byte{0x1f, 0x8b}
+	syslogPriExpr   = regexp.MustCompile(`^<(\d{1,3})>`)
+	syslogStampExpr = regexp.MustCompile(`^[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d `)
+	syslogTagExpr   = regexp.MustCompile(`^[^\s:\[]+(\[\d+])?: ?`)
+)
+
+// NetworkLine is a line of a log message received over the network.
+type NetworkLine struct {
+	Host  string // Host that sent the message, empty if unknown
+	Text  string
+	Error bool // Whether the message has the error severity or a more severe one
+}
+
+// gelfMessage is the part of a GELF message that is relayed.
+type gelfMessage struct {
+	Host         string `json:"host"`
+	ShortMessage string `json:"short_message"`
+	Level        *int   `json:"level"`
+}
+
+// ParseNetworkLog parses a log message received over the network. It can be a
+// GELF message, compressed or not, a syslog message (RFC 3164 or RFC 5424), or
+// plain text. Chunked GELF messages must be assembled with a GelfAssembler
+// first.
+//
+// Returns:
+//
+//	the lines of the message; a message of several lines is split
+func ParseNetworkLog(message []byte) ([]NetworkLine, error) {
+	message, err := decompress(message)
+	if err != nil {
+		return nil, err
+	}
+	if len(message) > 0 && message[0] == '{' {
+		var gelf gelfMessage
+		if err := json.Unmarshal(message, &gelf); err != nil {
+			return nil, fmt.Errorf("error decoding GELF message: %v", err)
+		}
+		// The specification defaults the level to alert, but most senders
+		// omit it for ordinary messages.
+		isError := gelf.Level != nil && *gelf.Level <= syslogSeverityError
+		return splitNetworkLines(gelf.Host, gelf.ShortMessage, isError), nil
+	}
+	host, text, isError := parseSyslog(string(message))
+	return splitNetworkLines(host, text, isError), nil
+}
+
+// decompress decompresses a zlib or gzip compressed message. Other messages are
+// returned as they are.
+func decompress(message []byte) ([]byte, error) {
+	var reader io.Reader
+	var err error
+	switch {
+	case bytes.HasPrefix(message, gzipMagic):
+		reader, err = gzip.NewReader(bytes.NewReader(message))
+	case len(message) >= 2 && message[0] == 0x78 && (uint16(message[0])<<8|uint16(message[1]))%31 == 0:
+		// A zlib header: deflate compression, with a check value.
+		reader, err = zlib.NewReader(bytes.NewReader(message))
+	default:
+		return message, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing message: %v", err)
+	}
+	decompressed, err := io.ReadAll(io.LimitReader(reader, networkLogMaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing message: %v", err)
+	}
+	return decompressed, nil
+}
+
+// parseSyslog parses a syslog message. A message without a priority is plain
+// text.
+//
+// Returns:
+//
+//	the host, the text of the message, and whether it is an error
+func parseSyslog(message string) (string, string, bool) {
+	message = strings.TrimRight(message, "\x00\r\n")
+	match := syslogPriExpr.FindStringSubmatch(message)
+	if match == nil {
+		return "", message, false
+	}
+	pri, _ := strconv.Atoi(match[1])
+	isError := pri%8 <= syslogSeverityError
+	message = message[len(match[0]):]
+
+	if strings.HasPrefix(message, "1 ") {
+		// RFC 5424: VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+		fields := strings.SplitN(message, " ", 7)
+		if len(fields) < 7 {
+			return "", message, isError
+		}
+		host := fields[2]
+		if host == "-" {
+			host = ""
+		}
+		text := skipStructuredData(fields[6])
+		return host, strings.TrimPrefix(text, "\ufeff"), isError
+	}
+
+	// RFC 3164: TIMESTAMP HOSTNAME TAG: MSG
+	host := ""
+	if stamp := syslogStampExpr.FindString(message); stamp != "" {
+		message = message[len(stamp):]
+		if i := strings.IndexByte(message, ' '); i >= 0 {
+			host, message = message[:i], message[i+1:]
+		}
+	}
+	return host, syslogTagExpr.ReplaceAllString(message, ""), isError
+}
+
+// skipStructuredData returns the message after the structured data of an RFC
+// 5424 syslog message.
+func skipStructuredData(message string) string {
+	if strings.HasPrefix(message, "-") {
+		return strings.TrimPrefix(message[1:], " ")
+	}
+	for strings.HasPrefix(message, "[") {
+		// Find the end of the element; "]" may be escaped in values.
+		end := -1
+		for i := 1; i < len(message); i++ {
+			if message[i] == '\\' {
+				i++
+			} else if message[i] == ']' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return ""
+		}
+		message = message[end+1:]
+	}
+	return strings.TrimPrefix(message, " ")
+}
+
+// splitNetworkLines splits the text of a message into lines.
+func splitNetworkLines(host string, text string, isError bool) []NetworkLine {
+	var lines []NetworkLine
+	for _, line := range strings.Split(strings.TrimRight(text, "\r\n"), "\n") {
+		lines = append(lines, NetworkLine{Host: host, Text: strings.TrimSuffix(line, "\r"), Error: isError})
+	}
+	return lines
+}
+
+// GelfAssembler assembles chunked GELF messages. It keeps at most
+// gelfMaxMessages incomplete messages of gelfMaxPendingSize in total. It is not
+// safe for concurrent use.
+type GelfAssembler struct {
+	messages map[string]*gelfChunks
+	pending  int // Total size of the chunks in messages
+}
+
+// gelfChunks are the chunks of a message received so far.
+type gelfChunks struct {
+	first    time.Time // When the first chunk was received
+	chunks   [][]byte
+	received int
+	size     int // Total size of the chunks received
+}
+
+// NewGelfAssembler creates a GelfAssembler.
+func NewGelfAssembler() *GelfAssembler {
+	return &GelfAssembler{messages: make(map[string]*gelfChunks)}
+}
+
+// Add adds a received packet. Packets that aren't GELF chunks are complete
+// messages.
+//
+// Returns:
+//
+//	the message, and whether it is complete; false while chunks are missing
+//	an error if the packet is an invalid chunk
+func (a *GelfAssembler) Add(packet []byte, now time.Time) ([]byte, bool, error) {
+	for id, message := range a.messages {
+		if now.Sub(message.first) > GelfChunkTimeout {
+			a.drop(id)
+		}
+	}
+	if !bytes.HasPrefix(packet, gelfChunkMagic) {
+		return packet, true, nil
+	}
+	// Magic bytes, message ID, sequence number and sequence count.
+	if len(packet) < 12 {
+		return nil, false, fmt.Errorf("truncated GELF chunk")
+	}
+	id, sequence, count := string(packet[2:10]), int(packet[10]), int(packet[11])
+	if count == 0 || count > gelfMaxChunks || sequence >= count {
+		return nil, false, fmt.Errorf("invalid GELF chunk %v of %v", sequence, count)
+	}
+	message, ok := a.messages[id]
+	if !ok {
+		if len(a.messages) >= gelfMaxMessages {
+			a.dropOldest()
+		}
+		message = &gelfChunks{first: now, chunks: make([][]byte, count)}
+		a.messages[id] = message
+	}
+	if len(message.chunks) != count {
+		return nil, false, fmt.Errorf("GELF chunk count changed from %v to %v", len(message.chunks), count)
+	}
+	if message.chunks[sequence] == nil {
+		chunk := packet[12:]
+		if message.size+len(chunk) > networkLogMaxSize {
+			a.drop(id)
+			return nil, false, fmt.Errorf("GELF message larger than %v bytes", networkLogMaxSize)
+		}
+		for a.pending+len(chunk) > gelfMaxPendingSize && len(a.messages) > 1 {
+			a.dropOldest(id)
+		}
+		message.chunks[sequence] = bytes.Clone(chunk)
+		message.received++
+		message.size += len(chunk)
+		a.pending += len(chunk)
+	}
+	if message.received < count {
+		return nil, false, nil
+	}
+	a.drop(id)
+	return bytes.Join(message.chunks, nil), true, nil
+}
+
+// drop drops an incomplete message.
+func (a *GelfAssembler) drop(id string) {
+	a.pending -= a.messages[id].size
+	delete(a.messages, id)
+}
+
+// dropOldest drops the incomplete message whose first chunk was received
+// first.
+//
+// Parameters:
+//
+//	keep: IDs of messages that aren't dropped
+func (a *GelfAssembler) dropOldest(keep ...string) {
+	oldest := ""
+	for id, message := range a.messages {
+		if slices.Contains(keep, id) {
+			continue
+		}
+		if oldest == "" || message.first.Before(a.messages[oldest].first) {
+			oldest = id
+		}
+	}
+	if oldest != "" {
+		a.drop(oldest)
+	}
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNetworkLog(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected []NetworkLine
+	}{
+		{"<Bob> hi\n", []NetworkLine{{Text: "<Bob> hi"}}},
+		{"a\r\nb", []NetworkLine{{Text: "a"}, {Text: "b"}}},
+		// GELF
+		{`{"version":"1.1","host":"lobby","short_message":"<Bob> hi"}`, []NetworkLine{{Host: "lobby", Text: "<Bob> hi"}}},
+		{`{"host":"lobby","short_message":"Crash","level":2}`, []NetworkLine{{Host: "lobby", Text: "Crash", Error: true}}},
+		{`{"host":"lobby","short_message":"Lag","level":4}`, []NetworkLine{{Host: "lobby", Text: "Lag"}}},
+		// RFC 3164
+		{"<14>Oct 14 18:19:09 world minecraft[42]: <Bob> hi", []NetworkLine{{Host: "world", Text: "<Bob> hi"}}},
+		{"<11>Oct  4 08:00:00 world server: Crash", []NetworkLine{{Host: "world", Text: "Crash", Error: true}}},
+		{"<13>no timestamp", []NetworkLine{{Text: "no timestamp"}}},
+		// RFC 5424
+		{"<14>1 2026-10-14T18:19:09Z world minecraft 42 - - <Bob> hi", []NetworkLine{{Host: "world", Text: "<Bob> hi"}}},
+		{`<14>1 - - - - - [id a="\]"][id2 b="c"] ` + "\ufeff<Bob> hi", []NetworkLine{{Text: "<Bob> hi"}}},
+	}
+	for i, test := range tests {
+		lines, err := ParseNetworkLog([]byte(test.message))
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Equal(t, test.expected, lines, "Test #%v", i)
+	}
+	_, err := ParseNetworkLog([]byte("{not json"))
+	assert.Error(t, err)
+}
+
+func TestParseNetworkLogCompressed(t *testing.T) {
+	message := []byte(`{"host":"lobby","short_message":"<Bob> hi"}`)
+	expected := []NetworkLine{{Host: "lobby", Text: "<Bob> hi"}}
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, _ = writer.Write(message)
+	_ = writer.Close()
+	lines, err := ParseNetworkLog(compressed.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, lines)
+
+	compressed.Reset()
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, _ = gzipWriter.Write(message)
+	_ = gzipWriter.Close()
+	lines, err = ParseNetworkLog(compressed.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, lines)
+}
+
+func gelfChunk(id byte, sequence byte, count byte, payload string) []byte {
+	return append([]byte{0x1e, 0x0f, id, 0, 0, 0, 0, 0, 0, 0, sequence, count}, payload...)
+}
+
+func TestGelfAssembler(t *testing.T) {
+	assembler := NewGelfAssembler()
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	message, complete, err := assembler.Add([]byte("plain"), now)
+	assert.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []byte("plain"), message)
+
+	// Chunks may arrive out of order, and interleaved with other messages.
+	_, complete, _ = assembler.Add(gelfChunk(1, 1, 2, "world"), now)
+	assert.False(t, complete)
+	_, complete, _ = assembler.Add(gelfChunk(2, 0, 2, "other"), now)
+	assert.False(t, complete)
+	message, complete, err = assembler.Add(gelfChunk(1, 0, 2, "hello "), now)
+	assert.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []byte("hello world"), message)
+
+	// Incomplete messages expire.
+	_, complete, _ = assembler.Add(gelfChunk(2, 1, 2, "!"), now.Add(GelfChunkTimeout+time.Second))
+	assert.False(t, complete)
+
+	_, _, err = assembler.Add(gelfChunk(3, 2, 2, ""), now)
+	assert.Error(t, err)
+	_, _, err = assembler.Add([]byte{0x1e, 0x0f, 1}, now)
+	assert.Error(t, err)
+}
+
+// gelfChunkOf returns a chunk of a message with a numeric ID.
+func gelfChunkOf(id int, sequence byte, count byte, payload []byte) []byte {
+	chunk := []byte{0x1e, 0x0f, byte(id), byte(id >> 8), 0, 0, 0, 0, 0, 0, sequence, count}
+	return append(chunk, payload...)
+}
+
+func TestGelfAssemblerLimits(t *testing.T) {
+	assembler := NewGelfAssembler()
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	// The oldest incomplete message is dropped for a new one.
+	for id := 0; id <= gelfMaxMessages; id++ {
+		_, complete, err := assembler.Add(gelfChunkOf(id, 0, 2, []byte("a")), now.Add(time.Duration(id)))
+		assert.NoError(t, err)
+		assert.False(t, complete)
+	}
+	assert.Len(t, assembler.messages, gelfMaxMessages)
+	_, complete, _ := assembler.Add(gelfChunkOf(0, 1, 2, []byte("b")), now)
+	assert.False(t, complete)
+	message, complete, _ := assembler.Add(gelfChunkOf(gelfMaxMessages, 1, 2, []byte("b")), now)
+	assert.True(t, complete)
+	assert.Equal(t, []byte("ab"), message)
+
+	// A message can't be larger than a decompressed one.
+	assembler = NewGelfAssembler()
+	chunk := make([]byte, networkLogMaxSize/8)
+	var err error
+	for sequence := byte(0); sequence < 9 && err == nil; sequence++ {
+		_, _, err = assembler.Add(gelfChunkOf(1, sequence, 10, chunk), now)
+	}
+	assert.Error(t, err)
+	assert.Empty(t, assembler.messages)
+	assert.Equal(t, 0, assembler.pending)
+
+	// The oldest messages are dropped to keep the total size below the limit.
+	for id := 0; id < gelfMaxPendingSize/len(chunk)+2; id++ {
+		_, _, err = assembler.Add(gelfChunkOf(id, 0, 2, chunk), now.Add(time.Duration(id)))
+		assert.NoError(t, err)
+	}
+	assert.LessOrEqual(t, assembler.pending, gelfMaxPendingSize)
+	_, complete, _ = assembler.Add(gelfChunkOf(0, 1, 2, chunk), now)
+	assert.False(t, complete)
+
+	// Expired messages are dropped.
+	assembler.Add([]byte("plain"), now.Add(GelfChunkTimeout+time.Second))
+	assert.Empty(t, assembler.messages)
+	assert.Equal(t, 0, assembler.pending)
+}
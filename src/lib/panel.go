@@ -0,0 +1,112 @@
+package lib
+
+// This file implements the control panel: a message the bridge keeps in a
+// channel, with buttons for common server actions, e.g. saving the world or
+// listing the players. A button writes a console command to the subprocess,
+// or runs an action of the bridge.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPanelTitle is the title of the control panel, see PanelConfig.Title.
+const DefaultPanelTitle = "Server control panel"
+
+// PanelButtonPrefix starts the custom IDs of the control panel's buttons,
+// followed by the index of the button.
+const PanelButtonPrefix = "panel:"
+
+// Actions of the bridge that panel buttons run, see PanelButton.Action.
+const (
+	PanelActionPlayers = "players" // Lists the online players, see --query_address
+	PanelActionStats   = "stats"   // Shows the rule stats, like /bridge stats
+)
+
+// Styles of panel buttons, see PanelButton.Style.
+const (
+	PanelStylePrimary   = "primary"
+	PanelStyleSecondary = "secondary"
+	PanelStyleSuccess   = "success"
+	PanelStyleDanger    = "danger"
+)
+
+// PanelConfig configures the control panel.
+type PanelConfig struct {
+	ChannelId   string // Optional, ID of the channel of the panel, defaults to the relay channel
+	Title       string // Optional, see DefaultPanelTitle
+	Description string // Optional, shown above the buttons
+	// Optional, the members who may press the buttons besides
+	// administrators, e.g. {"Roles": ["Moderator"]}
+	Users *AuthorCondition
+	// The buttons, in rows of five; Discord allows at most 25
+	Buttons []PanelButton `validate:"required,min=1,max=25,dive"`
+}
+
+// PanelButton is a button of the control panel. It has a Command, an Action
+// or a Form.
+type PanelButton struct {
+	Label string `validate:"required,max=80"`
+	Emoji string // Optional, a Unicode emoji shown before the label
+	// Optional, one of the PanelStyle constants, defaults to secondary
+	Style string `validate:"omitempty,oneof=primary secondary success danger"`
+	// Console command written to the subprocess, e.g. "save-all"
+	Command string `validate:"required_without_all=Action Form,excluded_with=Action Form"`
+	// One of the PanelAction constants
+	Action string `validate:"omitempty,oneof=players stats"`
+	// Name of the form the button opens, see Rules.Forms
+	Form string `validate:"excluded_with=Action"`
+	// Optional, Command: the response to the member who pressed the button,
+	// defaults to naming the command
+	Reply string
+}
+
+// PanelTitle returns the title of the control panel.
+func (c *PanelConfig) PanelTitle() string {
+	if c.Title == "" {
+		return DefaultPanelTitle
+	}
+	return c.Title
+}
+
+// MayUse reports whether a member may press the buttons of the control panel.
+//
+// Parameters:
+//
+//	admin: whether the member is an administrator
+func (c *PanelConfig) MayUse(member Author, admin bool) bool {
+	return admin || (c.Users != nil && c.Users.Matches(member))
+}
+
+// ButtonId returns the custom ID of the button with an index.
+func (c *PanelConfig) ButtonId(index int) string {
+	return PanelButtonPrefix + strconv.Itoa(index)
+}
+
+// Button returns the button of a custom ID.
+//
+// Returns:
+//
+//	the button, and false if the ID isn't one of a button of the panel, e.g.
+//	of a button that was removed since
+func (c *PanelConfig) Button(customId string) (PanelButton, bool) {
+	index, ok := strings.CutPrefix(customId, PanelButtonPrefix)
+	if !ok {
+		return PanelButton{}, false
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(c.Buttons) {
+		return PanelButton{}, false
+	}
+	return c.Buttons[i], true
+}
+
+// CommandReply returns the response to the member who pressed a button with
+// a Command.
+func (b PanelButton) CommandReply() string {
+	if b.Reply != "" {
+		return b.Reply
+	}
+	return fmt.Sprintf("Sent `%v` to the server.", b.Command)
+}
@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPanelConfig(t *testing.T) {
+	config := &PanelConfig{
+		Users: &AuthorCondition{Roles: []string{"moderator"}},
+		Buttons: []PanelButton{
+			{Label: "Save", Command: "save-all"},
+			{Label: "Players", Action: PanelActionPlayers},
+		},
+	}
+	assert.Equal(t, DefaultPanelTitle, config.PanelTitle())
+	assert.Equal(t, "panel:1", config.ButtonId(1))
+
+	button, ok := config.Button(config.ButtonId(0))
+	assert.True(t, ok)
+	assert.Equal(t, "save-all", button.Command)
+	assert.Equal(t, "Sent `save-all` to the server.", button.CommandReply())
+	button, ok = config.Button("panel:1")
+	assert.True(t, ok)
+	assert.Equal(t, PanelActionPlayers, button.Action)
+	for _, id := range []string{"panel:2", "panel:-1", "panel:x", "approve:1"} {
+		_, ok = config.Button(id)
+		assert.False(t, ok, id)
+	}
+
+	moderator := Author{Id: "1", Roles: []Role{{Id: "10", Name: "Moderator"}}}
+	assert.True(t, config.MayUse(moderator, false))
+	assert.False(t, config.MayUse(Author{Id: "2"}, false))
+	assert.True(t, config.MayUse(Author{Id: "2"}, true), "administrators may use the panel")
+}
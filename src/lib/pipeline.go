@@ -0,0 +1,158 @@
+package lib
+
+// This file implements the event model of the bridge: each line read from the
+// subprocess and each message received from Discord or a federated bridge is
+// an Event, which flows through the stages of a Pipeline, e.g. matching the
+// rules, the startup summary, rate limits and delivery. Features that route,
+// count, persist or publish messages are stages, composed with Then, rather
+// than code reading the lines themselves.
+
+import "time"
+
+// Sources of an Event.
+const (
+	EventSourceSubprocess = "subprocess" // A line read from the subprocess
+	EventSourceDiscord    = "discord"    // A message sent in the relay channel
+	EventSourcePeer       = "peer"       // A message received from a federated bridge
+)
+
+// Event is a line or message flowing through the bridge. It isn't related to
+// the Discord scheduled events of EventConfig.
+type Event struct {
+	Source      string     // One of the EventSource constants
+	Direction   string     // One of the Direction constants
+	Raw         string     // The line or message as it was read
+	Transformed string     // The result of the matching rule, as changed by later stages; empty until a rule matched
+	Line        LineInfo   // Where a subprocess line came from
+	Props       *Props     // Props of a Discord message, nil for other sources
+	Match       *RuleMatch // The rule that matched, nil until one did
+	Received    time.Time  // When the line was read or the message was received
+	Matched     time.Time  // When a rule matched, zero until one did
+}
+
+// NewEvent creates the event of a line or message read from a source.
+//
+// Parameters:
+//
+//	source: one of the EventSource constants
+//	direction: one of the Direction constants
+func NewEvent(source string, direction string, raw string, received time.Time) *Event {
+	return &Event{Source: source, Direction: direction, Raw: raw, Received: received}
+}
+
+// SetMatch sets the matching rule of the event, and the transformed text to its
+// result.
+//
+// Parameters:
+//
+//	match: the match, nil if no rule matched
+func (e *Event) SetMatch(match *RuleMatch, now time.Time) {
+	e.Match = match
+	if match != nil {
+		e.Transformed = match.Result
+		e.Matched = now
+	}
+}
+
+// RuleId returns the RuleId of the matching rule, or "" if no rule matched.
+func (e *Event) RuleId() string {
+	if e.Match == nil {
+		return ""
+	}
+	return e.Match.RuleId()
+}
+
+// MatchEvent applies the rules of the event's direction to it: the rules
+// matching subprocess lines with MatchPlayer, and the others with Match.
+//
+// Parameters:
+//
+//	resolve: returns the Props of a player, may be nil
+func (r *Rules) MatchEvent(event *Event, resolve PropsResolver) *RuleMatch {
+	if event.Source == EventSourceSubprocess {
+		return r.MatchPlayer(event.Direction, event.Line, event.Raw, resolve)
+	}
+	return r.Match(event.Direction, event.Props, event.Raw)
+}
+
+// Stage is a step of a Pipeline.
+type Stage struct {
+	Name string // Identifies the stage, e.g. in the log
+	// Process processes an event, and may change it. It returns false to drop
+	// the event, skipping the remaining stages.
+	Process func(event *Event) bool
+}
+
+// Tap creates a stage that observes events without dropping them, e.g. to
+// count them.
+func Tap(name string, observe func(event *Event)) Stage {
+	return Stage{Name: name, Process: func(event *Event) bool {
+		observe(event)
+		return true
+	}}
+}
+
+// MatchStage creates a stage that applies rules to events, see
+// Rules.MatchEvent. Events no rule matched aren't dropped, so that later
+// stages observe them; RequireMatch drops them.
+//
+// Parameters:
+//
+//	rules: returns the current rules, which may be reloaded
+//	resolve: returns the Props of a player, may be nil
+func MatchStage(rules func() *Rules, resolve PropsResolver) Stage {
+	return Tap("match", func(event *Event) {
+		event.SetMatch(rules().MatchEvent(event, resolve), time.Now())
+	})
+}
+
+// RequireMatch creates a stage that drops events no rule matched, or whose
+// result was filtered out.
+func RequireMatch() Stage {
+	return Stage{Name: "require match", Process: func(event *Event) bool {
+		return event.Match != nil
+	}}
+}
+
+// Pipeline is a sequence of stages that events flow through. Pipelines are
+// immutable and safe for concurrent use if their stages are.
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline creates a pipeline of stages, in the order events flow through
+// them.
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: append([]Stage(nil), stages...)}
+}
+
+// Then returns a pipeline with stages appended, leaving the pipeline
+// unchanged, so that a pipeline can be the start of several others.
+func (p *Pipeline) Then(stages ...Stage) *Pipeline {
+	combined := make([]Stage, 0, len(p.stages)+len(stages))
+	combined = append(combined, p.stages...)
+	return &Pipeline{stages: append(combined, stages...)}
+}
+
+// Stages returns the names of the stages, in order.
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.Name
+	}
+	return names
+}
+
+// Run passes an event through the stages, until one drops it.
+//
+// Returns:
+//
+//	the name of the stage that dropped the event, and false if one did
+func (p *Pipeline) Run(event *Event) (string, bool) {
+	for _, stage := range p.stages {
+		if !stage.Process(event) {
+			return stage.Name, false
+		}
+	}
+	return "", true
+}
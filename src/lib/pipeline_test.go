@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	var seen []string
+	record := func(name string) Stage {
+		return Tap(name, func(event *Event) { seen = append(seen, name+":"+event.Transformed) })
+	}
+	upper := Stage{Name: "shout", Process: func(event *Event) bool {
+		event.Transformed += "!"
+		return true
+	}}
+	drop := Stage{Name: "drop", Process: func(event *Event) bool { return event.Raw != "spam" }}
+
+	base := NewPipeline(record("first"), drop)
+	pipeline := base.Then(upper, record("last"))
+	assert.Equal(t, []string{"first", "drop"}, base.Stages(), "Then leaves the pipeline unchanged")
+	assert.Equal(t, []string{"first", "drop", "shout", "last"}, pipeline.Stages())
+
+	event := NewEvent(EventSourceDiscord, DirectionDiscordToSubprocess, "hi", time.Now())
+	event.Transformed = "hi"
+	dropped, ok := pipeline.Run(event)
+	assert.True(t, ok)
+	assert.Empty(t, dropped)
+	assert.Equal(t, "hi!", event.Transformed)
+	assert.Equal(t, []string{"first:hi", "last:hi!"}, seen)
+
+	seen = nil
+	dropped, ok = pipeline.Run(NewEvent(EventSourceDiscord, DirectionDiscordToSubprocess, "spam", time.Now()))
+	assert.False(t, ok)
+	assert.Equal(t, "drop", dropped)
+	assert.Equal(t, []string{"first:"}, seen)
+}
+
+func TestMatchStage(t *testing.T) {
+	rules := &Rules{
+		SubprocessToDiscord: []Rule{{Match: ext.Regexp{Regexp: regexp.MustCompile(`^<(\w+)> (.*)$`)}, Template: "**${1}**: ${2}"}},
+		DiscordToSubprocess: []Rule{{Match: ext.Regexp{Regexp: regexp.MustCompile(`^(.*)$`)}, Template: "say ^U: ${1}"}},
+	}
+	pipeline := NewPipeline(MatchStage(func() *Rules { return rules }, nil), RequireMatch())
+
+	line := NewEvent(EventSourceSubprocess, DirectionSubprocessToDiscord, "<Steve> hi", time.Now())
+	_, ok := pipeline.Run(line)
+	assert.True(t, ok)
+	assert.Equal(t, "**Steve**: hi", line.Transformed)
+	assert.Equal(t, "#0", line.RuleId())
+	assert.False(t, line.Matched.IsZero())
+
+	message := NewEvent(EventSourceDiscord, DirectionDiscordToSubprocess, "hello", time.Now())
+	message.Props = &Props{Author: Author{Username: "alex"}}
+	_, ok = pipeline.Run(message)
+	assert.True(t, ok)
+	assert.Equal(t, "say alex: hello", message.Transformed)
+
+	unmatched := NewEvent(EventSourceSubprocess, DirectionSubprocessToDiscord, "Server started", time.Now())
+	dropped, ok := pipeline.Run(unmatched)
+	assert.False(t, ok)
+	assert.Equal(t, "require match", dropped)
+	assert.Empty(t, unmatched.RuleId())
+}
@@ -0,0 +1,426 @@
+package lib
+
+// This file implements querying game servers for their status with their
+// query protocols, independent of what they print to the console.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query protocols, see QueryServer.
+const (
+	QueryProtocolMinecraft      = "minecraft"       // Minecraft Server List Ping, over TCP; lists a sample of the players
+	QueryProtocolMinecraftQuery = "minecraft_query" // Minecraft Query (GameSpy 4), over UDP; lists all players
+	QueryProtocolA2S            = "a2s"             // Steam A2S_INFO and A2S_PLAYER, over UDP
+)
+
+// queryMaxSize is the maximum size of a query response.
+const queryMaxSize = 1024 * 1024
+
+// minecraftSampleIdExpr matches the ID of fake entries of the Server List
+// Ping player sample, which servers use to show text in the player list.
+var minecraftSampleIdExpr = regexp.MustCompile(`^0{8}-0{4}-0{4}-0{4}-0{12}$`)
+
+// minecraftFormattingExpr matches the formatting codes of Minecraft text.
+var minecraftFormattingExpr = regexp.MustCompile(`§.?`)
+
+// ServerStatus is the status of a game server, as reported by its query
+// protocol.
+type ServerStatus struct {
+	Motd       string   // Message of the day, or the name of the server
+	Players    int      // Number of online players
+	MaxPlayers int      // Maximum number of players
+	Names      []string // Names of online players; a sample of them with QueryProtocolMinecraft
+}
+
+// QueryServer queries a game server for its status.
+//
+// Parameters:
+//
+//	protocol: one of the QueryProtocol constants
+//	addr: address of the server's query port, e.g. localhost:25565
+//	timeout: timeout of the whole query
+func QueryServer(protocol string, addr string, timeout time.Duration) (ServerStatus, error) {
+	switch protocol {
+	case QueryProtocolMinecraft:
+		return queryMinecraft(addr, timeout)
+	case QueryProtocolMinecraftQuery:
+		return queryMinecraftQuery(addr, timeout)
+	case QueryProtocolA2S:
+		return queryA2S(addr, timeout)
+	default:
+		return ServerStatus{}, fmt.Errorf("unknown query protocol %q, expected %q, %q or %q",
+			protocol, QueryProtocolMinecraft, QueryProtocolMinecraftQuery, QueryProtocolA2S)
+	}
+}
+
+// FormatServerStatus expands a server status template with the status.
+// The parameters are:
+//   - ^P turns into the number of online players
+//   - ^X turns into the maximum number of players
+//   - ^N turns into the names of the online players, separated by commas
+//   - ^M turns into the message of the day
+//   - ^^ turns into ^
+func FormatServerStatus(template string, status ServerStatus) string {
+	return expandParameters(template, map[byte]string{
+		'P': strconv.Itoa(status.Players),
+		'X': strconv.Itoa(status.MaxPlayers),
+		'N': strings.Join(status.Names, ", "),
+		'M': status.Motd,
+		'^': "^",
+	})
+}
+
+// dialQuery connects to a query port, setting the deadline of the query.
+func dialQuery(network string, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// minecraftStatus is the part of the Server List Ping response that is used.
+type minecraftStatus struct {
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+		Sample []struct {
+			Name string `json:"name"`
+			Id   string `json:"id"`
+		} `json:"sample"`
+	} `json:"players"`
+	Description json.RawMessage `json:"description"`
+}
+
+// queryMinecraft queries a server with the Minecraft Server List Ping
+// protocol, see https://wiki.vg/Server_List_Ping.
+func queryMinecraft(addr string, timeout time.Duration) (ServerStatus, error) {
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	port, err := strconv.ParseUint(portText, 10, 16)
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("invalid port %q", portText)
+	}
+	conn, err := dialQuery("tcp", addr, timeout)
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var handshake bytes.Buffer
+	writeVarInt(&handshake, 0x00) // Packet ID
+	writeVarInt(&handshake, -1)   // Protocol version, -1 when only pinging
+	writeVarInt(&handshake, int32(len(host)))
+	handshake.WriteString(host)
+	_ = binary.Write(&handshake, binary.BigEndian, uint16(port))
+	writeVarInt(&handshake, 1) // Next state: status
+	var request bytes.Buffer
+	writeVarInt(&request, int32(handshake.Len()))
+	request.Write(handshake.Bytes())
+	request.Write([]byte{1, 0x00}) // Status request: length 1, packet ID 0
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		return ServerStatus{}, err
+	}
+
+	reader := bufio.NewReader(conn)
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("error reading status: %v", err)
+	}
+	if length > queryMaxSize {
+		return ServerStatus{}, fmt.Errorf("status of %v bytes is too large", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(reader, packet); err != nil {
+		return ServerStatus{}, fmt.Errorf("error reading status: %v", err)
+	}
+	packetReader := bytes.NewReader(packet)
+	if id, err := binary.ReadUvarint(packetReader); err != nil || id != 0x00 {
+		return ServerStatus{}, fmt.Errorf("unexpected status packet")
+	}
+	if _, err := binary.ReadUvarint(packetReader); err != nil {
+		return ServerStatus{}, fmt.Errorf("unexpected status packet")
+	}
+	var status minecraftStatus
+	if err := json.NewDecoder(packetReader).Decode(&status); err != nil {
+		return ServerStatus{}, fmt.Errorf("error decoding status: %v", err)
+	}
+
+	result := ServerStatus{
+		Motd:       minecraftFormattingExpr.ReplaceAllString(flattenMinecraftText(status.Description), ""),
+		Players:    status.Players.Online,
+		MaxPlayers: status.Players.Max,
+	}
+	for _, player := range status.Players.Sample {
+		if player.Name != "" && !minecraftSampleIdExpr.MatchString(player.Id) {
+			result.Names = append(result.Names, player.Name)
+		}
+	}
+	return result, nil
+}
+
+// writeVarInt writes a Minecraft VarInt.
+func writeVarInt(buffer *bytes.Buffer, value int32) {
+	unsigned := uint32(value)
+	for unsigned >= 0x80 {
+		buffer.WriteByte(byte(unsigned) | 0x80)
+		unsigned >>= 7
+	}
+	buffer.WriteByte(byte(unsigned))
+}
+
+// flattenMinecraftText returns the plain text of Minecraft text, which is a
+// string, a text component or an array of them.
+func flattenMinecraftText(text json.RawMessage) string {
+	var plain string
+	if json.Unmarshal(text, &plain) == nil {
+		return plain
+	}
+	var component struct {
+		Text  string            `json:"text"`
+		Extra []json.RawMessage `json:"extra"`
+	}
+	var components []json.RawMessage
+	if json.Unmarshal(text, &components) == nil {
+		component.Extra = components
+	} else if json.Unmarshal(text, &component) != nil {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString(component.Text)
+	for _, extra := range component.Extra {
+		builder.WriteString(flattenMinecraftText(extra))
+	}
+	return builder.String()
+}
+
+// queryMinecraftQuery queries a server with the Minecraft Query protocol,
+// see https://wiki.vg/Query. It must be enabled in server.properties.
+func queryMinecraftQuery(addr string, timeout time.Duration) (ServerStatus, error) {
+	conn, err := dialQuery("udp", addr, timeout)
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	buffer := make([]byte, 65535)
+	sessionId := binary.BigEndian.AppendUint32(nil, uint32(time.Now().UnixNano())&0x0f0f0f0f)
+
+	// Handshake, to get a challenge token.
+	if _, err := conn.Write(append([]byte{0xfe, 0xfd, 0x09}, sessionId...)); err != nil {
+		return ServerStatus{}, err
+	}
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	if n < 6 || buffer[0] != 0x09 || !bytes.Equal(buffer[1:5], sessionId) {
+		return ServerStatus{}, fmt.Errorf("unexpected handshake response")
+	}
+	token, err := strconv.ParseInt(string(bytes.TrimRight(buffer[5:n], "\x00")), 10, 32)
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("invalid challenge token: %v", err)
+	}
+
+	// Full stat request, padded to tell it from a basic stat request.
+	request := append([]byte{0xfe, 0xfd, 0x00}, sessionId...)
+	request = binary.BigEndian.AppendUint32(request, uint32(token))
+	request = append(request, 0, 0, 0, 0)
+	if _, err := conn.Write(request); err != nil {
+		return ServerStatus{}, err
+	}
+	n, err = conn.Read(buffer)
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	// Type, session ID, and 11 bytes of padding.
+	if n < 16 || buffer[0] != 0x00 || !bytes.Equal(buffer[1:5], sessionId) {
+		return ServerStatus{}, fmt.Errorf("unexpected stat response")
+	}
+	return parseMinecraftQueryStat(strings.Split(string(buffer[16:n]), "\x00"))
+}
+
+// parseMinecraftQueryStat parses the fields of a full stat response: key
+// value pairs up to an empty key, then the player names after a "player_"
+// marker, up to an empty name.
+func parseMinecraftQueryStat(fields []string) (ServerStatus, error) {
+	values := make(map[string]string)
+	i := 0
+	for ; i+1 < len(fields) && fields[i] != ""; i += 2 {
+		values[fields[i]] = fields[i+1]
+	}
+	var status ServerStatus
+	var err error
+	status.Motd = minecraftFormattingExpr.ReplaceAllString(values["hostname"], "")
+	if status.Players, err = strconv.Atoi(values["numplayers"]); err != nil {
+		return ServerStatus{}, fmt.Errorf("invalid number of players %q", values["numplayers"])
+	}
+	if status.MaxPlayers, err = strconv.Atoi(values["maxplayers"]); err != nil {
+		return ServerStatus{}, fmt.Errorf("invalid maximum number of players %q", values["maxplayers"])
+	}
+	for ; i < len(fields); i++ {
+		if fields[i] == "\x01player_" {
+			// The marker is followed by an empty field.
+			for _, name := range fields[min(i+2, len(fields)):] {
+				if name == "" {
+					break
+				}
+				status.Names = append(status.Names, name)
+			}
+			break
+		}
+	}
+	return status, nil
+}
+
+// Requests and response types of the A2S protocol.
+var (
+	a2sInfoRequest   = []byte("\xff\xff\xff\xffTSource Engine Query\x00")
+	a2sPlayerRequest = []byte("\xff\xff\xff\xffU")
+)
+
+const (
+	a2sChallengeResponse = 0x41
+	a2sInfoResponse      = 0x49
+	a2sPlayerResponse    = 0x44
+)
+
+// queryA2S queries a server with the Steam A2S protocol, see
+// https://developer.valvesoftware.com/wiki/Server_queries.
+func queryA2S(addr string, timeout time.Duration) (ServerStatus, error) {
+	conn, err := dialQuery("udp", addr, timeout)
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	info, err := a2sRequest(conn, func(challenge []byte) []byte {
+		return append(bytes.Clone(a2sInfoRequest), challenge...)
+	}, a2sInfoResponse)
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("error querying info: %v", err)
+	}
+	var status ServerStatus
+	reader := bytes.NewReader(info)
+	_, _ = reader.ReadByte() // Protocol version
+	status.Motd = readCString(reader)
+	for range 3 {
+		readCString(reader) // Map, folder and game
+	}
+	_, _ = reader.Seek(2, io.SeekCurrent) // Steam application ID
+	players, err := reader.ReadByte()
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("truncated info response")
+	}
+	maxPlayers, err := reader.ReadByte()
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("truncated info response")
+	}
+	status.Players, status.MaxPlayers = int(players), int(maxPlayers)
+
+	list, err := a2sRequest(conn, func(challenge []byte) []byte {
+		if challenge == nil {
+			challenge = []byte{0xff, 0xff, 0xff, 0xff}
+		}
+		return append(bytes.Clone(a2sPlayerRequest), challenge...)
+	}, a2sPlayerResponse)
+	if err != nil {
+		return ServerStatus{}, fmt.Errorf("error querying players: %v", err)
+	}
+	reader = bytes.NewReader(list)
+	count, _ := reader.ReadByte()
+	for range count {
+		if _, err := reader.ReadByte(); err != nil { // Index
+			break
+		}
+		name := readCString(reader)
+		// Score and duration.
+		if _, err := reader.Seek(8, io.SeekCurrent); err != nil {
+			break
+		}
+		// Players that are still connecting have no name yet.
+		if name != "" {
+			status.Names = append(status.Names, name)
+		}
+	}
+	return status, nil
+}
+
+// a2sRequest sends an A2S request, answering the challenge if the server
+// sends one.
+//
+// Parameters:
+//
+//	request: builds the request, with the challenge, or nil at first
+//	responseType: type of the expected response
+//
+// Returns:
+//
+//	the payload of the response, after its type
+func a2sRequest(conn net.Conn, request func(challenge []byte) []byte, responseType byte) ([]byte, error) {
+	buffer := make([]byte, 65535)
+	var challenge []byte
+	// The server may send a new challenge instead of accepting the answer.
+	for range 3 {
+		if _, err := conn.Write(request(challenge)); err != nil {
+			return nil, err
+		}
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		packet := buffer[:n]
+		if bytes.HasPrefix(packet, []byte{0xfe, 0xff, 0xff, 0xff}) {
+			return nil, fmt.Errorf("split responses are not supported")
+		}
+		if n < 5 || !bytes.HasPrefix(packet, []byte{0xff, 0xff, 0xff, 0xff}) {
+			return nil, fmt.Errorf("unexpected response")
+		}
+		switch packet[4] {
+		case a2sChallengeResponse:
+			if n < 9 {
+				return nil, fmt.Errorf("truncated challenge")
+			}
+			challenge = bytes.Clone(packet[5:9])
+		case responseType:
+			return bytes.Clone(packet[5:]), nil
+		default:
+			return nil, fmt.Errorf("unexpected response type 0x%02x", packet[4])
+		}
+	}
+	return nil, fmt.Errorf("too many challenges")
+}
+
+// readCString reads a null-terminated string.
+func readCString(reader *bytes.Reader) string {
+	var builder strings.Builder
+	for {
+		b, err := reader.ReadByte()
+		if err != nil || b == 0 {
+			return builder.String()
+		}
+		builder.WriteByte(b)
+	}
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const queryTestTimeout = 2 * time.Second
+
+// serveUdp answers each UDP packet received with the response of handle, until
+// the test ends.
+func serveUdp(t *testing.T, handle func(request []byte) []byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(handle(bytes.Clone(buffer[:n])), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestQueryMinecraft(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	status := `{"version":{"name":"1.21","protocol":767},` +
+		`"players":{"max":20,"online":3,"sample":[` +
+		`{"name":"Bob","id":"4566e69f-c907-48ee-8d71-d7ba5aa00d20"},` +
+		`{"name":"§7and 1 more","id":"00000000-0000-0000-0000-000000000000"}]},` +
+		`"description":{"text":"§aA ","extra":["Minecraft ",{"text":"Server"}]}}`
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		reader := bufio.NewReader(conn)
+		// Handshake and status request.
+		for range 2 {
+			length, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return
+			}
+			_, _ = io.CopyN(io.Discard, reader, int64(length))
+		}
+		var payload bytes.Buffer
+		writeVarInt(&payload, 0x00)
+		writeVarInt(&payload, int32(len(status)))
+		payload.WriteString(status)
+		var response bytes.Buffer
+		writeVarInt(&response, int32(payload.Len()))
+		response.Write(payload.Bytes())
+		_, _ = conn.Write(response.Bytes())
+	}()
+
+	result, err := QueryServer(QueryProtocolMinecraft, listener.Addr().String(), queryTestTimeout)
+	assert.NoError(t, err)
+	assert.Equal(t, ServerStatus{Motd: "A Minecraft Server", Players: 3, MaxPlayers: 20, Names: []string{"Bob"}}, result)
+}
+
+func TestFlattenMinecraftText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{`"plain"`, "plain"},
+		{`{"text":"a","extra":[{"text":"b","extra":["c"]}]}`, "abc"},
+		{`[{"text":"a"},"b"]`, "ab"},
+		{`42`, ""},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, flattenMinecraftText(json.RawMessage(test.text)), "Test #%v", i)
+	}
+}
+
+func TestQueryMinecraftQuery(t *testing.T) {
+	addr := serveUdp(t, func(request []byte) []byte {
+		sessionId := request[3:7]
+		if request[2] == 0x09 {
+			return append(append([]byte{0x09}, sessionId...), "-1234\x00"...)
+		}
+		// The full stat request has the challenge token and padding.
+		if len(request) != 15 || int32(binary.BigEndian.Uint32(request[7:11])) != -1234 {
+			return nil
+		}
+		response := append([]byte{0x00}, sessionId...)
+		response = append(response, "splitnum\x00\x80\x00"...)
+		response = append(response, "hostname\x00§6A Server\x00numplayers\x002\x00maxplayers\x0020\x00\x00"...)
+		response = append(response, "\x01player_\x00\x00Bob\x00Alice\x00\x00"...)
+		return response
+	})
+
+	result, err := QueryServer(QueryProtocolMinecraftQuery, addr, queryTestTimeout)
+	assert.NoError(t, err)
+	assert.Equal(t, ServerStatus{Motd: "A Server", Players: 2, MaxPlayers: 20, Names: []string{"Bob", "Alice"}}, result)
+}
+
+func TestQueryA2S(t *testing.T) {
+	challenge := []byte{1, 2, 3, 4}
+	addr := serveUdp(t, func(request []byte) []byte {
+		// Both requests must answer the challenge.
+		if !bytes.HasSuffix(request, challenge) {
+			return append([]byte{0xff, 0xff, 0xff, 0xff, a2sChallengeResponse}, challenge...)
+		}
+		if request[4] == 'T' {
+			response := []byte{0xff, 0xff, 0xff, 0xff, a2sInfoResponse, 17}
+			response = append(response, "A Server\x00map\x00folder\x00game\x00"...)
+			return append(response, 0, 0, 2, 16, 0)
+		}
+		response := []byte{0xff, 0xff, 0xff, 0xff, a2sPlayerResponse, 2}
+		response = append(response, 0)
+		response = append(response, "Bob\x00"...)
+		response = append(response, make([]byte, 8)...)
+		// A player that is still connecting.
+		response = append(response, 1, 0)
+		return append(response, make([]byte, 8)...)
+	})
+
+	result, err := QueryServer(QueryProtocolA2S, addr, queryTestTimeout)
+	assert.NoError(t, err)
+	assert.Equal(t, ServerStatus{Motd: "A Server", Players: 2, MaxPlayers: 16, Names: []string{"Bob"}}, result)
+}
+
+func TestQueryServerErrors(t *testing.T) {
+	_, err := QueryServer("gamespy", "localhost:1", queryTestTimeout)
+	assert.Error(t, err)
+	addr := serveUdp(t, func(request []byte) []byte {
+		return []byte{0xfe, 0xff, 0xff, 0xff}
+	})
+	_, err = QueryServer(QueryProtocolA2S, addr, queryTestTimeout)
+	assert.ErrorContains(t, err, "split responses")
+}
+
+func TestFormatServerStatus(t *testing.T) {
+	status := ServerStatus{Motd: "A Server", Players: 2, MaxPlayers: 20, Names: []string{"Bob", "Alice"}}
+	assert.Equal(t, "2/20 on A Server: Bob, Alice ^Z ^", FormatServerStatus("^P/^X on ^M: ^N ^Z ^^", status))
+}
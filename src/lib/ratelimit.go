@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Overflow policies of a RateLimit.
+const (
+	// Messages wait until they may be relayed; if too many are waiting, the
+	// oldest are dropped.
+	RateLimitOverflowDrop = "drop"
+	// Messages over the limit are dropped, and the next relayed message is
+	// preceded by a summary of how many were dropped.
+	RateLimitOverflowSummarize = "summarize"
+)
+
+// DefaultRateLimitSummary is the summary sent to Discord when messages were
+// suppressed, if RateLimit.Summary is not set.
+const DefaultRateLimitSummary = "*%d messages suppressed*"
+
+// RateLimit caps the rate at which messages are relayed in a direction, e.g. to
+// protect Discord from log storms or the game from chat floods.
+type RateLimit struct {
+	Rate     float64 `validate:"gt=0"`                           // Messages per second
+	Burst    int     `validate:"gte=0"`                          // Messages that may be relayed at once, defaults to 1
+	Overflow string  `validate:"omitempty,oneof=drop summarize"` // Defaults to RateLimitOverflowDrop
+	Summary  string  // fmt format of the summary, %d is the number of suppressed messages
+}
+
+// RateLimiter enforces a RateLimit with a token bucket.
+// It is safe for concurrent use.
+type RateLimiter struct {
+	limit RateLimit
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mutex      sync.Mutex
+	tokens     float64   // May be negative while messages wait
+	last       time.Time // Time tokens were last refilled
+	suppressed int       // Messages dropped since the last relayed message
+}
+
+// NewRateLimiter creates a RateLimiter that allows a burst right away.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	if limit.Overflow == "" {
+		limit.Overflow = RateLimitOverflowDrop
+	}
+	return &RateLimiter{
+		limit:  limit,
+		now:    time.Now,
+		sleep:  time.Sleep,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait is called before relaying a message. With RateLimitOverflowDrop, it
+// blocks until the message may be relayed. With RateLimitOverflowSummarize, it
+// doesn't block.
+//
+// Returns:
+//
+//	whether the message may be relayed, and the number of messages suppressed
+//	since the last relayed message
+func (l *RateLimiter) Wait() (bool, int) {
+	l.mutex.Lock()
+	l.refill()
+	if l.limit.Overflow == RateLimitOverflowSummarize {
+		defer l.mutex.Unlock()
+		if l.tokens < 1 {
+			l.suppressed++
+			return false, 0
+		}
+		l.tokens--
+		suppressed := l.suppressed
+		l.suppressed = 0
+		return true, suppressed
+	}
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.limit.Rate * float64(time.Second))
+	}
+	l.mutex.Unlock()
+	if delay > 0 {
+		l.sleep(delay)
+	}
+	return true, 0
+}
+
+// FlushSuppressed is called periodically with RateLimitOverflowSummarize, so
+// that suppressed messages are summarized even if no message follows them. If
+// messages were suppressed and a message may be relayed, the summary takes its
+// place.
+//
+// Returns:
+//
+//	the number of messages suppressed since the last relayed message, or 0 if
+//	there are none or the summary must wait
+func (l *RateLimiter) FlushSuppressed() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill()
+	if l.suppressed == 0 || l.tokens < 1 {
+		return 0
+	}
+	l.tokens--
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return suppressed
+}
+
+// Summary returns the summary of suppressed messages, or "" if the limit has
+// no summary.
+//
+// Parameters:
+//
+//	fallback: used if RateLimit.Summary is not set
+func (l *RateLimiter) Summary(suppressed int, fallback string) string {
+	format := l.limit.Summary
+	if format == "" {
+		format = fallback
+	}
+	if format == "" {
+		return ""
+	}
+	return fmt.Sprintf(format, suppressed)
+}
+
+// refill adds the tokens earned since the last refill.
+func (l *RateLimiter) refill() {
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limit.Rate
+	if l.tokens > float64(l.limit.Burst) {
+		l.tokens = float64(l.limit.Burst)
+	}
+	l.last = now
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRateLimiter(limit RateLimit) (*RateLimiter, *time.Time, *[]time.Duration) {
+	limiter := NewRateLimiter(limit)
+	clock := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	var sleeps []time.Duration
+	limiter.now = func() time.Time { return clock }
+	limiter.last = clock
+	limiter.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		clock = clock.Add(d)
+	}
+	return limiter, &clock, &sleeps
+}
+
+func TestRateLimiterDrop(t *testing.T) {
+	limiter, clock, sleeps := newTestRateLimiter(RateLimit{Rate: 2, Burst: 2})
+	for i := 0; i < 4; i++ {
+		relay, suppressed := limiter.Wait()
+		assert.True(t, relay)
+		assert.Zero(t, suppressed)
+	}
+	// The burst is free, then one message every half second.
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, *sleeps)
+
+	*clock = clock.Add(time.Hour)
+	*sleeps = nil
+	limiter.Wait()
+	limiter.Wait()
+	assert.Empty(t, *sleeps)
+}
+
+func TestRateLimiterSummarize(t *testing.T) {
+	limiter, clock, sleeps := newTestRateLimiter(RateLimit{Rate: 1, Overflow: RateLimitOverflowSummarize})
+	relay, suppressed := limiter.Wait()
+	assert.True(t, relay)
+	assert.Zero(t, suppressed)
+	for i := 0; i < 3; i++ {
+		relay, _ = limiter.Wait()
+		assert.False(t, relay)
+	}
+	*clock = clock.Add(time.Second)
+	relay, suppressed = limiter.Wait()
+	assert.True(t, relay)
+	assert.Equal(t, 3, suppressed)
+	assert.Empty(t, *sleeps)
+
+	assert.Zero(t, limiter.FlushSuppressed())
+	limiter.Wait()
+	assert.Zero(t, limiter.FlushSuppressed(), "the summary waits for its turn")
+	*clock = clock.Add(time.Second)
+	assert.Equal(t, 1, limiter.FlushSuppressed())
+	assert.Zero(t, limiter.FlushSuppressed())
+
+	assert.Equal(t, "*3 messages suppressed*", limiter.Summary(3, DefaultRateLimitSummary))
+	assert.Equal(t, "", limiter.Summary(3, ""))
+}
+
+func TestRulesValidateRateLimits(t *testing.T) {
+	tests := []struct {
+		rateLimits string
+		valid      bool
+	}{
+		{`{"SubprocessToDiscord": {"Rate": 0.5}}`, true},
+		{`{"SubprocessToDiscord": {"Rate": 0}}`, false},
+		{`{"SubprocessToDiscord": {"Rate": -1}}`, false},
+		{`{"SubprocessToDiscord": {"Burst": 5}}`, false},
+	}
+	for i, test := range tests {
+		rules, err := ParseRules([]byte(`{"SubprocessToDiscord": [], "DiscordToSubprocess": [], "RateLimits": ` + test.rateLimits + `}`))
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Equal(t, test.valid, rules.Validate() == nil, "Test #%v", i)
+	}
+}
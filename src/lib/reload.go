@@ -0,0 +1,92 @@
+package lib
+
+// This file implements reloading the rules while the bridge runs, e.g. on
+// SIGHUP, see SignalActionReload, or when its files change, see ConfigWatch.
+// Most sections of the rules apply to each line and message, so reloaded rules
+// apply right away; others only apply on startup, e.g. the sinks that are
+// connected then, and keep their values until the next start.
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+)
+
+// KeepStartupSections keeps the sections of reloaded rules that only apply on
+// startup from the rules the bridge was started with.
+//
+// Parameters:
+//
+//	started: the rules the bridge was started with
+//
+// Returns:
+//
+//	the names of the sections that changed, which apply on the next start
+func (r *Rules) KeepStartupSections(started *Rules) []string {
+	var changed []string
+	keepSection(&r.SubprocessToPeer, started.SubprocessToPeer, "SubprocessToPeer", &changed)
+	keepSection(&r.PeerToSubprocess, started.PeerToSubprocess, "PeerToSubprocess", &changed)
+	keepSection(&r.Backfill, started.Backfill, "Backfill", &changed)
+	keepSection(&r.Sinks, started.Sinks, "Sinks", &changed)
+	keepSection(&r.Routes, started.Routes, "Routes", &changed)
+	keepSection(&r.RateLimits, started.RateLimits, "RateLimits", &changed)
+	keepSection(&r.States, started.States, "States", &changed)
+	keepSection(&r.Workflows, started.Workflows, "Workflows", &changed)
+	keepSection(&r.Link, started.Link, "Link", &changed)
+	keepSection(&r.Repeats, started.Repeats, "Repeats", &changed)
+	keepSection(&r.Panel, started.Panel, "Panel", &changed)
+	keepSection(&r.Forms, started.Forms, "Forms", &changed)
+	return changed
+}
+
+// keepSection replaces a section of reloaded rules with its value on startup,
+// adding its name to changed if they differ.
+func keepSection[T any](reloaded *T, started T, name string, changed *[]string) {
+	// Sections are compared as JSON, since compiled regular expressions
+	// can't be compared.
+	reloadedJson, _ := json.Marshal(*reloaded)
+	startedJson, _ := json.Marshal(started)
+	if !bytes.Equal(reloadedJson, startedJson) {
+		*changed = append(*changed, name)
+	}
+	*reloaded = started
+}
+
+// ConfigWatch detects changes of the configuration files, e.g. a Kubernetes
+// ConfigMap mounted as a volume, whose files are replaced by swapping a
+// symlink. Files are compared by their contents, since the modification times
+// of such files don't change. Files that can't be read have an empty hash, so
+// that a file being replaced is detected once it is complete.
+type ConfigWatch struct {
+	paths  []string
+	hashes []string // See ConfigHash, by index in paths
+}
+
+// NewConfigWatch creates a ConfigWatch of files, with their current contents.
+func NewConfigWatch(paths []string) *ConfigWatch {
+	return &ConfigWatch{paths: paths, hashes: configHashes(paths)}
+}
+
+// Changed reports whether the contents of a file changed since the last
+// call, or since the watch was created.
+func (w *ConfigWatch) Changed() bool {
+	current := configHashes(w.paths)
+	if slices.Equal(current, w.hashes) {
+		return false
+	}
+	w.hashes = current
+	return true
+}
+
+// configHashes returns the hashes of the contents of files, empty for those
+// that can't be read.
+func configHashes(paths []string) []string {
+	hashes := make([]string, len(paths))
+	for i, path := range paths {
+		if contents, err := os.ReadFile(path); err == nil {
+			hashes[i] = ConfigHash(contents)
+		}
+	}
+	return hashes
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRules_KeepStartupSections(t *testing.T) {
+	started, err := ParseRules([]byte(`{
+		"SubprocessToDiscord": [{"Match": "^(.*)$", "Template": "${1}"}],
+		"DiscordToSubprocess": [],
+		"Backfill": [{"Match": "joined", "Template": "joined"}],
+		"RateLimits": {"SubprocessToDiscord": {"Rate": 1}}
+	}`))
+	assert.NoError(t, err)
+	reloaded, err := ParseRules([]byte(`{
+		"SubprocessToDiscord": [{"Match": "^(.*)$", "Template": "**${1}**"}],
+		"DiscordToSubprocess": [],
+		"Backfill": [{"Match": "joined", "Template": "joined"}],
+		"RateLimits": {"SubprocessToDiscord": {"Rate": 2}}
+	}`))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"RateLimits"}, reloaded.KeepStartupSections(started))
+	assert.Equal(t, started.RateLimits, reloaded.RateLimits, "the startup value is kept")
+	assert.Equal(t, "**${1}**", reloaded.SubprocessToDiscord[0].Template, "the rules are reloaded")
+}
+
+func TestConfigWatch(t *testing.T) {
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "rules.json")
+	users := filepath.Join(dir, "users.json")
+	assert.NoError(t, os.WriteFile(rules, []byte("{}"), 0o644))
+	watch := NewConfigWatch([]string{rules, users})
+	assert.False(t, watch.Changed())
+
+	// Files are compared by their contents.
+	assert.NoError(t, os.WriteFile(rules, []byte("{}"), 0o644))
+	assert.False(t, watch.Changed())
+	assert.NoError(t, os.WriteFile(users, []byte("{}"), 0o644))
+	assert.True(t, watch.Changed())
+	assert.False(t, watch.Changed(), "changes are reported once")
+
+	// A file being replaced is a change, and so is the new file.
+	assert.NoError(t, os.Remove(rules))
+	assert.True(t, watch.Changed())
+	assert.NoError(t, os.WriteFile(rules, []byte("{}"), 0o644))
+	assert.True(t, watch.Changed())
+}
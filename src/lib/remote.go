@@ -0,0 +1,133 @@
+package lib
+
+// This file implements fetching files, e.g. rules, from HTTPS URLs, caching
+// them so that the bridge starts while the URL is unreachable.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// remoteFileLimit is the maximum size of a fetched file.
+const remoteFileLimit = 16 * 1024 * 1024
+
+// IsRemoteFile reports whether a path is an HTTPS URL, see RemoteFile.
+func IsRemoteFile(path string) bool {
+	return strings.HasPrefix(path, "https://")
+}
+
+// RemoteFile is a file fetched from an HTTPS URL and cached on disk. The ETag
+// of the cached file is saved next to it, so that unchanged files aren't
+// downloaded again.
+type RemoteFile struct {
+	Url       string
+	CachePath string       // Where the file is cached, see RemoteCachePath
+	Client    *http.Client // http.DefaultClient if nil
+	// Validate checks fetched contents before they replace the cached file,
+	// may be nil.
+	Validate func(contents []byte) error
+}
+
+// RemoteCachePath returns the path a file fetched from a URL is cached at.
+//
+// Parameters:
+//
+//	dir: cache directory
+//	suffix: file name suffix, e.g. ".rules.json"
+func RemoteCachePath(dir string, url string, suffix string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+suffix)
+}
+
+// Fetch downloads the file into the cache, unless the cached file is up to
+// date.
+//
+// Returns:
+//
+//	whether the cached file changed
+func (f *RemoteFile) Fetch() (bool, error) {
+	request, err := http.NewRequest(http.MethodGet, f.Url, nil)
+	if err != nil {
+		return false, err
+	}
+	etag, err := os.ReadFile(f.etagPath())
+	if err == nil && f.Cached() {
+		request.Header.Set("If-None-Match", string(etag))
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("fetching %v: unexpected status %v", f.Url, response.Status)
+	}
+	contents, err := io.ReadAll(io.LimitReader(response.Body, remoteFileLimit+1))
+	if err != nil {
+		return false, fmt.Errorf("fetching %v: %v", f.Url, err)
+	}
+	if len(contents) > remoteFileLimit {
+		return false, fmt.Errorf("fetching %v: larger than %v bytes", f.Url, remoteFileLimit)
+	}
+	if f.Validate != nil {
+		if err := f.Validate(contents); err != nil {
+			return false, fmt.Errorf("invalid file at %v: %v", f.Url, err)
+		}
+	}
+	// Servers without ETags send the whole file every time, so compare it with
+	// the cached one.
+	cached, err := os.ReadFile(f.CachePath)
+	changed := err != nil || !bytes.Equal(cached, contents)
+	if changed {
+		if err := writeFileAtomic(f.CachePath, contents); err != nil {
+			return false, err
+		}
+	}
+	newEtag := response.Header.Get("ETag")
+	if newEtag == "" {
+		_ = os.Remove(f.etagPath())
+	} else if err := writeFileAtomic(f.etagPath(), []byte(newEtag)); err != nil {
+		return changed, err
+	}
+	return changed, nil
+}
+
+// Cached reports whether the file is in the cache.
+func (f *RemoteFile) Cached() bool {
+	_, err := os.Stat(f.CachePath)
+	return err == nil
+}
+
+func (f *RemoteFile) etagPath() string {
+	return f.CachePath + ".etag"
+}
+
+// writeFileAtomic writes a file through a temporary file, so that readers
+// never see a partly written file.
+func writeFileAtomic(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, contents, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteFile(t *testing.T) {
+	contents := `{"version": 1}`
+	etag := `"v1"`
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(contents))
+	}))
+	defer server.Close()
+
+	assert.True(t, IsRemoteFile(server.URL))
+	dir := t.TempDir()
+	file := RemoteFile{
+		Url:       server.URL + "/minecraft.rules.json",
+		CachePath: RemoteCachePath(dir, server.URL+"/minecraft.rules.json", ".rules.json"),
+		Client:    server.Client(),
+	}
+	assert.False(t, file.Cached())
+
+	changed, err := file.Fetch()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	cached, _ := os.ReadFile(file.CachePath)
+	assert.Equal(t, contents, string(cached))
+
+	// Unchanged: the server responds with 304
+	changed, err = file.Fetch()
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, 2, requests)
+
+	contents, etag = `{"version": 2}`, `"v2"`
+	changed, err = file.Fetch()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	cached, _ = os.ReadFile(file.CachePath)
+	assert.Equal(t, contents, string(cached))
+
+	// Invalid files don't replace the cached one
+	contents, etag = `{`, `"v3"`
+	file.Validate = func([]byte) error { return errors.New("unexpected end of JSON input") }
+	_, err = file.Fetch()
+	assert.Error(t, err)
+	cached, _ = os.ReadFile(file.CachePath)
+	assert.Equal(t, `{"version": 2}`, string(cached))
+	assert.Equal(t, dir, filepath.Dir(file.CachePath))
+}
+
+func TestRemoteFileStatus(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	file := RemoteFile{Url: server.URL, CachePath: filepath.Join(t.TempDir(), "rules.json"), Client: server.Client()}
+	_, err := file.Fetch()
+	assert.ErrorContains(t, err, "404")
+	assert.False(t, file.Cached())
+}
@@ -0,0 +1,161 @@
+package lib
+
+// This file implements suppressing identical messages to Discord that repeat
+// over a limit, see Rules.Repeats.
+
+import (
+	"dgbridge/src/ext"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRepeatWindow is the window of a RepeatLimit, if RepeatLimit.Window is
+// not set.
+const DefaultRepeatWindow = time.Minute
+
+// DefaultRepeatNotice is the notice sent to Discord when repeated messages were
+// suppressed, if RepeatLimit.Notice is not set.
+const DefaultRepeatNotice = "*Suppressed ^# duplicate lines in ^W*"
+
+// RepeatLimit suppresses identical messages to Discord that repeat over a limit,
+// e.g. a modded server stuck in an error loop, whichever rules they match.
+type RepeatLimit struct {
+	Limit  int          `validate:"gte=1"` // Identical messages relayed per window, the rest are suppressed
+	Window ext.Duration // Optional, see DefaultRepeatWindow
+	// Optional, sent once a window with suppressed messages ends, where ^#
+	// turns into the number of suppressed messages, ^W into the window and ^^
+	// into ^; see DefaultRepeatNotice
+	Notice string
+}
+
+// RepeatFilter enforces a RepeatLimit. Each message starts a window on its
+// first occurrence, in which it is relayed Limit times.
+// It is safe for concurrent use.
+type RepeatFilter struct {
+	limit  int
+	window time.Duration
+	notice string
+
+	mutex   sync.Mutex
+	entries map[string]*repeatEntry
+	queue   []*repeatEntry // By start, so that expired windows are at the front
+	notices []string       // Of windows that ended, until Flush
+}
+
+// repeatEntry is the window of a message.
+type repeatEntry struct {
+	message    string
+	start      time.Time
+	count      int // Occurrences in the window
+	suppressed int
+}
+
+// NewRepeatFilter creates a RepeatFilter. A Limit below 1, which rules files
+// can't have, is 1.
+func NewRepeatFilter(limit RepeatLimit) *RepeatFilter {
+	if limit.Limit < 1 {
+		limit.Limit = 1
+	}
+	window := limit.Window.Duration
+	if window <= 0 {
+		window = DefaultRepeatWindow
+	}
+	notice := limit.Notice
+	if notice == "" {
+		notice = DefaultRepeatNotice
+	}
+	return &RepeatFilter{
+		limit:   limit.Limit,
+		window:  window,
+		notice:  notice,
+		entries: make(map[string]*repeatEntry),
+	}
+}
+
+// Window returns the window of the filter.
+func (f *RepeatFilter) Window() time.Duration {
+	return f.window
+}
+
+// Allow is called before relaying a message. Call Flush before relaying it, so
+// that the notices of windows that ended are sent first.
+//
+// Returns:
+//
+//	whether the message may be relayed
+func (f *RepeatFilter) Allow(message string, now time.Time) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.expire(now)
+	entry, ok := f.entries[message]
+	if !ok {
+		entry = &repeatEntry{message: message, start: now}
+		f.entries[message] = entry
+		f.queue = append(f.queue, entry)
+	}
+	entry.count++
+	if entry.count > f.limit {
+		entry.suppressed++
+		return false
+	}
+	return true
+}
+
+// Flush ends the windows that passed.
+//
+// Returns:
+//
+//	the notices of the windows in which messages were suppressed, e.g.
+//	"*Suppressed 1,284 duplicate lines in 60s*", oldest first
+func (f *RepeatFilter) Flush(now time.Time) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.expire(now)
+	notices := f.notices
+	f.notices = nil
+	return notices
+}
+
+// expire removes the windows that passed, keeping their notices. The caller
+// holds the mutex.
+func (f *RepeatFilter) expire(now time.Time) {
+	for len(f.queue) > 0 && !now.Before(f.queue[0].start.Add(f.window)) {
+		entry := f.queue[0]
+		f.queue = f.queue[1:]
+		delete(f.entries, entry.message)
+		if entry.suppressed > 0 {
+			f.notices = append(f.notices, expandParameters(f.notice, map[byte]string{
+				'#': formatCount(entry.suppressed),
+				'W': formatWindow(f.window),
+				'^': "^",
+			}))
+		}
+	}
+}
+
+// formatCount formats a number with thousands separators, e.g. "1,284".
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return sign + digits
+}
+
+// formatWindow formats a window in whole seconds, minutes or hours, e.g. "60s".
+func formatWindow(d time.Duration) string {
+	switch {
+	case d < 2*time.Minute || d%time.Minute != 0:
+		return fmt.Sprintf("%ds", int(d.Round(time.Second)/time.Second))
+	case d < 2*time.Hour || d%time.Hour != 0:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	default:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepeatFilter(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	filter := NewRepeatFilter(RepeatLimit{Limit: 2})
+	assert.Equal(t, DefaultRepeatWindow, filter.Window())
+
+	assert.True(t, filter.Allow("error", start))
+	assert.True(t, filter.Allow("error", start.Add(time.Second)))
+	assert.True(t, filter.Allow("other", start.Add(time.Second)))
+	for i := 0; i < 1284; i++ {
+		assert.False(t, filter.Allow("error", start.Add(2*time.Second)))
+	}
+	assert.Empty(t, filter.Flush(start.Add(59*time.Second)))
+
+	// The window of the first occurrence ended
+	assert.True(t, filter.Allow("error", start.Add(time.Minute)))
+	assert.Equal(t, []string{"*Suppressed 1,284 duplicate lines in 60s*"}, filter.Flush(start.Add(time.Minute)))
+	assert.Empty(t, filter.Flush(start.Add(time.Minute)), "notices are returned once")
+	assert.Empty(t, filter.Flush(start.Add(3*time.Minute)), "windows without suppressed messages have no notice")
+}
+
+func TestRepeatFilter_Notice(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	filter := NewRepeatFilter(RepeatLimit{Limit: 1, Window: ext.Duration{Duration: 5 * time.Minute}, Notice: "^# in ^W ^^"})
+	assert.True(t, filter.Allow("error", start))
+	assert.False(t, filter.Allow("error", start))
+	assert.Equal(t, []string{"1 in 5m ^"}, filter.Flush(start.Add(5*time.Minute)))
+}
+
+func TestRepeatLimitValidate(t *testing.T) {
+	tests := []struct {
+		repeats string
+		valid   bool
+	}{
+		{`{"Limit": 3}`, true},
+		{`{"Limit": 0}`, false},
+		{`{"Limit": -1}`, false},
+		{`{"Window": "1m"}`, false},
+	}
+	for i, test := range tests {
+		rules, err := ParseRules([]byte(`{"SubprocessToDiscord": [], "DiscordToSubprocess": [], "Repeats": ` + test.repeats + `}`))
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Equal(t, test.valid, rules.Validate() == nil, "Test #%v", i)
+	}
+
+	// A filter without a valid limit relays each message once per window.
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	filter := NewRepeatFilter(RepeatLimit{})
+	assert.True(t, filter.Allow("error", start))
+	assert.False(t, filter.Allow("error", start))
+}
+
+func TestFormatCount(t *testing.T) {
+	tests := []struct {
+		n        int
+		expected string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1284, "1,284"},
+		{1234567, "1,234,567"},
+		{-1284, "-1,284"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, formatCount(test.n), "Test #%v", i)
+	}
+}
+
+func TestFormatWindow(t *testing.T) {
+	tests := []struct {
+		window   time.Duration
+		expected string
+	}{
+		{30 * time.Second, "30s"},
+		{time.Minute, "60s"},
+		{90 * time.Second, "90s"},
+		{5 * time.Minute, "5m"},
+		{90 * time.Minute, "90m"},
+		{3 * time.Hour, "3h"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, formatWindow(test.window), "Test #%v", i)
+	}
+}
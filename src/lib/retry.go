@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides how often, and after how long, a failed operation such
+// as sending a Discord message is retried.
+type RetryPolicy struct {
+	MaxAttempts int           // Including the first attempt; values below 1 mean 1
+	BaseDelay   time.Duration // Delay before the first retry, doubled for every further retry
+	MaxDelay    time.Duration // Cap of the delay, 0 for no cap
+	random      func() float64
+}
+
+// Retry calls attempt until it succeeds, it fails with an error for which
+// transient returns false, or MaxAttempts attempts were made. Retries are
+// delayed with exponential backoff and jitter, see Delay.
+//
+// Returns:
+//
+//	the error of the last attempt, or nil if an attempt succeeded
+func (p RetryPolicy) Retry(attempt func() error, transient func(error) bool, sleep func(time.Duration)) error {
+	var err error
+	for i := 1; ; i++ {
+		if err = attempt(); err == nil || !transient(err) || i >= p.MaxAttempts {
+			return err
+		}
+		sleep(p.Delay(i))
+	}
+}
+
+// Delay returns the delay before a retry, after the given number of failed
+// attempts. The delay is randomized between half and all of the backoff, so that
+// several clients don't retry in lockstep.
+func (p RetryPolicy) Delay(failures int) time.Duration {
+	backoff := p.BaseDelay
+	for i := 1; i < failures && (p.MaxDelay == 0 || backoff < p.MaxDelay); i++ {
+		backoff *= 2
+	}
+	if p.MaxDelay > 0 && backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	random := p.random
+	if random == nil {
+		random = rand.Float64
+	}
+	return backoff/2 + time.Duration(random()*float64(backoff/2))
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, random: func() float64 { return 1 }}
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, policy.Delay(test.failures), "failures: %v", test.failures)
+	}
+	policy.random = func() float64 { return 0 }
+	assert.Equal(t, 500*time.Millisecond, policy.Delay(1))
+}
+
+func TestRetryPolicyRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+	tests := []struct {
+		errs     []error // Results of the attempts
+		attempts int
+		expected error
+	}{
+		{[]error{nil}, 1, nil},
+		{[]error{errTransient, nil}, 2, nil},
+		{[]error{errTransient, errTransient, errTransient, nil}, 3, errTransient},
+		{[]error{errPermanent, nil}, 1, errPermanent},
+	}
+	for i, test := range tests {
+		policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, random: func() float64 { return 1 }}
+		attempts := 0
+		var delays []time.Duration
+		err := policy.Retry(func() error {
+			attempts++
+			return test.errs[attempts-1]
+		}, func(err error) bool {
+			return err == errTransient
+		}, func(delay time.Duration) {
+			delays = append(delays, delay)
+		})
+		assert.Equal(t, test.expected, err, "Test #%v", i)
+		assert.Equal(t, test.attempts, attempts, "Test #%v", i)
+		assert.Len(t, delays, attempts-1, "Test #%v", i)
+	}
+}
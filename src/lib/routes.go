@@ -0,0 +1,119 @@
+package lib
+
+// This file implements the routing table of sinks, Rules.Routes: each route
+// publishes the matches of a direction, rule tag and exported variables to
+// named sinks, so that a match can go to several destinations, e.g. chat to an
+// IRC channel and deaths to a file. Sinks that no route names receive all
+// matches, as without routes.
+
+import (
+	"fmt"
+	"slices"
+)
+
+// CheckRoutes checks that the sinks have unique names, and that routes only
+// name declared sinks.
+//
+// Returns:
+//
+//	an error for each problem
+func (r *Rules) CheckRoutes() []error {
+	var errs []error
+	names := make(map[string]int)
+	for i, config := range r.Sinks {
+		if config.Name == "" {
+			continue
+		}
+		if first, ok := names[config.Name]; ok {
+			errs = append(errs, fmt.Errorf("sinks #%v and #%v are both named %q", first, i, config.Name))
+			continue
+		}
+		names[config.Name] = i
+	}
+	for i, route := range r.Routes {
+		if len(route.Sinks) == 0 {
+			errs = append(errs, fmt.Errorf("route #%v has no sinks", i))
+		}
+		for _, name := range route.Sinks {
+			if _, ok := names[name]; !ok {
+				errs = append(errs, fmt.Errorf("route #%v: no sink is named %q", i, name))
+			}
+		}
+	}
+	return errs
+}
+
+// RouteTable selects the sinks a match is published to, see Rules.Routes.
+type RouteTable struct {
+	routes   [][]int // Indexes of the sinks of each route, in Rules.Sinks
+	config   []SinkRoute
+	unrouted []int // Indexes of the sinks no route names
+}
+
+// NewRouteTable creates the route table of rules. Routes naming undeclared
+// sinks are ignored, see CheckRoutes.
+func NewRouteTable(rules *Rules) *RouteTable {
+	names := make(map[string]int)
+	for i, config := range rules.Sinks {
+		if _, ok := names[config.Name]; config.Name != "" && !ok {
+			names[config.Name] = i
+		}
+	}
+	t := &RouteTable{config: rules.Routes}
+	routed := make(map[int]bool)
+	for _, route := range rules.Routes {
+		var sinks []int
+		for _, name := range route.Sinks {
+			if index, ok := names[name]; ok {
+				sinks = append(sinks, index)
+				routed[index] = true
+			}
+		}
+		t.routes = append(t.routes, sinks)
+	}
+	for i := range rules.Sinks {
+		if !routed[i] {
+			t.unrouted = append(t.unrouted, i)
+		}
+	}
+	return t
+}
+
+// Sinks returns the sinks that a match is published to.
+//
+// Parameters:
+//
+//	direction: one of the Direction constants
+//	tags: the tags of the matching rule, see Rule.Tags
+//	vars: the variables exported by the matching rule, see Rule.Exports
+//
+// Returns:
+//
+//	the indexes of the sinks in Rules.Sinks, in order, each once
+func (t *RouteTable) Sinks(direction string, tags []string, vars map[string]string) []int {
+	sinks := slices.Clone(t.unrouted)
+	for i, route := range t.config {
+		if route.Direction != "" && route.Direction != direction {
+			continue
+		}
+		if route.Tag != "" && !slices.Contains(tags, route.Tag) {
+			continue
+		}
+		if !hasVars(vars, route.Vars) {
+			continue
+		}
+		sinks = append(sinks, t.routes[i]...)
+	}
+	slices.Sort(sinks)
+	return slices.Compact(sinks)
+}
+
+// hasVars reports whether vars has the values of expected.
+func hasVars(vars map[string]string, expected map[string]string) bool {
+	for name, value := range expected {
+		if actual, ok := vars[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteTable(t *testing.T) {
+	rules := &Rules{
+		Sinks: []SinkConfig{
+			{Type: SinkTypeMqtt, Url: "tcp://localhost:1883"},
+			{Type: SinkTypeIrc, Name: "irc", Url: "irc://localhost:6667", Topic: "#game"},
+			{Type: SinkTypeFile, Name: "deaths", Path: "deaths.jsonl"},
+			{Type: SinkTypeDiscord, Name: "staff", ChannelId: "123"},
+		},
+		Routes: []SinkRoute{
+			{Direction: DirectionSubprocessToDiscord, Tag: "chat", Sinks: []string{"irc"}},
+			{Direction: DirectionDiscordToSubprocess, Sinks: []string{"irc"}},
+			{Tag: "death", Sinks: []string{"deaths", "staff"}},
+			{Tag: "alert", Sinks: []string{"staff"}},
+			{Tag: "chat", Vars: map[string]string{"world": "nether"}, Sinks: []string{"deaths"}},
+		},
+	}
+	assert.Empty(t, rules.CheckRoutes())
+	table := NewRouteTable(rules)
+
+	assert.Equal(t, []int{0, 1}, table.Sinks(DirectionSubprocessToDiscord, []string{"chat"}, nil))
+	assert.Equal(t, []int{0, 1, 2}, table.Sinks(DirectionSubprocessToDiscord, []string{"chat"}, map[string]string{"world": "nether"}))
+	assert.Equal(t, []int{0, 1}, table.Sinks(DirectionSubprocessToDiscord, []string{"chat"}, map[string]string{"world": "overworld"}))
+	assert.Equal(t, []int{0, 1}, table.Sinks(DirectionDiscordToSubprocess, nil, nil))
+	assert.Equal(t, []int{0, 2, 3}, table.Sinks(DirectionSubprocessToDiscord, []string{"death", "alert"}, nil), "sinks are returned once")
+	assert.Equal(t, []int{0}, table.Sinks(DirectionSubprocessToDiscord, nil, nil), "unrouted sinks receive all matches")
+}
+
+func TestRouteTable_NoRoutes(t *testing.T) {
+	rules := &Rules{Sinks: []SinkConfig{{Type: SinkTypeMqtt}, {Type: SinkTypeNats}}}
+	assert.Equal(t, []int{0, 1}, NewRouteTable(rules).Sinks(DirectionSubprocessToDiscord, nil, nil))
+}
+
+func TestRules_CheckRoutes(t *testing.T) {
+	rules := &Rules{
+		Sinks: []SinkConfig{{Type: SinkTypeMqtt, Name: "a"}, {Type: SinkTypeNats, Name: "a"}},
+		Routes: []SinkRoute{
+			{Tag: "chat", Sinks: []string{"a", "b"}},
+			{Tag: "death"},
+		},
+	}
+	errs := rules.CheckRoutes()
+	if assert.Len(t, errs, 3) {
+		assert.Equal(t, `sinks #0 and #1 are both named "a"`, errs[0].Error())
+		assert.Equal(t, `route #0: no sink is named "b"`, errs[1].Error())
+		assert.Equal(t, "route #1 has no sinks", errs[2].Error())
+	}
+}
+
+func TestSinkConfigValidate(t *testing.T) {
+	tests := []struct {
+		sink  string
+		valid bool
+	}{
+		{`{"Type": "mqtt", "Url": "tcp://localhost:1883"}`, true},
+		{`{"Type": "mqtt"}`, false},
+		{`{"Type": "irc", "Url": "irc://localhost", "Topic": "#game"}`, true},
+		{`{"Type": "file", "Path": "events.jsonl"}`, true},
+		{`{"Type": "file"}`, false},
+		{`{"Type": "file", "Url": "events.jsonl"}`, false},
+		{`{"Type": "discord", "ChannelId": "123"}`, true},
+		{`{"Type": "discord"}`, false},
+		{`{"Type": "kafka", "Url": "tcp://localhost:9092"}`, false},
+	}
+	for i, test := range tests {
+		rules, err := ParseRules([]byte(`{"SubprocessToDiscord": [], "DiscordToSubprocess": [], "Sinks": [` + test.sink + `]}`))
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Equal(t, test.valid, rules.Validate() == nil, "Test #%v", i)
+	}
+}
@@ -0,0 +1,162 @@
+package lib
+
+// This file implements the documentation of a rules file, a table of the rules
+// of each direction for sharing with moderators, see "dgbridge docs-rules".
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// Formats of rule documentation, see FormatRuleDocs.
+const (
+	RuleDocsFormatMarkdown = "markdown"
+	RuleDocsFormatHtml     = "html"
+)
+
+// ruleDocsHtml is the template of HTML rule documentation.
+var ruleDocsHtml = template.Must(template.New("rules").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; vertical-align: top; }
+code { white-space: pre-wrap; }
+tr.disabled { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{- range .Sections}}
+<h2>{{.Direction}}</h2>
+<table>
+<tr><th>Rule</th><th>Description</th><th>Pattern</th><th>Template</th><th>Examples</th></tr>
+{{- range .Rules}}
+<tr{{if .Disabled}} class="disabled"{{end}}><td>{{.Name}}{{if .Disabled}} (disabled){{end}}</td><td>{{.Description}}</td><td><code>{{.Pattern}}</code></td><td>{{if .Passthrough}}<em>unchanged</em>{{else}}<code>{{.Template}}</code>{{end}}</td><td>
+{{- range $i, $example := .Examples}}{{if $i}}<br>{{end}}<code>{{.In}}</code> → {{if .Out}}<code>{{.Out}}</code>{{else}}<em>no match</em>{{end}}{{end -}}
+</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// ruleDocsSection is the rules of a direction, formatted for documentation.
+type ruleDocsSection struct {
+	Direction string
+	Rules     []ruleDoc
+}
+
+// ruleDoc is a Rule formatted for documentation.
+type ruleDoc struct {
+	Name        string // See RuleId
+	Description string
+	Pattern     string
+	Template    string
+	Passthrough bool
+	Disabled    bool
+	Examples    []RuleExample
+}
+
+// FormatRuleDocs formats a table of the rules of each direction that has any.
+//
+// Parameters:
+//
+//	format: one of the RuleDocsFormat constants
+//	title: title of the documentation, e.g. the name of the rules file
+func FormatRuleDocs(rules *Rules, format string, title string) ([]byte, error) {
+	var sections []ruleDocsSection
+	for _, direction := range RuleDirections {
+		list := rules.List(direction)
+		if len(list) == 0 {
+			continue
+		}
+		section := ruleDocsSection{Direction: direction}
+		for i := range list {
+			rule := &list[i]
+			section.Rules = append(section.Rules, ruleDoc{
+				Name:        RuleId(rule, i),
+				Description: rule.Description,
+				Pattern:     rule.Match.String(),
+				Template:    rule.Template,
+				Passthrough: rule.Passthrough,
+				Disabled:    !rule.IsEnabled(),
+				Examples:    rule.Examples,
+			})
+		}
+		sections = append(sections, section)
+	}
+	var buffer bytes.Buffer
+	switch format {
+	case RuleDocsFormatMarkdown:
+		buffer.WriteString("# " + title + "\n")
+		for _, section := range sections {
+			fmt.Fprintf(&buffer, "\n## %v\n\n", section.Direction)
+			buffer.WriteString("| Rule | Description | Pattern | Template | Examples |\n")
+			buffer.WriteString("| --- | --- | --- | --- | --- |\n")
+			for _, rule := range section.Rules {
+				name := markdownCell(rule.Name)
+				if rule.Disabled {
+					name += " (disabled)"
+				}
+				template := "*unchanged*"
+				if !rule.Passthrough {
+					template = markdownCode(rule.Template)
+				}
+				examples := make([]string, len(rule.Examples))
+				for i, example := range rule.Examples {
+					out := "*no match*"
+					if example.Out != "" {
+						out = markdownCode(example.Out)
+					}
+					examples[i] = markdownCode(example.In) + " → " + out
+				}
+				fmt.Fprintf(&buffer, "| %v | %v | %v | %v | %v |\n",
+					name, markdownCell(rule.Description), markdownCode(rule.Pattern), template, strings.Join(examples, "<br>"))
+			}
+		}
+	case RuleDocsFormatHtml:
+		err := ruleDocsHtml.Execute(&buffer, struct {
+			Title    string
+			Sections []ruleDocsSection
+		}{title, sections})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown rule docs format %q", format)
+	}
+	return buffer.Bytes(), nil
+}
+
+// markdownCell escapes text for a cell of a Markdown table, which is a single
+// line.
+func markdownCell(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`", "<", "&lt;").Replace(s)
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+// markdownCode formats text as a code span in a cell of a Markdown table.
+// Newlines are shown as \n, as code spans are a single line.
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	// Pipes end the cell even in code spans, unless they are escaped.
+	s = strings.ReplaceAll(s, "|", `\|`)
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatRuleDocs(t *testing.T) {
+	disabled := false
+	rules := &Rules{
+		SubprocessToDiscord: []Rule{
+			{
+				Name:        "chat",
+				Description: "Chat | messages",
+				Match:       ext.Regexp{Regexp: regexp.MustCompile(`<(\w+)> (.*)`)},
+				Template:    "**${1}**: ${2}",
+				Examples:    []RuleExample{{In: "<Steve> hi", Out: "**Steve**: hi"}, {In: "Steve joined"}},
+			},
+			{Match: ext.Regexp{Regexp: regexp.MustCompile(`a|b`)}, Passthrough: true, Enabled: &disabled},
+		},
+	}
+
+	markdown, err := FormatRuleDocs(rules, RuleDocsFormatMarkdown, "Rules")
+	assert.NoError(t, err)
+	assert.Equal(t, "# Rules\n"+
+		"\n## SubprocessToDiscord\n\n"+
+		"| Rule | Description | Pattern | Template | Examples |\n"+
+		"| --- | --- | --- | --- | --- |\n"+
+		"| chat | Chat \\| messages | `<(\\w+)> (.*)` | `**${1}**: ${2}` | `<Steve> hi` → `**Steve**: hi`<br>`Steve joined` → *no match* |\n"+
+		"| #1 (disabled) |  | `a\\|b` | *unchanged* |  |\n", string(markdown))
+
+	html, err := FormatRuleDocs(rules, RuleDocsFormatHtml, "Rules")
+	assert.NoError(t, err)
+	assert.Contains(t, string(html), "<h2>SubprocessToDiscord</h2>")
+	assert.Contains(t, string(html), "<td><code>&lt;(\\w&#43;)&gt; (.*)</code></td>")
+	assert.Contains(t, string(html), `<tr class="disabled"><td>#1 (disabled)</td>`)
+
+	_, err = FormatRuleDocs(rules, "pdf", "Rules")
+	assert.Error(t, err)
+}
+
+func TestMarkdownCode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"say hi", "`say hi`"},
+		{"a `b` c", "``a `b` c``"},
+		{"`quoted`", "`` `quoted` ``"},
+		{"line\nbreak", "`line\\nbreak`"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, markdownCode(tt.input))
+	}
+}
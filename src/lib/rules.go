@@ -3,39 +3,268 @@ package lib
 import (
 	"dgbridge/src/ext"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
 )
 
 // Added regex to strip ANSI color codes
 var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*m`)
 
+// StripAnsi removes ANSI color codes from a line.
+func StripAnsi(line string) string {
+	return ansiRegex.ReplaceAllString(line, "")
+}
+
+// Directions in which messages are relayed, named like the rule lists in Rules.
+const (
+	DirectionSubprocessToDiscord = "SubprocessToDiscord"
+	DirectionDiscordToSubprocess = "DiscordToSubprocess"
+	DirectionSubprocessToPeer    = "SubprocessToPeer"
+	DirectionPeerToSubprocess    = "PeerToSubprocess"
+	DirectionBackfill            = "Backfill" // Server log lines read on startup, see ReadLastLines
+)
+
+// RuleDirections are the directions that have a rule list in Rules.
+var RuleDirections = []string{
+	DirectionSubprocessToDiscord,
+	DirectionDiscordToSubprocess,
+	DirectionSubprocessToPeer,
+	DirectionPeerToSubprocess,
+	DirectionBackfill,
+}
+
+// Types of Discord messages, see Props.MessageType.
+const (
+	MessageTypeMessage      = "message"
+	MessageTypePoll         = "poll"
+	MessageTypeAnnouncement = "announcement" // Crossposted from a followed announcement channel
+)
+
+// Types of message authors, see Author.Type.
+const (
+	AuthorTypeUser    = "user"
+	AuthorTypeBot     = "bot"     // Bot accounts, e.g. other bridges or moderation bots
+	AuthorTypeWebhook = "webhook" // Webhooks, e.g. crossposted announcements or integrations
+)
+
+// Types of SinkConfig.
+const (
+	SinkTypeMqtt    = "mqtt"
+	SinkTypeNats    = "nats"
+	SinkTypeRedis   = "redis"
+	SinkTypeFile    = "file"    // Appends events to a file as JSON lines
+	SinkTypeWebhook = "webhook" // POSTs events to a URL as JSON
+	SinkTypeDiscord = "discord" // Sends the results to another Discord channel
+	SinkTypeIrc     = "irc"     // Sends the results to an IRC channel
+)
+
+// ExportPlayer is the variable a rule exports as the player a line is about,
+// if it has no Player template, see Rule.Exports.
+const ExportPlayer = "player"
+
 type (
 	Rules struct {
-		DiscordToSubprocess []Rule `validate:"required"`
-		SubprocessToDiscord []Rule `validate:"required"`
+		Version             int          // Schema version, see CurrentRulesVersion and MigrateRules
+		DiscordToSubprocess []Rule       `validate:"required,dive"`
+		SubprocessToDiscord []Rule       `validate:"required,dive"`
+		SubprocessToPeer    []Rule       // Optional, lines sent to federated bridges
+		PeerToSubprocess    []Rule       // Optional, messages received from federated bridges
+		Backfill            []Rule       // Optional, server log lines relayed on startup
+		Sinks               []SinkConfig `validate:"dive"` // Optional destinations for matched messages
+		// Optional, the sinks that matches are published to by direction and
+		// rule tag; sinks no route names receive all matches, see RouteTable
+		Routes []SinkRoute `validate:"dive"`
+		Filter *Filter     // Optional content filter
+		// Optional rate limits by direction
+		RateLimits map[string]RateLimit `validate:"dive,keys,oneof=SubprocessToDiscord DiscordToSubprocess,endkeys"`
+		// Optional, lines marking changes of the subprocess' state, see
+		// Rule.State
+		States *ProcessStates
+		// Optional, console commands run by the moderation slash commands
+		Workflows *Workflows
+		// Optional, linking Discord accounts to in-game names with /link
+		Link *LinkConfig
+		// Optional, suppresses identical messages to Discord that repeat over
+		// a limit, see RepeatFilter
+		Repeats *RepeatLimit
+		// Optional, the subprocess lines that are JSON objects, whose fields
+		// templates insert with ${json:field}
+		JsonLines *JsonLines
+		// Optional, who may approve the results of rules with
+		// RequireApproval
+		Approvals *ApprovalConfig
+		// Optional, the control panel message with buttons for common server
+		// actions
+		Panel *PanelConfig
+		// Optional, modals opened with a slash command or a panel button,
+		// whose fields are inserted into a console command
+		Forms []Form `validate:"dive"`
 	}
 	Rule struct {
-		Match    ext.Regexp `validate:"required"`
-		Template string     `validate:"required"`
+		Name  string     // Optional, identifies the rule in logs, sinks and tests, see RuleMatch.RuleId
+		Match ext.Regexp `validate:"required"`
+		// Required unless Passthrough is set
+		Template string `validate:"required_without=Passthrough,excluded_with=Passthrough"`
+		// Relay the matched line unchanged instead of building a template
+		Passthrough bool
+		NoFilter    bool // Don't apply the content filter to the result
+		// Optional, defaults to true; disabled rules are skipped, see IsEnabled
+		Enabled     *bool
+		Description string        // Optional, documents the rule's intent
+		Thread      *ThreadConfig // Optional, opens a thread for the incident the rule matched
+		Event       *EventConfig  // Optional, creates a Discord scheduled event
+		// Optional, applies the template to every match of Match and joins the
+		// results with Joiner, dropping the text between matches, see
+		// expandEach; e.g. for lines listing several players
+		Repeat   bool
+		Joiner   string
+		Critical bool // The result is also sent to the alert users as a direct message
+		// Optional, template of the in-game player the line is about, e.g.
+		// "${1}"; the rule's template is built with the player's Props, see
+		// Rules.MatchPlayer
+		Player string
+		// Optional, SubprocessToDiscord: template of the number of online
+		// players the line reports, e.g. "${1}" for "There are 3 of a max of
+		// 20 players online"; see DailyStats
+		Players string
+		// Optional, DiscordToSubprocess: template of the name of the subprocess
+		// instance the result is written to, e.g. "lobby"; defaults to all
+		// instances, see InstanceName
+		Instance string
+		// Optional, SubprocessToDiscord: RuleActionRelay (default) or
+		// RuleActionSummarize, see StartupSummary
+		Action string `validate:"omitempty,oneof=relay summarize"`
+		// Summary of the matches counted by the summarize action, ^# is the
+		// count, e.g. "Loaded ^# plugins"
+		Summary string `validate:"required_if=Action summarize"`
+		// Optional, DiscordToSubprocess: the type of Discord messages the rule
+		// applies to, one of the MessageType constants; defaults to all, see
+		// AppliesTo
+		MessageType string `validate:"omitempty,oneof=message poll announcement"`
+		// Optional, DiscordToSubprocess: the type of authors the rule applies
+		// to, one of the AuthorType constants; defaults to all, see AppliesTo
+		AuthorType string `validate:"omitempty,oneof=user bot webhook"`
+		// Optional, DiscordToSubprocess: conditions on the author, checked
+		// before Match, see AppliesTo
+		Author *AuthorCondition
+		// Optional, SubprocessToDiscord: the stream of the lines the rule
+		// applies to, one of the Source constants; defaults to both
+		Source string `validate:"omitempty,oneof=stdout stderr"`
+		// Optional, SubprocessToDiscord: the state of the subprocess the rule
+		// applies in, one of the ProcessState constants; defaults to all, see
+		// Rules.States
+		State string `validate:"omitempty,oneof=starting ready stopping"`
+		// Optional, inputs and the results the rule is expected to produce,
+		// see CheckExamples
+		Examples []RuleExample `validate:"dive"`
+		// Optional, tags that select the sinks the rule's matches are
+		// published to, see Rules.Routes
+		Tags []string
+		// Optional, variables extracted from the matched line for later
+		// stages, by name, e.g. {"world": "${2}"}; see RuleMatch.Vars
+		Exports map[string]string `validate:"dive,keys,required,endkeys,required"`
+		// Optional, DiscordToSubprocess: the result is only written to the
+		// subprocess once a moderator approved it, see Rules.Approvals
+		RequireApproval bool
+		// Optional, the locale of the thousand and decimal separators of
+		// ${1|num}, defaults to --locale; see valueFormat
+		Locale string `validate:"omitempty,oneof=de en es fr pt"`
+		// Optional, the Go layout of the timestamps inserted with ${1|time}
+		// that aren't Unix times, e.g. "2006-01-02 15:04:05"
+		TimeLayout string
+		// Optional, the IANA time zone of TimeLayout, e.g. "Europe/Berlin";
+		// defaults to the local time zone
+		TimeZone string `validate:"omitempty,timezone"`
+
+		location *time.Location // TimeZone, loaded by compile; nil for the local time zone
+	}
+	// SinkConfig declares a destination that matched messages are published
+	// to, such as a message broker.
+	SinkConfig struct {
+		Type string `validate:"required,oneof=mqtt nats redis file webhook discord irc"`
+		Name string // Optional, names the sink in Rules.Routes
+		// Broker URL, credentials may be included; URL of a webhook; or IRC
+		// server, e.g. ircs://irc.libera.chat:6697
+		Url       string `validate:"required_unless=Type file Type discord"`
+		Topic     string // MQTT topic prefix, NATS subject prefix, Redis stream key, or IRC channel
+		ClientId  string // MQTT client ID, or IRC nickname
+		Path      string `validate:"required_if=Type file"`    // File that events are appended to
+		ChannelId string `validate:"required_if=Type discord"` // Discord channel that results are sent to
+	}
+	// SinkRoute publishes the matches of a direction and rule tag to sinks,
+	// see Rules.Routes.
+	SinkRoute struct {
+		Direction string // Optional, one of the Direction constants; defaults to all
+		Tag       string // Optional, matches of rules with this tag, see Rule.Tags; defaults to all
+		// Optional, values that the variables exported by the rule must
+		// have, see Rule.Exports
+		Vars  map[string]string
+		Sinks []string `validate:"required,min=1"` // Names of the sinks, see SinkConfig.Name
 	}
 )
 
 type (
 	Props struct {
-		Author Author `validate:"required"`
+		Author   Author `validate:"required"`
+		Instance string // Name of the subprocess instance a line is from, may be empty
+		// Type of the Discord message, one of the MessageType constants; empty
+		// is MessageTypeMessage
+		MessageType string
+		Poll        *Poll  // Set if the message is a poll
+		RawContent  string // Content of the Discord message before NormalizeContent
+	}
+	// Poll is a Discord poll.
+	Poll struct {
+		Question string
+		Answers  []string
 	}
 	Author struct {
+		Id            string // Discord user ID, might not be set in tests
 		Username      string `validate:"required"`
 		Nickname      string // Nickname might not be set
 		GlobalName    string // GlobalName might not be set
 		Discriminator string `validate:"required"`
 		AccentColor   int    `validate:"required"`
+		IsBot         bool   // The author is a bot account
+		IsWebhook     bool   // The message was sent by a webhook, which has no user account
+		Roles         []Role // The author's roles in the guild, might not be set
+	}
+	// Role is a Discord role.
+	Role struct {
+		Id   string
+		Name string
 	}
 )
 
+// Mention returns a Discord mention of the author. The mention only contains the
+// numeric user ID, so it is safe to use even if the author's names contain
+// markup. Falls back to Username if the ID is not set or invalid, or is the ID
+// of a webhook, which can't be mentioned.
+func (a Author) Mention() string {
+	if !IsSnowflake(a.Id) || a.IsWebhook {
+		return a.Username
+	}
+	return "<@" + a.Id + ">"
+}
+
+// Type returns the type of the author, one of the AuthorType constants.
+func (a Author) Type() string {
+	switch {
+	case a.IsWebhook:
+		return AuthorTypeWebhook
+	case a.IsBot:
+		return AuthorTypeBot
+	default:
+		return AuthorTypeUser
+	}
+}
 
 // LoadRules loads a set of rules from a JSON file.
 func LoadRules(path string) (*Rules, error) {
@@ -43,99 +272,359 @@ func LoadRules(path string) (*Rules, error) {
 	if err != nil {
 		return nil, err
 	}
+	return ParseRules(fileContents)
+}
+
+// ParseRules parses a set of rules in the format of a rules file, see
+// LoadRules.
+func ParseRules(fileContents []byte) (*Rules, error) {
+	fileContents, _, err := MigrateRules(fileContents)
+	if err != nil {
+		return nil, err
+	}
 	var rules Rules
 	err = json.Unmarshal(fileContents, &rules)
 	if err != nil {
 		return nil, err
 	}
+	if rules.Filter != nil {
+		rules.Filter.compile()
+	}
+	for _, direction := range RuleDirections {
+		list := rules.List(direction)
+		for i := range list {
+			if err := list[i].compile(); err != nil {
+				return nil, fmt.Errorf("%v: rule %v: %v", direction, RuleId(&list[i], i), err)
+			}
+		}
+	}
 	return &rules, err
 }
 
+// Match applies the rules of a direction to a message, like MatchRules, then
+// applies the content filter to the result.
+//
+// Parameters:
+//
+//	direction: one of the Direction constants
+//	props: see MatchRules
+func (r *Rules) Match(direction string, props *Props, input string) *RuleMatch {
+	match := MatchRules(r.List(direction), props, input)
+	if match == nil {
+		return nil
+	}
+	r.filter(direction, match)
+	return match
+}
+
+// PropsResolver returns the Props of an in-game player, e.g. those of the
+// Discord user the player is mapped to.
+type PropsResolver func(player string) Props
+
+// MatchPlayer applies the rules of a direction to a subprocess line, like
+// Match, skipping rules for another stream or state of the subprocess. If the
+// matching rule has a Player template, the rule's template is built with the
+// Props of that player, e.g. ^C turns into the player's role color. If the
+// line is from a named subprocess instance, the template is built with the
+// instance name, see ^P.
+//
+// Parameters:
+//
+//	direction: one of the Direction constants
+//	info: where the line came from
+//	resolve: returns the Props of a player, may be nil
+func (r *Rules) MatchPlayer(direction string, info LineInfo, input string, resolve PropsResolver) *RuleMatch {
+	instance := info.Instance
+	info.Json = r.JsonLines.Parse(info, input)
+	match := matchRules(r.List(direction), nil, input, info)
+	if match == nil {
+		return nil
+	}
+	var props *Props
+	if match.hasPlayer() && resolve != nil {
+		resolved := resolve(match.Player(input))
+		props = &resolved
+	} else if instance != "" {
+		props = &Props{}
+	}
+	if props != nil {
+		props.Instance = instance
+		match.Result = ansiRegex.ReplaceAllString(applyRule(*match.Rule, props, input, match.Fields), "")
+	}
+	r.filter(direction, match)
+	return match
+}
+
+// Player returns the in-game name of the player a match is about, see
+// Rule.Player, or "" if the rule has no Player template. Without one, the
+// variable "player" exported by the rule is the player, see Rule.Exports.
+func (m *RuleMatch) Player(input string) string {
+	if m.Rule.Player == "" {
+		return m.Vars[ExportPlayer]
+	}
+	return StripAnsi(m.expand(input, m.Rule.Player))
+}
+
+// hasPlayer reports whether a match is about a player, see Player.
+func (m *RuleMatch) hasPlayer() bool {
+	_, ok := m.Rule.Exports[ExportPlayer]
+	return m.Rule.Player != "" || ok
+}
+
+// exports returns the variables exported by the rule of a match, see
+// Rule.Exports.
+func (m *RuleMatch) exports(input string) map[string]string {
+	if len(m.Rule.Exports) == 0 {
+		return nil
+	}
+	vars := make(map[string]string, len(m.Rule.Exports))
+	for name, template := range m.Rule.Exports {
+		vars[name] = strings.TrimSpace(StripAnsi(m.expand(input, template)))
+	}
+	return vars
+}
+
+// PlayerCount returns the number of online players a match reports, see
+// Rule.Players.
+//
+// Returns:
+//
+//	the number, and false if the rule has no Players template or it isn't
+//	a number
+func (m *RuleMatch) PlayerCount(input string) (int, bool) {
+	if m.Rule.Players == "" {
+		return 0, false
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(StripAnsi(m.expand(input, m.Rule.Players))))
+	if err != nil || count < 0 {
+		return 0, false
+	}
+	return count, true
+}
+
+// expand expands a template with the capture groups of the rule's first match
+// of a line.
+func (m *RuleMatch) expand(input string, template string) string {
+	input = strings.ReplaceAll(input, "\n", " ")
+	indices := m.Rule.Match.FindStringSubmatchIndex(input)
+	return string(m.Rule.Match.ExpandString(nil, expandJsonFields(template, m.Fields), input, indices))
+}
+
+// InstanceName returns the name of the subprocess instance the result of a
+// DiscordToSubprocess match is written to, or "" for all instances.
+func (m *RuleMatch) InstanceName(input string) string {
+	if m.Rule.Instance == "" {
+		return ""
+	}
+	return strings.TrimSpace(m.expand(input, m.Rule.Instance))
+}
+
+// filter applies the content filter to the result of a match, unless the rule
+// or direction is exempt.
+func (r *Rules) filter(direction string, match *RuleMatch) {
+	if r.Filter != nil && !match.Rule.NoFilter && r.Filter.AppliesTo(direction) {
+		match.Result = r.Filter.Apply(match.Result)
+	}
+}
+
+// List returns the rules of a direction.
+func (r *Rules) List(direction string) []Rule {
+	switch direction {
+	case DirectionSubprocessToDiscord:
+		return r.SubprocessToDiscord
+	case DirectionDiscordToSubprocess:
+		return r.DiscordToSubprocess
+	case DirectionSubprocessToPeer:
+		return r.SubprocessToPeer
+	case DirectionPeerToSubprocess:
+		return r.PeerToSubprocess
+	case DirectionBackfill:
+		return r.Backfill
+	}
+	return nil
+}
+
+// DisabledMatches returns the IDs (see RuleId) of the disabled rules of a
+// direction that match the input, e.g. to report them in tests.
+func (r *Rules) DisabledMatches(direction string, input string) []string {
+	var ids []string
+	input = strings.ReplaceAll(input, "\n", " ")
+	rules := r.List(direction)
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.IsEnabled() && rule.Match.MatchString(input) {
+			ids = append(ids, RuleId(rule, i))
+		}
+	}
+	return ids
+}
+
+// IsEnabled reports whether the rule is enabled. Rules are enabled unless
+// Enabled is set to false.
+func (rule *Rule) IsEnabled() bool {
+	return rule.Enabled == nil || *rule.Enabled
+}
+
+// AppliesTo reports whether the rule applies to a Discord message of the given
+// Props, see MessageType, AuthorType and Author. Rules apply to all subprocess
+// lines (nil props).
+func (rule *Rule) AppliesTo(props *Props) bool {
+	if props == nil {
+		return true
+	}
+	if rule.MessageType != "" {
+		messageType := props.MessageType
+		if messageType == "" {
+			messageType = MessageTypeMessage
+		}
+		if rule.MessageType != messageType {
+			return false
+		}
+	}
+	if rule.AuthorType != "" && rule.AuthorType != props.Author.Type() {
+		return false
+	}
+	return rule.Author == nil || rule.Author.Matches(props.Author)
+}
+
+// RuleMatch describes which rule produced the result of MatchRules.
+type RuleMatch struct {
+	Index  int        // Index of the rule in the rule list
+	Rule   *Rule      // The rule that matched
+	Groups []string   // Capture groups of the first match, group 0 is the whole match
+	Result string     // Result of applying the rule
+	Fields JsonFields // Fields of the matched JSON line, nil if it isn't one, see JsonLines
+	// Variables exported by the rule, see Rule.Exports; nil if it exports
+	// none
+	Vars map[string]string
+}
+
+// RuleId returns the rule's name, or its index (e.g. "#2") if it has no name.
+func (m *RuleMatch) RuleId() string {
+	return RuleId(m.Rule, m.Index)
+}
+
+// RuleId returns the name of a rule, or its index (e.g. "#2") if it has no
+// name.
+func RuleId(rule *Rule, index int) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return "#" + strconv.Itoa(index)
+}
+
+// Validate checks the rules against their validate tags, e.g. that rate limits
+// have a positive Rate, and that rule names are unique, see CheckRuleNames.
+func (r *Rules) Validate() error {
+	if err := validator.New().Struct(r); err != nil {
+		return err
+	}
+	return errors.Join(r.CheckRuleNames()...)
+}
+
+// CheckRuleNames checks that no two rules of a direction have the same name.
+//
+// Returns:
+//
+//	an error for each duplicate name
+func (r *Rules) CheckRuleNames() []error {
+	var errs []error
+	lists := []struct {
+		direction string
+		rules     []Rule
+	}{
+		{DirectionSubprocessToDiscord, r.SubprocessToDiscord},
+		{DirectionDiscordToSubprocess, r.DiscordToSubprocess},
+		{DirectionSubprocessToPeer, r.SubprocessToPeer},
+		{DirectionPeerToSubprocess, r.PeerToSubprocess},
+		{DirectionBackfill, r.Backfill},
+	}
+	for _, list := range lists {
+		seen := make(map[string]int)
+		for i, rule := range list.rules {
+			if rule.Name == "" {
+				continue
+			}
+			if first, ok := seen[rule.Name]; ok {
+				errs = append(errs, fmt.Errorf("%v: rules #%v and #%v are both named %q", list.direction, first, i, rule.Name))
+				continue
+			}
+			seen[rule.Name] = i
+		}
+	}
+	return errs
+}
+
 // ApplyRules applies rules to a string.
 // If props are provided, a matching template will be built using those props.
 func ApplyRules(rules []Rule, props *Props, input string) string {
-	for _, rule := range rules {
-		result := ApplyRule(rule, props, input)
+	match := MatchRules(rules, props, input)
+	if match == nil {
+		return ""
+	}
+	return match.Result
+}
+
+// MatchRules applies rules to a string like ApplyRules, and also reports which
+// rule produced the result.
+//
+// Returns nil if no rule matched.
+func MatchRules(rules []Rule, props *Props, input string) *RuleMatch {
+	return matchRules(rules, props, input, LineInfo{})
+}
+
+// matchRules is MatchRules for a subprocess line, see Rule.appliesToLine.
+func matchRules(rules []Rule, props *Props, input string, info LineInfo) *RuleMatch {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.IsEnabled() || !rule.AppliesTo(props) || !rule.appliesToLine(info) {
+			continue
+		}
+		result := applyRule(*rule, props, input, info.Json)
 		if result != "" {
 			// Strip ANSI color codes from the line before sending it to Discord
 			// This is necessary to avoid sending raw ANSI codes to Discord, which are
 			// ugly, but still allows the subprocess to use colors and the rules to match
 			// using ANSI codes.
 			result = ansiRegex.ReplaceAllString(result, "")
-			return result
+			match := &RuleMatch{
+				Index:  i,
+				Rule:   rule,
+				Groups: rule.Match.FindStringSubmatch(strings.ReplaceAll(input, "\n", " ")),
+				Result: result,
+				Fields: info.Json,
+			}
+			match.Vars = match.exports(input)
+			return match
 		}
 	}
-	return ""
+	return nil
 }
 
 // ApplyRule applies a rule to a given input string if it matches.
 //
 // Parameters:
-// props: If passed, the Rule's template is built with the given Props.
+// props: If passed, the Rule's template is built with the given Props, see
+// buildTemplate.
 func ApplyRule(rule Rule, props *Props, input string) string {
+	return applyRule(rule, props, input, nil)
+}
+
+// applyRule is ApplyRule for a line that may be a JSON line, see JsonLines.
+func applyRule(rule Rule, props *Props, input string, fields JsonFields) string {
 	// Remove newlines from input and replace them with spaces
 	input = strings.ReplaceAll(input, "\n", " ")
 
 	if rule.Match.MatchString(input) {
-		if props == nil {
-			return rule.Match.ReplaceAllString(input, rule.Template)
+		if rule.Passthrough {
+			return input
 		}
-		return rule.Match.ReplaceAllString(input, buildTemplate(rule.Template, *props))
-	}
-	return ""
-}
-
-// Builds a rule template for Discord -> Process communication.
-// It replaces all special combinations in the template with their corresponding properties.
-//
-// Example:
-//   - ^U turns into Username
-//   - ^T turns into Discriminator
-//   - ^C turns into RoleColor/AccentColor
-//   - ^N turns into Nickname (or Username if Nickname is not set)
-//
-// Returns template with Props applied.
-func buildTemplate(template string, props Props) string {
-	var result []rune
-	runes := []rune(template)
-	for i := 0; i < len(runes); i++ {
-		currentRune := runes[i]
-		if currentRune == '^' && i+1 < len(template) {
-			switch template[i+1] {
-			case '^':
-				// This is an escaped ^
-				result = append(result, '^')
-				i++
-				continue
-			case 'U':
-				if props.Author.GlobalName != "" {
-					result = append(result, []rune(props.Author.GlobalName)...)
-				} else {
-					result = append(result, []rune(props.Author.Username)...)
-				}
-				i++
-				continue
-			case 'T':
-				result = append(result, []rune(props.Author.Discriminator)...)
-				i++
-				continue
-			case 'C':
-				result = append(result, []rune(strconv.FormatInt(int64(props.Author.AccentColor), 16))...)
-				i++
-				continue
-			case 'N':
-				if props.Author.Nickname != "" {
-					result = append(result, []rune(props.Author.Nickname)...)
-				} else if props.Author.GlobalName != "" {
-					result = append(result, []rune(props.Author.GlobalName)...)
-				} else {
-					result = append(result, []rune(props.Author.Username)...)
-				}
-				i++
-				continue
-			}
+		template := expandTokens(rule.Template, RuleContext{Rule: &rule, Input: input, Props: props})
+		template = expandJsonFields(template, fields)
+		if rule.Repeat {
+			return expandEach(rule.Match.Regexp, input, template, rule.Joiner, rule.valueFormat())
 		}
-		result = append(result, currentRune)
+		return expandTemplate(rule.Match.Regexp, input, template, rule.valueFormat())
 	}
-	return string(result)
+	return ""
 }
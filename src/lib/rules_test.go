@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -26,6 +27,36 @@ func TestBuildTemplate(t *testing.T) {
 			Input:  "<^U#^T> ${1} ^^ ^A ^C ^N",
 			Expect: "<Bob^T#1337> ${1} ^ ^A ffff00 bobby",
 		},
+		{
+			Name: "User ID and mention",
+			Props: Props{
+				Author: Author{
+					Id:       "123456789012345678",
+					Username: "Bob",
+				},
+			},
+			Input:  "^I ^M",
+			Expect: "123456789012345678 <@123456789012345678>",
+		},
+		{
+			Name: "Mention without ID",
+			Props: Props{
+				Author: Author{
+					Username: "Bob",
+				},
+			},
+			Input:  "[^I] ^M",
+			Expect: "[] Bob",
+		},
+		{
+			Name: "Raw content",
+			Props: Props{
+				Author:     Author{Username: "Bob"},
+				RawContent: "<@123456789012345678> costs $5",
+			},
+			Input:  "^U: ^R",
+			Expect: "Bob: <@123456789012345678> costs $$5",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
@@ -34,3 +65,478 @@ func TestBuildTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyRuleArithmetic(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Match    string
+		Template string
+		Input    string
+		Expect   string
+	}{
+		{
+			Name:     "Ticks to seconds",
+			Match:    `^Tick took (\d+) ticks$`,
+			Template: "Tick took ${1/20}s",
+			Input:    "Tick took 50 ticks",
+			Expect:   "Tick took 2.5s",
+		},
+		{
+			Name:     "Bytes to megabytes with format",
+			Match:    `^Memory: (?P<bytes>\d+)$`,
+			Template: "Memory: ${bytes/1048576|%.1f} MB",
+			Input:    "Memory: 3145728",
+			Expect:   "Memory: 3.0 MB",
+		},
+		{
+			Name:     "Percentage",
+			Match:    `^Load (\S+)$`,
+			Template: "Load ${1*100|%.0f%%}",
+			Input:    "Load 0.456",
+			Expect:   "Load 46%",
+		},
+		{
+			Name:     "Integer coercion",
+			Match:    `^TPS: (\S+)$`,
+			Template: "TPS ${1|%d} (${1})",
+			Input:    "TPS: 19.7",
+			Expect:   "TPS 20 (19.7)",
+		},
+		{
+			Name:     "Addition",
+			Match:    `^Player (\d+)$`,
+			Template: "Player #${1+1}",
+			Input:    "Player 0",
+			Expect:   "Player #1",
+		},
+		{
+			Name:     "Non-numeric capture is inserted unchanged",
+			Match:    `^Value (\S+)$`,
+			Template: "Value ${1*2}",
+			Input:    "Value abc",
+			Expect:   "Value abc",
+		},
+		{
+			Name:     "Division by zero inserts capture unchanged",
+			Match:    `^Value (\S+)$`,
+			Template: "Value ${1/0}",
+			Input:    "Value 5",
+			Expect:   "Value 5",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			rule := Rule{Template: test.Template}
+			assert.NoError(t, rule.Match.UnmarshalText([]byte(test.Match)))
+			assert.Equal(t, test.Expect, ApplyRule(rule, nil, test.Input))
+		})
+	}
+}
+
+func TestParseRulesNumberFormat(t *testing.T) {
+	tests := []struct {
+		template string
+		isError  bool
+	}{
+		{"${1/20|%.1f}s", false},
+		{"${1*100|%5.0f%%}", false},
+		{"${1|%e} ${1|%g}", false},
+		{"${1|%d}", false},
+		{"${1|num:1} ${1|time:R}", false},
+		{"${1|%s}", true},
+		{"${1|%v}", true},
+		{"${1|%q}", true},
+		{"${1|%f %f}", true},
+		{"${1|seconds}", true},
+		{"${1|%*f}", true},
+	}
+	for i, test := range tests {
+		contents, err := json.Marshal(map[string]any{
+			"SubprocessToDiscord": []map[string]string{{"Match": `^(\S+)$`, "Template": test.template}},
+			"DiscordToSubprocess": []any{},
+		})
+		assert.NoError(t, err, "Test #%v", i)
+		_, err = ParseRules(contents)
+		assert.Equal(t, test.isError, err != nil, "Test #%v: %v", i, err)
+	}
+}
+
+func TestApplyRuleModifiers(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Match    string
+		Template string
+		Locale   string
+		Layout   string
+		Zone     string
+		Input    string
+		Expect   string
+	}{
+		{
+			Name:     "Thousand separators",
+			Match:    `^Blocks: (\d+)$`,
+			Template: "${1|num} blocks",
+			Input:    "Blocks: 1234567",
+			Expect:   "1,234,567 blocks",
+		},
+		{
+			Name:     "Locale of the rule",
+			Match:    `^Bytes: (\d+)$`,
+			Template: "${1/1048576|num:1} MB",
+			Locale:   "de",
+			Input:    "Bytes: 1363148800",
+			Expect:   "1.300,0 MB",
+		},
+		{
+			Name:     "Negative number",
+			Match:    `^Balance: (\S+)$`,
+			Template: "${1|num:2}",
+			Input:    "Balance: -1234.567",
+			Expect:   "-1,234.57",
+		},
+		{
+			Name:     "Unix time",
+			Match:    `^Restart at (\d+)$`,
+			Template: "Restart ${1|time:R}",
+			Input:    "Restart at 1717268400",
+			Expect:   "Restart <t:1717268400:R>",
+		},
+		{
+			Name:     "Unix time in milliseconds",
+			Match:    `^Restart at (\d+)$`,
+			Template: "Restart at ${1|time}",
+			Input:    "Restart at 1717268400123",
+			Expect:   "Restart at <t:1717268400>",
+		},
+		{
+			Name:     "Timestamp with a layout",
+			Match:    `^Backup at (.+)$`,
+			Template: "Backup at ${1|time:f}",
+			Layout:   "2006-01-02 15:04:05",
+			Zone:     "Europe/Berlin",
+			Input:    "Backup at 2024-06-01 21:00:00",
+			Expect:   "Backup at <t:1717268400:f>",
+		},
+		{
+			Name:     "Timestamp without a layout is inserted unchanged",
+			Match:    `^Backup at (.+)$`,
+			Template: "Backup at ${1|time}",
+			Input:    "Backup at 2024-06-01 21:00:00",
+			Expect:   "Backup at 2024-06-01 21:00:00",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			rule := Rule{Template: test.Template, Locale: test.Locale, TimeLayout: test.Layout, TimeZone: test.Zone}
+			assert.NoError(t, rule.Match.UnmarshalText([]byte(test.Match)))
+			assert.NoError(t, rule.compile())
+			assert.Equal(t, test.Expect, ApplyRule(rule, nil, test.Input))
+		})
+	}
+}
+
+func TestParseRulesTimeZone(t *testing.T) {
+	rules, err := ParseRules([]byte(`{
+		"SubprocessToDiscord": [{"Match": "^Backup at (.+)$", "Template": "${1|time}", "TimeLayout": "2006-01-02 15:04:05", "TimeZone": "Europe/Berlin"}],
+		"DiscordToSubprocess": []
+	}`))
+	assert.NoError(t, err)
+	match := rules.Match(DirectionSubprocessToDiscord, nil, "Backup at 2024-06-01 21:00:00")
+	assert.Equal(t, "<t:1717268400>", match.Result)
+}
+
+func TestApplyRuleRepeat(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Match    string
+		Template string
+		Joiner   string
+		Input    string
+		Expect   string
+	}{
+		{
+			Name:     "Player mentions",
+			Match:    `(?:Online: |, )(\w+)`,
+			Template: "@${1}",
+			Joiner:   " ",
+			Input:    "Online: a, b, c",
+			Expect:   "@a @b @c",
+		},
+		{
+			Name:     "List",
+			Match:    `(\w+) \((\d+)\)`,
+			Template: "- **${1}**: ${2*2} points",
+			Joiner:   "\n",
+			Input:    "Scores: Bob (3), Alice (5)",
+			Expect:   "- **Bob**: 6 points\n- **Alice**: 10 points",
+		},
+		{
+			Name:     "No joiner",
+			Match:    `\d`,
+			Template: "[${0}]",
+			Input:    "1 2 3",
+			Expect:   "[1][2][3]",
+		},
+		{
+			Name:     "No match",
+			Match:    `\d`,
+			Template: "[${0}]",
+			Input:    "a b c",
+			Expect:   "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			rule := Rule{Template: test.Template, Repeat: true, Joiner: test.Joiner}
+			assert.NoError(t, rule.Match.UnmarshalText([]byte(test.Match)))
+			assert.Equal(t, test.Expect, ApplyRule(rule, nil, test.Input))
+		})
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	rules := make([]Rule, 2)
+	assert.NoError(t, rules[0].Match.UnmarshalText([]byte(`^<(\w+)> (.*)$`)))
+	rules[0].Template = "**${1}**: ${2}"
+	assert.NoError(t, rules[1].Match.UnmarshalText([]byte(`^(\w+) joined$`)))
+	rules[1].Template = "${1} connected"
+
+	match := MatchRules(rules, nil, "Bob joined")
+	if assert.NotNil(t, match) {
+		assert.Equal(t, 1, match.Index)
+		assert.Same(t, &rules[1], match.Rule)
+		assert.Equal(t, []string{"Bob joined", "Bob"}, match.Groups)
+		assert.Equal(t, "Bob connected", match.Result)
+	}
+	assert.Nil(t, MatchRules(rules, nil, "no match"))
+}
+
+func TestRulesMatchPeerDirections(t *testing.T) {
+	rules := Rules{
+		SubprocessToPeer: make([]Rule, 1),
+		PeerToSubprocess: make([]Rule, 1),
+	}
+	assert.NoError(t, rules.SubprocessToPeer[0].Match.UnmarshalText([]byte(`^<(\w+)> (.*)$`)))
+	rules.SubprocessToPeer[0].Template = "[a] <${1}> ${2}"
+	assert.NoError(t, rules.PeerToSubprocess[0].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.PeerToSubprocess[0].Template = "say ${1}"
+
+	assert.Equal(t, "[a] <Bob> hi", rules.Match(DirectionSubprocessToPeer, nil, "<Bob> hi").Result)
+	assert.Equal(t, "say [a] <Bob> hi", rules.Match(DirectionPeerToSubprocess, nil, "[a] <Bob> hi").Result)
+	assert.Nil(t, rules.Match(DirectionSubprocessToDiscord, nil, "<Bob> hi"))
+}
+
+func TestRuleId(t *testing.T) {
+	rules := Rules{
+		SubprocessToDiscord: []Rule{{Name: "chat"}, {}, {Name: "chat"}},
+		DiscordToSubprocess: []Rule{{Name: "chat"}},
+	}
+	assert.Equal(t, "chat", RuleId(&rules.SubprocessToDiscord[0], 0))
+	assert.Equal(t, "#1", RuleId(&rules.SubprocessToDiscord[1], 1))
+
+	errs := rules.CheckRuleNames()
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, `SubprocessToDiscord: rules #0 and #2 are both named "chat"`, errs[0].Error())
+	}
+}
+
+func TestValidateRuleNames(t *testing.T) {
+	tests := []struct {
+		contents string
+		valid    bool
+	}{
+		{`{"SubprocessToDiscord": [
+			{"Name": "chat", "Match": "<(\\w+)> (.*)", "Template": "${2}"},
+			{"Name": "join", "Match": "(\\w+) joined", "Template": "${1}"}
+		], "DiscordToSubprocess": [{"Name": "chat", "Match": "(.*)", "Template": "say ${1}"}]}`, true},
+		{`{"SubprocessToDiscord": [
+			{"Name": "chat", "Match": "<(\\w+)> (.*)", "Template": "${2}"},
+			{"Name": "chat", "Match": "(.*)", "Template": "${1}"}
+		], "DiscordToSubprocess": []}`, false},
+	}
+	for i, test := range tests {
+		rules, err := ParseRules([]byte(test.contents))
+		assert.NoError(t, err, "Test #%v", i)
+		assert.Equal(t, test.valid, rules.Validate() == nil, "Test #%v", i)
+	}
+}
+
+func TestDisabledRules(t *testing.T) {
+	disabled := false
+	rules := Rules{SubprocessToDiscord: make([]Rule, 2)}
+	assert.NoError(t, rules.SubprocessToDiscord[0].Match.UnmarshalText([]byte(`^<(\w+)> (.*)$`)))
+	rules.SubprocessToDiscord[0].Template = "old ${2}"
+	rules.SubprocessToDiscord[0].Name = "old chat"
+	rules.SubprocessToDiscord[0].Enabled = &disabled
+	assert.NoError(t, rules.SubprocessToDiscord[1].Match.UnmarshalText([]byte(`^<(\w+)> (.*)$`)))
+	rules.SubprocessToDiscord[1].Template = "new ${2}"
+
+	match := rules.Match(DirectionSubprocessToDiscord, nil, "<Bob> hi")
+	if assert.NotNil(t, match) {
+		assert.Equal(t, 1, match.Index)
+		assert.Equal(t, "new hi", match.Result)
+	}
+	assert.Equal(t, []string{"old chat"}, rules.DisabledMatches(DirectionSubprocessToDiscord, "<Bob> hi"))
+	assert.Nil(t, rules.DisabledMatches(DirectionSubprocessToDiscord, "Bob joined"))
+}
+
+func TestRulesMatchPlayer(t *testing.T) {
+	rules := Rules{SubprocessToDiscord: make([]Rule, 2)}
+	assert.NoError(t, rules.SubprocessToDiscord[0].Match.UnmarshalText([]byte(`^<(\w+)> (.*)$`)))
+	rules.SubprocessToDiscord[0].Template = "^M (#^C): ${2}"
+	rules.SubprocessToDiscord[0].Player = "${1}"
+	assert.NoError(t, rules.SubprocessToDiscord[1].Match.UnmarshalText([]byte(`^(\w+) joined$`)))
+	rules.SubprocessToDiscord[1].Template = "${1} ^C"
+
+	var players []string
+	resolve := func(player string) Props {
+		players = append(players, player)
+		return Props{Author: Author{Id: "123456789012345678", Username: player, AccentColor: 0xff0000}}
+	}
+	assert.Equal(t, "<@123456789012345678> (#ff0000): hi", rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, "<Bob> hi", resolve).Result)
+	// Rules without a Player template are not built with Props.
+	assert.Equal(t, "Bob ^C", rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, "Bob joined", resolve).Result)
+	assert.Equal(t, []string{"Bob"}, players)
+	assert.Equal(t, "^M (#^C): hi", rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, "<Bob> hi", nil).Result)
+	assert.Nil(t, rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, "no match", resolve))
+}
+
+func TestRulesMatchExports(t *testing.T) {
+	rules := Rules{SubprocessToDiscord: make([]Rule, 2)}
+	assert.NoError(t, rules.SubprocessToDiscord[0].Match.UnmarshalText([]byte(`^\[(\w+)\] (\w+) died$`)))
+	rules.SubprocessToDiscord[0].Template = ":skull: ^M died"
+	rules.SubprocessToDiscord[0].Exports = map[string]string{"player": "${2}", "world": " \x1b[31m${1}\x1b[0m "}
+	assert.NoError(t, rules.SubprocessToDiscord[1].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.SubprocessToDiscord[1].Template = "${1}"
+
+	resolve := func(player string) Props {
+		return Props{Author: Author{Id: "123456789012345678", Username: player}}
+	}
+	match := rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, "[nether] Bob died", resolve)
+	assert.Equal(t, ":skull: <@123456789012345678> died", match.Result, "the exported player is resolved")
+	assert.Equal(t, map[string]string{"player": "Bob", "world": "nether"}, match.Vars)
+	assert.Equal(t, "Bob", match.Player("[nether] Bob died"))
+
+	match = rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, "Saving", resolve)
+	assert.Nil(t, match.Vars)
+	assert.Equal(t, "", match.Player("Saving"))
+}
+
+func TestRulesMatchInstance(t *testing.T) {
+	rules := Rules{SubprocessToDiscord: make([]Rule, 2), DiscordToSubprocess: make([]Rule, 2)}
+	assert.NoError(t, rules.SubprocessToDiscord[0].Match.UnmarshalText([]byte(`^<(\w+)> (.*)$`)))
+	rules.SubprocessToDiscord[0].Template = "[^P] **^U**: ${2}"
+	rules.SubprocessToDiscord[0].Player = "${1}"
+	assert.NoError(t, rules.SubprocessToDiscord[1].Match.UnmarshalText([]byte(`^(\w+) joined$`)))
+	rules.SubprocessToDiscord[1].Template = "[^P] ${1} joined"
+	resolve := func(player string) Props {
+		return Props{Author: Author{Username: player}}
+	}
+	assert.Equal(t, "[lobby] **Bob**: hi", rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{Instance: "lobby"}, "<Bob> hi", resolve).Result)
+	assert.Equal(t, "[world] Bob joined", rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{Instance: "world"}, "Bob joined", resolve).Result)
+	// Templates of a single subprocess are not built.
+	assert.Equal(t, "[^P] Bob joined", rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{}, "Bob joined", resolve).Result)
+
+	assert.NoError(t, rules.DiscordToSubprocess[0].Match.UnmarshalText([]byte(`^!(\w+) (.*)$`)))
+	rules.DiscordToSubprocess[0].Template = "${2}"
+	rules.DiscordToSubprocess[0].Instance = "${1}"
+	assert.NoError(t, rules.DiscordToSubprocess[1].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[1].Template = "say ${1}"
+	match := rules.Match(DirectionDiscordToSubprocess, nil, "!world time set day")
+	assert.Equal(t, "time set day", match.Result)
+	assert.Equal(t, "world", match.InstanceName("!world time set day"))
+	assert.Equal(t, "", rules.Match(DirectionDiscordToSubprocess, nil, "hi").InstanceName("hi"))
+}
+
+func TestRulesMatchMessageType(t *testing.T) {
+	rules := Rules{DiscordToSubprocess: make([]Rule, 3)}
+	assert.NoError(t, rules.DiscordToSubprocess[0].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[0].Template = "say ^U asks: ^Q (^O)"
+	rules.DiscordToSubprocess[0].MessageType = MessageTypePoll
+	assert.NoError(t, rules.DiscordToSubprocess[1].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[1].Template = "say [News] ${1}"
+	rules.DiscordToSubprocess[1].MessageType = MessageTypeAnnouncement
+	assert.NoError(t, rules.DiscordToSubprocess[2].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[2].Template = "say <^U> ${1}"
+	rules.DiscordToSubprocess[2].MessageType = MessageTypeMessage
+
+	author := Author{Username: "Bob"}
+	tests := []struct {
+		props    Props
+		input    string
+		expected string
+	}{
+		{Props{Author: author}, "hi", "say <Bob> hi"},
+		{Props{Author: author, MessageType: MessageTypeMessage}, "hi", "say <Bob> hi"},
+		{
+			Props{Author: author, MessageType: MessageTypePoll, Poll: &Poll{Question: "Reset?", Answers: []string{"Yes", "No"}}},
+			"Reset?",
+			"say Bob asks: Reset? (Yes / No)",
+		},
+		{
+			Props{Author: author, MessageType: MessageTypePoll, Poll: &Poll{Question: "Pay $1?", Answers: []string{"$1", "${1}"}}},
+			"Pay $1?",
+			"say Bob asks: Pay $1? ($1 / ${1})",
+		},
+		{Props{Author: Author{Username: "Server #news"}, MessageType: MessageTypeAnnouncement}, "Update out", "say [News] Update out"},
+	}
+	for i, test := range tests {
+		match := rules.Match(DirectionDiscordToSubprocess, &test.props, test.input)
+		assert.Equal(t, test.expected, match.Result, "Test #%v", i)
+	}
+	// Without props, e.g. in other directions, the message type is ignored.
+	assert.Equal(t, 0, rules.Match(DirectionDiscordToSubprocess, nil, "hi").Index)
+}
+
+func TestRulesMatchAuthorType(t *testing.T) {
+	rules := Rules{DiscordToSubprocess: make([]Rule, 3)}
+	assert.NoError(t, rules.DiscordToSubprocess[0].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[0].Template = "say [Bot] ^U: ${1}"
+	rules.DiscordToSubprocess[0].AuthorType = AuthorTypeBot
+	assert.NoError(t, rules.DiscordToSubprocess[1].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[1].Template = "say [Webhook] ^U: ${1}"
+	rules.DiscordToSubprocess[1].AuthorType = AuthorTypeWebhook
+	assert.NoError(t, rules.DiscordToSubprocess[2].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.DiscordToSubprocess[2].Template = "say <^U> ${1}"
+
+	tests := []struct {
+		author   Author
+		expected string
+	}{
+		{Author{Username: "Bob"}, "say <Bob> hi"},
+		{Author{Username: "Modbot", IsBot: true}, "say [Bot] Modbot: hi"},
+		// Webhooks are reported as bots by Discord, but have their own type.
+		{Author{Username: "GitHub", IsBot: true, IsWebhook: true}, "say [Webhook] GitHub: hi"},
+	}
+	for i, test := range tests {
+		match := rules.Match(DirectionDiscordToSubprocess, &Props{Author: test.author}, "hi")
+		assert.Equal(t, test.expected, match.Result, "Test #%v", i)
+	}
+	assert.Equal(t, 0, rules.Match(DirectionDiscordToSubprocess, nil, "hi").Index)
+}
+
+func TestAuthorMention(t *testing.T) {
+	tests := []struct {
+		author   Author
+		expected string
+	}{
+		{Author{Id: "123456789012345678", Username: "Bob"}, "<@123456789012345678>"},
+		{Author{Id: "", Username: "Bob"}, "Bob"},
+		{Author{Id: "<@1>", Username: "Bob"}, "Bob"},
+		// Webhooks have an ID for ^I, but can't be mentioned.
+		{Author{Id: "223456789012345678", Username: "GitHub", IsBot: true, IsWebhook: true}, "GitHub"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, test.author.Mention(), "Test #%v", i)
+	}
+}
+
+func TestPassthroughRule(t *testing.T) {
+	rule := Rule{Passthrough: true}
+	assert.NoError(t, rule.Match.UnmarshalText([]byte(`\[Server\] (.*)`)))
+	// The whole line is relayed, not just the match, and $ is not expanded.
+	assert.Equal(t, "12:00 [Server] costs $5 today", ApplyRule(rule, nil, "12:00 [Server] costs $5 today"))
+	// Color codes are stripped from the result, like for templates.
+	assert.Equal(t, "[Server] hi", ApplyRules([]Rule{rule}, nil, "[Server] \x1b[31mhi\x1b[0m"))
+	assert.Equal(t, "", ApplyRule(rule, nil, "other"))
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// minSlowModeWait is the least time messages are held back after Discord rate
+// limited one, since it may report a retry after 0s, e.g. when the slow mode
+// is about to allow the next message; sending again right away would fail
+// again.
+const minSlowModeWait = time.Second
+
+// SendBackoff holds back the messages to a Discord channel while sending them
+// can't succeed, so that a misconfigured channel doesn't fail, and log, every
+// message:
+//
+//   - while the bot lacks permission to send to the channel, messages are
+//     dropped; one message is sent again after a backoff that grows with every
+//     further failure, to notice once the permission was granted, and the
+//     others are dropped until it was sent
+//   - while the channel's slow mode limits the bot, messages wait until the
+//     slow mode allows the next one
+//
+// It is safe for concurrent use.
+type SendBackoff struct {
+	policy          RetryPolicy // Backoff of sends that failed with missing permissions
+	mutex           sync.Mutex
+	until           time.Time // Messages are held back until then
+	forbidden       bool      // Whether held back messages are dropped, instead of waiting
+	probing         bool      // Whether a message is being sent after the backoff of forbidden elapsed
+	failures        int       // Consecutive sends that failed with missing permissions
+	alertedForbid   bool      // Whether missing permissions were reported since the last successful send
+	alertedSlowMode bool      // Whether the slow mode was reported since the last successful send
+}
+
+// NewSendBackoff creates a SendBackoff.
+//
+// Parameters:
+//
+//	policy: the backoff after sends that failed with missing permissions;
+//		MaxAttempts is ignored
+func NewSendBackoff(policy RetryPolicy) *SendBackoff {
+	return &SendBackoff{policy: policy}
+}
+
+// Hold reports whether a message may be sent now.
+//
+// Returns:
+//
+//	the time to wait before sending it, 0 if it may be sent now, and true if
+//	it must be dropped instead, since the bot lacks permission to send it
+func (b *SendBackoff) Hold(now time.Time) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if now.Before(b.until) {
+		return b.until.Sub(now), b.forbidden
+	}
+	if b.forbidden {
+		// Only one message finds out whether the bot may send again.
+		if b.probing {
+			return 0, true
+		}
+		b.probing = true
+	}
+	return 0, false
+}
+
+// Forbidden records that sending a message failed because the bot lacks
+// permission, dropping the messages until the backoff elapsed.
+//
+// Returns:
+//
+//	true if this wasn't reported since the last successful send, i.e. an
+//	alert should be posted
+func (b *SendBackoff) Forbidden(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	b.until = now.Add(b.policy.Delay(b.failures))
+	b.forbidden = true
+	b.probing = false
+	alert := !b.alertedForbid
+	b.alertedForbid = true
+	return alert
+}
+
+// SlowMode records that sending a message failed because of the channel's
+// slow mode, holding back the messages until it allows the next one.
+//
+// Parameters:
+//
+//	retryAfter: the time until the next message is allowed, as reported by
+//		Discord; at least minSlowModeWait is waited
+//
+// Returns:
+//
+//	true if this wasn't reported since the last successful send, i.e. an
+//	alert should be posted
+func (b *SendBackoff) SlowMode(retryAfter time.Duration, now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if until := now.Add(max(retryAfter, minSlowModeWait)); until.After(b.until) {
+		b.until = until
+	}
+	// The message that was sent waits like the others.
+	b.probing = false
+	alert := !b.alertedSlowMode
+	b.alertedSlowMode = true
+	return alert
+}
+
+// Succeeded records that a message was sent, resetting the backoff and the
+// alerts.
+//
+// Returns:
+//
+//	true if the bot lacked permission before, i.e. the permission was granted
+func (b *SendBackoff) Succeeded() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	recovered := b.failures > 0
+	b.failures = 0
+	b.forbidden = false
+	b.probing = false
+	b.alertedForbid = false
+	b.alertedSlowMode = false
+	return recovered
+}
+
+// Failed records that sending a message failed for another reason, e.g. a
+// network error, so that the next message is sent to find out whether the bot
+// may send again.
+func (b *SendBackoff) Failed() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
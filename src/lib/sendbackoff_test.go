@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendBackoffForbidden(t *testing.T) {
+	backoff := NewSendBackoff(RetryPolicy{BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, random: func() float64 { return 1 }})
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	wait, drop := backoff.Hold(now)
+	assert.Zero(t, wait)
+	assert.False(t, drop)
+
+	assert.True(t, backoff.Forbidden(now))
+	wait, drop = backoff.Hold(now.Add(10 * time.Second))
+	assert.Equal(t, 50*time.Second, wait)
+	assert.True(t, drop)
+
+	// The next attempt after the backoff fails as well: the backoff grows, and
+	// no further alert is posted.
+	now = now.Add(time.Minute)
+	wait, _ = backoff.Hold(now)
+	assert.Zero(t, wait)
+	assert.False(t, backoff.Forbidden(now))
+	wait, drop = backoff.Hold(now)
+	assert.Equal(t, 2*time.Minute, wait)
+	assert.True(t, drop)
+	for i := 0; i < 5; i++ {
+		backoff.Forbidden(now)
+	}
+	wait, _ = backoff.Hold(now)
+	assert.Equal(t, 4*time.Minute, wait)
+
+	now = now.Add(4 * time.Minute)
+	assert.True(t, backoff.Succeeded())
+	assert.False(t, backoff.Succeeded())
+	assert.True(t, backoff.Forbidden(now), "alerts again after a successful send")
+	wait, _ = backoff.Hold(now)
+	assert.Equal(t, time.Minute, wait)
+}
+
+func TestSendBackoffSlowMode(t *testing.T) {
+	backoff := NewSendBackoff(RetryPolicy{BaseDelay: time.Minute})
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.True(t, backoff.SlowMode(10*time.Second, now))
+	wait, drop := backoff.Hold(now.Add(4 * time.Second))
+	assert.Equal(t, 6*time.Second, wait)
+	assert.False(t, drop)
+
+	// A shorter retry doesn't shorten the hold.
+	assert.False(t, backoff.SlowMode(time.Second, now))
+	wait, _ = backoff.Hold(now)
+	assert.Equal(t, 10*time.Second, wait)
+
+	wait, _ = backoff.Hold(now.Add(10 * time.Second))
+	assert.Zero(t, wait)
+	assert.False(t, backoff.Succeeded())
+	assert.True(t, backoff.SlowMode(10*time.Second, now))
+
+	// Retries after 0s still wait.
+	now = now.Add(time.Minute)
+	backoff.SlowMode(0, now)
+	wait, _ = backoff.Hold(now)
+	assert.Equal(t, minSlowModeWait, wait)
+}
+
+func TestSendBackoffProbe(t *testing.T) {
+	backoff := NewSendBackoff(RetryPolicy{BaseDelay: time.Minute, MaxDelay: 4 * time.Minute, random: func() float64 { return 1 }})
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	backoff.Forbidden(now)
+	now = now.Add(time.Minute)
+
+	// Once the backoff elapsed, one message is sent while the others are
+	// dropped.
+	wait, drop := backoff.Hold(now)
+	assert.Zero(t, wait)
+	assert.False(t, drop)
+	_, drop = backoff.Hold(now)
+	assert.True(t, drop)
+
+	// Another error lets the next message find out.
+	backoff.Failed()
+	_, drop = backoff.Hold(now)
+	assert.False(t, drop)
+	_, drop = backoff.Hold(now)
+	assert.True(t, drop)
+
+	assert.True(t, backoff.Succeeded())
+	_, drop = backoff.Hold(now)
+	assert.False(t, drop)
+	_, drop = backoff.Hold(now)
+	assert.False(t, drop, "messages aren't dropped once the bot may send")
+}
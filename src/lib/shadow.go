@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ShadowDiff is a difference between the results of the rules and of a
+// candidate rule set for the same input. Candidate rules run in the shadow of
+// the rules on live traffic, without their results being relayed, so that a
+// rewrite can be validated before it replaces the rules.
+type ShadowDiff struct {
+	Direction     string
+	Input         string
+	Current       *RuleMatch // Match of the rules, nil if no rule matched
+	Candidate     *RuleMatch // Match of the candidate rules, nil if no rule matched
+	CurrentRule   string     // See RuleMatch.RuleId, "none" if no rule matched
+	CandidateRule string
+}
+
+// CompareMatches compares the matches of the rules and of candidate rules for
+// an input. Matches differ if only one of them matched, or if their results
+// differ; the rules that matched may differ, e.g. after renaming them.
+//
+// Returns:
+//
+//	the difference, and false if the matches don't differ
+func CompareMatches(direction string, input string, current *RuleMatch, candidate *RuleMatch) (ShadowDiff, bool) {
+	diff := ShadowDiff{
+		Direction:     direction,
+		Input:         input,
+		Current:       current,
+		Candidate:     candidate,
+		CurrentRule:   "none",
+		CandidateRule: "none",
+	}
+	if current != nil {
+		diff.CurrentRule = current.RuleId()
+	}
+	if candidate != nil {
+		diff.CandidateRule = candidate.RuleId()
+	}
+	switch {
+	case current == nil && candidate == nil:
+		return diff, false
+	case current == nil || candidate == nil:
+		return diff, true
+	default:
+		return diff, current.Result != candidate.Result
+	}
+}
+
+// String formats the difference for the log.
+func (d ShadowDiff) String() string {
+	return fmt.Sprintf("%v input %q: current %v (rule %v), candidate %v (rule %v)",
+		d.Direction, d.Input, formatShadowResult(d.Current), d.CurrentRule, formatShadowResult(d.Candidate), d.CandidateRule)
+}
+
+func formatShadowResult(match *RuleMatch) string {
+	if match == nil {
+		return "no match"
+	}
+	return fmt.Sprintf("%q", match.Result)
+}
+
+// ShadowStats counts the inputs compared with candidate rules, and those whose
+// matches differed, by direction. It is safe for concurrent use.
+type ShadowStats struct {
+	mutex    sync.Mutex
+	compared map[string]int
+	differed map[string]int
+}
+
+// NewShadowStats creates a ShadowStats.
+func NewShadowStats() *ShadowStats {
+	return &ShadowStats{compared: make(map[string]int), differed: make(map[string]int)}
+}
+
+// Count counts a compared input.
+func (s *ShadowStats) Count(direction string, differed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.compared[direction]++
+	if differed {
+		s.differed[direction]++
+	}
+}
+
+// Summary returns the counts of a direction, e.g. "3 of 1,204 inputs
+// differed".
+func (s *ShadowStats) Summary(direction string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return fmt.Sprintf("%v of %v inputs differed", formatCount(s.differed[direction]), formatCount(s.compared[direction]))
+}
@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareMatches(t *testing.T) {
+	named := &Rule{Name: "chat"}
+	current := &RuleMatch{Index: 0, Rule: named, Result: "<Steve> hi"}
+
+	diff, differs := CompareMatches(DirectionSubprocessToDiscord, "hi", nil, nil)
+	assert.False(t, differs)
+	assert.Equal(t, "none", diff.CurrentRule)
+
+	_, differs = CompareMatches(DirectionSubprocessToDiscord, "hi", current, &RuleMatch{Index: 3, Rule: &Rule{}, Result: "<Steve> hi"})
+	assert.False(t, differs, "matches of different rules with the same result don't differ")
+
+	diff, differs = CompareMatches(DirectionSubprocessToDiscord, "hi", current, &RuleMatch{Index: 3, Rule: &Rule{}, Result: "**Steve**: hi"})
+	assert.True(t, differs)
+	assert.Equal(t, `SubprocessToDiscord input "hi": current "<Steve> hi" (rule chat), candidate "**Steve**: hi" (rule #3)`, diff.String())
+
+	diff, differs = CompareMatches(DirectionSubprocessToDiscord, "hi", current, nil)
+	assert.True(t, differs)
+	assert.Equal(t, `SubprocessToDiscord input "hi": current "<Steve> hi" (rule chat), candidate no match (rule none)`, diff.String())
+}
+
+func TestShadowStats(t *testing.T) {
+	stats := NewShadowStats()
+	for i := 0; i < 1204; i++ {
+		stats.Count(DirectionSubprocessToDiscord, i < 3)
+	}
+	stats.Count(DirectionDiscordToSubprocess, false)
+	assert.Equal(t, "3 of 1,204 inputs differed", stats.Summary(DirectionSubprocessToDiscord))
+	assert.Equal(t, "0 of 1 inputs differed", stats.Summary(DirectionDiscordToSubprocess))
+}
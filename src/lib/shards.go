@@ -0,0 +1,20 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ShardFor returns the gateway shard that receives the events of a guild, of
+// a bot connecting with a number of shards, see
+// https://discord.com/developers/docs/events/gateway#sharding.
+func ShardFor(guildId string, count int) (int, error) {
+	if count < 1 {
+		return 0, fmt.Errorf("invalid shard count %v", count)
+	}
+	id, err := strconv.ParseUint(guildId, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid guild ID %q", guildId)
+	}
+	return int((id >> 22) % uint64(count)), nil
+}
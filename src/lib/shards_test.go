@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardFor(t *testing.T) {
+	// 41771983423143937 >> 22 is 9959216934
+	shard, err := ShardFor("41771983423143937", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, shard)
+	shard, err = ShardFor("41771983423143937", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, shard)
+
+	_, err = ShardFor("41771983423143937", 0)
+	assert.Error(t, err)
+	_, err = ShardFor("general", 10)
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of SignalAction.
+const (
+	SignalActionForward = "forward" // Pass the signal on to the subprocess
+	SignalActionIgnore  = "ignore"  // Do nothing
+	SignalActionCommand = "command" // Write a command to the subprocess' stdin
+	SignalActionReload  = "reload"  // Reload the rules and users, see Rules.KeepStartupSections
+)
+
+// SignalAction is what dgbridge does when it receives a signal.
+type SignalAction struct {
+	Kind    string
+	Command string // Command written to stdin, for SignalActionCommand
+}
+
+// ParseSignalAction parses a signal action: "forward", "ignore", "reload", or
+// "command:<COMMAND>", e.g. "command:save-all".
+func ParseSignalAction(value string) (SignalAction, error) {
+	if command, ok := strings.CutPrefix(value, SignalActionCommand+":"); ok {
+		if command == "" {
+			return SignalAction{}, fmt.Errorf("signal action %q has no command", value)
+		}
+		return SignalAction{Kind: SignalActionCommand, Command: command}, nil
+	}
+	switch value {
+	case SignalActionForward, SignalActionIgnore, SignalActionReload:
+		return SignalAction{Kind: value}, nil
+	}
+	return SignalAction{}, fmt.Errorf("invalid signal action %q, expected %v, %v, %v or %v:<COMMAND>",
+		value, SignalActionForward, SignalActionIgnore, SignalActionReload, SignalActionCommand)
+}
+
+// NormalizeSignalName returns the conventional name of a signal, e.g. "SIGHUP"
+// for "hup", "HUP" or "sighup".
+func NormalizeSignalName(name string) string {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return name
+}
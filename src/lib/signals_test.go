@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSignalAction(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected SignalAction
+		wantErr  bool
+	}{
+		{"forward", SignalAction{Kind: SignalActionForward}, false},
+		{"ignore", SignalAction{Kind: SignalActionIgnore}, false},
+		{"reload", SignalAction{Kind: SignalActionReload}, false},
+		{"command:save-all flush", SignalAction{Kind: SignalActionCommand, Command: "save-all flush"}, false},
+		{"command:", SignalAction{}, true},
+		{"restart", SignalAction{}, true},
+	}
+	for _, test := range tests {
+		action, err := ParseSignalAction(test.value)
+		if test.wantErr {
+			assert.Error(t, err, test.value)
+			continue
+		}
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.expected, action)
+	}
+}
+
+func TestNormalizeSignalName(t *testing.T) {
+	assert.Equal(t, "SIGHUP", NormalizeSignalName("hup"))
+	assert.Equal(t, "SIGUSR1", NormalizeSignalName("SIGUSR1"))
+	assert.Equal(t, "SIGUSR2", NormalizeSignalName("sigusr2"))
+}
@@ -0,0 +1,131 @@
+package lib
+
+// This file implements the source stream and the state of the subprocess that
+// Subprocess ➡️ Discord rules can be limited to, see Rule.Source and
+// Rule.State.
+
+import (
+	"dgbridge/src/ext"
+	"sync"
+)
+
+// Streams of the subprocess, see Rule.Source.
+const (
+	SourceStdout = "stdout"
+	SourceStderr = "stderr"
+)
+
+// States of the subprocess, see Rule.State and ProcessStates.
+const (
+	ProcessStateStarting = "starting" // Until a line matches ProcessStates.Ready
+	ProcessStateReady    = "ready"
+	ProcessStateStopping = "stopping" // Once the bridge stops the subprocess, or a line matches ProcessStates.Stopping
+)
+
+// ProcessStates declares the lines that mark changes of the subprocess' state.
+type ProcessStates struct {
+	// Line printed once the subprocess is ready, e.g. "Done \(.*\)!"; without
+	// it, the subprocess is ready from the start
+	Ready ext.Regexp
+	// Optional, line printed when the subprocess begins to stop, e.g.
+	// "Stopping the server"
+	Stopping ext.Regexp
+}
+
+// LineInfo describes where a subprocess line came from, see Rules.MatchPlayer.
+type LineInfo struct {
+	Instance string // Name of the subprocess instance, empty if the bridge runs a single subprocess
+	Stderr   bool   // Whether the line was read from stderr
+	State    string // One of the ProcessState constants, empty if unknown
+	// Fields of the line if it's a JSON line, nil otherwise; set by
+	// Rules.MatchPlayer, see JsonLines
+	Json JsonFields
+}
+
+// Source returns the stream the line was read from, one of the Source
+// constants.
+func (info LineInfo) Source() string {
+	if info.Stderr {
+		return SourceStderr
+	}
+	return SourceStdout
+}
+
+// appliesToLine reports whether the rule applies to a subprocess line, see
+// Source and State. Rules without conditions apply to all lines, and State
+// conditions to lines of an unknown state.
+func (rule *Rule) appliesToLine(info LineInfo) bool {
+	if rule.Source != "" && rule.Source != info.Source() {
+		return false
+	}
+	if info.Json == nil && usesJsonFields(rule.Template) {
+		// Falls through to rules matching the raw line
+		return false
+	}
+	return rule.State == "" || info.State == "" || rule.State == info.State
+}
+
+// ProcessStateTracker tracks the state of a subprocess from its output. It is
+// safe for concurrent use.
+type ProcessStateTracker struct {
+	mutex  sync.Mutex
+	states *ProcessStates
+	state  string
+}
+
+// NewProcessStateTracker creates the tracker of a subprocess that is starting.
+//
+// Parameters:
+//
+//	states: lines marking state changes, may be nil
+func NewProcessStateTracker(states *ProcessStates) *ProcessStateTracker {
+	state := ProcessStateReady
+	if states != nil && states.Ready.Regexp != nil {
+		state = ProcessStateStarting
+	}
+	return &ProcessStateTracker{states: states, state: state}
+}
+
+// Observe updates the state with a line of the subprocess' output.
+//
+// Returns:
+//
+//	the state of the line; a line marking a state change has the new state
+func (t *ProcessStateTracker) Observe(line string) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.states == nil {
+		return t.state
+	}
+	line = StripAnsi(line)
+	if t.state == ProcessStateStarting && t.states.Ready.MatchString(line) {
+		t.state = ProcessStateReady
+	}
+	if t.state != ProcessStateStopping && t.states.Stopping.Regexp != nil && t.states.Stopping.MatchString(line) {
+		t.state = ProcessStateStopping
+	}
+	return t.state
+}
+
+// Stopping marks the subprocess as stopping, e.g. when the bridge stops it.
+func (t *ProcessStateTracker) Stopping() {
+	t.Set(ProcessStateStopping)
+}
+
+// Set sets the state, e.g. when a hosting panel reports it.
+//
+// Parameters:
+//
+//	state: one of the ProcessState constants
+func (t *ProcessStateTracker) Set(state string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.state = state
+}
+
+// State returns the current state.
+func (t *ProcessStateTracker) State() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.state
+}
@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessStateTracker(t *testing.T) {
+	states := &ProcessStates{}
+	assert.NoError(t, states.Ready.UnmarshalText([]byte(`Done \(.*\)!`)))
+	assert.NoError(t, states.Stopping.UnmarshalText([]byte(`^Stopping the server`)))
+	tracker := NewProcessStateTracker(states)
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"Loading libraries", ProcessStateStarting},
+		{"\x1b[32mDone (3.2s)!\x1b[0m", ProcessStateReady},
+		{"<Bob> hi", ProcessStateReady},
+		{"Stopping the server", ProcessStateStopping},
+		{"Done (1s)!", ProcessStateStopping},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, tracker.Observe(test.line), "Test #%v", i)
+	}
+}
+
+func TestProcessStateTrackerWithoutStates(t *testing.T) {
+	tracker := NewProcessStateTracker(nil)
+	assert.Equal(t, ProcessStateReady, tracker.Observe("Loading libraries"))
+	tracker.Stopping()
+	assert.Equal(t, ProcessStateStopping, tracker.Observe("Saving chunks"))
+	tracker.Set(ProcessStateStarting)
+	assert.Equal(t, ProcessStateStarting, tracker.State())
+}
+
+func TestRulesMatchLineInfo(t *testing.T) {
+	rules := Rules{SubprocessToDiscord: make([]Rule, 3)}
+	assert.NoError(t, rules.SubprocessToDiscord[0].Match.UnmarshalText([]byte(`^Loaded (.*)$`)))
+	rules.SubprocessToDiscord[0].Template = "Booting: loaded ${1}"
+	rules.SubprocessToDiscord[0].State = ProcessStateStarting
+	assert.NoError(t, rules.SubprocessToDiscord[1].Match.UnmarshalText([]byte(`^(.*)$`)))
+	rules.SubprocessToDiscord[1].Template = ":warning: ${1}"
+	rules.SubprocessToDiscord[1].Source = SourceStderr
+	assert.NoError(t, rules.SubprocessToDiscord[2].Match.UnmarshalText([]byte(`^Loaded (.*)$`)))
+	rules.SubprocessToDiscord[2].Template = "Reloaded ${1}"
+
+	tests := []struct {
+		info     LineInfo
+		input    string
+		expected string
+	}{
+		{LineInfo{State: ProcessStateStarting}, "Loaded world", "Booting: loaded world"},
+		{LineInfo{State: ProcessStateReady}, "Loaded world", "Reloaded world"},
+		// Lines of an unknown state match State rules.
+		{LineInfo{}, "Loaded world", "Booting: loaded world"},
+		{LineInfo{Stderr: true, State: ProcessStateReady}, "Out of memory", ":warning: Out of memory"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, rules.MatchPlayer(DirectionSubprocessToDiscord, test.info, test.input, nil).Result, "Test #%v", i)
+	}
+	assert.Nil(t, rules.MatchPlayer(DirectionSubprocessToDiscord, LineInfo{State: ProcessStateReady}, "Out of memory", nil))
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// RuleStat is the number of times a rule matched, and when it last matched.
+type RuleStat struct {
+	Direction string
+	RuleId    string    // See RuleId
+	Count     int       // Matches since the bridge started
+	LastMatch time.Time // Zero if the rule never matched
+}
+
+// RuleStats counts the matches of each rule, so that admins can check whether
+// expected events (e.g. backups) are actually happening.
+// It is safe for concurrent use.
+type RuleStats struct {
+	mutex      sync.Mutex
+	directions []string
+	stats      []RuleStat       // In rule order
+	index      map[string][]int // Offsets in stats by direction, by rule index
+}
+
+// NewRuleStats creates a RuleStats with all rules of the given directions,
+// including rules that never match.
+func NewRuleStats(rules *Rules, directions ...string) *RuleStats {
+	s := &RuleStats{directions: directions}
+	s.build(rules)
+	return s
+}
+
+// Reload replaces the rules of the stats with reloaded rules. Rules keep their
+// stats if a rule of the same direction and RuleId is reloaded, so the stats
+// of named rules survive reordering them.
+func (s *RuleStats) Reload(rules *Rules) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	type key struct{ direction, ruleId string }
+	previous := make(map[key]RuleStat, len(s.stats))
+	for _, stat := range s.stats {
+		previous[key{stat.Direction, stat.RuleId}] = stat
+	}
+	s.build(rules)
+	for i := range s.stats {
+		if stat, ok := previous[key{s.stats[i].Direction, s.stats[i].RuleId}]; ok {
+			s.stats[i] = stat
+		}
+	}
+}
+
+// build creates the stats of the rules, with no matches.
+func (s *RuleStats) build(rules *Rules) {
+	s.stats = nil
+	s.index = make(map[string][]int)
+	for _, direction := range s.directions {
+		list := rules.List(direction)
+		offsets := make([]int, len(list))
+		for i := range list {
+			offsets[i] = len(s.stats)
+			s.stats = append(s.stats, RuleStat{
+				Direction: direction,
+				RuleId:    RuleId(&list[i], i),
+			})
+		}
+		s.index[direction] = offsets
+	}
+}
+
+// Record counts a match. Matches of unknown directions or rules are ignored.
+func (s *RuleStats) Record(direction string, match *RuleMatch, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	offsets := s.index[direction]
+	if match.Index < 0 || match.Index >= len(offsets) {
+		return
+	}
+	stat := &s.stats[offsets[match.Index]]
+	stat.Count++
+	stat.LastMatch = now
+}
+
+// Snapshot returns a copy of the stats, in rule order.
+func (s *RuleStats) Snapshot() []RuleStat {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]RuleStat(nil), s.stats...)
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleStats(t *testing.T) {
+	rules := &Rules{
+		SubprocessToDiscord: []Rule{{Name: "backup"}, {}},
+		DiscordToSubprocess: []Rule{{}},
+	}
+	stats := NewRuleStats(rules, DirectionSubprocessToDiscord, DirectionDiscordToSubprocess)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	stats.Record(DirectionSubprocessToDiscord, &RuleMatch{Index: 0}, now)
+	stats.Record(DirectionSubprocessToDiscord, &RuleMatch{Index: 0}, now.Add(time.Minute))
+	stats.Record(DirectionDiscordToSubprocess, &RuleMatch{Index: 0}, now)
+	// Unknown rules are ignored.
+	stats.Record(DirectionDiscordToSubprocess, &RuleMatch{Index: 1}, now)
+	stats.Record(DirectionSubprocessToPeer, &RuleMatch{Index: 0}, now)
+
+	assert.Equal(t, []RuleStat{
+		{Direction: DirectionSubprocessToDiscord, RuleId: "backup", Count: 2, LastMatch: now.Add(time.Minute)},
+		{Direction: DirectionSubprocessToDiscord, RuleId: "#1"},
+		{Direction: DirectionDiscordToSubprocess, RuleId: "#0", Count: 1, LastMatch: now},
+	}, stats.Snapshot())
+}
+
+func TestRuleStats_Reload(t *testing.T) {
+	rules := &Rules{SubprocessToDiscord: []Rule{{Name: "backup"}, {}}}
+	stats := NewRuleStats(rules, DirectionSubprocessToDiscord, DirectionDiscordToSubprocess)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	stats.Record(DirectionSubprocessToDiscord, &RuleMatch{Index: 0}, now)
+
+	// The named rule moved, its stats move with it.
+	stats.Reload(&Rules{
+		SubprocessToDiscord: []Rule{{Name: "join"}, {Name: "backup"}},
+		DiscordToSubprocess: []Rule{{}},
+	})
+	stats.Record(DirectionDiscordToSubprocess, &RuleMatch{Index: 0}, now)
+	assert.Equal(t, []RuleStat{
+		{Direction: DirectionSubprocessToDiscord, RuleId: "join"},
+		{Direction: DirectionSubprocessToDiscord, RuleId: "backup", Count: 1, LastMatch: now},
+		{Direction: DirectionDiscordToSubprocess, RuleId: "#0", Count: 1, LastMatch: now},
+	}, stats.Snapshot())
+}
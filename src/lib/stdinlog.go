@@ -0,0 +1,99 @@
+package lib
+
+// This file implements stdin transcripts: the lines written to the stdin of the
+// subprocess, with the time they were written, for replaying them against a
+// fresh subprocess at their original pacing, see "dgbridge replay-stdin".
+//
+// A transcript is a JSON object per line. Each run of the subprocess starts
+// with an entry that has Start set, followed by the lines written in the run.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// stdinLogLineLimit is the maximum length of a line of a transcript.
+const stdinLogLineLimit = 1024 * 1024
+
+// StdinEntry is an entry of a stdin transcript.
+type StdinEntry struct {
+	Time     time.Time `json:"time"`
+	Start    bool      `json:"start,omitempty"`    // The subprocess was started, the entry has no line
+	Instance string    `json:"instance,omitempty"` // Name of the instance written to, empty for all
+	Text     string    `json:"text,omitempty"`
+}
+
+// StdinRun is a run of the subprocess in a stdin transcript.
+type StdinRun struct {
+	Started time.Time
+	Lines   []StdinEntry
+}
+
+// Offset returns the time from the start of the run to a line.
+func (r StdinRun) Offset(line StdinEntry) time.Duration {
+	return max(line.Time.Sub(r.Started), 0)
+}
+
+// StdinTranscript records a stdin transcript. It is safe for concurrent use.
+type StdinTranscript struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewStdinTranscript creates a StdinTranscript that appends entries to a
+// writer, e.g. a file opened for appending.
+func NewStdinTranscript(writer io.Writer) *StdinTranscript {
+	return &StdinTranscript{writer: writer}
+}
+
+// Start records the start of a run of the subprocess.
+func (t *StdinTranscript) Start(now time.Time) error {
+	return t.record(StdinEntry{Time: now, Start: true})
+}
+
+// Record records a line written to the subprocess.
+func (t *StdinTranscript) Record(instance string, text string, now time.Time) error {
+	return t.record(StdinEntry{Time: now, Instance: instance, Text: text})
+}
+
+func (t *StdinTranscript) record(entry StdinEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, err = t.writer.Write(append(line, '\n'))
+	return err
+}
+
+// ReadStdinTranscript reads the runs of a stdin transcript. Lines written before
+// the first start entry, e.g. of a transcript that was cut, form a run that
+// starts with its first line.
+func ReadStdinTranscript(reader io.Reader) ([]StdinRun, error) {
+	var runs []StdinRun
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, stdinLogLineLimit)
+	number := 0
+	for scanner.Scan() {
+		number++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry StdinEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %v: %v", number, err)
+		}
+		if entry.Start || len(runs) == 0 {
+			runs = append(runs, StdinRun{Started: entry.Time})
+		}
+		if !entry.Start {
+			runs[len(runs)-1].Lines = append(runs[len(runs)-1].Lines, entry)
+		}
+	}
+	return runs, scanner.Err()
+}
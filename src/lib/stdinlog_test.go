@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdinTranscript(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var buffer bytes.Buffer
+	transcript := NewStdinTranscript(&buffer)
+	assert.NoError(t, transcript.Start(start))
+	assert.NoError(t, transcript.Record("", "say hi", start.Add(time.Second)))
+	assert.NoError(t, transcript.Start(start.Add(time.Hour)))
+	assert.NoError(t, transcript.Record("lobby", "list", start.Add(time.Hour+2*time.Second)))
+
+	runs, err := ReadStdinTranscript(&buffer)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	assert.True(t, runs[0].Started.Equal(start))
+	assert.Len(t, runs[0].Lines, 1)
+	assert.Equal(t, "say hi", runs[0].Lines[0].Text)
+	assert.Equal(t, time.Second, runs[0].Offset(runs[0].Lines[0]))
+	assert.Equal(t, "lobby", runs[1].Lines[0].Instance)
+	assert.Equal(t, 2*time.Second, runs[1].Offset(runs[1].Lines[0]))
+}
+
+func TestReadStdinTranscript(t *testing.T) {
+	// A transcript without a start entry starts with its first line
+	runs, err := ReadStdinTranscript(strings.NewReader(
+		`{"time":"2024-05-01T12:00:05Z","text":"first"}` + "\n\n" +
+			`{"time":"2024-05-01T12:00:07Z","text":"second"}` + "\n"))
+	assert.NoError(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, time.Duration(0), runs[0].Offset(runs[0].Lines[0]))
+	assert.Equal(t, 2*time.Second, runs[0].Offset(runs[0].Lines[1]))
+
+	_, err = ReadStdinTranscript(strings.NewReader("{\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// LineTransformer transforms lines, e.g. by applying rules, so that the rule
+// engine can be used outside the bridge, such as in log processors and tests.
+type LineTransformer interface {
+	// Transform returns the transformed line, and false if the line is dropped.
+	Transform(line string) (string, bool)
+}
+
+// LineTransformerFunc adapts a function to a LineTransformer.
+type LineTransformerFunc func(line string) (string, bool)
+
+// Transform calls f(line).
+func (f LineTransformerFunc) Transform(line string) (string, bool) {
+	return f(line)
+}
+
+// RuleTransformer applies a list of rules to lines, see MatchRules. Lines that
+// no rule matches are dropped.
+type RuleTransformer struct {
+	Rules []Rule
+	Props *Props // Optional, see ApplyRule
+}
+
+// Transform applies the rules to a line.
+func (t RuleTransformer) Transform(line string) (string, bool) {
+	match := MatchRules(t.Rules, t.Props, line)
+	if match == nil {
+		return "", false
+	}
+	return match.Result, true
+}
+
+// Transformer returns a LineTransformer that applies the rules of a direction
+// and the content filter, like Match.
+//
+// Parameters:
+//
+//	direction: one of the Direction constants
+//	props: see MatchRules, may be nil
+func (r *Rules) Transformer(direction string, props *Props) LineTransformer {
+	return LineTransformerFunc(func(line string) (string, bool) {
+		match := r.Match(direction, props, line)
+		if match == nil {
+			return "", false
+		}
+		return match.Result, true
+	})
+}
+
+// TransformLines reads lines from reader, transforms them and writes the
+// results to writer, one per line, until reader is exhausted. Lines may be of
+// any length, and "\r\n" line endings are accepted.
+func TransformLines(reader io.Reader, writer io.Writer, transformer LineTransformer) error {
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadString('\n')
+		if line != "" {
+			if writeErr := transformLine(writer, transformer, line); writeErr != nil {
+				return writeErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// transformLine transforms a line, with or without its line ending, and writes
+// the result.
+func transformLine(writer io.Writer, transformer LineTransformer, line string) error {
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	result, ok := transformer.Transform(line)
+	if !ok {
+		return nil
+	}
+	_, err := io.WriteString(writer, result+"\n")
+	return err
+}
+
+// LineWriter is an io.WriteCloser that transforms the lines written to it and
+// writes the results to another writer. Incomplete lines are kept until they
+// are completed, or until Close.
+// It is not safe for concurrent use.
+type LineWriter struct {
+	writer      io.Writer
+	transformer LineTransformer
+	pending     []byte
+}
+
+// NewLineWriter creates a LineWriter writing to writer.
+func NewLineWriter(writer io.Writer, transformer LineTransformer) *LineWriter {
+	return &LineWriter{writer: writer, transformer: transformer}
+}
+
+// Write transforms the complete lines of p. If writing a line fails, the
+// bytes of p before that line are counted as written, so that the rest of p
+// can be written again.
+func (w *LineWriter) Write(p []byte) (int, error) {
+	buffered := len(w.pending) // Bytes of earlier writes
+	w.pending = append(w.pending, p...)
+	start := 0 // Start of the next line in pending
+	for {
+		end := bytes.IndexByte(w.pending[start:], '\n')
+		if end < 0 {
+			w.pending = w.pending[start:]
+			return len(p), nil
+		}
+		line := string(w.pending[start : start+end])
+		if err := transformLine(w.writer, w.transformer, line); err != nil {
+			// Keep the start of the line from earlier writes.
+			w.pending = w.pending[start:max(start, buffered)]
+			return max(start-buffered, 0), err
+		}
+		start += end + 1
+	}
+}
+
+// Close transforms the last line, if it is incomplete. It doesn't close the
+// underlying writer.
+func (w *LineWriter) Close() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	line := string(w.pending)
+	w.pending = nil
+	return transformLine(w.writer, w.transformer, line)
+}
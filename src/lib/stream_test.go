@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func streamRules(t *testing.T) []Rule {
+	rules := make([]Rule, 2)
+	assert.NoError(t, rules[0].Match.UnmarshalText([]byte(`^<(\w+)> (.*)$`)))
+	rules[0].Template = "**${1}**: ${2}"
+	assert.NoError(t, rules[1].Match.UnmarshalText([]byte(`^(\w+) joined$`)))
+	rules[1].Template = ":arrow_right: ${1}"
+	return rules
+}
+
+func TestTransformLines(t *testing.T) {
+	input := "<Bob> hi\r\nSaving...\nAlice joined\n<Bob> " + strings.Repeat("a", 100000)
+	var output strings.Builder
+	assert.NoError(t, TransformLines(strings.NewReader(input), &output, RuleTransformer{Rules: streamRules(t)}))
+	assert.Equal(t, "**Bob**: hi\n:arrow_right: Alice\n**Bob**: "+strings.Repeat("a", 100000)+"\n", output.String())
+
+	upper := LineTransformerFunc(func(line string) (string, bool) {
+		return strings.ToUpper(line), line != ""
+	})
+	output.Reset()
+	assert.NoError(t, TransformLines(strings.NewReader("a\n\nb"), &output, upper))
+	assert.Equal(t, "A\nB\n", output.String())
+}
+
+func TestRulesTransformer(t *testing.T) {
+	rules := Rules{SubprocessToDiscord: streamRules(t), Filter: &Filter{Words: []string{"darn"}}}
+	rules.Filter.compile()
+	var output strings.Builder
+	transformer := rules.Transformer(DirectionSubprocessToDiscord, nil)
+	assert.NoError(t, TransformLines(strings.NewReader("<Bob> darn it\n"), &output, transformer))
+	assert.Equal(t, "**Bob**: **** it\n", output.String())
+}
+
+func TestLineWriter(t *testing.T) {
+	var output strings.Builder
+	writer := NewLineWriter(&output, RuleTransformer{Rules: streamRules(t)})
+	for _, chunk := range []string{"<Bob", "> hi\nAlice jo", "ined\nnoise\n<Bob> bye"} {
+		n, err := writer.Write([]byte(chunk))
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "**Bob**: hi\n:arrow_right: Alice\n", output.String())
+	assert.NoError(t, writer.Close())
+	assert.Equal(t, "**Bob**: hi\n:arrow_right: Alice\n**Bob**: bye\n", output.String())
+}
+
+// failingWriter fails every write after the first ones.
+type failingWriter struct {
+	output strings.Builder
+	writes int // Writes that succeed
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.writes == 0 {
+		return 0, errors.New("disk full")
+	}
+	w.writes--
+	return w.output.Write(p)
+}
+
+func TestLineWriterError(t *testing.T) {
+	output := &failingWriter{writes: 1}
+	writer := NewLineWriter(output, RuleTransformer{Rules: streamRules(t)})
+	_, err := writer.Write([]byte("<Bob"))
+	assert.NoError(t, err)
+	// The line started by the earlier write fails, so nothing of p is written.
+	chunk := []byte("> hi\n<Bob> bye\n")
+	output.writes = 0
+	n, err := writer.Write(chunk)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	// The first line is written, the second fails.
+	output.writes = 1
+	n, err = writer.Write(chunk)
+	assert.Error(t, err)
+	assert.Equal(t, len("> hi\n"), n)
+	output.writes = 1
+	n, err = writer.Write(chunk[n:])
+	assert.NoError(t, err)
+	assert.Equal(t, len("<Bob> bye\n"), n)
+	assert.Equal(t, "**Bob**: hi\n**Bob**: bye\n", output.output.String())
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions of a Rule.
+const (
+	RuleActionRelay     = "relay"     // The result is relayed, the default
+	RuleActionSummarize = "summarize" // Matches during the startup window are counted, see StartupSummary
+)
+
+// StartupSummary counts the matches of SubprocessToDiscord rules with the
+// summarize action during a startup window, e.g. while the server loads its
+// plugins, so that a single summary is posted instead of every line.
+// It is safe for concurrent use.
+type StartupSummary struct {
+	mutex   sync.Mutex
+	rules   []Rule
+	until   time.Time
+	counts  map[int]int // By rule index
+	flushed bool
+}
+
+// NewStartupSummary creates a StartupSummary of the summarize rules of a list.
+//
+// Parameters:
+//
+//	until: end of the startup window
+//
+// Returns:
+//
+//	the summary, or nil if no rule has the summarize action
+func NewStartupSummary(rules []Rule, until time.Time) *StartupSummary {
+	for i := range rules {
+		if rules[i].Action == RuleActionSummarize {
+			return &StartupSummary{rules: rules, until: until, counts: make(map[int]int)}
+		}
+	}
+	return nil
+}
+
+// Until returns the end of the startup window.
+func (s *StartupSummary) Until() time.Time {
+	return s.until
+}
+
+// Count counts a match of a summarize rule, if the line was emitted during the
+// startup window and the summary wasn't flushed yet.
+//
+// Returns:
+//
+//	true if the match was counted and should not be relayed
+func (s *StartupSummary) Count(match *RuleMatch, at time.Time) bool {
+	if match.Rule.Action != RuleActionSummarize {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.flushed || at.After(s.until) {
+		return false
+	}
+	s.counts[match.Index]++
+	return true
+}
+
+// Flush ends the startup window.
+//
+// Returns:
+//
+//	the summary, e.g. "Loaded 214 plugins, 3 warnings", or "" if nothing was
+//	counted or the summary was already flushed
+func (s *StartupSummary) Flush() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.flushed {
+		return ""
+	}
+	s.flushed = true
+	var parts []string
+	for i := range s.rules {
+		if count := s.counts[i]; count > 0 {
+			parts = append(parts, strings.ReplaceAll(s.rules[i].Summary, "^#", strconv.Itoa(count)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
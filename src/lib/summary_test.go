@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartupSummary(t *testing.T) {
+	rules := make([]Rule, 3)
+	assert.NoError(t, rules[0].Match.UnmarshalText([]byte(`Loading plugin (\w+)`)))
+	rules[0].Template = "Loaded ${1}"
+	rules[0].Action = RuleActionSummarize
+	rules[0].Summary = "Loaded ^# plugins"
+	assert.NoError(t, rules[1].Match.UnmarshalText([]byte(`WARN (.*)`)))
+	rules[1].Template = ":warning: ${1}"
+	rules[1].Action = RuleActionSummarize
+	rules[1].Summary = "^# warnings"
+	assert.NoError(t, rules[2].Match.UnmarshalText([]byte(`(.*)`)))
+	rules[2].Template = "${1}"
+
+	assert.Nil(t, NewStartupSummary(rules[2:], time.Now()))
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	summary := NewStartupSummary(rules, start.Add(time.Minute))
+	lines := []string{"Loading plugin a", "Loading plugin b", "WARN slow", "Done"}
+	var relayed []string
+	for _, line := range lines {
+		match := MatchRules(rules, nil, line)
+		if !summary.Count(match, start) {
+			relayed = append(relayed, match.Result)
+		}
+	}
+	assert.Equal(t, []string{"Done"}, relayed)
+	// Lines after the window are relayed.
+	assert.False(t, summary.Count(MatchRules(rules, nil, "WARN late"), start.Add(2*time.Minute)))
+	assert.Equal(t, "Loaded 2 plugins, 1 warnings", summary.Flush())
+	assert.Equal(t, "", summary.Flush())
+	assert.False(t, summary.Count(MatchRules(rules, nil, "WARN again"), start))
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultThreadTimeout is the ThreadConfig.Timeout used if none is set.
+const DefaultThreadTimeout = 2 * time.Minute
+
+// threadNameLimit is the maximum length of a Discord thread name.
+const threadNameLimit = 100
+
+// ThreadConfig makes a SubprocessToDiscord rule open a Discord thread for the
+// incident it matched, e.g. a crash, from the message it sends. Subsequent
+// lines matching Continuation, such as a stack trace, are sent to the thread
+// instead of the relay channel, until no such line was seen for Timeout.
+type ThreadConfig struct {
+	Name         string       `validate:"required"` // Template of the thread name, like Rule.Template
+	Continuation ext.Regexp   `validate:"required"` // Lines that belong to the incident
+	Timeout      ext.Duration // Defaults to DefaultThreadTimeout
+}
+
+// ThreadName returns the name of the thread opened for a match.
+func (m *RuleMatch) ThreadName(input string) string {
+	input = strings.ReplaceAll(input, "\n", " ")
+	name := expandTemplate(m.Rule.Match.Regexp, input, m.Rule.Thread.Name, m.Rule.valueFormat())
+	name = strings.TrimSpace(ansiRegex.ReplaceAllString(name, ""))
+	if name == "" {
+		return "Incident"
+	}
+	if runes := []rune(name); len(runes) > threadNameLimit {
+		name = string(runes[:threadNameLimit])
+	}
+	return name
+}
+
+// ThreadTracker tracks the open incident thread, deciding which lines are sent
+// to it. It is safe for concurrent use.
+type ThreadTracker struct {
+	mutex    sync.Mutex
+	threadId string
+	config   *ThreadConfig
+	lastLine time.Time
+}
+
+// Open makes the tracker send continuation lines to a new thread, replacing the
+// previous one.
+func (t *ThreadTracker) Open(threadId string, config *ThreadConfig, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.threadId = threadId
+	t.config = config
+	t.lastLine = now
+}
+
+// Route reports whether a line continues the open incident.
+//
+// Returns:
+//
+//	the ID of the thread to send the line to, or "" if there's no open thread
+//	or the line doesn't continue it
+func (t *ThreadTracker) Route(line string, now time.Time) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.config == nil {
+		return ""
+	}
+	timeout := t.config.Timeout.Duration
+	if timeout <= 0 {
+		timeout = DefaultThreadTimeout
+	}
+	if now.Sub(t.lastLine) >= timeout {
+		t.config = nil
+		t.threadId = ""
+		return ""
+	}
+	if !t.config.Continuation.MatchString(ansiRegex.ReplaceAllString(line, "")) {
+		return ""
+	}
+	t.lastLine = now
+	return t.threadId
+}
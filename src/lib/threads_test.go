@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadName(t *testing.T) {
+	rules := []Rule{{Template: "**Server crashed!**", Thread: &ThreadConfig{Name: "Crash ${1}"}}}
+	assert.NoError(t, rules[0].Match.UnmarshalText([]byte(`^\[(\d{4}-\d\d-\d\d) [\d:]+] Server crashed`)))
+	input := "[2024-06-01 12:00:00] Server crashed"
+	match := MatchRules(rules, nil, input)
+	if assert.NotNil(t, match) {
+		assert.Equal(t, "Crash 2024-06-01", match.ThreadName(input))
+	}
+}
+
+func TestThreadTracker(t *testing.T) {
+	config := &ThreadConfig{Timeout: ext.Duration{Duration: time.Minute}}
+	assert.NoError(t, config.Continuation.UnmarshalText([]byte(`^\s+at `)))
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var tracker ThreadTracker
+	assert.Equal(t, "", tracker.Route("    at Main.main", start))
+
+	tracker.Open("123", config, start)
+	assert.Equal(t, "123", tracker.Route("    at Main.main", start.Add(30*time.Second)))
+	assert.Equal(t, "", tracker.Route("<Bob> hi", start.Add(40*time.Second)))
+	// The timeout restarts with every continuation line.
+	assert.Equal(t, "123", tracker.Route("    at Main.run", start.Add(80*time.Second)))
+	assert.Equal(t, "", tracker.Route("    at Main.run", start.Add(150*time.Second)))
+	assert.Equal(t, "", tracker.Route("    at Main.run", start.Add(151*time.Second)))
+}
@@ -0,0 +1,208 @@
+package lib
+
+// This file implements the ^X tokens of rule templates, and the registry that
+// programs using this package can add their own tokens to.
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RuleContext is what the tokens of a rule's template are resolved with.
+type RuleContext struct {
+	Rule  *Rule  // The rule whose template is built
+	Input string // The matched line or message, with line breaks replaced by spaces
+	// Props of the message, or of the player of a subprocess line (see
+	// Rule.Player); nil for subprocess lines without them
+	Props *Props
+}
+
+// TokenResolver returns the text a template token turns into.
+type TokenResolver func(context RuleContext) string
+
+var (
+	tokensMutex sync.RWMutex
+	// customTokens are the tokens added with RegisterToken, by character.
+	customTokens = make(map[rune]TokenResolver)
+)
+
+// builtinTokens are the built-in tokens, which need Props, by character. See
+// buildTemplate.
+var builtinTokens = map[rune]func(props *Props) string{
+	'U': func(props *Props) string {
+		if props.Author.GlobalName != "" {
+			return props.Author.GlobalName
+		}
+		return props.Author.Username
+	},
+	'T': func(props *Props) string { return props.Author.Discriminator },
+	'C': func(props *Props) string { return strconv.FormatInt(int64(props.Author.AccentColor), 16) },
+	'I': func(props *Props) string { return props.Author.Id },
+	'M': func(props *Props) string { return props.Author.Mention() },
+	'P': func(props *Props) string { return props.Instance },
+	// Message content and polls are free text, so $ must not expand groups.
+	'R': func(props *Props) string { return escapeDollars(props.RawContent) },
+	'Q': func(props *Props) string {
+		if props.Poll == nil {
+			return ""
+		}
+		return escapeDollars(props.Poll.Question)
+	},
+	'O': func(props *Props) string {
+		if props.Poll == nil {
+			return ""
+		}
+		return escapeDollars(strings.Join(props.Poll.Answers, " / "))
+	},
+	'N': func(props *Props) string {
+		if props.Author.Nickname != "" {
+			return props.Author.Nickname
+		}
+		if props.Author.GlobalName != "" {
+			return props.Author.GlobalName
+		}
+		return props.Author.Username
+	},
+}
+
+// builtinFormats are the formats of built-in tokens, written ^X{format}, by
+// token and format name.
+var builtinFormats = map[rune]map[string]func(props *Props) string{
+	'C': formatsOf(colorFormats, func(props *Props) int { return props.Author.AccentColor }),
+}
+
+// formatsOf returns token formats that format a value of Props.
+func formatsOf[T any](formats map[string]func(T) string, value func(props *Props) T) map[string]func(props *Props) string {
+	result := make(map[string]func(props *Props) string, len(formats))
+	for name, format := range formats {
+		result[name] = func(props *Props) string { return format(value(props)) }
+	}
+	return result
+}
+
+// RegisterToken adds a ^X token to rule templates, e.g. for values only known
+// to the program embedding the rule engine. Unlike the built-in tokens, custom
+// tokens are also resolved in the templates of subprocess lines without Props.
+// The text is inserted literally, so $ in it doesn't expand capture groups.
+//
+// Parameters:
+//
+//	token: the character following ^, an ASCII letter or digit that isn't
+//		used by a built-in or registered token
+//	resolve: returns the text of the token, called concurrently
+func RegisterToken(token rune, resolve TokenResolver) error {
+	if !(token >= 'a' && token <= 'z' || token >= 'A' && token <= 'Z' || token >= '0' && token <= '9') {
+		return fmt.Errorf("template token ^%c: must be an ASCII letter or digit", token)
+	}
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+	if _, ok := builtinTokens[token]; ok {
+		return fmt.Errorf("template token ^%c is built in", token)
+	}
+	if _, ok := customTokens[token]; ok {
+		return fmt.Errorf("template token ^%c is already registered", token)
+	}
+	customTokens[token] = resolve
+	return nil
+}
+
+// UnregisterToken removes a token added with RegisterToken, e.g. in tests.
+func UnregisterToken(token rune) {
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+	delete(customTokens, token)
+}
+
+// registeredTokens returns a copy of the tokens added with RegisterToken, so
+// that they are resolved without holding tokensMutex.
+func registeredTokens() map[rune]TokenResolver {
+	tokensMutex.RLock()
+	defer tokensMutex.RUnlock()
+	return maps.Clone(customTokens)
+}
+
+// Builds a rule template for Discord -> Process communication.
+// It replaces all special combinations in the template with their corresponding properties.
+//
+// Example:
+//   - ^U turns into Username
+//   - ^T turns into Discriminator
+//   - ^C turns into RoleColor/AccentColor, as hex digits; ^C{hex}, ^C{dec},
+//     ^C{mc}, ^C{mcname} and ^C{irc} format it, see colorFormats
+//   - ^N turns into Nickname (or Username if Nickname is not set)
+//   - ^I turns into the user ID
+//   - ^M turns into a mention of the user (<@ID>), or Username if the ID is not set
+//   - ^P turns into the name of the subprocess instance
+//   - ^Q turns into the question of a poll
+//   - ^O turns into the answers of a poll, separated by " / "
+//   - ^R turns into the raw content of the message, see NormalizeContent
+//
+// Tokens added with RegisterToken are replaced as well.
+//
+// Returns template with Props applied.
+func buildTemplate(template string, props Props) string {
+	return expandTokens(template, RuleContext{Props: &props})
+}
+
+// expandTokens replaces the tokens of a template, see buildTemplate. Built-in
+// tokens are left alone if the context has no Props; ^^ always turns into ^.
+func expandTokens(template string, context RuleContext) string {
+	if !strings.ContainsRune(template, '^') {
+		return template
+	}
+	registered := registeredTokens()
+	var result []rune
+	runes := []rune(template)
+	for i := 0; i < len(runes); i++ {
+		currentRune := runes[i]
+		if currentRune == '^' && i+1 < len(runes) {
+			token := runes[i+1]
+			if token == '^' {
+				// This is an escaped ^
+				result = append(result, '^')
+				i++
+				continue
+			}
+			if format, length := tokenFormat(token, runes[i+2:]); format != nil && context.Props != nil {
+				result = append(result, []rune(format(context.Props))...)
+				i += 1 + length
+				continue
+			}
+			if resolve, ok := builtinTokens[token]; ok && context.Props != nil {
+				result = append(result, []rune(resolve(context.Props))...)
+				i++
+				continue
+			}
+			if resolve, ok := registered[token]; ok {
+				result = append(result, []rune(escapeDollars(resolve(context)))...)
+				i++
+				continue
+			}
+		}
+		result = append(result, currentRune)
+	}
+	return string(result)
+}
+
+// tokenFormat returns the format of a built-in token following the token, e.g.
+// {hex}, and the number of runes it takes. Returns nil if there is no known
+// format.
+func tokenFormat(token rune, rest []rune) (func(props *Props) string, int) {
+	formats, ok := builtinFormats[token]
+	if !ok || len(rest) == 0 || rest[0] != '{' {
+		return nil, 0
+	}
+	end := slices.Index(rest, '}')
+	if end < 0 {
+		return nil, 0
+	}
+	format, ok := formats[string(rest[1:end])]
+	if !ok {
+		return nil, 0
+	}
+	return format, end + 1
+}
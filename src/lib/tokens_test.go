@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"dgbridge/src/ext"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterToken(t *testing.T) {
+	assert.NoError(t, RegisterToken('Z', func(context RuleContext) string { return "eu-west $1" }))
+	defer UnregisterToken('Z')
+	assert.NoError(t, RegisterToken('9', func(context RuleContext) string {
+		if context.Props == nil {
+			return context.Rule.Name
+		}
+		return context.Props.Author.Username
+	}))
+	defer UnregisterToken('9')
+
+	assert.Error(t, RegisterToken('U', func(RuleContext) string { return "" }))
+	assert.Error(t, RegisterToken('Z', func(RuleContext) string { return "" }))
+	assert.Error(t, RegisterToken('^', func(RuleContext) string { return "" }))
+	assert.Error(t, RegisterToken('é', func(RuleContext) string { return "" }))
+
+	rule := Rule{
+		Name:     "chat",
+		Match:    ext.Regexp{Regexp: regexp.MustCompile(`<(\w+)> (.*)`)},
+		Template: "[^Z/^9] ${1}: ${2} ^U ^^Z",
+	}
+	tests := []struct {
+		props    *Props
+		expected string
+	}{
+		// Built-in tokens need Props, custom tokens don't.
+		{nil, "[eu-west $1/chat] Steve: hi ^U ^Z"},
+		{&Props{Author: Author{Username: "Bob"}}, "[eu-west $1/Bob] Steve: hi Bob ^Z"},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, ApplyRule(rule, test.props, "<Steve> hi"), "Test #%v", i)
+	}
+}
+
+func TestExpandTokensEscape(t *testing.T) {
+	// ^^ turns into ^ even without Props or custom tokens.
+	rule := Rule{
+		Match:    ext.Regexp{Regexp: regexp.MustCompile(`<(\w+)> (.*)`)},
+		Template: "^^${1}^^: ${2}",
+	}
+	assert.Equal(t, "^Steve^: hi", ApplyRule(rule, nil, "<Steve> hi"))
+
+	// Resolvers are called without holding the registry's lock.
+	assert.NoError(t, RegisterToken('Y', func(context RuleContext) string {
+		UnregisterToken('X')
+		return "y"
+	}))
+	defer UnregisterToken('Y')
+	assert.Equal(t, "y", expandTokens("^Y", RuleContext{}))
+}
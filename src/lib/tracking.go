@@ -0,0 +1,221 @@
+package lib
+
+// This file implements the events reported to error trackers: Sentry, using
+// its envelope API, and generic HTTP endpoints receiving the event as JSON.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels of error events.
+const (
+	ErrorLevelError = "error"
+	ErrorLevelFatal = "fatal" // Panics
+)
+
+// ErrorEvent is an error or panic of the bridge, reported to error trackers.
+type ErrorEvent struct {
+	Id         string        `json:"id"` // 32 hex digits, see NewErrorEventId
+	Time       time.Time     `json:"time"`
+	Level      string        `json:"level"`           // One of the ErrorLevel constants
+	Kind       string        `json:"kind"`            // What failed, e.g. the job that panicked
+	Message    string        `json:"message"`         // The error, or the panic's value
+	Stack      string        `json:"stack,omitempty"` // Stack trace of panics
+	Rule       string        `json:"rule,omitempty"`  // ID of the rule involved, see RuleId
+	Suppressed int           `json:"suppressed"`      // Events of the kind left out since the last one
+	Metadata   ErrorMetadata `json:"metadata"`
+}
+
+// ErrorMetadata identifies the bridge that reported an event, e.g. among the
+// bridges of a hosting provider.
+type ErrorMetadata struct {
+	Version    string `json:"version"`
+	ConfigHash string `json:"configHash"`       // See ConfigHash
+	Host       string `json:"host"`             // Host name of the machine
+	Bridge     string `json:"bridge,omitempty"` // Name of the bridge, tells apart the bridges of a host
+}
+
+// NewErrorEventId returns a random event ID.
+func NewErrorEventId() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// ConfigHash returns a short hash of a configuration, e.g. the rules file, so
+// that events of bridges with the same configuration can be grouped.
+func ConfigHash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:6])
+}
+
+// SentryDsn is a parsed Sentry DSN, of the form
+// https://<public key>@<host>[/<path>]/<project>.
+type SentryDsn struct {
+	raw         string
+	publicKey   string
+	envelopeUrl string
+}
+
+// ParseSentryDsn parses a Sentry DSN.
+func ParseSentryDsn(dsn string) (*SentryDsn, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %v", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Sentry DSN: expected an http or https URL")
+	}
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: no public key")
+	}
+	path := strings.TrimSuffix(parsed.Path, "/")
+	index := strings.LastIndex(path, "/")
+	project := path[index+1:]
+	if project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: no project ID")
+	}
+	return &SentryDsn{
+		raw:         dsn,
+		publicKey:   parsed.User.Username(),
+		envelopeUrl: fmt.Sprintf("%v://%v%v/api/%v/envelope/", parsed.Scheme, parsed.Host, path[:index], project),
+	}, nil
+}
+
+// EnvelopeUrl returns the URL that envelopes are posted to.
+func (d *SentryDsn) EnvelopeUrl() string {
+	return d.envelopeUrl
+}
+
+// AuthHeader returns the X-Sentry-Auth header of requests.
+func (d *SentryDsn) AuthHeader() string {
+	client := "dgbridge"
+	if Version != "" {
+		client += "/" + Version
+	}
+	return fmt.Sprintf("Sentry sentry_version=7, sentry_key=%v, sentry_client=%v", d.publicKey, client)
+}
+
+// sentryEvent is an event in Sentry's format.
+type sentryEvent struct {
+	EventId    string            `json:"event_id"`
+	Timestamp  string            `json:"timestamp"`
+	Level      string            `json:"level"`
+	Platform   string            `json:"platform"`
+	Logger     string            `json:"logger"`
+	Release    string            `json:"release,omitempty"`
+	ServerName string            `json:"server_name,omitempty"`
+	Tags       map[string]string `json:"tags"`
+	Extra      map[string]any    `json:"extra,omitempty"`
+	Exception  struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// SentryEnvelope encodes an event as a Sentry envelope.
+func (d *SentryDsn) SentryEnvelope(event ErrorEvent) ([]byte, error) {
+	payload := sentryEvent{
+		EventId:    event.Id,
+		Timestamp:  event.Time.UTC().Format(time.RFC3339Nano),
+		Level:      event.Level,
+		Platform:   "go",
+		Logger:     "dgbridge",
+		ServerName: event.Metadata.Host,
+		Tags:       map[string]string{"kind": event.Kind, "config_hash": event.Metadata.ConfigHash},
+		Extra:      make(map[string]any),
+	}
+	if event.Metadata.Version != "" {
+		payload.Release = "dgbridge@" + event.Metadata.Version
+	}
+	if event.Rule != "" {
+		payload.Tags["rule"] = event.Rule
+	}
+	if event.Metadata.Bridge != "" {
+		payload.Tags["bridge"] = event.Metadata.Bridge
+	}
+	if event.Stack != "" {
+		payload.Extra["stack"] = event.Stack
+	}
+	if event.Suppressed > 0 {
+		payload.Extra["suppressed"] = event.Suppressed
+	}
+	payload.Exception.Values = []sentryException{{Type: event.Kind, Value: event.Message}}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(map[string]string{
+		"event_id": event.Id,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339),
+		"dsn":      d.raw,
+	})
+	if err != nil {
+		return nil, err
+	}
+	item, err := json.Marshal(map[string]any{"type": "event", "length": len(body)})
+	if err != nil {
+		return nil, err
+	}
+	var envelope bytes.Buffer
+	for _, line := range [][]byte{header, item, body} {
+		envelope.Write(line)
+		envelope.WriteByte('\n')
+	}
+	return envelope.Bytes(), nil
+}
+
+// ErrorThrottle limits the events reported to error trackers to one per kind
+// and interval, so that an error repeated on every line doesn't flood them. It
+// is safe for concurrent use.
+type ErrorThrottle struct {
+	mutex      sync.Mutex
+	interval   time.Duration
+	last       map[string]time.Time // Time of the last event, by kind
+	suppressed map[string]int       // Events left out since the last one, by kind
+}
+
+// NewErrorThrottle creates an ErrorThrottle.
+//
+// Parameters:
+//
+//	interval: minimum time between events of a kind
+func NewErrorThrottle(interval time.Duration) *ErrorThrottle {
+	return &ErrorThrottle{
+		interval:   interval,
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// Allow decides whether an event of a kind is reported.
+//
+// Returns:
+//
+//	whether to report the event, and the number of events of the kind left
+//	out since the last reported one
+func (t *ErrorThrottle) Allow(kind string, now time.Time) (bool, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if last, ok := t.last[kind]; ok && now.Sub(last) < t.interval {
+		t.suppressed[kind]++
+		return false, 0
+	}
+	suppressed := t.suppressed[kind]
+	t.last[kind] = now
+	delete(t.suppressed, kind)
+	return true, suppressed
+}
@@ -0,0 +1,407 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// UserMap maps in-game names to Discord user IDs, so that subprocess output
+// mentioning a player (e.g. "@Bob") can mention the player's Discord account.
+//
+// One Discord user may own several in-game names (e.g. alt accounts). In
+// files, a name may also be mapped to a UserEntry listing aliases; each alias
+// becomes a name of its own when the file is loaded.
+type UserMap map[string]string
+
+// UserEntry is the long form of a user map entry, for names with aliases.
+type UserEntry struct {
+	Id      string   // Discord user ID
+	Aliases []string // Other names that also mention the user, e.g. nicknames
+}
+
+// EncryptedUserMapHeader is the first line of an encrypted user map file.
+// It is followed by the base64-encoded AES-GCM nonce and ciphertext.
+const EncryptedUserMapHeader = "dgbridge-encrypted-users-v1\n"
+
+// UserMapKeyEnv is the environment variable holding the base64-encoded user map
+// key, used if no key file is given.
+const UserMapKeyEnv = "DGBRIDGE_USERS_KEY"
+
+// UserMapKeySize is the size of the AES-256 key used to encrypt user maps.
+const UserMapKeySize = 32
+
+// userTagRegex matches a mention of an in-game name in subprocess output.
+var userTagRegex = regexp.MustCompile(`@(\w+)`)
+
+// LoadUserMap loads a user map from a JSON file.
+// If the file is encrypted, it is decrypted with key; key may be nil if the
+// file is not encrypted.
+//
+// Returns an error if any user ID is not a plausible Discord ID.
+func LoadUserMap(path string, key []byte) (UserMap, error) {
+	fileContents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	userMap, err := ParseUserMap(fileContents, key)
+	if err != nil {
+		return nil, err
+	}
+	if errs := userMap.Validate(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return userMap, nil
+}
+
+// ParseUserMap parses the contents of a user map file, decrypting them with key
+// if they are encrypted.
+func ParseUserMap(contents []byte, key []byte) (UserMap, error) {
+	if IsEncryptedUserMap(contents) {
+		if key == nil {
+			return nil, fmt.Errorf("user map is encrypted, but no key was provided")
+		}
+		plaintext, err := DecryptUserMap(contents, key)
+		if err != nil {
+			return nil, err
+		}
+		contents = plaintext
+	}
+	var userMap UserMap
+	if err := json.Unmarshal(contents, &userMap); err != nil {
+		return nil, err
+	}
+	return userMap, nil
+}
+
+// UnmarshalJSON decodes a user map whose values are either Discord user IDs or
+// UserEntry objects.
+func (m *UserMap) UnmarshalJSON(data []byte) error {
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	result := make(UserMap, len(entries))
+	add := func(name string, id string) error {
+		if existingId, ok := result.Lookup(name); ok && existingId != id {
+			return fmt.Errorf("%q is mapped to both %v and %v", name, existingId, id)
+		}
+		result[name] = id
+		return nil
+	}
+	// Sort names for deterministic errors.
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var entry UserEntry
+		if err := json.Unmarshal(entries[name], &entry.Id); err != nil {
+			if err := json.Unmarshal(entries[name], &entry); err != nil {
+				return fmt.Errorf("%q: expected a Discord user ID or an object with Id and Aliases", name)
+			}
+		}
+		if err := add(name, entry.Id); err != nil {
+			return err
+		}
+		for _, alias := range entry.Aliases {
+			if err := add(alias, entry.Id); err != nil {
+				return err
+			}
+		}
+	}
+	*m = result
+	return nil
+}
+
+// MarshalUserMap encodes a user map as indented JSON, keeping the shape of the
+// entries of the file it was loaded from: an entry with aliases is written as
+// a UserEntry again as long as its name and aliases are all still mapped to
+// its user. Other names are written as plain entries.
+//
+// Parameters:
+//
+//	previous: the decrypted contents of the file the user map was loaded from,
+//		nil if there is none
+func MarshalUserMap(m UserMap, previous []byte) ([]byte, error) {
+	var entries map[string]json.RawMessage
+	if previous != nil {
+		// A previous file that can't be parsed has no shape to keep.
+		_ = json.Unmarshal(previous, &entries)
+	}
+	result := make(map[string]any, len(m))
+	written := make(map[string]bool, len(m))
+	for name, raw := range entries {
+		var entry UserEntry
+		if json.Unmarshal(raw, &entry) != nil || len(entry.Aliases) == 0 || m[name] != entry.Id {
+			continue
+		}
+		kept := true
+		for _, alias := range entry.Aliases {
+			kept = kept && m[alias] == entry.Id
+		}
+		if !kept {
+			continue
+		}
+		result[name] = entry
+		written[name] = true
+		for _, alias := range entry.Aliases {
+			written[alias] = true
+		}
+	}
+	for name, id := range m {
+		if !written[name] {
+			result[name] = id
+		}
+	}
+	contents, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(contents, '\n'), nil
+}
+
+// IsEncryptedUserMap reports whether the contents of a user map file are
+// encrypted.
+func IsEncryptedUserMap(contents []byte) bool {
+	return bytes.HasPrefix(contents, []byte(EncryptedUserMapHeader))
+}
+
+// EncryptUserMap encrypts the contents of a user map file with key.
+func EncryptUserMap(plaintext []byte, key []byte) ([]byte, error) {
+	gcm, err := newUserMapCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(EncryptedUserMapHeader))
+	encoded := base64.StdEncoding.EncodeToString(sealed)
+	return []byte(EncryptedUserMapHeader + encoded + "\n"), nil
+}
+
+// DecryptUserMap decrypts the contents of an encrypted user map file.
+func DecryptUserMap(contents []byte, key []byte) ([]byte, error) {
+	gcm, err := newUserMapCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	encoded := strings.TrimSpace(string(contents[len(EncryptedUserMapHeader):]))
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted user map: %v", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed encrypted user map: too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(EncryptedUserMapHeader))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting user map (wrong key?): %v", err)
+	}
+	return plaintext, nil
+}
+
+func newUserMapCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != UserMapKeySize {
+		return nil, fmt.Errorf("user map key must be %v bytes, got %v", UserMapKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ParseUserMapKey decodes a base64-encoded user map key.
+func ParseUserMapKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("user map key is not valid base64: %v", err)
+	}
+	if len(key) != UserMapKeySize {
+		return nil, fmt.Errorf("user map key must be %v bytes, got %v", UserMapKeySize, len(key))
+	}
+	return key, nil
+}
+
+// LoadUserMapKey loads the base64-encoded user map key from a file, or from the
+// UserMapKeyEnv environment variable if keyFile is empty.
+//
+// Returns:
+//
+//	the key, or nil if no key was given
+func LoadUserMapKey(keyFile string) ([]byte, error) {
+	encoded := os.Getenv(UserMapKeyEnv)
+	if keyFile != "" {
+		contents, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading user map key: %v", err)
+		}
+		encoded = string(contents)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	return ParseUserMapKey(encoded)
+}
+
+// GenerateUserMapKey generates a random base64-encoded user map key.
+func GenerateUserMapKey() (string, error) {
+	key := make([]byte, UserMapKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Lookup returns the Discord user ID of an in-game name.
+// Names are compared case-insensitively.
+func (m UserMap) Lookup(name string) (string, bool) {
+	if id, ok := m[name]; ok {
+		return id, true
+	}
+	for mappedName, id := range m {
+		if strings.EqualFold(mappedName, name) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// ApplyUserTags replaces mentions of in-game names (e.g. "@Bob") in a message
+// with Discord mentions of the mapped users. Unknown names are left unchanged.
+func ApplyUserTags(userMap UserMap, message string) string {
+	if len(userMap) == 0 {
+		return message
+	}
+	return userTagRegex.ReplaceAllStringFunc(message, func(tag string) string {
+		id, ok := userMap.Lookup(tag[1:])
+		if !ok {
+			return tag
+		}
+		return "<@" + id + ">"
+	})
+}
+
+// snowflakeRegex matches a plausible Discord ID: Discord IDs ("snowflakes")
+// are 17 to 20 digit numbers.
+var snowflakeRegex = regexp.MustCompile(`^[0-9]{17,20}$`)
+
+// IsSnowflake reports whether id is a plausible Discord ID.
+func IsSnowflake(id string) bool {
+	return snowflakeRegex.MatchString(id)
+}
+
+// Validate checks that all user IDs are plausible Discord IDs.
+//
+// Returns:
+//
+//	an error for each invalid entry, sorted by in-game name
+func (m UserMap) Validate() []error {
+	var errs []error
+	for _, name := range m.Names() {
+		if !IsSnowflake(m[name]) {
+			errs = append(errs, fmt.Errorf("%q: %q is not a valid Discord user ID", name, m[name]))
+		}
+	}
+	return errs
+}
+
+// Names returns all in-game names of the user map, sorted.
+func (m UserMap) Names() []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UserMapConflict describes an in-game name that is mapped to different users
+// in two user maps being merged.
+type UserMapConflict struct {
+	Name       string // In-game name
+	ExistingId string // Discord user ID in the map being merged into
+	NewId      string // Discord user ID in the map being merged from
+}
+
+// Merge adds all entries of other to the user map.
+// If a name is mapped to a different user in both maps, the existing entry is
+// kept unless overwrite is set; either way the conflict is reported.
+//
+// Returns:
+//
+//	the conflicts found, sorted by in-game name
+func (m UserMap) Merge(other UserMap, overwrite bool) []UserMapConflict {
+	var conflicts []UserMapConflict
+	for _, name := range other.Names() {
+		newId := other[name]
+		existingName, existingId := name, ""
+		for mappedName, id := range m {
+			if strings.EqualFold(mappedName, name) {
+				existingName, existingId = mappedName, id
+				break
+			}
+		}
+		if existingId != "" && existingId != newId {
+			conflicts = append(conflicts, UserMapConflict{
+				Name:       name,
+				ExistingId: existingId,
+				NewId:      newId,
+			})
+			if !overwrite {
+				continue
+			}
+		}
+		delete(m, existingName)
+		m[name] = newId
+	}
+	return conflicts
+}
+
+// ParseUserMapCSV parses a user map from CSV with the columns name and
+// discord_id. A header row with these column names is optional.
+func ParseUserMapCSV(contents []byte) (UserMap, error) {
+	reader := csv.NewReader(bytes.NewReader(contents))
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	userMap := make(UserMap, len(records))
+	for i, record := range records {
+		if i == 0 && record[0] == "name" && record[1] == "discord_id" {
+			continue
+		}
+		userMap[record[0]] = record[1]
+	}
+	return userMap, nil
+}
+
+// MarshalCSV encodes the user map as CSV with a header row, see
+// ParseUserMapCSV.
+func (m UserMap) MarshalCSV() ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	_ = writer.Write([]string{"name", "discord_id"})
+	for _, name := range m.Names() {
+		_ = writer.Write([]string{name, m[name]})
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyUserTags(t *testing.T) {
+	userMap := UserMap{
+		"Bob":   "123456789012345678",
+		"alice": "234567890123456789",
+	}
+	tests := []struct {
+		Name   string
+		Input  string
+		Expect string
+	}{
+		{"Mention", "hi @Bob!", "hi <@123456789012345678>!"},
+		{"Case-insensitive", "@ALICE @bob", "<@234567890123456789> <@123456789012345678>"},
+		{"Unknown name", "hi @Carol", "hi @Carol"},
+		{"No mention", "Bob joined", "Bob joined"},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expect, ApplyUserTags(userMap, test.Input))
+		})
+	}
+}
+
+func TestEncryptedUserMap(t *testing.T) {
+	encoded, err := GenerateUserMapKey()
+	assert.NoError(t, err)
+	key, err := ParseUserMapKey(encoded)
+	assert.NoError(t, err)
+
+	plaintext := []byte(`{"Bob": "123456789012345678"}`)
+	encrypted, err := EncryptUserMap(plaintext, key)
+	assert.NoError(t, err)
+	assert.True(t, IsEncryptedUserMap(encrypted))
+	assert.NotContains(t, string(encrypted), "Bob")
+
+	userMap, err := ParseUserMap(encrypted, key)
+	assert.NoError(t, err)
+	assert.Equal(t, UserMap{"Bob": "123456789012345678"}, userMap)
+
+	_, err = ParseUserMap(encrypted, nil)
+	assert.Error(t, err)
+	otherKey := make([]byte, UserMapKeySize)
+	_, err = ParseUserMap(encrypted, otherKey)
+	assert.Error(t, err)
+
+	userMap, err = ParseUserMap(plaintext, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, UserMap{"Bob": "123456789012345678"}, userMap)
+}
+
+func TestUserMapValidate(t *testing.T) {
+	userMap := UserMap{
+		"Bob":   "123456789012345678",
+		"Carol": "12345",
+		"Dave":  "not-an-id",
+	}
+	errs := userMap.Validate()
+	if assert.Len(t, errs, 2) {
+		assert.Contains(t, errs[0].Error(), "Carol")
+		assert.Contains(t, errs[1].Error(), "Dave")
+	}
+}
+
+func TestUserMapMerge(t *testing.T) {
+	userMap := UserMap{
+		"Bob":   "123456789012345678",
+		"Alice": "234567890123456789",
+	}
+	conflicts := userMap.Merge(UserMap{
+		"alice": "345678901234567890",
+		"Bob":   "123456789012345678",
+		"Carol": "456789012345678901",
+	}, false)
+	assert.Equal(t, []UserMapConflict{{
+		Name:       "alice",
+		ExistingId: "234567890123456789",
+		NewId:      "345678901234567890",
+	}}, conflicts)
+	assert.Equal(t, UserMap{
+		"Bob":   "123456789012345678",
+		"Alice": "234567890123456789",
+		"Carol": "456789012345678901",
+	}, userMap)
+
+	userMap.Merge(UserMap{"alice": "345678901234567890"}, true)
+	assert.Equal(t, "345678901234567890", userMap["alice"])
+	assert.NotContains(t, userMap, "Alice")
+}
+
+func TestUserMapCSV(t *testing.T) {
+	userMap := UserMap{
+		"Bob":   "123456789012345678",
+		"Alice": "234567890123456789",
+	}
+	encoded, err := userMap.MarshalCSV()
+	assert.NoError(t, err)
+	assert.Equal(t, "name,discord_id\nAlice,234567890123456789\nBob,123456789012345678\n", string(encoded))
+
+	decoded, err := ParseUserMapCSV(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, userMap, decoded)
+
+	decoded, err = ParseUserMapCSV([]byte("Bob, 123456789012345678\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, UserMap{"Bob": "123456789012345678"}, decoded)
+}
+
+func TestLoadUserMapRejectsInvalidIds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"Bob": "123456789012345678", "Carol": "@carol"}`), 0600))
+	_, err := LoadUserMap(path, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Carol")
+	}
+}
+
+func TestParseUserMapAliases(t *testing.T) {
+	userMap, err := ParseUserMap([]byte(`{
+		"Bob": {"Id": "123456789012345678", "Aliases": ["bobby", "B0b"]},
+		"BobAlt": "123456789012345678",
+		"Alice": "234567890123456789"
+	}`), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, UserMap{
+		"Bob":    "123456789012345678",
+		"bobby":  "123456789012345678",
+		"B0b":    "123456789012345678",
+		"BobAlt": "123456789012345678",
+		"Alice":  "234567890123456789",
+	}, userMap)
+	assert.Equal(t, "<@123456789012345678> <@123456789012345678>", ApplyUserTags(userMap, "@Bobby @BobAlt"))
+
+	_, err = ParseUserMap([]byte(`{
+		"Bob": {"Id": "123456789012345678", "Aliases": ["alice"]},
+		"Alice": "234567890123456789"
+	}`), nil)
+	assert.Error(t, err)
+
+	_, err = ParseUserMap([]byte(`{"Bob": 123}`), nil)
+	assert.Error(t, err)
+}
+
+func TestMarshalUserMap(t *testing.T) {
+	previous := []byte(`{
+		"Bob": {"Id": "123456789012345678", "Aliases": ["bobby"]},
+		"Carol": {"Id": "345678901234567890", "Aliases": ["caz"]},
+		"Alice": "234567890123456789"
+	}`)
+	userMap, err := ParseUserMap(previous, nil)
+	assert.NoError(t, err)
+	userMap["Dave"] = "456789012345678901"
+	// Carol's alias now mentions another user, so the entry is split.
+	userMap["caz"] = "456789012345678901"
+
+	contents, err := MarshalUserMap(userMap, previous)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"Bob": {"Id": "123456789012345678", "Aliases": ["bobby"]},
+		"Carol": "345678901234567890",
+		"caz": "456789012345678901",
+		"Alice": "234567890123456789",
+		"Dave": "456789012345678901"
+	}`, string(contents))
+	loaded, err := ParseUserMap(contents, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, userMap, loaded)
+
+	contents, err = MarshalUserMap(UserMap{"Bob": "123456789012345678"}, nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Bob": "123456789012345678"}`, string(contents))
+}
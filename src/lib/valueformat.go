@@ -0,0 +1,191 @@
+package lib
+
+// This file implements the named modifiers of template expressions, which
+// format a capture group for the reader instead of with a printf format:
+//
+//   - ${1|num} inserts a number with the thousand separators of the rule's
+//     locale, e.g. 1,234,567 or 1.234.567
+//   - ${1|num:2} also rounds it to two decimal places
+//   - ${1|time} inserts a timestamp as a Discord timestamp, e.g. <t:1717268400>,
+//     which Discord shows in each viewer's time zone and language
+//   - ${1|time:R} inserts it with a Discord timestamp style, e.g. "in 2 hours"
+//
+// Timestamps are Unix times in seconds or milliseconds, or are parsed with the
+// rule's TimeLayout.
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Named modifiers of template expressions.
+const (
+	modifierNumber = "num"
+	modifierTime   = "time"
+)
+
+// modifierRegex matches the named modifiers of template expressions, with an
+// optional argument, e.g. "num:2" or "time:R".
+var modifierRegex = regexp.MustCompile(`^(num|time)(?::(\w+))?$`)
+
+// discordTimestampStyles are the styles of Discord timestamps, e.g. R for a
+// relative time.
+const discordTimestampStyles = "tTdDfFR"
+
+// unixMillisThreshold is the smallest Unix time taken to be in milliseconds:
+// the year 2001 in milliseconds, but the year 33658 in seconds.
+const unixMillisThreshold = 1e12
+
+// numberSeparators are the thousand and decimal separators of the locales of
+// the message catalogs.
+var numberSeparators = map[string][2]string{
+	"en": {",", "."},
+	"de": {".", ","},
+	"es": {".", ","},
+	"fr": {"\u202f", ","}, // Narrow no-break space
+	"pt": {".", ","},
+}
+
+// valueFormat configures the named modifiers of a rule's templates.
+type valueFormat struct {
+	locale     string         // See Rule.Locale
+	timeLayout string         // See Rule.TimeLayout
+	location   *time.Location // See Rule.TimeZone, nil for the local time zone
+}
+
+// valueFormat returns the configuration of the named modifiers of the rule's
+// templates.
+func (rule *Rule) valueFormat() valueFormat {
+	return valueFormat{locale: rule.Locale, timeLayout: rule.TimeLayout, location: rule.location}
+}
+
+// compile loads the TimeZone of the rule, so that it isn't loaded for every
+// match, and checks the number formats of its templates, see
+// checkNumberFormats. It is called by ParseRules; invalid time zones are
+// reported by Rules.Validate, and the local time zone is used instead.
+func (rule *Rule) compile() error {
+	rule.location = nil
+	if rule.TimeZone != "" {
+		rule.location, _ = time.LoadLocation(rule.TimeZone)
+	}
+	templates := []string{rule.Template}
+	if rule.Thread != nil {
+		templates = append(templates, rule.Thread.Name)
+	}
+	if rule.Event != nil {
+		templates = append(templates, rule.Event.Name, rule.Event.Description, rule.Event.Start, rule.Event.Location)
+	}
+	for _, template := range templates {
+		if err := checkNumberFormats(template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseModifier parses the named modifier of a template expression.
+//
+// Returns:
+//
+//	the modifier and its argument, and false if the format isn't a named
+//	modifier, e.g. a printf format
+func parseModifier(format string) (string, string, bool) {
+	parts := modifierRegex.FindStringSubmatch(format)
+	if parts == nil {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// applyModifier formats a number with a named modifier.
+//
+// Parameters:
+//
+//	modifier: one of the modifier constants
+//	argument: the decimal places of modifierNumber, or the timestamp style of
+//		modifierTime; may be empty
+func (f valueFormat) applyModifier(value float64, modifier string, argument string) string {
+	if modifier == modifierTime {
+		seconds := value
+		if math.Abs(seconds) >= unixMillisThreshold {
+			seconds /= 1000
+		}
+		return discordTimestamp(time.Unix(int64(math.Floor(seconds)), 0), argument)
+	}
+	return f.formatNumber(value, argument)
+}
+
+// formatNumber formats a number with the thousand and decimal separators of
+// the locale.
+//
+// Parameters:
+//
+//	decimals: the number of decimal places, or "" to keep those of the value
+func (f valueFormat) formatNumber(value float64, decimals string) string {
+	text := strconv.FormatFloat(value, 'f', -1, 64)
+	if places, err := strconv.Atoi(decimals); err == nil {
+		text = strconv.FormatFloat(value, 'f', places, 64)
+	}
+	separators, ok := numberSeparators[f.locale]
+	if !ok {
+		separators, ok = numberSeparators[locale]
+	}
+	if !ok {
+		separators = numberSeparators[DefaultLocale]
+	}
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	integer, fraction, hasFraction := strings.Cut(text, ".")
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteString(separators[0])
+		}
+		grouped.WriteRune(digit)
+	}
+	if hasFraction {
+		return sign + grouped.String() + separators[1] + fraction
+	}
+	return sign + grouped.String()
+}
+
+// parseTimestamp parses a timestamp that isn't a Unix time with the rule's
+// TimeLayout, in its TimeZone.
+//
+// Returns:
+//
+//	the time, and false if the rule has no TimeLayout or it doesn't match
+func (f valueFormat) parseTimestamp(text string) (time.Time, bool) {
+	if f.timeLayout == "" {
+		return time.Time{}, false
+	}
+	location := f.location
+	if location == nil {
+		location = time.Local
+	}
+	parsed, err := time.ParseInLocation(f.timeLayout, strings.TrimSpace(text), location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return parsed, true
+}
+
+// discordTimestamp returns the Discord timestamp of a time, e.g.
+// <t:1717268400:R>.
+//
+// Parameters:
+//
+//	style: one of discordTimestampStyles, or "" for Discord's default; other
+//		styles are ignored
+func discordTimestamp(t time.Time, style string) string {
+	if len(style) == 1 && strings.Contains(discordTimestampStyles, style) {
+		return fmt.Sprintf("<t:%v:%v>", t.Unix(), style)
+	}
+	return fmt.Sprintf("<t:%v>", t.Unix())
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two versions such as "v1.0.5" or "1.1.0-rc1".
+// Numeric parts are compared as numbers, and a version with a pre-release
+// suffix is older than the same version without one.
+//
+// Returns:
+//
+//	-1 if a is older than b, 0 if they are equal, 1 if a is newer
+func CompareVersions(a string, b string) int {
+	aCore, aPre, _ := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	bCore, bPre, _ := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+	aParts := strings.Split(aCore, ".")
+	bParts := strings.Split(bCore, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		if c := compareVersionPart(aParts, bParts, i); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre < bPre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// compareVersionPart compares the i-th numeric part of two versions; missing
+// parts are 0.
+func compareVersionPart(a []string, b []string, i int) int {
+	var aValue, bValue int
+	if i < len(a) {
+		aValue, _ = strconv.Atoi(a[i])
+	}
+	if i < len(b) {
+		bValue, _ = strconv.Atoi(b[i])
+	}
+	switch {
+	case aValue < bValue:
+		return -1
+	case aValue > bValue:
+		return 1
+	}
+	return 0
+}
+
+// SummarizeReleaseNotes returns the first lines of a release's notes, e.g. the
+// Markdown changelog of a GitHub release, without headings and empty lines.
+// If lines were left out, the summary ends with "…".
+func SummarizeReleaseNotes(notes string, maxLines int) string {
+	var lines []string
+	truncated := false
+	for _, line := range strings.Split(strings.ReplaceAll(notes, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(lines) == maxLines {
+			truncated = true
+			break
+		}
+		lines = append(lines, line)
+	}
+	if truncated {
+		lines = append(lines, "…")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.0.5", "v1.0.5", 0},
+		{"v1.0.5", "1.0.5", 0},
+		{"v1.0.5", "v1.0.10", -1},
+		{"v1.1.0", "v1.0.10", 1},
+		{"v1.1", "v1.1.0", 0},
+		{"v2.0.0-rc1", "v2.0.0", -1},
+		{"v2.0.0-rc2", "v2.0.0-rc1", 1},
+		{"v2.0.0-rc1", "v1.9.9", 1},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, CompareVersions(test.a, test.b), "Test #%v", i)
+	}
+}
+
+func TestSummarizeReleaseNotes(t *testing.T) {
+	notes := "## Changes\r\n\r\n* Added A\r\n* Fixed B\r\n\r\n### Other\n* Updated C\n"
+	assert.Equal(t, "* Added A\n* Fixed B\n* Updated C", SummarizeReleaseNotes(notes, 5))
+	assert.Equal(t, "* Added A\n* Fixed B\n…", SummarizeReleaseNotes(notes, 2))
+	assert.Equal(t, "", SummarizeReleaseNotes("", 5))
+}
@@ -0,0 +1,216 @@
+package lib
+
+// This file implements the built-in moderation workflows: a Discord slash
+// command is translated to a console command, and the console's confirmation
+// or error decides the command's reply.
+
+import (
+	"dgbridge/src/ext"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWorkflowTimeout is the Workflow.Timeout used if none is set.
+const DefaultWorkflowTimeout = 5 * time.Second
+
+// Names of the workflows, see Workflows.Get.
+const (
+	WorkflowWhitelistAdd    = "WhitelistAdd"
+	WorkflowWhitelistRemove = "WhitelistRemove"
+	WorkflowBan             = "Ban"
+)
+
+// Presets of workflows, see Workflows.Preset.
+const (
+	WorkflowPresetMinecraft = "minecraft"
+)
+
+// workflowPlayerExpr matches the player names accepted by workflows. They are
+// written to the console, so they may not contain spaces or line breaks.
+var workflowPlayerExpr = regexp.MustCompile(`^[\w.\-]{1,64}$`)
+
+// workflowPresets are the workflows of the presets, by preset and workflow
+// name.
+var workflowPresets = map[string]map[string]*Workflow{
+	WorkflowPresetMinecraft: {
+		WorkflowWhitelistAdd: {
+			Command: "whitelist add ^P",
+			Success: workflowRegexp(`Added \S+ to the whitelist`),
+			Failure: workflowRegexp(`Player is already whitelisted|That player does not exist|Unknown or incomplete command|Incorrect argument`),
+		},
+		WorkflowWhitelistRemove: {
+			Command: "whitelist remove ^P",
+			Success: workflowRegexp(`Removed \S+ from the whitelist`),
+			Failure: workflowRegexp(`Player is not whitelisted|That player does not exist|Unknown or incomplete command|Incorrect argument`),
+		},
+		WorkflowBan: {
+			Command: "ban ^P ^R",
+			Success: workflowRegexp(`Banned \S+: `),
+			Failure: workflowRegexp(`Nothing changed\. The player is already banned|That player does not exist|Unknown or incomplete command|Incorrect argument`),
+		},
+	},
+}
+
+func workflowRegexp(expr string) ext.Regexp {
+	return ext.Regexp{Regexp: regexp.MustCompile(expr)}
+}
+
+// Workflows configures the moderation slash commands: /whitelist add,
+// /whitelist remove and /ban. Commands without a workflow are not offered.
+type Workflows struct {
+	// Optional, takes the workflows that aren't configured from a preset, one
+	// of the WorkflowPreset constants
+	Preset          string    `validate:"omitempty,oneof=minecraft"`
+	WhitelistAdd    *Workflow // /whitelist add
+	WhitelistRemove *Workflow // /whitelist remove
+	Ban             *Workflow // /ban
+}
+
+// Workflow is a console command run by a slash command, and the lines of its
+// outcome.
+type Workflow struct {
+	// Template of the command, where ^P turns into the player, ^R into the
+	// reason and ^^ into ^
+	Command string     `validate:"required"`
+	Success ext.Regexp `validate:"required"` // Line confirming that the command succeeded
+	Failure ext.Regexp // Optional, line reporting that the command failed
+	Timeout ext.Duration
+}
+
+// WorkflowResult is the outcome of a workflow.
+type WorkflowResult struct {
+	Success  bool
+	TimedOut bool   // Neither Success nor Failure matched in time
+	Line     string // The line that matched, without ANSI color codes
+}
+
+// Get returns the configured workflow of a name, or the preset's.
+//
+// Parameters:
+//
+//	name: one of the Workflow constants
+//
+// Returns:
+//
+//	the workflow, or nil if there's none
+func (w *Workflows) Get(name string) *Workflow {
+	if w == nil {
+		return nil
+	}
+	var workflow *Workflow
+	switch name {
+	case WorkflowWhitelistAdd:
+		workflow = w.WhitelistAdd
+	case WorkflowWhitelistRemove:
+		workflow = w.WhitelistRemove
+	case WorkflowBan:
+		workflow = w.Ban
+	}
+	if workflow == nil {
+		workflow = workflowPresets[w.Preset][name]
+	}
+	return workflow
+}
+
+// FormatCommand returns the console command of the workflow.
+//
+// Parameters:
+//
+//	player: name of the player, see ValidWorkflowPlayer
+//	reason: reason given by the moderator, may be empty; line breaks are
+//		replaced with spaces
+func (w *Workflow) FormatCommand(player string, reason string) string {
+	reason = strings.Join(strings.Fields(reason), " ")
+	command := expandParameters(w.Command, map[byte]string{'P': player, 'R': reason, '^': "^"})
+	return strings.TrimSpace(command)
+}
+
+// timeout returns the time to wait for the outcome of the workflow.
+func (w *Workflow) timeout() time.Duration {
+	if w.Timeout.Duration <= 0 {
+		return DefaultWorkflowTimeout
+	}
+	return w.Timeout.Duration
+}
+
+// ValidWorkflowPlayer checks that a player name can be written to the console.
+func ValidWorkflowPlayer(player string) error {
+	if !workflowPlayerExpr.MatchString(player) {
+		return fmt.Errorf("invalid player name %q", player)
+	}
+	return nil
+}
+
+// WorkflowRunner runs workflows one at a time, so that the console's lines
+// can be attributed to the workflow that is running. It is safe for
+// concurrent use.
+type WorkflowRunner struct {
+	runMutex sync.Mutex // Held while a workflow runs
+	mutex    sync.Mutex // Guards pending
+	pending  *pendingWorkflow
+}
+
+// pendingWorkflow is a workflow waiting for its outcome.
+type pendingWorkflow struct {
+	workflow *Workflow
+	result   chan WorkflowResult // Receives the outcome, buffered
+}
+
+// Run runs a workflow, waiting for workflows that are already running first.
+//
+// Parameters:
+//
+//	write: writes the command to the console
+//
+// Returns:
+//
+//	the outcome, once a line matched or the workflow's timeout passed
+func (r *WorkflowRunner) Run(workflow *Workflow, player string, reason string, write func(command string)) WorkflowResult {
+	r.runMutex.Lock()
+	defer r.runMutex.Unlock()
+	pending := &pendingWorkflow{workflow: workflow, result: make(chan WorkflowResult, 1)}
+	r.mutex.Lock()
+	r.pending = pending
+	r.mutex.Unlock()
+	defer func() {
+		r.mutex.Lock()
+		r.pending = nil
+		r.mutex.Unlock()
+	}()
+
+	write(workflow.FormatCommand(player, reason))
+	timer := time.NewTimer(workflow.timeout())
+	defer timer.Stop()
+	select {
+	case result := <-pending.result:
+		return result
+	case <-timer.C:
+		return WorkflowResult{TimedOut: true}
+	}
+}
+
+// Observe checks whether a console line is the outcome of the running
+// workflow. Failure is checked before Success.
+func (r *WorkflowRunner) Observe(line string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.pending == nil {
+		return
+	}
+	line = StripAnsi(line)
+	workflow := r.pending.workflow
+	var result WorkflowResult
+	switch {
+	case workflow.Failure.Regexp != nil && workflow.Failure.MatchString(line):
+		result = WorkflowResult{Success: false, Line: line}
+	case workflow.Success.MatchString(line):
+		result = WorkflowResult{Success: true, Line: line}
+	default:
+		return
+	}
+	r.pending.result <- result
+	r.pending = nil
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkflowsGet(t *testing.T) {
+	var workflows Workflows
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"Preset": "minecraft",
+		"Ban": {"Command": "banlist add ^P ^R", "Success": "^OK$"}
+	}`), &workflows))
+	assert.Equal(t, "whitelist add Bob", workflows.Get(WorkflowWhitelistAdd).FormatCommand("Bob", ""))
+	assert.Equal(t, "banlist add Bob Griefing spawn", workflows.Get(WorkflowBan).FormatCommand("Bob", "Griefing\nspawn "))
+
+	assert.Nil(t, (&Workflows{}).Get(WorkflowBan))
+	assert.Nil(t, (*Workflows)(nil).Get(WorkflowBan))
+}
+
+func TestValidWorkflowPlayer(t *testing.T) {
+	assert.NoError(t, ValidWorkflowPlayer("Bob_42"))
+	assert.NoError(t, ValidWorkflowPlayer(".BedrockBob"))
+	assert.Error(t, ValidWorkflowPlayer(""))
+	assert.Error(t, ValidWorkflowPlayer("Bob\nop Bob"))
+	assert.Error(t, ValidWorkflowPlayer("Bob Alice"))
+}
+
+func TestWorkflowRunner(t *testing.T) {
+	preset := (&Workflows{Preset: WorkflowPresetMinecraft}).Get(WorkflowWhitelistAdd)
+	tests := []struct {
+		lines    []string
+		expected WorkflowResult
+	}{
+		{
+			[]string{"[12:00:00] [Server thread/INFO]: <Bob> hi", "\x1b[0m[12:00:01] [Server thread/INFO]: Added Bob to the whitelist"},
+			WorkflowResult{Success: true, Line: "[12:00:01] [Server thread/INFO]: Added Bob to the whitelist"},
+		},
+		{
+			[]string{"[12:00:01] [Server thread/INFO]: Player is already whitelisted"},
+			WorkflowResult{Line: "[12:00:01] [Server thread/INFO]: Player is already whitelisted"},
+		},
+	}
+	for i, test := range tests {
+		var runner WorkflowRunner
+		var commands []string
+		result := runner.Run(preset, "Bob", "", func(command string) {
+			commands = append(commands, command)
+			for _, line := range test.lines {
+				runner.Observe(line)
+			}
+		})
+		assert.Equal(t, test.expected, result, "Test #%v", i)
+		assert.Equal(t, []string{"whitelist add Bob"}, commands, "Test #%v", i)
+	}
+}
+
+func TestWorkflowRunnerTimeout(t *testing.T) {
+	var runner WorkflowRunner
+	workflow := &Workflow{Command: "ban ^P", Success: workflowRegexp(`^Banned`)}
+	workflow.Timeout.Duration = 10 * time.Millisecond
+	result := runner.Run(workflow, "Bob", "", func(command string) {})
+	assert.Equal(t, WorkflowResult{TimedOut: true}, result)
+	// Lines after the workflow are ignored.
+	runner.Observe("Banned Bob")
+}
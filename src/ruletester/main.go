@@ -12,8 +12,13 @@ import (
 var validate *validator.Validate
 
 type CliArgs struct {
-	RulesFile string `arg:"required,-r,--rules" help:"Rules to be tested"`
-	TestFile  string `arg:"required,-t,--test"  help:"Path to test file"`
+	RulesFile  string `arg:"required,-r,--rules" help:"Rules to be tested"`
+	TestFile   string `arg:"-t,--test"  help:"Path to test file, required unless a corpus is given"`
+	UsersFile  string `arg:"-u,--users" help:"Path to the users file, may be encrypted (key from DGBRIDGE_USERS_KEY)"`
+	CheckLoops bool   `arg:"--check_loops" help:"Check that rule results don't match SubprocessToDiscord rules again, which may cause feedback loops"`
+	// Corpora measure which rules carry traffic, see CorpusMetrics
+	Corpus        string `arg:"--corpus" help:"File of subprocess lines, e.g. a server log, to report SubprocessToDiscord rule metrics for"`
+	DiscordCorpus string `arg:"--discord_corpus" help:"File of Discord messages, one per line, to report DiscordToSubprocess rule metrics for"`
 }
 
 func main() {
@@ -28,7 +33,10 @@ func main() {
 	// Parse CLI args
 	//
 	var args CliArgs
-	arg.MustParse(&args)
+	parser := arg.MustParse(&args)
+	if args.TestFile == "" && args.Corpus == "" && args.DiscordCorpus == "" {
+		parser.Fail("--test is required unless --corpus or --discord_corpus is given")
+	}
 
 	//
 	// Load files from CLI parameters
@@ -38,14 +46,41 @@ func main() {
 		printError("Failed to load rules file: %v", err)
 		os.Exit(1)
 	}
-	root, err := loadFileRoot(args)
+	userMap, err := loadUsersFile(args)
 	if err != nil {
-		printError("Failed to load test file: %v", err)
+		printError("Failed to load users file: %v", err)
 		os.Exit(1)
 	}
 
-	testRunner := NewTestRunner(root, rules)
-	testRunner.RunTests()
+	if args.TestFile != "" {
+		root, err := loadFileRoot(args)
+		if err != nil {
+			printError("Failed to load test file: %v", err)
+			os.Exit(1)
+		}
+		testRunner := NewTestRunner(root, rules, userMap, args.CheckLoops)
+		testRunner.RunTests()
+	}
+
+	corpora := []struct {
+		direction string
+		path      string
+		props     *lib.Props
+	}{
+		{lib.DirectionSubprocessToDiscord, args.Corpus, nil},
+		{lib.DirectionDiscordToSubprocess, args.DiscordCorpus, &lib.ExampleProps},
+	}
+	for _, corpus := range corpora {
+		if corpus.path == "" {
+			continue
+		}
+		metrics, err := measureCorpus(rules, corpus.direction, corpus.path, corpus.props)
+		if err != nil {
+			printError("Failed to read corpus: %v\n", err)
+			os.Exit(1)
+		}
+		metrics.Print(rules)
+	}
 }
 
 func loadFileRoot(args CliArgs) (*FileRoot, error) {
@@ -71,7 +106,7 @@ func loadRulesFile(args CliArgs) (*lib.Rules, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error loading rules: %v", err)
 	}
-	if err := validate.Struct(rules); err != nil {
+	if err := rules.Validate(); err != nil {
 		return nil, fmt.Errorf(
 			"Validation of rules file failed.\n"+
 				"Please look at the errors below and try to fix them.\n"+
@@ -80,6 +115,17 @@ func loadRulesFile(args CliArgs) (*lib.Rules, error) {
 	return rules, nil
 }
 
+func loadUsersFile(args CliArgs) (lib.UserMap, error) {
+	if args.UsersFile == "" {
+		return nil, nil
+	}
+	key, err := lib.LoadUserMapKey("")
+	if err != nil {
+		return nil, err
+	}
+	return lib.LoadUserMap(args.UsersFile, key)
+}
+
 func printError(format string, vargs ...any) {
 	_, _ = fmt.Fprintf(os.Stderr, format, vargs...)
 }
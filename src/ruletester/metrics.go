@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"dgbridge/src/lib"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// corpusLineLimit is the maximum length of a corpus line.
+const corpusLineLimit = 1024 * 1024
+
+// RuleMetrics are the metrics of a rule over a corpus.
+type RuleMetrics struct {
+	Matches     int
+	TotalLength int           // Characters of the results
+	Longest     time.Duration // Longest time a line matched by the rule took to transform
+}
+
+// AverageLength returns the average number of characters of the results.
+func (m RuleMetrics) AverageLength() float64 {
+	if m.Matches == 0 {
+		return 0
+	}
+	return float64(m.TotalLength) / float64(m.Matches)
+}
+
+// CorpusMetrics are the metrics of the rules of a direction over a corpus, e.g.
+// a server log, showing which rules carry traffic.
+type CorpusMetrics struct {
+	Direction string
+	Lines     int
+	Unmatched int
+	Rules     []RuleMetrics // By rule index
+}
+
+// measureCorpus applies the rules of a direction to each line of a corpus
+// file, like the bridge does.
+//
+// Parameters:
+//
+//	props: the Props of the Discord messages of DiscordToSubprocess, nil for
+//	subprocess lines
+func measureCorpus(rules *lib.Rules, direction string, path string, props *lib.Props) (CorpusMetrics, error) {
+	metrics := CorpusMetrics{Direction: direction, Rules: make([]RuleMetrics, len(rules.List(direction)))}
+	file, err := os.Open(path)
+	if err != nil {
+		return metrics, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), corpusLineLimit)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		metrics.Lines++
+		start := time.Now()
+		var match *lib.RuleMatch
+		if props != nil {
+			match = rules.Match(direction, props, line)
+		} else {
+			match = rules.MatchPlayer(direction, lib.LineInfo{}, line, playerProps)
+		}
+		elapsed := time.Since(start)
+		if match == nil {
+			metrics.Unmatched++
+			continue
+		}
+		rule := &metrics.Rules[match.Index]
+		rule.Matches++
+		rule.TotalLength += utf8.RuneCountInString(match.Result)
+		rule.Longest = max(rule.Longest, elapsed)
+	}
+	return metrics, scanner.Err()
+}
+
+// Print prints a table of the metrics of each rule.
+func (m CorpusMetrics) Print(rules *lib.Rules) {
+	printHeading(fmt.Sprintf("%v corpus: %v lines\n", m.Direction, m.Lines))
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "Rule\tMatches\tShare\tAvg. length\tLongest")
+	list := rules.List(m.Direction)
+	for i, metrics := range m.Rules {
+		_, _ = fmt.Fprintf(writer, "%v\t%v\t%v\t%.1f\t%v\n",
+			lib.RuleId(&list[i], i), metrics.Matches, formatShare(metrics.Matches, m.Lines),
+			metrics.AverageLength(), metrics.Longest.Round(time.Microsecond))
+	}
+	_, _ = fmt.Fprintf(writer, "(none)\t%v\t%v\n", m.Unmatched, formatShare(m.Unmatched, m.Lines))
+	_ = writer.Flush()
+}
+
+// formatShare formats the share of the corpus lines, e.g. "12.5%".
+func formatShare(count int, lines int) string {
+	if lines == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(count)*100/float64(lines))
+}
@@ -7,8 +7,10 @@ import (
 )
 
 type TestRunner struct {
-	TestFile *FileRoot
-	Rules    *lib.Rules
+	TestFile   *FileRoot
+	Rules      *lib.Rules
+	UserMap    lib.UserMap
+	CheckLoops bool // Also run loop checks, see LoopCheck
 }
 
 type TestResults struct {
@@ -20,10 +22,12 @@ type Test interface {
 	Run(testRunner *TestRunner, number int, rules *lib.Rules) bool
 }
 
-func NewTestRunner(testFile *FileRoot, rules *lib.Rules) TestRunner {
+func NewTestRunner(testFile *FileRoot, rules *lib.Rules, userMap lib.UserMap, checkLoops bool) TestRunner {
 	return TestRunner{
-		TestFile: testFile,
-		Rules:    rules,
+		TestFile:   testFile,
+		Rules:      rules,
+		UserMap:    userMap,
+		CheckLoops: checkLoops,
 	}
 }
 
@@ -34,6 +38,18 @@ func (r *TestRunner) RunTests() {
 	}
 	results.Add(RunTests(r, "SubprocessToDiscord", r.TestFile.Tests.SubprocessToDiscord, r.Rules))
 	results.Add(RunTests(r, "DiscordToSubprocess", r.TestFile.Tests.DiscordToSubprocess, r.Rules))
+	if len(r.TestFile.Tests.Cases) > 0 {
+		results.Add(RunTests(r, "Cases", r.TestFile.Tests.Cases, r.Rules))
+	}
+	if len(r.TestFile.Tests.UserTags) > 0 {
+		results.Add(RunTests(r, "UserTags", r.TestFile.Tests.UserTags, r.Rules))
+	}
+	if examples := r.exampleTests(); len(examples) > 0 {
+		results.Add(RunTests(r, "Rule examples", examples, r.Rules))
+	}
+	if r.CheckLoops {
+		results.Add(RunTests(r, "Loop check", r.loopChecks(), r.Rules))
+	}
 
 	fmt.Printf("Finished: Tests passed: %v, failed: %v\n", results.Passed, results.Failed)
 }
@@ -58,23 +74,31 @@ func RunTests[T Test](testRunner *TestRunner, bannerTitle string, tests []T, rul
 }
 
 func printBanner(bannerTitle string, amountTests int) {
-	banner := fmt.Sprintf("%v tests: Running %v tests\n", bannerTitle, amountTests)
-	{
-		line := strings.Repeat("-", len(banner))
-		banner = line + "\n" + banner + line + "\n"
-	}
-	fmt.Printf(banner)
+	printHeading(fmt.Sprintf("%v tests: Running %v tests\n", bannerTitle, amountTests))
+}
+
+// printHeading prints a line of text between rulers.
+func printHeading(banner string) {
+	line := strings.Repeat("-", len(banner))
+	fmt.Print(line + "\n" + banner + line + "\n")
+}
+
+// lineInfo returns where the test's line came from.
+func (t SubprocessToDiscordTest) lineInfo() lib.LineInfo {
+	return lib.LineInfo{Stderr: t.Source == lib.SourceStderr, State: t.State}
 }
 
 func (t SubprocessToDiscordTest) Run(_ *TestRunner, number int, rules *lib.Rules) bool {
-	result := lib.ApplyRules(rules.SubprocessToDiscord, nil, t.Input)
-	if result != t.Expect {
+	result, ruleId, ruleOk := applyRules(rules, lib.DirectionSubprocessToDiscord, nil, t.lineInfo(), t.Input, t.ExpectRule)
+	printDisabledMatches(rules, lib.DirectionSubprocessToDiscord, number, t.Input)
+	if result != t.Expect || !ruleOk {
 		fmt.Printf(
 			"❌  SubprocessToDiscordTest Test #%v: FAIL:\n"+
 				"\tInput:\t\t%v\n"+
 				"\tExpected:\t%v\n"+
-				"\tGot:\t\t%v\n",
-			number, t.Input, t.Expect, result,
+				"\tGot:\t\t%v\n"+
+				"\tRule:\t\t%v\n",
+			number, t.Input, t.Expect, result, formatRule(ruleId, t.ExpectRule),
 		)
 		return false
 	}
@@ -83,16 +107,57 @@ func (t SubprocessToDiscordTest) Run(_ *TestRunner, number int, rules *lib.Rules
 }
 
 func (t DiscordToSubprocessTest) Run(testRunner *TestRunner, number int, rules *lib.Rules) bool {
-	userProps, ok := testRunner.TestFile.UserProps[t.UserProps]
+	userProps, ok := testRunner.props(t.UserProps, t.Props)
 	if !ok {
 		printError("❌  Test #%v: bad test: missing UserProps \"%v\".\n", number, t.UserProps)
 		return false
 	}
 
-	result := lib.ApplyRules(rules.DiscordToSubprocess, &userProps, t.Input)
-	if result != t.Expect {
+	result, ruleId, ruleOk := applyRules(rules, lib.DirectionDiscordToSubprocess, userProps, lib.LineInfo{}, t.Input, t.ExpectRule)
+	printDisabledMatches(rules, lib.DirectionDiscordToSubprocess, number, t.Input)
+	if result != t.Expect || !ruleOk {
 		fmt.Printf(
 			"❌  d2s Test #%v: FAIL:\n"+
+				"\tInput:\t\t%v\n"+
+				"\tExpected:\t%v\n"+
+				"\tGot:\t\t%v\n"+
+				"\tRule:\t\t%v\n",
+			number, t.Input, t.Expect, result, formatRule(ruleId, t.ExpectRule),
+		)
+		return false
+	}
+	fmt.Printf("✅  Test #%v: PASS\n", number)
+	return true
+}
+
+// props returns the Props of a DiscordToSubprocess test: its inline Props, or
+// the UserProps of the given name.
+func (r *TestRunner) props(name string, inline *lib.Props) (*lib.Props, bool) {
+	if inline != nil {
+		return inline, true
+	}
+	userProps, ok := r.TestFile.UserProps[name]
+	return &userProps, ok
+}
+
+func (t TestCase) Run(testRunner *TestRunner, number int, rules *lib.Rules) bool {
+	if t.Direction == lib.DirectionDiscordToSubprocess {
+		test := DiscordToSubprocessTest{Input: t.Input, Expect: t.Expect, ExpectRule: t.ExpectRule, UserProps: t.UserProps, Props: t.Props}
+		return test.Run(testRunner, number, rules)
+	}
+	test := SubprocessToDiscordTest{Input: t.Input, Expect: t.Expect, ExpectRule: t.ExpectRule, Source: t.Source, State: t.State}
+	return test.Run(testRunner, number, rules)
+}
+
+func (t UserTagTest) Run(testRunner *TestRunner, number int, _ *lib.Rules) bool {
+	if testRunner.UserMap == nil {
+		printError("❌  Test #%v: bad test: user tag tests require a users file (--users).\n", number)
+		return false
+	}
+	result := lib.ApplyUserTags(testRunner.UserMap, t.Input)
+	if result != t.Expect {
+		fmt.Printf(
+			"❌  UserTagTest Test #%v: FAIL:\n"+
 				"\tInput:\t\t%v\n"+
 				"\tExpected:\t%v\n"+
 				"\tGot:\t\t%v\n",
@@ -104,7 +169,168 @@ func (t DiscordToSubprocessTest) Run(testRunner *TestRunner, number int, rules *
 	return true
 }
 
+// ExampleTest checks an example embedded in a rule, see lib.RuleExample.
+type ExampleTest struct {
+	Direction string
+	Index     int // Index of the rule in the rule list of Direction
+	Example   lib.RuleExample
+}
+
+// exampleTests returns a test for each example of the rules.
+func (r *TestRunner) exampleTests() []ExampleTest {
+	var tests []ExampleTest
+	for _, direction := range lib.RuleDirections {
+		for i, rule := range r.Rules.List(direction) {
+			for _, example := range rule.Examples {
+				tests = append(tests, ExampleTest{Direction: direction, Index: i, Example: example})
+			}
+		}
+	}
+	return tests
+}
+
+func (t ExampleTest) Run(_ *TestRunner, number int, rules *lib.Rules) bool {
+	failure := rules.CheckExample(t.Direction, t.Index, t.Example)
+	if failure != nil {
+		got := failure.Got
+		if failure.GotRule != "" {
+			got += fmt.Sprintf(" (rule %v)", failure.GotRule)
+		}
+		fmt.Printf(
+			"❌  ExampleTest Test #%v: FAIL: example of %v rule %v\n"+
+				"\tInput:\t\t%v\n"+
+				"\tExpected:\t%v\n"+
+				"\tGot:\t\t%v\n",
+			number, t.Direction, failure.RuleId, t.Example.In, t.Example.Out, got,
+		)
+		return false
+	}
+	fmt.Printf("✅  Test #%v: PASS\n", number)
+	return true
+}
+
+// LoopCheck checks that the result of a test input doesn't match the
+// SubprocessToDiscord rules. Such a rule set is prone to feedback loops: a
+// message relayed to Discord that the subprocess prints again (e.g. a bridge
+// sharing a channel with another one), or a message from Discord that the
+// subprocess echoes to its console, is relayed to Discord again.
+type LoopCheck struct {
+	Direction string
+	Input     string
+	Props     *lib.Props
+}
+
+// loopChecks returns a loop check for each SubprocessToDiscord,
+// DiscordToSubprocess and Cases test.
+func (r *TestRunner) loopChecks() []LoopCheck {
+	var checks []LoopCheck
+	for _, test := range r.TestFile.Tests.SubprocessToDiscord {
+		checks = append(checks, LoopCheck{Direction: lib.DirectionSubprocessToDiscord, Input: test.Input})
+	}
+	for _, test := range r.TestFile.Tests.DiscordToSubprocess {
+		// Missing UserProps are reported by the test itself.
+		if userProps, ok := r.props(test.UserProps, test.Props); ok {
+			checks = append(checks, LoopCheck{Direction: lib.DirectionDiscordToSubprocess, Input: test.Input, Props: userProps})
+		}
+	}
+	for _, test := range r.TestFile.Tests.Cases {
+		if test.Direction == lib.DirectionSubprocessToDiscord {
+			checks = append(checks, LoopCheck{Direction: test.Direction, Input: test.Input})
+		} else if userProps, ok := r.props(test.UserProps, test.Props); ok {
+			checks = append(checks, LoopCheck{Direction: test.Direction, Input: test.Input, Props: userProps})
+		}
+	}
+	return checks
+}
+
+func (t LoopCheck) Run(_ *TestRunner, number int, rules *lib.Rules) bool {
+	result, ruleId, _ := applyRules(rules, t.Direction, t.Props, lib.LineInfo{}, t.Input, "")
+	if result == "" {
+		fmt.Printf("✅  Test #%v: PASS\n", number)
+		return true
+	}
+	if match := rules.Match(lib.DirectionSubprocessToDiscord, nil, result); match != nil {
+		fmt.Printf(
+			"❌  LoopCheck Test #%v: FAIL: the result of a %v rule matches a SubprocessToDiscord rule\n"+
+				"\tInput:\t\t%v\n"+
+				"\tResult:\t\t%v (rule %v)\n"+
+				"\tMatched:\t%v (rule %v)\n",
+			number, t.Direction, t.Input, result, ruleId, match.Result, match.RuleId(),
+		)
+		return false
+	}
+	fmt.Printf("✅  Test #%v: PASS\n", number)
+	return true
+}
+
 func (r *TestResults) Add(other TestResults) {
 	r.Passed += other.Passed
 	r.Failed += other.Failed
 }
+
+// applyRules applies the rules of a direction like the bridge does.
+//
+// Parameters:
+//
+//	info: where a subprocess line came from, see lib.Rules.MatchPlayer
+//	expectRule: the rule expected to match, see ruleMatches
+//
+// Returns:
+//
+//	the result and the ID of the matched rule (see lib.RuleId), marked if it
+//	is a passthrough rule, or an empty string and "none" if no rule matched,
+//	and whether the expected rule matched
+func applyRules(rules *lib.Rules, direction string, props *lib.Props, info lib.LineInfo, input string, expectRule string) (string, string, bool) {
+	var match *lib.RuleMatch
+	if props != nil {
+		match = rules.Match(direction, props, input)
+	} else {
+		match = rules.MatchPlayer(direction, info, input, playerProps)
+	}
+	if match == nil {
+		return "", "none", ruleMatches(expectRule, nil)
+	}
+	ruleId := match.RuleId()
+	if match.Rule.Passthrough {
+		ruleId += " (passthrough)"
+	}
+	return match.Result, ruleId, ruleMatches(expectRule, match)
+}
+
+// playerProps returns the Props of a player for rules with a Player template.
+// Unlike the bridge, it doesn't look up Discord members, so only the Username
+// is set.
+func playerProps(player string) lib.Props {
+	return lib.Props{Author: lib.Author{Username: player}}
+}
+
+// ruleMatches checks whether the expected rule produced a match. The rule may
+// be given by name, or by index (e.g. "#2"); "none" expects no rule to match,
+// and an empty string expects any rule.
+func ruleMatches(expectRule string, match *lib.RuleMatch) bool {
+	switch {
+	case expectRule == "":
+		return true
+	case match == nil:
+		return expectRule == "none"
+	default:
+		return expectRule == match.Rule.Name || expectRule == fmt.Sprintf("#%v", match.Index)
+	}
+}
+
+// formatRule formats the matched rule of a failed test, with the expected rule
+// if the test has one.
+func formatRule(ruleId string, expectRule string) string {
+	if expectRule == "" {
+		return ruleId
+	}
+	return fmt.Sprintf("%v (expected %v)", ruleId, expectRule)
+}
+
+// printDisabledMatches reports disabled rules that would have matched a test
+// input, since the test result may change once they are enabled again.
+func printDisabledMatches(rules *lib.Rules, direction string, number int, input string) {
+	for _, ruleId := range rules.DisabledMatches(direction, input) {
+		fmt.Printf("⚠️  Test #%v: skipped disabled rule %v, which matches\n", number, ruleId)
+	}
+}
@@ -8,15 +8,43 @@ type (
 		UserProps map[string]lib.Props `validate:"dive"`
 	}
 	Tests struct {
-		DiscordToSubprocess []DiscordToSubprocessTest `validate:"required"`
-		SubprocessToDiscord []SubprocessToDiscordTest `validate:"required,dive"`
+		DiscordToSubprocess []DiscordToSubprocessTest `validate:"required_without=Cases"`
+		SubprocessToDiscord []SubprocessToDiscordTest `validate:"required_without=Cases,dive"`
+		UserTags            []UserTagTest             `validate:"dive"`
+		Cases               []TestCase                `validate:"dive"` // Tests of either direction
 	}
 	DiscordToSubprocessTest struct {
-		Input     string `validate:"required"`
-		Expect    string
-		UserProps string `validate:"required"`
+		Input      string `validate:"required"`
+		Expect     string
+		ExpectRule string     // Optional, see ruleMatches
+		UserProps  string     // Name of the FileRoot.UserProps of the message
+		Props      *lib.Props // Optional, the Props of the message instead of UserProps
 	}
 	SubprocessToDiscordTest struct {
+		Input      string `validate:"required"`
+		Expect     string
+		ExpectRule string // Optional, see ruleMatches
+		Source     string `validate:"omitempty,oneof=stdout stderr"`           // Optional, defaults to stdout
+		State      string `validate:"omitempty,oneof=starting ready stopping"` // Optional, see lib.Rule.State
+	}
+	// TestCase is a test of the direction it declares, so that tests of both
+	// directions can be listed together.
+	TestCase struct {
+		Direction  string `validate:"required,oneof=SubprocessToDiscord DiscordToSubprocess"`
+		Input      string `validate:"required"`
+		Expect     string
+		ExpectRule string // Optional, see ruleMatches
+		// DiscordToSubprocess: name of the FileRoot.UserProps of the message,
+		// or the Props themselves
+		UserProps string
+		Props     *lib.Props
+		// SubprocessToDiscord, optional: see SubprocessToDiscordTest
+		Source string `validate:"omitempty,oneof=stdout stderr"`
+		State  string `validate:"omitempty,oneof=starting ready stopping"`
+	}
+	// UserTagTest checks the mentions of a message sent to Discord, see
+	// lib.ApplyUserTags. It requires a users file.
+	UserTagTest struct {
 		Input  string `validate:"required"`
 		Expect string
 	}
@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"dgbridge/src/lib"
+	"log"
+	"sync/atomic"
+)
+
+// discordQueueSize is the amount of results that can be waiting to be sent
+// before new ones are dropped.
+const discordQueueSize = 256
+
+// DiscordSend sends a message to a Discord channel, with the bridge's session.
+type DiscordSend func(channelId string, content string) error
+
+// Discord sends the result of each event to a Discord channel other than the
+// relay channel, e.g. a staff channel. Results are sent once the bridge
+// attaches its session, see Router.AttachDiscord; earlier ones are dropped.
+type Discord struct {
+	channelId string
+	send      atomic.Pointer[DiscordSend]
+	queue     *queue[string]
+}
+
+// NewDiscord creates a Discord sink.
+func NewDiscord(config lib.SinkConfig) *Discord {
+	self := &Discord{channelId: config.ChannelId}
+	self.queue = newQueue(discordQueueSize, self.sendResult)
+	return self
+}
+
+// Attach sets the function that sends messages.
+func (self *Discord) Attach(send DiscordSend) {
+	self.send.Store(&send)
+}
+
+// Publish queues the result of an event to be sent.
+// This function is non-blocking; if the queue is full or the sink is closed
+// the result is dropped.
+func (self *Discord) Publish(event Event) {
+	if event.Output == "" {
+		return
+	}
+	if !self.queue.push(event.Output) {
+		log.Println("error sending Discord sink message: queue is full or closed, dropping it")
+	}
+}
+
+// Close sends the queued results.
+func (self *Discord) Close() {
+	self.queue.close("Discord")
+}
+
+// sendResult sends a queued result.
+func (self *Discord) sendResult(content string) {
+	send := self.send.Load()
+	if send == nil {
+		log.Println("error sending Discord sink message: the Discord session isn't ready, dropping it")
+		return
+	}
+	if err := (*send)(self.channelId, content); err != nil {
+		log.Printf("error sending Discord sink message to channel %v: %v", self.channelId, err)
+	}
+}
@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// File appends events to a file, as a JSON object per line.
+type File struct {
+	mutex sync.Mutex
+	file  *os.File // nil once closed
+}
+
+// NewFile opens the file of a sink for appending, creating it if it doesn't
+// exist.
+func NewFile(config lib.SinkConfig) (*File, error) {
+	file, err := os.OpenFile(config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sink file: %v", err)
+	}
+	return &File{file: file}, nil
+}
+
+// Publish appends an event to the file; errors are logged. Events published
+// after Close are dropped.
+func (self *File) Publish(event Event) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("error encoding file event: %v", err)
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.file == nil {
+		log.Println("error writing file event: the sink is closed, dropping event")
+		return
+	}
+	if _, err := self.file.Write(append(line, '\n')); err != nil {
+		log.Printf("error writing file event: %v", err)
+	}
+}
+
+// Close closes the file. Closing a closed sink does nothing.
+func (self *File) Close() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.file != nil {
+		_ = self.file.Close()
+		self.file = nil
+	}
+}
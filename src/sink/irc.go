@@ -0,0 +1,276 @@
+package sink
+
+import (
+	"bufio"
+	"crypto/tls"
+	"dgbridge/src/lib"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// ircQueueSize is the amount of results that can be waiting to be sent
+	// before new ones are dropped.
+	ircQueueSize = 256
+	// ircReconnectDelay is the time between attempts to reconnect.
+	ircReconnectDelay = 30 * time.Second
+	// ircMessageDelay is the time between messages, so that servers don't
+	// disconnect the bridge for flooding.
+	ircMessageDelay = 500 * time.Millisecond
+	// ircTextLimit is the maximum length of the text of a message in bytes,
+	// which leaves room for the prefix in the 512 bytes of an IRC line.
+	ircTextLimit = 400
+)
+
+// Irc sends the result of each event to an IRC channel, whose name is Topic.
+// It reconnects if the connection is lost.
+type Irc struct {
+	address    string
+	serverName string // Host name verified with ircs URLs, empty for irc URLs
+	password   string // Server password, from the URL's user info
+	nick       string
+	channel    string
+	queue      chan string
+	done       chan struct{}
+}
+
+// NewIrc creates an IRC sink, which connects in the background. The URL is
+// irc://host[:6667] or ircs://host[:6697] for TLS; the password of its user
+// info, if any, is sent as the server password.
+func NewIrc(config lib.SinkConfig) (*Irc, error) {
+	parsed, err := url.Parse(config.Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IRC URL: %v", err)
+	}
+	self := &Irc{
+		nick:    config.ClientId,
+		channel: config.Topic,
+		queue:   make(chan string, ircQueueSize),
+		done:    make(chan struct{}),
+	}
+	port := parsed.Port()
+	switch parsed.Scheme {
+	case "irc":
+		if port == "" {
+			port = "6667"
+		}
+	case "ircs":
+		if port == "" {
+			port = "6697"
+		}
+		self.serverName = parsed.Hostname()
+	default:
+		return nil, fmt.Errorf("invalid IRC URL %q, expected irc:// or ircs://", config.Url)
+	}
+	self.address = net.JoinHostPort(parsed.Hostname(), port)
+	if parsed.User != nil {
+		self.password, _ = parsed.User.Password()
+	}
+	if self.nick == "" {
+		self.nick = DefaultTopic
+	}
+	if !strings.HasPrefix(self.channel, "#") && !strings.HasPrefix(self.channel, "&") {
+		return nil, fmt.Errorf("the Topic of IRC sinks is the channel, e.g. #minecraft")
+	}
+	go self.run()
+	return self, nil
+}
+
+// Publish queues the result of an event to be sent.
+// This function is non-blocking; if the queue is full the result is dropped.
+func (self *Irc) Publish(event Event) {
+	if event.Output == "" {
+		return
+	}
+	select {
+	case self.queue <- event.Output:
+	default:
+		log.Println("error sending IRC message: queue is full, dropping it")
+	}
+}
+
+// Close leaves the server.
+func (self *Irc) Close() {
+	close(self.done)
+}
+
+// run keeps a connection to the server until the sink is closed.
+func (self *Irc) run() {
+	for {
+		err := self.session()
+		select {
+		case <-self.done:
+			return
+		default:
+		}
+		log.Printf("error in IRC connection to %v, reconnecting in %v: %v", self.address, ircReconnectDelay, err)
+		select {
+		case <-self.done:
+			return
+		case <-time.After(ircReconnectDelay):
+		}
+	}
+}
+
+// session connects to the server, joins the channel, and sends the queued
+// results until the connection is lost or the sink is closed.
+func (self *Irc) session() error {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if self.serverName != "" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", self.address, &tls.Config{ServerName: self.serverName})
+	} else {
+		conn, err = dialer.Dial("tcp", self.address)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	var writeMutex sync.Mutex
+	write := func(format string, args ...any) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		_ = conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		_, err := fmt.Fprintf(conn, format+"\r\n", args...)
+		return err
+	}
+
+	if self.password != "" {
+		_ = write("PASS %v", self.password)
+	}
+	_ = write("NICK %v", self.nick)
+	if err := write("USER %v 0 * :dgbridge", self.nick); err != nil {
+		return err
+	}
+	joined := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() { readErr <- self.readLines(conn, write, joined) }()
+	select {
+	case <-joined:
+	case err := <-readErr:
+		return err
+	case <-self.done:
+		_ = write("QUIT")
+		return nil
+	case <-time.After(time.Minute):
+		return fmt.Errorf("timed out joining %v", self.channel)
+	}
+
+	for {
+		select {
+		case text := <-self.queue:
+			for _, line := range ircLines(text) {
+				if err := write("PRIVMSG %v :%v", self.channel, line); err != nil {
+					return err
+				}
+				time.Sleep(ircMessageDelay)
+			}
+		case err := <-readErr:
+			return err
+		case <-self.done:
+			_ = write("QUIT")
+			return nil
+		}
+	}
+}
+
+// readLines handles the lines sent by the server until the connection is
+// lost: it answers pings, joins the channel once registered, and picks
+// another nick if the nick is taken.
+//
+// Parameters:
+//
+//	joined: closed once the channel is joined
+func (self *Irc) readLines(conn net.Conn, write func(format string, args ...any) error, joined chan struct{}) error {
+	scanner := bufio.NewScanner(conn)
+	nick := self.nick
+	isJoined := false
+	for {
+		// Servers ping idle clients every few minutes.
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Minute))
+		if !scanner.Scan() {
+			break
+		}
+		prefix, command, params := parseIrcLine(scanner.Text())
+		last := ""
+		if len(params) > 0 {
+			last = params[len(params)-1]
+		}
+		switch command {
+		case "PING":
+			_ = write("PONG :%v", last)
+		case "001":
+			// Registered
+			_ = write("JOIN %v", self.channel)
+		case "433":
+			// The nick is taken
+			nick += "_"
+			_ = write("NICK %v", nick)
+		case "JOIN":
+			if from, _, _ := strings.Cut(prefix, "!"); from == nick && !isJoined {
+				isJoined = true
+				close(joined)
+			}
+		case "403", "471", "473", "474", "475":
+			return fmt.Errorf("can't join %v: %v", self.channel, last)
+		case "ERROR":
+			return fmt.Errorf("server error: %v", last)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("connection closed by the server")
+}
+
+// parseIrcLine splits an IRC line into its prefix, command and parameters,
+// the last of which may contain spaces.
+func parseIrcLine(line string) (string, string, []string) {
+	prefix := ""
+	if strings.HasPrefix(line, ":") {
+		prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	line, trailing, hasTrailing := strings.Cut(line, " :")
+	if strings.HasPrefix(line, ":") {
+		// No middle parameters, e.g. "PING :server"
+		line, trailing, hasTrailing = "", line[1:], true
+	}
+	fields := strings.Fields(line)
+	if hasTrailing {
+		fields = append(fields, trailing)
+	}
+	if len(fields) == 0 {
+		return prefix, "", nil
+	}
+	return prefix, strings.ToUpper(fields[0]), fields[1:]
+}
+
+// ircLines splits text into the lines of IRC messages, splitting lines that
+// are longer than ircTextLimit. Both \r and \n end a line, since either would
+// end the IRC line and send the rest as a command.
+func ircLines(text string) []string {
+	var lines []string
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		for len(line) > ircTextLimit {
+			cut := ircTextLimit
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			lines = append(lines, line[:cut])
+			line = line[cut:]
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultTopic is the topic prefix, subject prefix or stream key used by sinks
+// without a configured topic.
+const DefaultTopic = "dgbridge"
+
+// Mqtt publishes events to an MQTT broker, on the topic <Topic>/<Direction>.
+type Mqtt struct {
+	client      mqtt.Client
+	topicPrefix string
+}
+
+// NewMqtt connects to an MQTT broker.
+// The client reconnects automatically if the connection is lost.
+func NewMqtt(config lib.SinkConfig) (*Mqtt, error) {
+	broker, username, password, err := splitCredentials(config.Url)
+	if err != nil {
+		return nil, err
+	}
+	clientId := config.ClientId
+	if clientId == "" {
+		clientId = DefaultTopic
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientId).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		// SetConnectRetry keeps trying in the background.
+		log.Printf("[info] MQTT broker %v not reachable yet, retrying in the background\n", broker)
+	} else if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("error connecting to MQTT broker: %v", err)
+	}
+	return &Mqtt{
+		client:      client,
+		topicPrefix: topicOrDefault(config.Topic),
+	}, nil
+}
+
+// Publish publishes an event to the topic of its direction.
+// This function is non-blocking; errors are logged.
+func (self *Mqtt) Publish(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("error encoding MQTT event: %v", err)
+		return
+	}
+	token := self.client.Publish(self.topicPrefix+"/"+event.Direction, 0, false, payload)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Printf("error publishing MQTT event: %v", token.Error())
+		}
+	}()
+}
+
+// Close disconnects from the broker.
+func (self *Mqtt) Close() {
+	self.client.Disconnect(250)
+}
+
+// splitCredentials removes the user info from a URL.
+//
+// Returns:
+//
+//	the URL without user info, and the username and password
+func splitCredentials(rawUrl string) (string, string, string, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid URL: %v", err)
+	}
+	if parsed.User == nil {
+		return rawUrl, "", "", nil
+	}
+	username := parsed.User.Username()
+	password, _ := parsed.User.Password()
+	parsed.User = nil
+	return parsed.String(), username, password, nil
+}
+
+// topicOrDefault returns topic, or DefaultTopic if topic is empty.
+func topicOrDefault(topic string) string {
+	if topic == "" {
+		return DefaultTopic
+	}
+	return topic
+}
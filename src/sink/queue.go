@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// queueFlushTimeout is the maximum time Close waits for the queued items to be
+// sent, so that an unreachable destination doesn't hold up shutdown.
+const queueFlushTimeout = 10 * time.Second
+
+// queue is a bounded queue of items sent by a single worker, for sinks that
+// must not block Publish.
+type queue[T any] struct {
+	mutex  sync.RWMutex
+	closed bool
+	items  chan T
+	done   chan struct{} // Closed once the worker sent all items
+}
+
+// newQueue creates a queue and starts its worker.
+//
+// Parameters:
+//
+//	size: the amount of items that can be waiting before new ones are dropped
+//	send: sends an item, called by the worker
+func newQueue[T any](size int, send func(T)) *queue[T] {
+	self := &queue[T]{
+		items: make(chan T, size),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(self.done)
+		for item := range self.items {
+			send(item)
+		}
+	}()
+	return self
+}
+
+// push queues an item without blocking.
+//
+// Returns:
+//
+//	false if the item was dropped because the queue is full or closed
+func (self *queue[T]) push(item T) bool {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if self.closed {
+		return false
+	}
+	select {
+	case self.items <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting items, and waits up to queueFlushTimeout for the
+// queued ones to be sent. Closing a closed queue does nothing.
+//
+// Parameters:
+//
+//	name: name of the sink, for the log
+func (self *queue[T]) close(name string) {
+	self.mutex.Lock()
+	if self.closed {
+		self.mutex.Unlock()
+		return
+	}
+	self.closed = true
+	close(self.items)
+	self.mutex.Unlock()
+	select {
+	case <-self.done:
+	case <-time.After(queueFlushTimeout):
+		log.Printf("error closing %v sink: the queued events weren't sent after %v, dropping them", name, queueFlushTimeout)
+	}
+}
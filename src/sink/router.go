@@ -0,0 +1,65 @@
+package sink
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+)
+
+// Router is a Sink that publishes each event to the sinks its route selects,
+// see lib.RouteTable.
+type Router struct {
+	sinks []Sink // By index in the configs, nil if the sink couldn't be created
+	table *lib.RouteTable
+}
+
+// NewRouter creates the sinks of all configs, and routes events to them.
+// Sinks that can't be created are skipped, and their errors are returned
+// together with the router.
+//
+// Parameters:
+//
+//	routes: the routing table, see lib.Rules.Routes
+func NewRouter(configs []lib.SinkConfig, routes []lib.SinkRoute) (*Router, []error) {
+	self := &Router{
+		sinks: make([]Sink, len(configs)),
+		table: lib.NewRouteTable(&lib.Rules{Sinks: configs, Routes: routes}),
+	}
+	var errs []error
+	for i, config := range configs {
+		sink, err := New(config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sink #%v (%v): %v", i, config.Type, err))
+			continue
+		}
+		self.sinks[i] = sink
+	}
+	return self, errs
+}
+
+// AttachDiscord makes the Discord session available to the discord sinks,
+// once it is ready.
+func (self *Router) AttachDiscord(send DiscordSend) {
+	for _, sink := range self.sinks {
+		if discord, ok := sink.(*Discord); ok {
+			discord.Attach(send)
+		}
+	}
+}
+
+// Publish publishes an event to the sinks of its direction and rule tags.
+func (self *Router) Publish(event Event) {
+	for _, index := range self.table.Sinks(event.Direction, event.Tags, event.Vars) {
+		if sink := self.sinks[index]; sink != nil {
+			sink.Publish(event)
+		}
+	}
+}
+
+// Close closes all sinks.
+func (self *Router) Close() {
+	for _, sink := range self.sinks {
+		if sink != nil {
+			sink.Close()
+		}
+	}
+}
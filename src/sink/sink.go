@@ -0,0 +1,70 @@
+package sink
+
+// This file declares the Sink interface, which is implemented by every
+// destination that matched messages can be published to besides Discord and
+// the subprocess, such as message brokers.
+
+import (
+	"dgbridge/src/lib"
+	"fmt"
+	"time"
+)
+
+// Event is published to sinks for every message matched by a rule.
+type Event struct {
+	Bridge    string            `json:"bridge,omitempty"`    // Name of the bridge, see --bridge_name
+	Direction string            `json:"direction"`           // lib.DirectionSubprocessToDiscord or lib.DirectionDiscordToSubprocess
+	Rule      int               `json:"rule"`                // Index of the matched rule
+	RuleName  string            `json:"rule_name,omitempty"` // Name of the matched rule, if it has one
+	Groups    []string          `json:"groups"`              // Capture groups, group 0 is the whole match
+	Tags      []string          `json:"tags,omitempty"`      // Tags of the matched rule, see lib.Rule.Tags
+	Vars      map[string]string `json:"vars,omitempty"`      // Variables exported by the matched rule, see lib.Rule.Exports
+	Input     string            `json:"input"`               // Message before applying the rule
+	Output    string            `json:"output"`              // Message after applying the rule
+	Time      time.Time         `json:"time"`                // When the message was matched
+}
+
+// NewEvent creates the Event of a bridge event that a rule matched.
+func NewEvent(event *lib.Event) Event {
+	return Event{
+		Direction: event.Direction,
+		Rule:      event.Match.Index,
+		RuleName:  event.Match.Rule.Name,
+		Groups:    event.Match.Groups,
+		Tags:      event.Match.Rule.Tags,
+		Vars:      event.Match.Vars,
+		Input:     event.Raw,
+		Output:    event.Transformed,
+		Time:      event.Matched,
+	}
+}
+
+// Sink is a destination for events.
+type Sink interface {
+	// Publish publishes an event. It must not block for long; errors are
+	// logged by the sink.
+	Publish(event Event)
+	// Close flushes pending events and closes the connection.
+	Close()
+}
+
+// New creates and connects a sink from its configuration in the rules file.
+func New(config lib.SinkConfig) (Sink, error) {
+	switch config.Type {
+	case lib.SinkTypeMqtt:
+		return NewMqtt(config)
+	case lib.SinkTypeNats:
+		return NewNats(config)
+	case lib.SinkTypeRedis:
+		return NewRedis(config)
+	case lib.SinkTypeFile:
+		return NewFile(config)
+	case lib.SinkTypeWebhook:
+		return NewWebhook(config)
+	case lib.SinkTypeDiscord:
+		return NewDiscord(config), nil
+	case lib.SinkTypeIrc:
+		return NewIrc(config)
+	}
+	return nil, fmt.Errorf("unknown sink type %q", config.Type)
+}
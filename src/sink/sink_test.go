@@ -0,0 +1,188 @@
+package sink
+
+import (
+	"bufio"
+	"dgbridge/src/lib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscordFlushesOnClose(t *testing.T) {
+	discord := NewDiscord(lib.SinkConfig{ChannelId: "42"})
+	var sent []string
+	discord.Attach(func(channelId string, content string) error {
+		assert.Equal(t, "42", channelId)
+		sent = append(sent, content)
+		return nil
+	})
+	discord.Publish(Event{Output: "first"})
+	discord.Publish(Event{Output: ""})
+	discord.Publish(Event{Output: "second"})
+	discord.Close()
+	assert.Equal(t, []string{"first", "second"}, sent)
+
+	// Neither panics once closed.
+	discord.Publish(Event{Output: "third"})
+	discord.Close()
+	assert.Equal(t, []string{"first", "second"}, sent)
+}
+
+func TestWebhookFlushesOnClose(t *testing.T) {
+	var mutex sync.Mutex
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &event))
+		mutex.Lock()
+		received = append(received, event)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	_, err := NewWebhook(lib.SinkConfig{Url: "ftp://example.com"})
+	assert.Error(t, err)
+	webhook, err := NewWebhook(lib.SinkConfig{Url: server.URL})
+	assert.NoError(t, err)
+	webhook.Publish(Event{Rule: 1, Output: "first"})
+	webhook.Publish(Event{Rule: 2, Output: "second"})
+	webhook.Close()
+	webhook.Publish(Event{Rule: 3, Output: "third"})
+	webhook.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Len(t, received, 2)
+	for i, expected := range []string{"first", "second"} {
+		assert.Equal(t, expected, received[i].Output, "Test #%v", i)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	file, err := NewFile(lib.SinkConfig{Path: path})
+	assert.NoError(t, err)
+	file.Publish(Event{Direction: lib.DirectionSubprocessToDiscord, Output: "first"})
+	file.Publish(Event{Direction: lib.DirectionDiscordToSubprocess, Output: "second"})
+	file.Close()
+	file.Publish(Event{Output: "third"})
+	file.Close()
+
+	assert.Equal(t, []string{"first", "second"}, readOutputs(t, path))
+}
+
+// readOutputs returns the outputs of the events appended to a file by a file
+// sink.
+func readOutputs(t *testing.T, path string) []string {
+	t.Helper()
+	contents, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer contents.Close()
+	var outputs []string
+	scanner := bufio.NewScanner(contents)
+	for scanner.Scan() {
+		var event Event
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		outputs = append(outputs, event.Output)
+	}
+	return outputs
+}
+
+func TestRouter(t *testing.T) {
+	dir := t.TempDir()
+	router, errs := NewRouter([]lib.SinkConfig{
+		{Type: lib.SinkTypeFile, Name: "chat", Path: filepath.Join(dir, "chat.jsonl")},
+		{Type: lib.SinkTypeWebhook, Name: "broken", Url: "ftp://example.com"},
+		{Type: lib.SinkTypeFile, Name: "deaths", Path: filepath.Join(dir, "deaths.jsonl")},
+	}, []lib.SinkRoute{
+		{Direction: lib.DirectionSubprocessToDiscord, Tag: "chat", Sinks: []string{"chat", "broken"}},
+		{Tag: "death", Sinks: []string{"deaths"}},
+	})
+	assert.Len(t, errs, 1)
+
+	router.Publish(Event{Direction: lib.DirectionSubprocessToDiscord, Tags: []string{"chat"}, Output: "hello"})
+	router.Publish(Event{Direction: lib.DirectionDiscordToSubprocess, Tags: []string{"chat"}, Output: "hi"})
+	router.Publish(Event{Direction: lib.DirectionSubprocessToDiscord, Tags: []string{"chat", "death"}, Output: "Steve fell"})
+	router.Publish(Event{Direction: lib.DirectionSubprocessToDiscord, Output: "untagged"})
+	router.Close()
+
+	assert.Equal(t, []string{"hello", "Steve fell"}, readOutputs(t, filepath.Join(dir, "chat.jsonl")))
+	assert.Equal(t, []string{"Steve fell"}, readOutputs(t, filepath.Join(dir, "deaths.jsonl")))
+}
+
+func TestParseIrcLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		prefix  string
+		command string
+		params  []string
+	}{
+		{"PING :irc.example.com", "", "PING", []string{"irc.example.com"}},
+		{":irc.example.com 001 dgbridge :Welcome to the network", "irc.example.com", "001", []string{"dgbridge", "Welcome to the network"}},
+		{":dgbridge!bridge@host JOIN #minecraft", "dgbridge!bridge@host", "JOIN", []string{"#minecraft"}},
+		{":irc.example.com 433 * dgbridge :Nickname is already in use", "irc.example.com", "433", []string{"*", "dgbridge", "Nickname is already in use"}},
+		{"error :Closing link", "", "ERROR", []string{"Closing link"}},
+		{":irc.example.com", "irc.example.com", "", nil},
+		{"", "", "", nil},
+	}
+	for i, test := range tests {
+		prefix, command, params := parseIrcLine(test.line)
+		assert.Equal(t, test.prefix, prefix, "Test #%v", i)
+		assert.Equal(t, test.command, command, "Test #%v", i)
+		assert.Equal(t, test.params, params, "Test #%v", i)
+	}
+}
+
+func TestIrcLines(t *testing.T) {
+	long := strings.Repeat("a", ircTextLimit)
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"hello", []string{"hello"}},
+		{"first\r\nsecond\n\n  \nthird", []string{"first", "second", "third"}},
+		// A lone carriage return ends the IRC line too.
+		{"hi\rQUIT :bye", []string{"hi", "QUIT :bye"}},
+		{long + "b", []string{long, "b"}},
+		// Lines aren't split inside a character.
+		{strings.Repeat("a", ircTextLimit-1) + "é", []string{strings.Repeat("a", ircTextLimit-1), "é"}},
+		{"\r\n", nil},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.expected, ircLines(test.text), "Test #%v", i)
+	}
+}
+
+func TestQueueDropsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	var sent []int
+	queue := newQueue(1, func(item int) {
+		<-release
+		sent = append(sent, item)
+	})
+	assert.True(t, queue.push(1))
+	// The worker holds at most one item while the queue holds another.
+	dropped := 0
+	for i := 2; i <= 4; i++ {
+		if !queue.push(i) {
+			dropped++
+		}
+	}
+	assert.GreaterOrEqual(t, dropped, 1)
+	close(release)
+	queue.close("test")
+	assert.Equal(t, 1, sent[0])
+	assert.Equal(t, 4-dropped, len(sent))
+	assert.False(t, queue.push(5))
+}
@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"bytes"
+	"dgbridge/src/lib"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookQueueSize is the amount of events that can be waiting to be posted
+// before new events are dropped.
+const webhookQueueSize = 256
+
+// Webhook POSTs each event to a URL, as JSON.
+type Webhook struct {
+	url    string
+	client *http.Client
+	queue  *queue[Event]
+}
+
+// NewWebhook creates a webhook sink. Nothing is sent until the first event.
+func NewWebhook(config lib.SinkConfig) (*Webhook, error) {
+	parsed, err := url.Parse(config.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("invalid webhook URL %q, expected an http or https URL", config.Url)
+	}
+	self := &Webhook{
+		url:    config.Url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	self.queue = newQueue(webhookQueueSize, self.postEvent)
+	return self, nil
+}
+
+// Publish queues an event to be posted.
+// This function is non-blocking; if the queue is full or the sink is closed
+// the event is dropped.
+func (self *Webhook) Publish(event Event) {
+	if !self.queue.push(event) {
+		log.Println("error posting webhook event: queue is full or closed, dropping event")
+	}
+}
+
+// Close posts the queued events.
+func (self *Webhook) Close() {
+	self.queue.close("webhook")
+}
+
+// postEvent posts a queued event.
+func (self *Webhook) postEvent(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("error encoding webhook event: %v", err)
+		return
+	}
+	response, err := self.client.Post(self.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("error posting webhook event: %v", err)
+		return
+	}
+	_ = response.Body.Close()
+	if response.StatusCode >= 300 {
+		log.Printf("error posting webhook event: %v", response.Status)
+	}
+}
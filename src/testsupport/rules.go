@@ -0,0 +1,149 @@
+package testsupport
+
+// This file implements helpers for testing rule sets: parsing and validating
+// them like dgbridge does, and relaying the lines of a FakeSubprocess and
+// Discord messages through their rules.
+
+import (
+	"dgbridge/src/lib"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ParseRules parses and validates a rules file's contents, like
+// dgbridge check, failing the test if they are invalid.
+func ParseRules(t testing.TB, contents string) *lib.Rules {
+	t.Helper()
+	rules, err := lib.ParseRules([]byte(contents))
+	if err == nil {
+		err = rules.Validate()
+	}
+	if err != nil {
+		t.Fatalf("invalid rules: %v", err)
+	}
+	return rules
+}
+
+// LoadRules is ParseRules for a rules file, e.g. one shipped with an
+// integration.
+func LoadRules(t testing.TB, path string) *lib.Rules {
+	t.Helper()
+	rules, err := lib.LoadRules(path)
+	if err == nil {
+		err = rules.Validate()
+	}
+	if err != nil {
+		t.Fatalf("invalid rules %v: %v", path, err)
+	}
+	return rules
+}
+
+// DiscordUser returns the Props of a message by a Discord user, for
+// Bridge.SendDiscord.
+//
+// Parameters:
+//
+//	roles: names of the user's roles
+func DiscordUser(id string, username string, roles ...string) *lib.Props {
+	props := &lib.Props{
+		Author:      lib.Author{Id: id, Username: username},
+		MessageType: lib.MessageTypeMessage,
+	}
+	for _, role := range roles {
+		props.Author.Roles = append(props.Author.Roles, lib.Role{Name: role})
+	}
+	return props
+}
+
+// Bridge relays between a FakeSubprocess and a fake Discord channel through a
+// rule set, the way dgbridge matches the rules: the results of the lines the
+// subprocess prints are recorded as Discord messages, and the results of
+// Discord messages are written to the subprocess' stdin. It is safe for
+// concurrent use.
+type Bridge struct {
+	Rules      *lib.Rules
+	Subprocess *FakeSubprocess
+
+	toDiscord    *lib.Pipeline
+	toSubprocess *lib.Pipeline
+	mutex        sync.Mutex
+	discord      []string
+}
+
+// NewBridge creates a Bridge.
+//
+// Parameters:
+//
+//	stages: stages run after a rule matched, in both directions, e.g. to
+//		test an integration; they may drop events
+func NewBridge(rules *lib.Rules, subprocess *FakeSubprocess, stages ...lib.Stage) *Bridge {
+	self := &Bridge{Rules: rules, Subprocess: subprocess}
+	load := func() *lib.Rules { return self.Rules }
+	matched := lib.NewPipeline(lib.MatchStage(load, nil), lib.RequireMatch()).Then(stages...)
+	self.toDiscord = matched.Then(lib.Tap("discord", func(event *lib.Event) {
+		self.mutex.Lock()
+		defer self.mutex.Unlock()
+		self.discord = append(self.discord, event.Transformed)
+	}))
+	self.toSubprocess = matched.Then(lib.Tap("subprocess", func(event *lib.Event) {
+		subprocess.WriteLine(event.Transformed)
+	}))
+	return self
+}
+
+// Run starts the subprocess and relays its script, returning once the script
+// was relayed.
+//
+// Returns:
+//
+//	the results relayed to Discord, in order
+func (self *Bridge) Run() ([]string, error) {
+	lineCh := self.Subprocess.OutputLineEvent.Listen()
+	defer self.Subprocess.OutputLineEvent.Off(lineCh)
+	if err := self.Subprocess.Start(); err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case line := <-lineCh:
+			self.relayLine(line)
+		case <-self.Subprocess.ScriptDone():
+			// The last line was received, and relayed, before the script was
+			// done.
+			return self.DiscordMessages(), nil
+		}
+	}
+}
+
+// relayLine relays a line of the subprocess to Discord.
+func (self *Bridge) relayLine(line Line) {
+	event := lib.NewEvent(lib.EventSourceSubprocess, lib.DirectionSubprocessToDiscord, line.Text, time.Now())
+	event.Line = lib.LineInfo{Instance: self.Subprocess.Name, Stderr: line.Stderr}
+	self.toDiscord.Run(event)
+}
+
+// SendDiscord relays a Discord message to the subprocess.
+//
+// Parameters:
+//
+//	props: the message's author and type, see DiscordUser
+//
+// Returns:
+//
+//	the line written to the subprocess' stdin, and false if no rule matched
+func (self *Bridge) SendDiscord(props *lib.Props, text string) (string, bool) {
+	event := lib.NewEvent(lib.EventSourceDiscord, lib.DirectionDiscordToSubprocess, text, time.Now())
+	event.Props = props
+	if _, ok := self.toSubprocess.Run(event); !ok {
+		return "", false
+	}
+	return event.Transformed, true
+}
+
+// DiscordMessages returns the results relayed to Discord so far, in order.
+func (self *Bridge) DiscordMessages() []string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return append([]string(nil), self.discord...)
+}
@@ -0,0 +1,56 @@
+package testsupport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRules = `{
+	"SubprocessToDiscord": [
+		{"Match": "<(\\w+)> (.*)", "Template": "**${1}**: ${2}"},
+		{"Match": "^ERROR (.*)", "Template": ":warning: ${1}", "Source": "stderr"}
+	],
+	"DiscordToSubprocess": [
+		{"Match": "^!kick (\\w+)$", "Template": "kick ${1}", "Author": {"Roles": ["Moderator"]}},
+		{"Match": "(.*)", "Template": "say ^U: ${1}"}
+	]
+}`
+
+func TestBridge(t *testing.T) {
+	subprocess := NewFakeSubprocess(
+		Stdout("<Steve> hello"),
+		Stdout("Saving the world"),
+		Stderr("ERROR out of memory"),
+		Stdout("ERROR not on stderr"),
+	)
+	bridge := NewBridge(ParseRules(t, testRules), subprocess)
+	messages, err := bridge.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"**Steve**: hello", ":warning: out of memory"}, messages)
+	_, err = bridge.Run()
+	assert.Error(t, err, "the subprocess runs once")
+
+	line, ok := bridge.SendDiscord(DiscordUser("1", "alex", "Moderator"), "!kick Steve")
+	assert.True(t, ok)
+	assert.Equal(t, "kick Steve", line)
+	line, ok = bridge.SendDiscord(DiscordUser("2", "sam"), "!kick Steve")
+	assert.True(t, ok)
+	assert.Equal(t, "say sam: !kick Steve", line, "only moderators may kick")
+	assert.Equal(t, []string{"kick Steve", "say sam: !kick Steve"}, subprocess.Stdin())
+}
+
+func TestFakeSubprocess(t *testing.T) {
+	subprocess := NewFakeSubprocess(Stdout("a"), Stderr("b"))
+	subprocess.Name = "lobby"
+	stderrCh := subprocess.StderrLineEvent.Listen()
+	exitCh := subprocess.ExitEvent.Listen()
+	assert.NoError(t, subprocess.Start())
+	assert.Equal(t, "b", <-stderrCh)
+	<-subprocess.ScriptDone()
+	assert.Equal(t, "lobby", subprocess.InstanceName())
+
+	go subprocess.Exit(3)
+	assert.Equal(t, 3, <-exitCh)
+	subprocess.Stop() // Exited already, so nothing is emitted
+}
@@ -0,0 +1,122 @@
+// Package testsupport provides test doubles and helpers for code that embeds
+// dgbridge's packages, e.g. to unit test a rule set or an integration without
+// spawning a subprocess or connecting to Discord.
+package testsupport
+
+import (
+	"dgbridge/src/ext"
+	"fmt"
+	"sync"
+)
+
+// Line is a line the fake subprocess prints.
+type Line struct {
+	Text   string
+	Stderr bool // Whether the line is printed to stderr
+}
+
+// Stdout returns a line printed to stdout.
+func Stdout(text string) Line {
+	return Line{Text: text}
+}
+
+// Stderr returns a line printed to stderr.
+func Stderr(text string) Line {
+	return Line{Text: text, Stderr: true}
+}
+
+// FakeSubprocess is an in-memory stand-in for dgbridge's SubprocessContext: it
+// prints a script of lines once started, and captures the lines written to its
+// stdin instead of running a program. Its events are emitted like those of
+// SubprocessContext, so listeners must listen before it is started and keep
+// reading. It is safe for concurrent use.
+type FakeSubprocess struct {
+	Name            string                   // Name of the instance, see InstanceName
+	StdoutLineEvent ext.EventChannel[string] // Emits the stdout lines
+	StderrLineEvent ext.EventChannel[string] // Emits the stderr lines
+	OutputLineEvent ext.EventChannel[Line]   // Emits stdout and stderr lines, in order
+	ExitEvent       ext.EventChannel[int]    // Emits the exit code once the subprocess exited
+
+	mutex      sync.Mutex
+	script     []Line
+	stdin      []string
+	started    bool
+	exited     bool
+	scriptDone chan struct{} // Closed once the script was printed
+}
+
+// NewFakeSubprocess creates a FakeSubprocess that prints a script once
+// started.
+func NewFakeSubprocess(script ...Line) *FakeSubprocess {
+	return &FakeSubprocess{script: script, scriptDone: make(chan struct{})}
+}
+
+// InstanceName returns the name of the instance.
+func (self *FakeSubprocess) InstanceName() string {
+	return self.Name
+}
+
+// Start starts printing the script, returning before it was printed; see
+// ScriptDone.
+func (self *FakeSubprocess) Start() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.started {
+		return fmt.Errorf("the subprocess was started already")
+	}
+	self.started = true
+	go func() {
+		self.Print(self.script...)
+		close(self.scriptDone)
+	}()
+	return nil
+}
+
+// ScriptDone is closed once the script was printed, i.e. every listener
+// received its last line.
+func (self *FakeSubprocess) ScriptDone() <-chan struct{} {
+	return self.scriptDone
+}
+
+// Print prints lines, in addition to the script, returning once every
+// listener received them.
+func (self *FakeSubprocess) Print(lines ...Line) {
+	for _, line := range lines {
+		if line.Stderr {
+			self.StderrLineEvent.Broadcast(line.Text)
+		} else {
+			self.StdoutLineEvent.Broadcast(line.Text)
+		}
+		self.OutputLineEvent.Broadcast(line)
+	}
+}
+
+// WriteLine captures a line written to stdin, see Stdin.
+func (self *FakeSubprocess) WriteLine(text string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.stdin = append(self.stdin, text)
+}
+
+// Stdin returns the lines written to stdin, in order.
+func (self *FakeSubprocess) Stdin() []string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return append([]string(nil), self.stdin...)
+}
+
+// Stop stops the subprocess, which exits with code 0.
+func (self *FakeSubprocess) Stop() {
+	self.Exit(0)
+}
+
+// Exit makes the subprocess exit with a code. Later calls do nothing.
+func (self *FakeSubprocess) Exit(code int) {
+	self.mutex.Lock()
+	exited := self.exited
+	self.exited = true
+	self.mutex.Unlock()
+	if !exited {
+		self.ExitEvent.Broadcast(code)
+	}
+}